@@ -42,6 +42,18 @@ func (m *QueuedMessage) Dispatched() {
 	m.metrics.ObserveDispatchMessageLatency(time.Since(m.time))
 }
 
+// frozen returns a copy of the QueuedMessage that carries the FrozenMessage
+// of the original message.
+func (m *QueuedMessage) frozen() *QueuedMessage {
+	message := *m.message
+	message.Message = message.FrozenMessage
+	return &QueuedMessage{
+		time:    m.time,
+		metrics: m.metrics,
+		message: &message,
+	}
+}
+
 // A Message is a message that will be broadcast to Subscribers.
 type Message struct {
 	Contest    string `json:"contest,omitempty"`
@@ -50,6 +62,12 @@ type Message struct {
 	User       string `json:"user,omitempty"`
 	Public     bool   `json:"public"`
 	Message    string `json:"message"`
+
+	// FrozenMessage, if set, is sent instead of Message to the subscribers
+	// that are neither administrators of the contest, problemset or problem of
+	// the message nor its user. This is used while the scoreboard of a contest
+	// is frozen.
+	FrozenMessage string `json:"frozen_message,omitempty"`
 }
 
 // A ValidateFilterResponse holds the results of a Validate request.
@@ -134,12 +152,20 @@ func (b *Broadcaster) Run() {
 
 		case m := <-b.messages:
 			b.metrics.IncrementMessagesCount()
+			var frozen *QueuedMessage
 			for s := range b.subscribers {
 				if !s.Matches(m.message) {
 					continue
 				}
+				queued := m
+				if m.message.FrozenMessage != "" && !s.SeesUnfrozen(m.message) {
+					if frozen == nil {
+						frozen = m.frozen()
+					}
+					queued = frozen
+				}
 				select {
-				case s.Send() <- m:
+				case s.Send() <- queued:
 					break
 
 				default:
@@ -277,7 +303,7 @@ func NewSubscriber(
 	q.Set("filter", filterString)
 	requestURL.RawQuery = q.Encode()
 
-	request, err := http.NewRequest("GET", requestURL.String(), nil)
+	request, err := http.NewRequestWithContext(ctx.Context, "GET", requestURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -360,6 +386,22 @@ func (s *Subscriber) Matches(msg *Message) bool {
 	return false
 }
 
+// Administers returns whether the subscriber is an administrator of the
+// contest, problemset or problem of the provided message.
+func (s *Subscriber) Administers(msg *Message) bool {
+	return s.admin ||
+		(msg.Contest != "" && stringMapContains(s.contestAdminMap, msg.Contest)) ||
+		(msg.Problemset != 0 && intMapContains(s.problemsetAdminMap, msg.Problemset)) ||
+		(msg.Problem != "" && stringMapContains(s.problemAdminMap, msg.Problem))
+}
+
+// SeesUnfrozen returns whether the subscriber gets the full Message even while
+// the scoreboard is frozen: the administrators of the message, and the user
+// that the message is about, who can always see their own results.
+func (s *Subscriber) SeesUnfrozen(msg *Message) bool {
+	return s.Administers(msg) || (s.user != "" && msg.User == s.user)
+}
+
 // Run loops waiting for one of three events to happen: connection closure, a
 // new message is ready to be delivered to this subscriber, and periodic ping
 // ticks.
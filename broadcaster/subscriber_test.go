@@ -0,0 +1,57 @@
+package broadcaster
+
+import (
+	"testing"
+)
+
+func TestSeesUnfrozen(t *testing.T) {
+	msg := &Message{
+		Contest:       "contest",
+		User:          "owner",
+		Message:       "full",
+		FrozenMessage: "frozen",
+	}
+	for _, tc := range []struct {
+		name       string
+		subscriber *Subscriber
+		expected   bool
+	}{
+		{
+			"anonymous",
+			&Subscriber{},
+			false,
+		},
+		{
+			"other contestant",
+			&Subscriber{user: "other"},
+			false,
+		},
+		{
+			"owner",
+			&Subscriber{user: "owner"},
+			true,
+		},
+		{
+			"contest admin",
+			&Subscriber{
+				user:            "admin",
+				contestAdminMap: map[string]struct{}{"contest": {}},
+			},
+			true,
+		},
+		{
+			"system admin",
+			&Subscriber{user: "root", admin: true},
+			true,
+		},
+	} {
+		if got := tc.subscriber.SeesUnfrozen(msg); got != tc.expected {
+			t.Errorf("%s: SeesUnfrozen() == %v, want %v", tc.name, got, tc.expected)
+		}
+	}
+
+	// A message without a user is not shown unfrozen to anonymous subscribers.
+	if (&Subscriber{}).SeesUnfrozen(&Message{Contest: "contest"}) {
+		t.Errorf("SeesUnfrozen() of a message without a user == true, want false")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+	"github.com/omegaup/quark/runner"
+)
+
+// appealsFilename is the artifact of a run where the results of its appeals
+// are stored, next to its details.json.
+const appealsFilename = "appeals.json"
+
+// errNoLimitsOverride is returned when an appeal would not change any limit.
+var errNoLimitsOverride = errors.New("the appeal does not override any limit")
+
+// appealRequest asks for a submission to be re-run with different limits
+// (e.g. with 50% more time), to find out whether a verdict was decided by a
+// few milliseconds. The results of the re-run are never official.
+type appealRequest struct {
+	GUID string `json:"guid"`
+	common.LimitsOverride
+}
+
+// appealCase compares the results of a case in the original run and in the
+// re-run.
+type appealCase struct {
+	Group           string         `json:"group"`
+	Name            string         `json:"name"`
+	OriginalVerdict common.Verdict `json:"original_verdict"`
+	OriginalTime    float64        `json:"original_time"`
+	Verdict         common.Verdict `json:"verdict"`
+	Time            float64        `json:"time"`
+}
+
+// appealResult is the outcome of an appealRequest.
+type appealResult struct {
+	Time            time.Time             `json:"time"`
+	Override        common.LimitsOverride `json:"override"`
+	OriginalVerdict common.Verdict        `json:"original_verdict"`
+	OriginalScore   float64               `json:"original_score"`
+	Verdict         common.Verdict        `json:"verdict"`
+	Score           float64               `json:"score"`
+	Cases           []appealCase          `json:"cases"`
+	Result          *runner.RunResult     `json:"result"`
+}
+
+// appealsLock serializes the updates to the appeals of the runs.
+var appealsLock sync.Mutex
+
+// currentRunID returns the ID of the current run of the submission.
+func currentRunID(db *sql.DB, guid string) (int64, error) {
+	var runID sql.NullInt64
+	if err := queryRowWithRetry(
+		db,
+		`SELECT current_run_id FROM Submissions WHERE guid = ?;`,
+		guid,
+	).Scan(&runID); err != nil {
+		return 0, err
+	}
+	if !runID.Valid {
+		return 0, sql.ErrNoRows
+	}
+	return runID.Int64, nil
+}
+
+// appealRun re-runs the current run of the submission with the overridden
+// limits and stores the comparison with the original results next to them.
+// Neither the database nor the original results are modified, and nothing
+// is broadcast.
+func appealRun(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	runs *grader.Queue,
+	request *appealRequest,
+) (*appealResult, error) {
+	if request.LimitsOverride == (common.LimitsOverride{}) {
+		return nil, errNoLimitsOverride
+	}
+	runID, err := currentRunID(db, request.GUID)
+	if err != nil {
+		return nil, err
+	}
+	runInfo, err := newRunInfoFromID(ctx, db, runID, artifacts)
+	if err != nil {
+		return nil, err
+	}
+	runArtifacts := runInfo.Artifacts
+	var original runner.RunResult
+	if err := readArtifactJSON(ctx, runArtifacts, "details.json", &original); err != nil {
+		return nil, err
+	}
+	source, err := artifacts.Submissions.GetSource(&ctx.Context, runInfo.GUID)
+	if err != nil {
+		return nil, err
+	}
+	scratch, err := artifacts.Scratch()
+	if err != nil {
+		return nil, err
+	}
+	defer scratch.Clean()
+
+	// The run must not be mistaken for the original one, so that its results
+	// are never written to the database nor broadcast.
+	override := request.LimitsOverride
+	runInfo.ID = 0
+	runInfo.SubmissionID = 0
+	runInfo.GUID = ""
+	runInfo.Run.GUID = ""
+	runInfo.Run.Source = source
+	runInfo.Run.LimitsOverride = &override
+	runInfo.Artifacts = scratch
+
+	inputRef, err := ctx.InputManager.Add(
+		runInfo.Run.InputHash,
+		grader.NewInputFactory(runInfo.Run.ProblemName, &ctx.Config),
+	)
+	if err != nil {
+		return nil, err
+	}
+	runWaitHandle, err := runs.AddWaitableRun(&ctx.Context, runInfo, inputRef)
+	if err != nil {
+		return nil, err
+	}
+	<-runWaitHandle.Ready()
+
+	result := newAppealResult(&original, &runInfo.Result, override)
+
+	appealsLock.Lock()
+	defer appealsLock.Unlock()
+	var appeals []*appealResult
+	if err := readArtifactJSON(ctx, runArtifacts, appealsFilename, &appeals); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	appeals = append(appeals, result)
+	contents, err := json.Marshal(appeals)
+	if err != nil {
+		return nil, err
+	}
+	if err := runArtifacts.Put(&ctx.Context, appealsFilename, bytes.NewReader(contents)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// runAppeals returns the results of all the appeals of the current run of
+// the submission.
+func runAppeals(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	guid string,
+) ([]*appealResult, error) {
+	runID, err := currentRunID(db, guid)
+	if err != nil {
+		return nil, err
+	}
+	appeals := []*appealResult{}
+	err = readArtifactJSON(ctx, artifacts.Grader(&ctx.Context, runID), appealsFilename, &appeals)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return appeals, nil
+}
+
+func readArtifactJSON(ctx *grader.Context, artifacts grader.Artifacts, filename string, v any) error {
+	f, err := artifacts.Get(&ctx.Context, filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+// newAppealResult compares the results of the original run and the re-run,
+// case by case.
+func newAppealResult(
+	original *runner.RunResult,
+	rerun *runner.RunResult,
+	override common.LimitsOverride,
+) *appealResult {
+	result := &appealResult{
+		Time:            time.Now(),
+		Override:        override,
+		OriginalVerdict: original.Verdict,
+		OriginalScore:   base.RationalToFloat(original.Score),
+		Verdict:         rerun.Verdict,
+		Score:           base.RationalToFloat(rerun.Score),
+		Cases:           []appealCase{},
+		Result:          rerun,
+	}
+
+	type caseKey struct {
+		group, name string
+	}
+	originalCases := make(map[caseKey]*runner.CaseResult)
+	for i := range original.Groups {
+		group := &original.Groups[i]
+		for j := range group.Cases {
+			originalCases[caseKey{group.Group, group.Cases[j].Name}] = &group.Cases[j]
+		}
+	}
+	for _, group := range rerun.Groups {
+		for _, caseResult := range group.Cases {
+			comparison := appealCase{
+				Group:   group.Group,
+				Name:    caseResult.Name,
+				Verdict: caseResult.Verdict,
+				Time:    caseResult.Meta.Time,
+			}
+			if originalCase, ok := originalCases[caseKey{group.Group, caseResult.Name}]; ok {
+				comparison.OriginalVerdict = originalCase.Verdict
+				comparison.OriginalTime = originalCase.Meta.Time
+			}
+			result.Cases = append(result.Cases, comparison)
+		}
+	}
+	return result
+}
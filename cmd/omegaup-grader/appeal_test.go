@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/runner"
+)
+
+func TestCurrentRunID(t *testing.T) {
+	db := newInMemoryDB(t, "partial")
+
+	runID, err := currentRunID(db, "1")
+	if err != nil {
+		t.Fatalf("currentRunID failed: %v", err)
+	}
+	if runID != 1 {
+		t.Errorf("currentRunID(\"1\") == %d, want 1", runID)
+	}
+
+	if _, err := currentRunID(db, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("currentRunID(\"missing\") == %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestNewAppealResult(t *testing.T) {
+	original := runner.NewRunResult("TLE", big.NewRat(1, 1))
+	original.Score = big.NewRat(1, 2)
+	original.Groups = []runner.GroupResult{
+		{
+			Group: "easy",
+			Cases: []runner.CaseResult{
+				{Name: "easy", Verdict: "AC", Meta: runner.RunMetadata{Time: 0.2}},
+			},
+		},
+		{
+			Group: "hard",
+			Cases: []runner.CaseResult{
+				{Name: "hard", Verdict: "TLE", Meta: runner.RunMetadata{Time: 1.003}},
+			},
+		},
+	}
+	rerun := runner.NewRunResult("AC", big.NewRat(1, 1))
+	rerun.Score = big.NewRat(1, 1)
+	rerun.Groups = []runner.GroupResult{
+		{
+			Group: "easy",
+			Cases: []runner.CaseResult{
+				{Name: "easy", Verdict: "AC", Meta: runner.RunMetadata{Time: 0.21}},
+			},
+		},
+		{
+			Group: "hard",
+			Cases: []runner.CaseResult{
+				{Name: "hard", Verdict: "AC", Meta: runner.RunMetadata{Time: 1.004}},
+			},
+		},
+	}
+	override := common.LimitsOverride{TimeLimitFactor: 1.5}
+
+	result := newAppealResult(original, rerun, override)
+	if result.OriginalVerdict != "TLE" || result.OriginalScore != 0.5 ||
+		result.Verdict != "AC" || result.Score != 1 ||
+		result.Override != override || result.Result != rerun {
+		t.Errorf("newAppealResult() == %+v, want the verdicts and scores of both runs", result)
+	}
+	expectedCases := []appealCase{
+		{Group: "easy", Name: "easy", OriginalVerdict: "AC", OriginalTime: 0.2, Verdict: "AC", Time: 0.21},
+		{Group: "hard", Name: "hard", OriginalVerdict: "TLE", OriginalTime: 1.003, Verdict: "AC", Time: 1.004},
+	}
+	if !reflect.DeepEqual(expectedCases, result.Cases) {
+		t.Errorf("newAppealResult().Cases == %+v, want %+v", result.Cases, expectedCases)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/grader"
+)
+
+// auditEntry is one action that was requested through the admin API.
+type auditEntry struct {
+	Time    time.Time      `json:"time"`
+	Actor   string         `json:"actor"`
+	Action  string         `json:"action"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// auditLog records who requested each admin action, and when. The entries
+// are appended to a file, one JSON object per line, and are never modified.
+type auditLog struct {
+	sync.Mutex
+	path string
+}
+
+var audit = &auditLog{}
+
+// setup makes the auditLog append the entries to the file in path. Until it
+// is called, Record does nothing.
+func (a *auditLog) setup(path string) {
+	a.Lock()
+	defer a.Unlock()
+	a.path = path
+}
+
+// auditActor returns the identity of whoever sent the request: the common
+// name of their client certificate, or their address if TLS is not being
+// used. Unlike peerName, this ignores any names that the client claims to
+// have in the headers.
+func auditActor(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return r.RemoteAddr
+}
+
+// Record appends an entry for the action that the request asked for.
+func (a *auditLog) Record(
+	ctx *grader.Context,
+	r *http.Request,
+	action string,
+	details map[string]any,
+) {
+	entry := auditEntry{
+		Time:    time.Now(),
+		Actor:   auditActor(r),
+		Action:  action,
+		Details: details,
+	}
+	if err := a.append(&entry); err != nil {
+		ctx.Log.Error(
+			"Failed to record admin action in the audit log",
+			map[string]any{
+				"entry": entry,
+				"err":   err,
+			},
+		)
+	}
+}
+
+func (a *auditLog) append(entry *auditEntry) error {
+	marshaled, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	marshaled = append(marshaled, '\n')
+
+	a.Lock()
+	defer a.Unlock()
+	if a.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(marshaled)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Query returns the most recent entries (at most limit of them) that
+// happened at or after since. If action is not empty, only the entries for
+// that action are returned.
+func (a *auditLog) Query(since time.Time, action string, limit int) ([]auditEntry, error) {
+	a.Lock()
+	defer a.Unlock()
+	entries := []auditEntry{}
+	if a.path == "" {
+		return entries, nil
+	}
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Time.Before(since) || (action != "" && entry.Action != action) {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, scanner.Err()
+}
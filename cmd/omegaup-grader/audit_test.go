@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := newGraderContext(t)
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(path.Dir(ctx.Config.Grader.RuntimePath))
+	}
+	if err := os.MkdirAll(ctx.Config.Grader.RuntimePath, 0755); err != nil {
+		t.Fatalf("Failed to create the runtime path: %v", err)
+	}
+
+	log := &auditLog{}
+	log.setup(path.Join(ctx.Config.Grader.RuntimePath, "audit.log"))
+
+	start := time.Now()
+	r := httptest.NewRequest("POST", "/run/grade/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	// Names in the headers are not trusted.
+	r.Header.Set("OmegaUp-Runner-Name", "impostor")
+	log.Record(ctx, r, "rejudge", map[string]any{"run_ids": []int64{1, 2}})
+
+	r = httptest.NewRequest("POST", "/reload-config/", nil)
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{
+			{Subject: pkix.Name{CommonName: "frontend"}},
+		},
+	}
+	log.Record(ctx, r, "reload_config", nil)
+
+	entries, err := log.Query(time.Time{}, "", 0)
+	if err != nil {
+		t.Fatalf("Failed to query the audit log: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) == %d, want 2", len(entries))
+	}
+	if entries[0].Actor != "10.0.0.1:1234" || entries[0].Action != "rejudge" {
+		t.Errorf("entries[0] == %+v, want a rejudge by 10.0.0.1:1234", entries[0])
+	}
+	if entries[1].Actor != "frontend" || entries[1].Action != "reload_config" {
+		t.Errorf("entries[1] == %+v, want a config reload by frontend", entries[1])
+	}
+	if entries[0].Time.Before(start.Add(-time.Second)) {
+		t.Errorf("entries[0].Time == %v, want it after %v", entries[0].Time, start)
+	}
+
+	if entries, err := log.Query(time.Time{}, "reload_config", 0); err != nil || len(entries) != 1 {
+		t.Errorf("log.Query(action=reload_config) == (%v, %v), want one entry", entries, err)
+	}
+	if entries, err := log.Query(time.Time{}, "", 1); err != nil || len(entries) != 1 || entries[0].Action != "reload_config" {
+		t.Errorf("log.Query(limit=1) == (%v, %v), want the most recent entry", entries, err)
+	}
+	if entries, err := log.Query(time.Now().Add(time.Hour), "", 0); err != nil || len(entries) != 0 {
+		t.Errorf("log.Query(since=future) == (%v, %v), want no entries", entries, err)
+	}
+}
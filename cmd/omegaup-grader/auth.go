@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+)
+
+const (
+	// hmacKeyHeader is the header with the ID of the key that signed the
+	// request.
+	hmacKeyHeader = "OmegaUp-Auth-Key"
+	// hmacTimestampHeader is the header with the time, in seconds since the
+	// epoch, when the request was signed.
+	hmacTimestampHeader = "OmegaUp-Auth-Timestamp"
+	// hmacSignatureHeader is the header with the hex-encoded signature of the
+	// request.
+	hmacSignatureHeader = "OmegaUp-Auth-Signature"
+
+	// maxOAuthCacheEntries is the number of cached introspections after which
+	// the expired ones are discarded.
+	maxOAuthCacheEntries = 1024
+)
+
+// authenticatedEndpoints are the frontend endpoints that can have an
+// authentication policy.
+var authenticatedEndpoints = map[string]struct{}{
+	"/run/new/":           {},
+	"/run/grade/":         {},
+	"/run/status/":        {},
+	"/run/progress/":      {},
+	"/run/resource/":      {},
+	"/submission/source/": {},
+	"/artifact/upload/":   {},
+	"/scoreboard/":        {},
+	"/problem/manifest/":  {},
+	"/problem/stats/":     {},
+	"/broadcast/":         {},
+	"/ephemeral/":         {},
+	"/ci/":                {},
+}
+
+// requiredAuthEndpoints are the frontend endpoints that must always have an
+// authentication policy, since they write to the grader or send messages on
+// its behalf.
+var requiredAuthEndpoints = []string{
+	"/artifact/upload/",
+	"/broadcast/",
+}
+
+// An authenticator establishes who sent a request to one of the frontend
+// endpoints.
+type authenticator interface {
+	// Authenticate returns the identity of whoever sent the request, or an
+	// error if the request does not have valid credentials for this method.
+	Authenticate(r *http.Request) (string, error)
+}
+
+// mtlsAuthenticator authenticates the requests with the client certificate
+// that the server already verified.
+type mtlsAuthenticator struct {
+	subjects map[string]struct{}
+}
+
+func (a *mtlsAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", errors.New("no client certificate")
+	}
+	subject := r.TLS.PeerCertificates[0].Subject.CommonName
+	if len(a.subjects) > 0 {
+		if _, ok := a.subjects[subject]; !ok {
+			return "", fmt.Errorf("client certificate %q is not allowed", subject)
+		}
+	}
+	return "mtls:" + subject, nil
+}
+
+// hmacAuthenticator authenticates the requests that are signed with one of
+// the shared secrets.
+type hmacAuthenticator struct {
+	keys    map[string]string
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// hmacSignature returns the signature of a request: the HMAC-SHA256 of its
+// timestamp, method, URI and the SHA-256 of its body, separated by newlines.
+func hmacSignature(secret, timestamp, method, uri string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", timestamp, method, uri, hex.EncodeToString(bodyHash[:]))
+	return mac.Sum(nil)
+}
+
+func (a *hmacAuthenticator) Authenticate(r *http.Request) (string, error) {
+	keyID := r.Header.Get(hmacKeyHeader)
+	if keyID == "" {
+		return "", errors.New("request is not signed")
+	}
+	secret, ok := a.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("unknown key %q", keyID)
+	}
+	timestamp := r.Header.Get(hmacTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp %q: %w", timestamp, err)
+	}
+	skew := a.now().Sub(time.Unix(seconds, 0))
+	if skew > a.maxSkew || -skew > a.maxSkew {
+		return "", fmt.Errorf("timestamp is off by %v", skew)
+	}
+	signature, err := hex.DecodeString(r.Header.Get(hmacSignatureHeader))
+	if err != nil {
+		return "", fmt.Errorf("invalid signature: %w", err)
+	}
+
+	// The body is signed too, so it has to be read completely and then put
+	// back for the handler.
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("failed to read the body: %w", err)
+		}
+	}
+	if !hmac.Equal(signature, hmacSignature(secret, timestamp, r.Method, r.URL.RequestURI(), body)) {
+		return "", errors.New("signature mismatch")
+	}
+	return "hmac:" + keyID, nil
+}
+
+// oauthIntrospection is the part of an OAuth 2.0 token introspection
+// response (RFC 7662) that is used.
+type oauthIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope"`
+	ClientID  string `json:"client_id"`
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type oauthCacheEntry struct {
+	identity string
+	expires  time.Time
+}
+
+// oauthAuthenticator authenticates the requests whose bearer tokens are
+// active according to an OAuth 2.0 token introspection endpoint. The results
+// are cached, so that the endpoint is not asked about every request.
+type oauthAuthenticator struct {
+	sync.Mutex
+	config *common.GraderOAuthAuthConfig
+	client *http.Client
+	cache  map[string]oauthCacheEntry
+	now    func() time.Time
+}
+
+func (a *oauthAuthenticator) Authenticate(r *http.Request) (string, error) {
+	authorization := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == authorization || token == "" {
+		return "", errors.New("no bearer token")
+	}
+	now := a.now()
+
+	a.Lock()
+	entry, ok := a.cache[token]
+	a.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.identity, nil
+	}
+
+	introspection, err := a.introspect(r, token)
+	if err != nil {
+		return "", err
+	}
+	if !introspection.Active {
+		return "", errors.New("token is not active")
+	}
+	if a.config.Scope != "" {
+		found := false
+		for _, scope := range strings.Fields(introspection.Scope) {
+			if scope == a.config.Scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("token does not have the %q scope", a.config.Scope)
+		}
+	}
+	identity := introspection.Subject
+	if identity == "" {
+		identity = introspection.ClientID
+	}
+	entry = oauthCacheEntry{
+		identity: "oauth:" + identity,
+		expires:  now.Add(time.Duration(a.config.CacheTTL)),
+	}
+	if introspection.ExpiresAt != 0 {
+		if expires := time.Unix(introspection.ExpiresAt, 0); expires.Before(entry.expires) {
+			entry.expires = expires
+		}
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if len(a.cache) >= maxOAuthCacheEntries {
+		for cachedToken, cached := range a.cache {
+			if !now.Before(cached.expires) {
+				delete(a.cache, cachedToken)
+			}
+		}
+	}
+	a.cache[token] = entry
+	return entry.identity, nil
+}
+
+func (a *oauthAuthenticator) introspect(r *http.Request, token string) (*oauthIntrospection, error) {
+	req, err := http.NewRequestWithContext(
+		r.Context(),
+		"POST",
+		a.config.IntrospectionURL,
+		strings.NewReader(url.Values{"token": {token}}.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if a.config.ClientID != "" {
+		req.SetBasicAuth(a.config.ClientID, a.config.ClientSecret)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token introspection failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token introspection failed: %s", resp.Status)
+	}
+	var introspection oauthIntrospection
+	if err := json.NewDecoder(resp.Body).Decode(&introspection); err != nil {
+		return nil, fmt.Errorf("invalid token introspection response: %w", err)
+	}
+	return &introspection, nil
+}
+
+// frontendAuth applies the authentication policies of the frontend
+// endpoints.
+type frontendAuth struct {
+	methods  map[string]authenticator
+	policies map[string][]string
+}
+
+// newFrontendAuth creates the authenticators that the policies in the
+// configuration use. It fails if a policy is for an endpoint that is not in
+// authenticatedEndpoints, or if it uses a method that does not exist or that
+// is not configured.
+func newFrontendAuth(config *common.GraderAuthConfig) (*frontendAuth, error) {
+	auth := &frontendAuth{
+		methods:  make(map[string]authenticator),
+		policies: config.Policies,
+	}
+	for _, endpoint := range requiredAuthEndpoints {
+		if _, ok := config.Policies[endpoint]; !ok {
+			return nil, fmt.Errorf("%s must have an authentication policy", endpoint)
+		}
+	}
+	for endpoint, methods := range config.Policies {
+		if _, ok := authenticatedEndpoints[endpoint]; !ok {
+			return nil, fmt.Errorf("%s cannot have an authentication policy", endpoint)
+		}
+		if len(methods) == 0 {
+			return nil, fmt.Errorf("the policy of %s has no authentication methods", endpoint)
+		}
+		for _, method := range methods {
+			if _, ok := auth.methods[method]; ok {
+				continue
+			}
+			switch method {
+			case "mtls":
+				subjects := make(map[string]struct{})
+				for _, subject := range config.MTLS.Subjects {
+					subjects[subject] = struct{}{}
+				}
+				auth.methods[method] = &mtlsAuthenticator{subjects: subjects}
+			case "hmac":
+				if len(config.HMAC.Keys) == 0 {
+					return nil, fmt.Errorf("the policy of %s uses hmac, but there are no keys", endpoint)
+				}
+				auth.methods[method] = &hmacAuthenticator{
+					keys:    config.HMAC.Keys,
+					maxSkew: time.Duration(config.HMAC.MaxSkew),
+					now:     time.Now,
+				}
+			case "oauth":
+				if config.OAuth.IntrospectionURL == "" {
+					return nil, fmt.Errorf("the policy of %s uses oauth, but there is no introspection URL", endpoint)
+				}
+				auth.methods[method] = &oauthAuthenticator{
+					config: &config.OAuth,
+					client: &http.Client{Timeout: 10 * time.Second},
+					cache:  make(map[string]oauthCacheEntry),
+					now:    time.Now,
+				}
+			default:
+				return nil, fmt.Errorf("the policy of %s has an unknown authentication method %q", endpoint, method)
+			}
+		}
+	}
+	return auth, nil
+}
+
+// wrap returns a handler that only lets the requests to the endpoint through
+// if one of the methods of its policy authenticates them. Endpoints without a
+// policy are not wrapped.
+func (a *frontendAuth) wrap(ctx *grader.Context, endpoint string, handler http.Handler) http.Handler {
+	methods, ok := a.policies[endpoint]
+	if !ok {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures := make(map[string]string)
+		for _, method := range methods {
+			identity, err := a.methods[method].Authenticate(r)
+			if err == nil {
+				ctx.Log.Debug(
+					"Authenticated request",
+					map[string]any{
+						"url":      r.URL.Path,
+						"identity": identity,
+					},
+				)
+				handler.ServeHTTP(w, r)
+				return
+			}
+			failures[method] = err.Error()
+		}
+		ctx.Log.Error(
+			"Unauthenticated request",
+			map[string]any{
+				"url":      r.URL.Path,
+				"remote":   r.RemoteAddr,
+				"failures": failures,
+			},
+		)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
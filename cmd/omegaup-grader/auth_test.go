@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omegaup/quark/common"
+)
+
+func TestFrontendAuth(t *testing.T) {
+	ctx := newGraderContext(t)
+
+	introspections := 0
+	introspectionServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		introspections++
+		if clientID, _, _ := r.BasicAuth(); clientID != "grader" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.FormValue("token") {
+		case "valid":
+			fmt.Fprint(w, `{"active": true, "sub": "frontend", "scope": "read grade"}`)
+		case "unscoped":
+			fmt.Fprint(w, `{"active": true, "sub": "frontend", "scope": "read"}`)
+		default:
+			fmt.Fprint(w, `{"active": false}`)
+		}
+	}))
+	defer introspectionServer.Close()
+
+	config := common.DefaultConfig().Grader.Auth
+	config.Policies = map[string][]string{
+		"/run/grade/":         {"hmac", "oauth"},
+		"/submission/source/": {"mtls"},
+		"/artifact/upload/":   {"mtls"},
+		"/broadcast/":         {"mtls"},
+		"/ci/":                {"hmac"},
+	}
+	config.MTLS.Subjects = []string{"frontend"}
+	config.HMAC.Keys = map[string]string{"key": "secret"}
+	config.OAuth.IntrospectionURL = introspectionServer.URL
+	config.OAuth.ClientID = "grader"
+	config.OAuth.Scope = "grade"
+	auth, err := newFrontendAuth(&config)
+	if err != nil {
+		t.Fatalf("Failed to create the authenticators: %v", err)
+	}
+
+	handler := func(endpoint string) http.Handler {
+		return auth.wrap(ctx, endpoint, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			fmt.Fprint(w, string(body))
+		}))
+	}
+	signed := func(r *http.Request, secret string, timestamp time.Time, body string) *http.Request {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		r.Header.Set(hmacKeyHeader, "key")
+		r.Header.Set(hmacTimestampHeader, ts)
+		r.Header.Set(
+			hmacSignatureHeader,
+			hex.EncodeToString(hmacSignature(secret, ts, r.Method, r.URL.RequestURI(), []byte(body))),
+		)
+		return r
+	}
+	withCertificate := func(r *http.Request, subject string) *http.Request {
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{
+				{Subject: pkix.Name{CommonName: subject}},
+			},
+		}
+		return r
+	}
+	withToken := func(r *http.Request, token string) *http.Request {
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name     string
+		endpoint string
+		request  *http.Request
+		status   int
+	}{
+		{
+			"no policy",
+			"/run/new/",
+			httptest.NewRequest("POST", "/run/new/", strings.NewReader("body")),
+			http.StatusOK,
+		},
+		{
+			"no credentials",
+			"/run/grade/",
+			httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")),
+			http.StatusUnauthorized,
+		},
+		{
+			"signed",
+			"/run/grade/",
+			signed(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")), "secret", now, "body"),
+			http.StatusOK,
+		},
+		{
+			"wrong secret",
+			"/run/grade/",
+			signed(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")), "wrong", now, "body"),
+			http.StatusUnauthorized,
+		},
+		{
+			"tampered body",
+			"/run/grade/",
+			signed(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("tampered")), "secret", now, "body"),
+			http.StatusUnauthorized,
+		},
+		{
+			"old signature",
+			"/run/grade/",
+			signed(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")), "secret", now.Add(-time.Hour), "body"),
+			http.StatusUnauthorized,
+		},
+		{
+			"valid token",
+			"/run/grade/",
+			withToken(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")), "valid"),
+			http.StatusOK,
+		},
+		{
+			"token without scope",
+			"/run/grade/",
+			withToken(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")), "unscoped"),
+			http.StatusUnauthorized,
+		},
+		{
+			"inactive token",
+			"/run/grade/",
+			withToken(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")), "revoked"),
+			http.StatusUnauthorized,
+		},
+		{
+			"certificate",
+			"/submission/source/",
+			withCertificate(httptest.NewRequest("GET", "/submission/source/guid/", nil), "frontend"),
+			http.StatusOK,
+		},
+		{
+			"other certificate",
+			"/submission/source/",
+			withCertificate(httptest.NewRequest("GET", "/submission/source/guid/", nil), "runner"),
+			http.StatusUnauthorized,
+		},
+		{
+			"token instead of certificate",
+			"/submission/source/",
+			withToken(httptest.NewRequest("GET", "/submission/source/guid/", nil), "valid"),
+			http.StatusUnauthorized,
+		},
+		{
+			"unauthenticated broadcast",
+			"/broadcast/",
+			httptest.NewRequest("POST", "/broadcast/", strings.NewReader("body")),
+			http.StatusUnauthorized,
+		},
+		{
+			"signed ci request",
+			"/ci/",
+			signed(httptest.NewRequest("GET", "/ci/problem/sumas/", nil), "secret", now, ""),
+			http.StatusOK,
+		},
+	} {
+		w := httptest.NewRecorder()
+		handler(tc.endpoint).ServeHTTP(w, tc.request)
+		if w.Code != tc.status {
+			t.Errorf("%s: status == %d, want %d", tc.name, w.Code, tc.status)
+		}
+		if w.Code == http.StatusOK && tc.request.Method == "POST" && w.Body.String() != "body" {
+			t.Errorf("%s: body == %q, want \"body\"", tc.name, w.Body.String())
+		}
+	}
+
+	// Valid tokens are only introspected once.
+	introspections = 0
+	w := httptest.NewRecorder()
+	handler("/run/grade/").ServeHTTP(
+		w,
+		withToken(httptest.NewRequest("POST", "/run/grade/", strings.NewReader("body")), "valid"),
+	)
+	if w.Code != http.StatusOK || introspections != 0 {
+		t.Errorf("status == %d, introspections == %d, want %d, 0", w.Code, introspections, http.StatusOK)
+	}
+
+	for _, policies := range []map[string][]string{
+		{"/grader/status/": {"mtls"}, "/artifact/upload/": {"mtls"}, "/broadcast/": {"mtls"}},
+		{"/run/new/": {}, "/artifact/upload/": {"mtls"}, "/broadcast/": {"mtls"}},
+		{"/run/new/": {"password"}, "/artifact/upload/": {"mtls"}, "/broadcast/": {"mtls"}},
+		{"/run/new/": {"hmac"}, "/artifact/upload/": {"mtls"}, "/broadcast/": {"mtls"}},
+		{"/run/new/": {"mtls"}, "/broadcast/": {"mtls"}},
+		{"/artifact/upload/": {"mtls"}},
+		{"/artifact/upload/": {}, "/broadcast/": {"mtls"}},
+	} {
+		if _, err := newFrontendAuth(&common.GraderAuthConfig{Policies: policies}); err == nil {
+			t.Errorf("newFrontendAuth(%v) succeeded, want an error", policies)
+		}
+	}
+}
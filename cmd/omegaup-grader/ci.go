@@ -574,6 +574,7 @@ func (h *ciHandler) Shutdown(ctx context.Context) error {
 func registerCIHandlers(
 	ctx *grader.Context,
 	mux *http.ServeMux,
+	auth *frontendAuth,
 	ephemeralRunManager *grader.EphemeralRunManager,
 ) shutdowner {
 	ciHandler := &ciHandler{
@@ -584,7 +585,7 @@ func registerCIHandlers(
 		reportChan:          make(chan *reportWithPath, 128),
 		doneChan:            make(chan struct{}),
 	}
-	mux.Handle(ctx.Tracing.WrapHandle("/ci/", ciHandler))
+	mux.Handle(ctx.Tracing.WrapHandle("/ci/", auth.wrap(ctx, "/ci/", ciHandler)))
 	go ciHandler.run()
 	return ciHandler
 }
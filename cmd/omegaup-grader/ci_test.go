@@ -82,9 +82,9 @@ func TestCI(t *testing.T) {
 		t.Fatalf("Failed to fully initalize the ephemeral run manager: %s", err)
 	}
 	mux := http.NewServeMux()
-	shutdowner := registerCIHandlers(ctx, mux, ephemeralRunManager)
+	shutdowner := registerCIHandlers(ctx, mux, &frontendAuth{}, ephemeralRunManager)
 	defer shutdowner.Shutdown(context.Background())
-	registerRunnerHandlers(ctx, mux, nil, true)
+	registerRunnerHandlers(ctx, mux, nil, grader.NewArtifactManager(nil, nil), true)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
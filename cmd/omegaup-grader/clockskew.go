@@ -0,0 +1,166 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/grader"
+)
+
+var (
+	// logTimestampRe matches the timestamp of a logfmt line.
+	logTimestampRe = regexp.MustCompile(`(?m)(^|\s)t=(\S+)`)
+
+	// logTimestampLayouts are the layouts of the timestamps in the logs that
+	// the runners send. The first one is the one that log15 uses.
+	logTimestampLayouts = []string{
+		"2006-01-02T15:04:05-0700",
+		time.RFC3339Nano,
+	}
+)
+
+type observedClock struct {
+	offset   time.Duration
+	skewed   bool
+	lastSeen time.Time
+}
+
+// clockMonitor keeps track of how far ahead of the grader's clock the clock of
+// each runner is, based on the OmegaUp-Runner-Time header that runners send
+// when they request a run. The offset includes the time the request took to
+// arrive, so it is only accurate to within the network latency.
+type clockMonitor struct {
+	sync.Mutex
+	runners map[string]observedClock
+}
+
+var clocks = newClockMonitor()
+
+func newClockMonitor() *clockMonitor {
+	return &clockMonitor{
+		runners: make(map[string]observedClock),
+	}
+}
+
+// Observe records the offset between the time in the header, which must be
+// in RFC3339 format, and the time in which the grader received the request.
+// A warning is logged when the offset of a runner goes beyond the configured
+// threshold, and an informational message when it goes back within it.
+func (m *clockMonitor) Observe(
+	ctx *grader.Context,
+	runnerName, header string,
+	received time.Time,
+) {
+	if header == "" {
+		return
+	}
+	runnerTime, err := time.Parse(time.RFC3339Nano, header)
+	if err != nil {
+		ctx.Log.Error(
+			"Invalid runner time",
+			map[string]any{
+				"runner": runnerName,
+				"header": header,
+				"err":    err,
+			},
+		)
+		return
+	}
+	offset := runnerTime.Sub(received)
+	threshold := time.Duration(ctx.Config.Grader.ClockSkewThreshold)
+	skewed := threshold > 0 && (offset > threshold || offset < -threshold)
+
+	m.Lock()
+	previous := m.runners[runnerName]
+	m.runners[runnerName] = observedClock{
+		offset:   offset,
+		skewed:   skewed,
+		lastSeen: received,
+	}
+	m.Unlock()
+
+	gaugeVecs["grader_runner_clock_offset_seconds"].WithLabelValues(runnerName).Set(offset.Seconds())
+
+	if skewed && !previous.skewed {
+		ctx.Metrics.CounterAdd("grader_runner_clock_skew_warnings", 1)
+		ctx.Log.Warn(
+			"Runner clock is skewed",
+			map[string]any{
+				"runner":    runnerName,
+				"offset":    offset.String(),
+				"threshold": threshold.String(),
+			},
+		)
+	} else if !skewed && previous.skewed {
+		ctx.Log.Info(
+			"Runner clock is no longer skewed",
+			map[string]any{
+				"runner": runnerName,
+				"offset": offset.String(),
+			},
+		)
+	}
+}
+
+// Offset returns the most recently observed offset of the clock of the runner.
+// It returns false if the runner has not been seen recently.
+func (m *clockMonitor) Offset(runnerName string) (time.Duration, bool) {
+	cutoffTime := time.Now().Add(-3 * time.Minute)
+	m.Lock()
+	defer m.Unlock()
+	observed, ok := m.runners[runnerName]
+	if !ok || observed.lastSeen.Before(cutoffTime) {
+		return 0, false
+	}
+	return observed.offset, true
+}
+
+// Offsets returns the offset in seconds of the clock of each one of the
+// runners that have been seen recently.
+func (m *clockMonitor) Offsets() map[string]float64 {
+	cutoffTime := time.Now().Add(-3 * time.Minute)
+	result := make(map[string]float64)
+	m.Lock()
+	defer m.Unlock()
+	for runnerName, observed := range m.runners {
+		if observed.lastSeen.Before(cutoffTime) {
+			delete(m.runners, runnerName)
+			gaugeVecs["grader_runner_clock_offset_seconds"].DeleteLabelValues(runnerName)
+			continue
+		}
+		result[runnerName] = observed.offset.Seconds()
+	}
+	return result
+}
+
+// Adjust translates the timestamps of the logs that the runner sent to the
+// grader's clock, so that they can be interleaved with the grader's own logs.
+// The logs are returned unmodified if the offset of the runner is not known.
+func (m *clockMonitor) Adjust(runnerName string, logs []byte) []byte {
+	offset, ok := m.Offset(runnerName)
+	if !ok || offset == 0 {
+		return logs
+	}
+	return adjustLogTimestamps(logs, offset)
+}
+
+// adjustLogTimestamps subtracts offset from the timestamps of the logfmt
+// lines, keeping their layout. Timestamps that cannot be parsed are left as
+// they are.
+func adjustLogTimestamps(logs []byte, offset time.Duration) []byte {
+	return logTimestampRe.ReplaceAllFunc(logs, func(match []byte) []byte {
+		submatches := logTimestampRe.FindSubmatch(match)
+		prefix, value := submatches[1], string(submatches[2])
+		for _, layout := range logTimestampLayouts {
+			t, err := time.Parse(layout, value)
+			if err != nil {
+				continue
+			}
+			adjusted := append([]byte{}, prefix...)
+			adjusted = append(adjusted, "t="...)
+			return append(adjusted, t.Add(-offset).Format(layout)...)
+		}
+		return match
+	})
+}
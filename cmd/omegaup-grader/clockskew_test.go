@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockMonitor(t *testing.T) {
+	ctx := newGraderContext(t)
+	monitor := newClockMonitor()
+
+	received := time.Now()
+	monitor.Observe(ctx, "runner-1", received.Add(5*time.Second).UTC().Format(time.RFC3339Nano), received)
+	monitor.Observe(ctx, "runner-2", received.Add(-250*time.Millisecond).UTC().Format(time.RFC3339Nano), received)
+	monitor.Observe(ctx, "runner-3", "not a time", received)
+	monitor.Observe(ctx, "runner-4", "", received)
+
+	offsets := monitor.Offsets()
+	expectedOffsets := map[string]float64{
+		"runner-1": 5,
+		"runner-2": -0.25,
+	}
+	if len(offsets) != len(expectedOffsets) {
+		t.Errorf("monitor.Offsets() == %v, want %v", offsets, expectedOffsets)
+	}
+	for runnerName, expected := range expectedOffsets {
+		if got := offsets[runnerName]; got != expected {
+			t.Errorf("monitor.Offsets()[%q] == %v, want %v", runnerName, got, expected)
+		}
+	}
+
+	if !monitor.runners["runner-1"].skewed {
+		t.Errorf("runner-1 should be skewed")
+	}
+	if monitor.runners["runner-2"].skewed {
+		t.Errorf("runner-2 should not be skewed")
+	}
+
+	logs := []byte("t=2021-01-01T00:00:05+0000 lvl=info msg=\"Running\"\n")
+	expected := "t=2021-01-01T00:00:00+0000 lvl=info msg=\"Running\"\n"
+	if got := string(monitor.Adjust("runner-1", logs)); got != expected {
+		t.Errorf("monitor.Adjust(\"runner-1\") == %q, want %q", got, expected)
+	}
+	if got := string(monitor.Adjust("runner-5", logs)); got != string(logs) {
+		t.Errorf("monitor.Adjust(\"runner-5\") == %q, want %q", got, string(logs))
+	}
+}
+
+func TestAdjustLogTimestamps(t *testing.T) {
+	for _, tc := range []struct {
+		logs     string
+		offset   time.Duration
+		expected string
+	}{
+		{
+			logs:     "t=2021-01-01T00:00:05-0600 lvl=dbug msg=a\nt=2021-01-01T00:01:00-0600 lvl=dbug msg=b\n",
+			offset:   5 * time.Second,
+			expected: "t=2021-01-01T00:00:00-0600 lvl=dbug msg=a\nt=2021-01-01T00:00:55-0600 lvl=dbug msg=b\n",
+		},
+		{
+			logs:     "t=2021-01-01T00:00:00.5Z lvl=info msg=c\n",
+			offset:   -1500 * time.Millisecond,
+			expected: "t=2021-01-01T00:00:02Z lvl=info msg=c\n",
+		},
+		{
+			logs:     "t=yesterday lvl=info msg=d start=2021-01-01T00:00:00Z\n",
+			offset:   time.Second,
+			expected: "t=yesterday lvl=info msg=d start=2021-01-01T00:00:00Z\n",
+		},
+	} {
+		if got := string(adjustLogTimestamps([]byte(tc.logs), tc.offset)); got != tc.expected {
+			t.Errorf("adjustLogTimestamps(%q, %v) == %q, want %q", tc.logs, tc.offset, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// 2021-01-01 is a Friday.
+	after := time.Date(2021, time.January, 1, 12, 30, 15, 0, time.UTC)
+	for _, tc := range []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2021, time.January, 1, 12, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2021, time.January, 2, 3, 0, 0, 0, time.UTC)},
+		{"*/20 13-15 * * *", time.Date(2021, time.January, 1, 13, 0, 0, 0, time.UTC)},
+		{"45 12 * * *", time.Date(2021, time.January, 1, 12, 45, 0, 0, time.UTC)},
+		{"0 2 * * 0,6", time.Date(2021, time.January, 2, 2, 0, 0, 0, time.UTC)},
+		{"0 0 1 3 *", time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		// Either the day of the month or the day of the week needs to match.
+		{"0 0 15 * 1", time.Date(2021, time.January, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		schedule, err := parseCronSchedule(tc.spec)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q) failed: %v", tc.spec, err)
+			continue
+		}
+		if got := schedule.Next(after); !got.Equal(tc.expected) {
+			t.Errorf("parseCronSchedule(%q).Next(%v) == %v, want %v", tc.spec, after, got, tc.expected)
+		}
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := parseCronSchedule(spec); err == nil {
+			t.Errorf("parseCronSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
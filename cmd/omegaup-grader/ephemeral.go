@@ -74,6 +74,23 @@ type ephemeralRunHandler struct {
 	ctx                 *grader.Context
 }
 
+// capEphemeralLimits silently applies the configured caps to the limits of an
+// ephemeral run.
+func capEphemeralLimits(ctx *grader.Context, limits *common.LimitsSettings) {
+	limits.TimeLimit = base.Min(
+		ctx.Config.Grader.Ephemeral.CaseTimeLimit,
+		limits.TimeLimit,
+	)
+	limits.OverallWallTimeLimit = base.Min(
+		ctx.Config.Grader.Ephemeral.OverallWallTimeLimit,
+		limits.OverallWallTimeLimit,
+	)
+	limits.MemoryLimit = base.Min(
+		ctx.Config.Grader.Ephemeral.MemoryLimit,
+		limits.MemoryLimit,
+	)
+}
+
 func (h *ephemeralRunHandler) validateRequest(
 	ctx *grader.Context,
 	ephemeralRunRequest *grader.EphemeralRunRequest,
@@ -81,19 +98,7 @@ func (h *ephemeralRunHandler) validateRequest(
 	if ephemeralRunRequest.Input.Limits == nil {
 		return nil
 	}
-	// Silently apply some caps.
-	ephemeralRunRequest.Input.Limits.TimeLimit = base.Min(
-		ctx.Config.Grader.Ephemeral.CaseTimeLimit,
-		ephemeralRunRequest.Input.Limits.TimeLimit,
-	)
-	ephemeralRunRequest.Input.Limits.OverallWallTimeLimit = base.Min(
-		ctx.Config.Grader.Ephemeral.OverallWallTimeLimit,
-		ephemeralRunRequest.Input.Limits.OverallWallTimeLimit,
-	)
-	ephemeralRunRequest.Input.Limits.MemoryLimit = base.Min(
-		ctx.Config.Grader.Ephemeral.MemoryLimit,
-		ephemeralRunRequest.Input.Limits.MemoryLimit,
-	)
+	capEphemeralLimits(ctx, ephemeralRunRequest.Input.Limits)
 	return nil
 }
 
@@ -153,7 +158,7 @@ func (h *ephemeralRunHandler) addAndWaitForRun(
 			return inputFactoryErr
 		}
 		errorString := inputFactoryErr.Error()
-		fakeResult := runner.NewRunResult("CE", maxScore)
+		fakeResult := runner.NewRunResult(common.VerdictCompileError, maxScore)
 		fakeResult.CompileError = &errorString
 		if err = json.NewEncoder(resultWriter).Encode(fakeResult); err != nil {
 			ctx.Log.Error(
@@ -403,11 +408,16 @@ func (h *ephemeralRunHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 func registerEphemeralHandlers(
 	ctx *grader.Context,
 	mux *http.ServeMux,
+	auth *frontendAuth,
 	ephemeralRunManager *grader.EphemeralRunManager,
 ) {
 	ephemeralRunHandler := &ephemeralRunHandler{
 		ephemeralRunManager: ephemeralRunManager,
 		ctx:                 ctx,
 	}
-	mux.Handle(ctx.Tracing.WrapHandle("/ephemeral/run/", ephemeralRunHandler))
+	mux.Handle(ctx.Tracing.WrapHandle("/ephemeral/run/", auth.wrap(ctx, "/ephemeral/", ephemeralRunHandler)))
+	mux.Handle(ctx.Tracing.WrapHandle("/ephemeral/execute/", auth.wrap(ctx, "/ephemeral/", &ephemeralExecuteHandler{
+		ephemeralRunManager: ephemeralRunManager,
+		ctx:                 ctx,
+	})))
 }
@@ -88,22 +88,23 @@ func runnerRequestRun(t *testing.T, ctx *grader.Context, ts *httptest.Server) er
 		t.Errorf("Failed to parse result URL: %s", err)
 		return err
 	}
+	artifactsURL, err := url.Parse(fmt.Sprintf("%s/run/%d/artifacts/", ts.URL, run.AttemptID))
+	if err != nil {
+		t.Errorf("Failed to parse artifacts URL: %s", err)
+		return err
+	}
 
 	var buf bytes.Buffer
+	var filesZip bytes.Buffer
 	var contentType string
 	{
 		multipartWriter := multipart.NewWriter(&buf)
 		contentType = multipartWriter.FormDataContentType()
-		filesWriter, err := multipartWriter.CreateFormFile("file", "files.zip")
-		if err != nil {
-			t.Errorf("Failed to create files.zip multipart writer: %s", err)
-			return err
-		}
 
 		inputManager := common.NewInputManager(&ctx.Context)
 		inputRef, err := inputManager.Add(
 			run.InputHash,
-			runner.NewInputFactory(ts.Client(), &ctx.Config, baseURL, ""),
+			runner.NewInputFactory(&ctx.Context, ts.Client(), baseURL, ""),
 		)
 		if err != nil {
 			t.Errorf("Failed to add input to input manager: %s", err)
@@ -111,7 +112,7 @@ func runnerRequestRun(t *testing.T, ctx *grader.Context, ts *httptest.Server) er
 		}
 		defer inputRef.Release()
 
-		result, err := runner.Grade(&ctx.Context, filesWriter, &run, inputRef.Input, &runner.NoopSandbox{})
+		result, err := runner.Grade(&ctx.Context, &filesZip, &run, inputRef.Input, &runner.NoopSandbox{})
 		if err != nil {
 			t.Errorf("Failed to grade run: %s", err)
 			return err
@@ -131,15 +132,37 @@ func runnerRequestRun(t *testing.T, ctx *grader.Context, ts *httptest.Server) er
 		multipartWriter.Close()
 	}
 
+	// The artifacts are uploaded separately, before the results.
+	artifactsReq := &http.Request{
+		Method: "POST",
+		URL:    artifactsURL,
+		Header: map[string][]string{
+			"Content-Type":  {"application/zip"},
+			workTokenHeader: {resp.Header.Get(workTokenHeader)},
+		},
+		Body: ioutil.NopCloser(&filesZip),
+	}
+	response, err := ts.Client().Do(artifactsReq)
+	if err != nil {
+		t.Errorf("Failed to upload artifacts: %s", err)
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Failed to upload artifacts: status %d", response.StatusCode)
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
 	req := &http.Request{
 		Method: "POST",
 		URL:    uploadURL,
 		Header: map[string][]string{
-			"Content-Type": {contentType},
+			"Content-Type":  {contentType},
+			workTokenHeader: {resp.Header.Get(workTokenHeader)},
 		},
 		Body: ioutil.NopCloser(&buf),
 	}
-	response, err := ts.Client().Do(req)
+	response, err = ts.Client().Do(req)
 	if err != nil {
 		t.Errorf("Failed to upload final results: %s", err)
 		return err
@@ -159,8 +182,8 @@ func TestEphemeralGrader(t *testing.T) {
 		t.Fatalf("Failed to fully initalize the ephemeral run manager: %s", err)
 	}
 	mux := http.NewServeMux()
-	registerEphemeralHandlers(ctx, mux, ephemeralRunManager)
-	registerRunnerHandlers(ctx, mux, nil, true)
+	registerEphemeralHandlers(ctx, mux, &frontendAuth{}, ephemeralRunManager)
+	registerRunnerHandlers(ctx, mux, nil, grader.NewArtifactManager(nil, nil), true)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
@@ -215,3 +238,103 @@ func TestEphemeralGrader(t *testing.T) {
 		t.Fatalf("Failed to read all: %v", err)
 	}
 }
+
+func TestEphemeralExecute(t *testing.T) {
+	ctx := newGraderContext(t)
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(path.Dir(ctx.Config.Grader.RuntimePath))
+	}
+	ephemeralRunManager := grader.NewEphemeralRunManager(ctx)
+	if err := ephemeralRunManager.Initialize(); err != nil {
+		t.Fatalf("Failed to fully initalize the ephemeral run manager: %s", err)
+	}
+	mux := http.NewServeMux()
+	registerEphemeralHandlers(ctx, mux, &frontendAuth{}, ephemeralRunManager)
+	registerRunnerHandlers(ctx, mux, nil, grader.NewArtifactManager(nil, nil), true)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	go func() {
+		err := runnerRequestRun(t, ctx, ts)
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	res, err := ts.Client().Post(
+		ts.URL+"/ephemeral/execute/new/",
+		"application/json",
+		bytes.NewBufferString(`{"source": "print(input())", "language": "py3", "input": "1 2"}`),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create execute request: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to request execution: Status %v, headers: %v", res.StatusCode, res.Header)
+	}
+
+	var result grader.ExecuteResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode execute result: %v", err)
+	}
+	if result.CompileError != nil {
+		t.Errorf("result.CompileError == %q, want nil", *result.CompileError)
+	}
+	if result.Meta == nil || result.Meta.Verdict != "OK" {
+		t.Errorf("result.Meta == %v, want a meta with an OK verdict", result.Meta)
+	}
+}
+
+func TestEphemeralValidate(t *testing.T) {
+	ctx := newGraderContext(t)
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(path.Dir(ctx.Config.Grader.RuntimePath))
+	}
+	ephemeralRunManager := grader.NewEphemeralRunManager(ctx)
+	if err := ephemeralRunManager.Initialize(); err != nil {
+		t.Fatalf("Failed to fully initalize the ephemeral run manager: %s", err)
+	}
+	mux := http.NewServeMux()
+	registerEphemeralHandlers(ctx, mux, &frontendAuth{}, ephemeralRunManager)
+	registerRunnerHandlers(ctx, mux, nil, grader.NewArtifactManager(nil, nil), true)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	go func() {
+		err := runnerRequestRun(t, ctx, ts)
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	res, err := ts.Client().Post(
+		ts.URL+"/ephemeral/validate/new/",
+		"application/json",
+		bytes.NewBufferString(`{
+			"source": "print(1)",
+			"language": "py3",
+			"input": "1 2",
+			"expected_output": "3",
+			"output": "3"
+		}`),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create validate request: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to request validation: Status %v, headers: %v", res.StatusCode, res.Header)
+	}
+
+	var result grader.ValidateResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode validate result: %v", err)
+	}
+	if result.CompileError != nil {
+		t.Errorf("result.CompileError == %q, want nil", *result.CompileError)
+	}
+	if result.Verdict == "" {
+		t.Errorf("result.Verdict is empty, want the verdict of the case")
+	}
+}
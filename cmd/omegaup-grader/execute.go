@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+	"github.com/omegaup/quark/runner"
+)
+
+const (
+	// executeCaseName is the name of the only case of an execute request.
+	executeCaseName = "run"
+)
+
+// ephemeralExecuteHandler compiles a program and runs it against a single
+// input, returning its output and metadata without validating or scoring it.
+// It can also run a custom validator against a single candidate output.
+// Nothing is kept after the response is sent.
+type ephemeralExecuteHandler struct {
+	ephemeralRunManager *grader.EphemeralRunManager
+	ctx                 *grader.Context
+}
+
+// readExecuteOutputs reads the files with the provided names from the
+// files.zip artifact of a run into the matching destinations.
+func readExecuteOutputs(r io.Reader, outputs map[string]*string) error {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	z, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return err
+	}
+	for _, f := range z.File {
+		dst, ok := outputs[f.Name]
+		if !ok {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		output, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		*dst = string(output)
+	}
+	return nil
+}
+
+// findExecuteCase returns the result of the only case of an execute request,
+// or nil if it was not run.
+func findExecuteCase(result *runner.RunResult) *runner.CaseResult {
+	for _, group := range result.Groups {
+		for i := range group.Cases {
+			if group.Cases[i].Name == executeCaseName {
+				return &group.Cases[i]
+			}
+		}
+	}
+	return nil
+}
+
+// runAndWait grades the source against the literal input in an ephemeral run
+// and waits for it to finish. The caller must clean the artifacts of the
+// returned RunInfo.
+func (h *ephemeralExecuteHandler) runAndWait(
+	ctx *grader.Context,
+	literalInput *common.LiteralInput,
+	language, source string,
+) (*grader.RunInfo, int, error) {
+	inputFactory, err := common.NewLiteralInputFactory(
+		literalInput,
+		ctx.Config.Grader.RuntimePath,
+		common.LiteralPersistGrader,
+	)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	runInfo := grader.NewRunInfo()
+	runInfo.Run.InputHash = inputFactory.Hash()
+	runInfo.Run.Language = language
+	runInfo.Run.Source = source
+	runInfo.Priority = grader.QueuePriorityEphemeral
+	if _, err := h.ephemeralRunManager.SetEphemeral(runInfo); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	cleanup := func() {
+		if err := runInfo.Artifacts.Clean(); err != nil {
+			ctx.Log.Error(
+				"Error cleaning up after execution",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	}
+
+	inputRef, err := ctx.InputManager.Add(inputFactory.Hash(), inputFactory)
+	if err != nil {
+		cleanup()
+		return nil, http.StatusBadRequest, err
+	}
+	runs, err := ctx.QueueManager.Get(grader.DefaultQueueName)
+	if err != nil {
+		inputRef.Release()
+		cleanup()
+		return nil, http.StatusInternalServerError, err
+	}
+	runWaitHandle, err := runs.AddWaitableRun(&ctx.Context, runInfo, inputRef)
+	if err != nil {
+		cleanup()
+		return nil, http.StatusServiceUnavailable, err
+	}
+	<-runWaitHandle.Ready()
+	return runInfo, http.StatusOK, nil
+}
+
+func (h *ephemeralExecuteHandler) execute(
+	ctx *grader.Context,
+	executeRequest *grader.ExecuteRequest,
+) (*grader.ExecuteResult, int, error) {
+	limits := common.DefaultLiteralLimitSettings
+	if executeRequest.Limits != nil {
+		limits = *executeRequest.Limits
+	}
+	capEphemeralLimits(ctx, &limits)
+
+	runInfo, status, err := h.runAndWait(
+		ctx,
+		&common.LiteralInput{
+			Cases: map[string]*common.LiteralCaseSettings{
+				executeCaseName: {
+					Input:  executeRequest.Input,
+					Weight: big.NewRat(1, 1),
+				},
+			},
+			Limits: &limits,
+		},
+		executeRequest.Language,
+		executeRequest.Source,
+	)
+	if err != nil {
+		return nil, status, err
+	}
+	defer runInfo.Artifacts.Clean()
+
+	result := &grader.ExecuteResult{
+		CompileError: runInfo.Result.CompileError,
+		CompileMeta:  runInfo.Result.CompileMeta,
+	}
+	caseResult := findExecuteCase(&runInfo.Result)
+	if result.CompileError != nil || caseResult == nil {
+		return result, http.StatusOK, nil
+	}
+	result.Meta = &caseResult.Meta
+
+	fd, err := runInfo.Artifacts.Get(&ctx.Context, "files.zip")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer fd.Close()
+	if err := readExecuteOutputs(fd, map[string]*string{
+		fmt.Sprintf("%s.out", executeCaseName): &result.Stdout,
+		fmt.Sprintf("%s.err", executeCaseName): &result.Stderr,
+	}); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return result, http.StatusOK, nil
+}
+
+// validate runs the validator against the candidate output. The candidate
+// output is graded as an output-only run, so that the validator sees it
+// exactly as it was provided.
+func (h *ephemeralExecuteHandler) validate(
+	ctx *grader.Context,
+	validateRequest *grader.ValidateRequest,
+) (*grader.ValidateResult, int, error) {
+	limits := common.DefaultLiteralLimitSettings
+	if validateRequest.Limits != nil {
+		limits = *validateRequest.Limits
+	}
+	capEphemeralLimits(ctx, &limits)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	outputWriter, err := zw.Create(fmt.Sprintf("%s.out", executeCaseName))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if _, err := io.WriteString(outputWriter, validateRequest.Output); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	runInfo, status, err := h.runAndWait(
+		ctx,
+		&common.LiteralInput{
+			Cases: map[string]*common.LiteralCaseSettings{
+				executeCaseName: {
+					Input:          validateRequest.Input,
+					ExpectedOutput: validateRequest.ExpectedOutput,
+					Weight:         big.NewRat(1, 1),
+				},
+			},
+			Limits: &limits,
+			Validator: &common.LiteralValidatorSettings{
+				Name: common.ValidatorNameCustom,
+				CustomValidator: &common.LiteralCustomValidatorSettings{
+					Source:   validateRequest.Source,
+					Language: validateRequest.Language,
+					Limits:   &limits,
+				},
+			},
+		},
+		"cat",
+		"data:application/zip;base64,"+base64.StdEncoding.EncodeToString(archive.Bytes()),
+	)
+	if err != nil {
+		return nil, status, err
+	}
+	defer runInfo.Artifacts.Clean()
+
+	result := &grader.ValidateResult{
+		CompileError: runInfo.Result.CompileError,
+		CompileMeta:  runInfo.Result.CompileMeta,
+	}
+	caseResult := findExecuteCase(&runInfo.Result)
+	if result.CompileError != nil || caseResult == nil {
+		return result, http.StatusOK, nil
+	}
+	result.Verdict = caseResult.Verdict
+	result.Score = base.RationalToFloat(caseResult.Score)
+	if validatorMeta, ok := caseResult.IndividualMeta["validator"]; ok {
+		result.Meta = &validatorMeta
+	}
+
+	fd, err := runInfo.Artifacts.Get(&ctx.Context, "files.zip")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer fd.Close()
+	if err := readExecuteOutputs(fd, map[string]*string{
+		fmt.Sprintf("validator/%s.err", executeCaseName): &result.Stderr,
+	}); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return result, http.StatusOK, nil
+}
+
+func (h *ephemeralExecuteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := h.ctx.Wrap(r.Context())
+	if r.URL.Path != "/ephemeral/execute/new/" && r.URL.Path != "/ephemeral/validate/new/" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var result any
+	var status int
+	var err error
+	if r.URL.Path == "/ephemeral/validate/new/" {
+		var validateRequest grader.ValidateRequest
+		if err := json.NewDecoder(r.Body).Decode(&validateRequest); err != nil {
+			ctx.Log.Error(
+				"Error decoding validate request",
+				map[string]any{
+					"err": err,
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx.Metrics.CounterAdd("grader_ephemeral_validations_total", 1)
+		result, status, err = h.validate(ctx, &validateRequest)
+	} else {
+		var executeRequest grader.ExecuteRequest
+		if err := json.NewDecoder(r.Body).Decode(&executeRequest); err != nil {
+			ctx.Log.Error(
+				"Error decoding execute request",
+				map[string]any{
+					"err": err,
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx.Metrics.CounterAdd("grader_ephemeral_executions_total", 1)
+		result, status, err = h.execute(ctx, &executeRequest)
+	}
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to execute",
+			map[string]any{
+				"path": r.URL.Path,
+				"err":  err,
+			},
+		)
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		ctx.Log.Error(
+			"Error sending execute result",
+			map[string]any{
+				"err": err,
+			},
+		)
+	}
+}
@@ -12,14 +12,18 @@ import (
 	"io"
 	"io/ioutil"
 	"math/big"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -27,6 +31,7 @@ import (
 
 	base "github.com/omegaup/go-base/v3"
 	"github.com/omegaup/quark/broadcaster"
+	"github.com/omegaup/quark/common"
 	"github.com/omegaup/quark/grader"
 	"github.com/omegaup/quark/runner"
 )
@@ -37,11 +42,21 @@ const (
 
 var (
 	guidRegex = regexp.MustCompile("^[0-9a-f]{32}$")
+
+	errSourceTooLarge        = errors.New("source too large")
+	errInvalidSourceEncoding = errors.New("source is not valid UTF-8")
+	errMissingSource         = errors.New("missing source part")
 )
 
 type graderRunningStatus struct {
-	RunnerName string `json:"name"`
-	ID         int64  `json:"id"`
+	RunnerName  string `json:"name"`
+	ID          int64  `json:"id"`
+	CasesGraded int    `json:"cases_graded"`
+}
+
+type runProgressResponse struct {
+	CasesFinished int                   `json:"cases_finished"`
+	Cases         []runner.CaseProgress `json:"cases,omitempty"`
 }
 
 type graderStatusQueue struct {
@@ -56,12 +71,116 @@ type graderStatusResponse struct {
 	BoadcasterSockets int               `json:"broadcaster_sockets"`
 	EmbeddedRunner    bool              `json:"embedded_runner"`
 	RunningQueue      graderStatusQueue `json:"queue"`
+
+	// RunnerToolchains are the toolchain versions of each runner, and
+	// ToolchainSkew lists the runners that have each version of the
+	// toolchains that don't have the same version in all runners.
+	RunnerToolchains map[string]map[string]string   `json:"runner_toolchains"`
+	ToolchainSkew    map[string]map[string][]string `json:"toolchain_skew,omitempty"`
+
+	// RunnerClockOffsets is how many seconds ahead of the grader's clock the
+	// clock of each runner is.
+	RunnerClockOffsets map[string]float64 `json:"runner_clock_offsets"`
 }
 
 type runGradeRequest struct {
 	RunIDs  []int64 `json:"run_ids,omitempty"`
 	Rejudge bool    `json:"rejudge"`
 	Debug   bool    `json:"debug"`
+
+	// DryRun makes the grader only report what would happen to each one of
+	// the runs, without grading any of them.
+	DryRun bool `json:"dry_run"`
+
+	// Schedule makes the grader hold the rejudge of the runs until the next
+	// time that matches this crontab-like schedule, and then add them to the
+	// queue gradually, keeping it below MaxQueueLength runs.
+	Schedule       string `json:"schedule,omitempty"`
+	MaxQueueLength int    `json:"max_queue_length,omitempty"`
+}
+
+// maxRunStatusGUIDs is the maximum number of runs whose status can be
+// requested at once.
+const maxRunStatusGUIDs = 1000
+
+// Possible values of runStatusEntry.State.
+const (
+	// runStateQueued is used for runs that are waiting for a runner.
+	runStateQueued = "queued"
+	// runStateInflight is used for runs that a runner is grading.
+	runStateInflight = "in-flight"
+	// runStateFinished is used for runs whose results are in the database.
+	runStateFinished = "finished"
+	// runStatePending is used for runs that are not finished, but that this
+	// grader has not added to a queue (yet).
+	runStatePending = "pending"
+	// runStateNotFound is used for GUIDs that do not belong to any run.
+	runStateNotFound = "not_found"
+)
+
+type runStatusRequest struct {
+	GUIDs []string `json:"guids"`
+}
+
+// runStatusEntry is the current state of a run. The queue information is only
+// set for queued and in-flight runs, and the results only for finished runs.
+type runStatusEntry struct {
+	GUID  string `json:"guid"`
+	State string `json:"state"`
+
+	Queue        string               `json:"queue,omitempty"`
+	Priority     grader.QueuePriority `json:"priority,omitempty"`
+	AttemptsLeft int                  `json:"attempts_left,omitempty"`
+	QueueTime    *time.Time           `json:"queue_time,omitempty"`
+
+	// Frozen is set when the results of a finished run are hidden because
+	// the scoreboard of its contest is frozen.
+	Frozen bool `json:"frozen,omitempty"`
+
+	Verdict common.Verdict `json:"verdict,omitempty"`
+	Score   *float64       `json:"score,omitempty"`
+	Runtime *float64       `json:"runtime,omitempty"`
+	Memory  *base.Byte     `json:"memory,omitempty"`
+}
+
+type runStatusResponse struct {
+	Runs []runStatusEntry `json:"runs"`
+}
+
+// runGradeDryRunEntry is what would happen to a run if it were graded.
+type runGradeDryRunEntry struct {
+	RunID          int64  `json:"run_id"`
+	Problem        string `json:"problem,omitempty"`
+	Version        string `json:"version,omitempty"`
+	Language       string `json:"language,omitempty"`
+	Queue          string `json:"queue,omitempty"`
+	Priority       string `json:"priority,omitempty"`
+	SandboxProfile string `json:"sandbox_profile,omitempty"`
+	InputCached    bool   `json:"input_cached"`
+	Error          string `json:"error,omitempty"`
+}
+
+type runGradeDryRunResponse struct {
+	Status       string                `json:"status"`
+	DryRun       bool                  `json:"dry_run"`
+	WouldEnqueue int                   `json:"would_enqueue"`
+	Failed       int                   `json:"failed"`
+	Runs         []runGradeDryRunEntry `json:"runs"`
+}
+
+// queueImportEntry is what happened to a run of an imported queue snapshot.
+type queueImportEntry struct {
+	RunID   int64  `json:"run_id"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type queueImportResponse struct {
+	Status   string             `json:"status"`
+	Imported int                `json:"imported"`
+	Skipped  int                `json:"skipped"`
+	Failed   int                `json:"failed"`
+	Runs     []queueImportEntry `json:"runs"`
 }
 
 type runGradeResource struct {
@@ -126,12 +245,40 @@ func queryRowWithRetry(db *sql.DB, query string, args ...any) (row *sql.Row) {
 	return
 }
 
+// roundedScores returns the score and the contest score of the run, after
+// rounding the contest score according to the run's score rounding policy. The
+// score is adjusted so that it stays consistent with the contest score.
+func roundedScores(run *grader.RunInfo) (*big.Rat, *big.Rat) {
+	if run.ScoreRounding == nil || run.ScoreRounding.Mode == common.ScoreRoundingModeNone {
+		return run.Result.Score, run.Result.ContestScore
+	}
+	contestScore := run.ScoreRounding.Round(run.Result.ContestScore)
+	score := run.Result.Score
+	if run.Result.MaxScore != nil && run.Result.MaxScore.Sign() > 0 {
+		score = new(big.Rat).Quo(contestScore, run.Result.MaxScore)
+	}
+	return score, contestScore
+}
+
+// exactScore returns the numerator and denominator of the score, which are
+// written to the database when V1.ExactScores is set so that standings do not
+// need to go through a float64, which could make them disagree with the
+// grader. Both are nil if there is no score or if it does not fit in a
+// BIGINT.
+func exactScore(score *big.Rat) (any, any) {
+	if score == nil || !score.Num().IsInt64() || !score.Denom().IsInt64() {
+		return nil, nil
+	}
+	return score.Num().Int64(), score.Denom().Int64()
+}
+
 func updateDatabase(
 	ctx *grader.Context,
 	db *sql.DB,
 	status string,
 	run *grader.RunInfo,
 ) error {
+	score, contestScore := roundedScores(run)
 	return transactionWithRetry(ctx.Context.Context, db, nil, func(tx *sql.Tx) error {
 		if status != "ready" {
 			_, err := tx.Exec(
@@ -150,52 +297,56 @@ func updateDatabase(
 				return fmt.Errorf("update runs: %w", err)
 			}
 		} else {
-			if run.PenaltyType == "runtime" {
-				_, err := tx.Exec(
-					`
-					UPDATE
-						Runs
-					SET
-						status = ?, verdict = ?, runtime = ?, penalty = ?, memory = ?,
-						score = ?, contest_score = ?, judged_by = ?
-					WHERE
-						run_id = ?;
-					`,
-					status,
-					run.Result.Verdict,
-					run.Result.Time*1000,
-					run.Result.Time*1000,
-					run.Result.Memory.Bytes(),
-					base.RationalToFloat(run.Result.Score),
-					base.RationalToFloat(run.Result.ContestScore),
-					run.Result.JudgedBy,
-					run.ID,
-				)
-				if err != nil {
-					return fmt.Errorf("update runs: %w", err)
-				}
-			} else {
+			// Runs whose penalty cannot be computed keep the one the frontend
+			// assigned to them.
+			var penalty any
+			if p, ok := run.Penalty(); ok {
+				penalty = p
+			}
+			_, err := tx.Exec(
+				`
+				UPDATE
+					Runs
+				SET
+					status = ?, verdict = ?, runtime = ?, penalty = COALESCE(?, penalty),
+					memory = ?, score = ?, contest_score = ?, judged_by = ?
+				WHERE
+					run_id = ?;
+				`,
+				status,
+				run.Result.Verdict,
+				run.Result.Time*1000,
+				penalty,
+				run.Result.Memory.Bytes(),
+				base.RationalToFloat(score),
+				base.RationalToFloat(contestScore),
+				run.Result.JudgedBy,
+				run.ID,
+			)
+			if err != nil {
+				return fmt.Errorf("update runs: %w", err)
+			}
+			if ctx.Config.Grader.V1.ExactScores {
+				scoreNumerator, scoreDenominator := exactScore(score)
+				contestScoreNumerator, contestScoreDenominator := exactScore(contestScore)
 				_, err := tx.Exec(
 					`
 					UPDATE
 						Runs
 					SET
-						status = ?, verdict = ?, runtime = ?, memory = ?, score = ?,
-						contest_score = ?, judged_by = ?
+						score_numerator = ?, score_denominator = ?,
+						contest_score_numerator = ?, contest_score_denominator = ?
 					WHERE
 						run_id = ?;
 					`,
-					status,
-					run.Result.Verdict,
-					run.Result.Time*1000,
-					run.Result.Memory.Bytes(),
-					base.RationalToFloat(run.Result.Score),
-					base.RationalToFloat(run.Result.ContestScore),
-					run.Result.JudgedBy,
+					scoreNumerator,
+					scoreDenominator,
+					contestScoreNumerator,
+					contestScoreDenominator,
 					run.ID,
 				)
 				if err != nil {
-					return fmt.Errorf("update runs: %w", err)
+					return fmt.Errorf("update exact scores: %w", err)
 				}
 			}
 			for _, g := range run.Result.Groups {
@@ -236,6 +387,49 @@ func updateDatabase(
 	})
 }
 
+// updateDatabaseGroup stores the result of a single group of a run that is
+// still being graded, so that progress can be shown for long-running runs.
+func updateDatabaseGroup(
+	ctx *grader.Context,
+	db *sql.DB,
+	run *grader.RunInfo,
+	group *runner.GroupResult,
+) error {
+	return transactionWithRetry(ctx.Context.Context, db, nil, func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`
+			REPLACE INTO
+				Runs_Groups(run_id, group_name, score, verdict)
+			VALUES
+				(?, ?, ?, ?);
+			`,
+			run.ID,
+			group.Group,
+			base.RationalToFloat(group.Score),
+			group.Verdict(),
+		)
+		if err != nil {
+			return fmt.Errorf("replace into groups (%d, %s): %w", run.ID, group.Group, err)
+		}
+		_, err = tx.Exec(
+			`
+			UPDATE
+				Runs
+			SET
+				status = 'running'
+			WHERE
+				run_id = ? AND
+				status != 'ready';
+			`,
+			run.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("update runs: %w", err)
+		}
+		return nil
+	})
+}
+
 func broadcastRun(
 	ctx *grader.Context,
 	db *sql.DB,
@@ -254,28 +448,30 @@ func broadcastRun(
 		message.Contest = *run.Contest
 	}
 	type serializedRun struct {
-		User         string    `json:"username"`
-		Contest      *string   `json:"contest_alias,omitempty"`
-		Problemset   *int64    `json:"problemset,omitempty"`
-		Problem      string    `json:"alias"`
-		GUID         string    `json:"guid"`
-		Runtime      float64   `json:"runtime"`
-		Penalty      float64   `json:"penalty"`
-		Memory       base.Byte `json:"memory"`
-		Score        float64   `json:"score"`
-		ContestScore float64   `json:"contest_score"`
-		Status       string    `json:"status"`
-		Verdict      string    `json:"verdict"`
-		SubmitDelay  float64   `json:"submit_delay"`
-		Time         float64   `json:"time"`
-		Language     string    `json:"language"`
+		User          string               `json:"username"`
+		Contest       *string              `json:"contest_alias,omitempty"`
+		Problemset    *int64               `json:"problemset,omitempty"`
+		Problem       string               `json:"alias"`
+		GUID          string               `json:"guid"`
+		Runtime       float64              `json:"runtime"`
+		Penalty       float64              `json:"penalty"`
+		Memory        base.Byte            `json:"memory"`
+		Score         float64              `json:"score"`
+		ContestScore  float64              `json:"contest_score"`
+		Status        string               `json:"status"`
+		Verdict       common.Verdict       `json:"verdict"`
+		VerdictDetail common.VerdictDetail `json:"verdict_detail"`
+		SubmitDelay   float64              `json:"submit_delay"`
+		Time          float64              `json:"time"`
+		Language      string               `json:"language"`
 	}
 	type runFinishedMessage struct {
 		Message string        `json:"message"`
 		Run     serializedRun `json:"run"`
 	}
-	score := base.RationalToFloat(run.Result.Score)
-	contestScore := base.RationalToFloat(run.Result.ContestScore)
+	roundedScore, roundedContestScore := roundedScores(run)
+	score := base.RationalToFloat(roundedScore)
+	contestScore := base.RationalToFloat(roundedContestScore)
 	if run.ScoreMode == "all_or_nothing" && score != 1 {
 		score = 0
 		contestScore = 0
@@ -283,39 +479,52 @@ func broadcastRun(
 	msg := runFinishedMessage{
 		Message: "/run/update/",
 		Run: serializedRun{
-			Contest:      run.Contest,
-			Problemset:   run.Problemset,
-			Problem:      run.Run.ProblemName,
-			GUID:         run.GUID,
-			Runtime:      run.Result.Time,
-			Memory:       run.Result.Memory,
-			Score:        score,
-			ContestScore: contestScore,
-			Status:       "ready",
-			Verdict:      run.Result.Verdict,
-			Language:     run.Run.Language,
-			Time:         -1,
-			SubmitDelay:  -1,
-			Penalty:      -1,
+			Contest:       run.Contest,
+			Problemset:    run.Problemset,
+			Problem:       run.Run.ProblemName,
+			GUID:          run.GUID,
+			Runtime:       run.Result.Time,
+			Memory:        run.Result.Memory,
+			Score:         score,
+			ContestScore:  contestScore,
+			Status:        "ready",
+			Verdict:       run.Result.Verdict,
+			VerdictDetail: run.Result.Verdict.Detail(),
+			Language:      run.Run.Language,
+			Time:          -1,
+			SubmitDelay:   -1,
+			Penalty:       -1,
 		},
 	}
 	var runTime time.Time
+	var submissionTime time.Time
+	var contestStartTime, contestFinishTime sql.NullTime
+	var contestScoreboard sql.NullInt64
+	var contestShowScoreboardAfter sql.NullBool
 	err := queryRowWithRetry(
 		db,
 		`SELECT
-			i.username, r.penalty, s.submit_delay, r.time
+			i.username, r.penalty, s.submit_delay, r.time, s.time, c.start_time,
+			c.finish_time, c.scoreboard, c.show_scoreboard_after
 		FROM
 			Runs r
 		INNER JOIN
 			Submissions s ON s.submission_id = r.submission_id
 		INNER JOIN
 			Identities i ON i.identity_id = s.identity_id
+		LEFT JOIN
+			Contests c ON c.problemset_id = s.problemset_id
 		WHERE
 			r.run_id = ?;`, run.ID).Scan(
 		&msg.Run.User,
 		&msg.Run.Penalty,
 		&msg.Run.SubmitDelay,
 		&runTime,
+		&submissionTime,
+		&contestStartTime,
+		&contestFinishTime,
+		&contestScoreboard,
+		&contestShowScoreboardAfter,
 	)
 	if err != nil {
 		return err
@@ -333,6 +542,64 @@ func broadcastRun(
 
 	message.Message = string(marshaled)
 
+	if contestStartTime.Valid && contestFinishTime.Valid && scoreboardFrozen(
+		submissionTime,
+		contestStartTime.Time,
+		contestFinishTime.Time,
+		contestScoreboard.Int64,
+		contestShowScoreboardAfter.Bool,
+		time.Now(),
+	) {
+		// Only the administrators of the contest get to see the outcome of the
+		// run while the scoreboard is frozen.
+		type frozenRun struct {
+			User        string  `json:"username"`
+			Contest     *string `json:"contest_alias,omitempty"`
+			Problemset  *int64  `json:"problemset,omitempty"`
+			Problem     string  `json:"alias"`
+			GUID        string  `json:"guid"`
+			Status      string  `json:"status"`
+			SubmitDelay float64 `json:"submit_delay"`
+			Time        float64 `json:"time"`
+			Language    string  `json:"language"`
+		}
+		frozenMarshaled, err := json.Marshal(&struct {
+			Message string    `json:"message"`
+			Run     frozenRun `json:"run"`
+		}{
+			Message: msg.Message,
+			Run: frozenRun{
+				User:        msg.Run.User,
+				Contest:     msg.Run.Contest,
+				Problemset:  msg.Run.Problemset,
+				Problem:     msg.Run.Problem,
+				GUID:        msg.Run.GUID,
+				Status:      "frozen",
+				SubmitDelay: msg.Run.SubmitDelay,
+				Time:        msg.Run.Time,
+				Language:    msg.Run.Language,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		message.FrozenMessage = string(frozenMarshaled)
+	}
+
+	if message.Contest != "" && broadcasts.Len() > 0 {
+		// Sending this message now could deliver it before an older message
+		// about the same run that is still in the outbox, so it waits for its
+		// turn there.
+		if err := broadcasts.Put(ctx, &message); err != nil {
+			ctx.Log.Error(
+				"Error storing run broadcast in the outbox",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+		return nil
+	}
 	if err := broadcast(ctx, client, &message); err != nil {
 		ctx.Log.Error(
 			"Error sending run broadcast",
@@ -340,10 +607,154 @@ func broadcastRun(
 				"err": err,
 			},
 		)
+		if message.Contest == "" {
+			// Only the scoreboards of contests need to know about every run.
+			return nil
+		}
+		if err := broadcasts.Put(ctx, &message); err != nil {
+			ctx.Log.Error(
+				"Error storing run broadcast in the outbox",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
 	}
 	return nil
 }
 
+// scoreboardFrozen returns whether the outcome of a submission made at
+// submissionTime must be hidden from the contestants because the scoreboard of
+// the contest is frozen. The scoreboard of a contest is only visible during
+// the first scoreboardPercent% of the contest, and stays frozen after the
+// contest finishes unless showScoreboardAfter is set.
+func scoreboardFrozen(
+	submissionTime time.Time,
+	startTime time.Time,
+	finishTime time.Time,
+	scoreboardPercent int64,
+	showScoreboardAfter bool,
+	now time.Time,
+) bool {
+	if scoreboardPercent >= 100 {
+		return false
+	}
+	freezeTime := startTime.Add(
+		time.Duration(float64(finishTime.Sub(startTime)) * float64(scoreboardPercent) / 100),
+	)
+	if submissionTime.Before(freezeTime) {
+		return false
+	}
+	if now.Before(finishTime) {
+		return true
+	}
+	return !showScoreboardAfter
+}
+
+// runVisibility is how much of the results of a run can be shown to the
+// contestant that submitted it.
+type runVisibility struct {
+	feedback common.FeedbackLevel
+	frozen   bool
+}
+
+// newRunVisibility returns the visibility of a run submitted at
+// submissionTime. The contest columns are NULL for runs outside of a
+// contest, which can always be seen in full.
+func newRunVisibility(
+	submissionTime time.Time,
+	contestStartTime sql.NullTime,
+	contestFinishTime sql.NullTime,
+	contestScoreboard sql.NullInt64,
+	contestShowScoreboardAfter sql.NullBool,
+	contestFeedback sql.NullString,
+	now time.Time,
+) runVisibility {
+	var visibility runVisibility
+	if contestFeedback.Valid {
+		visibility.feedback = contestFeedbackLevel(contestFeedback.String)
+	}
+	if contestStartTime.Valid && contestFinishTime.Valid {
+		visibility.frozen = scoreboardFrozen(
+			submissionTime,
+			contestStartTime.Time,
+			contestFinishTime.Time,
+			contestScoreboard.Int64,
+			contestShowScoreboardAfter.Bool,
+			now,
+		)
+	}
+	return visibility
+}
+
+// queryRunVisibility returns the visibility of the run with the provided ID.
+func queryRunVisibility(db *sql.DB, runID int64) (runVisibility, error) {
+	var submissionTime time.Time
+	var contestStartTime, contestFinishTime sql.NullTime
+	var contestScoreboard sql.NullInt64
+	var contestShowScoreboardAfter sql.NullBool
+	var contestFeedback sql.NullString
+	err := queryRowWithRetry(
+		db,
+		`SELECT
+			s.time, c.start_time, c.finish_time, c.scoreboard,
+			c.show_scoreboard_after, c.feedback
+		FROM
+			Runs r
+		INNER JOIN
+			Submissions s ON s.submission_id = r.submission_id
+		LEFT JOIN
+			Contests c ON c.problemset_id = s.problemset_id
+		WHERE
+			r.run_id = ?;`, runID).Scan(
+		&submissionTime,
+		&contestStartTime,
+		&contestFinishTime,
+		&contestScoreboard,
+		&contestShowScoreboardAfter,
+		&contestFeedback,
+	)
+	if err != nil {
+		return runVisibility{}, err
+	}
+	return newRunVisibility(
+		submissionTime,
+		contestStartTime,
+		contestFinishTime,
+		contestScoreboard,
+		contestShowScoreboardAfter,
+		contestFeedback,
+		time.Now(),
+	), nil
+}
+
+// runProgress returns the progress of a run that can be shown to the
+// contestant. The results of the cases are only included if the feedback
+// level allows to show them and the scoreboard is not frozen, and only the
+// public cases keep all of their information unless the feedback level is
+// full-diff. Otherwise only the number of cases that finished is included.
+func runProgress(cases []runner.CaseProgress, visibility runVisibility) *runProgressResponse {
+	response := &runProgressResponse{
+		CasesFinished: len(cases),
+	}
+	if visibility.frozen {
+		return response
+	}
+	switch visibility.feedback {
+	case common.FeedbackLevelFullDiff:
+		response.Cases = cases
+	case common.FeedbackLevelPerCase, common.FeedbackLevelDefault:
+		response.Cases = make([]runner.CaseProgress, len(cases))
+		for i := range cases {
+			response.Cases[i] = runner.CaseProgress{
+				Group: cases[i].Group,
+				Case:  cases[i].Case.Redacted(),
+			}
+		}
+	}
+	return response
+}
+
 func runPostProcessor(
 	ctx *grader.Context,
 	db *sql.DB,
@@ -351,10 +762,29 @@ func runPostProcessor(
 	client *http.Client,
 ) {
 	for run := range finishedRuns {
-		if run.Result.Verdict == "JE" {
+		if run.Result.Verdict == common.VerdictValidatorError {
+			// This is most likely a bug in the problem's validator.
+			ctx.Metrics.CounterAdd("grader_runs_ve", 1)
+			ctx.Log.Error(
+				"Run has a validator error",
+				map[string]any{
+					"problem": run.Run.ProblemName,
+					"run":     run,
+				},
+			)
+		}
+		if run.Result.Verdict == common.VerdictJudgeError {
 			ctx.Metrics.CounterAdd("grader_runs_je", 1)
+		} else if gradingTime := run.Summary.Total(); run.Result.Verdict != common.VerdictCompileError && gradingTime > 0 {
+			// The wall time of the result only adds up the time of the cases,
+			// so the timings that the runner reported are used instead.
+			ctx.SlowProblemDetector.Observe(
+				run.Run.ProblemName,
+				time.Duration(gradingTime*float64(time.Second)),
+			)
 		}
-		if ctx.Config.Grader.V1.UpdateDatabase {
+		if ctx.Config.Grader.V1.UpdateDatabase && run.ID != 0 {
+			dbWriteStart := time.Now()
 			if err := updateDatabase(ctx, db, "ready", run); err != nil {
 				ctx.Log.Error(
 					"Error updating the database",
@@ -363,7 +793,13 @@ func runPostProcessor(
 						"run": run,
 					},
 				)
+			} else {
+				ctx.QueueManager.Timelines.Record(run.GUID, grader.RunTimelineEvent{
+					Type:      grader.RunTimelineDBUpdated,
+					AttemptID: run.Run.AttemptID,
+				})
 			}
+			run.Summary.DBWrite = time.Since(dbWriteStart).Seconds()
 		}
 		if ctx.Config.Grader.V1.SendBroadcast {
 			if err := broadcastRun(ctx, db, client, run); err != nil {
@@ -373,11 +809,59 @@ func runPostProcessor(
 						"err": err,
 					},
 				)
+			} else {
+				ctx.QueueManager.Timelines.Record(run.GUID, grader.RunTimelineEvent{
+					Type:      grader.RunTimelineBroadcast,
+					AttemptID: run.Run.AttemptID,
+				})
+			}
+		}
+		if ctx.Config.Grader.Scoreboard {
+			if err := updateScoreboard(ctx, db, client, run); err != nil {
+				ctx.Log.Error(
+					"Error updating the scoreboard",
+					map[string]any{
+						"err": err,
+						"run": run.ID,
+					},
+				)
 			}
 		}
+		reportRunSummary(ctx, run)
+		problemStats.Observe(run)
 	}
 }
 
+// reportRunSummary emits the summary of where the time of a finished run was
+// spent as a single log event, and records each phase in the metrics.
+func reportRunSummary(ctx *grader.Context, run *grader.RunInfo) {
+	summary := run.Summary
+	ctx.Log.Info(
+		"Run summary",
+		map[string]any{
+			"id":         run.ID,
+			"attempt_id": run.Run.AttemptID,
+			"problem":    run.Run.ProblemName,
+			"runner":     run.Result.JudgedBy,
+			"verdict":    run.Result.Verdict,
+			"queue_wait": summary.QueueWait,
+			"download":   summary.Download,
+			"compile":    summary.Compile,
+			"run":        summary.Run,
+			"validate":   summary.Validate,
+			"upload":     summary.Upload,
+			"db_write":   summary.DBWrite,
+		},
+	)
+	ctx.Metrics.SummaryObserve("grader_run_queue_wait_seconds", summary.QueueWait)
+	ctx.Metrics.SummaryObserve("grader_run_download_seconds", summary.Download)
+	ctx.Metrics.SummaryObserve("grader_run_compile_seconds", summary.Compile)
+	ctx.Metrics.SummaryObserve("grader_run_run_seconds", summary.Run)
+	ctx.Metrics.SummaryObserve("grader_run_validate_seconds", summary.Validate)
+	ctx.Metrics.SummaryObserve("grader_run_upload_seconds", summary.Upload)
+	ctx.Metrics.SummaryObserve("grader_run_db_write_seconds", summary.DBWrite)
+}
+
 // dbRun represents a run in the database.
 type dbRun struct {
 	runID        int64
@@ -439,8 +923,28 @@ func runQueueLoop(
 		},
 	)
 
-	for range newRuns {
-		ctx.Log.Debug("New run in the queue", nil)
+	var reconcileTick <-chan time.Time
+	if interval := ctx.Config.Grader.V1.ReconcileInterval; interval > 0 && ctx.Config.Grader.V1.UpdateDatabase {
+		ticker := time.NewTicker(time.Duration(interval))
+		defer ticker.Stop()
+		reconcileTick = ticker.C
+	}
+	suspects := make(map[int64]struct{})
+
+	for {
+		select {
+		case _, ok := <-newRuns:
+			if !ok {
+				return
+			}
+			ctx.Log.Debug("New run in the queue", nil)
+		case <-reconcileTick:
+			var reconciled int
+			suspects, reconciled = reconcilePendingRuns(ctx, db, suspects)
+			if reconciled == 0 {
+				continue
+			}
+		}
 		totalRunsInRound := 0
 		// Every time a new notification arrives, continuously get all the new runs
 		// from the database until there's nothing new.
@@ -531,7 +1035,7 @@ func runQueueLoop(
 					&grader.RunInfo{
 						ID:           dbRun.runID,
 						SubmissionID: dbRun.submissionID,
-						Result:       *runner.NewRunResult("JE", &big.Rat{}),
+						Result:       *runner.NewRunResult(common.VerdictJudgeError, &big.Rat{}),
 					},
 				)
 				if err != nil {
@@ -560,7 +1064,7 @@ func runQueueLoop(
 						&grader.RunInfo{
 							ID:           dbRun.runID,
 							SubmissionID: dbRun.submissionID,
-							Result:       *runner.NewRunResult("JE", &big.Rat{}),
+							Result:       *runner.NewRunResult(common.VerdictJudgeError, &big.Rat{}),
 						},
 					)
 					if err != nil {
@@ -619,12 +1123,127 @@ func runQueueLoop(
 	}
 }
 
-// gradeDir gets the new-style Run ID-based path.
-func gradeDir(ctx *grader.Context, runID int64) string {
-	return path.Join(
-		ctx.Config.Grader.V1.RuntimeGradePath,
-		fmt.Sprintf("%02d/%02d/%d", runID%100, (runID%10000)/100, runID),
+// reconcilePendingRuns marks as new the runs that the database considers to
+// be waiting, compiling or running, but that the grader is not handling (e.g.
+// because they were lost in a crash), so that they are injected again. Runs
+// in any other status (like the ones whose results are being uploaded) are
+// left alone, and nothing is done if the grader does not update the database.
+// Since runs are marked as pending slightly before being added to a queue, and
+// marked as ready slightly after being closed, a run is only reconciled if it
+// was also a suspect in the previous call. It returns the suspects for the
+// next call and the number of runs that were reconciled.
+func reconcilePendingRuns(
+	ctx *grader.Context,
+	db *sql.DB,
+	previousSuspects map[int64]struct{},
+) (map[int64]struct{}, int) {
+	suspects := make(map[int64]struct{})
+	if !ctx.Config.Grader.V1.UpdateDatabase {
+		return suspects, 0
+	}
+	rows, err := queryWithRetry(
+		db,
+		`
+		SELECT
+			run_id,
+			status
+		FROM
+			Runs
+		WHERE
+			status IN ('waiting', 'compiling', 'running');
+		`,
 	)
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to get pending runs",
+			map[string]any{
+				"err": err,
+			},
+		)
+		return previousSuspects, 0
+	}
+	stuckRuns := make(map[int64]string)
+	for rows.Next() {
+		var runID int64
+		var status string
+		if err := rows.Scan(&runID, &status); err != nil {
+			ctx.Log.Error(
+				"Failed to get pending run",
+				map[string]any{
+					"err": err,
+				},
+			)
+			continue
+		}
+		if ctx.QueueManager.IsActive(runID) {
+			continue
+		}
+		if _, ok := previousSuspects[runID]; !ok {
+			suspects[runID] = struct{}{}
+			continue
+		}
+		stuckRuns[runID] = status
+	}
+	rows.Close()
+
+	reconciled := 0
+	for runID, status := range stuckRuns {
+		// Only update the run if it was not updated in the meantime.
+		result, err := execWithRetry(
+			db,
+			`
+			UPDATE
+				Runs
+			SET
+				status = 'new'
+			WHERE
+				run_id = ? AND status = ?;
+			`,
+			runID,
+			status,
+		)
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to reconcile pending run",
+				map[string]any{
+					"run": runID,
+					"err": err,
+				},
+			)
+			continue
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			continue
+		}
+		ctx.Log.Warn(
+			"Reconciled pending run",
+			map[string]any{
+				"run":    runID,
+				"status": status,
+			},
+		)
+		reconciled++
+	}
+	if reconciled > 0 {
+		ctx.Metrics.CounterAdd("grader_runs_reconciled", float64(reconciled))
+	}
+	return suspects, reconciled
+}
+
+// contestFeedbackLevel returns the FeedbackLevel of the feedback setting of a
+// contest. The frontend's none, summary and detailed settings are mapped to
+// the closest feedback level, and the feedback levels themselves are also
+// accepted. Anything else gets the default feedback level.
+func contestFeedbackLevel(feedback string) common.FeedbackLevel {
+	switch feedback {
+	case "detailed":
+		return common.FeedbackLevelPerCase
+	}
+	level := common.FeedbackLevel(feedback)
+	if !level.Valid() {
+		return common.FeedbackLevelDefault
+	}
+	return level
 }
 
 func newRunInfoFromID(
@@ -640,11 +1259,15 @@ func newRunInfoFromID(
 	var penaltyType sql.NullString
 	var contestPoints sql.NullFloat64
 	var scoreMode sql.NullString
+	var feedback sql.NullString
+	var submissionTime time.Time
+	var contestFinishTime sql.NullTime
 	err := queryRowWithRetry(
 		db,
 		`SELECT
 			s.guid, c.alias, s.problemset_id, c.penalty_type, c.score_mode,
-			s.language, p.alias, pp.points, r.version, r.submission_id
+			c.feedback, s.language, p.alias, pp.points, r.version,
+			r.submission_id, s.time, c.finish_time, s.submit_delay
 		FROM
 			Runs r
 		INNER JOIN
@@ -663,16 +1286,22 @@ func newRunInfoFromID(
 		&problemset,
 		&penaltyType,
 		&scoreMode,
+		&feedback,
 		&runInfo.Run.Language,
 		&runInfo.Run.ProblemName,
 		&contestPoints,
 		&runInfo.Run.InputHash,
 		&runInfo.SubmissionID,
+		&submissionTime,
+		&contestFinishTime,
+		&runInfo.SubmitDelay,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	runInfo.Run.GUID = runInfo.GUID
+
 	if contestName.Valid {
 		runInfo.Contest = &contestName.String
 	}
@@ -687,6 +1316,9 @@ func newRunInfoFromID(
 	} else {
 		runInfo.ScoreMode = "partial"
 	}
+	if feedback.Valid {
+		runInfo.FeedbackLevel = contestFeedbackLevel(feedback.String)
+	}
 	if contestPoints.Valid {
 		runInfo.Run.MaxScore = base.FloatToRational(contestPoints.Float64)
 	} else {
@@ -696,7 +1328,22 @@ func newRunInfoFromID(
 	runInfo.Result.MaxScore = runInfo.Run.MaxScore
 	runInfo.Artifacts = artifacts.Grader(&ctx.Context, runInfo.ID)
 
+	runInfo.ScoreRounding, err = grader.ProblemScoreRounding(
+		&ctx.Context,
+		ctx.Config.Grader.GitserverURL,
+		ctx.Config.Grader.GitserverAuthorization,
+		runInfo.Run.ProblemName,
+		runInfo.Run.InputHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if runInfo.ScoreRounding == nil {
+		runInfo.ScoreRounding = &ctx.Config.Grader.ScoreRounding
+	}
+
 	slow, err := grader.IsProblemSlow(
+		&ctx.Context,
 		ctx.Config.Grader.GitserverURL,
 		ctx.Config.Grader.GitserverAuthorization,
 		runInfo.Run.ProblemName,
@@ -705,7 +1352,16 @@ func newRunInfoFromID(
 	if err != nil {
 		return nil, err
 	}
-	if slow {
+	slow = ctx.SlowProblemDetector.IsSlow(runInfo.Run.ProblemName, slow)
+	runInfo.Slow = slow
+	if contestFinishTime.Valid && submittedNearContestEnd(
+		submissionTime,
+		contestFinishTime.Time,
+		time.Now(),
+		time.Duration(ctx.Config.Grader.V1.ContestEndPriorityWindow),
+	) {
+		runInfo.Priority = grader.QueuePriorityHigh
+	} else if slow {
 		runInfo.Priority = grader.QueuePriorityLow
 	} else {
 		runInfo.Priority = grader.QueuePriorityNormal
@@ -714,6 +1370,26 @@ func newRunInfoFromID(
 	return runInfo, nil
 }
 
+// submittedNearContestEnd returns whether a submission was made in the last
+// window of a contest that finishes at finishTime. Contestants are waiting
+// for the results of those submissions to decide what to do in the little
+// time they have left. Once the contest has been over for a while, there is
+// no longer any rush, so rejudges of those submissions are not affected.
+func submittedNearContestEnd(
+	submissionTime time.Time,
+	finishTime time.Time,
+	now time.Time,
+	window time.Duration,
+) bool {
+	if window <= 0 {
+		return false
+	}
+	if submissionTime.Before(finishTime.Add(-window)) || submissionTime.After(finishTime) {
+		return false
+	}
+	return now.Before(finishTime.Add(window))
+}
+
 func injectRun(
 	ctx *grader.Context,
 	artifacts *grader.ArtifactManager,
@@ -734,6 +1410,19 @@ func injectRun(
 		return err
 	}
 	runInfo.Run.Source = source
+	if err := validateRunSubmission(ctx, runInfo); err != nil {
+		if submissionRejectionReason(err) != "" {
+			rejectRun(ctx, runInfo, err)
+			return nil
+		}
+		ctx.Log.Warn(
+			"Failed to validate the submission",
+			map[string]any{
+				"err":   err,
+				"runId": runInfo.ID,
+			},
+		)
+	}
 	if runInfo.Priority == grader.QueuePriorityNormal {
 		runInfo.Priority = priority
 	}
@@ -774,18 +1463,344 @@ func injectRun(
 	return nil
 }
 
-func broadcast(
-	ctx *grader.Context,
-	client *http.Client,
-	message *broadcaster.Message,
-) error {
-	marshaled, err := json.Marshal(message)
-	if err != nil {
-		return err
+// validateRunSubmission returns an error if the problem does not accept the
+// submission of the run, which must already have its source.
+func validateRunSubmission(ctx *grader.Context, runInfo *grader.RunInfo) error {
+	return grader.ValidateSubmission(
+		&ctx.Context,
+		ctx.Config.Grader.GitserverURL,
+		ctx.Config.Grader.GitserverAuthorization,
+		runInfo.Run.ProblemName,
+		runInfo.Run.InputHash,
+		runInfo.Run.Language,
+		base.Byte(len(runInfo.Run.Source)),
+	)
+}
+
+// submissionRejectionReason returns the reason why a submission was rejected
+// by validateRunSubmission, or an empty string if the error was not caused by
+// the submission.
+func submissionRejectionReason(err error) string {
+	switch {
+	case errors.Is(err, common.ErrLanguageNotAllowed):
+		return "language_not_allowed"
+	case errors.Is(err, common.ErrSourceTooLarge):
+		return "source_too_large"
+	default:
+		return ""
 	}
+}
 
-	resp, err := client.Post(
-		ctx.Config.Grader.BroadcasterURL,
+// rejectRun finishes a run whose submission the problem does not accept
+// without sending it to a runner. It gets a CE with the reason as the compile
+// error, and is then post-processed like any other run.
+func rejectRun(ctx *grader.Context, runInfo *grader.RunInfo, reason error) {
+	ctx.Log.Info(
+		"Rejected run",
+		map[string]any{
+			"id":       runInfo.ID,
+			"problem":  runInfo.Run.ProblemName,
+			"language": runInfo.Run.Language,
+			"reason":   reason,
+		},
+	)
+	ctx.Metrics.CounterAdd("grader_runs_rejected", 1)
+	compileError := reason.Error()
+	runInfo.Result = *runner.NewRunResult(common.VerdictCompileError, runInfo.Run.MaxScore)
+	runInfo.Result.CompileError = &compileError
+
+	for _, file := range []struct {
+		name   string
+		result *runner.RunResult
+	}{
+		{"details.json", &runInfo.Result},
+		{"details.redacted.json", runInfo.Result.WithFeedback(runInfo.FeedbackLevel)},
+	} {
+		contents, err := json.MarshalIndent(file.result, "", "  ")
+		if err == nil {
+			err = runInfo.Artifacts.Put(&ctx.Context, file.name, bytes.NewReader(contents))
+		}
+		if err != nil {
+			ctx.Log.Error(
+				"Unable to write the results of a rejected run",
+				map[string]any{
+					"id":   runInfo.ID,
+					"file": file.name,
+					"err":  err,
+				},
+			)
+		}
+	}
+	ctx.QueueManager.PostProcessor.PostProcess(runInfo)
+}
+
+// runStatuses returns the current state of the runs with the provided GUIDs,
+// in the same order. The state of the runs that this grader is handling comes
+// from its queues, and the rest are looked up in the database with a single
+// query. The results of the finished runs are filtered the same way as the
+// results that are shown to contestants: nothing is shown while the
+// scoreboard is frozen, and only the verdict with the none feedback level.
+func runStatuses(db *sql.DB, queueManager *grader.QueueManager, guids []string) ([]runStatusEntry, error) {
+	entries := make([]runStatusEntry, len(guids))
+	var missing []any
+	for i, guid := range guids {
+		entries[i].GUID = guid
+		entry, ok := queueManager.ActiveRun(guid)
+		if !ok {
+			missing = append(missing, guid)
+			continue
+		}
+		entries[i].State = runStateQueued
+		if entry.Running {
+			entries[i].State = runStateInflight
+		}
+		entries[i].Queue = entry.Queue
+		entries[i].Priority = entry.Priority
+		entries[i].AttemptsLeft = entry.AttemptsLeft
+		if !entry.QueueTime.IsZero() {
+			queueTime := entry.QueueTime
+			entries[i].QueueTime = &queueTime
+		}
+	}
+
+	type dbStatus struct {
+		status     string
+		verdict    common.Verdict
+		score      float64
+		runtime    float64
+		memory     base.Byte
+		visibility runVisibility
+	}
+	statuses := make(map[string]dbStatus)
+	if len(missing) > 0 {
+		rows, err := queryWithRetry(
+			db,
+			`
+			SELECT
+				s.guid, r.status, r.verdict, r.score, r.runtime, r.memory,
+				s.time, c.start_time, c.finish_time, c.scoreboard,
+				c.show_scoreboard_after, c.feedback
+			FROM
+				Submissions s
+			INNER JOIN
+				Runs r ON r.run_id = s.current_run_id
+			LEFT JOIN
+				Contests c ON c.problemset_id = s.problemset_id
+			WHERE
+				s.guid IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(missing)), ", ")+`);
+			`,
+			missing...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for rows.Next() {
+			var guid string
+			var status dbStatus
+			var runtime int64
+			var submissionTime time.Time
+			var contestStartTime, contestFinishTime sql.NullTime
+			var contestScoreboard sql.NullInt64
+			var contestShowScoreboardAfter sql.NullBool
+			var contestFeedback sql.NullString
+			if err := rows.Scan(
+				&guid,
+				&status.status,
+				&status.verdict,
+				&status.score,
+				&runtime,
+				&status.memory,
+				&submissionTime,
+				&contestStartTime,
+				&contestFinishTime,
+				&contestScoreboard,
+				&contestShowScoreboardAfter,
+				&contestFeedback,
+			); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			status.runtime = float64(runtime) / 1000
+			status.visibility = newRunVisibility(
+				submissionTime,
+				contestStartTime,
+				contestFinishTime,
+				contestScoreboard,
+				contestShowScoreboardAfter,
+				contestFeedback,
+				now,
+			)
+			statuses[guid] = status
+		}
+		rows.Close()
+	}
+
+	for i := range entries {
+		if entries[i].State != "" {
+			continue
+		}
+		status, ok := statuses[entries[i].GUID]
+		if !ok {
+			entries[i].State = runStateNotFound
+			continue
+		}
+		if status.status != "ready" {
+			entries[i].State = runStatePending
+			continue
+		}
+		entries[i].State = runStateFinished
+		if status.visibility.frozen {
+			entries[i].Frozen = true
+			continue
+		}
+		entries[i].Verdict = status.verdict
+		if status.visibility.feedback == common.FeedbackLevelNone {
+			continue
+		}
+		entries[i].Score = &status.score
+		entries[i].Runtime = &status.runtime
+		entries[i].Memory = &status.memory
+	}
+	return entries, nil
+}
+
+// dryRunGrade resolves the runs the same way runQueueLoop does before adding
+// them to the queue and reports the outcome, without changing their status in
+// the database nor fetching anything that is missing.
+func dryRunGrade(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	request *runGradeRequest,
+) *runGradeDryRunResponse {
+	response := &runGradeDryRunResponse{
+		Status: "ok",
+		DryRun: true,
+		Runs:   make([]runGradeDryRunEntry, 0, len(request.RunIDs)),
+	}
+	for _, runID := range request.RunIDs {
+		entry := runGradeDryRunEntry{
+			RunID: runID,
+		}
+		runInfo, err := newRunInfoFromID(ctx, db, runID, artifacts)
+		if err != nil {
+			entry.Error = err.Error()
+			response.Failed++
+			response.Runs = append(response.Runs, entry)
+			continue
+		}
+		// Rejudged runs belong to submissions that runQueueLoop has already
+		// seen, so they get low priority unless something else was decided.
+		if request.Rejudge && runInfo.Priority == grader.QueuePriorityNormal {
+			runInfo.Priority = grader.QueuePriorityLow
+		}
+		entry.Problem = runInfo.Run.ProblemName
+		entry.Version = runInfo.Run.InputHash
+		entry.Language = runInfo.Run.Language
+		entry.Queue = grader.DefaultQueueName
+		entry.Priority = runInfo.Priority.Name()
+		entry.SandboxProfile = ctx.Config.Grader.QueueSandboxProfiles[entry.Queue]
+		entry.InputCached = grader.InputCached(&ctx.Config, runInfo.Run.InputHash)
+		response.WouldEnqueue++
+		response.Runs = append(response.Runs, entry)
+	}
+	return response
+}
+
+// importQueueSnapshot adds the runs of a snapshot taken from the queues of
+// another grader to the queues of this one. The runs keep the queue, priority
+// and attempts left that they had there, and everything else is read from the
+// database as usual. Runs that are already being handled by this grader are
+// skipped.
+func importQueueSnapshot(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	snapshot *grader.QueueSnapshot,
+) *queueImportResponse {
+	response := &queueImportResponse{
+		Status: "ok",
+		Runs:   make([]queueImportEntry, 0, len(snapshot.Runs)),
+	}
+	for i := range snapshot.Runs {
+		snapshotEntry := &snapshot.Runs[i]
+		entry := queueImportEntry{
+			RunID: snapshotEntry.ID,
+		}
+		if ctx.QueueManager.IsActive(snapshotEntry.ID) {
+			entry.Skipped = true
+			response.Skipped++
+		} else if err := importQueueSnapshotEntry(ctx, db, artifacts, snapshotEntry); err != nil {
+			ctx.Log.Error(
+				"Failed to import run from queue snapshot",
+				map[string]any{
+					"err": err,
+					"run": snapshotEntry,
+				},
+			)
+			entry.Error = err.Error()
+			response.Failed++
+		} else {
+			response.Imported++
+		}
+		response.Runs = append(response.Runs, entry)
+	}
+	return response
+}
+
+func importQueueSnapshotEntry(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	snapshotEntry *grader.QueueSnapshotEntry,
+) error {
+	if snapshotEntry.ID <= 0 {
+		return fmt.Errorf("invalid run id %d", snapshotEntry.ID)
+	}
+	if snapshotEntry.Priority < 0 || snapshotEntry.Priority >= grader.QueuePriorityEphemeral {
+		return fmt.Errorf("invalid priority %d", snapshotEntry.Priority)
+	}
+	queueName := snapshotEntry.Queue
+	if queueName == "" {
+		queueName = grader.DefaultQueueName
+	}
+	runs, err := ctx.QueueManager.Get(queueName)
+	if err != nil {
+		return err
+	}
+	runInfo, err := newRunInfoFromID(ctx, db, snapshotEntry.ID, artifacts)
+	if err != nil {
+		return err
+	}
+	if snapshotEntry.GUID != "" && snapshotEntry.GUID != runInfo.GUID {
+		return fmt.Errorf(
+			"run %d belongs to submission %s, not %s",
+			snapshotEntry.ID,
+			runInfo.GUID,
+			snapshotEntry.GUID,
+		)
+	}
+	runInfo.Priority = snapshotEntry.Priority
+	runInfo.MaxAttempts = snapshotEntry.AttemptsLeft
+	if err := updateDatabase(ctx, db, "waiting", runInfo); err != nil {
+		return err
+	}
+	return injectRun(ctx, artifacts, runs, snapshotEntry.Priority, runInfo)
+}
+
+func broadcast(
+	ctx *grader.Context,
+	client *http.Client,
+	message *broadcaster.Message,
+) error {
+	marshaled, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(
+		ctx.Config.Grader.BroadcasterURL,
 		"text/json",
 		bytes.NewReader(marshaled),
 	)
@@ -809,9 +1824,164 @@ func broadcast(
 	return nil
 }
 
+// readRunSource reads the source of a new run from the request body, which
+// can be either the raw source or a multipart/form-data body with a "source"
+// part. Sources larger than maxSize bytes or that are not valid UTF-8 are
+// rejected.
+func readRunSource(r *http.Request, maxSize base.Byte) ([]byte, error) {
+	var body io.Reader = r.Body
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/form-data" {
+		multipartReader := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := multipartReader.NextPart()
+			if err == io.EOF {
+				return nil, errMissingSource
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid multipart body: %w", err)
+			}
+			if part.FormName() == "source" {
+				body = part
+				break
+			}
+		}
+	}
+	if maxSize > 0 {
+		body = io.LimitReader(body, int64(maxSize)+1)
+	}
+	source, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("read source: %w", err)
+	}
+	if maxSize > 0 && base.Byte(len(source)) > maxSize {
+		return nil, errSourceTooLarge
+	}
+	if !utf8.Valid(source) {
+		return nil, errInvalidSourceEncoding
+	}
+	return source, nil
+}
+
+// handleArtifactUpload handles the resumable upload of artifacts that can be
+// referenced by runs, such as large output-only submissions. A HEAD request
+// returns the number of bytes that have been uploaded so far in the
+// Upload-Offset header, a PATCH request with an Upload-Offset header appends
+// its body to the upload, and a PATCH request with an "Upload-Complete: true"
+// header commits the upload after appending its body.
+func handleArtifactUpload(
+	ctx *grader.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	artifacts *grader.ArtifactManager,
+) {
+	defer r.Body.Close()
+	tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(tokens) != 3 || !common.IsValidArtifactID(tokens[2]) {
+		ctx.Log.Error(
+			"Invalid request",
+			map[string]any{
+				"url": r.URL.Path,
+			},
+		)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id := tokens[2]
+
+	switch r.Method {
+	case "HEAD":
+		offset, complete, err := artifacts.Uploads.Offset(&ctx.Context, id)
+		if err != nil {
+			ctx.Log.Error(
+				"/artifact/upload/",
+				map[string]any{
+					"id":       id,
+					"response": "internal server error",
+					"err":      err,
+				},
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.Header().Set("Upload-Complete", strconv.FormatBool(complete))
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			ctx.Log.Error(
+				"Invalid Upload-Offset",
+				map[string]any{
+					"id":     id,
+					"offset": r.Header.Get("Upload-Offset"),
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		newOffset, err := artifacts.Uploads.Append(
+			&ctx.Context,
+			id,
+			offset,
+			r.Body,
+			int64(ctx.Config.Grader.MaxArtifactUploadSize),
+		)
+		if err == nil && r.Header.Get("Upload-Complete") == "true" {
+			err = artifacts.Uploads.Commit(&ctx.Context, id)
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, grader.ErrUploadOffsetMismatch),
+				errors.Is(err, grader.ErrUploadInProgress),
+				errors.Is(err, grader.ErrUploadComplete):
+				status = http.StatusConflict
+			case errors.Is(err, grader.ErrUploadTooLarge):
+				status = http.StatusRequestEntityTooLarge
+			}
+			ctx.Log.Error(
+				"/artifact/upload/",
+				map[string]any{
+					"id":       id,
+					"offset":   offset,
+					"response": http.StatusText(status),
+					"err":      err,
+				},
+			)
+			w.WriteHeader(status)
+			return
+		}
+		ctx.Log.Info(
+			"/artifact/upload/",
+			map[string]any{
+				"id":       id,
+				"offset":   newOffset,
+				"response": "ok",
+			},
+		)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		ctx.Log.Error(
+			"Invalid request",
+			map[string]any{
+				"url":    r.URL.Path,
+				"method": r.Method,
+			},
+		)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// registerFrontendHandlers registers the handlers that the frontend uses in
+// mux, and the ones used to operate the grader in adminMux. Both can be the
+// same mux.
 func registerFrontendHandlers(
 	ctx *grader.Context,
 	mux *http.ServeMux,
+	adminMux *http.ServeMux,
+	auth *frontendAuth,
 	newRuns chan struct{},
 	db *sql.DB,
 	artifacts *grader.ArtifactManager,
@@ -821,6 +1991,21 @@ func registerFrontendHandlers(
 		panic(err)
 	}
 	go runQueueLoop(ctx, runs, newRuns, db, artifacts)
+	regressions.setup(ctx, db, artifacts, runs)
+	if ctx.Config.Grader.AuditLogPath != "" {
+		audit.setup(ctx.Config.Grader.AuditLogPath)
+	} else {
+		audit.setup(path.Join(ctx.Config.Grader.RuntimePath, "audit.log"))
+	}
+	if err := rejudges.setup(
+		ctx,
+		db,
+		newRuns,
+		path.Join(ctx.Config.Grader.RuntimePath, "scheduled-rejudges.json"),
+		time.Duration(ctx.Config.Grader.V1.RejudgeInjectInterval),
+	); err != nil {
+		panic(err)
+	}
 
 	transport := &http.Transport{
 		Dial: (&net.Dialer{
@@ -852,52 +2037,627 @@ func registerFrontendHandlers(
 		if err := http2.ConfigureTransport(transport); err != nil {
 			panic(err)
 		}
-	}
+	}
+
+	client := &http.Client{Transport: transport}
+
+	if ctx.Config.Grader.V1.SendBroadcast && ctx.Config.Grader.V1.BroadcastOutboxSize > 0 &&
+		ctx.Config.Grader.V1.BroadcastRetryInterval > 0 {
+		if err := broadcasts.setup(
+			path.Join(ctx.Config.Grader.RuntimePath, "broadcast-outbox"),
+			ctx.Config.Grader.V1.BroadcastOutboxSize,
+		); err != nil {
+			panic(err)
+		}
+		go broadcasts.run(ctx, client, time.Duration(ctx.Config.Grader.V1.BroadcastRetryInterval))
+	}
+
+	finishedRunsChan := make(chan *grader.RunInfo, 1)
+	ctx.QueueManager.PostProcessor.AddListener(finishedRunsChan)
+	go runPostProcessor(ctx, db, finishedRunsChan, client)
+
+	adminMux.Handle("/metrics", promhttp.Handler())
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/status/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = ctx.Wrap(r.Context())
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		runData := ctx.InflightMonitor.GetRunData()
+		status := graderStatusResponse{
+			Status: "ok",
+			RunningQueue: graderStatusQueue{
+				Runners: []string{},
+				Running: make([]graderRunningStatus, len(runData)),
+			},
+		}
+
+		for i, data := range runData {
+			status.RunningQueue.Running[i].RunnerName = data.Runner
+			status.RunningQueue.Running[i].ID = data.ID
+			status.RunningQueue.Running[i].CasesGraded = data.CasesGraded
+		}
+		status.RunnerToolchains = toolchains.Runners()
+		status.ToolchainSkew = skew(status.RunnerToolchains)
+		status.RunnerClockOffsets = clocks.Offsets()
+		for runnerName := range status.RunnerToolchains {
+			status.RunningQueue.Runners = append(status.RunningQueue.Runners, runnerName)
+		}
+		sort.Strings(status.RunningQueue.Runners)
+		for _, queueInfo := range ctx.QueueManager.GetQueueInfo() {
+			for _, l := range queueInfo.Lengths {
+				status.RunningQueue.RunQueueLength += l
+			}
+		}
+		encoder := json.NewEncoder(w)
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := encoder.Encode(&status); err != nil {
+			ctx.Log.Error(
+				"Error writing /grader/status/ response",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})))
+
+	mux.Handle(ctx.Tracing.WrapHandle("/run/new/", auth.wrap(ctx, "/run/new/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = ctx.Wrap(r.Context())
+		if r.Method != "POST" {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+		if len(tokens) != 3 {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url": r.URL.Path,
+				},
+			)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		runID, err := strconv.ParseUint(tokens[2], 10, 64)
+		if err != nil {
+			ctx.Log.Error(
+				"Invalid Run ID",
+				map[string]any{
+					"run id": tokens[2],
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		source, err := readRunSource(r, ctx.Config.Grader.V1.MaxSourceSize)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errSourceTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			ctx.Log.Error(
+				"/run/new/",
+				map[string]any{
+					"runID":    runID,
+					"response": http.StatusText(status),
+					"err":      err,
+				},
+			)
+			w.WriteHeader(status)
+			return
+		}
+		runInfo, err := newRunInfoFromID(ctx, db, int64(runID), artifacts)
+		if err != nil {
+			ctx.Log.Error(
+				"/run/new/",
+				map[string]any{
+					"runID":    runID,
+					"response": "internal server error",
+					"err":      err,
+				},
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		err = artifacts.Submissions.PutSource(&ctx.Context, runInfo.GUID, bytes.NewReader(source))
+		if err != nil {
+			ctx.Log.Error(
+				"/run/new/",
+				map[string]any{
+					"runID":    runID,
+					"guid":     runInfo.GUID,
+					"response": "internal server error",
+					"err":      err,
+				},
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Submissions that the problem does not accept are rejected right away,
+		// instead of having a runner compile them just to get a CE.
+		runInfo.Run.Source = string(source)
+		if err := validateRunSubmission(ctx, runInfo); err != nil {
+			reason := submissionRejectionReason(err)
+			if reason == "" {
+				ctx.Log.Warn(
+					"Failed to validate the submission",
+					map[string]any{
+						"runID": runID,
+						"err":   err,
+					},
+				)
+			} else {
+				rejectRun(ctx, runInfo, err)
+				ctx.Log.Info(
+					"/run/new/",
+					map[string]any{
+						"guid":     runInfo.GUID,
+						"response": reason,
+					},
+				)
+				w.Header().Set("Content-Type", "text/json; charset=utf-8")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]string{
+					"status":  "error",
+					"error":   reason,
+					"message": err.Error(),
+				})
+				return
+			}
+		}
+
+		// This helps close a race where several runs are created and the run loop
+		// grabs the ID of a run whose submission's source has not yet been written
+		// to disk.
+		err = updateDatabase(ctx, db, "new", runInfo)
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to mark a run as new",
+				map[string]any{
+					"run": runInfo,
+					"err": err,
+				},
+			)
+		}
+
+		// Try to notify the channel that there's something new. If it has already
+		// been notified, do nothing.
+		select {
+		case newRuns <- struct{}{}:
+		default:
+		}
+
+		ctx.Log.Info(
+			"/run/new/",
+			map[string]any{
+				"guid":     runInfo.GUID,
+				"response": "ok",
+			},
+		)
+		w.WriteHeader(http.StatusOK)
+	}))))
+
+	mux.Handle(ctx.Tracing.WrapHandle("/run/grade/", auth.wrap(ctx, "/run/grade/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = ctx.Wrap(r.Context())
+		decoder := json.NewDecoder(r.Body)
+		defer r.Body.Close()
+
+		var request runGradeRequest
+		if err := decoder.Decode(&request); err != nil {
+			ctx.Log.Error(
+				"Error receiving grade request",
+				map[string]any{
+					"err": err,
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx.Log.Info(
+			"/run/grade/",
+			map[string]any{
+				"request": request,
+			},
+		)
+		if request.DryRun {
+			w.Header().Set("Content-Type", "text/json; charset=utf-8")
+			if err := json.NewEncoder(w).Encode(dryRunGrade(ctx, db, artifacts, &request)); err != nil {
+				ctx.Log.Error(
+					"Failed to encode the dry run response",
+					map[string]any{
+						"err": err,
+					},
+				)
+			}
+			return
+		}
+		if request.Schedule != "" {
+			job, err := rejudges.Schedule(request.Schedule, request.MaxQueueLength, request.RunIDs)
+			if err != nil {
+				ctx.Log.Error(
+					"Failed to schedule rejudge",
+					map[string]any{
+						"err": err,
+					},
+				)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			audit.Record(ctx, r, "schedule_rejudge", map[string]any{
+				"id":               job.ID,
+				"run_ids":          request.RunIDs,
+				"schedule":         request.Schedule,
+				"max_queue_length": job.MaxQueueLength,
+			})
+			w.Header().Set("Content-Type", "text/json; charset=utf-8")
+			if err := json.NewEncoder(w).Encode(job); err != nil {
+				ctx.Log.Error(
+					"Failed to encode the scheduled rejudge",
+					map[string]any{
+						"err": err,
+					},
+				)
+			}
+			return
+		}
+		action := "grade"
+		if request.Rejudge {
+			action = "rejudge"
+		}
+		audit.Record(ctx, r, action, map[string]any{
+			"run_ids": request.RunIDs,
+			"debug":   request.Debug,
+		})
+
+		// Try to notify the channel that there's something new. If it has already
+		// been notified, do nothing.
+		select {
+		case newRuns <- struct{}{}:
+		default:
+		}
+
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		fmt.Fprintf(w, "{\"status\":\"ok\"}")
+	}))))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/rejudges/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(rejudges.Jobs()); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the scheduled rejudges",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/queue/export/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(ctx.QueueManager.Snapshot()); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the queue snapshot",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/queue/import/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		if r.Method != "POST" {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		defer r.Body.Close()
+
+		var snapshot grader.QueueSnapshot
+		if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+			ctx.Log.Error(
+				"Error receiving queue snapshot",
+				map[string]any{
+					"err": err,
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		runIDs := make([]int64, len(snapshot.Runs))
+		for i, entry := range snapshot.Runs {
+			runIDs[i] = entry.ID
+		}
+		audit.Record(ctx, r, "queue_import", map[string]any{
+			"run_ids": runIDs,
+		})
+
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(importQueueSnapshot(ctx, db, artifacts, &snapshot)); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the queue import response",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/regression/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		switch r.Method {
+		case "GET":
+		case "POST":
+			if !regressions.Trigger("requested") {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			audit.Record(ctx, r, "regression_check", nil)
+		default:
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(regressions.Report()); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the regression report",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/timeline/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		if r.Method != "GET" {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// /grader/timeline/<guid>/ returns everything that happened to the
+		// most recent runs of the submission while this grader handled them.
+		tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(tokens) != 3 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		timeline, ok := ctx.QueueManager.Timelines.Get(tokens[2])
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(timeline); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the run timeline",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/scoreboard/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(tokens) < 3 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		problemset, err := strconv.ParseInt(tokens[2], 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == "GET" {
+			// /grader/scoreboard/<problemset>/ returns the standings of the
+			// contest as seen by its administrators, including the runs that
+			// were submitted while the scoreboard is frozen.
+			if len(tokens) != 3 || !ctx.Config.Grader.Scoreboard {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			serveScoreboard(ctx, w, db, problemset, true)
+			return
+		}
+		if r.Method != "POST" {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// /grader/scoreboard/<problemset>/reload/ discards the standings of
+		// the contest, so that they are loaded from the database again. This
+		// is needed after the frontend changes something that the grader does
+		// not see, like a disqualified submission.
+		if len(tokens) != 4 || tokens[3] != "reload" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		audit.Record(ctx, r, "scoreboard_reload", map[string]any{
+			"problemset": problemset,
+		})
+		scoreboards.Forget(problemset)
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/appeal/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		var response any
+		switch r.Method {
+		case "GET":
+			// /grader/appeal/<guid>/ returns the appeals of the submission.
+			tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if len(tokens) != 3 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			appeals, err := runAppeals(ctx, db, artifacts, tokens[2])
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				ctx.Log.Error(
+					"Failed to get the appeals",
+					map[string]any{
+						"guid": tokens[2],
+						"err":  err,
+					},
+				)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			response = appeals
+		case "POST":
+			var request appealRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				ctx.Log.Error(
+					"Error receiving appeal request",
+					map[string]any{
+						"err": err,
+					},
+				)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			audit.Record(ctx, r, "appeal", map[string]any{
+				"guid":     request.GUID,
+				"override": request.LimitsOverride,
+			})
+			result, err := appealRun(ctx, db, artifacts, runs, &request)
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, errNoLimitsOverride) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				ctx.Log.Error(
+					"Failed to re-run the appealed run",
+					map[string]any{
+						"guid": request.GUID,
+						"err":  err,
+					},
+				)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			response = result
+		default:
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
 
-	client := &http.Client{Transport: transport}
-
-	finishedRunsChan := make(chan *grader.RunInfo, 1)
-	ctx.QueueManager.PostProcessor.AddListener(finishedRunsChan)
-	go runPostProcessor(ctx, db, finishedRunsChan, client)
-
-	mux.Handle("/metrics", promhttp.Handler())
-
-	mux.Handle(ctx.Tracing.WrapHandle("/grader/status/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx = ctx.Wrap(r.Context())
 		w.Header().Set("Content-Type", "text/json; charset=utf-8")
-		runData := ctx.InflightMonitor.GetRunData()
-		status := graderStatusResponse{
-			Status: "ok",
-			RunningQueue: graderStatusQueue{
-				Runners: []string{},
-				Running: make([]graderRunningStatus, len(runData)),
-			},
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the appeal",
+				map[string]any{
+					"err": err,
+				},
+			)
 		}
+	})))
 
-		for i, data := range runData {
-			status.RunningQueue.Running[i].RunnerName = data.Runner
-			status.RunningQueue.Running[i].ID = data.ID
+	mux.Handle(ctx.Tracing.WrapHandle("/problem/stats/", auth.wrap(ctx, "/problem/stats/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		if r.Method != "GET" {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
 		}
-		for _, queueInfo := range ctx.QueueManager.GetQueueInfo() {
-			for _, l := range queueInfo.Lengths {
-				status.RunningQueue.RunQueueLength += l
+
+		// /problem/stats/ returns the statistics of all the problems, and
+		// /problem/stats/<alias>/ the ones of a single problem.
+		tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		var response any
+		switch len(tokens) {
+		case 2:
+			response = problemStats.All()
+		case 3:
+			stats := problemStats.Get(tokens[2])
+			if stats == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
 			}
+			response = stats
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
-		encoder := json.NewEncoder(w)
+
 		w.Header().Set("Content-Type", "text/json; charset=utf-8")
-		if err := encoder.Encode(&status); err != nil {
+		if err := json.NewEncoder(w).Encode(response); err != nil {
 			ctx.Log.Error(
-				"Error writing /grader/status/ response",
+				"Failed to encode the problem statistics",
 				map[string]any{
 					"err": err,
 				},
 			)
 		}
-	})))
+	}))))
 
-	mux.Handle(ctx.Tracing.WrapHandle("/run/new/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx = ctx.Wrap(r.Context())
-		if r.Method != "POST" {
+	mux.Handle(ctx.Tracing.WrapHandle("/scoreboard/", auth.wrap(ctx, "/scoreboard/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		if r.Method != "GET" {
 			ctx.Log.Error(
 				"Invalid request",
 				map[string]any{
@@ -908,126 +2668,205 @@ func registerFrontendHandlers(
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
+		if !ctx.Config.Grader.Scoreboard {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
+		// /scoreboard/<problemset>/ returns the standings of the contest, as
+		// seen by the contestants. The runs that were submitted while the
+		// scoreboard is frozen are only available through
+		// /grader/scoreboard/<problemset>/ in the admin port.
 		tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(tokens) != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		problemset, err := strconv.ParseInt(tokens[1], 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		serveScoreboard(ctx, w, db, problemset, false)
+	}))))
 
-		if len(tokens) != 3 {
+	problemManifestRe := regexp.MustCompile("^/problem/manifest/([a-zA-Z0-9_-]+)/([a-f0-9]{40})/?$")
+	mux.Handle(ctx.Tracing.WrapHandle("/problem/manifest/", auth.wrap(ctx, "/problem/manifest/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		if r.Method != "GET" {
 			ctx.Log.Error(
 				"Invalid request",
 				map[string]any{
-					"url": r.URL.Path,
+					"url":    r.URL.Path,
+					"method": r.Method,
 				},
 			)
-			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
-		runID, err := strconv.ParseUint(tokens[2], 10, 64)
+		// /problem/manifest/<alias>/<input hash>/ returns the structure of the
+		// cases of the problem for that input.
+		res := problemManifestRe.FindStringSubmatch(r.URL.Path)
+		if res == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		problemName, inputHash := res[1], res[2]
+		inputRef, err := ctx.InputManager.Add(
+			inputHash,
+			grader.NewInputFactory(problemName, &ctx.Config),
+		)
 		if err != nil {
 			ctx.Log.Error(
-				"Invalid Run ID",
+				"Input not found",
 				map[string]any{
-					"run id": tokens[2],
+					"problem": problemName,
+					"hash":    inputHash,
+					"err":     err,
 				},
 			)
-			w.WriteHeader(http.StatusBadRequest)
+			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		runInfo, err := newRunInfoFromID(ctx, db, int64(runID), artifacts)
-		if err != nil {
+		manifest := common.NewInputManifest(inputHash, inputRef.Input.Settings())
+		inputRef.Release()
+
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(manifest); err != nil {
 			ctx.Log.Error(
-				"/run/new/",
+				"Failed to encode the input manifest",
 				map[string]any{
-					"runID":    runID,
-					"response": "internal server error",
-					"err":      err,
+					"err": err,
 				},
 			)
-			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))))
+
+	runProgressRe := regexp.MustCompile("^/run/progress/([0-9]+)/?$")
+	mux.Handle(ctx.Tracing.WrapHandle("/run/status/", auth.wrap(ctx, "/run/status/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		if r.Method != "POST" {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
-		err = artifacts.Submissions.PutSource(&ctx.Context, runInfo.GUID, r.Body)
+		// /run/status/ returns the current state of all the runs whose GUIDs
+		// are in the request, so that the frontend does not need to poll each
+		// one of them separately.
+		var request runStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			ctx.Log.Error(
+				"Error receiving run status request",
+				map[string]any{
+					"err": err,
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(request.GUIDs) > maxRunStatusGUIDs {
+			ctx.Log.Error(
+				"Too many runs in the run status request",
+				map[string]any{
+					"count": len(request.GUIDs),
+					"max":   maxRunStatusGUIDs,
+				},
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		entries, err := runStatuses(db, ctx.QueueManager, request.GUIDs)
 		if err != nil {
 			ctx.Log.Error(
-				"/run/new/",
+				"Failed to get the status of the runs",
 				map[string]any{
-					"runID":    runID,
-					"guid":     runInfo.GUID,
-					"response": "internal server error",
-					"err":      err,
+					"err": err,
 				},
 			)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// This helps close a race where several runs are created and the run loop
-		// grabs the ID of a run whose submission's source has not yet been written
-		// to disk.
-		err = updateDatabase(ctx, db, "new", runInfo)
-		if err != nil {
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(&runStatusResponse{Runs: entries}); err != nil {
 			ctx.Log.Error(
-				"Failed to mark a run as new",
+				"Failed to encode the run status",
 				map[string]any{
-					"run": runInfo,
 					"err": err,
 				},
 			)
 		}
+	}))))
 
-		// Try to notify the channel that there's something new. If it has already
-		// been notified, do nothing.
-		select {
-		case newRuns <- struct{}{}:
-		default:
+	mux.Handle(ctx.Tracing.WrapHandle("/run/progress/", auth.wrap(ctx, "/run/progress/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		if r.Method != "GET" {
+			ctx.Log.Error(
+				"Invalid request",
+				map[string]any{
+					"url":    r.URL.Path,
+					"method": r.Method,
+				},
+			)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
 		}
 
-		ctx.Log.Info(
-			"/run/new/",
-			map[string]any{
-				"guid":     runInfo.GUID,
-				"response": "ok",
-			},
-		)
-		w.WriteHeader(http.StatusOK)
-	})))
-
-	mux.Handle(ctx.Tracing.WrapHandle("/run/grade/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx = ctx.Wrap(r.Context())
-		decoder := json.NewDecoder(r.Body)
-		defer r.Body.Close()
-
-		var request runGradeRequest
-		if err := decoder.Decode(&request); err != nil {
+		// /run/progress/<run id>/ returns the results of the cases of the run
+		// that the runner has streamed so far, while it is being graded. They
+		// are filtered the same way as the final results of the run.
+		res := runProgressRe.FindStringSubmatch(r.URL.Path)
+		if res == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		runID, err := strconv.ParseInt(res[1], 10, 64)
+		if err != nil || runID == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		cases, ok := ctx.InflightMonitor.CaseProgress(runID)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		visibility, err := queryRunVisibility(db, runID)
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			ctx.Log.Error(
-				"Error receiving grade request",
+				"Failed to get the visibility of the run",
 				map[string]any{
+					"id":  runID,
 					"err": err,
 				},
 			)
-			w.WriteHeader(http.StatusBadRequest)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		ctx.Log.Info(
-			"/run/grade/",
-			map[string]any{
-				"request": request,
-			},
-		)
-
-		// Try to notify the channel that there's something new. If it has already
-		// been notified, do nothing.
-		select {
-		case newRuns <- struct{}{}:
-		default:
-		}
 
 		w.Header().Set("Content-Type", "text/json; charset=utf-8")
-		fmt.Fprintf(w, "{\"status\":\"ok\"}")
-	})))
+		if err := json.NewEncoder(w).Encode(runProgress(cases, visibility)); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the run progress",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	}))))
 
-	mux.Handle(ctx.Tracing.WrapHandle("/submission/source/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle(ctx.Tracing.WrapHandle("/submission/source/", auth.wrap(ctx, "/submission/source/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx = ctx.Wrap(r.Context())
 		if r.Method != "GET" {
 			ctx.Log.Error(
@@ -1093,9 +2932,13 @@ func registerFrontendHandlers(
 		)
 		w.WriteHeader(http.StatusOK)
 		w.Write(sourceBytes)
-	})))
+	}))))
+
+	mux.Handle(ctx.Tracing.WrapHandle("/artifact/upload/", auth.wrap(ctx, "/artifact/upload/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleArtifactUpload(ctx.Wrap(r.Context()), w, r, artifacts)
+	}))))
 
-	mux.Handle(ctx.Tracing.WrapHandle("/run/resource/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle(ctx.Tracing.WrapHandle("/run/resource/", auth.wrap(ctx, "/run/resource/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx = ctx.Wrap(r.Context())
 		decoder := json.NewDecoder(r.Body)
 		defer r.Body.Close()
@@ -1137,13 +2980,11 @@ func registerFrontendHandlers(
 			return
 		}
 
-		filePath := path.Join(
-			gradeDir(ctx, request.RunID),
-			request.Filename,
-		)
-		f, err := os.Open(filePath)
+		// The artifacts are read through the ArtifactManager so that they are
+		// decrypted if needed.
+		f, err := artifacts.Grader(&ctx.Context, request.RunID).Get(&ctx.Context, request.Filename)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if errors.Is(err, os.ErrNotExist) {
 				ctx.Log.Info(
 					"/run/resource/",
 					map[string]any{
@@ -1168,21 +3009,24 @@ func registerFrontendHandlers(
 		}
 		defer f.Close()
 
-		info, err := f.Stat()
-		if err != nil {
-			ctx.Log.Info(
-				"/run/resource/",
-				map[string]any{
-					"request":  request,
-					"response": "internal server error",
-					"err":      err,
-				},
-			)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
 		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		// The size of the encrypted artifacts is not known in advance.
+		if file, ok := f.(*os.File); ok {
+			info, err := file.Stat()
+			if err != nil {
+				ctx.Log.Info(
+					"/run/resource/",
+					map[string]any{
+						"request":  request,
+						"response": "internal server error",
+						"err":      err,
+					},
+				)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		}
 
 		ctx.Log.Info(
 			"/run/resource/",
@@ -1193,9 +3037,9 @@ func registerFrontendHandlers(
 		)
 		w.WriteHeader(http.StatusOK)
 		io.Copy(w, f)
-	})))
+	}))))
 
-	mux.Handle(ctx.Tracing.WrapHandle("/broadcast/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle(ctx.Tracing.WrapHandle("/broadcast/", auth.wrap(ctx, "/broadcast/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx = ctx.Wrap(r.Context())
 		decoder := json.NewDecoder(r.Body)
 		defer r.Body.Close()
@@ -1227,12 +3071,53 @@ func registerFrontendHandlers(
 		}
 		w.Header().Set("Content-Type", "text/json; charset=utf-8")
 		fmt.Fprintf(w, "{\"status\":\"ok\"}")
-	})))
+	}))))
 
-	mux.Handle(ctx.Tracing.WrapHandle("/reload-config/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	adminMux.Handle(ctx.Tracing.WrapHandle("/reload-config/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx = ctx.Wrap(r.Context())
 		ctx.Log.Info("/reload-config/", nil)
+		audit.Record(ctx, r, "reload_config", nil)
 		w.Header().Set("Content-Type", "text/json; charset=utf-8")
 		fmt.Fprintf(w, "{\"status\":\"ok\"}")
 	})))
+
+	adminMux.Handle(ctx.Tracing.WrapHandle("/grader/audit/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctx.Wrap(r.Context())
+		var since time.Time
+		if s := r.URL.Query().Get("since"); s != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, s); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		limit := 100
+		if s := r.URL.Query().Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		entries, err := audit.Query(since, r.URL.Query().Get("action"), limit)
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to read the audit log",
+				map[string]any{
+					"err": err,
+				},
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			ctx.Log.Error(
+				"Failed to encode the audit log",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})))
 }
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -60,6 +65,10 @@ func newInMemoryDB(t *testing.T, scoreMode string) *sql.DB {
 			contest_score double DEFAULT NULL,
 			time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			judged_by varchar DEFAULT NULL,
+			score_numerator bigint DEFAULT NULL,
+			score_denominator bigint DEFAULT NULL,
+			contest_score_numerator bigint DEFAULT NULL,
+			contest_score_denominator bigint DEFAULT NULL,
 			UNIQUE (submission_id, version)
 		);
 		CREATE TABLE Runs_Groups (
@@ -334,11 +343,484 @@ func TestUpdateDatabase(t *testing.T) {
 	}
 }
 
+func TestUpdateDatabasePenalty(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+
+	run := grader.RunInfo{
+		ID:           1,
+		SubmissionID: 1,
+		GUID:         "1",
+		Run:          &common.Run{},
+		Result: runner.RunResult{
+			Verdict:      "AC",
+			Score:        big.NewRat(1, 1),
+			ContestScore: big.NewRat(1, 1),
+			MaxScore:     big.NewRat(1, 1),
+			Time:         1.5,
+		},
+	}
+	for _, tc := range []struct {
+		penaltyType string
+		submitDelay int64
+		expected    int64
+	}{
+		{"runtime", 0, 1500},
+		{"contest_start", 42, 42},
+		// Unknown penalty types keep the previous penalty.
+		{"unknown", 7, 42},
+		{"none", 7, 0},
+	} {
+		run.PenaltyType = tc.penaltyType
+		run.SubmitDelay = tc.submitDelay
+		if err := updateDatabase(ctx, db, "ready", &run); err != nil {
+			t.Fatalf("Error updating the database: %v", err)
+		}
+		var penalty int64
+		if err := queryRowWithRetry(
+			db,
+			`SELECT penalty FROM Runs WHERE run_id = 1;`,
+		).Scan(
+			&penalty,
+		); err != nil {
+			t.Fatalf("Error reading the database: %v", err)
+		}
+		if penalty != tc.expected {
+			t.Errorf("penalty_type %q: penalty == %d, want %d", tc.penaltyType, penalty, tc.expected)
+		}
+	}
+}
+
+func TestDatabaseScore(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+
+	run := grader.RunInfo{
+		ID:           1,
+		SubmissionID: 1,
+		GUID:         "1",
+		Run:          &common.Run{},
+		PenaltyType:  "none",
+		Result: runner.RunResult{
+			Verdict:      "PA",
+			Score:        big.NewRat(2, 3),
+			ContestScore: big.NewRat(200, 3),
+			MaxScore:     big.NewRat(100, 1),
+		},
+		ScoreRounding: &common.ScoreRoundingSettings{
+			Mode:          common.ScoreRoundingModeFloor,
+			DecimalPlaces: 1,
+		},
+	}
+	score, contestScore := roundedScores(&run)
+	if contestScore.Cmp(big.NewRat(666, 10)) != 0 {
+		t.Errorf("contestScore == %v, expected 66.6", contestScore)
+	}
+	if score.Cmp(big.NewRat(666, 1000)) != 0 {
+		t.Errorf("score == %v, expected 0.666", score)
+	}
+
+	ctx.Config.Grader.V1.ExactScores = true
+	if err := updateDatabase(ctx, db, "ready", &run); err != nil {
+		t.Fatalf("Error updating the database: %v", err)
+	}
+	var floatContestScore float64
+	var scoreNumerator, scoreDenominator, contestScoreNumerator, contestScoreDenominator int64
+	if err := queryRowWithRetry(
+		db,
+		`
+		SELECT
+			contest_score, score_numerator, score_denominator,
+			contest_score_numerator, contest_score_denominator
+		FROM
+			Runs
+		WHERE
+			run_id = 1;
+		`,
+	).Scan(
+		&floatContestScore,
+		&scoreNumerator,
+		&scoreDenominator,
+		&contestScoreNumerator,
+		&contestScoreDenominator,
+	); err != nil {
+		t.Fatalf("Error reading the database: %v", err)
+	}
+	if floatContestScore != 66.6 {
+		t.Errorf("contest_score == %v, expected 66.6", floatContestScore)
+	}
+	if got := big.NewRat(scoreNumerator, scoreDenominator); got.Cmp(score) != 0 {
+		t.Errorf("exact score == %v, expected %v", got, score)
+	}
+	if got := big.NewRat(contestScoreNumerator, contestScoreDenominator); got.Cmp(contestScore) != 0 {
+		t.Errorf("exact contest score == %v, expected %v", got, contestScore)
+	}
+
+	if numerator, denominator := exactScore(new(big.Rat).SetFrac(
+		new(big.Int).Lsh(big.NewInt(1), 70),
+		big.NewInt(3),
+	)); numerator != nil || denominator != nil {
+		t.Errorf("exactScore() of a huge score == %v/%v, expected nil", numerator, denominator)
+	}
+}
+
+func TestSubmittedNearContestEnd(t *testing.T) {
+	finishTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	window := 15 * time.Minute
+	for _, tc := range []struct {
+		name           string
+		submissionTime time.Time
+		now            time.Time
+		window         time.Duration
+		expected       bool
+	}{
+		{"in window", finishTime.Add(-10 * time.Minute), finishTime.Add(-10 * time.Minute), window, true},
+		{"last second", finishTime, finishTime, window, true},
+		{"before window", finishTime.Add(-time.Hour), finishTime.Add(-time.Hour), window, false},
+		{"after contest", finishTime.Add(time.Minute), finishTime.Add(time.Minute), window, false},
+		{"late rejudge", finishTime.Add(-10 * time.Minute), finishTime.Add(24 * time.Hour), window, false},
+		{"disabled", finishTime.Add(-10 * time.Minute), finishTime.Add(-10 * time.Minute), 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := submittedNearContestEnd(tc.submissionTime, finishTime, tc.now, tc.window); got != tc.expected {
+				t.Errorf("submittedNearContestEnd() == %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestReconcilePendingRuns(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+
+	if _, err := execWithRetry(
+		db,
+		`UPDATE Runs SET status = 'waiting' WHERE run_id = 1;`,
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+
+	// The first pass only marks the run as a suspect.
+	suspects, reconciled := reconcilePendingRuns(ctx, db, make(map[int64]struct{}))
+	if reconciled != 0 {
+		t.Errorf("reconciled == %d, want 0", reconciled)
+	}
+	if _, ok := suspects[1]; !ok || len(suspects) != 1 {
+		t.Errorf("suspects == %v, want only run 1", suspects)
+	}
+
+	// The second pass resets it, since it was not picked up by any queue.
+	suspects, reconciled = reconcilePendingRuns(ctx, db, suspects)
+	if reconciled != 1 {
+		t.Errorf("reconciled == %d, want 1", reconciled)
+	}
+	if len(suspects) != 0 {
+		t.Errorf("suspects == %v, want none", suspects)
+	}
+
+	var status string
+	if err := queryRowWithRetry(
+		db,
+		`SELECT status FROM Runs WHERE run_id = 1;`,
+	).Scan(
+		&status,
+	); err != nil {
+		t.Fatalf("Error reading the database: %v", err)
+	}
+	if status != "new" {
+		t.Errorf("status == %q, want \"new\"", status)
+	}
+
+	// Runs that are already new are left alone.
+	suspects, reconciled = reconcilePendingRuns(ctx, db, suspects)
+	if reconciled != 0 || len(suspects) != 0 {
+		t.Errorf("reconciled == %d, suspects == %v, want nothing", reconciled, suspects)
+	}
+
+	// So are the runs whose results are being uploaded.
+	if _, err := execWithRetry(
+		db,
+		`UPDATE Runs SET status = 'uploading' WHERE run_id = 1;`,
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		suspects, reconciled = reconcilePendingRuns(ctx, db, suspects)
+		if reconciled != 0 || len(suspects) != 0 {
+			t.Errorf("reconciled == %d, suspects == %v, want nothing", reconciled, suspects)
+		}
+	}
+
+	// Nothing is reconciled if the grader does not update the database.
+	if _, err := execWithRetry(
+		db,
+		`UPDATE Runs SET status = 'running' WHERE run_id = 1;`,
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	ctx.Config.Grader.V1.UpdateDatabase = false
+	for i := 0; i < 2; i++ {
+		suspects, reconciled = reconcilePendingRuns(ctx, db, suspects)
+		if reconciled != 0 || len(suspects) != 0 {
+			t.Errorf("reconciled == %d, suspects == %v, want nothing", reconciled, suspects)
+		}
+	}
+}
+
+func TestDryRunGrade(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+
+	response := dryRunGrade(ctx, db, nil, &runGradeRequest{
+		RunIDs:  []int64{1000},
+		Rejudge: true,
+		DryRun:  true,
+	})
+	if response.WouldEnqueue != 0 || response.Failed != 1 {
+		t.Errorf("dryRunGrade() == %+v, want one failed run", response)
+	}
+	if len(response.Runs) != 1 || response.Runs[0].RunID != 1000 || response.Runs[0].Error == "" {
+		t.Errorf("dryRunGrade().Runs == %+v, want an error for run 1000", response.Runs)
+	}
+}
+
+func TestRunStatuses(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+
+	entries, err := runStatuses(db, ctx.QueueManager, []string{"missing", "1"})
+	if err != nil {
+		t.Fatalf("Failed to get the status of the runs: %v", err)
+	}
+	if len(entries) != 2 || entries[0].State != runStateNotFound || entries[1].State != runStatePending {
+		t.Errorf("runStatuses() == %+v, want not_found and pending", entries)
+	}
+
+	if _, err := execWithRetry(
+		db,
+		`UPDATE Runs SET status = 'ready', verdict = 'AC', score = 1, runtime = 1500, memory = 1024 WHERE run_id = 1;`,
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	entries, err = runStatuses(db, ctx.QueueManager, []string{"1"})
+	if err != nil {
+		t.Fatalf("Failed to get the status of the runs: %v", err)
+	}
+	if len(entries) != 1 || entries[0].State != runStateFinished || entries[0].Verdict != "AC" ||
+		*entries[0].Score != 1 || *entries[0].Runtime != 1.5 || *entries[0].Memory != 1024 {
+		t.Errorf("runStatuses() == %+v, want a finished AC run", entries)
+	}
+
+	// The contest only allows to show the verdict.
+	if _, err := execWithRetry(
+		db,
+		`UPDATE Submissions SET problemset_id = 1;`,
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	entries, err = runStatuses(db, ctx.QueueManager, []string{"1"})
+	if err != nil {
+		t.Fatalf("Failed to get the status of the runs: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Verdict != "AC" || entries[0].Score != nil || entries[0].Runtime != nil {
+		t.Errorf("runStatuses() == %+v, want only the verdict", entries)
+	}
+
+	// Nothing is shown while the scoreboard is frozen.
+	now := time.Now().UTC()
+	if _, err := execWithRetry(
+		db,
+		`
+		UPDATE Contests SET start_time = ?, finish_time = ?, scoreboard = 50, feedback = 'summary';
+		UPDATE Submissions SET time = ?;
+		`,
+		now.Add(-time.Hour).Format("2006-01-02 15:04:05"),
+		now.Add(time.Hour).Format("2006-01-02 15:04:05"),
+		now.Format("2006-01-02 15:04:05"),
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	entries, err = runStatuses(db, ctx.QueueManager, []string{"1"})
+	if err != nil {
+		t.Fatalf("Failed to get the status of the runs: %v", err)
+	}
+	if len(entries) != 1 || entries[0].State != runStateFinished || !entries[0].Frozen ||
+		entries[0].Verdict != "" || entries[0].Score != nil {
+		t.Errorf("runStatuses() == %+v, want a frozen run", entries)
+	}
+}
+
+func TestRunProgress(t *testing.T) {
+	db := newInMemoryDB(t, "partial")
+	cases := []runner.CaseProgress{
+		{Group: "0", Case: runner.CaseResult{Name: "0", Verdict: "AC", OutputSize: 10, Visibility: common.CaseVisibilityPublic}},
+		{Group: "1", Case: runner.CaseResult{Name: "1", Verdict: "WA", OutputSize: 10, Visibility: common.CaseVisibilityHidden}},
+	}
+
+	// Runs outside of a contest get the default feedback.
+	visibility, err := queryRunVisibility(db, 1)
+	if err != nil {
+		t.Fatalf("Failed to get the visibility of the run: %v", err)
+	}
+	progress := runProgress(cases, visibility)
+	if progress.CasesFinished != 2 || len(progress.Cases) != 2 {
+		t.Fatalf("runProgress() == %+v, want two cases", progress)
+	}
+	if progress.Cases[0].Case.OutputSize != 10 || progress.Cases[1].Case.OutputSize != 0 ||
+		progress.Cases[1].Case.Verdict != "WA" {
+		t.Errorf("runProgress().Cases == %+v, want the hidden case redacted", progress.Cases)
+	}
+
+	// The feedback of the contest hides the cases.
+	if _, err := execWithRetry(
+		db,
+		`UPDATE Submissions SET problemset_id = 1;`,
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	visibility, err = queryRunVisibility(db, 1)
+	if err != nil {
+		t.Fatalf("Failed to get the visibility of the run: %v", err)
+	}
+	if progress := runProgress(cases, visibility); progress.CasesFinished != 2 || progress.Cases != nil {
+		t.Errorf("runProgress() == %+v, want only the number of cases", progress)
+	}
+
+	// And so does the freeze, even with full-diff feedback.
+	now := time.Now().UTC()
+	if _, err := execWithRetry(
+		db,
+		`
+		UPDATE Contests SET start_time = ?, finish_time = ?, scoreboard = 50, feedback = 'full-diff';
+		UPDATE Submissions SET time = ?;
+		`,
+		now.Add(-time.Hour).Format("2006-01-02 15:04:05"),
+		now.Add(time.Hour).Format("2006-01-02 15:04:05"),
+		now.Format("2006-01-02 15:04:05"),
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	visibility, err = queryRunVisibility(db, 1)
+	if err != nil {
+		t.Fatalf("Failed to get the visibility of the run: %v", err)
+	}
+	if progress := runProgress(cases, visibility); progress.CasesFinished != 2 || progress.Cases != nil {
+		t.Errorf("runProgress() == %+v, want only the number of cases while frozen", progress)
+	}
+	visibility.frozen = false
+	if progress := runProgress(cases, visibility); len(progress.Cases) != 2 || progress.Cases[1].Case.OutputSize != 10 {
+		t.Errorf("runProgress() == %+v, want all the cases unredacted", progress)
+	}
+}
+
+func TestSubmissionRejectionReason(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		expected string
+	}{
+		{fmt.Errorf("%w: \"java\" is not one of py3", common.ErrLanguageNotAllowed), "language_not_allowed"},
+		{fmt.Errorf("%w: 2048 bytes is more than the 1024 allowed", common.ErrSourceTooLarge), "source_too_large"},
+		{errors.New("failed to get problem settings"), ""},
+	} {
+		if got := submissionRejectionReason(tc.err); got != tc.expected {
+			t.Errorf("submissionRejectionReason(%v) == %q, want %q", tc.err, got, tc.expected)
+		}
+	}
+}
+
+func TestReadRunSource(t *testing.T) {
+	multipartBody := func(fieldName, contents string) (string, string) {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		if err := w.WriteField(fieldName, contents); err != nil {
+			t.Fatalf("Failed to write field: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to close multipart writer: %v", err)
+		}
+		return buf.String(), w.FormDataContentType()
+	}
+
+	for _, tc := range []struct {
+		name           string
+		body           string
+		expectedSource string
+		expectedErr    error
+	}{
+		{
+			name:           "raw",
+			body:           "print(3)",
+			expectedSource: "print(3)",
+		},
+		{
+			name:        "raw too large",
+			body:        "print(3) # padding",
+			expectedErr: errSourceTooLarge,
+		},
+		{
+			name:        "invalid encoding",
+			body:        "print(\xff)",
+			expectedErr: errInvalidSourceEncoding,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/run/new/1/", strings.NewReader(tc.body))
+			source, err := readRunSource(req, base.Byte(16))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("readRunSource() error == %v, want %v", err, tc.expectedErr)
+			}
+			if string(source) != tc.expectedSource {
+				t.Errorf("readRunSource() == %q, want %q", string(source), tc.expectedSource)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name           string
+		fieldName      string
+		contents       string
+		expectedSource string
+		expectedErr    error
+	}{
+		{
+			name:           "multipart",
+			fieldName:      "source",
+			contents:       "print(3)",
+			expectedSource: "print(3)",
+		},
+		{
+			name:        "multipart too large",
+			fieldName:   "source",
+			contents:    "print(3) # padding",
+			expectedErr: errSourceTooLarge,
+		},
+		{
+			name:        "multipart missing source",
+			fieldName:   "code",
+			contents:    "print(3)",
+			expectedErr: errMissingSource,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body, contentType := multipartBody(tc.fieldName, tc.contents)
+			req := httptest.NewRequest("POST", "/run/new/1/", strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			source, err := readRunSource(req, base.Byte(16))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("readRunSource() error == %v, want %v", err, tc.expectedErr)
+			}
+			if string(source) != tc.expectedSource {
+				t.Errorf("readRunSource() == %q, want %q", string(source), tc.expectedSource)
+			}
+		})
+	}
+}
+
 func TestBroadcastRun(t *testing.T) {
 	ctx := newGraderContext(t)
 	scenarios := []struct {
 		scoreMode     string
-		verdict       string
+		verdict       common.Verdict
 		score         *big.Rat
 		expectedScore float64
 	}{
@@ -409,3 +891,113 @@ func TestBroadcastRun(t *testing.T) {
 		})
 	}
 }
+
+func TestScoreboardFrozen(t *testing.T) {
+	startTime := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	finishTime := startTime.Add(4 * time.Hour)
+	freezeTime := startTime.Add(3 * time.Hour)
+	for _, tc := range []struct {
+		name                string
+		submissionTime      time.Time
+		scoreboardPercent   int64
+		showScoreboardAfter bool
+		now                 time.Time
+		expected            bool
+	}{
+		{"before freeze", freezeTime.Add(-time.Minute), 75, true, freezeTime, false},
+		{"during freeze", freezeTime.Add(time.Minute), 75, true, freezeTime.Add(time.Minute), true},
+		{"no freeze", freezeTime.Add(time.Minute), 100, true, freezeTime.Add(time.Minute), false},
+		{"after contest", freezeTime.Add(time.Minute), 75, true, finishTime.Add(time.Minute), false},
+		{"hidden after contest", freezeTime.Add(time.Minute), 75, false, finishTime.Add(time.Minute), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := scoreboardFrozen(
+				tc.submissionTime,
+				startTime,
+				finishTime,
+				tc.scoreboardPercent,
+				tc.showScoreboardAfter,
+				tc.now,
+			); got != tc.expected {
+				t.Errorf("scoreboardFrozen() == %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBroadcastFrozenRun(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+
+	now := time.Now().UTC()
+	if _, err := execWithRetry(
+		db,
+		`
+		UPDATE Contests SET start_time = ?, finish_time = ?, scoreboard = 50;
+		UPDATE Submissions SET problemset_id = 1, time = ?;
+		`,
+		now.Add(-time.Hour).Format("2006-01-02 15:04:05"),
+		now.Add(time.Hour).Format("2006-01-02 15:04:05"),
+		now.Format("2006-01-02 15:04:05"),
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+
+	var message broadcaster.Message
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Fatalf("Failed to read request from client: %v", err)
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	ctx.Config.Grader.BroadcasterURL = ts.URL
+	defer ts.Close()
+
+	contest := "contest"
+	run := grader.RunInfo{
+		ID:          1,
+		GUID:        "1",
+		Contest:     &contest,
+		Run:         &common.Run{},
+		PenaltyType: "none",
+		ScoreMode:   "partial",
+		Result: runner.RunResult{
+			Verdict:      "AC",
+			Score:        big.NewRat(1, 1),
+			ContestScore: big.NewRat(1, 1),
+			MaxScore:     big.NewRat(1, 1),
+		},
+	}
+	if err := broadcastRun(ctx, db, ts.Client(), &run); err != nil {
+		t.Fatalf("Error broadcasting run: %v", err)
+	}
+
+	var encodedMessage struct {
+		Run map[string]any `json:"run"`
+	}
+	if err := json.Unmarshal([]byte(message.Message), &encodedMessage); err != nil {
+		t.Fatalf("Error decoding inner message: %v", err)
+	}
+	if encodedMessage.Run["verdict"] != "AC" {
+		t.Errorf("message.verdict=%v, want %v", encodedMessage.Run["verdict"], "AC")
+	}
+
+	if message.FrozenMessage == "" {
+		t.Fatalf("message.FrozenMessage is empty")
+	}
+	var encodedFrozenMessage struct {
+		Run map[string]any `json:"run"`
+	}
+	if err := json.Unmarshal([]byte(message.FrozenMessage), &encodedFrozenMessage); err != nil {
+		t.Fatalf("Error decoding frozen message: %v", err)
+	}
+	if encodedFrozenMessage.Run["status"] != "frozen" {
+		t.Errorf("frozen message.status=%v, want %v", encodedFrozenMessage.Run["status"], "frozen")
+	}
+	for _, key := range []string{"verdict", "score", "contest_score", "runtime", "memory", "penalty"} {
+		if value, ok := encodedFrozenMessage.Run[key]; ok {
+			t.Errorf("frozen message.%s=%v, want it omitted", key, value)
+		}
+	}
+}
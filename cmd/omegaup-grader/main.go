@@ -27,6 +27,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/coreos/go-systemd/v22/daemon"
 	_ "github.com/go-sql-driver/mysql"
@@ -57,6 +58,9 @@ type shutdowner interface {
 type processRunStatus struct {
 	status int
 	retry  bool
+	// incompatible is set when the runner was not able to grade the run, so
+	// it needs to be sent to a different runner.
+	incompatible bool
 }
 
 // A ResponseStruct represents the result of a run request.
@@ -97,6 +101,44 @@ func peerName(r *http.Request, insecure bool) string {
 	return r.TLS.PeerCertificates[0].Subject.CommonName
 }
 
+// newArtifactCipher returns the ArtifactCipher with the keys in the
+// configuration, or nil if there are no keys. The keys in KMSKeys are
+// decrypted with KMS.
+func newArtifactCipher(config *common.GraderEncryptionConfig, sess *session.Session) (*grader.ArtifactCipher, error) {
+	if len(config.Keys) == 0 && len(config.KMSKeys) == 0 {
+		if config.KeyID != "" {
+			return nil, fmt.Errorf("encryption key %q not found", config.KeyID)
+		}
+		return nil, nil
+	}
+	keys := make(map[string][]byte)
+	for id, encoded := range config.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	if len(config.KMSKeys) > 0 {
+		kmsc := kms.New(sess)
+		for id, encoded := range config.KMSKeys {
+			if _, ok := keys[id]; ok {
+				return nil, fmt.Errorf("encryption key %q is configured twice", id)
+			}
+			ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("encryption key %q: %w", id, err)
+			}
+			output, err := kmsc.Decrypt(&kms.DecryptInput{CiphertextBlob: ciphertext})
+			if err != nil {
+				return nil, fmt.Errorf("decrypt encryption key %q: %w", id, err)
+			}
+			keys[id] = output.Plaintext
+		}
+	}
+	return grader.NewArtifactCipher(config.KeyID, keys)
+}
+
 func readGzippedFile(filename string) (string, error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -207,9 +249,10 @@ func main() {
 	}
 	ctx.Tracing = nrtracing.New(app)
 
-	var s3c *s3.S3
-	if ctx.Config.Grader.UseS3 {
-		sess, err := session.NewSession(
+	var sess *session.Session
+	if ctx.Config.Grader.UseS3 || len(ctx.Config.Grader.Encryption.KMSKeys) > 0 {
+		var err error
+		sess, err = session.NewSession(
 			aws.NewConfig().
 				WithHTTPClient(&http.Client{
 					Timeout: 5 * time.Minute,
@@ -232,9 +275,17 @@ func main() {
 			ctx.Log.Error("aws session", map[string]any{"error": err})
 			os.Exit(1)
 		}
+	}
+	var s3c *s3.S3
+	if ctx.Config.Grader.UseS3 {
 		s3c = s3.New(sess)
 	}
-	artifacts := grader.NewArtifactManager(s3c)
+	cipher, err := newArtifactCipher(&ctx.Config.Grader.Encryption, sess)
+	if err != nil {
+		ctx.Log.Error("artifact encryption", map[string]any{"error": err})
+		os.Exit(1)
+	}
+	artifacts := grader.NewArtifactManager(s3c, cipher)
 
 	expvar.Publish("codemanager", expvar.Func(func() any {
 		return graderContext().InputManager
@@ -287,21 +338,27 @@ func main() {
 		}
 	}()
 
+	auth, err := newFrontendAuth(&ctx.Config.Grader.Auth)
+	if err != nil {
+		ctx.Log.Error("frontend authentication", map[string]any{"error": err})
+		os.Exit(1)
+	}
+
 	setupMetrics(ctx)
 	var shutdowners []shutdowner
 	var wg sync.WaitGroup
 	{
 		mux := http.NewServeMux()
-		registerEphemeralHandlers(ctx, mux, ephemeralRunManager)
+		registerEphemeralHandlers(ctx, mux, auth, ephemeralRunManager)
 		shutdowners = append(
 			shutdowners,
-			registerCIHandlers(ctx, mux, ephemeralRunManager),
+			registerCIHandlers(ctx, mux, auth, ephemeralRunManager),
 		)
 		shutdowners = append(
 			shutdowners,
 			common.RunServer(
 				&ctx.Config.Grader.Ephemeral.TLS,
-				mux,
+				instrumentHandler(ctx, "ephemeral", mux),
 				&wg,
 				fmt.Sprintf(":%d", ctx.Config.Grader.Ephemeral.Port),
 				ctx.Config.Grader.Ephemeral.Proxied,
@@ -310,12 +367,12 @@ func main() {
 	}
 	{
 		mux := http.NewServeMux()
-		registerRunnerHandlers(ctx, mux, db, *insecure)
+		registerRunnerHandlers(ctx, mux, db, artifacts, *insecure)
 		shutdowners = append(
 			shutdowners,
 			common.RunServer(
 				&ctx.Config.TLS,
-				mux,
+				instrumentHandler(ctx, "runner", mux),
 				&wg,
 				fmt.Sprintf(":%d", ctx.Config.Grader.Port),
 				*insecure,
@@ -334,12 +391,28 @@ func main() {
 	newRuns <- struct{}{}
 	{
 		mux := http.DefaultServeMux
-		registerFrontendHandlers(graderContext(), mux, newRuns, db, artifacts)
+		adminMux := http.DefaultServeMux
+		if ctx.Config.Grader.AdminAddr != "" {
+			// pprof and expvar register their handlers in the default mux, so it
+			// is the one that is kept for the admin endpoints.
+			mux = http.NewServeMux()
+			shutdowners = append(
+				shutdowners,
+				common.RunServer(
+					&ctx.Config.TLS,
+					instrumentHandler(ctx, "admin", adminMux),
+					&wg,
+					ctx.Config.Grader.AdminAddr,
+					*insecure,
+				),
+			)
+		}
+		registerFrontendHandlers(graderContext(), mux, adminMux, auth, newRuns, db, artifacts)
 		shutdowners = append(
 			shutdowners,
 			common.RunServer(
 				&ctx.Config.TLS,
-				mux,
+				instrumentHandler(ctx, "frontend", mux),
 				&wg,
 				fmt.Sprintf(":%d", ctx.Config.Grader.V1.Port),
 				*insecure,
@@ -102,6 +102,40 @@ var (
 			},
 			[]string{"runner_hostname", "runner_public_ip"},
 		),
+		"grader_runner_clock_offset_seconds": prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "quark",
+				Subsystem: "grader",
+				Help:      "How far ahead of the grader's clock the clock of each runner is",
+				Name:      "runner_clock_offset_seconds",
+			},
+			[]string{"runner_hostname"},
+		),
+	}
+
+	counterVecs = map[string]*prometheus.CounterVec{
+		"grader_http_requests_total": prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "quark",
+				Subsystem: "grader",
+				Help:      "Number of HTTP requests handled, per endpoint and status code",
+				Name:      "http_requests_total",
+			},
+			[]string{"server", "endpoint", "method", "code"},
+		),
+	}
+
+	histogramVecs = map[string]*prometheus.HistogramVec{
+		"grader_http_request_duration_seconds": prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "quark",
+				Subsystem: "grader",
+				Help:      "The time it took to handle HTTP requests, per endpoint",
+				Name:      "http_request_duration_seconds",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+			},
+			[]string{"server", "endpoint", "method"},
+		),
 	}
 
 	counters = map[string]prometheus.Counter{
@@ -111,6 +145,18 @@ var (
 			Help:      "Number of graded ephemeral runs",
 			Name:      "ephemeral_runs_total",
 		}),
+		"grader_ephemeral_executions_total": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of ephemeral executions",
+			Name:      "ephemeral_executions_total",
+		}),
+		"grader_ephemeral_validations_total": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of ephemeral validator dry-runs",
+			Name:      "ephemeral_validations_total",
+		}),
 		"grader_ci_jobs_total": prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: "quark",
 			Subsystem: "grader",
@@ -135,12 +181,120 @@ var (
 			Help:      "Number of runs that were abandoned",
 			Name:      "runs_abandoned",
 		}),
+		"grader_runs_superseded_results": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of results that were discarded because their attempt had been superseded",
+			Name:      "runs_superseded_results",
+		}),
+		"grader_runs_duplicate_results": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of results that were ignored because they had already been uploaded",
+			Name:      "runs_duplicate_results",
+		}),
+		"grader_runs_rejected_uploads": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of results and artifacts that were rejected because they did not have a valid work token",
+			Name:      "runs_rejected_uploads",
+		}),
+		"grader_runs_coalesced": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of duplicate requests to grade a run that were coalesced into the one already being graded",
+			Name:      "runs_coalesced",
+		}),
+		"grader_runs_rerouted": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of runs that were sent to a different runner because the original one was not able to grade them",
+			Name:      "runs_rerouted",
+		}),
+		"grader_binary_cache_hits": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of compiled binaries that were served to runners",
+			Name:      "binary_cache_hits",
+		}),
+		"grader_binary_cache_misses": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of compiled binaries that runners requested and were not available",
+			Name:      "binary_cache_misses",
+		}),
+		"grader_regression_runs_total": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of previously-accepted runs that were re-graded to detect regressions",
+			Name:      "regression_runs_total",
+		}),
+		"grader_regressions": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of previously-accepted runs that no longer got AC when re-graded",
+			Name:      "regressions",
+		}),
+		"grader_runs_throttled": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of times a run was kept in the queue because its problem exceeded its dispatch rate",
+			Name:      "runs_throttled",
+		}),
+		"grader_broadcasts_redelivered": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of broadcast messages that were delivered from the outbox",
+			Name:      "broadcasts_redelivered",
+		}),
+		"grader_broadcasts_dropped": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of broadcast messages that were dropped because the outbox was full",
+			Name:      "broadcasts_dropped",
+		}),
+		"grader_runner_clock_skew_warnings": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of times the clock of a runner went beyond the skew threshold",
+			Name:      "runner_clock_skew_warnings",
+		}),
+		"grader_rejudge_runs_injected": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of runs of scheduled rejudges that were added to the queue",
+			Name:      "rejudge_runs_injected",
+		}),
+		"grader_runs_rejected": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of runs that were rejected because the problem does not accept their submission",
+			Name:      "runs_rejected",
+		}),
+		"grader_runs_reconciled": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of pending runs that were injected again after the grader lost track of them",
+			Name:      "runs_reconciled",
+		}),
 		"grader_runs_je": prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: "quark",
 			Subsystem: "grader",
 			Help:      "Number of runs that were JE",
 			Name:      "runs_je",
 		}),
+		"grader_runs_ve": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of runs that were VE",
+			Name:      "runs_ve",
+		}),
+		"grader_runs_problemsetter_failure": prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "quark",
+			Subsystem: "grader",
+			Help:      "Number of runs where the problemsetter's binary failed repeatedly",
+			Name:      "runs_problemsetter_failure",
+		}),
 	}
 
 	summaries = map[string]prometheus.Summary{
@@ -179,6 +333,55 @@ var (
 			Name:       "queue_high_delay_seconds",
 			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 		}),
+		"grader_run_queue_wait_seconds": prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace:  "quark",
+			Subsystem:  "grader",
+			Help:       "The time a run spent waiting in the queue",
+			Name:       "run_queue_wait_seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		"grader_run_download_seconds": prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace:  "quark",
+			Subsystem:  "grader",
+			Help:       "The time a run spent downloading the input",
+			Name:       "run_download_seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		"grader_run_compile_seconds": prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace:  "quark",
+			Subsystem:  "grader",
+			Help:       "The time a run spent compiling",
+			Name:       "run_compile_seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		"grader_run_run_seconds": prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace:  "quark",
+			Subsystem:  "grader",
+			Help:       "The time a run spent running the cases",
+			Name:       "run_run_seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		"grader_run_validate_seconds": prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace:  "quark",
+			Subsystem:  "grader",
+			Help:       "The time a run spent validating the outputs",
+			Name:       "run_validate_seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		"grader_run_upload_seconds": prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace:  "quark",
+			Subsystem:  "grader",
+			Help:       "The time a run spent uploading the results",
+			Name:       "run_upload_seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		"grader_run_db_write_seconds": prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace:  "quark",
+			Subsystem:  "grader",
+			Help:       "The time a run spent writing the results to the database",
+			Name:       "run_db_write_seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
 	}
 )
 
@@ -228,6 +431,12 @@ func setupMetrics(ctx *grader.Context) {
 	for _, gaugeVec := range gaugeVecs {
 		prometheus.MustRegister(gaugeVec)
 	}
+	for _, counterVec := range counterVecs {
+		prometheus.MustRegister(counterVec)
+	}
+	for _, histogramVec := range histogramVecs {
+		prometheus.MustRegister(histogramVec)
+	}
 	for _, counter := range counters {
 		prometheus.MustRegister(counter)
 	}
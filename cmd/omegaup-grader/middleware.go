@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/omegaup/quark/grader"
+)
+
+// statusRecorder is an http.ResponseWriter that remembers the status code of
+// the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush is needed by the handlers that stream their responses.
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// CloseNotify is needed by the handlers that wait for runs.
+func (w *statusRecorder) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+// instrumentHandler wraps the mux of one of the servers of the grader so
+// that the number of requests and their latencies are exported per endpoint,
+// and every request is written to the access log if Grader.AccessLog is set.
+// Endpoints are identified by the pattern they were registered with, so that
+// paths with IDs in them do not create a new series each.
+func instrumentHandler(ctx *grader.Context, server string, mux *http.ServeMux) http.Handler {
+	requests := counterVecs["grader_http_requests_total"]
+	durations := histogramVecs["grader_http_request_duration_seconds"]
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, endpoint := mux.Handler(r)
+		if endpoint == "" {
+			endpoint = "unknown"
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+
+		requests.WithLabelValues(server, endpoint, r.Method, strconv.Itoa(recorder.status)).Inc()
+		durations.WithLabelValues(server, endpoint, r.Method).Observe(elapsed.Seconds())
+		if ctx.Config.Grader.AccessLog {
+			ctx.Log.Info(
+				"http request",
+				map[string]any{
+					"server":   server,
+					"method":   r.Method,
+					"path":     r.URL.Path,
+					"endpoint": endpoint,
+					"status":   recorder.status,
+					"duration": elapsed.Seconds(),
+					"remote":   r.RemoteAddr,
+				},
+			)
+		}
+	})
+}
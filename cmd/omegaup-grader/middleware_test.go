@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	ctx := newGraderContext(t)
+	ctx.Config.Grader.AccessLog = true
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("response writer is not an http.Flusher")
+		}
+		if r.URL.Path == "/run/2/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "ok")
+	})
+	handler := instrumentHandler(ctx, "test", mux)
+
+	for _, requestPath := range []string{"/run/1/", "/run/2/", "/run/3/", "/missing/"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", requestPath, nil))
+	}
+
+	requests := counterVecs["grader_http_requests_total"]
+	for _, tc := range []struct {
+		endpoint string
+		code     string
+		expected float64
+	}{
+		{"/run/", "200", 2},
+		{"/run/", "404", 1},
+		{"unknown", "404", 1},
+	} {
+		if got := testutil.ToFloat64(requests.WithLabelValues("test", tc.endpoint, "GET", tc.code)); got != tc.expected {
+			t.Errorf("requests{endpoint=%q, code=%q} == %v, want %v", tc.endpoint, tc.code, got, tc.expected)
+		}
+	}
+}
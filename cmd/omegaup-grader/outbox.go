@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/broadcaster"
+	"github.com/omegaup/quark/grader"
+)
+
+// broadcastOutbox keeps the broadcast messages that the broadcaster did not
+// acknowledge on disk, and sends them again until it does. This gives
+// at-least-once delivery to the consumers that cannot afford to miss a
+// message (like the scoreboard), even across restarts of the broadcaster or
+// the grader. Consumers must tolerate getting the same message twice.
+type broadcastOutbox struct {
+	sync.Mutex
+	root    string
+	maxSize int
+
+	// The messages in the outbox are numbered sequentially. first is the
+	// number of the oldest one and next is the number of the one that will be
+	// stored next, so there are next - first messages in the outbox.
+	first uint64
+	next  uint64
+
+	// flushLock makes sure that only one Flush sends messages at a time. It
+	// is separate from the Mutex so that Put does not need to wait for the
+	// messages to be sent.
+	flushLock sync.Mutex
+}
+
+var broadcasts = &broadcastOutbox{}
+
+// setup makes the outbox store the messages in root, keeping at most maxSize
+// of them. Any messages left over from a previous run are kept. Until it is
+// called, Put does nothing.
+func (o *broadcastOutbox) setup(root string, maxSize int) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	o.Lock()
+	defer o.Unlock()
+	o.root = root
+	o.maxSize = maxSize
+	names, err := o.pending()
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		o.first, _ = strconv.ParseUint(strings.TrimSuffix(names[0], ".json"), 10, 64)
+		last, _ := strconv.ParseUint(strings.TrimSuffix(names[len(names)-1], ".json"), 10, 64)
+		o.next = last + 1
+	}
+	return nil
+}
+
+// pending returns the names of the files of the messages in the outbox, from
+// oldest to newest.
+func (o *broadcastOutbox) pending() ([]string, error) {
+	entries, err := ioutil.ReadDir(o.root)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// messagePath returns the path of the file of the message with the specified
+// number. The names are zero-padded so that they sort in the order in which
+// the messages were stored.
+func (o *broadcastOutbox) messagePath(seq uint64) string {
+	return path.Join(o.root, fmt.Sprintf("%020d.json", seq))
+}
+
+// Len returns the number of messages in the outbox.
+func (o *broadcastOutbox) Len() int {
+	o.Lock()
+	defer o.Unlock()
+	return int(o.next - o.first)
+}
+
+// Put stores the message in the outbox. If the outbox is full, the oldest
+// messages are dropped. It does nothing if the outbox is disabled.
+func (o *broadcastOutbox) Put(ctx *grader.Context, message *broadcaster.Message) error {
+	o.Lock()
+	defer o.Unlock()
+	if o.root == "" || o.maxSize <= 0 {
+		return nil
+	}
+	for o.next-o.first >= uint64(o.maxSize) {
+		ctx.Log.Error(
+			"Broadcast outbox full, dropping the oldest message",
+			map[string]any{
+				"file": o.messagePath(o.first),
+			},
+		)
+		ctx.Metrics.CounterAdd("grader_broadcasts_dropped", 1)
+		os.Remove(o.messagePath(o.first))
+		o.first++
+	}
+
+	marshaled, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(o.root, "message-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(marshaled)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), o.messagePath(o.next)); err != nil {
+		return err
+	}
+	o.next++
+	return nil
+}
+
+// remove removes the message with the specified number, and all the ones
+// before it, from the outbox. Messages that Put already dropped are ignored.
+func (o *broadcastOutbox) remove(seq uint64) {
+	o.Lock()
+	defer o.Unlock()
+	if seq < o.first {
+		return
+	}
+	os.Remove(o.messagePath(seq))
+	o.first = seq + 1
+}
+
+// Flush sends the messages that were in the outbox when it was called in
+// order, and removes the ones that the broadcaster acknowledged. It stops at
+// the first message that fails, so that it is retried before any of the newer
+// ones. The messages are sent without holding the lock, so Put can keep
+// storing new messages in the meantime. It returns the number of messages that
+// were delivered.
+func (o *broadcastOutbox) Flush(ctx *grader.Context, client *http.Client) (int, error) {
+	o.flushLock.Lock()
+	defer o.flushLock.Unlock()
+	o.Lock()
+	root, first, next := o.root, o.first, o.next
+	o.Unlock()
+	if root == "" {
+		return 0, nil
+	}
+	delivered := 0
+	for seq := first; seq < next; seq++ {
+		filePath := o.messagePath(seq)
+		contents, err := ioutil.ReadFile(filePath)
+		if os.IsNotExist(err) {
+			// Put dropped it because the outbox was full.
+			o.remove(seq)
+			continue
+		}
+		if err != nil {
+			return delivered, err
+		}
+		var message broadcaster.Message
+		if err := json.Unmarshal(contents, &message); err != nil {
+			ctx.Log.Error(
+				"Dropping corrupt message from the broadcast outbox",
+				map[string]any{
+					"file": filePath,
+					"err":  err,
+				},
+			)
+			o.remove(seq)
+			continue
+		}
+		if err := broadcast(ctx, client, &message); err != nil {
+			return delivered, err
+		}
+		o.remove(seq)
+		delivered++
+	}
+	return delivered, nil
+}
+
+// run periodically sends the messages in the outbox.
+func (o *broadcastOutbox) run(ctx *grader.Context, client *http.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Context.Context.Done():
+			return
+		case <-ticker.C:
+		}
+		delivered, err := o.Flush(ctx, client)
+		if delivered > 0 {
+			ctx.Metrics.CounterAdd("grader_broadcasts_redelivered", float64(delivered))
+			ctx.Log.Info(
+				"Delivered messages from the broadcast outbox",
+				map[string]any{
+					"count": delivered,
+				},
+			)
+		}
+		if err != nil {
+			ctx.Log.Warn(
+				"Failed to deliver messages from the broadcast outbox",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	}
+}
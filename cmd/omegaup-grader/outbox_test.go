@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/omegaup/quark/broadcaster"
+)
+
+func TestBroadcastOutbox(t *testing.T) {
+	ctx := newGraderContext(t)
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(path.Dir(ctx.Config.Grader.RuntimePath))
+	}
+
+	available := false
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var message broadcaster.Message
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Fatalf("Failed to read request from client: %v", err)
+		}
+		received = append(received, message.Contest)
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	ctx.Config.Grader.BroadcasterURL = ts.URL
+	defer ts.Close()
+
+	root := path.Join(ctx.Config.Grader.RuntimePath, "broadcast-outbox")
+	outbox := &broadcastOutbox{}
+	if err := outbox.setup(root, 2); err != nil {
+		t.Fatalf("Failed to set up the outbox: %v", err)
+	}
+	for _, contest := range []string{"contest-1", "contest-2", "contest-3"} {
+		if err := outbox.Put(ctx, &broadcaster.Message{Contest: contest}); err != nil {
+			t.Fatalf("Failed to store message: %v", err)
+		}
+	}
+
+	if delivered, err := outbox.Flush(ctx, ts.Client()); err == nil || delivered != 0 {
+		t.Errorf("outbox.Flush() == (%d, %v), want an error", delivered, err)
+	}
+	if outbox.Len() != 2 {
+		t.Errorf("outbox.Len() == %d, want 2", outbox.Len())
+	}
+
+	// The messages survive a restart of the grader.
+	outbox = &broadcastOutbox{}
+	if err := outbox.setup(root, 2); err != nil {
+		t.Fatalf("Failed to set up the outbox: %v", err)
+	}
+	if err := outbox.Put(ctx, &broadcaster.Message{Contest: "contest-4"}); err != nil {
+		t.Fatalf("Failed to store message: %v", err)
+	}
+
+	available = true
+	if delivered, err := outbox.Flush(ctx, ts.Client()); err != nil || delivered != 2 {
+		t.Errorf("outbox.Flush() == (%d, %v), want (2, nil)", delivered, err)
+	}
+	// The oldest messages are dropped when the outbox is full.
+	expected := []string{"contest-3", "contest-4"}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("received %v, want %v", received, expected)
+	}
+	if names, err := outbox.pending(); err != nil || len(names) != 0 {
+		t.Errorf("outbox.pending() == (%v, %v), want it empty", names, err)
+	}
+	if outbox.Len() != 0 {
+		t.Errorf("outbox.Len() == %d, want 0", outbox.Len())
+	}
+}
+
+func TestBroadcastOutboxPutDuringFlush(t *testing.T) {
+	ctx := newGraderContext(t)
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(path.Dir(ctx.Config.Grader.RuntimePath))
+	}
+
+	root := path.Join(ctx.Config.Grader.RuntimePath, "broadcast-outbox")
+	outbox := &broadcastOutbox{}
+	if err := outbox.setup(root, 2); err != nil {
+		t.Fatalf("Failed to set up the outbox: %v", err)
+	}
+
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var message broadcaster.Message
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Fatalf("Failed to read request from client: %v", err)
+		}
+		received = append(received, message.Contest)
+		if message.Contest == "contest-1" {
+			// The outbox is not locked while the message is sent, so new
+			// messages can be stored. They fill the outbox, which drops
+			// contest-1 and contest-2, so contest-2 is never sent.
+			for _, contest := range []string{"contest-3", "contest-4"} {
+				if err := outbox.Put(ctx, &broadcaster.Message{Contest: contest}); err != nil {
+					t.Errorf("Failed to store message: %v", err)
+				}
+			}
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	ctx.Config.Grader.BroadcasterURL = ts.URL
+	defer ts.Close()
+
+	for _, contest := range []string{"contest-1", "contest-2"} {
+		if err := outbox.Put(ctx, &broadcaster.Message{Contest: contest}); err != nil {
+			t.Fatalf("Failed to store message: %v", err)
+		}
+	}
+	if delivered, err := outbox.Flush(ctx, ts.Client()); err != nil || delivered != 1 {
+		t.Errorf("outbox.Flush() == (%d, %v), want (1, nil)", delivered, err)
+	}
+	if outbox.Len() != 2 {
+		t.Errorf("outbox.Len() == %d, want 2", outbox.Len())
+	}
+	if delivered, err := outbox.Flush(ctx, ts.Client()); err != nil || delivered != 2 {
+		t.Errorf("outbox.Flush() == (%d, %v), want (2, nil)", delivered, err)
+	}
+	expected := []string{"contest-1", "contest-3", "contest-4"}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("received %v, want %v", received, expected)
+	}
+}
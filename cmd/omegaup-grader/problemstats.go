@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+)
+
+// timeLimitMarginBuckets are the upper bounds of the buckets of the histogram
+// of how close the cases got to the time limit, as a fraction of it. The last
+// bucket holds the cases that went over the time limit.
+var timeLimitMarginBuckets = []float64{0.1, 0.25, 0.5, 0.75, 0.9, 1.0}
+
+// problemStatistics are the aggregated results of the runs of a problem.
+type problemStatistics struct {
+	Runs     int64            `json:"runs"`
+	Verdicts map[string]int64 `json:"verdicts"`
+
+	// AverageGradingTime is the average time, in seconds, that the runners
+	// spent downloading, compiling, running and uploading the results of a
+	// run. AverageQueueWait is the average time the runs waited to be picked
+	// up by a runner.
+	AverageGradingTime float64 `json:"average_grading_time"`
+	AverageQueueWait   float64 `json:"average_queue_wait"`
+
+	// TimeLimitMargins is a histogram of the CPU time of each case as a
+	// fraction of the time limit: TimeLimitMargins[i] is the number of cases
+	// whose fraction was at most TimeLimitMarginBuckets[i] (and more than the
+	// previous one). The extra final entry counts the cases that went over.
+	TimeLimitMarginBuckets []float64 `json:"time_limit_margin_buckets"`
+	TimeLimitMargins       []int64   `json:"time_limit_margins"`
+
+	// ValidatorFailures is the number of cases that got a validator error.
+	ValidatorFailures int64 `json:"validator_failures"`
+
+	LastRunTime time.Time `json:"last_run_time"`
+
+	totalGradingTime float64
+	totalQueueWait   float64
+}
+
+// problemStatisticsCollector aggregates the results of the runs of each
+// problem as they are post-processed, to help problemsetters tune the limits
+// of their problems. The statistics are kept in memory since the grader
+// started.
+type problemStatisticsCollector struct {
+	sync.Mutex
+	problems map[string]*problemStatistics
+}
+
+var problemStats = newProblemStatisticsCollector()
+
+func newProblemStatisticsCollector() *problemStatisticsCollector {
+	return &problemStatisticsCollector{
+		problems: make(map[string]*problemStatistics),
+	}
+}
+
+// Observe adds the result of the run to the statistics of its problem. Runs
+// that are not stored in the database (ephemeral runs and regression checks)
+// are ignored.
+func (c *problemStatisticsCollector) Observe(run *grader.RunInfo) {
+	if run.ID == 0 || run.Run == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	stats, ok := c.problems[run.Run.ProblemName]
+	if !ok {
+		stats = &problemStatistics{
+			Verdicts:               make(map[string]int64),
+			TimeLimitMarginBuckets: timeLimitMarginBuckets,
+			TimeLimitMargins:       make([]int64, len(timeLimitMarginBuckets)+1),
+		}
+		c.problems[run.Run.ProblemName] = stats
+	}
+
+	stats.Runs++
+	stats.Verdicts[string(run.Result.Verdict)]++
+	timings := run.Summary.RunTimings
+	stats.totalGradingTime += timings.Download + timings.Compile + timings.Run + timings.Upload
+	stats.totalQueueWait += run.Summary.QueueWait
+	stats.AverageGradingTime = stats.totalGradingTime / float64(stats.Runs)
+	stats.AverageQueueWait = stats.totalQueueWait / float64(stats.Runs)
+	stats.LastRunTime = time.Now()
+
+	for _, group := range run.Result.Groups {
+		for _, caseResult := range group.Cases {
+			if caseResult.Verdict == common.VerdictValidatorError {
+				stats.ValidatorFailures++
+			}
+			if run.TimeLimit <= 0 || caseResult.Verdict == common.VerdictSkipped || caseResult.Verdict == common.VerdictJudgeError {
+				// Skipped cases were never run, and judge errors say nothing
+				// about the limits.
+				continue
+			}
+			// The runner computes the ratio with the time limit of the case,
+			// which can be different from the one of the problem.
+			margin := caseResult.TimeLimitRatio
+			if margin == 0 {
+				margin = caseResult.Meta.Time / run.TimeLimit.Seconds()
+			}
+			bucket := sort.SearchFloat64s(timeLimitMarginBuckets, margin)
+			if caseResult.Verdict == common.VerdictTimeLimitExceeded {
+				// The sandbox might stop the program slightly before the limit.
+				bucket = len(timeLimitMarginBuckets)
+			}
+			stats.TimeLimitMargins[bucket]++
+		}
+	}
+}
+
+// Get returns a copy of the statistics of the problem, or nil if none of its
+// runs have been observed.
+func (c *problemStatisticsCollector) Get(problemName string) *problemStatistics {
+	c.Lock()
+	defer c.Unlock()
+	stats, ok := c.problems[problemName]
+	if !ok {
+		return nil
+	}
+	return stats.copy()
+}
+
+// All returns a copy of the statistics of all the problems.
+func (c *problemStatisticsCollector) All() map[string]*problemStatistics {
+	c.Lock()
+	defer c.Unlock()
+	result := make(map[string]*problemStatistics, len(c.problems))
+	for problemName, stats := range c.problems {
+		result[problemName] = stats.copy()
+	}
+	return result
+}
+
+func (s *problemStatistics) copy() *problemStatistics {
+	result := *s
+	result.Verdicts = make(map[string]int64, len(s.Verdicts))
+	for verdict, count := range s.Verdicts {
+		result.Verdicts[verdict] = count
+	}
+	result.TimeLimitMargins = append([]int64{}, s.TimeLimitMargins...)
+	return &result
+}
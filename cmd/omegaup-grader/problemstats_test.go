@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+	"github.com/omegaup/quark/runner"
+)
+
+func TestProblemStatistics(t *testing.T) {
+	collector := newProblemStatisticsCollector()
+
+	newRun := func(id int64, verdict common.Verdict, caseVerdicts []common.Verdict, caseTimes []float64) *grader.RunInfo {
+		run := &grader.RunInfo{
+			ID:        id,
+			Run:       &common.Run{ProblemName: "sumas"},
+			TimeLimit: time.Second,
+		}
+		run.Result.Verdict = verdict
+		run.Summary.QueueWait = 1
+		run.Summary.Compile = 0.5
+		run.Summary.Run = 1.5
+		group := runner.GroupResult{Group: "0"}
+		for i, caseVerdict := range caseVerdicts {
+			group.Cases = append(group.Cases, runner.CaseResult{
+				Verdict: caseVerdict,
+				Meta:    runner.RunMetadata{Time: caseTimes[i]},
+			})
+		}
+		run.Result.Groups = []runner.GroupResult{group}
+		return run
+	}
+
+	collector.Observe(newRun(1, "AC", []common.Verdict{"AC", "AC"}, []float64{0.05, 0.95}))
+	collector.Observe(newRun(2, "TLE", []common.Verdict{"AC", "TLE", "SK"}, []float64{0.3, 0.99, 0}))
+	collector.Observe(newRun(3, "VE", []common.Verdict{"VE"}, []float64{0.6}))
+	// Runs that are not in the database are ignored.
+	collector.Observe(newRun(0, "AC", []common.Verdict{"AC"}, []float64{0.1}))
+
+	if stats := collector.Get("unknown"); stats != nil {
+		t.Errorf("collector.Get(\"unknown\") == %+v, want nil", stats)
+	}
+	stats := collector.Get("sumas")
+	if stats == nil {
+		t.Fatalf("collector.Get(\"sumas\") == nil")
+	}
+	if stats.Runs != 3 {
+		t.Errorf("stats.Runs == %d, want 3", stats.Runs)
+	}
+	expectedVerdicts := map[string]int64{"AC": 1, "TLE": 1, "VE": 1}
+	if !reflect.DeepEqual(expectedVerdicts, stats.Verdicts) {
+		t.Errorf("stats.Verdicts == %v, want %v", stats.Verdicts, expectedVerdicts)
+	}
+	if stats.AverageGradingTime != 2 || stats.AverageQueueWait != 1 {
+		t.Errorf("stats == %+v, want an average grading time of 2 and queue wait of 1", stats)
+	}
+	expectedMargins := []int64{1, 0, 1, 1, 0, 1, 1}
+	if !reflect.DeepEqual(expectedMargins, stats.TimeLimitMargins) {
+		t.Errorf("stats.TimeLimitMargins == %v, want %v", stats.TimeLimitMargins, expectedMargins)
+	}
+	if stats.ValidatorFailures != 1 {
+		t.Errorf("stats.ValidatorFailures == %d, want 1", stats.ValidatorFailures)
+	}
+
+	// The returned statistics are a copy.
+	stats.Verdicts["AC"] = 100
+	if got := collector.All()["sumas"].Verdicts["AC"]; got != 1 {
+		t.Errorf("collector.All()[\"sumas\"].Verdicts[\"AC\"] == %d, want 1", got)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+)
+
+// regressionCandidate is a run that previously got AC and that will be
+// re-graded against the current version of its problem.
+type regressionCandidate struct {
+	RunID   int64
+	Problem string
+	Version string
+}
+
+// regressionFailure is a run that previously got AC and no longer does.
+type regressionFailure struct {
+	RunID   int64          `json:"run_id"`
+	Problem string         `json:"problem"`
+	Version string         `json:"version"`
+	Verdict common.Verdict `json:"verdict"`
+}
+
+// regressionReport is the result of re-grading a sample of runs.
+type regressionReport struct {
+	Reason    string              `json:"reason"`
+	StartTime time.Time           `json:"start_time"`
+	EndTime   *time.Time          `json:"end_time,omitempty"`
+	Checked   int                 `json:"checked"`
+	Failures  []regressionFailure `json:"failures"`
+}
+
+// regressionDetector re-grades a sample of runs that previously got AC after
+// the grading environment changes, and reports the ones that no longer pass.
+// The runs are graded with low priority and their results are discarded, so
+// the database is never updated.
+type regressionDetector struct {
+	sync.Mutex
+	ctx       *grader.Context
+	db        *sql.DB
+	artifacts *grader.ArtifactManager
+	runs      *grader.Queue
+	running   bool
+	report    *regressionReport
+}
+
+var regressions = &regressionDetector{}
+
+// setup provides the regressionDetector with everything it needs to re-grade
+// runs. Until it is called, Trigger does nothing. ctx must outlive the checks,
+// so it cannot be the context of a request.
+func (d *regressionDetector) setup(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	runs *grader.Queue,
+) {
+	d.Lock()
+	defer d.Unlock()
+	d.ctx = ctx
+	d.db = db
+	d.artifacts = artifacts
+	d.runs = runs
+}
+
+// Trigger starts re-grading a sample of runs in the background. It returns
+// false if the check is disabled or if there is already one in progress.
+func (d *regressionDetector) Trigger(reason string) bool {
+	d.Lock()
+	defer d.Unlock()
+	if d.ctx == nil || d.ctx.Config.Grader.V1.RegressionSampleSize <= 0 || d.running {
+		return false
+	}
+	d.running = true
+	d.report = &regressionReport{
+		Reason:    reason,
+		StartTime: time.Now(),
+		Failures:  []regressionFailure{},
+	}
+	go d.run(d.ctx, d.db, d.artifacts, d.runs, reason)
+	return true
+}
+
+// Report returns a copy of the report of the most recent check, or nil if
+// there has not been one.
+func (d *regressionDetector) Report() *regressionReport {
+	d.Lock()
+	defer d.Unlock()
+	if d.report == nil {
+		return nil
+	}
+	report := *d.report
+	report.Failures = append([]regressionFailure{}, d.report.Failures...)
+	return &report
+}
+
+func (d *regressionDetector) run(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	runs *grader.Queue,
+	reason string,
+) {
+	defer func() {
+		endTime := time.Now()
+		d.Lock()
+		d.running = false
+		d.report.EndTime = &endTime
+		d.Unlock()
+	}()
+
+	candidates, err := sampleAcceptedRuns(
+		db,
+		ctx.Config.Grader.V1.RegressionMaxProblems,
+		ctx.Config.Grader.V1.RegressionSampleSize,
+	)
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to sample runs for the regression check",
+			map[string]any{
+				"err": err,
+			},
+		)
+		return
+	}
+	ctx.Log.Info(
+		"Starting regression check",
+		map[string]any{
+			"reason": reason,
+			"runs":   len(candidates),
+		},
+	)
+
+	var wg sync.WaitGroup
+	for _, candidate := range candidates {
+		wg.Add(1)
+		go func(candidate regressionCandidate) {
+			defer wg.Done()
+			verdict, err := regradeRun(ctx, db, artifacts, runs, candidate)
+			if err != nil {
+				ctx.Log.Error(
+					"Failed to re-grade run for the regression check",
+					map[string]any{
+						"run": candidate.RunID,
+						"err": err,
+					},
+				)
+				return
+			}
+			ctx.Metrics.CounterAdd("grader_regression_runs_total", 1)
+			d.Lock()
+			d.report.Checked++
+			if verdict != common.VerdictAccepted {
+				d.report.Failures = append(d.report.Failures, regressionFailure{
+					RunID:   candidate.RunID,
+					Problem: candidate.Problem,
+					Version: candidate.Version,
+					Verdict: verdict,
+				})
+			}
+			d.Unlock()
+			if verdict != common.VerdictAccepted {
+				ctx.Metrics.CounterAdd("grader_regressions", 1)
+				ctx.Log.Warn(
+					"Previously accepted run no longer gets AC",
+					map[string]any{
+						"run":     candidate.RunID,
+						"problem": candidate.Problem,
+						"version": candidate.Version,
+						"verdict": verdict,
+					},
+				)
+			}
+		}(candidate)
+	}
+	wg.Wait()
+
+	report := d.Report()
+	ctx.Log.Info(
+		"Regression check finished",
+		map[string]any{
+			"reason":   reason,
+			"checked":  report.Checked,
+			"failures": len(report.Failures),
+		},
+	)
+}
+
+// sampleAcceptedRuns returns up to sampleSize of the most recent runs that
+// got AC in each of the maxProblems most submitted problems.
+func sampleAcceptedRuns(
+	db *sql.DB,
+	maxProblems int,
+	sampleSize int,
+) ([]regressionCandidate, error) {
+	type problem struct {
+		id      int64
+		alias   string
+		version string
+	}
+	rows, err := queryWithRetry(
+		db,
+		`
+		SELECT
+			problem_id,
+			alias,
+			current_version
+		FROM
+			Problems
+		WHERE
+			accepted > 0
+		ORDER BY
+			submissions DESC
+		LIMIT ?;
+		`,
+		maxProblems,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var problems []problem
+	for rows.Next() {
+		var p problem
+		if err := rows.Scan(&p.id, &p.alias, &p.version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		problems = append(problems, p)
+	}
+	rows.Close()
+
+	var candidates []regressionCandidate
+	for _, p := range problems {
+		rows, err := queryWithRetry(
+			db,
+			`
+			SELECT
+				r.run_id
+			FROM
+				Submissions s
+			INNER JOIN
+				Runs r ON r.run_id = s.current_run_id
+			WHERE
+				s.problem_id = ? AND r.status = 'ready' AND r.verdict = 'AC'
+			ORDER BY
+				r.run_id DESC
+			LIMIT ?;
+			`,
+			p.id,
+			sampleSize,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			candidate := regressionCandidate{
+				Problem: p.alias,
+				Version: p.version,
+			}
+			if err := rows.Scan(&candidate.RunID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			candidates = append(candidates, candidate)
+		}
+		rows.Close()
+	}
+	return candidates, nil
+}
+
+// regradeRun grades the run against the current version of its problem and
+// returns the verdict, without storing the results anywhere.
+func regradeRun(
+	ctx *grader.Context,
+	db *sql.DB,
+	artifacts *grader.ArtifactManager,
+	runs *grader.Queue,
+	candidate regressionCandidate,
+) (common.Verdict, error) {
+	runInfo, err := newRunInfoFromID(ctx, db, candidate.RunID, artifacts)
+	if err != nil {
+		return "", err
+	}
+	source, err := artifacts.Submissions.GetSource(&ctx.Context, runInfo.GUID)
+	if err != nil {
+		return "", err
+	}
+	scratch, err := artifacts.Scratch()
+	if err != nil {
+		return "", err
+	}
+	defer scratch.Clean()
+
+	// The run must not be mistaken for the original one, so that its results
+	// are never written to the database nor broadcast.
+	runInfo.ID = 0
+	runInfo.SubmissionID = 0
+	runInfo.GUID = ""
+	runInfo.Run.GUID = ""
+	runInfo.Run.Source = source
+	runInfo.Run.InputHash = candidate.Version
+	runInfo.Artifacts = scratch
+	runInfo.Priority = grader.QueuePriorityLow
+
+	inputRef, err := ctx.InputManager.Add(
+		runInfo.Run.InputHash,
+		grader.NewInputFactory(runInfo.Run.ProblemName, &ctx.Config),
+	)
+	if err != nil {
+		return "", err
+	}
+	runWaitHandle, err := runs.AddWaitableRun(&ctx.Context, runInfo, inputRef)
+	if err != nil {
+		return "", err
+	}
+	<-runWaitHandle.Ready()
+	return runInfo.Result.Verdict, nil
+}
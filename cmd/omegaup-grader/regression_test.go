@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSampleAcceptedRuns(t *testing.T) {
+	db := newInMemoryDB(t, "partial")
+
+	// Problems without any accepted runs are not sampled.
+	candidates, err := sampleAcceptedRuns(db, 10, 3)
+	if err != nil {
+		t.Fatalf("Failed to sample runs: %v", err)
+	}
+	if len(candidates) != 0 {
+		t.Errorf("candidates == %v, want none", candidates)
+	}
+
+	if _, err := execWithRetry(
+		db,
+		`
+		UPDATE Problems SET accepted = 1, submissions = 2, current_version = '2';
+		UPDATE Runs SET status = 'ready', verdict = 'AC' WHERE run_id = 1;
+		`,
+	); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+
+	candidates, err = sampleAcceptedRuns(db, 10, 3)
+	if err != nil {
+		t.Fatalf("Failed to sample runs: %v", err)
+	}
+	expected := []regressionCandidate{
+		{RunID: 1, Problem: "problem", Version: "2"},
+	}
+	if !reflect.DeepEqual(expected, candidates) {
+		t.Errorf("candidates == %v, want %v", candidates, expected)
+	}
+}
+
+func TestRegressionDetectorDisabled(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+	detector := &regressionDetector{}
+
+	if detector.Trigger("test") {
+		t.Errorf("Trigger() == true before setup, want false")
+	}
+	detector.setup(ctx, db, nil, nil)
+	ctx.Config.Grader.V1.RegressionSampleSize = 0
+	if detector.Trigger("test") {
+		t.Errorf("Trigger() == true with the check disabled, want false")
+	}
+	if report := detector.Report(); report != nil {
+		t.Errorf("Report() == %v, want nil", report)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/grader"
+)
+
+// rejudgeMaxBatchSize is the maximum number of runs of a scheduled rejudge
+// that are marked as new at once.
+const rejudgeMaxBatchSize = 500
+
+// scheduledRejudge is a rejudge that waits until its schedule says it can
+// start, and then adds its runs to the queue gradually.
+type scheduledRejudge struct {
+	ID             int64     `json:"id"`
+	Schedule       string    `json:"schedule"`
+	MaxQueueLength int       `json:"max_queue_length,omitempty"`
+	CreateTime     time.Time `json:"create_time"`
+	StartTime      time.Time `json:"start_time"`
+	Started        bool      `json:"started"`
+	Injected       int       `json:"injected"`
+	PendingRunIDs  []int64   `json:"pending_run_ids"`
+}
+
+// scheduledRejudgeStatus is what is reported about a scheduledRejudge, without
+// the (potentially very long) list of runs.
+type scheduledRejudgeStatus struct {
+	ID             int64     `json:"id"`
+	Schedule       string    `json:"schedule"`
+	MaxQueueLength int       `json:"max_queue_length"`
+	CreateTime     time.Time `json:"create_time"`
+	StartTime      time.Time `json:"start_time"`
+	Started        bool      `json:"started"`
+	Injected       int       `json:"injected"`
+	Pending        int       `json:"pending"`
+}
+
+// rejudgeScheduler holds the rejudges that were requested to happen later
+// (e.g. during off-peak hours). Once a rejudge starts, its runs are marked as
+// new in the database in batches, as long as the queue is shorter than the
+// rejudge's limit, so that the queue never gets flooded. The rejudges are
+// persisted so that they survive restarts.
+type rejudgeScheduler struct {
+	sync.Mutex
+	ctx      *grader.Context
+	db       *sql.DB
+	newRuns  chan<- struct{}
+	path     string
+	interval time.Duration
+	nextID   int64
+	jobs     map[int64]*scheduledRejudge
+}
+
+var rejudges = &rejudgeScheduler{
+	jobs: make(map[int64]*scheduledRejudge),
+}
+
+// setup loads the rejudges that are stored in statePath and starts them.
+// Until it is called, Schedule fails. ctx must outlive the rejudges, so it
+// cannot be the context of a request.
+func (s *rejudgeScheduler) setup(
+	ctx *grader.Context,
+	db *sql.DB,
+	newRuns chan<- struct{},
+	statePath string,
+	interval time.Duration,
+) error {
+	s.Lock()
+	defer s.Unlock()
+	s.ctx = ctx
+	s.db = db
+	s.newRuns = newRuns
+	s.path = statePath
+	s.interval = interval
+
+	contents, err := ioutil.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var jobs []*scheduledRejudge
+	if err := json.Unmarshal(contents, &jobs); err != nil {
+		return fmt.Errorf("load scheduled rejudges from %s: %w", statePath, err)
+	}
+	for _, job := range jobs {
+		s.jobs[job.ID] = job
+		if job.ID >= s.nextID {
+			s.nextID = job.ID + 1
+		}
+		go s.run(job)
+	}
+	return nil
+}
+
+// Schedule holds a rejudge of the runs until the next time that matches the
+// schedule, which is in the crontab format and is evaluated in the grader's
+// local time. maxQueueLength overrides the configured limit if positive.
+func (s *rejudgeScheduler) Schedule(
+	schedule string,
+	maxQueueLength int,
+	runIDs []int64,
+) (*scheduledRejudgeStatus, error) {
+	cron, err := parseCronSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	startTime := cron.Next(now)
+	if startTime.IsZero() {
+		return nil, fmt.Errorf("cron schedule %q never matches", schedule)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.ctx == nil {
+		return nil, errors.New("scheduled rejudges are not enabled")
+	}
+	job := &scheduledRejudge{
+		ID:             s.nextID,
+		Schedule:       schedule,
+		MaxQueueLength: maxQueueLength,
+		CreateTime:     now,
+		StartTime:      startTime,
+		PendingRunIDs:  append([]int64{}, runIDs...),
+	}
+	s.nextID++
+	s.jobs[job.ID] = job
+	if err := s.save(); err != nil {
+		delete(s.jobs, job.ID)
+		return nil, err
+	}
+	go s.run(job)
+	return s.status(job), nil
+}
+
+// Jobs returns the status of all the rejudges that have not finished.
+func (s *rejudgeScheduler) Jobs() []*scheduledRejudgeStatus {
+	s.Lock()
+	defer s.Unlock()
+	result := make([]*scheduledRejudgeStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		result = append(result, s.status(job))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+func (s *rejudgeScheduler) status(job *scheduledRejudge) *scheduledRejudgeStatus {
+	return &scheduledRejudgeStatus{
+		ID:             job.ID,
+		Schedule:       job.Schedule,
+		MaxQueueLength: s.maxQueueLength(job),
+		CreateTime:     job.CreateTime,
+		StartTime:      job.StartTime,
+		Started:        job.Started,
+		Injected:       job.Injected,
+		Pending:        len(job.PendingRunIDs),
+	}
+}
+
+func (s *rejudgeScheduler) maxQueueLength(job *scheduledRejudge) int {
+	if job.MaxQueueLength > 0 {
+		return job.MaxQueueLength
+	}
+	return s.ctx.Config.Grader.V1.RejudgeMaxQueueLength
+}
+
+// save writes all the rejudges to disk. The caller must hold the lock.
+func (s *rejudgeScheduler) save() error {
+	jobs := make([]*scheduledRejudge, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	contents, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(path.Dir(s.path), path.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s *rejudgeScheduler) run(job *scheduledRejudge) {
+	s.Lock()
+	ctx, started, startTime, pending := s.ctx, job.Started, job.StartTime, len(job.PendingRunIDs)
+	s.Unlock()
+
+	if !started {
+		timer := time.NewTimer(time.Until(startTime))
+		select {
+		case <-timer.C:
+		case <-ctx.Context.Context.Done():
+			timer.Stop()
+			return
+		}
+		ctx.Log.Info(
+			"Starting scheduled rejudge",
+			map[string]any{
+				"id":   job.ID,
+				"runs": pending,
+			},
+		)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		done, injected, err := s.inject(job)
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to inject the runs of a scheduled rejudge",
+				map[string]any{
+					"id":  job.ID,
+					"err": err,
+				},
+			)
+		}
+		if done {
+			ctx.Log.Info(
+				"Scheduled rejudge finished",
+				map[string]any{
+					"id":   job.ID,
+					"runs": injected,
+				},
+			)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Context.Context.Done():
+			return
+		}
+	}
+}
+
+// inject marks as new as many of the pending runs of the rejudge as fit in
+// the queue without going over its limit (and at most rejudgeMaxBatchSize),
+// and lets runQueueLoop know about them. It returns true once there are no
+// more pending runs, together with the number of runs injected so far.
+func (s *rejudgeScheduler) inject(job *scheduledRejudge) (bool, int, error) {
+	queueLength := 0
+	if queueInfo, ok := s.ctx.QueueManager.GetQueueInfo()[grader.DefaultQueueName]; ok {
+		for _, l := range queueInfo.Lengths {
+			queueLength += l
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	job.Started = true
+	batchSize := s.maxQueueLength(job) - queueLength
+	if batchSize > rejudgeMaxBatchSize {
+		batchSize = rejudgeMaxBatchSize
+	}
+	if batchSize > len(job.PendingRunIDs) {
+		batchSize = len(job.PendingRunIDs)
+	}
+	if batchSize > 0 {
+		batch := job.PendingRunIDs[:batchSize]
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		args := make([]any, len(batch))
+		for i, runID := range batch {
+			args[i] = runID
+		}
+		// Runs that are being graded right now are left alone.
+		if _, err := execWithRetry(
+			s.db,
+			fmt.Sprintf(
+				`UPDATE Runs SET status = 'new' WHERE status = 'ready' AND run_id IN (%s);`,
+				placeholders,
+			),
+			args...,
+		); err != nil {
+			return false, job.Injected, err
+		}
+		job.PendingRunIDs = job.PendingRunIDs[batchSize:]
+		job.Injected += batchSize
+		s.ctx.Metrics.CounterAdd("grader_rejudge_runs_injected", float64(batchSize))
+
+		select {
+		case s.newRuns <- struct{}{}:
+		default:
+		}
+	}
+
+	done := len(job.PendingRunIDs) == 0
+	if done {
+		delete(s.jobs, job.ID)
+	}
+	return done, job.Injected, s.save()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+func TestScheduledRejudge(t *testing.T) {
+	ctx := newGraderContext(t)
+	db := newInMemoryDB(t, "partial")
+	if _, err := execWithRetry(db, `UPDATE Runs SET status = 'ready' WHERE run_id = 1;`); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+
+	statePath := path.Join(t.TempDir(), "scheduled-rejudges.json")
+	newRuns := make(chan struct{}, 1)
+	scheduler := &rejudgeScheduler{
+		jobs: make(map[int64]*scheduledRejudge),
+	}
+	if err := scheduler.setup(ctx, db, newRuns, statePath, time.Hour); err != nil {
+		t.Fatalf("Failed to set up the scheduler: %v", err)
+	}
+
+	if _, err := scheduler.Schedule("not a schedule", 0, []int64{1}); err == nil {
+		t.Errorf("scheduler.Schedule() succeeded with an invalid schedule")
+	}
+	// The schedule is far enough into the future that the job never starts
+	// on its own during the test.
+	job, err := scheduler.Schedule("0 0 1 1 *", 1, []int64{1, 2})
+	if err != nil {
+		t.Fatalf("Failed to schedule rejudge: %v", err)
+	}
+	if job.Pending != 2 || job.Started {
+		t.Errorf("scheduled rejudge == %+v, want 2 pending runs", job)
+	}
+
+	// The jobs survive restarts.
+	restored := &rejudgeScheduler{
+		jobs: make(map[int64]*scheduledRejudge),
+	}
+	if err := restored.setup(ctx, db, newRuns, statePath, time.Hour); err != nil {
+		t.Fatalf("Failed to restore the scheduler: %v", err)
+	}
+	if jobs := restored.Jobs(); len(jobs) != 1 || jobs[0].ID != job.ID || jobs[0].Pending != 2 {
+		t.Errorf("restored.Jobs() == %+v, want %+v", jobs, job)
+	}
+
+	// Only one run fits in the queue at a time.
+	done, injected, err := scheduler.inject(scheduler.jobs[job.ID])
+	if err != nil || done || injected != 1 {
+		t.Errorf("scheduler.inject() == (%v, %v, %v), want (false, 1, nil)", done, injected, err)
+	}
+	select {
+	case <-newRuns:
+	default:
+		t.Errorf("runQueueLoop was not notified")
+	}
+	var status string
+	if err := queryRowWithRetry(db, `SELECT status FROM Runs WHERE run_id = 1;`).Scan(&status); err != nil {
+		t.Fatalf("Error reading the database: %v", err)
+	}
+	if status != "new" {
+		t.Errorf("status == %q, want \"new\"", status)
+	}
+
+	done, injected, err = scheduler.inject(scheduler.jobs[job.ID])
+	if err != nil || !done || injected != 2 {
+		t.Errorf("scheduler.inject() == (%v, %v, %v), want (true, 2, nil)", done, injected, err)
+	}
+	if jobs := scheduler.Jobs(); len(jobs) != 0 {
+		t.Errorf("scheduler.Jobs() == %+v, want none", jobs)
+	}
+}
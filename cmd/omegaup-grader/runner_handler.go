@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -19,16 +21,14 @@ import (
 )
 
 func processRun(
+	ctx *grader.Context,
+	db *sql.DB,
 	r *http.Request,
 	attemptID uint64,
 	runCtx *grader.RunContext,
 	insecure bool,
 ) *processRunStatus {
 	runnerName := peerName(r, insecure)
-	// TODO: make this a per-attempt directory so we can only commit directories
-	// that will be not retried.
-	// Best-effort deletion of the grade dir.
-	runCtx.RunInfo.Artifacts.Clean()
 	runCtx.RunInfo.Result.JudgedBy = runnerName
 
 	multipartReader, err := r.MultipartReader()
@@ -40,8 +40,9 @@ func processRun(
 				"runner": runnerName,
 			},
 		)
-		return &processRunStatus{http.StatusBadRequest, true}
+		return &processRunStatus{http.StatusBadRequest, true, false}
 	}
+	incompatible := false
 	for {
 		part, err := multipartReader.NextPart()
 		if err == io.EOF {
@@ -54,7 +55,7 @@ func processRun(
 					"runner": runnerName,
 				},
 			)
-			return &processRunStatus{http.StatusBadRequest, true}
+			return &processRunStatus{http.StatusBadRequest, true, false}
 		}
 		runCtx.Log.Debug(
 			"Processing file",
@@ -79,13 +80,101 @@ func processRun(
 						"runner": runnerName,
 					},
 				)
-				return &processRunStatus{http.StatusBadRequest, true}
+				return &processRunStatus{http.StatusBadRequest, true, false}
 			}
 			runCtx.RunInfo.Result = result
 			runCtx.RunInfo.Result.JudgedBy = runnerName
+		} else if part.FileName() == "group.json" {
+			var groupResult runner.GroupResult
+			decoder := json.NewDecoder(part)
+			decoder.UseNumber()
+			if err := decoder.Decode(&groupResult); err != nil {
+				runCtx.Log.Error(
+					"Error obtaining group result",
+					map[string]any{
+						"err":    err,
+						"runner": runnerName,
+					},
+				)
+				return &processRunStatus{http.StatusBadRequest, true, false}
+			}
+			if db == nil || !ctx.Config.Grader.V1.UpdateDatabase || runCtx.RunInfo.ID == 0 {
+				continue
+			}
+			if err := updateDatabaseGroup(ctx, db, runCtx.RunInfo, &groupResult); err != nil {
+				runCtx.Log.Error(
+					"Error updating the database with a partial result",
+					map[string]any{
+						"err":    err,
+						"group":  groupResult.Group,
+						"runner": runnerName,
+					},
+				)
+			}
+		} else if part.FileName() == "cases.jsonl" {
+			// The runner keeps this file open and writes a line for every case as
+			// soon as it has been validated.
+			decoder := json.NewDecoder(part)
+			decoder.UseNumber()
+			for {
+				var progress runner.CaseProgress
+				if err := decoder.Decode(&progress); err == io.EOF {
+					break
+				} else if err != nil {
+					// The per-case results are only used to show the progress, since
+					// the final result has all of them, so the run is still valid.
+					runCtx.Log.Error(
+						"Error obtaining case result",
+						map[string]any{
+							"err":    err,
+							"runner": runnerName,
+						},
+					)
+					break
+				}
+				ctx.InflightMonitor.AddCaseProgress(attemptID, &progress)
+			}
+		} else if part.FileName() == "timings.json" {
+			var timings runner.RunTimings
+			if err := json.NewDecoder(part).Decode(&timings); err != nil {
+				// The timings are only informative, so the run is still valid.
+				runCtx.Log.Error(
+					"Error obtaining timings",
+					map[string]any{
+						"err":    err,
+						"runner": runnerName,
+					},
+				)
+				continue
+			}
+			runCtx.RunInfo.Summary.RunTimings = timings
+		} else if part.FileName() == "capability.json" {
+			var capabilityErr runner.CapabilityError
+			if err := json.NewDecoder(part).Decode(&capabilityErr); err != nil {
+				runCtx.Log.Error(
+					"Error obtaining capability error",
+					map[string]any{
+						"err":    err,
+						"runner": runnerName,
+					},
+				)
+				return &processRunStatus{http.StatusBadRequest, true, false}
+			}
+			runCtx.Log.Warn(
+				"Runner is not able to grade run",
+				map[string]any{
+					"err":    &capabilityErr,
+					"runner": runnerName,
+				},
+			)
+			incompatible = true
 		} else if part.FileName() == "logs.txt" {
 			var buffer bytes.Buffer
-			if _, err := io.Copy(&buffer, part); err != nil {
+			var logsReader io.Reader = part
+			if ctx.Config.Grader.MaxRunLogSize > 0 {
+				logsReader = io.LimitReader(part, int64(ctx.Config.Grader.MaxRunLogSize))
+			}
+			if _, err := io.Copy(&buffer, logsReader); err != nil {
 				runCtx.Log.Error(
 					"Unable to read logs",
 					map[string]any{
@@ -93,9 +182,9 @@ func processRun(
 						"runner": runnerName,
 					},
 				)
-				return &processRunStatus{http.StatusBadRequest, true}
+				return &processRunStatus{http.StatusBadRequest, true, false}
 			}
-			runCtx.AppendLogSection(runnerName, buffer.Bytes())
+			runCtx.AppendLogSection(runnerName, clocks.Adjust(runnerName, buffer.Bytes()))
 		} else {
 			err = runCtx.RunInfo.Artifacts.Put(runCtx.Context, part.FileName(), part)
 			if err != nil {
@@ -106,7 +195,7 @@ func processRun(
 						"runner": runnerName,
 					},
 				)
-				return &processRunStatus{http.StatusBadRequest, true}
+				return &processRunStatus{http.StatusBadRequest, true, false}
 			}
 		}
 	}
@@ -119,7 +208,10 @@ func processRun(
 			"runInfo": runCtx.RunInfo,
 		},
 	)
-	if runCtx.RunInfo.Result.Verdict == "JE" {
+	if incompatible {
+		return &processRunStatus{http.StatusOK, true, true}
+	}
+	if runCtx.RunInfo.Result.Verdict == common.VerdictJudgeError {
 		// Retry the run in case it is some transient problem.
 		runCtx.Log.Info(
 			"Judge Error. Re-attempting run.",
@@ -129,21 +221,188 @@ func processRun(
 				"runInfo": runCtx.RunInfo,
 			},
 		)
-		return &processRunStatus{http.StatusOK, true}
+		return &processRunStatus{http.StatusOK, true, false}
+	}
+	if runCtx.RunInfo.Result.ProblemsetterFailure() {
+		if runCtx.RetryJudgeFailure() {
+			// The problemsetter's binary might have failed due to a transient
+			// problem, so give it one more chance.
+			runCtx.Log.Warn(
+				"Problemsetter binary failed. Re-attempting run.",
+				map[string]any{
+					"runner":  runnerName,
+					"runInfo": runCtx.RunInfo,
+				},
+			)
+			return &processRunStatus{http.StatusOK, true, false}
+		}
+		ctx.Metrics.CounterAdd("grader_runs_problemsetter_failure", 1)
+		runCtx.Log.Error(
+			"Problemsetter binary failed repeatedly",
+			map[string]any{
+				"problem": runCtx.RunInfo.Run.ProblemName,
+				"runner":  runnerName,
+				"runInfo": runCtx.RunInfo,
+			},
+		)
+	}
+	return &processRunStatus{http.StatusOK, false, false}
+}
+
+// processRunnerLogs receives the logs of an attempt that failed before the
+// runner was able to upload its results, which were requested through the
+// OmegaUp-Log-Request header.
+func processRunnerLogs(
+	ctx *grader.Context,
+	r *http.Request,
+	attemptID uint64,
+	insecure bool,
+) int {
+	runnerName := peerName(r, insecure)
+	runCtx, ok := ctx.InflightMonitor.TakeLogRequest(runnerName, attemptID)
+	if !ok {
+		return http.StatusNotFound
+	}
+	var buffer bytes.Buffer
+	if _, err := io.Copy(
+		&buffer,
+		io.LimitReader(r.Body, int64(ctx.Config.Grader.RunnerLogRequestSize)),
+	); err != nil {
+		runCtx.Log.Error(
+			"Unable to read runner logs",
+			map[string]any{
+				"err":        err,
+				"attempt_id": attemptID,
+				"runner":     runnerName,
+			},
+		)
+		return http.StatusBadRequest
+	}
+	if !runCtx.Closed() {
+		runCtx.AppendLogSection(
+			fmt.Sprintf("%s attempt %d", runnerName, attemptID),
+			clocks.Adjust(runnerName, buffer.Bytes()),
+		)
+		return http.StatusOK
+	}
+
+	// The run was already finalized, so the logs need to be stored separately.
+	err := runCtx.RunInfo.Artifacts.Put(
+		&ctx.Context,
+		fmt.Sprintf("logs.%d.txt", attemptID),
+		&buffer,
+	)
+	if err != nil {
+		ctx.Log.Error(
+			"Unable to store runner logs",
+			map[string]any{
+				"err":        err,
+				"attempt_id": attemptID,
+				"runner":     runnerName,
+			},
+		)
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// processRunArtifacts receives the files.zip of an attempt, which the runner
+// uploads separately from its results so that failing to upload it does not
+// require grading the run again. It must be uploaded before the results,
+// since those finalize the run.
+func processRunArtifacts(
+	ctx *grader.Context,
+	r *http.Request,
+	attemptID uint64,
+	insecure bool,
+) int {
+	runnerName := peerName(r, insecure)
+	runCtx, _, ok := ctx.InflightMonitor.Get(attemptID)
+	if !ok || runCtx.RunInfo.Run.AttemptID != attemptID {
+		if !ok && !ctx.InflightMonitor.Superseded(attemptID) {
+			return http.StatusNotFound
+		}
+		// Same as with the results, let the runner move on.
+		ctx.Log.Warn(
+			"Discarding artifacts of a superseded attempt",
+			map[string]any{
+				"attempt_id": attemptID,
+				"runner":     runnerName,
+			},
+		)
+		io.Copy(io.Discard, r.Body)
+		return http.StatusOK
+	}
+	if err := runCtx.RunInfo.Artifacts.Put(runCtx.Context, "files.zip", r.Body); err != nil {
+		runCtx.Log.Error(
+			"Unable to store artifacts",
+			map[string]any{
+				"err":        err,
+				"attempt_id": attemptID,
+				"runner":     runnerName,
+			},
+		)
+		return http.StatusInternalServerError
 	}
-	return &processRunStatus{http.StatusOK, false}
+	return http.StatusOK
+}
+
+// rejectUpload returns whether an upload for an attempt has to be rejected
+// because it does not have a valid work token.
+func rejectUpload(
+	ctx *grader.Context,
+	workTokens *workTokenSigner,
+	r *http.Request,
+	attemptID uint64,
+	insecure bool,
+) bool {
+	runnerName := peerName(r, insecure)
+	err := workTokens.Check(r, attemptID, runnerName)
+	if err == nil {
+		return false
+	}
+	ctx.Metrics.CounterAdd("grader_runs_rejected_uploads", 1)
+	ctx.Log.Error(
+		"Rejecting upload with an invalid work token",
+		map[string]any{
+			"err":        err,
+			"url":        r.URL.Path,
+			"attempt_id": attemptID,
+			"runner":     runnerName,
+		},
+	)
+	return true
+}
+
+// canReroute returns whether there is any known runner other than the
+// provided one that has not reported that it is not able to grade the run.
+func canReroute(runCtx *grader.RunContext, runnerName string) bool {
+	for name := range toolchains.Runners() {
+		if name != runnerName && !runCtx.IncompatibleWith(name) {
+			return true
+		}
+	}
+	return false
 }
 
 func registerRunnerHandlers(
 	ctx *grader.Context,
 	mux *http.ServeMux,
 	db *sql.DB,
+	artifacts *grader.ArtifactManager,
 	insecure bool,
 ) {
 	runs, err := ctx.QueueManager.Get(grader.DefaultQueueName)
 	if err != nil {
 		panic(err)
 	}
+	workTokens, err := newWorkTokenSigner(&ctx.Config.Grader)
+	if err != nil {
+		panic(err)
+	}
+	if ctx.Config.Grader.WorkTokenKey == "" {
+		ctx.Log.Warn("No work token key configured, in-flight runs will be rejected after a restart", nil)
+	}
 
 	mux.Handle(ctx.Tracing.WrapHandle("/monitoring/benchmark/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx = ctx.Wrap(r.Context())
@@ -175,6 +434,7 @@ func registerRunnerHandlers(
 	})))
 
 	mux.Handle(ctx.Tracing.WrapHandle("/run/request/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received := time.Now()
 		ctx = ctx.Wrap(r.Context())
 		defer r.Body.Close()
 		runnerName := peerName(r, insecure)
@@ -186,6 +446,11 @@ func registerRunnerHandlers(
 			},
 		)
 
+		if toolchains.Observe(ctx, runnerName, r.Header.Get("OmegaUp-Runner-Toolchains")) {
+			regressions.Trigger(fmt.Sprintf("toolchains of %s changed", runnerName))
+		}
+		clocks.Observe(ctx, runnerName, r.Header.Get("OmegaUp-Runner-Time"), received)
+
 		// Add the runner to the list of known runners.
 		m, ok := ctx.Metrics.(*prometheusMetrics)
 		if ok {
@@ -195,21 +460,55 @@ func registerRunnerHandlers(
 			}
 		}
 
-		runCtx, _, ok := runs.GetRun(
-			runnerName,
-			ctx.InflightMonitor,
-			w.(http.CloseNotifier).CloseNotify(),
+		runnerFeatures := common.RunnerFeatures(
+			r.Header.Get("OmegaUp-Runner-Protocol"),
+			r.Header.Get("OmegaUp-Runner-Features"),
 		)
-		if !ok {
-			ctx.Log.Debug(
-				"client gone",
-				map[string]any{
-					"client": runnerName,
-				},
+		var runCtx *grader.RunContext
+		for {
+			runCtx, _, ok = runs.GetRun(
+				runnerName,
+				ctx.InflightMonitor,
+				w.(http.CloseNotifier).CloseNotify(),
 			)
-			return
+			if !ok {
+				ctx.Log.Debug(
+					"client gone",
+					map[string]any{
+						"client": runnerName,
+					},
+				)
+				return
+			}
+			// The runner might have already reported that it is not able to
+			// grade the run, or it might not support everything the run needs.
+			incompatible := runCtx.IncompatibleWith(runnerName)
+			if missing := common.MissingRunFeatures(
+				runCtx.RunInfo.Run.RequiredFeatures(),
+				runnerFeatures,
+			); len(missing) != 0 {
+				runCtx.Log.Debug(
+					"runner does not support the features of the run",
+					map[string]any{
+						"client":   runnerName,
+						"features": common.FormatRunFeatures(missing),
+					},
+				)
+				incompatible = true
+			}
+			if !incompatible || !canReroute(runCtx, runnerName) {
+				break
+			}
+			runCtx.Reroute(runnerName)
 		}
 
+		// TODO: make this a per-attempt directory so we can only commit
+		// directories that will be not retried.
+		// Best-effort deletion of the grade dir. This is done when the run is
+		// served instead of when its results are received, since the runner
+		// uploads the artifacts before the results.
+		runCtx.RunInfo.Artifacts.Clean()
+
 		runCtx.Log.Debug(
 			"served run",
 			map[string]any{
@@ -217,30 +516,109 @@ func registerRunnerHandlers(
 				"client": runnerName,
 			},
 		)
+		runCtx.RunInfo.Run.ProtocolVersion = common.RunnerProtocolVersion
+		runCtx.RunInfo.Run.Features = runCtx.RunInfo.Run.RequiredFeatures()
 		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		w.Header().Set("OmegaUp-Grader-Protocol", strconv.Itoa(common.RunnerProtocolVersion))
+		w.Header().Set(workTokenHeader, workTokens.Issue(runCtx.RunInfo.Run.AttemptID, runnerName))
 		// TODO: Remove this.
 		w.Header().Set("Sync-ID", "0")
+		for _, attemptID := range ctx.InflightMonitor.PendingLogRequests(runnerName) {
+			w.Header().Add("OmegaUp-Log-Request", url.Values{
+				"attempt_id": {strconv.FormatUint(attemptID, 10)},
+				"max_size":   {strconv.FormatInt(int64(ctx.Config.Grader.RunnerLogRequestSize), 10)},
+			}.Encode())
+		}
 		encoder := json.NewEncoder(w)
 		runCtx.Transaction.InsertDistributedTraceHeaders(w.Header())
 		encoder.Encode(runCtx.RunInfo.Run)
 	})))
 
 	runRe := regexp.MustCompile("/run/([0-9]+)/results/?")
+	logsRe := regexp.MustCompile("/run/([0-9]+)/logs/?")
+	artifactsRe := regexp.MustCompile("/run/([0-9]+)/artifacts/?")
 	mux.Handle(ctx.Tracing.WrapHandle("/run/", http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx = ctx.Wrap(r.Context())
 		defer r.Body.Close()
+		if res := logsRe.FindStringSubmatch(r.URL.Path); res != nil {
+			attemptID, _ := strconv.ParseUint(res[1], 10, 64)
+			w.WriteHeader(processRunnerLogs(ctx, r, attemptID, insecure))
+			return
+		}
+		if res := artifactsRe.FindStringSubmatch(r.URL.Path); res != nil {
+			attemptID, _ := strconv.ParseUint(res[1], 10, 64)
+			if rejectUpload(ctx, workTokens, r, attemptID, insecure) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(processRunArtifacts(ctx, r, attemptID, insecure))
+			return
+		}
 		res := runRe.FindStringSubmatch(r.URL.Path)
 		if res == nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 		attemptID, _ := strconv.ParseUint(res[1], 10, 64)
+		if rejectUpload(ctx, workTokens, r, attemptID, insecure) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 		runCtx, _, ok := ctx.InflightMonitor.Get(attemptID)
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
+		if !ok && ctx.InflightMonitor.Completed(attemptID) {
+			// The runner is retrying an upload whose results were already
+			// processed, possibly because it did not get the response. The
+			// run must not be post-processed twice.
+			ctx.Metrics.CounterAdd("grader_runs_duplicate_results", 1)
+			ctx.Log.Warn(
+				"Ignoring duplicate results",
+				map[string]any{
+					"attempt_id": attemptID,
+					"runner":     peerName(r, insecure),
+				},
+			)
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusOK)
 			return
 		}
-		result := processRun(r, attemptID, runCtx, insecure)
+		if !ok || runCtx.RunInfo.Run.AttemptID != attemptID {
+			if !ok && !ctx.InflightMonitor.Superseded(attemptID) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			// The attempt timed out and the run was already given to another
+			// runner. Acknowledge the upload so that the runner moves on, but
+			// discard the results since they are no longer the current ones.
+			ctx.Metrics.CounterAdd("grader_runs_superseded_results", 1)
+			ctx.Log.Warn(
+				"Discarding results of a superseded attempt",
+				map[string]any{
+					"attempt_id": attemptID,
+					"runner":     peerName(r, insecure),
+				},
+			)
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !ctx.InflightMonitor.ClaimUpload(attemptID) {
+			// Another upload of the same results is still being processed.
+			ctx.Metrics.CounterAdd("grader_runs_duplicate_results", 1)
+			ctx.Log.Warn(
+				"Rejecting concurrent upload of the same results",
+				map[string]any{
+					"attempt_id": attemptID,
+					"runner":     peerName(r, insecure),
+				},
+			)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		result := processRun(ctx, db, r, attemptID, runCtx, insecure)
+		// The attempt is over, either because its results were processed or
+		// because the run will get another one, so its token cannot be used
+		// again.
+		workTokens.Spend(r.Header.Get(workTokenHeader))
 		w.WriteHeader(result.status)
 		if !result.retry {
 			// The run either finished correctly or encountered a fatal error.
@@ -248,6 +626,16 @@ func registerRunnerHandlers(
 			runCtx.Close()
 			return
 		}
+		if result.incompatible {
+			runnerName := peerName(r, insecure)
+			if canReroute(runCtx, runnerName) {
+				ctx.Metrics.CounterAdd("grader_runs_rerouted", 1)
+				runCtx.Reroute(runnerName)
+				return
+			}
+			// No other known runner is able to grade the run, so this counts as
+			// a failed attempt.
+		}
 		runCtx.Log.Error(
 			"run errored out. retrying",
 			map[string]any{
@@ -256,9 +644,69 @@ func registerRunnerHandlers(
 		)
 		// status is OK only when the runner successfully sent a JE verdict.
 		lastAttempt := result.status == http.StatusOK
+		if !lastAttempt {
+			// The runner was not able to finish uploading its results, so the
+			// logs are probably missing. Ask for them the next time it connects.
+			ctx.InflightMonitor.RequestLogs(runCtx, peerName(r, insecure))
+		}
 		runCtx.Requeue(lastAttempt)
 	}), time.Duration(5*time.Minute), "Request timed out")))
 
+	binaryRe := regexp.MustCompile("^/binary/([a-f0-9]{40})/?$")
+	mux.Handle(ctx.Tracing.WrapHandle("/binary/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = ctx.Wrap(r.Context())
+		defer r.Body.Close()
+		res := binaryRe.FindStringSubmatch(r.URL.Path)
+		if res == nil || ctx.BinaryCache == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		key := res[1]
+		switch r.Method {
+		case http.MethodGet:
+			f, err := ctx.BinaryCache.Open(key)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					ctx.Log.Error(
+						"Failed to open binary",
+						map[string]any{
+							"key": key,
+							"err": err,
+						},
+					)
+				}
+				ctx.Metrics.CounterAdd("grader_binary_cache_misses", 1)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			defer f.Close()
+			ctx.Metrics.CounterAdd("grader_binary_cache_hits", 1)
+			w.Header().Set("Content-Type", "application/gzip")
+			w.WriteHeader(http.StatusOK)
+			io.Copy(w, f)
+		case http.MethodPost:
+			if err := ctx.BinaryCache.Put(key, r.Body); err != nil {
+				ctx.Log.Error(
+					"Failed to store binary",
+					map[string]any{
+						"key":    key,
+						"runner": peerName(r, insecure),
+						"err":    err,
+					},
+				)
+				if errors.Is(err, grader.ErrBinaryTooLarge) {
+					w.WriteHeader(http.StatusRequestEntityTooLarge)
+				} else {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})))
+
 	inputRe := regexp.MustCompile("/input/(?:([a-zA-Z0-9_-]*)/)?([a-f0-9]{40})/?")
 	mux.Handle(ctx.Tracing.WrapHandle("/input/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx = ctx.Wrap(r.Context())
@@ -296,7 +744,7 @@ func registerRunnerHandlers(
 			return
 		}
 		defer inputRef.Release()
-		if err := inputRef.Input.(common.TransmittableInput).Transmit(w); err != nil {
+		if err := inputRef.Input.(common.TransmittableInput).Transmit(w, r); err != nil {
 			ctx.Log.Error(
 				"Error transmitting input",
 				map[string]any{
@@ -307,4 +755,39 @@ func registerRunnerHandlers(
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	})))
+
+	artifactRe := regexp.MustCompile("/artifact/([0-9a-f]{32})/?")
+	mux.Handle(ctx.Tracing.WrapHandle("/artifact/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = ctx.Wrap(r.Context())
+		defer r.Body.Close()
+		res := artifactRe.FindStringSubmatch(r.URL.Path)
+		if res == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		id := res[1]
+		f, err := artifacts.Uploads.Get(&ctx.Context, id)
+		if err != nil {
+			ctx.Log.Error(
+				"Artifact not found",
+				map[string]any{
+					"id":  id,
+					"err": err,
+				},
+			)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err := io.Copy(w, f); err != nil {
+			ctx.Log.Error(
+				"Error transmitting artifact",
+				map[string]any{
+					"id":  id,
+					"err": err,
+				},
+			)
+		}
+	})))
 }
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/broadcaster"
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+)
+
+// scoreboardSettings are the settings of a contest that determine its
+// standings.
+type scoreboardSettings struct {
+	Alias               string
+	StartTime           time.Time
+	FinishTime          time.Time
+	ScoreboardPercent   int64
+	ShowScoreboardAfter bool
+	ScoreMode           string
+
+	// WrongRunPenalty is added to the penalty of a problem for every run that
+	// was made before the one that got its points. It is only used with the
+	// contest_start and problem_open penalty types, since it is in minutes.
+	WrongRunPenalty int64
+
+	// PenaltyCalcPolicy is how the penalties of the problems are combined:
+	// "max" uses the largest one, and anything else adds them.
+	PenaltyCalcPolicy string
+}
+
+// scoreboardRun is a submission that counts towards the standings of a
+// contest, with the results of its current run.
+type scoreboardRun struct {
+	SubmissionID   int64
+	Username       string
+	Problem        string
+	Verdict        common.Verdict
+	Points         float64
+	Penalty        int64
+	SubmissionTime time.Time
+}
+
+// scoreboardProblem is how a contestant did in a problem of the contest.
+type scoreboardProblem struct {
+	Alias   string  `json:"alias"`
+	Points  float64 `json:"points"`
+	Penalty int64   `json:"penalty"`
+	Runs    int     `json:"runs"`
+}
+
+// scoreboardEntry is the standing of a contestant.
+type scoreboardEntry struct {
+	Place    int                 `json:"place"`
+	Username string              `json:"username"`
+	Points   float64             `json:"points"`
+	Penalty  int64               `json:"penalty"`
+	Problems []scoreboardProblem `json:"problems"`
+}
+
+// scoreboard are the standings of a contest. Frozen is set when the runs that
+// were submitted while the scoreboard is frozen were left out.
+type scoreboard struct {
+	Problemset int64             `json:"problemset"`
+	Contest    string            `json:"contest_alias"`
+	Frozen     bool              `json:"frozen"`
+	Problems   []string          `json:"problems"`
+	Ranking    []scoreboardEntry `json:"ranking"`
+}
+
+// contestStandings are the runs of a contest that its standings are computed
+// from, indexed by username, problem and submission.
+type contestStandings struct {
+	settings scoreboardSettings
+	problems []string
+	runs     map[string]map[string]map[int64]*scoreboardRun
+}
+
+func (c *contestStandings) add(run *scoreboardRun) {
+	found := false
+	for _, problem := range c.problems {
+		if problem == run.Problem {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.problems = append(c.problems, run.Problem)
+	}
+	userRuns, ok := c.runs[run.Username]
+	if !ok {
+		userRuns = make(map[string]map[int64]*scoreboardRun)
+		c.runs[run.Username] = userRuns
+	}
+	problemRuns, ok := userRuns[run.Problem]
+	if !ok {
+		problemRuns = make(map[int64]*scoreboardRun)
+		userRuns[run.Problem] = problemRuns
+	}
+	problemRuns[run.SubmissionID] = run
+}
+
+// problem returns how the contestant did in the problem, only taking into
+// account the runs that are visible, and whether any run was left out because
+// the scoreboard is frozen. Compile and judge errors do not count.
+func (c *contestStandings) problem(
+	alias string,
+	problemRuns map[int64]*scoreboardRun,
+	admin bool,
+	now time.Time,
+) (scoreboardProblem, bool) {
+	result := scoreboardProblem{Alias: alias}
+	frozen := false
+	var runs []*scoreboardRun
+	for _, run := range problemRuns {
+		if run.Verdict == common.VerdictCompileError || run.Verdict == common.VerdictJudgeError {
+			continue
+		}
+		if !admin && scoreboardFrozen(
+			run.SubmissionTime,
+			c.settings.StartTime,
+			c.settings.FinishTime,
+			c.settings.ScoreboardPercent,
+			c.settings.ShowScoreboardAfter,
+			now,
+		) {
+			frozen = true
+			continue
+		}
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].SubmissionTime.Equal(runs[j].SubmissionTime) {
+			return runs[i].SubmissionTime.Before(runs[j].SubmissionTime)
+		}
+		return runs[i].SubmissionID < runs[j].SubmissionID
+	})
+	result.Runs = len(runs)
+	for i, run := range runs {
+		if run.Points <= result.Points {
+			continue
+		}
+		result.Points = run.Points
+		result.Penalty = run.Penalty + int64(i)*c.settings.WrongRunPenalty
+	}
+	return result, frozen
+}
+
+// standings computes the standings of the contest. Only administrators get to
+// see the runs that were submitted while the scoreboard is frozen.
+func (c *contestStandings) standings(problemset int64, admin bool, now time.Time) *scoreboard {
+	result := &scoreboard{
+		Problemset: problemset,
+		Contest:    c.settings.Alias,
+		Problems:   append([]string(nil), c.problems...),
+		Ranking:    make([]scoreboardEntry, 0, len(c.runs)),
+	}
+	for username, userRuns := range c.runs {
+		entry := scoreboardEntry{
+			Username: username,
+			Problems: make([]scoreboardProblem, len(c.problems)),
+		}
+		for i, alias := range c.problems {
+			problem, frozen := c.problem(alias, userRuns[alias], admin, now)
+			result.Frozen = result.Frozen || frozen
+			entry.Problems[i] = problem
+			entry.Points += problem.Points
+			if c.settings.PenaltyCalcPolicy == "max" {
+				if problem.Penalty > entry.Penalty {
+					entry.Penalty = problem.Penalty
+				}
+			} else {
+				entry.Penalty += problem.Penalty
+			}
+		}
+		result.Ranking = append(result.Ranking, entry)
+	}
+	sort.Slice(result.Ranking, func(i, j int) bool {
+		a, b := &result.Ranking[i], &result.Ranking[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Penalty != b.Penalty {
+			return a.Penalty < b.Penalty
+		}
+		return a.Username < b.Username
+	})
+	for i := range result.Ranking {
+		entry := &result.Ranking[i]
+		if i > 0 {
+			previous := &result.Ranking[i-1]
+			if previous.Points == entry.Points && previous.Penalty == entry.Penalty {
+				entry.Place = previous.Place
+				continue
+			}
+		}
+		entry.Place = i + 1
+	}
+	return result
+}
+
+// scoreboardService keeps the standings of the contests in memory, so that
+// they are not computed from the database every time they are requested. The
+// runs of a contest are loaded from the database the first time that it is
+// needed, and then kept up to date with the runs that the grader finishes.
+type scoreboardService struct {
+	sync.Mutex
+	contests map[int64]*contestStandings
+
+	// pending are the contests that are being loaded from the database, with
+	// the runs that were observed while they were, so that they can be
+	// replayed once the load finishes instead of being lost.
+	pending map[int64]*pendingStandings
+}
+
+// pendingStandings are the runs that were observed while the contest was
+// being loaded by one or more goroutines.
+type pendingStandings struct {
+	loads int
+	runs  []*scoreboardRun
+}
+
+var scoreboards = newScoreboardService()
+
+func newScoreboardService() *scoreboardService {
+	return &scoreboardService{
+		contests: make(map[int64]*contestStandings),
+		pending:  make(map[int64]*pendingStandings),
+	}
+}
+
+// BeginLoad signals that the runs of the contest are about to be read from
+// the database. Every run observed from this point on is replayed by Load, so
+// it is not lost even if the database was read before the run finished. It
+// must be followed by either Load or AbortLoad.
+func (s *scoreboardService) BeginLoad(problemset int64) {
+	s.Lock()
+	defer s.Unlock()
+	pending, ok := s.pending[problemset]
+	if !ok {
+		pending = &pendingStandings{}
+		s.pending[problemset] = pending
+	}
+	pending.loads++
+}
+
+// AbortLoad signals that the runs of the contest could not be read from the
+// database.
+func (s *scoreboardService) AbortLoad(problemset int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.finishLoad(problemset)
+}
+
+func (s *scoreboardService) finishLoad(problemset int64) []*scoreboardRun {
+	pending, ok := s.pending[problemset]
+	if !ok {
+		return nil
+	}
+	pending.loads--
+	if pending.loads <= 0 {
+		delete(s.pending, problemset)
+	}
+	return pending.runs
+}
+
+// Load replaces the runs of the contest. The runs that were observed since
+// BeginLoad are added on top of them.
+func (s *scoreboardService) Load(
+	problemset int64,
+	settings scoreboardSettings,
+	problems []string,
+	runs []*scoreboardRun,
+) {
+	contest := &contestStandings{
+		settings: settings,
+		problems: append([]string(nil), problems...),
+		runs:     make(map[string]map[string]map[int64]*scoreboardRun),
+	}
+	for _, run := range runs {
+		contest.add(run)
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, run := range s.finishLoad(problemset) {
+		contest.add(run)
+	}
+	s.contests[problemset] = contest
+}
+
+// Observe adds the run to the standings of the contest, replacing any previous
+// run of the same submission. It returns false if the contest has not been
+// loaded and is not being loaded.
+func (s *scoreboardService) Observe(problemset int64, settings scoreboardSettings, run *scoreboardRun) bool {
+	s.Lock()
+	defer s.Unlock()
+	pending, loading := s.pending[problemset]
+	if loading {
+		pending.runs = append(pending.runs, run)
+	}
+	contest, ok := s.contests[problemset]
+	if !ok {
+		return loading
+	}
+	contest.settings = settings
+	contest.add(run)
+	return true
+}
+
+// Forget discards the standings of the contest, so that they are loaded from
+// the database again the next time they are needed.
+func (s *scoreboardService) Forget(problemset int64) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.contests, problemset)
+}
+
+// Scoreboard returns the standings of the contest, if it has been loaded.
+func (s *scoreboardService) Scoreboard(problemset int64, admin bool, now time.Time) (*scoreboard, bool) {
+	s.Lock()
+	defer s.Unlock()
+	contest, ok := s.contests[problemset]
+	if !ok {
+		return nil, false
+	}
+	return contest.standings(problemset, admin, now), true
+}
+
+// scoreboardPoints returns the points that a run gets in the scoreboard.
+func scoreboardPoints(scoreMode string, score, contestScore float64) float64 {
+	if scoreMode == "all_or_nothing" && score != 1 {
+		return 0
+	}
+	return contestScore
+}
+
+// queryScoreboardSettings reads the settings of the contest of the problemset
+// from the database. It returns sql.ErrNoRows if the problemset is not a
+// contest.
+func queryScoreboardSettings(db *sql.DB, problemset int64) (*scoreboardSettings, error) {
+	var settings scoreboardSettings
+	var penaltyType string
+	var wrongRunPenalty int64
+	err := queryRowWithRetry(
+		db,
+		`SELECT
+			c.alias, c.start_time, c.finish_time, c.scoreboard,
+			c.show_scoreboard_after, c.score_mode, c.penalty, c.penalty_type,
+			c.penalty_calc_policy
+		FROM
+			Contests c
+		WHERE
+			c.problemset_id = ?;`, problemset).Scan(
+		&settings.Alias,
+		&settings.StartTime,
+		&settings.FinishTime,
+		&settings.ScoreboardPercent,
+		&settings.ShowScoreboardAfter,
+		&settings.ScoreMode,
+		&wrongRunPenalty,
+		&penaltyType,
+		&settings.PenaltyCalcPolicy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if penaltyType == grader.PenaltyTypeContestStart || penaltyType == grader.PenaltyTypeProblemOpen {
+		settings.WrongRunPenalty = wrongRunPenalty
+	}
+	return &settings, nil
+}
+
+// loadScoreboard loads the standings of the contest of the problemset from
+// the database.
+func loadScoreboard(db *sql.DB, problemset int64) error {
+	scoreboards.BeginLoad(problemset)
+	settings, problems, runs, err := queryScoreboard(db, problemset)
+	if err != nil {
+		scoreboards.AbortLoad(problemset)
+		return err
+	}
+	scoreboards.Load(problemset, *settings, problems, runs)
+	return nil
+}
+
+// queryScoreboard reads the settings, problems and runs of the contest of the
+// problemset from the database.
+func queryScoreboard(
+	db *sql.DB,
+	problemset int64,
+) (*scoreboardSettings, []string, []*scoreboardRun, error) {
+	settings, err := queryScoreboardSettings(db, problemset)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rows, err := queryWithRetry(
+		db,
+		`
+		SELECT
+			p.alias
+		FROM
+			Problemset_Problems pp
+		INNER JOIN
+			Problems p ON p.problem_id = pp.problem_id
+		WHERE
+			pp.problemset_id = ?
+		ORDER BY
+			pp.`+"`order`"+`, p.alias;
+		`,
+		problemset,
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var problems []string
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		problems = append(problems, alias)
+	}
+	rows.Close()
+
+	rows, err = queryWithRetry(
+		db,
+		`
+		SELECT
+			s.submission_id, i.username, p.alias, r.verdict, r.score,
+			r.contest_score, r.penalty, s.time
+		FROM
+			Submissions s
+		INNER JOIN
+			Runs r ON r.run_id = s.current_run_id
+		INNER JOIN
+			Identities i ON i.identity_id = s.identity_id
+		INNER JOIN
+			Problems p ON p.problem_id = s.problem_id
+		WHERE
+			s.problemset_id = ? AND s.type = 'normal' AND r.status = 'ready';
+		`,
+		problemset,
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var runs []*scoreboardRun
+	for rows.Next() {
+		var run scoreboardRun
+		var score float64
+		var contestScore sql.NullFloat64
+		if err := rows.Scan(
+			&run.SubmissionID,
+			&run.Username,
+			&run.Problem,
+			&run.Verdict,
+			&score,
+			&contestScore,
+			&run.Penalty,
+			&run.SubmissionTime,
+		); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		run.Points = scoreboardPoints(settings.ScoreMode, score, contestScore.Float64)
+		runs = append(runs, &run)
+	}
+	rows.Close()
+
+	return settings, problems, runs, nil
+}
+
+// getScoreboard returns the standings of the contest of the problemset,
+// loading them from the database if needed.
+func getScoreboard(db *sql.DB, problemset int64, admin bool) (*scoreboard, error) {
+	if result, ok := scoreboards.Scoreboard(problemset, admin, time.Now()); ok {
+		return result, nil
+	}
+	if err := loadScoreboard(db, problemset); err != nil {
+		return nil, err
+	}
+	result, _ := scoreboards.Scoreboard(problemset, admin, time.Now())
+	return result, nil
+}
+
+// serveScoreboard writes the standings of the contest of the problemset as
+// JSON.
+func serveScoreboard(
+	ctx *grader.Context,
+	w http.ResponseWriter,
+	db *sql.DB,
+	problemset int64,
+	admin bool,
+) {
+	response, err := getScoreboard(db, problemset, admin)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to get the scoreboard",
+			map[string]any{
+				"problemset": problemset,
+				"err":        err,
+			},
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		ctx.Log.Error(
+			"Failed to encode the scoreboard",
+			map[string]any{
+				"err": err,
+			},
+		)
+	}
+}
+
+// updateScoreboard adds a finished run to the standings of its contest, and
+// broadcasts the new standing of the contestant if V1.SendBroadcast is set.
+// Runs outside of a contest, as well as runs that are not the current run of
+// their submission (like runs of other versions of the problem), are ignored.
+func updateScoreboard(
+	ctx *grader.Context,
+	db *sql.DB,
+	client *http.Client,
+	run *grader.RunInfo,
+) error {
+	if run.ID == 0 || run.Problemset == nil || run.Contest == nil {
+		return nil
+	}
+	problemset := *run.Problemset
+	settings, err := queryScoreboardSettings(db, problemset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var submissionType string
+	var currentRunID sql.NullInt64
+	scoreboardRun := &scoreboardRun{
+		SubmissionID: run.SubmissionID,
+		Problem:      run.Run.ProblemName,
+		Verdict:      run.Result.Verdict,
+	}
+	err = queryRowWithRetry(
+		db,
+		`SELECT
+			i.username, s.type, s.current_run_id, s.time, r.penalty
+		FROM
+			Runs r
+		INNER JOIN
+			Submissions s ON s.submission_id = r.submission_id
+		INNER JOIN
+			Identities i ON i.identity_id = s.identity_id
+		WHERE
+			r.run_id = ?;`, run.ID).Scan(
+		&scoreboardRun.Username,
+		&submissionType,
+		&currentRunID,
+		&scoreboardRun.SubmissionTime,
+		&scoreboardRun.Penalty,
+	)
+	if err != nil {
+		return err
+	}
+	if submissionType != "normal" || !currentRunID.Valid || currentRunID.Int64 != run.ID {
+		return nil
+	}
+	if penalty, ok := run.Penalty(); ok {
+		scoreboardRun.Penalty = penalty
+	}
+	score, contestScore := roundedScores(run)
+	scoreboardRun.Points = scoreboardPoints(
+		settings.ScoreMode,
+		base.RationalToFloat(score),
+		base.RationalToFloat(contestScore),
+	)
+
+	if !scoreboards.Observe(problemset, *settings, scoreboardRun) {
+		// The contest was not loaded yet. The database already has the run,
+		// so loading the contest also adds it.
+		if err := loadScoreboard(db, problemset); err != nil {
+			return err
+		}
+	}
+
+	if !ctx.Config.Grader.V1.SendBroadcast {
+		return nil
+	}
+	return broadcastScoreboardEntry(ctx, client, problemset, settings.Alias, scoreboardRun.Username)
+}
+
+// broadcastScoreboardEntry broadcasts the standing of the contestant to the
+// subscribers of the contest. While the scoreboard is frozen, the ones that
+// are not administrators of the contest get the frozen standing instead.
+func broadcastScoreboardEntry(
+	ctx *grader.Context,
+	client *http.Client,
+	problemset int64,
+	contest string,
+	username string,
+) error {
+	now := time.Now()
+	type scoreboardUpdateMessage struct {
+		Message    string           `json:"message"`
+		Problemset int64            `json:"problemset"`
+		Contest    string           `json:"contest_alias"`
+		Entry      *scoreboardEntry `json:"entry"`
+	}
+	marshalEntry := func(admin bool) (string, bool, error) {
+		result, ok := scoreboards.Scoreboard(problemset, admin, now)
+		if !ok {
+			return "", false, errors.New("scoreboard not loaded")
+		}
+		msg := scoreboardUpdateMessage{
+			Message:    "/scoreboard/update/",
+			Problemset: problemset,
+			Contest:    contest,
+		}
+		for i := range result.Ranking {
+			if result.Ranking[i].Username == username {
+				msg.Entry = &result.Ranking[i]
+				break
+			}
+		}
+		marshaled, err := json.Marshal(&msg)
+		return string(marshaled), result.Frozen, err
+	}
+
+	message := broadcaster.Message{
+		Contest:    contest,
+		Problemset: problemset,
+		Public:     true,
+	}
+	var err error
+	message.Message, _, err = marshalEntry(true)
+	if err != nil {
+		return err
+	}
+	frozenMessage, frozen, err := marshalEntry(false)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		message.FrozenMessage = frozenMessage
+	}
+	return broadcast(ctx, client, &message)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/grader"
+	"github.com/omegaup/quark/runner"
+)
+
+func TestContestStandings(t *testing.T) {
+	startTime := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	finishTime := startTime.Add(4 * time.Hour)
+	freezeTime := startTime.Add(3 * time.Hour)
+	settings := scoreboardSettings{
+		Alias:               "contest",
+		StartTime:           startTime,
+		FinishTime:          finishTime,
+		ScoreboardPercent:   75,
+		ShowScoreboardAfter: true,
+		WrongRunPenalty:     20,
+	}
+	scoreboards := newScoreboardService()
+	scoreboards.Load(
+		1,
+		settings,
+		[]string{"a", "b"},
+		[]*scoreboardRun{
+			{SubmissionID: 1, Username: "alice", Problem: "a", Verdict: "WA", Points: 0, Penalty: 10, SubmissionTime: startTime.Add(10 * time.Minute)},
+			{SubmissionID: 2, Username: "alice", Problem: "a", Verdict: "CE", Points: 0, Penalty: 15, SubmissionTime: startTime.Add(15 * time.Minute)},
+			{SubmissionID: 3, Username: "alice", Problem: "a", Verdict: "AC", Points: 100, Penalty: 30, SubmissionTime: startTime.Add(30 * time.Minute)},
+			{SubmissionID: 4, Username: "bob", Problem: "a", Verdict: "AC", Points: 100, Penalty: 50, SubmissionTime: startTime.Add(50 * time.Minute)},
+			{SubmissionID: 5, Username: "carol", Problem: "b", Verdict: "PA", Points: 40, Penalty: 20, SubmissionTime: startTime.Add(20 * time.Minute)},
+		},
+	)
+
+	// alice and bob are tied: alice got one wrong run before solving a.
+	standings, ok := scoreboards.Scoreboard(1, false, freezeTime)
+	if !ok {
+		t.Fatalf("scoreboard of problemset 1 not found")
+	}
+	for _, expected := range []scoreboardEntry{
+		{Place: 1, Username: "alice", Points: 100, Penalty: 50},
+		{Place: 1, Username: "bob", Points: 100, Penalty: 50},
+		{Place: 3, Username: "carol", Points: 40, Penalty: 20},
+	} {
+		entry := findScoreboardEntry(standings, expected.Username)
+		if entry == nil || entry.Place != expected.Place || entry.Points != expected.Points || entry.Penalty != expected.Penalty {
+			t.Errorf("entry == %+v, want %+v", entry, expected)
+		}
+	}
+	if entry := findScoreboardEntry(standings, "alice"); entry != nil && entry.Problems[0].Runs != 2 {
+		t.Errorf("alice's runs of a == %d, want 2", entry.Problems[0].Runs)
+	}
+
+	// Runs submitted after the freeze are only visible to administrators.
+	scoreboards.Observe(1, settings, &scoreboardRun{
+		SubmissionID: 6, Username: "carol", Problem: "b", Verdict: "AC", Points: 100, Penalty: 200, SubmissionTime: freezeTime.Add(20 * time.Minute),
+	})
+	now := freezeTime.Add(30 * time.Minute)
+	public, _ := scoreboards.Scoreboard(1, false, now)
+	if entry := findScoreboardEntry(public, "carol"); !public.Frozen || entry.Points != 40 {
+		t.Errorf("public carol == %+v, frozen %v, want 40 points while frozen", entry, public.Frozen)
+	}
+	admin, _ := scoreboards.Scoreboard(1, true, now)
+	if entry := findScoreboardEntry(admin, "carol"); admin.Frozen || entry.Points != 100 || entry.Penalty != 220 {
+		t.Errorf("admin carol == %+v, frozen %v, want 100 points and 220 penalty", entry, admin.Frozen)
+	}
+	public, _ = scoreboards.Scoreboard(1, false, finishTime.Add(time.Minute))
+	if entry := findScoreboardEntry(public, "carol"); public.Frozen || entry.Points != 100 {
+		t.Errorf("public carol after the contest == %+v, frozen %v, want 100 points", entry, public.Frozen)
+	}
+
+	// A rejudged run replaces the previous run of the submission.
+	scoreboards.Observe(1, settings, &scoreboardRun{
+		SubmissionID: 4, Username: "bob", Problem: "a", Verdict: "WA", Points: 0, Penalty: 50, SubmissionTime: startTime.Add(50 * time.Minute),
+	})
+	admin, _ = scoreboards.Scoreboard(1, true, now)
+	if entry := findScoreboardEntry(admin, "bob"); entry.Points != 0 || entry.Penalty != 0 {
+		t.Errorf("bob after the rejudge == %+v, want no points", entry)
+	}
+
+	if _, ok := scoreboards.Scoreboard(2, false, now); ok {
+		t.Errorf("scoreboard of problemset 2 found")
+	}
+}
+
+func TestScoreboardObserveWhileLoading(t *testing.T) {
+	startTime := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	settings := scoreboardSettings{
+		Alias:      "contest",
+		StartTime:  startTime,
+		FinishTime: startTime.Add(4 * time.Hour),
+	}
+	scoreboards := newScoreboardService()
+	now := startTime.Add(time.Hour)
+
+	// A run that finishes after the database was read, but before the
+	// standings are installed, is replayed on top of them.
+	scoreboards.BeginLoad(1)
+	if !scoreboards.Observe(1, settings, &scoreboardRun{
+		SubmissionID: 2, Username: "bob", Problem: "a", Verdict: "AC", Points: 100, SubmissionTime: startTime.Add(20 * time.Minute),
+	}) {
+		t.Errorf("Observe() == false while the contest is being loaded")
+	}
+	scoreboards.Load(
+		1,
+		settings,
+		[]string{"a"},
+		[]*scoreboardRun{
+			{SubmissionID: 1, Username: "alice", Problem: "a", Verdict: "AC", Points: 100, SubmissionTime: startTime.Add(10 * time.Minute)},
+		},
+	)
+	standings, _ := scoreboards.Scoreboard(1, false, now)
+	if entry := findScoreboardEntry(standings, "bob"); entry == nil || entry.Points != 100 {
+		t.Errorf("bob == %+v, want 100 points", entry)
+	}
+
+	// Reloading the standings does not lose the runs observed meanwhile.
+	scoreboards.BeginLoad(1)
+	scoreboards.Observe(1, settings, &scoreboardRun{
+		SubmissionID: 3, Username: "carol", Problem: "a", Verdict: "PA", Points: 40, SubmissionTime: startTime.Add(30 * time.Minute),
+	})
+	scoreboards.Load(1, settings, []string{"a"}, nil)
+	standings, _ = scoreboards.Scoreboard(1, false, now)
+	if entry := findScoreboardEntry(standings, "carol"); entry == nil || entry.Points != 40 {
+		t.Errorf("carol == %+v, want 40 points", entry)
+	}
+
+	// Once the load is aborted, the runs are no longer buffered.
+	scoreboards.BeginLoad(2)
+	scoreboards.AbortLoad(2)
+	if scoreboards.Observe(2, settings, &scoreboardRun{SubmissionID: 4, Username: "dave", Problem: "a"}) {
+		t.Errorf("Observe() == true for a contest that was not loaded")
+	}
+}
+
+func findScoreboardEntry(s *scoreboard, username string) *scoreboardEntry {
+	for i := range s.Ranking {
+		if s.Ranking[i].Username == username {
+			return &s.Ranking[i]
+		}
+	}
+	return nil
+}
+
+func TestUpdateScoreboard(t *testing.T) {
+	ctx := newGraderContext(t)
+	ctx.Config.Grader.V1.SendBroadcast = false
+	db := newInMemoryDB(t, "partial")
+	if _, err := execWithRetry(
+		db,
+		`UPDATE Submissions SET problemset_id = 1 WHERE submission_id = 1;`,
+	); err != nil {
+		t.Fatalf("Failed to update the submission: %v", err)
+	}
+	scoreboards.Forget(1)
+	defer scoreboards.Forget(1)
+
+	contest := "contest"
+	problemset := int64(1)
+	run := &grader.RunInfo{
+		ID:           1,
+		SubmissionID: 1,
+		GUID:         "1",
+		Contest:      &contest,
+		Problemset:   &problemset,
+		Run:          &common.Run{ProblemName: "problem"},
+		PenaltyType:  "none",
+		ScoreMode:    "partial",
+		Result: runner.RunResult{
+			Verdict:      "AC",
+			Score:        big.NewRat(1, 1),
+			ContestScore: big.NewRat(1, 1),
+			MaxScore:     big.NewRat(1, 1),
+		},
+	}
+	if err := updateDatabase(ctx, db, "ready", run); err != nil {
+		t.Fatalf("Failed to update the database: %v", err)
+	}
+	if err := updateScoreboard(ctx, db, nil, run); err != nil {
+		t.Fatalf("Failed to update the scoreboard: %v", err)
+	}
+
+	standings, err := getScoreboard(db, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to get the scoreboard: %v", err)
+	}
+	if len(standings.Problems) != 1 || standings.Problems[0] != "problem" {
+		t.Errorf("problems == %v, want [problem]", standings.Problems)
+	}
+	entry := findScoreboardEntry(standings, "identity")
+	if entry == nil || entry.Place != 1 || entry.Points != 1 {
+		t.Errorf("entry == %+v, want the identity in first place with 1 point", entry)
+	}
+
+	// Runs outside of a contest are ignored.
+	run.Problemset = nil
+	if err := updateScoreboard(ctx, db, nil, run); err != nil {
+		t.Errorf("Failed to update the scoreboard: %v", err)
+	}
+
+	if _, err := getScoreboard(db, 2, false); err == nil {
+		t.Errorf("scoreboard of a problemset that is not a contest found")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/grader"
+)
+
+// observedToolchains are the toolchain versions that a runner reported.
+type observedToolchains struct {
+	versions map[string]string
+	header   string
+	lastSeen time.Time
+}
+
+// toolchainInventory keeps track of the versions of the compilers and
+// interpreters that are installed in each runner, so that version skew
+// between runners can be diagnosed.
+type toolchainInventory struct {
+	sync.Mutex
+	runners map[string]observedToolchains
+}
+
+var toolchains = newToolchainInventory()
+
+func newToolchainInventory() *toolchainInventory {
+	return &toolchainInventory{
+		runners: make(map[string]observedToolchains),
+	}
+}
+
+// Observe records the toolchain versions that the runner sent in the
+// OmegaUp-Runner-Toolchains header. It returns true if the versions of a
+// runner that had already been seen changed.
+func (i *toolchainInventory) Observe(ctx *grader.Context, runnerName, header string) bool {
+	if header == "" {
+		return false
+	}
+
+	i.Lock()
+	previous, ok := i.runners[runnerName]
+	if ok && previous.header == header {
+		previous.lastSeen = time.Now()
+		i.runners[runnerName] = previous
+		i.Unlock()
+		return false
+	}
+	i.Unlock()
+
+	var versions map[string]string
+	if err := json.Unmarshal([]byte(header), &versions); err != nil {
+		ctx.Log.Warn(
+			"Invalid runner toolchains",
+			map[string]any{
+				"runner": runnerName,
+				"err":    err,
+			},
+		)
+		return false
+	}
+
+	i.Lock()
+	i.runners[runnerName] = observedToolchains{
+		versions: versions,
+		header:   header,
+		lastSeen: time.Now(),
+	}
+	i.Unlock()
+
+	if !ok {
+		ctx.Log.Info(
+			"Runner toolchains registered",
+			map[string]any{
+				"runner":     runnerName,
+				"toolchains": versions,
+			},
+		)
+		return false
+	}
+	ctx.Log.Info(
+		"Runner toolchains changed",
+		map[string]any{
+			"runner":   runnerName,
+			"previous": previous.versions,
+			"current":  versions,
+		},
+	)
+	return true
+}
+
+// Runners returns the toolchain versions of each one of the runners that have
+// been seen recently.
+func (i *toolchainInventory) Runners() map[string]map[string]string {
+	cutoffTime := time.Now().Add(-3 * time.Minute)
+	result := make(map[string]map[string]string)
+	i.Lock()
+	defer i.Unlock()
+	for runnerName, observed := range i.runners {
+		if observed.lastSeen.Before(cutoffTime) {
+			delete(i.runners, runnerName)
+			continue
+		}
+		result[runnerName] = observed.versions
+	}
+	return result
+}
+
+// skew returns, for each toolchain that has more than one version across the
+// provided runners, the sorted list of runners that have each version.
+func skew(runners map[string]map[string]string) map[string]map[string][]string {
+	versions := make(map[string]map[string][]string)
+	for runnerName, toolchains := range runners {
+		for toolchain, version := range toolchains {
+			if _, ok := versions[toolchain]; !ok {
+				versions[toolchain] = make(map[string][]string)
+			}
+			versions[toolchain][version] = append(versions[toolchain][version], runnerName)
+		}
+	}
+	for toolchain, runnersByVersion := range versions {
+		if len(runnersByVersion) < 2 {
+			delete(versions, toolchain)
+			continue
+		}
+		for _, runnerNames := range runnersByVersion {
+			sort.Strings(runnerNames)
+		}
+	}
+	return versions
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToolchainInventory(t *testing.T) {
+	ctx := newGraderContext(t)
+	inventory := newToolchainInventory()
+
+	inventory.Observe(ctx, "runner-1", `{"gcc":"gcc 10.2.1","python3":"Python 3.9.2"}`)
+	inventory.Observe(ctx, "runner-2", `{"gcc":"gcc 10.2.1","python3":"Python 3.9.2"}`)
+	inventory.Observe(ctx, "runner-3", `{"gcc":"gcc 10.2.1","python3":"Python 3.9.2"}`)
+	inventory.Observe(ctx, "runner-4", `not json`)
+	inventory.Observe(ctx, "runner-5", ``)
+
+	// runner-2 was upgraded.
+	inventory.Observe(ctx, "runner-2", `{"gcc":"gcc 12.2.0","python3":"Python 3.9.2"}`)
+
+	runners := inventory.Runners()
+	expectedRunners := map[string]map[string]string{
+		"runner-1": {"gcc": "gcc 10.2.1", "python3": "Python 3.9.2"},
+		"runner-2": {"gcc": "gcc 12.2.0", "python3": "Python 3.9.2"},
+		"runner-3": {"gcc": "gcc 10.2.1", "python3": "Python 3.9.2"},
+	}
+	if !reflect.DeepEqual(expectedRunners, runners) {
+		t.Errorf("inventory.Runners() == %v, want %v", runners, expectedRunners)
+	}
+
+	expectedSkew := map[string]map[string][]string{
+		"gcc": {
+			"gcc 10.2.1": {"runner-1", "runner-3"},
+			"gcc 12.2.0": {"runner-2"},
+		},
+	}
+	if got := skew(runners); !reflect.DeepEqual(expectedSkew, got) {
+		t.Errorf("skew() == %v, want %v", got, expectedSkew)
+	}
+}
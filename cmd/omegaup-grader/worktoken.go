@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/common"
+)
+
+// workTokenHeader is the header with the work token of an attempt. The grader
+// sends it together with the run, and the runner sends it back with the
+// results and the artifacts of the attempt.
+const workTokenHeader = "OmegaUp-Work-Token"
+
+// errMissingWorkToken is returned when a request that requires a work token
+// does not have one.
+var errMissingWorkToken = errors.New("missing work token")
+
+// workTokenSigner issues and verifies the work tokens, which prove that an
+// attempt was given to the runner that is uploading its results, and that it
+// was given recently enough.
+//
+// A token is the attempt ID, the time when it expires in seconds since the
+// epoch, and the hex-encoded HMAC-SHA256 of both and the name of the runner,
+// separated by dots. The name of the runner is not part of the token, since
+// the grader already knows who is uploading.
+//
+// Once the results of an attempt are processed, its token is spent and any
+// other upload with it is rejected, so that the results cannot be replayed.
+type workTokenSigner struct {
+	key     []byte
+	ttl     time.Duration
+	require bool
+	now     func() time.Time
+
+	spentLock sync.Mutex
+	// spent maps the tokens that were already spent to the time at which
+	// they expire. They are forgotten once they expire, since they are not
+	// valid anymore anyway.
+	spent map[string]time.Time
+}
+
+// newWorkTokenSigner returns the workTokenSigner with the key in the
+// configuration. If there is no key, a random one is used, so the tokens
+// stop being valid when the grader restarts. Production graders must
+// configure a key.
+func newWorkTokenSigner(config *common.GraderConfig) (*workTokenSigner, error) {
+	var key []byte
+	if config.WorkTokenKey == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate the work token key: %w", err)
+		}
+	} else {
+		var err error
+		key, err = base64.StdEncoding.DecodeString(config.WorkTokenKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid work token key: %w", err)
+		}
+		if len(key) < 16 {
+			return nil, fmt.Errorf("work token key is %d bytes long, want at least 16", len(key))
+		}
+	}
+	if config.WorkTokenTTL <= 0 {
+		return nil, fmt.Errorf("invalid work token TTL %v", config.WorkTokenTTL)
+	}
+	return &workTokenSigner{
+		key:     key,
+		ttl:     time.Duration(config.WorkTokenTTL),
+		require: config.RequireWorkTokens,
+		now:     time.Now,
+		spent:   make(map[string]time.Time),
+	}, nil
+}
+
+func (s *workTokenSigner) mac(attemptID uint64, expires int64, runnerName string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%d\n%d\n%s", attemptID, expires, runnerName)
+	return mac.Sum(nil)
+}
+
+// Issue returns the token for an attempt that is being given to a runner.
+func (s *workTokenSigner) Issue(attemptID uint64, runnerName string) string {
+	expires := s.now().Add(s.ttl).Unix()
+	return fmt.Sprintf(
+		"%d.%d.%s",
+		attemptID,
+		expires,
+		hex.EncodeToString(s.mac(attemptID, expires, runnerName)),
+	)
+}
+
+// Verify returns an error if the token was not issued for the attempt and
+// the runner, or if it has already expired.
+func (s *workTokenSigner) Verify(token string, attemptID uint64, runnerName string) error {
+	if token == "" {
+		return errMissingWorkToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed work token")
+	}
+	tokenAttemptID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed work token attempt ID: %w", err)
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed work token expiration: %w", err)
+	}
+	signature, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed work token signature: %w", err)
+	}
+	if !hmac.Equal(signature, s.mac(tokenAttemptID, expires, runnerName)) {
+		return errors.New("work token signature mismatch")
+	}
+	if tokenAttemptID != attemptID {
+		return fmt.Errorf("work token is for attempt %d", tokenAttemptID)
+	}
+	if expired := time.Unix(expires, 0); !s.now().Before(expired) {
+		return fmt.Errorf("work token expired at %v", expired)
+	}
+	s.spentLock.Lock()
+	defer s.spentLock.Unlock()
+	if _, ok := s.spent[token]; ok {
+		return errors.New("work token was already used")
+	}
+	return nil
+}
+
+// Spend marks the token as used, once the results of its attempt were
+// processed. Verify rejects it from then on.
+func (s *workTokenSigner) Spend(token string) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+	now := s.now()
+	s.spentLock.Lock()
+	defer s.spentLock.Unlock()
+	for spentToken, expired := range s.spent {
+		if !now.Before(expired) {
+			delete(s.spent, spentToken)
+		}
+	}
+	s.spent[token] = time.Unix(expires, 0)
+}
+
+// Check verifies the work token of an upload for an attempt, and returns
+// whether the upload can be accepted. Uploads without a token are only
+// accepted if tokens are not required, so that runners that predate them can
+// still be used while they are upgraded. Tokens are required by default.
+func (s *workTokenSigner) Check(r *http.Request, attemptID uint64, runnerName string) error {
+	err := s.Verify(r.Header.Get(workTokenHeader), attemptID, runnerName)
+	if errors.Is(err, errMissingWorkToken) && !s.require {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/omegaup/quark/common"
+)
+
+func TestWorkTokens(t *testing.T) {
+	config := common.DefaultConfig().Grader
+	signer, err := newWorkTokenSigner(&config)
+	if err != nil {
+		t.Fatalf("Failed to create the work token signer: %v", err)
+	}
+	now := time.Now()
+	signer.now = func() time.Time { return now }
+	token := signer.Issue(1, "runner")
+
+	for _, tc := range []struct {
+		name       string
+		token      string
+		attemptID  uint64
+		runnerName string
+		elapsed    time.Duration
+		valid      bool
+	}{
+		{"valid", token, 1, "runner", 0, true},
+		{"almost expired", token, 1, "runner", time.Hour - time.Second, true},
+		{"expired", token, 1, "runner", time.Hour, false},
+		{"other attempt", token, 2, "runner", 0, false},
+		{"other runner", token, 1, "other", 0, false},
+		{"forged attempt", "2" + token[1:], 2, "runner", 0, false},
+		{"malformed", "token", 1, "runner", 0, false},
+		{"missing", "", 1, "runner", 0, false},
+	} {
+		signer.now = func() time.Time { return now.Add(tc.elapsed) }
+		err := signer.Verify(tc.token, tc.attemptID, tc.runnerName)
+		if tc.valid && err != nil {
+			t.Errorf("%s: Verify() == %v, want nil", tc.name, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("%s: Verify() succeeded, want an error", tc.name)
+		}
+	}
+	signer.now = func() time.Time { return now }
+
+	// Tokens from another grader are not valid.
+	other, err := newWorkTokenSigner(&config)
+	if err != nil {
+		t.Fatalf("Failed to create the work token signer: %v", err)
+	}
+	if err := other.Verify(token, 1, "runner"); err == nil {
+		t.Errorf("Verify() with another key succeeded, want an error")
+	}
+
+	// Uploads without a token are rejected unless tokens are not required.
+	r := httptest.NewRequest("POST", "/run/1/results/", nil)
+	if err := signer.Check(r, 1, "runner"); err == nil {
+		t.Errorf("Check() without a required token succeeded, want an error")
+	}
+	signer.require = false
+	if err := signer.Check(r, 1, "runner"); err != nil {
+		t.Errorf("Check() without a token == %v, want nil", err)
+	}
+	signer.require = true
+	r.Header.Set(workTokenHeader, token)
+	if err := signer.Check(r, 1, "runner"); err != nil {
+		t.Errorf("Check() == %v, want nil", err)
+	}
+
+	// Once the attempt is over, the token cannot be used again.
+	signer.Spend(token)
+	if err := signer.Check(r, 1, "runner"); err == nil {
+		t.Errorf("Check() with a spent token succeeded, want an error")
+	}
+	// Spent tokens are forgotten once they expire.
+	signer.now = func() time.Time { return now.Add(time.Hour) }
+	signer.Spend(signer.Issue(2, "runner"))
+	if _, ok := signer.spent[token]; ok {
+		t.Errorf("expired token is still spent")
+	}
+
+	config.WorkTokenKey = "c2hvcnQ="
+	if _, err := newWorkTokenSigner(&config); err == nil {
+		t.Errorf("newWorkTokenSigner() with a short key succeeded, want an error")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+
+	"github.com/pkg/errors"
+)
+
+// logArchive keeps the logs of the most recent attempts, so that they can be
+// sent to the grader if it requests them. This is useful for runs that failed
+// before the results could be uploaded.
+type logArchive struct {
+	sync.Mutex
+	maxSize    base.Byte
+	size       base.Byte
+	attemptIDs []uint64
+	logs       map[uint64][]byte
+}
+
+func newLogArchive(maxSize base.Byte) *logArchive {
+	return &logArchive{
+		maxSize: maxSize,
+		logs:    make(map[uint64][]byte),
+	}
+}
+
+// Add stores a copy of the logs for the specified attempt ID, evicting the
+// logs of the oldest attempts if needed.
+func (a *logArchive) Add(attemptID uint64, contents []byte) {
+	if a.maxSize == 0 {
+		return
+	}
+	if base.Byte(len(contents)) > a.maxSize {
+		contents = contents[len(contents)-int(a.maxSize):]
+	}
+	a.Lock()
+	defer a.Unlock()
+	if _, ok := a.logs[attemptID]; ok {
+		return
+	}
+	for a.size+base.Byte(len(contents)) > a.maxSize && len(a.attemptIDs) > 0 {
+		oldest := a.attemptIDs[0]
+		a.attemptIDs = a.attemptIDs[1:]
+		a.size -= base.Byte(len(a.logs[oldest]))
+		delete(a.logs, oldest)
+	}
+	a.logs[attemptID] = append([]byte(nil), contents...)
+	a.attemptIDs = append(a.attemptIDs, attemptID)
+	a.size += base.Byte(len(contents))
+}
+
+// Get returns at most the last maxSize bytes of the logs for the specified
+// attempt ID.
+func (a *logArchive) Get(attemptID uint64, maxSize base.Byte) ([]byte, bool) {
+	a.Lock()
+	defer a.Unlock()
+	contents, ok := a.logs[attemptID]
+	if !ok {
+		return nil, false
+	}
+	if maxSize > 0 && base.Byte(len(contents)) > maxSize {
+		contents = contents[len(contents)-int(maxSize):]
+	}
+	return contents, true
+}
+
+var logs *logArchive
+
+// sendRequestedLogs sends the logs that the grader requested through the
+// OmegaUp-Log-Request headers.
+func sendRequestedLogs(
+	ctx *common.Context,
+	client *http.Client,
+	baseURL *url.URL,
+	header http.Header,
+) {
+	for _, rawRequest := range header.Values("OmegaUp-Log-Request") {
+		if err := sendRequestedLog(ctx, client, baseURL, rawRequest); err != nil {
+			ctx.Log.Error(
+				"Failed to send requested logs",
+				map[string]any{
+					"request": rawRequest,
+					"err":     err,
+				},
+			)
+		}
+	}
+}
+
+func sendRequestedLog(
+	ctx *common.Context,
+	client *http.Client,
+	baseURL *url.URL,
+	rawRequest string,
+) error {
+	request, err := url.ParseQuery(rawRequest)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse the log request")
+	}
+	attemptID, err := strconv.ParseUint(request.Get("attempt_id"), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid attempt id")
+	}
+	var maxSize base.Byte
+	if rawMaxSize := request.Get("max_size"); rawMaxSize != "" {
+		parsedMaxSize, err := strconv.ParseInt(rawMaxSize, 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "invalid max size")
+		}
+		maxSize = base.Byte(parsedMaxSize)
+	}
+
+	contents, ok := logs.Get(attemptID, maxSize)
+	if !ok {
+		contents = []byte(fmt.Sprintf("logs for attempt %d are no longer available\n", attemptID))
+	}
+
+	logsURL, err := baseURL.Parse(fmt.Sprintf("run/%d/logs/", attemptID))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the logs upload URL")
+	}
+	req, err := http.NewRequestWithContext(
+		ctx.Context,
+		"POST",
+		logsURL.String(),
+		bytes.NewReader(contents),
+	)
+	if err != nil {
+		return err
+	}
+	if ctx.Config.Runner.Hostname != "" {
+		req.Header.Add("OmegaUp-Runner-Name", ctx.Config.Runner.Hostname)
+	}
+	req.Header.Add("Content-Type", "text/plain; charset=utf-8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("non-2xx error code returned: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -62,10 +62,31 @@ var (
 	configPath = flag.String("config", "/etc/omegaup/runner/config.json",
 		"Runner configuration file")
 	globalContext atomic.Value
-	ioLock        sync.Mutex
+	ioLock        sync.RWMutex
 	inputManager  *common.InputManager
 	sandbox       runner.Sandbox
 
+	// profileSandboxes are the sandboxes for each of the configured sandbox
+	// profiles. Runs that request a profile that is not in here are rejected.
+	profileSandboxes map[string]runner.Sandbox
+
+	// toolchainSandboxes are the sandboxes for each of the configured
+	// toolchains, indexed by toolchain and then by sandbox profile (with the
+	// empty string being the default profile).
+	toolchainSandboxes map[string]map[string]runner.Sandbox
+
+	// binaryCache is nil if neither the local compile cache nor sharing
+	// binaries with other runners is enabled.
+	binaryCache runner.BinaryCache
+
+	// sandboxCPUs are the CPUs to which the sandboxed programs are pinned. It
+	// is empty if they can run on any CPU.
+	sandboxCPUs []int
+
+	// thermalMonitor is nil if the runner does not pause when the CPUs are
+	// throttled.
+	thermalMonitor *runner.ThermalMonitor
+
 	// ProgramVersion is the version of the code from which the binary was built from.
 	ProgramVersion string
 )
@@ -487,7 +508,7 @@ func runOneshotCI(ctx *common.Context, sandbox runner.Sandbox) *ci.Report {
 				)
 				return err
 			}
-			if result.Verdict != "AC" && result.Verdict != "PA" && result.Verdict != "WA" {
+			if result.Verdict != common.VerdictAccepted && result.Verdict != common.VerdictPartiallyAccepted && result.Verdict != common.VerdictWrongAnswer {
 				ctx.Log.Error(
 					"Error generating outputs",
 					map[string]any{
@@ -715,7 +736,22 @@ func main() {
 	ctx := globalContext.Load().(*common.Context)
 	if *noop {
 		sandbox = &runner.NoopSandbox{}
-	} else {
+	} else if ctx.Config.Runner.Sandbox == "isolate" || ctx.Config.Runner.Sandbox == "nsjail" {
+		if len(ctx.Config.Runner.SandboxProfiles) != 0 || len(ctx.Config.Runner.Toolchains) != 0 {
+			ctx.Log.Error(
+				"Sandbox profiles and toolchains are only supported by the omegajail sandbox",
+				map[string]any{
+					"sandbox": ctx.Config.Runner.Sandbox,
+				},
+			)
+			os.Exit(1)
+		}
+		if ctx.Config.Runner.Sandbox == "isolate" {
+			sandbox = runner.NewIsolateSandbox(ctx.Config.Runner.Isolate)
+		} else {
+			sandbox = runner.NewNsjailSandbox(ctx.Config.Runner.Nsjail)
+		}
+	} else if ctx.Config.Runner.Sandbox == "omegajail" {
 		omegajailRoot, err := filepath.Abs(ctx.Config.Runner.OmegajailRoot)
 		if err != nil {
 			ctx.Log.Error(
@@ -733,7 +769,73 @@ func main() {
 			// Disable sandboxing when running inside Docker.
 			oj.DisableSandboxing = true
 		}
+		oj.CgroupRoot = ctx.Config.Runner.CgroupRoot
 		sandbox = oj
+
+		profileSandboxes = make(map[string]runner.Sandbox)
+		for name, profile := range ctx.Config.Runner.SandboxProfiles {
+			profileRoot := omegajailRoot
+			if profile.OmegajailRoot != "" {
+				profileRoot, err = filepath.Abs(profile.OmegajailRoot)
+				if err != nil {
+					ctx.Log.Error(
+						"Failed to get omegajail root",
+						map[string]any{
+							"profile": name,
+							"err":     err,
+						},
+					)
+					os.Exit(1)
+				}
+			}
+			profileSandbox := runner.NewOmegajailSandbox(profileRoot)
+			profileSandbox.AllowSigsysFallback = oj.AllowSigsysFallback
+			profileSandbox.DisableSandboxing = oj.DisableSandboxing
+			profileSandbox.CgroupRoot = oj.CgroupRoot
+			profileSandbox.ExtraFlags = profile.ExtraFlags
+			profileSandboxes[name] = profileSandbox
+		}
+
+		toolchainSandboxes = make(map[string]map[string]runner.Sandbox)
+		for toolchain, root := range ctx.Config.Runner.Toolchains {
+			toolchainRoot, err := filepath.Abs(root)
+			if err != nil {
+				ctx.Log.Error(
+					"Failed to get omegajail root",
+					map[string]any{
+						"toolchain": toolchain,
+						"err":       err,
+					},
+				)
+				os.Exit(1)
+			}
+			newToolchainSandbox := func(extraFlags []string) runner.Sandbox {
+				toolchainSandbox := runner.NewOmegajailSandbox(toolchainRoot)
+				toolchainSandbox.AllowSigsysFallback = oj.AllowSigsysFallback
+				toolchainSandbox.DisableSandboxing = oj.DisableSandboxing
+				toolchainSandbox.CgroupRoot = oj.CgroupRoot
+				toolchainSandbox.ExtraFlags = extraFlags
+				return toolchainSandbox
+			}
+			toolchainSandboxes[toolchain] = map[string]runner.Sandbox{
+				"": newToolchainSandbox(nil),
+			}
+			for name, profile := range ctx.Config.Runner.SandboxProfiles {
+				if profile.OmegajailRoot != "" {
+					// The toolchain and the profile would each provide the root.
+					continue
+				}
+				toolchainSandboxes[toolchain][name] = newToolchainSandbox(profile.ExtraFlags)
+			}
+		}
+	} else {
+		ctx.Log.Error(
+			"Unknown sandbox",
+			map[string]any{
+				"sandbox": ctx.Config.Runner.Sandbox,
+			},
+		)
+		os.Exit(1)
 	}
 
 	if isOneShotMode() {
@@ -863,13 +965,16 @@ func main() {
 		runner.NewCachedInputFactory(inputPath),
 		&ioLock,
 	)
+	httpConfig := &ctx.Config.Runner.HTTP
 	transport := &http.Transport{
 		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
+			Timeout:   time.Duration(httpConfig.DialTimeout),
+			KeepAlive: time.Duration(httpConfig.KeepAlive),
 		}).Dial,
-		TLSHandshakeTimeout:   10 * time.Second,
+		TLSHandshakeTimeout:   time.Duration(httpConfig.TLSHandshakeTimeout),
 		ExpectContinueTimeout: 1 * time.Second,
+		IdleConnTimeout:       time.Duration(httpConfig.IdleConnTimeout),
+		MaxIdleConnsPerHost:   httpConfig.MaxIdleConnsPerHost,
 	}
 	if !*insecure {
 		cert, err := ioutil.ReadFile(ctx.Config.TLS.CertFile)
@@ -890,8 +995,13 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		if err := http2.ConfigureTransport(transport); err != nil {
-			panic(err)
+		if !httpConfig.DisableHTTP2 {
+			http2Transport, err := http2.ConfigureTransports(transport)
+			if err != nil {
+				panic(err)
+			}
+			http2Transport.ReadIdleTimeout = time.Duration(httpConfig.HTTP2ReadIdleTimeout)
+			http2Transport.PingTimeout = time.Duration(httpConfig.HTTP2PingTimeout)
 		}
 	}
 
@@ -902,19 +1012,84 @@ func main() {
 		panic(err)
 	}
 
+	if ctx.Config.Runner.ShareBinaries {
+		binaryCache = runner.NewGraderBinaryCache(client, baseURL)
+	}
+	if ctx.Config.Runner.CompileCacheSize > 0 {
+		binaryCache, err = runner.NewLocalBinaryCache(
+			path.Join(ctx.Config.Runner.RuntimePath, "compile-cache"),
+			ctx.Config.Runner.CompileCacheSize,
+			binaryCache,
+		)
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to create the compile cache",
+				map[string]any{
+					"err": err,
+				},
+			)
+			os.Exit(1)
+		}
+	}
+
+	sandboxCPUs, err = runner.ParseCPUList(ctx.Config.Runner.SandboxCPUs)
+	if err != nil {
+		ctx.Log.Error(
+			"Invalid sandbox CPUs",
+			map[string]any{
+				"err": err,
+			},
+		)
+		os.Exit(1)
+	}
+	if ctx.Config.Runner.Concurrency < 1 {
+		ctx.Log.Error(
+			"Invalid concurrency",
+			map[string]any{
+				"concurrency": ctx.Config.Runner.Concurrency,
+			},
+		)
+		os.Exit(1)
+	}
+	if ctx.Config.Runner.ThermalThrottleCooldown > 0 {
+		thermalMonitor = runner.NewThermalMonitor(sandboxCPUs)
+		if _, err := thermalMonitor.Throttled(); err != nil {
+			ctx.Log.Error(
+				"Failed to read the CPU throttling counters",
+				map[string]any{
+					"err": err,
+				},
+			)
+			os.Exit(1)
+		}
+	}
+
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
 	cancelContext, cancel := context.WithCancel(ctx.Context)
 	ctx.Context = cancelContext
+	// stopCtx is cancelled as soon as the runner is asked to stop, so that no
+	// more runs are requested. ctx is only cancelled if the runs that are being
+	// graded need to be abandoned.
+	stopContext, stop := context.WithCancel(ctx.Context)
+	stopCtx := ctx.Wrap(stopContext)
 
 	setupMetrics(ctx)
+	logs = newLogArchive(ctx.Config.Runner.LogArchiveSize)
+	probeToolchains(ctx)
+	go toolchainsLoop(ctx)
+	if ctx.Config.Runner.StatusPort != 0 {
+		setupStatusServer(ctx)
+	}
 	var wg sync.WaitGroup
 	if !*noop {
 		// Only run the benchmark loop if the sandbox is actually running.
 		// Otherwise the results are moot.
-		go benchmarkLoop(ctx, &wg)
+		go benchmarkLoop(stopCtx, &wg)
+	}
+	for i := 0; i < ctx.Config.Runner.Concurrency; i++ {
+		go runnerLoop(ctx, stopCtx, &wg, client, baseURL)
 	}
-	go runnerLoop(ctx, &wg, client, baseURL)
 
 	ctx.Log.Info(
 		"omegaUp runner ready",
@@ -927,10 +1102,24 @@ func main() {
 	<-stopChan
 
 	daemon.SdNotify(false, "STOPPING=1")
-	ctx.Log.Info("Shutting down server...", nil)
+	ctx.Log.Info("Shutting down server, waiting for the runs in progress...", nil)
 
+	// Let the runs that are being graded finish and upload their results,
+	// unless the runner is asked to stop again.
+	stop()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-stopChan:
+		ctx.Log.Warn("Abandoning the runs in progress", nil)
+		cancel()
+		<-stopped
+	}
 	cancel()
-	wg.Wait()
 
 	ctx.Close()
 	ctx.Log.Info("Server gracefully stopped.", nil)
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -19,6 +23,17 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	// artifactUploadAttempts is the number of times that the upload of the
+	// artifacts of a run is attempted. The artifacts are best-effort, so the
+	// results are sent without them if all the attempts fail.
+	artifactUploadAttempts = 3
+
+	// artifactUploadRetryDelay is how long to wait before the second attempt
+	// to upload the artifacts. Each subsequent attempt waits longer.
+	artifactUploadRetryDelay = 5 * time.Second
+)
+
 func benchmarkLoop(ctx *common.Context, wg *sync.WaitGroup) {
 	wg.Add(1)
 	defer wg.Done()
@@ -55,13 +70,33 @@ func benchmarkLoop(ctx *common.Context, wg *sync.WaitGroup) {
 	}
 }
 
-func runnerLoop(ctx *common.Context, wg *sync.WaitGroup, client *http.Client, baseURL *url.URL) {
+// runnerLoop requests runs and grades them one at a time until stopCtx is
+// cancelled. The run that is being graded when that happens is still
+// finished with ctx, and its results are uploaded.
+func runnerLoop(
+	ctx *common.Context,
+	stopCtx *common.Context,
+	wg *sync.WaitGroup,
+	client *http.Client,
+	baseURL *url.URL,
+) {
 	wg.Add(1)
 	defer wg.Done()
 	var sleepTime float32 = 1
+	maxSleepTime := float32(time.Duration(ctx.Config.Runner.HTTP.MaxReconnectDelay).Seconds())
+	if maxSleepTime < sleepTime {
+		maxSleepTime = sleepTime
+	}
 
 	for {
-		if err := processRun(ctx, client, baseURL); err != nil {
+		if !waitWhileThrottled(stopCtx) {
+			return
+		}
+		if err := processRun(ctx, stopCtx.Context, client, baseURL); err != nil {
+			if stopCtx.Context.Err() != nil {
+				// The request for a new run was cancelled.
+				return
+			}
 			if err, ok := err.(net.Error); ok && err.Timeout() {
 				// Timeouts are expected. Just retry.
 				sleepTime = 1
@@ -75,17 +110,65 @@ func runnerLoop(ctx *common.Context, wg *sync.WaitGroup, client *http.Client, ba
 			)
 			// Randomized exponential backoff.
 			select {
-			case <-ctx.Context.Done():
+			case <-stopCtx.Context.Done():
 				return
 			case <-time.After(time.Duration(rand.Float32()*sleepTime) * time.Second):
 				// continue with the loop.
 			}
-			if sleepTime < 64 {
+			if sleepTime < maxSleepTime {
 				sleepTime *= 2
 			}
+			if sleepTime > maxSleepTime {
+				sleepTime = maxSleepTime
+			}
 		} else {
 			sleepTime = 1
 		}
+		if stopCtx.Context.Err() != nil {
+			return
+		}
+	}
+}
+
+// throttleLock makes the runner loops wait for the throttling checks one at a
+// time, so that all of them stay paused while any of them is waiting for the
+// CPUs to cool down.
+var throttleLock sync.Mutex
+
+// waitWhileThrottled blocks without accepting runs for as long as the CPUs
+// keep being throttled for being too hot. It returns false if the context is
+// cancelled while waiting.
+func waitWhileThrottled(ctx *common.Context) bool {
+	if thermalMonitor == nil {
+		return true
+	}
+	throttleLock.Lock()
+	defer throttleLock.Unlock()
+	for {
+		throttled, err := thermalMonitor.Throttled()
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to read the CPU throttling counters",
+				map[string]any{
+					"err": err,
+				},
+			)
+			return true
+		}
+		if !throttled {
+			return true
+		}
+		ctx.Log.Warn(
+			"CPUs are being throttled, pausing",
+			map[string]any{
+				"cooldown": ctx.Config.Runner.ThermalThrottleCooldown,
+			},
+		)
+		select {
+		case <-ctx.Context.Done():
+			return false
+		case <-time.After(time.Duration(ctx.Config.Runner.ThermalThrottleCooldown)):
+		}
 	}
 }
 
@@ -168,8 +251,12 @@ func (cb *channelBuffer) Read(buf []byte) (int, error) {
 	return written, nil
 }
 
+// processRun requests a run and grades it. The request is made with
+// requestCtx, so that it can be cancelled without affecting the grading of a
+// run that was already received.
 func processRun(
 	parentCtx *common.Context,
+	requestCtx context.Context,
 	client *http.Client,
 	baseURL *url.URL,
 ) error {
@@ -177,7 +264,7 @@ func processRun(
 	if err != nil {
 		panic(err)
 	}
-	req, err := http.NewRequestWithContext(parentCtx.Context, "GET", requestURL.String(), nil)
+	req, err := http.NewRequestWithContext(requestCtx, "GET", requestURL.String(), nil)
 	if err != nil {
 		return err
 	}
@@ -187,6 +274,12 @@ func processRun(
 	if parentCtx.Config.Runner.PublicIP != "" {
 		req.Header.Add("OmegaUp-Runner-PublicIP", parentCtx.Config.Runner.PublicIP)
 	}
+	if _, toolchainsHeader := status.currentToolchains(); toolchainsHeader != "" {
+		req.Header.Add("OmegaUp-Runner-Toolchains", toolchainsHeader)
+	}
+	req.Header.Add("OmegaUp-Runner-Protocol", strconv.Itoa(common.RunnerProtocolVersion))
+	req.Header.Add("OmegaUp-Runner-Features", common.FormatRunFeatures(common.SupportedRunFeatures))
+	req.Header.Add("OmegaUp-Runner-Time", time.Now().UTC().Format(time.RFC3339Nano))
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -201,6 +294,8 @@ func processRun(
 	ctx.Transaction.AcceptDistributedTraceHeaders(tracing.TransportQueue, resp.Header)
 	defer ctx.Transaction.End()
 
+	sendRequestedLogs(ctx, client, baseURL, resp.Header)
+
 	decoder := json.NewDecoder(resp.Body)
 	var run common.Run
 	if err := decoder.Decode(&run); err != nil {
@@ -210,6 +305,10 @@ func processRun(
 	if err != nil {
 		return errors.Wrap(err, "failed to create the result upload URL")
 	}
+	artifactsURL, err := baseURL.Parse(fmt.Sprintf("run/%d/artifacts/", run.AttemptID))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the artifact upload URL")
+	}
 
 	finished := make(chan error, 1)
 
@@ -217,29 +316,55 @@ func processRun(
 		ctx,
 		client,
 		uploadURL.String(),
+		artifactsURL.String(),
+		resp.Header.Get("OmegaUp-Work-Token"),
 		&run,
 		finished,
 	); err != nil {
+		logs.Add(run.AttemptID, ctx.LogBuffer())
 		return err
 	}
 
-	return <-finished
+	err = <-finished
+	if err != nil {
+		ctx.Log.Error(
+			"Error uploading results",
+			map[string]any{
+				"err": err,
+			},
+		)
+	}
+	logs.Add(run.AttemptID, ctx.LogBuffer())
+	return err
 }
 
 func gradeAndUploadResults(
 	ctx *common.Context,
 	client *http.Client,
 	uploadURL string,
+	artifactsURL string,
+	workToken string,
 	run *common.Run,
 	finished chan<- error,
 ) error {
+	// The artifacts are written to a file instead of being streamed, so that
+	// their upload can be retried.
+	filesZip, err := ioutil.TempFile(ctx.Config.Runner.RuntimePath, "files.*.zip")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		filesZip.Close()
+		os.Remove(filesZip.Name())
+	}()
+
 	requestBody := newChannelBuffer()
 	defer requestBody.closeChannel()
 	multipartWriter := multipart.NewWriter(requestBody)
 	defer multipartWriter.Close()
 	go func() {
 		defer requestBody.Close()
-		req, err := http.NewRequest("POST", uploadURL, requestBody)
+		req, err := http.NewRequestWithContext(ctx.Context, "POST", uploadURL, requestBody)
 		if err != nil {
 			finished <- err
 			close(finished)
@@ -248,6 +373,9 @@ func gradeAndUploadResults(
 		if ctx.Config.Runner.Hostname != "" {
 			req.Header.Add("OmegaUp-Runner-Name", ctx.Config.Runner.Hostname)
 		}
+		if workToken != "" {
+			req.Header.Add("OmegaUp-Work-Token", workToken)
+		}
 		req.Header.Add("Content-Type", multipartWriter.FormDataContentType())
 		response, err := client.Do(req)
 		if err != nil {
@@ -260,9 +388,20 @@ func gradeAndUploadResults(
 		close(finished)
 	}()
 
-	filesWriter := newFilesZipWriter(multipartWriter)
-	result, err := gradeRun(ctx, client, run, filesWriter)
-	filesWriter.Close()
+	resultsWriter := newResultsWriter(multipartWriter)
+	result, err := gradeRun(ctx, client, run, resultsWriter, filesZip)
+	if err == nil {
+		if err := uploadArtifacts(ctx, client, artifactsURL, workToken, filesZip); err != nil {
+			// The results are still valid without the artifacts.
+			ctx.Log.Error(
+				"Error uploading artifacts",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	}
+	resultsWriter.finish()
 	if err != nil {
 		// Still try to send the details
 		ctx.Log.Error(
@@ -271,7 +410,32 @@ func gradeAndUploadResults(
 				"err": err,
 			},
 		)
-		result = runner.NewRunResult("JE", run.MaxScore)
+		result = runner.NewRunResult(common.VerdictJudgeError, run.MaxScore)
+
+		var capabilityErr *runner.CapabilityError
+		if errors.As(err, &capabilityErr) {
+			// Let the grader know that this run needs to go to a different
+			// runner.
+			capabilityWriter, err := multipartWriter.CreateFormFile("file", "capability.json")
+			if err != nil {
+				ctx.Log.Error(
+					"Error sending capability.json",
+					map[string]any{
+						"err": err,
+					},
+				)
+				return err
+			}
+			if err := json.NewEncoder(capabilityWriter).Encode(capabilityErr); err != nil {
+				ctx.Log.Error(
+					"Error encoding capability.json",
+					map[string]any{
+						"err": err,
+					},
+				)
+				return err
+			}
+		}
 	}
 
 	if *noop {
@@ -300,6 +464,26 @@ func gradeAndUploadResults(
 		return err
 	}
 
+	timingsWriter, err := multipartWriter.CreateFormFile("file", "timings.json")
+	if err != nil {
+		ctx.Log.Error(
+			"Error sending timings.json",
+			map[string]any{
+				"err": err,
+			},
+		)
+		return err
+	}
+	if err := json.NewEncoder(timingsWriter).Encode(&result.Timings); err != nil {
+		ctx.Log.Error(
+			"Error encoding timings.json",
+			map[string]any{
+				"err": err,
+			},
+		)
+		return err
+	}
+
 	// Send uncompressed logs.
 	logsBuffer := ctx.LogBuffer()
 	if logsBuffer != nil {
@@ -326,92 +510,264 @@ func gradeAndUploadResults(
 	return nil
 }
 
-// filesZipWriter is an io.WriteCloser backed by a multipart.Writer that
-// creates files called `.keepalive` every 15 seconds until the first real
-// write is made. This allows the connection to avoid timing out due to nothing
-// being sent for 60s.
-type filesZipWriter struct {
+// resultsWriter is backed by the multipart.Writer of the request that sends
+// the results of a run. Until finish is called, it creates files called
+// `.keepalive` every 15 seconds so that the connection does not time out due
+// to nothing being sent for 60s while the run is being graded and its
+// artifacts are being uploaded. Until then, it can also send partial results.
+type resultsWriter struct {
+	sync.Mutex
 	multipartWriter *multipart.Writer
-	writeReadyChan  chan<- struct{}
+	finishChan      chan<- struct{}
 	tickerDoneChan  <-chan struct{}
 	once            sync.Once
+	finished        bool
 
-	w    io.Writer
-	wErr error
+	// casesWriter is the `cases.jsonl` file that is currently open, if any.
+	// Creating any other file closes it.
+	casesWriter io.Writer
 }
 
-var _ io.WriteCloser = (*filesZipWriter)(nil)
-
-func newFilesZipWriter(multipartWriter *multipart.Writer) *filesZipWriter {
-	writeReadyChan := make(chan struct{})
+func newResultsWriter(multipartWriter *multipart.Writer) *resultsWriter {
+	finishChan := make(chan struct{})
 	tickerDoneChan := make(chan struct{})
+	w := &resultsWriter{
+		multipartWriter: multipartWriter,
+		finishChan:      finishChan,
+		tickerDoneChan:  tickerDoneChan,
+	}
 	go func() {
 		tick := time.NewTicker(15 * time.Second)
 		for {
 			select {
 			case <-tick.C:
+				w.Lock()
 				multipartWriter.CreateFormFile("file", ".keepalive")
-			case <-writeReadyChan:
+				w.casesWriter = nil
+				w.Unlock()
+			case <-finishChan:
 				tick.Stop()
 				close(tickerDoneChan)
 				return
 			}
 		}
 	}()
-	return &filesZipWriter{
-		multipartWriter: multipartWriter,
-		writeReadyChan:  writeReadyChan,
-		tickerDoneChan:  tickerDoneChan,
-	}
+	return w
 }
 
-// ready marks the writer as ready to start writing to the files.zip file. This
-// will wait for any outstanding write to a `.keepalive` file, and stop trying
-// to create such files.
-func (w *filesZipWriter) ready() {
+// finish marks the writer as ready to write the final results. This will
+// wait for any outstanding write to a `.keepalive` file, and stop trying to
+// create such files.
+func (w *resultsWriter) finish() {
 	w.once.Do(func() {
-		close(w.writeReadyChan)
+		close(w.finishChan)
 		<-w.tickerDoneChan
 
-		w.w, w.wErr = w.multipartWriter.CreateFormFile("file", "files.zip")
+		w.Lock()
+		defer w.Unlock()
+		w.finished = true
+		w.casesWriter = nil
 	})
 }
 
-func (w *filesZipWriter) Write(b []byte) (int, error) {
-	w.ready()
-	if w.wErr != nil {
-		return 0, w.wErr
+// writeGroupResult sends the partial results of a group as a `group.json`
+// file. Any results that are available after the final results have started
+// being written are dropped.
+func (w *resultsWriter) writeGroupResult(groupResult *runner.GroupResult) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.finished {
+		return nil
+	}
+	w.casesWriter = nil
+	groupWriter, err := w.multipartWriter.CreateFormFile("file", "group.json")
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(groupWriter).Encode(groupResult)
+}
+
+// writeCaseResult sends the result of a case as a line of a `cases.jsonl`
+// file, which is kept open so that the grader can read each line as soon as
+// it is written. Like with writeGroupResult, any results that are available
+// after the final results have started being written are dropped.
+func (w *resultsWriter) writeCaseResult(group string, caseResult *runner.CaseResult) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.finished {
+		return nil
+	}
+	if w.casesWriter == nil {
+		casesWriter, err := w.multipartWriter.CreateFormFile("file", "cases.jsonl")
+		if err != nil {
+			return err
+		}
+		w.casesWriter = casesWriter
+	}
+	return json.NewEncoder(w.casesWriter).Encode(&runner.CaseProgress{
+		Group: group,
+		Case:  *caseResult,
+	})
+}
+
+// uploadArtifacts sends the files.zip of a run to the grader in its own
+// request, which is retried independently of the results. The artifacts must
+// be uploaded before the final results are sent, since the grader stops
+// accepting them once the run is finalized.
+func uploadArtifacts(
+	ctx *common.Context,
+	client *http.Client,
+	uploadURL string,
+	workToken string,
+	f *os.File,
+) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := uploadArtifactsOnce(ctx, client, uploadURL, workToken, f, info.Size())
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= artifactUploadAttempts {
+			return err
+		}
+		ctx.Log.Warn(
+			"Failed to upload artifacts, retrying",
+			map[string]any{
+				"attempt": attempt,
+				"err":     err,
+			},
+		)
+		select {
+		case <-ctx.Context.Done():
+			return ctx.Context.Err()
+		case <-time.After(time.Duration(attempt) * artifactUploadRetryDelay):
+		}
+	}
+}
+
+// uploadArtifactsOnce makes a single attempt to upload the artifacts, and
+// returns whether it can be retried if it failed.
+func uploadArtifactsOnce(
+	ctx *common.Context,
+	client *http.Client,
+	uploadURL string,
+	workToken string,
+	f *os.File,
+	size int64,
+) (bool, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	// The client closes the body of the request, but the file is needed for
+	// the retries.
+	req, err := http.NewRequestWithContext(ctx.Context, "POST", uploadURL, io.NopCloser(f))
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = size
+	if ctx.Config.Runner.Hostname != "" {
+		req.Header.Add("OmegaUp-Runner-Name", ctx.Config.Runner.Hostname)
+	}
+	if workToken != "" {
+		req.Header.Add("OmegaUp-Work-Token", workToken)
+	}
+	req.Header.Add("Content-Type", "application/zip")
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		// The grader rejected the artifacts, so retrying will not help.
+		return false, errors.Errorf("non-2xx error code returned: %d", resp.StatusCode)
 	}
-	return w.w.Write(b)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return true, errors.Errorf("non-2xx error code returned: %d", resp.StatusCode)
+	}
+	return false, nil
 }
 
-func (w *filesZipWriter) Close() error {
-	w.ready()
-	return w.wErr
+// addInput adds the input to the cache, but stops waiting for it if ctx
+// expires first. The input keeps being downloaded in that case, since other
+// runs might be waiting for it too, and is released once it is ready.
+func addInput(
+	ctx *common.Context,
+	hash string,
+	factory common.InputFactory,
+) (*common.InputRef, error) {
+	type addResult struct {
+		inputRef *common.InputRef
+		err      error
+	}
+	done := make(chan addResult, 1)
+	go func() {
+		inputRef, err := inputManager.Add(hash, factory)
+		done <- addResult{inputRef, err}
+	}()
+	select {
+	case result := <-done:
+		return result.inputRef, result.err
+	case <-ctx.Context.Done():
+		go func() {
+			if result := <-done; result.inputRef != nil {
+				result.inputRef.Release()
+			}
+		}()
+		return nil, ctx.Context.Err()
+	}
 }
 
 func gradeRun(
 	ctx *common.Context,
 	client *http.Client,
 	run *common.Run,
+	resultsWriter *resultsWriter,
 	filesWriter io.Writer,
 ) (*runner.RunResult, error) {
 	defer ctx.Transaction.StartSegment("grade").End()
 
-	// Make sure no other I/O is being made while we grade this run.
+	// Reject the run before downloading anything if it cannot be graded here.
+	if err := runner.CheckRunProtocol(run); err != nil {
+		return nil, err
+	}
+	runSandbox, err := sandboxForRun(run)
+	if err != nil {
+		return nil, err
+	}
+
+	// The input is shared with the other runs of the same problem, so it is
+	// downloaded with the context of the runner instead of the one of this
+	// run, which can expire before the others are done waiting for it.
+	inputCtx := ctx
+	if ctx.Config.Runner.RunDeadline != 0 {
+		deadlineCtx, cancel := context.WithTimeout(
+			ctx.Context,
+			time.Duration(ctx.Config.Runner.RunDeadline),
+		)
+		defer cancel()
+		ctx = ctx.Wrap(deadlineCtx)
+	}
+
+	// Make sure no other I/O is being made while we grade this run. Other runs
+	// can still be graded at the same time.
 	ioLockSegment := ctx.Transaction.StartSegment("I/O lock")
-	ioLock.Lock()
-	defer ioLock.Unlock()
+	ioLock.RLock()
+	defer ioLock.RUnlock()
 	ioLockSegment.End()
 
 	inputSegment := ctx.Transaction.StartSegment("input")
+	downloadStart := time.Now()
 	baseURL, err := url.Parse(ctx.Config.Runner.GraderURL)
 	if err != nil {
 		panic(err)
 	}
-	inputRef, err := inputManager.Add(
+	inputRef, err := addInput(
+		ctx,
 		run.InputHash,
-		runner.NewInputFactory(client, &ctx.Config, baseURL, run.ProblemName),
+		runner.NewInputFactory(inputCtx, client, baseURL, run.ProblemName),
 	)
 	if err != nil {
 		return nil, err
@@ -419,5 +775,184 @@ func gradeRun(
 	defer inputRef.Release()
 	inputSegment.End()
 
-	return runner.Grade(ctx, filesWriter, run, inputRef.Input, sandbox)
+	if id, ok := common.ArtifactReference(run.Source); ok {
+		artifactSegment := ctx.Transaction.StartSegment("artifact")
+		err := runner.FetchOutputOnlyArtifact(ctx, client, baseURL, run.AttemptID, id)
+		artifactSegment.End()
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(runner.OutputOnlyArtifactPath(ctx, run.AttemptID, id))
+	}
+	downloadDuration := time.Since(downloadStart)
+
+	status.startRun(run, runner.NewRunLayout(ctx.Config.Runner.RuntimePath, run.AttemptID).Root)
+	defer status.finishRun(run.AttemptID)
+
+	var listener runner.GroupResultListener
+	if slow := inputRef.Input.Settings().Slow; slow || ctx.Config.Runner.FastFeedback {
+		// Runs for slow problems take a long time, so send the results of each
+		// group as they become available to be able to show some progress. In
+		// fast-feedback mode, the results of the sample groups (which are run
+		// first) are sent as soon as they are available.
+		listener = func(groupResult *runner.GroupResult) {
+			if !slow && !runner.IsSampleGroup(groupResult.Group) {
+				return
+			}
+			if err := resultsWriter.writeGroupResult(groupResult); err != nil {
+				ctx.Log.Error(
+					"Error sending group result",
+					map[string]any{
+						"group": groupResult.Group,
+						"err":   err,
+					},
+				)
+			}
+		}
+	}
+
+	opts := runner.GradeOptions{
+		Listener:    listener,
+		Environment: executionEnvironment(ctx),
+	}
+	if ctx.Config.Runner.StreamCaseResults {
+		opts.CaseListener = func(group string, caseResult *runner.CaseResult) {
+			if err := resultsWriter.writeCaseResult(group, caseResult); err != nil {
+				ctx.Log.Error(
+					"Error sending case result",
+					map[string]any{
+						"group": group,
+						"case":  caseResult.Name,
+						"err":   err,
+					},
+				)
+			}
+		}
+	}
+	if binaryCache != nil {
+		toolchains, _ := status.currentToolchains()
+		opts.BinaryCache = binaryCache
+		opts.BinaryFingerprint = runner.ToolchainFingerprint(toolchains)
+	}
+	if ctx.Config.Runner.CPUGovernor != "" {
+		if err := cpuGovernor.acquire(ctx.Config.Runner.CPUGovernor); err != nil {
+			ctx.Log.Error(
+				"Failed to set the CPU governor",
+				map[string]any{
+					"governor": ctx.Config.Runner.CPUGovernor,
+					"err":      err,
+				},
+			)
+		} else {
+			defer func() {
+				if err := cpuGovernor.release(); err != nil {
+					ctx.Log.Error(
+						"Failed to restore the CPU governor",
+						map[string]any{
+							"err": err,
+						},
+					)
+				}
+			}()
+		}
+	}
+	result, err := runner.GradeWithOptions(ctx, filesWriter, run, inputRef.Input, runSandbox, opts)
+	if result != nil {
+		result.Timings.Download = downloadDuration.Seconds()
+	}
+	return result, err
+}
+
+// executionEnvironment returns the description of this runner that is
+// recorded in the results of the runs.
+func executionEnvironment(ctx *common.Context) *runner.ExecutionEnvironment {
+	sandboxName := ctx.Config.Runner.Sandbox
+	if *noop {
+		sandboxName = "noop"
+	}
+	toolchains, _ := status.currentToolchains()
+	return &runner.ExecutionEnvironment{
+		Runner:               ctx.Config.Runner.Hostname,
+		Sandbox:              sandboxName,
+		Kernel:               runner.KernelVersion(),
+		ToolchainFingerprint: runner.ToolchainFingerprint(toolchains),
+	}
+}
+
+// governorLock keeps the CPU governor locked for as long as any run is being
+// graded, since the runs that are graded in parallel share the CPUs.
+type governorLock struct {
+	sync.Mutex
+	count   int
+	restore func() error
+}
+
+var cpuGovernor governorLock
+
+func (l *governorLock) acquire(governor string) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.count == 0 {
+		restore, err := runner.LockCPUGovernor(sandboxCPUs, governor)
+		if err != nil {
+			return err
+		}
+		l.restore = restore
+	}
+	l.count++
+	return nil
+}
+
+// release restores the previous governors once the last run that acquired
+// the lock is done.
+func (l *governorLock) release() error {
+	l.Lock()
+	defer l.Unlock()
+	l.count--
+	if l.count > 0 {
+		return nil
+	}
+	restore := l.restore
+	l.restore = nil
+	return restore()
+}
+
+// sandboxForRun returns the sandbox for the profile and toolchain that the run
+// requested. Runs that request an unknown profile, or a toolchain together
+// with a profile that has its own omegajail root, are rejected instead of
+// being graded with the default sandbox. Runs that request a toolchain that
+// is not available get a runner.CapabilityError so that they can be graded by
+// a different runner.
+func sandboxForRun(run *common.Run) (runner.Sandbox, error) {
+	if *noop {
+		return sandbox, nil
+	}
+	if run.SandboxProfile != "" {
+		if _, ok := profileSandboxes[run.SandboxProfile]; !ok {
+			return nil, errors.Errorf("unknown sandbox profile %q", run.SandboxProfile)
+		}
+	}
+	if run.Toolchain != "" {
+		sandboxes, ok := toolchainSandboxes[run.Toolchain]
+		if !ok {
+			return nil, &runner.CapabilityError{Toolchain: run.Toolchain}
+		}
+		toolchainSandbox, ok := sandboxes[run.SandboxProfile]
+		if !ok {
+			return nil, errors.Errorf(
+				"sandbox profile %q has its own omegajail root and cannot be used with toolchain %q",
+				run.SandboxProfile,
+				run.Toolchain,
+			)
+		}
+		return toolchainSandbox, nil
+	}
+	if run.SandboxProfile == "" {
+		return sandbox, nil
+	}
+	profileSandbox, ok := profileSandboxes[run.SandboxProfile]
+	if !ok {
+		return nil, errors.Errorf("unknown sandbox profile %q", run.SandboxProfile)
+	}
+	return profileSandbox, nil
 }
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/runner"
+)
+
+// currentRunStatus is the information of a run that is currently being
+// graded.
+type currentRunStatus struct {
+	AttemptID uint64    `json:"attempt_id"`
+	GUID      string    `json:"guid,omitempty"`
+	Problem   string    `json:"problem"`
+	Language  string    `json:"language"`
+	InputHash string    `json:"input_hash"`
+	Case      string    `json:"case,omitempty"`
+	StartTime time.Time `json:"start_time"`
+	Elapsed   float64   `json:"elapsed"`
+
+	// root is the root of the RunLayout of the run, which is used to tell
+	// which run a case belongs to.
+	root string
+}
+
+// runnerStatus keeps track of what the runner is doing, so that it can be
+// served through the status endpoint.
+type runnerStatus struct {
+	sync.Mutex
+	current     map[uint64]*currentRunStatus
+	sandboxName string
+	toolchains  map[string]string
+	// toolchainsHeader is the JSON-encoded version of toolchains, which is
+	// sent to the grader in every run request.
+	toolchainsHeader string
+}
+
+var status runnerStatus
+
+func (s *runnerStatus) startRun(run *common.Run, root string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.current == nil {
+		s.current = make(map[uint64]*currentRunStatus)
+	}
+	s.current[run.AttemptID] = &currentRunStatus{
+		AttemptID: run.AttemptID,
+		GUID:      run.GUID,
+		Problem:   run.ProblemName,
+		Language:  run.Language,
+		InputHash: run.InputHash,
+		StartTime: time.Now(),
+		root:      root,
+	}
+}
+
+func (s *runnerStatus) finishRun(attemptID uint64) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.current, attemptID)
+}
+
+// setCase records the case that is being run by the run whose files are
+// written to outputFile.
+func (s *runnerStatus) setCase(outputFile string) {
+	s.Lock()
+	defer s.Unlock()
+	for _, current := range s.current {
+		if !strings.HasPrefix(outputFile, current.root+"/") {
+			continue
+		}
+		current.Case = strings.TrimSuffix(path.Base(outputFile), path.Ext(outputFile))
+		return
+	}
+}
+
+// setToolchains records the versions of the installed toolchains, and returns
+// whether they changed.
+func (s *runnerStatus) setToolchains(toolchains map[string]string) (bool, error) {
+	header, err := json.Marshal(toolchains)
+	if err != nil {
+		return false, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.toolchainsHeader == string(header) {
+		return false, nil
+	}
+	s.toolchains = toolchains
+	s.toolchainsHeader = string(header)
+	return true, nil
+}
+
+func (s *runnerStatus) currentToolchains() (map[string]string, string) {
+	s.Lock()
+	defer s.Unlock()
+	return s.toolchains, s.toolchainsHeader
+}
+
+// probeToolchains records the versions of the installed toolchains, logging
+// them if they changed since the last time they were probed.
+func probeToolchains(ctx *common.Context) {
+	toolchains := runner.ToolchainVersions(ctx)
+	changed, err := status.setToolchains(toolchains)
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to record toolchain versions",
+			map[string]any{
+				"err": err,
+			},
+		)
+		return
+	}
+	if changed {
+		ctx.Log.Info(
+			"Toolchain versions changed",
+			map[string]any{
+				"toolchains": toolchains,
+			},
+		)
+	}
+}
+
+// toolchainsLoop probes the toolchain versions periodically, so that upgrades
+// are reported to the grader without restarting the runner.
+func toolchainsLoop(ctx *common.Context) {
+	if ctx.Config.Runner.ToolchainProbeInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(ctx.Config.Runner.ToolchainProbeInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Context.Done():
+			return
+		case <-ticker.C:
+			probeToolchains(ctx)
+		}
+	}
+}
+
+// currentRuns returns the runs that are being graded, from the oldest to the
+// newest.
+func (s *runnerStatus) currentRuns() []*currentRunStatus {
+	s.Lock()
+	defer s.Unlock()
+	runs := make([]*currentRunStatus, 0, len(s.current))
+	for _, current := range s.current {
+		run := *current
+		run.Elapsed = time.Since(run.StartTime).Seconds()
+		runs = append(runs, &run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartTime.Before(runs[j].StartTime)
+	})
+	return runs
+}
+
+// statusSandbox is a Sandbox that records the case that is currently being
+// run before delegating to the actual Sandbox.
+type statusSandbox struct {
+	runner.Sandbox
+	status *runnerStatus
+}
+
+// The optional interfaces of the wrapped Sandbox are only implemented by the
+// variants of statusSandbox that forward them, so that wrapping a Sandbox
+// does not change which ones it implements.
+type statusNetworkSandbox struct{ *statusSandbox }
+type statusCompilerVersionSandbox struct{ *statusSandbox }
+type statusNetworkCompilerVersionSandbox struct{ *statusSandbox }
+
+var (
+	_ runner.NetworkSandbox         = statusNetworkSandbox{}
+	_ runner.CompilerVersionSandbox = statusCompilerVersionSandbox{}
+	_ runner.NetworkSandbox         = statusNetworkCompilerVersionSandbox{}
+	_ runner.CompilerVersionSandbox = statusNetworkCompilerVersionSandbox{}
+)
+
+// newStatusSandbox wraps the sandbox in a statusSandbox that implements the
+// same optional interfaces as it.
+func newStatusSandbox(sandbox runner.Sandbox, status *runnerStatus) runner.Sandbox {
+	wrapped := &statusSandbox{
+		Sandbox: sandbox,
+		status:  status,
+	}
+	_, network := sandbox.(runner.NetworkSandbox)
+	_, compilerVersion := sandbox.(runner.CompilerVersionSandbox)
+	switch {
+	case network && compilerVersion:
+		return statusNetworkCompilerVersionSandbox{wrapped}
+	case network:
+		return statusNetworkSandbox{wrapped}
+	case compilerVersion:
+		return statusCompilerVersionSandbox{wrapped}
+	default:
+		return wrapped
+	}
+}
+
+func (s *statusSandbox) Run(
+	ctx *common.Context,
+	limits *common.LimitsSettings,
+	lang, chdir, inputFile, outputFile, errorFile, metaFile, target string,
+	originalInputFile, originalOutputFile, runMetaFile *string,
+	extraParams []string,
+	extraMountPoints map[string]string,
+) (*runner.RunMetadata, error) {
+	s.status.setCase(outputFile)
+	return s.Sandbox.Run(
+		ctx,
+		limits,
+		lang, chdir, inputFile, outputFile, errorFile, metaFile, target,
+		originalInputFile, originalOutputFile, runMetaFile,
+		extraParams,
+		extraMountPoints,
+	)
+}
+
+func (s *statusSandbox) withNetworkPolicy(policy common.NetworkPolicy) (runner.Sandbox, error) {
+	sandbox, err := s.Sandbox.(runner.NetworkSandbox).WithNetworkPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	return newStatusSandbox(sandbox, s.status), nil
+}
+
+func (s *statusSandbox) compilerVersion(ctx *common.Context, lang string) (string, error) {
+	return s.Sandbox.(runner.CompilerVersionSandbox).CompilerVersion(ctx, lang)
+}
+
+func (s statusNetworkSandbox) WithNetworkPolicy(policy common.NetworkPolicy) (runner.Sandbox, error) {
+	return s.withNetworkPolicy(policy)
+}
+
+func (s statusCompilerVersionSandbox) CompilerVersion(ctx *common.Context, lang string) (string, error) {
+	return s.compilerVersion(ctx, lang)
+}
+
+func (s statusNetworkCompilerVersionSandbox) WithNetworkPolicy(policy common.NetworkPolicy) (runner.Sandbox, error) {
+	return s.withNetworkPolicy(policy)
+}
+
+func (s statusNetworkCompilerVersionSandbox) CompilerVersion(ctx *common.Context, lang string) (string, error) {
+	return s.compilerVersion(ctx, lang)
+}
+
+func sandboxName(sandbox runner.Sandbox) string {
+	switch sandbox.(type) {
+	case *runner.OmegajailSandbox:
+		return "omegajail"
+	case *runner.IsolateSandbox:
+		return "isolate"
+	case *runner.NsjailSandbox:
+		return "nsjail"
+	case *runner.NoopSandbox:
+		return "noop"
+	default:
+		return fmt.Sprintf("%T", sandbox)
+	}
+}
+
+// statusLimits are the limits of the runner that are served through the status
+// endpoint. Only these are exposed, instead of the whole configuration, so
+// that no credentials or internal URLs are leaked through it.
+type statusLimits struct {
+	CompileTimeLimit   base.Duration `json:"compile_time_limit"`
+	CompileOutputLimit base.Byte     `json:"compile_output_limit"`
+	CompileErrorLimit  base.Byte     `json:"compile_error_limit"`
+	HardMemoryLimit    base.Byte     `json:"hard_memory_limit"`
+	OverallOutputLimit base.Byte     `json:"overall_output_limit"`
+	MaxArtifactSize    base.Byte     `json:"max_artifact_size"`
+	OutputOnlyMaxFiles int           `json:"output_only_max_files"`
+	RunDeadline        base.Duration `json:"run_deadline"`
+}
+
+func newStatusLimits(config *common.RunnerConfig) statusLimits {
+	return statusLimits{
+		CompileTimeLimit:   config.CompileTimeLimit,
+		CompileOutputLimit: config.CompileOutputLimit,
+		CompileErrorLimit:  config.CompileErrorLimit,
+		HardMemoryLimit:    config.HardMemoryLimit,
+		OverallOutputLimit: config.OverallOutputLimit,
+		MaxArtifactSize:    config.MaxArtifactSize,
+		OutputOnlyMaxFiles: config.OutputOnlyMaxFiles,
+		RunDeadline:        config.RunDeadline,
+	}
+}
+
+func setupStatusServer(ctx *common.Context) {
+	status.sandboxName = sandboxName(sandbox)
+	sandbox = newStatusSandbox(sandbox, &status)
+	for name, profileSandbox := range profileSandboxes {
+		profileSandboxes[name] = newStatusSandbox(profileSandbox, &status)
+	}
+	for _, sandboxes := range toolchainSandboxes {
+		for name, toolchainSandbox := range sandboxes {
+			sandboxes[name] = newStatusSandbox(toolchainSandbox, &status)
+		}
+	}
+
+	statusMux := http.NewServeMux()
+	statusMux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		toolchains, _ := status.currentToolchains()
+		response := struct {
+			Version    string               `json:"version"`
+			Hostname   string               `json:"hostname"`
+			Runs       []*currentRunStatus  `json:"runs"`
+			Cache      *common.InputManager `json:"cache"`
+			Sandbox    string               `json:"sandbox"`
+			Toolchains map[string]string    `json:"toolchains"`
+			Limits     statusLimits         `json:"limits"`
+		}{
+			Version:    ProgramVersion,
+			Hostname:   ctx.Config.Runner.Hostname,
+			Runs:       status.currentRuns(),
+			Cache:      inputManager,
+			Sandbox:    status.sandboxName,
+			Toolchains: toolchains,
+			Limits:     newStatusLimits(&ctx.Config.Runner),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(&response); err != nil {
+			ctx.Log.Error(
+				"Error writing /status/ response",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	})
+	go func() {
+		addr := fmt.Sprintf("localhost:%d", ctx.Config.Runner.StatusPort)
+		err := http.ListenAndServe(addr, statusMux)
+		if !errors.Is(err, http.ErrServerClosed) {
+			ctx.Log.Error(
+				"http listen and serve",
+				map[string]any{
+					"err": err,
+				},
+			)
+		}
+	}()
+}
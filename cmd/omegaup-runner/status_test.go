@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/runner"
+)
+
+type fakeSandbox struct{}
+
+func (*fakeSandbox) Supported() bool {
+	return true
+}
+
+func (*fakeSandbox) Compile(
+	ctx *common.Context,
+	lang string,
+	inputFiles []string,
+	chdir, outputFile, errorFile, metaFile, target string,
+	extraFlags []string,
+) (*runner.RunMetadata, error) {
+	return &runner.RunMetadata{Verdict: "OK"}, nil
+}
+
+func (*fakeSandbox) Run(
+	ctx *common.Context,
+	limits *common.LimitsSettings,
+	lang, chdir, inputFile, outputFile, errorFile, metaFile, target string,
+	originalInputFile, originalOutputFile, runMetaFile *string,
+	extraParams []string,
+	extraMountPoints map[string]string,
+) (*runner.RunMetadata, error) {
+	return &runner.RunMetadata{Verdict: "OK"}, nil
+}
+
+type fakeCompilerVersionSandbox struct {
+	fakeSandbox
+}
+
+func (*fakeCompilerVersionSandbox) CompilerVersion(ctx *common.Context, lang string) (string, error) {
+	return lang + " 1.0", nil
+}
+
+func TestStatusSandbox(t *testing.T) {
+	var status runnerStatus
+
+	wrapped := newStatusSandbox(&fakeCompilerVersionSandbox{}, &status)
+	versionSandbox, ok := wrapped.(runner.CompilerVersionSandbox)
+	if !ok {
+		t.Fatalf("%T does not implement CompilerVersionSandbox", wrapped)
+	}
+	if version, err := versionSandbox.CompilerVersion(nil, "cpp17-gcc"); err != nil || version != "cpp17-gcc 1.0" {
+		t.Errorf("CompilerVersion() == %q, %v, want \"cpp17-gcc 1.0\"", version, err)
+	}
+	if _, ok := wrapped.(runner.NetworkSandbox); ok {
+		t.Errorf("%T implements NetworkSandbox, but the wrapped sandbox does not", wrapped)
+	}
+
+	wrapped = newStatusSandbox(&fakeSandbox{}, &status)
+	if _, ok := wrapped.(runner.CompilerVersionSandbox); ok {
+		t.Errorf("%T implements CompilerVersionSandbox, but the wrapped sandbox does not", wrapped)
+	}
+
+	wrapped = newStatusSandbox(&runner.NoopSandbox{}, &status)
+	networkSandbox, ok := wrapped.(runner.NetworkSandbox)
+	if !ok {
+		t.Fatalf("%T does not implement NetworkSandbox", wrapped)
+	}
+	networkWrapped, err := networkSandbox.WithNetworkPolicy(common.NetworkPolicyLoopback)
+	if err != nil {
+		t.Fatalf("Failed to set the network policy: %v", err)
+	}
+	if _, ok := networkWrapped.(statusNetworkSandbox); !ok {
+		t.Errorf("WithNetworkPolicy() == %T, want a statusNetworkSandbox", networkWrapped)
+	}
+}
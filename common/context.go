@@ -42,6 +42,42 @@ type V1Config struct {
 	RuntimePath      string
 	SendBroadcast    bool
 	UpdateDatabase   bool
+	MaxSourceSize    base.Byte // 0 disables the submission source size limit
+
+	// ExactScores makes the grader also write the exact score and contest
+	// score of each run to the score_numerator, score_denominator,
+	// contest_score_numerator and contest_score_denominator BIGINT columns of
+	// Runs, which the database must have. The FLOAT columns are still written.
+	ExactScores bool
+
+	// ReconcileInterval is how often the database is checked for pending
+	// runs that the grader lost track of. 0 (the default) disables this. It
+	// has no effect if UpdateDatabase is false.
+	ReconcileInterval base.Duration
+
+	// When the toolchains of a runner change, up to RegressionSampleSize runs
+	// that previously got AC in each of the RegressionMaxProblems most
+	// submitted problems are re-graded, to catch regressions in the grading
+	// environment. 0 disables this.
+	RegressionSampleSize  int
+	RegressionMaxProblems int
+
+	// Runs that were submitted in the last ContestEndPriorityWindow of a
+	// contest are graded with high priority. 0 disables this.
+	ContestEndPriorityWindow base.Duration
+
+	// Run broadcasts of contests that the broadcaster does not acknowledge are
+	// kept in an outbox on disk and sent again every BroadcastRetryInterval,
+	// so that the scoreboard does not miss them if the broadcaster restarts.
+	// At most BroadcastOutboxSize messages are kept. 0 disables this.
+	BroadcastOutboxSize    int
+	BroadcastRetryInterval base.Duration
+
+	// Once a scheduled rejudge starts, its runs are added to the queue every
+	// RejudgeInjectInterval, as long as the queue has fewer than
+	// RejudgeMaxQueueLength runs (unless the rejudge sets its own limit).
+	RejudgeInjectInterval base.Duration
+	RejudgeMaxQueueLength int
 }
 
 // GraderEphemeralConfig represents the configuration for the Grader web interface.
@@ -62,6 +98,92 @@ type GraderCIConfig struct {
 	CISizeLimit base.Byte
 }
 
+// GraderSlowDetectionConfig represents the configuration for the automatic
+// detection of slow problems.
+type GraderSlowDetectionConfig struct {
+	Enabled    bool
+	WindowSize int
+	MinSamples int
+	Percentile float64
+	Threshold  base.Duration
+}
+
+// GraderAuthConfig represents the configuration of the authentication of the
+// requests to the frontend endpoints of the grader.
+type GraderAuthConfig struct {
+	// Policies maps a frontend endpoint (like "/submission/source/") to the
+	// authentication methods ("mtls", "hmac" or "oauth") that it accepts. A
+	// request is allowed if any of them authenticates it. Endpoints that are
+	// not in the map do not require authentication. Only /run/new/,
+	// /run/grade/, /run/status/, /run/progress/, /run/resource/,
+	// /submission/source/, /artifact/upload/, /scoreboard/,
+	// /problem/manifest/, /problem/stats/, /broadcast/, /ephemeral/ and /ci/
+	// can have a policy. /artifact/upload/ and /broadcast/ must always have
+	// one, and they use mtls by default.
+	Policies map[string][]string
+
+	MTLS  GraderMTLSAuthConfig
+	HMAC  GraderHMACAuthConfig
+	OAuth GraderOAuthAuthConfig
+}
+
+// GraderMTLSAuthConfig represents the configuration of the "mtls"
+// authentication method, which uses the client certificate that the server
+// already verified.
+type GraderMTLSAuthConfig struct {
+	// Subjects are the common names of the certificates that are accepted.
+	// Empty accepts all of them.
+	Subjects []string
+}
+
+// GraderHMACAuthConfig represents the configuration of the "hmac"
+// authentication method, where the requests are signed with a shared secret.
+type GraderHMACAuthConfig struct {
+	// Keys maps the ID of each key to its secret.
+	Keys map[string]string
+
+	// MaxSkew is how old (or how far in the future) the timestamp of a
+	// signed request can be.
+	MaxSkew base.Duration
+}
+
+// GraderOAuthAuthConfig represents the configuration of the "oauth"
+// authentication method, where the bearer tokens of the requests are checked
+// with an OAuth 2.0 token introspection endpoint (RFC 7662).
+type GraderOAuthAuthConfig struct {
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+
+	// Scope is the scope that the tokens must have. Empty accepts any active
+	// token.
+	Scope string
+
+	// CacheTTL is how long the result of an introspection is reused for the
+	// same token.
+	CacheTTL base.Duration
+}
+
+// GraderEncryptionConfig represents the configuration of the encryption of
+// the sources of the submissions and the grade artifacts that the grader
+// stores.
+type GraderEncryptionConfig struct {
+	// KeyID is the ID of the key that new artifacts are encrypted with. Empty
+	// disables the encryption of new artifacts, but the ones that are already
+	// encrypted can still be read if their keys are configured.
+	KeyID string
+
+	// Keys maps the ID of each key to the key, as 32 bytes encoded in base64.
+	// The keys that are no longer used for new artifacts must be kept to read
+	// the artifacts that they encrypted.
+	Keys map[string]string
+
+	// KMSKeys maps the ID of each key to the key encrypted with AWS KMS,
+	// encoded in base64. They are decrypted with KMS when the grader starts,
+	// and are used like the ones in Keys.
+	KMSKeys map[string]string
+}
+
 // GraderConfig represents the configuration for the Grader.
 type GraderConfig struct {
 	ChannelLength          int
@@ -74,7 +196,80 @@ type GraderConfig struct {
 	V1                     V1Config
 	Ephemeral              GraderEphemeralConfig
 	CI                     GraderCIConfig
+	SlowDetection          GraderSlowDetectionConfig
 	UseS3                  bool
+	RunnerLogRequestSize   base.Byte
+	ScoreRounding          ScoreRoundingSettings
+	MaxArtifactUploadSize  base.Byte // 0 disables the artifact upload size limit
+	MaxRunLogSize          base.Byte // 0 disables the run log size limit
+
+	// QueueSandboxProfiles maps the name of a queue to the sandbox profile
+	// that the runners must use for all the runs in that queue.
+	QueueSandboxProfiles map[string]string
+
+	// QueueMaxGradeRetries and PriorityMaxGradeRetries override
+	// MaxGradeRetries for the runs in a queue and for the runs with a priority
+	// ("high", "normal", "low" or "ephemeral"). The queue takes precedence.
+	QueueMaxGradeRetries    map[string]int
+	PriorityMaxGradeRetries map[string]int
+
+	// Runs are queued again GradeRetryDelay plus a random delay of up to
+	// GradeRetryJitter after a failed attempt.
+	GradeRetryDelay  base.Duration
+	GradeRetryJitter base.Duration
+
+	// BinaryCacheSize is the maximum size of the compiled binaries that
+	// runners share through the grader. 0 disables this.
+	BinaryCacheSize base.Byte
+
+	// ProblemDispatchRates maps the alias of a problem to the maximum number
+	// of its runs per minute that are handed to runners. The rest of the runs
+	// of that problem wait in the queue.
+	ProblemDispatchRates map[string]float64
+
+	// AdminAddr is the address (like "localhost:21681") where the endpoints
+	// used to operate the grader (status, metrics, regression checks, config
+	// reloads, pprof and expvar) are served. Empty serves them together with
+	// the frontend endpoints on V1.Port.
+	AdminAddr string
+
+	// AuditLogPath is the file where the actions requested through the admin
+	// endpoints are recorded. Empty means "audit.log" in RuntimePath.
+	AuditLogPath string
+
+	// AccessLog makes every HTTP request that the grader handles be logged.
+	AccessLog bool
+
+	// ClockSkewThreshold is how far apart the clocks of a runner and the
+	// grader can be before a warning is logged. 0 disables the warning.
+	ClockSkewThreshold base.Duration
+
+	// Scoreboard makes the grader keep the standings of the contests in
+	// memory as it finishes their runs, and serve them in /scoreboard/. If
+	// V1.SendBroadcast is also set, the changes to the standings are
+	// broadcast.
+	Scoreboard bool
+
+	// Auth configures which clients can use the frontend endpoints.
+	Auth GraderAuthConfig
+	// Encryption configures the encryption at rest of the sources of the
+	// submissions and the grade artifacts.
+	Encryption GraderEncryptionConfig
+
+	// WorkTokenKey is the key, encoded in base64, that signs the work tokens
+	// that are sent with each run, which the runner has to send back with its
+	// results and artifacts. Empty uses a random key, so the tokens of the
+	// runs that were in flight stop being valid when the grader restarts and
+	// their uploads are rejected. Production graders must set it to at least
+	// 16 random bytes.
+	WorkTokenKey string
+	// WorkTokenTTL is how long after a run is given to a runner its results
+	// can be uploaded.
+	WorkTokenTTL base.Duration
+	// RequireWorkTokens rejects the results and artifacts without a work
+	// token. It can be disabled so that only the ones with invalid tokens are
+	// rejected while the runners are upgraded.
+	RequireWorkTokens bool
 }
 
 // TLSConfig represents the configuration for TLS.
@@ -95,6 +290,203 @@ type RunnerConfig struct {
 	OverallOutputLimit base.Byte
 	OmegajailRoot      string
 	PreserveFiles      bool
+	StatusPort         uint16 // 0 disables the status endpoint
+	LogArchiveSize     base.Byte
+	RunDeadline        base.Duration // 0 disables the per-run deadline
+	MaxArtifactSize    base.Byte     // 0 disables the artifact size limit
+	FastFeedback       bool          // send the sample group results early
+	OutputOnlyMaxFiles int           // 0 disables the output-only file count limit
+
+	// CompileErrorLimit is the maximum size of the compile.out and
+	// compile.err files that is kept after compiling, which bounds the size
+	// of the compile errors that are reported. Only the beginning and the end
+	// of larger outputs are kept. 0 keeps them whole.
+	CompileErrorLimit base.Byte
+
+	// Input archives of at least InputSegmentedDownloadMinSize are downloaded
+	// in InputDownloadConcurrency parallel byte ranges. 0 disables this.
+	InputSegmentedDownloadMinSize base.Byte
+	InputDownloadConcurrency      int
+
+	// LazyInputs requests inputs as lazy input archives, so that only the
+	// cases that are run get decompressed.
+	LazyInputs bool
+
+	// Concurrency is the number of runs that are graded in parallel. Each run
+	// has its own RunLayout, and they all share the CPUs in SandboxCPUs, so
+	// this should not be larger than the number of CPUs that the runs can use
+	// without slowing each other down.
+	Concurrency int
+
+	// StreamCaseResults sends the result of each case to the grader as soon as
+	// it has been validated, so that the progress of the run can be shown
+	// while it is being graded. Graders that do not support this store the
+	// results as an artifact of the run.
+	StreamCaseResults bool
+
+	// Cases whose CPU time is within BorderlineTLEMargin (as a fraction of the
+	// time limit) of the time limit are re-run BorderlineTLEReruns times, and
+	// the attempt with the median CPU time is used. Runs that were killed for
+	// using up their CPU time are not re-run. 0 reruns disables this.
+	BorderlineTLEMargin float64
+	BorderlineTLEReruns int
+
+	// Sandbox is the sandbox that compiles and runs the programs:
+	// "omegajail", "isolate" or "nsjail". Only omegajail supports sandbox
+	// profiles and toolchains.
+	Sandbox string
+
+	// Isolate configures the isolate sandbox.
+	Isolate IsolateConfig
+
+	// Nsjail configures the nsjail sandbox.
+	Nsjail NsjailConfig
+
+	// SandboxProfiles are the sandbox profiles that can be requested by runs,
+	// in addition to the default one.
+	SandboxProfiles map[string]SandboxProfileConfig
+
+	// Toolchains maps the names of the toolchains that problems can pin to the
+	// omegajail root that provides them.
+	Toolchains map[string]string
+
+	// ShareBinaries makes the runner upload the binaries it compiles to the
+	// grader, and download the ones compiled by other runners with the same
+	// architecture and toolchain versions instead of compiling them again.
+	ShareBinaries bool
+
+	// CompileCacheSize is the maximum size of the compiled binaries that the
+	// runner keeps in RuntimePath, so that rejudges and identical submissions
+	// are not compiled again. The least recently used ones are evicted first.
+	// Zero disables the cache.
+	CompileCacheSize base.Byte
+
+	// The versions of the installed compilers and interpreters are probed
+	// again every ToolchainProbeInterval, so that upgrades are reported to the
+	// grader. 0 disables this.
+	ToolchainProbeInterval base.Duration
+
+	// TmpfsRunRootSize mounts the directory of each run on a tmpfs of at most
+	// this size, so that disk I/O does not add variance to the measured times.
+	// The disk is used if the tmpfs cannot be mounted. 0 disables this.
+	TmpfsRunRootSize base.Byte
+
+	// JavaPolicyTemplate is the path of a Java security policy template that
+	// Java programs run under, with {{.RunRoot}} replaced by the directory of
+	// the run. Denied permissions are reported as RFE. Empty disables this.
+	JavaPolicyTemplate string
+
+	// Languages has the configuration of the languages, which overrides the
+	// one in DefaultRunnerLanguages. See RunnerConfig.Language for how they
+	// are combined, and RunnerConfig.SandboxLimits for how their limits are
+	// applied.
+	Languages map[string]RunnerLanguageConfig
+
+	// CgroupRoot is a cgroup v2 directory (e.g. /sys/fs/cgroup/omegaup) with
+	// the cpu and memory controllers enabled for its children, where the
+	// omegajail sandbox creates a cgroup for each execution. The CPU time,
+	// peak memory and OOM kills of the execution are then taken from it,
+	// which accounts for all the threads and processes of the program. Empty
+	// disables this.
+	CgroupRoot string
+
+	// SandboxCPUs is the list of CPUs (e.g. "4-7,12") to which the sandboxed
+	// programs are pinned, so that in hosts with heterogeneous cores they
+	// only run on the performance ones. Empty allows all CPUs.
+	SandboxCPUs string
+
+	// CPUGovernor is the frequency scaling governor (e.g. "performance") that
+	// the SandboxCPUs are set to while a run is being graded, restoring the
+	// previous one afterwards. Empty leaves the governor alone.
+	CPUGovernor string
+
+	// ThermalThrottleCooldown is how long the runner stops accepting runs
+	// after the SandboxCPUs were throttled for being too hot, since the times
+	// measured on throttled CPUs are not fair. It keeps waiting for as long as
+	// they are still being throttled. Zero disables this.
+	ThermalThrottleCooldown base.Duration
+
+	// HTTP configures the client that is used to talk to the grader.
+	HTTP RunnerHTTPConfig
+}
+
+// RunnerHTTPConfig represents the configuration of the HTTP client that the
+// Runner uses to talk to the grader.
+type RunnerHTTPConfig struct {
+	DialTimeout         base.Duration
+	KeepAlive           base.Duration
+	TLSHandshakeTimeout base.Duration
+	IdleConnTimeout     base.Duration
+	MaxIdleConnsPerHost int // 0 uses the net/http default
+
+	// DisableHTTP2 makes the runner use HTTP/1.1 even if the grader supports
+	// HTTP/2.
+	DisableHTTP2 bool
+
+	// An HTTP/2 connection that has not received anything in
+	// HTTP2ReadIdleTimeout is pinged, and closed if the ping is not answered
+	// in HTTP2PingTimeout. This detects graders that went away without closing
+	// the connection, which would otherwise leave the long poll for runs
+	// hanging. 0 disables the health checks.
+	HTTP2ReadIdleTimeout base.Duration
+	HTTP2PingTimeout     base.Duration
+
+	// MaxReconnectDelay is the maximum time the runner waits before asking the
+	// grader for a run again after a failure.
+	MaxReconnectDelay base.Duration
+}
+
+// SandboxProfileConfig represents the configuration of an alternative sandbox
+// profile for the Runner. A profile with its own OmegajailRoot cannot be used
+// by runs that pin a toolchain, since the toolchain provides the root too.
+type SandboxProfileConfig struct {
+	OmegajailRoot string   // defaults to RunnerConfig.OmegajailRoot
+	ExtraFlags    []string // extra flags that are passed to omegajail
+}
+
+// IsolateConfig represents the configuration of the isolate sandbox.
+type IsolateConfig struct {
+	Binary     string // path of the isolate binary
+	FirstBoxID int    // the runner uses the boxes [FirstBoxID, FirstBoxID+Boxes)
+	Boxes      int    // number of programs that can run at the same time
+	Cgroups    bool   // use control groups to account for memory
+
+	// Languages maps each language to the commands that compile and run it,
+	// and overrides the built-in ones.
+	Languages map[string]SandboxLanguageConfig
+}
+
+// NsjailConfig represents the configuration of the nsjail sandbox.
+type NsjailConfig struct {
+	Binary  string // path of the nsjail binary
+	Cgroups bool   // use control groups to limit memory and processes
+
+	// PolicyRoot is the directory with the nsjail configuration files that
+	// the programs of each language run under: <language>.cfg, or default.cfg
+	// if the language does not have one.
+	PolicyRoot string
+
+	// Languages maps each language to the commands that compile and run it,
+	// and overrides the built-in ones.
+	Languages map[string]SandboxLanguageConfig
+}
+
+// SandboxLanguageConfig represents the commands that compile and run programs
+// in a language in the sandboxes that do not know about languages (isolate
+// and nsjail). In the commands, "{target}" is replaced by the name of the
+// compile target, and the "{sources}" and "{flags}" arguments are replaced by
+// the source files and the extra arguments, respectively. In the Run command,
+// "{heap}" is replaced by the size in MiB that the heap of a managed runtime
+// can have given the memory limit, and the arguments that have it are dropped
+// if there is no memory limit.
+type SandboxLanguageConfig struct {
+	Compile []string
+	Run     []string
+
+	// Processes is the maximum number of processes and threads that the
+	// program can have while running. 0 means a single one, and a negative
+	// number means unlimited.
+	Processes int
 }
 
 // DbConfig represents the configuration for the database.
@@ -173,13 +565,26 @@ var defaultConfig = Config{
 		Port:                   11302,
 		RuntimePath:            "/var/lib/omegaup/",
 		MaxGradeRetries:        3,
+		RunnerLogRequestSize:   base.Byte(64) * base.Kibibyte,
+		MaxArtifactUploadSize:  base.Byte(1) * base.Gibibyte,
+		MaxRunLogSize:          base.Byte(64) * base.Mebibyte,
+		BinaryCacheSize:        base.Byte(512) * base.Mebibyte,
+		ClockSkewThreshold:     base.Duration(time.Second),
 		V1: V1Config{
-			Enabled:          false,
-			Port:             21680,
-			RuntimeGradePath: "/var/lib/omegaup/grade",
-			RuntimePath:      "/var/lib/omegaup/",
-			SendBroadcast:    true,
-			UpdateDatabase:   true,
+			Enabled:                false,
+			Port:                   21680,
+			RuntimeGradePath:       "/var/lib/omegaup/grade",
+			RuntimePath:            "/var/lib/omegaup/",
+			SendBroadcast:          true,
+			UpdateDatabase:         true,
+			ReconcileInterval:      base.Duration(0),
+			RegressionSampleSize:   0,
+			RegressionMaxProblems:  50,
+			BroadcastOutboxSize:    10000,
+			BroadcastRetryInterval: base.Duration(10 * time.Second),
+			RejudgeInjectInterval:  base.Duration(10 * time.Second),
+			RejudgeMaxQueueLength:  200,
+			MaxSourceSize:          base.Byte(100) * base.Mebibyte,
 		},
 		Ephemeral: GraderEphemeralConfig{
 			EphemeralSizeLimit:   base.Gibibyte,
@@ -198,17 +603,83 @@ var defaultConfig = Config{
 		CI: GraderCIConfig{
 			CISizeLimit: base.Byte(256) * base.Mebibyte,
 		},
-		UseS3: false,
+		SlowDetection: GraderSlowDetectionConfig{
+			Enabled:    false,
+			WindowSize: 50,
+			MinSamples: 10,
+			Percentile: 0.9,
+			Threshold:  base.Duration(time.Duration(30) * time.Second),
+		},
+		UseS3:             false,
+		WorkTokenTTL:      base.Duration(time.Hour),
+		RequireWorkTokens: true,
+		Auth: GraderAuthConfig{
+			Policies: map[string][]string{
+				"/artifact/upload/": {"mtls"},
+				"/broadcast/":       {"mtls"},
+			},
+			HMAC: GraderHMACAuthConfig{
+				MaxSkew: base.Duration(5 * time.Minute),
+			},
+			OAuth: GraderOAuthAuthConfig{
+				CacheTTL: base.Duration(time.Minute),
+			},
+		},
 	},
 	Runner: RunnerConfig{
-		RuntimePath:        "/var/lib/omegaup/runner",
-		GraderURL:          "https://omegaup.com:11302",
-		CompileTimeLimit:   base.Duration(time.Duration(30) * time.Second),
-		CompileOutputLimit: base.Byte(10) * base.Mebibyte,
-		HardMemoryLimit:    base.Byte(640) * base.Mebibyte,
-		OverallOutputLimit: base.Byte(100) * base.Mebibyte,
-		OmegajailRoot:      "/var/lib/omegajail",
-		PreserveFiles:      false,
+		RuntimePath:                   "/var/lib/omegaup/runner",
+		GraderURL:                     "https://omegaup.com:11302",
+		CompileTimeLimit:              base.Duration(time.Duration(30) * time.Second),
+		CompileOutputLimit:            base.Byte(10) * base.Mebibyte,
+		CompileErrorLimit:             base.Byte(64) * base.Kibibyte,
+		HardMemoryLimit:               base.Byte(640) * base.Mebibyte,
+		OverallOutputLimit:            base.Byte(100) * base.Mebibyte,
+		OmegajailRoot:                 "/var/lib/omegajail",
+		Sandbox:                       "omegajail",
+		PreserveFiles:                 false,
+		StatusPort:                    0,
+		LogArchiveSize:                base.Byte(10) * base.Mebibyte,
+		RunDeadline:                   base.Duration(0),
+		MaxArtifactSize:               base.Byte(256) * base.Mebibyte,
+		FastFeedback:                  false,
+		BorderlineTLEMargin:           0.05,
+		BorderlineTLEReruns:           0,
+		OutputOnlyMaxFiles:            1024,
+		InputSegmentedDownloadMinSize: base.Byte(64) * base.Mebibyte,
+		InputDownloadConcurrency:      4,
+		LazyInputs:                    false,
+		Concurrency:                   1,
+		StreamCaseResults:             false,
+		ShareBinaries:                 false,
+		CompileCacheSize:              base.Byte(256) * base.Mebibyte,
+		ToolchainProbeInterval:        base.Duration(10 * time.Minute),
+		TmpfsRunRootSize:              base.Byte(0),
+		SandboxCPUs:                   "",
+		CPUGovernor:                   "",
+		ThermalThrottleCooldown:       base.Duration(0),
+		JavaPolicyTemplate:            "",
+		Isolate: IsolateConfig{
+			Binary:     "isolate",
+			FirstBoxID: 0,
+			Boxes:      4,
+			Cgroups:    false,
+		},
+		Nsjail: NsjailConfig{
+			Binary:     "nsjail",
+			Cgroups:    false,
+			PolicyRoot: "/etc/omegaup/runner/nsjail",
+		},
+		HTTP: RunnerHTTPConfig{
+			DialTimeout:          base.Duration(30 * time.Second),
+			KeepAlive:            base.Duration(30 * time.Second),
+			TLSHandshakeTimeout:  base.Duration(10 * time.Second),
+			IdleConnTimeout:      base.Duration(90 * time.Second),
+			MaxIdleConnsPerHost:  4,
+			DisableHTTP2:         false,
+			HTTP2ReadIdleTimeout: base.Duration(30 * time.Second),
+			HTTP2PingTimeout:     base.Duration(15 * time.Second),
+			MaxReconnectDelay:    base.Duration(8 * time.Second),
+		},
 	},
 	TLS: TLSConfig{
 		CertFile: "/etc/omegaup/grader/certificate.pem",
@@ -237,12 +708,19 @@ type Context struct {
 
 // DefaultConfig returns a default Config.
 func DefaultConfig() Config {
-	return defaultConfig
+	config := defaultConfig
+	// The maps are copied so that decoding a configuration on top of the
+	// default one does not modify it.
+	config.Grader.Auth.Policies = make(map[string][]string, len(defaultConfig.Grader.Auth.Policies))
+	for endpoint, methods := range defaultConfig.Grader.Auth.Policies {
+		config.Grader.Auth.Policies[endpoint] = methods
+	}
+	return config
 }
 
 // NewConfig creates a new Config from the specified reader.
 func NewConfig(reader io.Reader) (*Config, error) {
-	config := defaultConfig
+	config := DefaultConfig()
 
 	// Read basic config
 	decoder := json.NewDecoder(reader)
@@ -42,3 +42,18 @@ func TestConfigSerializability(t *testing.T) {
 		t.Errorf("Serialized config empty")
 	}
 }
+
+func TestConfigAuthPolicies(t *testing.T) {
+	config, err := NewConfig(bytes.NewBufferString(
+		`{"Grader": {"Auth": {"Policies": {"/run/new/": ["hmac"]}}}}`,
+	))
+	if err != nil {
+		t.Fatalf("Failed to read the config: %v", err)
+	}
+	if len(config.Grader.Auth.Policies["/artifact/upload/"]) == 0 {
+		t.Errorf("Policies == %v, want the default policy of /artifact/upload/", config.Grader.Auth.Policies)
+	}
+	if _, ok := DefaultConfig().Grader.Auth.Policies["/run/new/"]; ok {
+		t.Errorf("NewConfig() modified the default config")
+	}
+}
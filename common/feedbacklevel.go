@@ -0,0 +1,43 @@
+package common
+
+// FeedbackLevel determines how much of the results of a run can be shown to
+// the contestant that submitted it, so that problemsets can give partial
+// feedback (like IOI-style contests do).
+type FeedbackLevel string
+
+const (
+	// FeedbackLevelNone only allows to show whether the run compiled, and the
+	// compile error if it did not.
+	FeedbackLevelNone FeedbackLevel = "none"
+
+	// FeedbackLevelSummary allows to show the verdict, score and resources of
+	// the whole run, but nothing about its groups nor its cases.
+	FeedbackLevelSummary FeedbackLevel = "summary"
+
+	// FeedbackLevelPerGroup also allows to show the scores of the groups, but
+	// nothing about their cases.
+	FeedbackLevelPerGroup FeedbackLevel = "per-group"
+
+	// FeedbackLevelPerCase also allows to show the verdicts and scores of the
+	// cases. Only the public cases keep the rest of their information. This is
+	// the default, and will be used if the feedback level is not selected.
+	FeedbackLevelPerCase FeedbackLevel = "per-case"
+
+	// FeedbackLevelDefault is an alias of FeedbackLevelPerCase.
+	FeedbackLevelDefault FeedbackLevel = ""
+
+	// FeedbackLevelFullDiff allows to show all the information of all the
+	// cases, as if all of them were public, so that the contestant can compare
+	// their outputs with the expected ones.
+	FeedbackLevelFullDiff FeedbackLevel = "full-diff"
+)
+
+// Valid returns whether the feedback level is one of the known ones.
+func (l FeedbackLevel) Valid() bool {
+	switch l {
+	case FeedbackLevelNone, FeedbackLevelSummary, FeedbackLevelPerGroup,
+		FeedbackLevelPerCase, FeedbackLevelDefault, FeedbackLevelFullDiff:
+		return true
+	}
+	return false
+}
@@ -71,8 +71,19 @@ type TransmittableInput interface {
 
 	// Transmit sends a serialized version of the Input over HTTP. It should be a
 	// .tar.gz file with the Content-SHA1 header set to the hexadecimal
-	// representation of the SHA-1 hash of the file.
-	Transmit(http.ResponseWriter) error
+	// representation of the SHA-1 hash of the file. Range requests should be
+	// honored so that interrupted downloads can be resumed.
+	Transmit(http.ResponseWriter, *http.Request) error
+}
+
+// LazyInput is an Input whose case files are only extracted from its archive
+// when they are needed.
+type LazyInput interface {
+	Input
+
+	// ExtractCase makes the .in and .out files of the case with the provided
+	// name available under Path(). It is a no-op if they already are.
+	ExtractCase(caseName string) error
 }
 
 // InputRef represents a reference to an Input
@@ -0,0 +1,63 @@
+package common
+
+import (
+	base "github.com/omegaup/go-base/v3"
+)
+
+// InputManifest is the structure of the cases of a problem for a particular
+// Input, so that frontends can show the breakdown of the results without
+// parsing the problem's repository.
+type InputManifest struct {
+	InputHash   string          `json:"input_hash"`
+	Limits      LimitsSettings  `json:"limits"`
+	Interactive bool            `json:"interactive"`
+	Validator   ValidatorName   `json:"validator"`
+	Groups      []GroupManifest `json:"groups"`
+}
+
+// GroupManifest is a group of cases in an InputManifest.
+type GroupManifest struct {
+	Name   string         `json:"name"`
+	Weight float64        `json:"weight"`
+	Cases  []CaseManifest `json:"cases"`
+}
+
+// CaseManifest is a case in an InputManifest.
+type CaseManifest struct {
+	Name       string         `json:"name"`
+	Weight     float64        `json:"weight"`
+	Visibility CaseVisibility `json:"visibility"`
+}
+
+// NewInputManifest returns the manifest of the problem with the settings of
+// the Input with the provided hash. The weights are the ones used for
+// scoring, so they take absolute points and normalization into account.
+func NewInputManifest(inputHash string, settings *ProblemSettings) *InputManifest {
+	manifest := &InputManifest{
+		InputHash:   inputHash,
+		Limits:      settings.Limits,
+		Interactive: settings.Interactive != nil,
+		Validator:   settings.Validator.Name,
+		Groups:      make([]GroupManifest, 0, len(settings.Cases)),
+	}
+	for _, group := range settings.ScoringCases() {
+		groupManifest := GroupManifest{
+			Name:   group.Name,
+			Weight: base.RationalToFloat(group.Weight()),
+			Cases:  make([]CaseManifest, 0, len(group.Cases)),
+		}
+		for _, c := range group.Cases {
+			visibility := c.Visibility
+			if visibility == CaseVisibilityDefault {
+				visibility = CaseVisibilityHidden
+			}
+			groupManifest.Cases = append(groupManifest.Cases, CaseManifest{
+				Name:       c.Name,
+				Weight:     base.RationalToFloat(c.Weight),
+				Visibility: visibility,
+			})
+		}
+		manifest.Groups = append(manifest.Groups, groupManifest)
+	}
+	return manifest
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestNewInputManifest(t *testing.T) {
+	settings := &ProblemSettings{
+		Cases: []GroupSettings{
+			{
+				Name: "easy",
+				Cases: []CaseSettings{
+					{Name: "easy.0", Weight: big.NewRat(1, 1), Visibility: CaseVisibilityPublic},
+					{Name: "easy.1", Weight: big.NewRat(1, 1)},
+				},
+			},
+			{
+				Name: "hard",
+				Cases: []CaseSettings{
+					{Name: "hard", Weight: big.NewRat(2, 1)},
+				},
+			},
+		},
+		Limits:           DefaultLimits,
+		Validator:        ValidatorSettings{Name: ValidatorNameTokenCaseless},
+		NormalizeWeights: true,
+	}
+
+	manifest := NewInputManifest("0123456789abcdef0123456789abcdef01234567", settings)
+	expected := &InputManifest{
+		InputHash: "0123456789abcdef0123456789abcdef01234567",
+		Limits:    DefaultLimits,
+		Validator: ValidatorNameTokenCaseless,
+		Groups: []GroupManifest{
+			{
+				Name:   "easy",
+				Weight: 0.5,
+				Cases: []CaseManifest{
+					{Name: "easy.0", Weight: 0.25, Visibility: CaseVisibilityPublic},
+					{Name: "easy.1", Weight: 0.25, Visibility: CaseVisibilityHidden},
+				},
+			},
+			{
+				Name:   "hard",
+				Weight: 0.5,
+				Cases: []CaseManifest{
+					{Name: "hard", Weight: 0.5, Visibility: CaseVisibilityHidden},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("NewInputManifest() == %+v, want %+v", manifest, expected)
+	}
+
+	// The settings are not modified by the normalization.
+	if settings.Cases[1].Cases[0].Weight.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("settings weight == %v, want 2", settings.Cases[1].Cases[0].Weight)
+	}
+
+	marshaled, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal the manifest: %v", err)
+	}
+	var unmarshaled map[string]any
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		t.Fatalf("Failed to unmarshal the manifest: %v", err)
+	}
+	for _, key := range []string{"input_hash", "limits", "interactive", "validator", "groups"} {
+		if _, ok := unmarshaled[key]; !ok {
+			t.Errorf("marshaled manifest %s is missing %q", marshaled, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	base "github.com/omegaup/go-base/v3"
+)
+
+// LanguageLimits is a profile of adjustments that the sandboxes make to the
+// limits of the programs in a language, to make up for the overhead of its
+// compiler or runtime. The memory verdicts are still based on the limits of
+// the problem: a program that uses more memory than the problem allows gets
+// MLE even if MemoryFactor let it run to completion.
+type LanguageLimits struct {
+	// TimeFactor multiplies the CPU time limit, if it is greater than 1, so
+	// that problems with limits meant for compiled languages are still
+	// solvable in slower ones.
+	TimeFactor float64
+
+	// ExtraTime is added to the CPU time limit, after it is multiplied by
+	// TimeFactor.
+	ExtraTime base.Duration
+
+	// ExtraWallTime is added to the extra wall time.
+	ExtraWallTime base.Duration
+
+	// MemoryFactor multiplies the memory limit, if it is greater than 1.
+	MemoryFactor float64
+}
+
+// LanguageProfile returns the LanguageLimits profile of the language, from
+// its RunnerLanguageConfig. Languages without a profile get no adjustments.
+func (config *RunnerConfig) LanguageProfile(lang string) LanguageLimits {
+	if limits := config.Language(lang).Limits; limits != nil {
+		return *limits
+	}
+	return LanguageLimits{}
+}
+
+// SandboxLimits returns the limits that the sandboxes enforce for a program in
+// the language. They are computed in this order:
+//
+//  1. The limits of the problem, with the LimitsOverride of the run already
+//     applied by the caller.
+//  2. The LanguageLimits profile of the language: the time limit is multiplied
+//     by TimeFactor, ExtraTime and ExtraWallTime are added, and the memory
+//     limit is multiplied by MemoryFactor.
+//  3. The memory limit is capped at Runner.HardMemoryLimit.
+//
+// A zero memory limit means that there is no limit, so it is neither
+// multiplied nor capped.
+func (config *RunnerConfig) SandboxLimits(lang string, limits *LimitsSettings) LimitsSettings {
+	profile := config.LanguageProfile(lang)
+	result := *limits
+	if profile.TimeFactor > 1 {
+		result.TimeLimit = base.Duration(float64(result.TimeLimit) * profile.TimeFactor)
+	}
+	result.TimeLimit += profile.ExtraTime
+	result.ExtraWallTime += profile.ExtraWallTime
+	if result.MemoryLimit > 0 {
+		if profile.MemoryFactor > 1 {
+			result.MemoryLimit = base.Byte(float64(result.MemoryLimit) * profile.MemoryFactor)
+		}
+		result.MemoryLimit = base.Min(config.HardMemoryLimit, result.MemoryLimit)
+	}
+	return result
+}
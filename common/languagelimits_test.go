@@ -0,0 +1,114 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+func TestSandboxLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.Runner.HardMemoryLimit = 640 * base.Mebibyte
+	config.Runner.Languages = map[string]RunnerLanguageConfig{
+		"py3": {Limits: &LanguageLimits{ExtraTime: base.Duration(500 * time.Millisecond), MemoryFactor: 1.5}},
+		"go":  {Limits: &LanguageLimits{}},
+		"kt":  {Compiler: "/opt/kotlinc/bin/kotlinc"},
+	}
+	for _, tc := range []struct {
+		lang     string
+		limits   LimitsSettings
+		expected LimitsSettings
+	}{
+		{
+			"cpp17-gcc",
+			LimitsSettings{TimeLimit: base.Duration(time.Second), MemoryLimit: 256 * base.Mebibyte},
+			LimitsSettings{TimeLimit: base.Duration(time.Second), MemoryLimit: 256 * base.Mebibyte},
+		},
+		{
+			"cpp17-gcc",
+			LimitsSettings{MemoryLimit: 1024 * base.Mebibyte},
+			LimitsSettings{MemoryLimit: 640 * base.Mebibyte},
+		},
+		{
+			"java",
+			LimitsSettings{TimeLimit: base.Duration(time.Second), ExtraWallTime: base.Duration(500 * time.Millisecond)},
+			LimitsSettings{TimeLimit: base.Duration(2 * time.Second), ExtraWallTime: base.Duration(500 * time.Millisecond)},
+		},
+		{
+			"kt",
+			LimitsSettings{TimeLimit: base.Duration(time.Second), ExtraWallTime: base.Duration(500 * time.Millisecond)},
+			LimitsSettings{TimeLimit: base.Duration(2 * time.Second), ExtraWallTime: base.Duration(1500 * time.Millisecond)},
+		},
+		{
+			"py3",
+			LimitsSettings{TimeLimit: base.Duration(time.Second), MemoryLimit: 256 * base.Mebibyte},
+			LimitsSettings{TimeLimit: base.Duration(1500 * time.Millisecond), MemoryLimit: 384 * base.Mebibyte},
+		},
+		{
+			"py3",
+			LimitsSettings{MemoryLimit: 512 * base.Mebibyte},
+			LimitsSettings{TimeLimit: base.Duration(500 * time.Millisecond), MemoryLimit: 640 * base.Mebibyte},
+		},
+		{
+			"py3-pypy",
+			LimitsSettings{TimeLimit: base.Duration(time.Second), MemoryLimit: 256 * base.Mebibyte},
+			LimitsSettings{TimeLimit: base.Duration(2 * time.Second), MemoryLimit: 256 * base.Mebibyte},
+		},
+		// Languages that are configured without a profile keep the default one.
+		{
+			"kt",
+			LimitsSettings{TimeLimit: base.Duration(time.Second)},
+			LimitsSettings{TimeLimit: base.Duration(2 * time.Second), ExtraWallTime: base.Duration(time.Second)},
+		},
+		// The configured profile replaces the default one.
+		{
+			"go",
+			LimitsSettings{MemoryLimit: 256 * base.Mebibyte},
+			LimitsSettings{MemoryLimit: 256 * base.Mebibyte},
+		},
+		{
+			"py3",
+			LimitsSettings{MemoryLimit: 0},
+			LimitsSettings{TimeLimit: base.Duration(500 * time.Millisecond), MemoryLimit: 0},
+		},
+	} {
+		limits := tc.limits
+		if result := config.Runner.SandboxLimits(tc.lang, &limits); result != tc.expected {
+			t.Errorf("SandboxLimits(%q, %+v) == %+v, want %+v", tc.lang, tc.limits, result, tc.expected)
+		}
+		if limits != tc.limits {
+			t.Errorf("SandboxLimits(%q) modified the limits: %+v", tc.lang, limits)
+		}
+	}
+
+	// Without a configured profile, Go programs get the default one.
+	config.Runner.Languages = nil
+	limits := &LimitsSettings{MemoryLimit: 256 * base.Mebibyte}
+	if result := config.Runner.SandboxLimits("go", limits); result.MemoryLimit != 512*base.Mebibyte {
+		t.Errorf("SandboxLimits(\"go\").MemoryLimit == %d, want %d", result.MemoryLimit, 512*base.Mebibyte)
+	}
+}
+
+func TestRunnerLanguage(t *testing.T) {
+	config := DefaultConfig()
+	config.Runner.Languages = map[string]RunnerLanguageConfig{
+		"kt":   {CompilerFlags: []string{"-nowarn"}},
+		"cpp":  {ParentFlags: []string{}},
+		"rust": {Compiler: "/usr/bin/rustc", Policy: "c"},
+	}
+
+	kt := config.Runner.Language("kt")
+	if kt.Compiler != "/usr/bin/kotlinc" || len(kt.CompilerFlags) != 1 || kt.Policy != "java" || kt.Limits == nil {
+		t.Errorf("Language(\"kt\") == %+v, want the defaults with the configured flags", kt)
+	}
+	if cpp := config.Runner.Language("cpp"); cpp.ParentFlags == nil || len(cpp.ParentFlags) != 0 {
+		t.Errorf("Language(\"cpp\").ParentFlags == %q, want none", cpp.ParentFlags)
+	}
+	if c := config.Runner.Language("c"); len(c.ParentFlags) != 1 {
+		t.Errorf("Language(\"c\").ParentFlags == %q, want the default ones", c.ParentFlags)
+	}
+	if rust := config.Runner.Language("rust"); rust.Compiler != "/usr/bin/rustc" || rust.Policy != "c" || rust.Limits != nil {
+		t.Errorf("Language(\"rust\") == %+v, want the configured one", rust)
+	}
+}
@@ -0,0 +1,209 @@
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A lazy input archive is an alternative to the .tar.gz representation of an
+// Input that allows extracting individual files without decompressing the
+// whole archive, so that runners only need to decompress the cases that are
+// actually run. Its layout is:
+//
+//	blob 0 | blob 1 | ... | blob n-1 | index | trailer
+//
+// Each blob is an individually gzip-compressed file. The index is a JSON
+// object that maps each file's path to its LazyArchiveEntry, and the trailer is
+// the big-endian 64-bit offset of the index followed by LazyArchiveMagic.
+
+const (
+	// LazyArchiveMagic is the magic string at the very end of a lazy input
+	// archive.
+	LazyArchiveMagic = "OMGLAZY1"
+
+	// LazyArchiveContentType is the media type of lazy input archives. Runners
+	// that support them include it in the Accept header of input requests.
+	LazyArchiveContentType = "application/x-omegaup-lazy-input"
+)
+
+const lazyArchiveTrailerSize = 8 + len(LazyArchiveMagic)
+
+var (
+	// ErrNotLazyArchive is returned when trying to open a file that is not a
+	// lazy input archive.
+	ErrNotLazyArchive = stderrors.New("not a lazy input archive")
+)
+
+// LazyArchiveEntry is the location of a single file in a lazy input archive.
+type LazyArchiveEntry struct {
+	Offset         int64  `json:"offset"`
+	CompressedSize int64  `json:"compressed_size"`
+	Size           int64  `json:"size"`
+	SHA1           string `json:"sha1"`
+}
+
+// LazyArchive is an opened lazy input archive.
+type LazyArchive struct {
+	r     io.ReaderAt
+	Index map[string]LazyArchiveEntry
+}
+
+// IsLazyCaseFile returns whether the file with the provided path inside an
+// Input is only extracted when the case it belongs to is run.
+func IsLazyCaseFile(name string) bool {
+	return strings.HasPrefix(name, "cases/") &&
+		(strings.HasSuffix(name, ".in") || strings.HasSuffix(name, ".out"))
+}
+
+// OpenLazyArchive reads the index of the lazy input archive of the provided
+// size.
+func OpenLazyArchive(r io.ReaderAt, size int64) (*LazyArchive, error) {
+	if size < int64(lazyArchiveTrailerSize) {
+		return nil, ErrNotLazyArchive
+	}
+	trailer := make([]byte, lazyArchiveTrailerSize)
+	if _, err := r.ReadAt(trailer, size-int64(lazyArchiveTrailerSize)); err != nil {
+		return nil, fmt.Errorf("read trailer: %w", err)
+	}
+	if string(trailer[8:]) != LazyArchiveMagic {
+		return nil, ErrNotLazyArchive
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(trailer[:8]))
+	indexSize := size - int64(lazyArchiveTrailerSize) - indexOffset
+	if indexOffset < 0 || indexSize < 0 {
+		return nil, fmt.Errorf("invalid index offset %d", indexOffset)
+	}
+	archive := &LazyArchive{r: r}
+	if err := json.NewDecoder(io.NewSectionReader(r, indexOffset, indexSize)).Decode(&archive.Index); err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	for name, entry := range archive.Index {
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") || path.Clean(name) != name {
+			return nil, fmt.Errorf("invalid path %q", name)
+		}
+		if entry.Offset < 0 || entry.CompressedSize < 0 || entry.Offset+entry.CompressedSize > indexOffset {
+			return nil, fmt.Errorf("invalid entry for %q", name)
+		}
+	}
+	return archive, nil
+}
+
+// Names returns the sorted list of the paths of the files in the archive.
+func (a *LazyArchive) Names() []string {
+	names := make([]string, 0, len(a.Index))
+	for name := range a.Index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extract decompresses the file with the provided name into dst, verifying
+// its hash.
+func (a *LazyArchive) Extract(name string, dst string) error {
+	entry, ok := a.Index[name]
+	if !ok {
+		return fmt.Errorf("%q not found in the archive: %w", name, os.ErrNotExist)
+	}
+	gz, err := gzip.NewReader(io.NewSectionReader(a.r, entry.Offset, entry.CompressedSize))
+	if err != nil {
+		return fmt.Errorf("decompress %q: %w", name, err)
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmpPath := dst + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	hasher := NewHashReader(io.LimitReader(gz, entry.Size+1), sha1.New())
+	n, err := io.Copy(f, hasher)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("decompress %q: %w", name, err)
+	}
+	if n != entry.Size {
+		return fmt.Errorf("size mismatch for %q: got %d, want %d", name, n, entry.Size)
+	}
+	if actualHash := fmt.Sprintf("%0x", hasher.Sum(nil)); actualHash != entry.SHA1 {
+		return fmt.Errorf("hash mismatch for %q: got %s, want %s", name, actualHash, entry.SHA1)
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// WriteLazyArchive converts the uncompressed tar stream in r into a lazy input
+// archive that is written to w. It returns the total uncompressed size of the
+// files in the archive.
+func WriteLazyArchive(w io.Writer, r io.Reader) (int64, error) {
+	archive := tar.NewReader(r)
+	index := make(map[string]LazyArchiveEntry)
+	var offset, uncompressedSize int64
+	var blob bytes.Buffer
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		blob.Reset()
+		gz := gzip.NewWriter(&blob)
+		hasher := NewHashReader(archive, sha1.New())
+		size, err := io.Copy(gz, hasher)
+		if err != nil {
+			return 0, err
+		}
+		if err := gz.Close(); err != nil {
+			return 0, err
+		}
+		n, err := blob.WriteTo(w)
+		if err != nil {
+			return 0, err
+		}
+		index[path.Clean(hdr.Name)] = LazyArchiveEntry{
+			Offset:         offset,
+			CompressedSize: n,
+			Size:           size,
+			SHA1:           fmt.Sprintf("%0x", hasher.Sum(nil)),
+		}
+		offset += n
+		uncompressedSize += size
+	}
+
+	marshaledIndex, err := json.Marshal(index)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(marshaledIndex); err != nil {
+		return 0, err
+	}
+	trailer := make([]byte, 8, lazyArchiveTrailerSize)
+	binary.BigEndian.PutUint64(trailer, uint64(offset))
+	trailer = append(trailer, LazyArchiveMagic...)
+	if _, err := w.Write(trailer); err != nil {
+		return 0, err
+	}
+	return uncompressedSize, nil
+}
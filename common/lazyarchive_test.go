@@ -0,0 +1,90 @@
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestLazyArchive(t *testing.T) {
+	files := map[string]string{
+		"settings.json": "{}",
+		"cases/0.in":    "1 2",
+		"cases/0.out":   "3",
+	}
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for _, name := range []string{"settings.json", "cases/0.in", "cases/0.out"} {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     "./" + name,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatalf("Failed to write header for %s: %v", name, err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar: %v", err)
+	}
+
+	var lazyBuf bytes.Buffer
+	uncompressedSize, err := WriteLazyArchive(&lazyBuf, &tarBuf)
+	if err != nil {
+		t.Fatalf("WriteLazyArchive() failed: %v", err)
+	}
+	if uncompressedSize != 6 {
+		t.Errorf("uncompressedSize == %d, want 6", uncompressedSize)
+	}
+
+	contents := lazyBuf.Bytes()
+	archive, err := OpenLazyArchive(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		t.Fatalf("OpenLazyArchive() failed: %v", err)
+	}
+	expectedNames := []string{"cases/0.in", "cases/0.out", "settings.json"}
+	if !reflect.DeepEqual(expectedNames, archive.Names()) {
+		t.Errorf("Names() == %v, want %v", archive.Names(), expectedNames)
+	}
+
+	dir := t.TempDir()
+	for name, expected := range files {
+		dst := path.Join(dir, name)
+		if err := archive.Extract(name, dst); err != nil {
+			t.Fatalf("Extract(%q) failed: %v", name, err)
+		}
+		actual, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", dst, err)
+		}
+		if string(actual) != expected {
+			t.Errorf("contents of %s == %q, want %q", name, string(actual), expected)
+		}
+	}
+	if err := archive.Extract("cases/1.in", path.Join(dir, "cases/1.in")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Extract() of a missing file == %v, want %v", err, os.ErrNotExist)
+	}
+
+	entry := archive.Index["cases/0.in"]
+	entry.SHA1 = "0000000000000000000000000000000000000000"
+	archive.Index["cases/0.in"] = entry
+	if err := archive.Extract("cases/0.in", path.Join(dir, "corrupt.in")); err == nil {
+		t.Errorf("Extract() with a hash mismatch succeeded")
+	}
+
+	if !IsLazyCaseFile("cases/0.in") || IsLazyCaseFile("settings.json") || IsLazyCaseFile("cases/0.meta") {
+		t.Errorf("IsLazyCaseFile() returned unexpected results")
+	}
+
+	notLazy := []byte("not a lazy input archive at all")
+	if _, err := OpenLazyArchive(bytes.NewReader(notLazy), int64(len(notLazy))); !errors.Is(err, ErrNotLazyArchive) {
+		t.Errorf("OpenLazyArchive() of another format == %v, want %v", err, ErrNotLazyArchive)
+	}
+}
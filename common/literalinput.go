@@ -181,7 +181,7 @@ type LiteralRun struct {
 
 func validateLanguage(lang string) error {
 	switch lang {
-	case "c", "c11-gcc", "c11-clang", "cpp", "cpp11", "cpp17-gcc", "cpp17-clang", "kj", "kp", "java", "py", "py2", "py3", "pas", "rb", "cat":
+	case "c", "c11-gcc", "c11-clang", "cpp", "cpp11", "cpp17-gcc", "cpp17-clang", "kj", "kp", "java", "kt", "cs", "js", "py", "py2", "py3", "py3-pypy", "pas", "rb", "go", "cat":
 		return nil
 	default:
 		return fmt.Errorf("invalid language %q", lang)
@@ -215,7 +215,7 @@ func LanguageFileExtension(language string) string {
 		return "cpp"
 	case "c", "c11-gcc", "c11-clang":
 		return "c"
-	case "py", "py2", "py3":
+	case "py", "py2", "py3", "py3-pypy":
 		return "py"
 	}
 	return language
@@ -564,20 +564,23 @@ func (input *inMemoryInput) Persist() error {
 // Transmit sends a serialized version of the Input to the runner. It sends a
 // .tar.gz file with the Content-SHA1 header with the hexadecimal
 // representation of its SHA-1 hash.
-func (input *inMemoryInput) Transmit(w http.ResponseWriter) error {
+func (input *inMemoryInput) Transmit(w http.ResponseWriter, r *http.Request) error {
 	fd, err := os.Open(input.archivePath)
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
 	w.Header().Add("Content-Type", "application/x-gzip")
 	w.Header().Add("Content-SHA1", input.hash)
 	w.Header().Add(
 		"X-Content-Uncompressed-Size", strconv.FormatInt(input.uncompressedSize.Bytes(), 10),
 	)
-	w.WriteHeader(http.StatusOK)
-	_, err = io.Copy(w, fd)
-	return err
+	http.ServeContent(w, r, "", info.ModTime(), fd)
+	return nil
 }
 
 func (input *inMemoryInput) Delete() error {
@@ -33,6 +33,7 @@ var (
 		"cases",
 		"settings.json",
 		"testplan",
+		"hooks",
 
 		// public/private:
 		"interactive",
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -63,8 +64,108 @@ const (
 	// GroupScorePolicyMin assigns the minimum of all the individual cases'
 	// scores multiplied by the weight of the group.
 	GroupScorePolicyMin GroupScorePolicy = "min"
+
+	// GroupScorePolicySum assigns the sum of all the individual cases'
+	// scores, even if some of them are zero.
+	GroupScorePolicySum GroupScorePolicy = "sum"
+
+	// GroupScorePolicyAllOrNothing assigns the weight of the group only if
+	// all the individual cases got a full score, and zero otherwise.
+	GroupScorePolicyAllOrNothing GroupScorePolicy = "all-or-nothing"
+
+	// GroupScorePolicyMultiplicative assigns the product of all the
+	// individual cases' scores multiplied by the weight of the group.
+	GroupScorePolicyMultiplicative GroupScorePolicy = "multiplicative"
 )
 
+// Valid returns whether the policy is one of the known ones.
+func (p GroupScorePolicy) Valid() bool {
+	switch p {
+	case GroupScorePolicyDefault,
+		GroupScorePolicySumIfNotZero,
+		GroupScorePolicyMin,
+		GroupScorePolicySum,
+		GroupScorePolicyAllOrNothing,
+		GroupScorePolicyMultiplicative:
+		return true
+	}
+	return false
+}
+
+// CaseVisibility determines how much of the results of a case can be shown to
+// contestants.
+type CaseVisibility string
+
+const (
+	// CaseVisibilityHidden only allows the verdict and score of the case to be
+	// shown. This is the default, and will be used if the visibility is not
+	// selected.
+	CaseVisibilityHidden CaseVisibility = "hidden"
+
+	// CaseVisibilityDefault is an alias of CaseVisibilityHidden.
+	CaseVisibilityDefault CaseVisibility = ""
+
+	// CaseVisibilityPublic allows all the results of the case to be shown.
+	CaseVisibilityPublic CaseVisibility = "public"
+)
+
+// ScoreRoundingMode determines how scores are rounded.
+type ScoreRoundingMode string
+
+const (
+	// ScoreRoundingModeNone does not round scores. This is the default.
+	ScoreRoundingModeNone ScoreRoundingMode = ""
+
+	// ScoreRoundingModeRound rounds scores to the nearest value, with halves
+	// rounded up.
+	ScoreRoundingModeRound ScoreRoundingMode = "round"
+
+	// ScoreRoundingModeFloor rounds scores down.
+	ScoreRoundingModeFloor ScoreRoundingMode = "floor"
+)
+
+// NetworkPolicy determines what network access the programs have while they
+// run.
+type NetworkPolicy string
+
+const (
+	// NetworkPolicyNone does not allow any network access. This is the default,
+	// and will be used if the policy is not selected.
+	NetworkPolicyNone NetworkPolicy = "none"
+
+	// NetworkPolicyDefault is an alias of NetworkPolicyNone.
+	NetworkPolicyDefault NetworkPolicy = ""
+
+	// NetworkPolicyLoopback only allows connections through the loopback
+	// interface of the sandbox, for problems that need a local client/server
+	// pair. All other network access is still blocked.
+	NetworkPolicyLoopback NetworkPolicy = "loopback"
+)
+
+// ScoreRoundingSettings determines how the contest scores are rounded when
+// they are stored and broadcast. Setting DecimalPlaces to zero only allows
+// integer scores.
+type ScoreRoundingSettings struct {
+	Mode          ScoreRoundingMode `json:"Mode,omitempty"`
+	DecimalPlaces int               `json:"DecimalPlaces,omitempty"`
+}
+
+// Round returns the score rounded according to the settings.
+func (s *ScoreRoundingSettings) Round(score *big.Rat) *big.Rat {
+	if s == nil || s.Mode == ScoreRoundingModeNone || score == nil {
+		return score
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(s.DecimalPlaces)), nil)
+	scaled := new(big.Rat).Mul(score, new(big.Rat).SetInt(scale))
+	if s.Mode == ScoreRoundingModeRound {
+		scaled.Add(scaled, big.NewRat(1, 2))
+	}
+	// The denominator is always positive, so Div rounds towards negative
+	// infinity.
+	rounded := new(big.Int).Div(scaled.Num(), scaled.Denom())
+	return new(big.Rat).SetFrac(rounded, scale)
+}
+
 // ValidatorSettings represents the options used to validate outputs.
 type ValidatorSettings struct {
 	Lang             *string          `json:"Lang,omitempty"`
@@ -74,6 +175,39 @@ type ValidatorSettings struct {
 	GroupScorePolicy GroupScorePolicy `json:"GroupScorePolicy,omitempty"`
 }
 
+// HookSettings represents a program from the input that runs at one of the
+// hook points of the grading pipeline. Its source is
+// hooks/<point>.<lang> (e.g. hooks/pre-case.py3), and it runs in the sandbox
+// with the limits of the validator, unless they are overridden.
+type HookSettings struct {
+	Lang   string          `json:"Lang"`
+	Limits *LimitsSettings `json:"Limits,omitempty"`
+}
+
+// HooksSettings contains the programs that run at the hook points of the
+// grading pipeline. Each of them receives a file in its standard input, and
+// its standard output replaces it.
+type HooksSettings struct {
+	// PreCompile receives the source of the submission before it is compiled.
+	PreCompile *HookSettings `json:"PreCompile,omitempty"`
+
+	// PreCase receives the input of each case before it runs. Both the
+	// programs and the validator receive its output instead of the input.
+	PreCase *HookSettings `json:"PreCase,omitempty"`
+
+	// PostCase receives the output of the submission after each case that
+	// runs correctly, and its output is what is validated.
+	PostCase *HookSettings `json:"PostCase,omitempty"`
+
+	// Scorer receives the results of all the cases after they are validated
+	// as JSON, and writes the score of the run between 0 and 1, optionally
+	// followed by the names of groups and their scores. It replaces the
+	// scores that the validator and the score policies of the groups gave,
+	// for problems whose score is not a combination of the scores of the
+	// cases.
+	Scorer *HookSettings `json:"Scorer,omitempty"`
+}
+
 // InteractiveInterface represents the metadata needed to compile and run
 // libinteractive problems.
 type InteractiveInterface struct {
@@ -106,16 +240,64 @@ type InteractiveSettings struct {
 type CaseSettings struct {
 	Name   string
 	Weight *big.Rat
+
+	// Points is the absolute number of points of the case. If any case of a
+	// problem has points, they are used for scoring instead of the weights,
+	// and they should add up to the MaxScore of the runs.
+	Points     *big.Rat
+	Visibility CaseVisibility
+
+	// TimeLimit, MemoryLimit and OutputLimit replace the limits of the problem
+	// for this case if they are not zero, for problems that have a case that
+	// needs more resources than the rest.
+	TimeLimit   base.Duration
+	MemoryLimit base.Byte
+	OutputLimit base.Byte
+}
+
+// HasLimits returns whether the case replaces any of the limits of the
+// problem.
+func (c *CaseSettings) HasLimits() bool {
+	return c.TimeLimit != 0 || c.MemoryLimit != 0 || c.OutputLimit != 0
+}
+
+// ApplyLimits replaces the limits of the problem with the ones of the case
+// that are not zero.
+func (c *CaseSettings) ApplyLimits(limits *LimitsSettings) {
+	if c.TimeLimit != 0 {
+		limits.TimeLimit = c.TimeLimit
+	}
+	if c.MemoryLimit != 0 {
+		limits.MemoryLimit = c.MemoryLimit
+	}
+	if c.OutputLimit != 0 {
+		limits.OutputLimit = c.OutputLimit
+	}
 }
 
 // MarshalJSON implements the json.Marshaler interface.
 func (c *CaseSettings) MarshalJSON() ([]byte, error) {
+	var points *float64
+	if c.Points != nil {
+		value := base.RationalToFloat(c.Points)
+		points = &value
+	}
 	return json.Marshal(&struct {
-		Name   string
-		Weight float64
+		Name        string
+		Weight      float64
+		Points      *float64       `json:",omitempty"`
+		Visibility  CaseVisibility `json:",omitempty"`
+		TimeLimit   base.Duration  `json:",omitempty"`
+		MemoryLimit base.Byte      `json:",omitempty"`
+		OutputLimit base.Byte      `json:",omitempty"`
 	}{
-		Name:   c.Name,
-		Weight: base.RationalToFloat(c.Weight),
+		Name:        c.Name,
+		Weight:      base.RationalToFloat(c.Weight),
+		Points:      points,
+		Visibility:  c.Visibility,
+		TimeLimit:   c.TimeLimit,
+		MemoryLimit: c.MemoryLimit,
+		OutputLimit: c.OutputLimit,
 	})
 }
 
@@ -126,8 +308,13 @@ func (c *CaseSettings) UnmarshalJSON(data []byte) error {
 	}
 
 	settings := struct {
-		Name   string
-		Weight float64
+		Name        string
+		Weight      float64
+		Points      *float64       `json:",omitempty"`
+		Visibility  CaseVisibility `json:",omitempty"`
+		TimeLimit   base.Duration  `json:",omitempty"`
+		MemoryLimit base.Byte      `json:",omitempty"`
+		OutputLimit base.Byte      `json:",omitempty"`
 	}{}
 
 	if err := json.Unmarshal(data, &settings); err != nil {
@@ -136,6 +323,15 @@ func (c *CaseSettings) UnmarshalJSON(data []byte) error {
 
 	c.Name = settings.Name
 	c.Weight = base.FloatToRational(settings.Weight)
+	if settings.Points != nil {
+		c.Points = base.FloatToRational(*settings.Points)
+	} else {
+		c.Points = nil
+	}
+	c.Visibility = settings.Visibility
+	c.TimeLimit = settings.TimeLimit
+	c.MemoryLimit = settings.MemoryLimit
+	c.OutputLimit = settings.OutputLimit
 
 	return nil
 }
@@ -152,6 +348,24 @@ func (c ByCaseName) Less(i, j int) bool { return c[i].Name < c[j].Name }
 type GroupSettings struct {
 	Cases []CaseSettings
 	Name  string
+
+	// ScorePolicy overrides the GroupScorePolicy of the validator for this
+	// group.
+	ScorePolicy GroupScorePolicy `json:",omitempty"`
+
+	// Validator overrides the validator of the problem for this group. Custom
+	// validators use the validator.<lang> file of the problem, so all the
+	// custom validators of a problem must have the same language.
+	Validator *ValidatorSettings `json:",omitempty"`
+
+	// FailFast skips the rest of the cases of the group once one of them does
+	// not run successfully.
+	FailFast bool `json:",omitempty"`
+
+	// DependsOn are the names of the groups whose cases must all run
+	// successfully for this group to be run. Only the groups that run before
+	// this one are taken into account.
+	DependsOn []string `json:",omitempty"`
 }
 
 // Weight returns the sum of the individual case weights.
@@ -179,6 +393,208 @@ type ProblemSettings struct {
 	Limits      LimitsSettings       `json:"Limits"`
 	Slow        bool                 `json:"Slow"`
 	Validator   ValidatorSettings    `json:"Validator"`
+
+	// ScoreRounding overrides the grader's default score rounding policy for
+	// this problem.
+	ScoreRounding *ScoreRoundingSettings `json:"ScoreRounding,omitempty"`
+
+	// NormalizeWeights makes the weights of the cases be scaled so that they
+	// add up to 1. Otherwise the weights are used as-is.
+	NormalizeWeights bool `json:"NormalizeWeights,omitempty"`
+
+	// Toolchains pins the toolchain that compiles the submissions in each
+	// language (e.g. {"cpp17-gcc": "g++-10"}), for problems that depend on a
+	// particular compiler version.
+	Toolchains map[string]string `json:"Toolchains,omitempty"`
+
+	// NetworkPolicy determines what network access the programs have.
+	NetworkPolicy NetworkPolicy `json:"NetworkPolicy,omitempty"`
+
+	// Languages restricts the languages that submissions can use. Empty
+	// allows all of them.
+	Languages []string `json:"Languages,omitempty"`
+
+	// MaxSourceSize is the maximum size of the source of submissions, in
+	// addition to the grader's limit. 0 disables it.
+	MaxSourceSize base.Byte `json:"MaxSourceSize,omitempty"`
+
+	// Hooks are the programs from the input that run at the hook points of
+	// the grading pipeline.
+	Hooks *HooksSettings `json:"Hooks,omitempty"`
+}
+
+var (
+	// ErrLanguageNotAllowed is returned when a submission uses a language that
+	// the problem does not allow.
+	ErrLanguageNotAllowed = stderrors.New("language not allowed")
+
+	// ErrSourceTooLarge is returned when the source of a submission is larger
+	// than what the problem allows.
+	ErrSourceTooLarge = stderrors.New("source too large")
+)
+
+// ValidateSubmission returns an error if a submission in the language and
+// with a source of the provided size is not accepted by the problem. The
+// error wraps ErrLanguageNotAllowed or ErrSourceTooLarge.
+func (s *ProblemSettings) ValidateSubmission(language string, sourceSize base.Byte) error {
+	if len(s.Languages) != 0 {
+		allowed := false
+		for _, allowedLanguage := range s.Languages {
+			if language == allowedLanguage {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf(
+				"%w: %q is not one of %s",
+				ErrLanguageNotAllowed,
+				language,
+				strings.Join(s.Languages, ", "),
+			)
+		}
+	}
+	if s.MaxSourceSize > 0 && sourceSize > s.MaxSourceSize {
+		return fmt.Errorf(
+			"%w: %d bytes is more than the %d allowed",
+			ErrSourceTooLarge,
+			sourceSize.Bytes(),
+			s.MaxSourceSize.Bytes(),
+		)
+	}
+	return nil
+}
+
+// GroupScorePolicy returns the policy that assigns the score of the group:
+// its own, or the one of its validator if it does not have one.
+func (s *ProblemSettings) GroupScorePolicy(group *GroupSettings) GroupScorePolicy {
+	if group.ScorePolicy != GroupScorePolicyDefault {
+		return group.ScorePolicy
+	}
+	return s.GroupValidator(group).GroupScorePolicy
+}
+
+// GroupValidator returns the validator of the group: its own, or the one of
+// the problem if it does not have one.
+func (s *ProblemSettings) GroupValidator(group *GroupSettings) *ValidatorSettings {
+	if group.Validator != nil {
+		return group.Validator
+	}
+	return &s.Validator
+}
+
+// CustomValidatorLang returns the language of the custom validator that the
+// problem or any of its groups use, or an empty string if none of them do,
+// together with the limits of the first one of them, which are the ones it is
+// compiled with.
+func (s *ProblemSettings) CustomValidatorLang() (string, *LimitsSettings, error) {
+	lang := ""
+	var limits *LimitsSettings
+	validators := []*ValidatorSettings{&s.Validator}
+	for _, group := range s.Cases {
+		if group.Validator != nil {
+			validators = append(validators, group.Validator)
+		}
+	}
+	for _, validator := range validators {
+		if validator.Name != ValidatorNameCustom {
+			continue
+		}
+		if validator.Lang == nil || *validator.Lang == "" {
+			return "", nil, errors.New("custom validator has no language")
+		}
+		if lang != "" && lang != *validator.Lang {
+			return "", nil, errors.Errorf(
+				"custom validators have different languages: %q and %q",
+				lang,
+				*validator.Lang,
+			)
+		}
+		if lang == "" {
+			limits = validator.Limits
+		}
+		lang = *validator.Lang
+	}
+	return lang, limits, nil
+}
+
+// TotalWeight returns the sum of the weights of all the cases.
+func (s *ProblemSettings) TotalWeight() *big.Rat {
+	totalWeight := &big.Rat{}
+	for _, group := range s.Cases {
+		totalWeight.Add(totalWeight, group.Weight())
+	}
+	return totalWeight
+}
+
+// ValidateWeights returns an error if the weights of the cases do not add up
+// to 1 and they are not explicitly normalized or replaced by absolute points.
+func (s *ProblemSettings) ValidateWeights() error {
+	if s.NormalizeWeights || s.UsesPoints() {
+		return nil
+	}
+	if totalWeight := s.TotalWeight(); totalWeight.Cmp(big.NewRat(1, 1)) != 0 {
+		return errors.Errorf(
+			"case weights add up to %s instead of 1",
+			totalWeight.FloatString(4),
+		)
+	}
+	return nil
+}
+
+// UsesPoints returns whether any of the cases of the problem has absolute
+// points.
+func (s *ProblemSettings) UsesPoints() bool {
+	for _, group := range s.Cases {
+		for _, c := range group.Cases {
+			if c.Points != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ScoringCases returns a copy of the groups with the weights that should be
+// used for scoring. If the problem uses absolute points, the points of each
+// case are used as its weight, and cases without points get no weight. If
+// NormalizeWeights is set, the weights are scaled so that they add up to 1.
+func (s *ProblemSettings) ScoringCases() []GroupSettings {
+	usesPoints := s.UsesPoints()
+	if !usesPoints && !s.NormalizeWeights {
+		return s.Cases
+	}
+	totalWeight := &big.Rat{}
+	groups := make([]GroupSettings, len(s.Cases))
+	for i, group := range s.Cases {
+		groups[i] = GroupSettings{
+			Name:        group.Name,
+			Cases:       make([]CaseSettings, len(group.Cases)),
+			ScorePolicy: group.ScorePolicy,
+			Validator:   group.Validator,
+			FailFast:    group.FailFast,
+			DependsOn:   group.DependsOn,
+		}
+		for j, c := range group.Cases {
+			if !usesPoints {
+				c.Weight = new(big.Rat).Set(c.Weight)
+			} else if c.Points != nil {
+				c.Weight = new(big.Rat).Set(c.Points)
+			} else {
+				c.Weight = &big.Rat{}
+			}
+			totalWeight.Add(totalWeight, c.Weight)
+			groups[i].Cases[j] = c
+		}
+	}
+	if s.NormalizeWeights && totalWeight.Sign() > 0 {
+		for _, group := range groups {
+			for j := range group.Cases {
+				group.Cases[j].Weight.Quo(group.Cases[j].Weight, totalWeight)
+			}
+		}
+	}
+	return groups
 }
 
 var (
@@ -247,7 +663,7 @@ func (r *ScoreRange) UnmarshalJSON(data []byte) error {
 type SolutionSettings struct {
 	Filename                   string      `json:"filename"`
 	ScoreRange                 *ScoreRange `json:"score_range,omitempty"`
-	Verdict                    string      `json:"verdict,omitempty"`
+	Verdict                    Verdict     `json:"verdict,omitempty"`
 	Language                   string      `json:"language,omitempty"`
 	AllowFractionalPercentages bool        `json:"allow_fractional_percentages,omitempty"`
 }
@@ -274,24 +690,6 @@ type TestsSettings struct {
 	ExpectedMaxScore *base.Rat                `json:"max_score,omitempty"`
 }
 
-var (
-	// VerdictList is the sorted list of verdicts from worse to better.
-	VerdictList = []string{
-		"JE",
-		"CE",
-		"RFE",
-		"VE",
-		"MLE",
-		"RTE",
-		"TLE",
-		"OLE",
-		"WA",
-		"PA",
-		"AC",
-		"OK",
-	}
-)
-
 // CaseWeightMapping is a map representation of []GroupSettings, to make it
 // possible to build it incrementally from a list of files or from a testplan
 // file.
@@ -1,10 +1,15 @@
 package common
 
 import (
+	"encoding/json"
+	"errors"
 	"math/big"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
 )
 
 func TestCaseWeightMappingSymmetricDiff(t *testing.T) {
@@ -118,3 +123,251 @@ func TestCaseWeightMappingParseTestplan(t *testing.T) {
 		t.Errorf("expected %v, got %v", expectedGroupSettings, groupSettings)
 	}
 }
+
+func TestProblemSettingsScoringCases(t *testing.T) {
+	settings := ProblemSettings{
+		Cases: []GroupSettings{
+			{
+				Name: "0",
+				Cases: []CaseSettings{
+					{
+						Name:   "0",
+						Weight: big.NewRat(1, 1),
+						Points: big.NewRat(30, 1),
+					},
+				},
+			},
+			{
+				Name: "1",
+				Cases: []CaseSettings{
+					{
+						Name:   "1",
+						Weight: big.NewRat(1, 1),
+					},
+				},
+			},
+		},
+	}
+	if !settings.UsesPoints() {
+		t.Errorf("expected settings to use points")
+	}
+	scoringCases := settings.ScoringCases()
+	if scoringCases[0].Cases[0].Weight.Cmp(big.NewRat(30, 1)) != 0 {
+		t.Errorf("expected weight 30, got %v", scoringCases[0].Cases[0].Weight)
+	}
+	if scoringCases[1].Cases[0].Weight.Cmp(&big.Rat{}) != 0 {
+		t.Errorf("expected weight 0, got %v", scoringCases[1].Cases[0].Weight)
+	}
+	if settings.Cases[0].Cases[0].Weight.Cmp(big.NewRat(1, 1)) != 0 {
+		t.Errorf("original settings were modified: %v", settings.Cases[0].Cases[0].Weight)
+	}
+
+	settings.Cases[0].Cases[0].Points = nil
+	if settings.UsesPoints() {
+		t.Errorf("expected settings to not use points")
+	}
+}
+
+func TestProblemSettingsNormalizeWeights(t *testing.T) {
+	settings := ProblemSettings{
+		Cases: []GroupSettings{
+			{
+				Name: "0",
+				Cases: []CaseSettings{
+					{Name: "0.0", Weight: big.NewRat(1, 1)},
+					{Name: "0.1", Weight: big.NewRat(1, 1)},
+				},
+			},
+			{
+				Name: "1",
+				Cases: []CaseSettings{
+					{Name: "1", Weight: big.NewRat(2, 1)},
+				},
+			},
+		},
+	}
+	if err := settings.ValidateWeights(); err == nil {
+		t.Errorf("expected an error for weights that do not add up to 1")
+	}
+
+	settings.NormalizeWeights = true
+	if err := settings.ValidateWeights(); err != nil {
+		t.Errorf("unexpected error with normalized weights: %v", err)
+	}
+	scoringCases := settings.ScoringCases()
+	expectedWeights := []*big.Rat{big.NewRat(1, 4), big.NewRat(1, 4), big.NewRat(1, 2)}
+	var weights []*big.Rat
+	for _, group := range scoringCases {
+		for _, c := range group.Cases {
+			weights = append(weights, c.Weight)
+		}
+	}
+	if len(weights) != len(expectedWeights) {
+		t.Fatalf("expected %d weights, got %d", len(expectedWeights), len(weights))
+	}
+	for i := range weights {
+		if weights[i].Cmp(expectedWeights[i]) != 0 {
+			t.Errorf("expected weights %v, got %v", expectedWeights, weights)
+			break
+		}
+	}
+	if settings.Cases[1].Cases[0].Weight.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("original settings were modified: %v", settings.Cases[1].Cases[0].Weight)
+	}
+}
+
+func TestProblemSettingsValidateSubmission(t *testing.T) {
+	settings := ProblemSettings{
+		Languages:     []string{"cpp17-gcc", "py3"},
+		MaxSourceSize: base.Byte(1024),
+	}
+	for _, tc := range []struct {
+		language    string
+		sourceSize  base.Byte
+		expectedErr error
+	}{
+		{"py3", base.Byte(1024), nil},
+		{"java", base.Byte(10), ErrLanguageNotAllowed},
+		{"cpp17-gcc", base.Byte(1025), ErrSourceTooLarge},
+	} {
+		err := settings.ValidateSubmission(tc.language, tc.sourceSize)
+		if !errors.Is(err, tc.expectedErr) {
+			t.Errorf(
+				"ValidateSubmission(%q, %d) == %v, want %v",
+				tc.language,
+				tc.sourceSize.Bytes(),
+				err,
+				tc.expectedErr,
+			)
+		}
+	}
+
+	// Problems without restrictions accept everything.
+	if err := (&ProblemSettings{}).ValidateSubmission("kp", base.Byte(1)<<30); err != nil {
+		t.Errorf("ValidateSubmission() == %v, want nil", err)
+	}
+}
+
+func TestCaseSettingsLimits(t *testing.T) {
+	var c CaseSettings
+	if err := json.Unmarshal(
+		[]byte(`{"Name": "huge", "Weight": 1, "TimeLimit": "5s", "MemoryLimit": 536870912}`),
+		&c,
+	); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if !c.HasLimits() {
+		t.Errorf("HasLimits() == false, want true")
+	}
+	limits := DefaultLimits
+	c.ApplyLimits(&limits)
+	expected := DefaultLimits
+	expected.TimeLimit = base.Duration(5 * time.Second)
+	expected.MemoryLimit = 512 * base.Mebibyte
+	if limits != expected {
+		t.Errorf("ApplyLimits() == %v, want %v", limits, expected)
+	}
+
+	marshaled, err := json.Marshal(&CaseSettings{Name: "0", Weight: big.NewRat(1, 1)})
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if strings.Contains(string(marshaled), "Limit") {
+		t.Errorf("json.Marshal() == %s, want no limits", marshaled)
+	}
+	if (&CaseSettings{}).HasLimits() {
+		t.Errorf("HasLimits() == true, want false")
+	}
+}
+
+func TestProblemSettingsGroupScorePolicy(t *testing.T) {
+	settings := ProblemSettings{
+		Cases: []GroupSettings{
+			{Name: "0"},
+			{Name: "1", ScorePolicy: GroupScorePolicyMultiplicative},
+		},
+		Validator: ValidatorSettings{GroupScorePolicy: GroupScorePolicyMin},
+	}
+	for i, expected := range []GroupScorePolicy{GroupScorePolicyMin, GroupScorePolicyMultiplicative} {
+		if policy := settings.GroupScorePolicy(&settings.Cases[i]); policy != expected {
+			t.Errorf("GroupScorePolicy(%q) == %q, want %q", settings.Cases[i].Name, policy, expected)
+		}
+	}
+	if policy := settings.ScoringCases()[1].ScorePolicy; policy != GroupScorePolicyMultiplicative {
+		t.Errorf("ScoringCases()[1].ScorePolicy == %q, want %q", policy, GroupScorePolicyMultiplicative)
+	}
+
+	for _, tc := range []struct {
+		policy GroupScorePolicy
+		valid  bool
+	}{
+		{GroupScorePolicyDefault, true},
+		{GroupScorePolicySum, true},
+		{GroupScorePolicyAllOrNothing, true},
+		{"max", false},
+	} {
+		if valid := tc.policy.Valid(); valid != tc.valid {
+			t.Errorf("GroupScorePolicy(%q).Valid() == %v, want %v", tc.policy, valid, tc.valid)
+		}
+	}
+}
+
+func TestProblemSettingsGroupValidator(t *testing.T) {
+	py3, cpp := "py3", "cpp17-gcc"
+	settings := ProblemSettings{
+		Cases: []GroupSettings{
+			{Name: "0"},
+			{
+				Name: "1",
+				Validator: &ValidatorSettings{
+					Name:             ValidatorNameCustom,
+					Lang:             &py3,
+					GroupScorePolicy: GroupScorePolicyMin,
+					Limits:           &LimitsSettings{TimeLimit: base.Duration(3 * time.Second)},
+				},
+			},
+		},
+		Validator: ValidatorSettings{Name: ValidatorNameToken},
+	}
+	if validator := settings.GroupValidator(&settings.Cases[0]); validator != &settings.Validator {
+		t.Errorf("GroupValidator(\"0\") == %v, want the validator of the problem", validator)
+	}
+	if validator := settings.GroupValidator(&settings.Cases[1]); validator.Name != ValidatorNameCustom {
+		t.Errorf("GroupValidator(\"1\") == %v, want the validator of the group", validator)
+	}
+	if policy := settings.GroupScorePolicy(&settings.Cases[1]); policy != GroupScorePolicyMin {
+		t.Errorf("GroupScorePolicy(\"1\") == %q, want %q", policy, GroupScorePolicyMin)
+	}
+	if lang, limits, err := settings.CustomValidatorLang(); err != nil || lang != py3 ||
+		limits == nil || limits.TimeLimit != base.Duration(3*time.Second) {
+		t.Errorf("CustomValidatorLang() == %q, %v, %v, want %q with the limits of the group", lang, limits, err, py3)
+	}
+
+	settings.Validator = ValidatorSettings{Name: ValidatorNameCustom, Lang: &cpp}
+	if _, _, err := settings.CustomValidatorLang(); err == nil {
+		t.Errorf("CustomValidatorLang() with different languages succeeded, want an error")
+	}
+	if lang, limits, err := (&ProblemSettings{}).CustomValidatorLang(); err != nil || lang != "" || limits != nil {
+		t.Errorf("CustomValidatorLang() == %q, %v, %v, want no language", lang, limits, err)
+	}
+}
+
+func TestScoreRoundingSettingsRound(t *testing.T) {
+	for _, entry := range []struct {
+		settings *ScoreRoundingSettings
+		score    *big.Rat
+		expected *big.Rat
+	}{
+		{nil, big.NewRat(2, 3), big.NewRat(2, 3)},
+		{&ScoreRoundingSettings{}, big.NewRat(2, 3), big.NewRat(2, 3)},
+		{&ScoreRoundingSettings{Mode: ScoreRoundingModeFloor, DecimalPlaces: 2}, big.NewRat(2, 3), big.NewRat(66, 100)},
+		{&ScoreRoundingSettings{Mode: ScoreRoundingModeRound, DecimalPlaces: 2}, big.NewRat(2, 3), big.NewRat(67, 100)},
+		{&ScoreRoundingSettings{Mode: ScoreRoundingModeRound}, big.NewRat(5, 2), big.NewRat(3, 1)},
+		{&ScoreRoundingSettings{Mode: ScoreRoundingModeFloor}, big.NewRat(299, 3), big.NewRat(99, 1)},
+	} {
+		got := entry.settings.Round(entry.score)
+		if got.Cmp(entry.expected) != 0 {
+			t.Errorf("%v.Round(%v) == %v, expected %v", entry.settings, entry.score, got, entry.expected)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"sort"
+	"strings"
+)
+
+// RunnerProtocolVersion is the version of the protocol that the grader and
+// the runners use to exchange runs. It is only incremented for changes that
+// cannot be expressed as a new RunFeature.
+const RunnerProtocolVersion = 1
+
+// A RunFeature is something that a run needs from the runner that grades it
+// and that not all runners might support. Runners advertise the features they
+// support when they request a run, and the grader only hands them runs that
+// require a subset of those, so that runners with different versions can be
+// part of the same fleet during upgrades.
+type RunFeature string
+
+const (
+	// RunFeatureSandboxProfiles is required by runs with a SandboxProfile.
+	RunFeatureSandboxProfiles RunFeature = "sandbox-profiles"
+
+	// RunFeatureToolchains is required by runs with a pinned Toolchain.
+	RunFeatureToolchains RunFeature = "toolchains"
+
+	// RunFeatureLimitsOverride is required by runs with a LimitsOverride.
+	RunFeatureLimitsOverride RunFeature = "limits-override"
+)
+
+var (
+	// SupportedRunFeatures are the features that this version of the runner
+	// supports.
+	SupportedRunFeatures = []RunFeature{
+		RunFeatureLimitsOverride,
+		RunFeatureSandboxProfiles,
+		RunFeatureToolchains,
+	}
+
+	// legacyRunFeatures are the features that runners that predate protocol
+	// versioning support, even if they don't advertise them.
+	legacyRunFeatures = []RunFeature{
+		RunFeatureSandboxProfiles,
+		RunFeatureToolchains,
+	}
+)
+
+// RequiredFeatures returns the features that the runner needs to support in
+// order to grade the run, in lexicographic order.
+func (r *Run) RequiredFeatures() []RunFeature {
+	var features []RunFeature
+	if r.SandboxProfile != "" {
+		features = append(features, RunFeatureSandboxProfiles)
+	}
+	if r.Toolchain != "" {
+		features = append(features, RunFeatureToolchains)
+	}
+	if r.LimitsOverride != nil {
+		features = append(features, RunFeatureLimitsOverride)
+	}
+	sortRunFeatures(features)
+	return features
+}
+
+// FormatRunFeatures returns the features in the format used by the
+// OmegaUp-Runner-Features header.
+func FormatRunFeatures(features []RunFeature) string {
+	names := make([]string, len(features))
+	for i, feature := range features {
+		names[i] = string(feature)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// ParseRunFeatures parses the value of the OmegaUp-Runner-Features header.
+func ParseRunFeatures(header string) []RunFeature {
+	var features []RunFeature
+	for _, name := range strings.Split(header, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		features = append(features, RunFeature(name))
+	}
+	sortRunFeatures(features)
+	return features
+}
+
+// RunnerFeatures returns the features that a runner supports, given the
+// values of the OmegaUp-Runner-Protocol and OmegaUp-Runner-Features headers
+// it sent. Runners that don't send the protocol version predate it and are
+// assumed to support the features that existed back then.
+func RunnerFeatures(protocolHeader, featuresHeader string) []RunFeature {
+	if protocolHeader == "" {
+		return legacyRunFeatures
+	}
+	return ParseRunFeatures(featuresHeader)
+}
+
+// MissingRunFeatures returns the features in required that are not in
+// supported, in lexicographic order.
+func MissingRunFeatures(required, supported []RunFeature) []RunFeature {
+	var missing []RunFeature
+	for _, feature := range required {
+		found := false
+		for _, supportedFeature := range supported {
+			if feature == supportedFeature {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, feature)
+		}
+	}
+	sortRunFeatures(missing)
+	return missing
+}
+
+func sortRunFeatures(features []RunFeature) {
+	sort.Slice(features, func(i, j int) bool {
+		return features[i] < features[j]
+	})
+}
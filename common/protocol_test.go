@@ -0,0 +1,122 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+func TestRunRequiredFeatures(t *testing.T) {
+	for _, tc := range []struct {
+		run      Run
+		expected []RunFeature
+	}{
+		{Run{}, nil},
+		{Run{SandboxProfile: "strict"}, []RunFeature{RunFeatureSandboxProfiles}},
+		{
+			Run{SandboxProfile: "strict", Toolchain: "gcc-12"},
+			[]RunFeature{RunFeatureSandboxProfiles, RunFeatureToolchains},
+		},
+		{
+			Run{Toolchain: "gcc-12", LimitsOverride: &LimitsOverride{TimeLimitFactor: 1.5}},
+			[]RunFeature{RunFeatureLimitsOverride, RunFeatureToolchains},
+		},
+	} {
+		if got := tc.run.RequiredFeatures(); !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%v.RequiredFeatures() == %v, want %v", &tc.run, got, tc.expected)
+		}
+	}
+}
+
+func TestRunnerFeatures(t *testing.T) {
+	header := FormatRunFeatures([]RunFeature{RunFeatureToolchains, "generators"})
+	if header != "generators,toolchains" {
+		t.Errorf("FormatRunFeatures() == %q, want %q", header, "generators,toolchains")
+	}
+
+	for _, tc := range []struct {
+		protocol string
+		features string
+		expected []RunFeature
+	}{
+		{"", "", legacyRunFeatures},
+		{"", "generators", legacyRunFeatures},
+		{"1", "", nil},
+		{"1", header, []RunFeature{"generators", RunFeatureToolchains}},
+		{"1", " toolchains , ,generators", []RunFeature{"generators", RunFeatureToolchains}},
+	} {
+		if got := RunnerFeatures(tc.protocol, tc.features); !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("RunnerFeatures(%q, %q) == %v, want %v", tc.protocol, tc.features, got, tc.expected)
+		}
+	}
+
+	missing := MissingRunFeatures(
+		[]RunFeature{RunFeatureToolchains, "per-case-limits", "generators"},
+		[]RunFeature{RunFeatureSandboxProfiles, RunFeatureToolchains},
+	)
+	expectedMissing := []RunFeature{"generators", "per-case-limits"}
+	if !reflect.DeepEqual(expectedMissing, missing) {
+		t.Errorf("MissingRunFeatures() == %v, want %v", missing, expectedMissing)
+	}
+}
+
+func TestRunProtocolJSON(t *testing.T) {
+	run := Run{
+		AttemptID:       1,
+		Language:        "cpp17-gcc",
+		Toolchain:       "gcc-12",
+		ProtocolVersion: RunnerProtocolVersion,
+		Features:        []RunFeature{RunFeatureToolchains},
+	}
+	encoded, err := json.Marshal(&run)
+	if err != nil {
+		t.Fatalf("Failed to marshal run: %v", err)
+	}
+	var decoded Run
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal run: %v", err)
+	}
+	if decoded.ProtocolVersion != run.ProtocolVersion || !reflect.DeepEqual(decoded.Features, run.Features) {
+		t.Errorf("json.Unmarshal(%s) == %v, want %v", encoded, &decoded, &run)
+	}
+
+	// Runs from graders that predate the protocol version have neither field.
+	if err := json.Unmarshal([]byte(`{"attempt_id": 2, "language": "py3"}`), &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal run: %v", err)
+	}
+	if decoded.ProtocolVersion != 0 || decoded.Features != nil {
+		t.Errorf("decoded run == %v, want no protocol version nor features", &decoded)
+	}
+}
+
+func TestLimitsOverride(t *testing.T) {
+	override := &LimitsOverride{
+		Limits:          LimitsSettings{MemoryLimit: 64 * base.Mebibyte},
+		TimeLimitFactor: 1.5,
+	}
+	limits := DefaultLimits
+	override.Apply(&limits)
+	expected := DefaultLimits
+	expected.MemoryLimit = 64 * base.Mebibyte
+	expected.TimeLimit = base.Duration(1500 * time.Millisecond)
+	expected.OverallWallTimeLimit = base.Duration(90 * time.Second)
+	if limits != expected {
+		t.Errorf("%+v.Apply(%+v) == %+v, want %+v", override, DefaultLimits, limits, expected)
+	}
+
+	run := Run{AttemptID: 1, LimitsOverride: override}
+	encoded, err := json.Marshal(&run)
+	if err != nil {
+		t.Fatalf("Failed to marshal run: %v", err)
+	}
+	var decoded Run
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal run: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.LimitsOverride, override) {
+		t.Errorf("json.Unmarshal(%s).LimitsOverride == %+v, want %+v", encoded, decoded.LimitsOverride, override)
+	}
+}
@@ -7,14 +7,41 @@ import (
 	base "github.com/omegaup/go-base/v3"
 	"math/big"
 	"math/rand"
+	"regexp"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 var (
 	attemptID uint64
+
+	artifactIDRegex = regexp.MustCompile("^[0-9a-f]{32}$")
 )
 
+// ArtifactSourcePrefix is the prefix of the Source of an output-only run that
+// references a previously uploaded artifact instead of inlining a dataurl.
+const ArtifactSourcePrefix = "artifact:"
+
+// IsValidArtifactID returns whether id can be used to identify an uploaded
+// artifact.
+func IsValidArtifactID(id string) bool {
+	return artifactIDRegex.MatchString(id)
+}
+
+// ArtifactReference returns the ID of the uploaded artifact that source
+// references, if any.
+func ArtifactReference(source string) (string, bool) {
+	if !strings.HasPrefix(source, ArtifactSourcePrefix) {
+		return "", false
+	}
+	id := strings.TrimSpace(source[len(ArtifactSourcePrefix):])
+	if !IsValidArtifactID(id) {
+		return "", false
+	}
+	return id, true
+}
+
 func init() {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	attemptID = uint64(r.Int63())
@@ -23,6 +50,7 @@ func init() {
 // A Run represents an omegaUp run.
 type Run struct {
 	AttemptID   uint64   `json:"attempt_id"`
+	GUID        string   `json:"guid,omitempty"`
 	Source      string   `json:"source"`
 	Language    string   `json:"language"`
 	ProblemName string   `json:"problem"`
@@ -30,26 +58,93 @@ type Run struct {
 	InputHash   string   `json:"input_hash"`
 	MaxScore    *big.Rat `json:"max_score"`
 	Debug       bool     `json:"debug"`
+
+	// SandboxProfile is the name of the sandbox profile the runner must use
+	// for this run. It is set by the grader queue the run was added to, and
+	// the default sandbox is used if it is empty.
+	SandboxProfile string `json:"sandbox_profile,omitempty"`
+
+	// Toolchain is the name of the toolchain the runner must use to compile
+	// this run, as pinned by the problem settings. Runners that don't have it
+	// reject the run so that it is sent to a different one.
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// ProtocolVersion and Features are set by the grader when the run is
+	// handed to a runner. Features are the ones in RequiredFeatures, so that
+	// runners can reject runs that need something they don't know about.
+	ProtocolVersion int          `json:"protocol_version,omitempty"`
+	Features        []RunFeature `json:"features,omitempty"`
+
+	// LimitsOverride replaces the limits of the problem. It is only set for
+	// runs whose results are not official, like the re-executions that are
+	// used to resolve appeals.
+	LimitsOverride *LimitsOverride `json:"limits_override,omitempty"`
+}
+
+// LimitsOverride describes how the limits of a problem are changed for a run.
+type LimitsOverride struct {
+	// Limits replaces each of the limits of the problem that is not zero.
+	Limits LimitsSettings `json:"limits"`
+
+	// TimeLimitFactor multiplies the time limit and the overall wall time
+	// limit of the problem (after replacing them), if positive. 1.5 gives 50%
+	// more time.
+	TimeLimitFactor float64 `json:"time_limit_factor,omitempty"`
+}
+
+// Apply changes the limits according to the override.
+func (o *LimitsOverride) Apply(limits *LimitsSettings) {
+	if o.Limits.ExtraWallTime != 0 {
+		limits.ExtraWallTime = o.Limits.ExtraWallTime
+	}
+	if o.Limits.MemoryLimit != 0 {
+		limits.MemoryLimit = o.Limits.MemoryLimit
+	}
+	if o.Limits.OutputLimit != 0 {
+		limits.OutputLimit = o.Limits.OutputLimit
+	}
+	if o.Limits.OverallWallTimeLimit != 0 {
+		limits.OverallWallTimeLimit = o.Limits.OverallWallTimeLimit
+	}
+	if o.Limits.TimeLimit != 0 {
+		limits.TimeLimit = o.Limits.TimeLimit
+	}
+	if o.TimeLimitFactor > 0 {
+		limits.TimeLimit = base.Duration(float64(limits.TimeLimit) * o.TimeLimitFactor)
+		limits.OverallWallTimeLimit = base.Duration(float64(limits.OverallWallTimeLimit) * o.TimeLimitFactor)
+	}
 }
 
 // MarshalJSON implements the json.Marshaler interface.
 func (r *Run) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		AttemptID   uint64  `json:"attempt_id"`
-		Source      string  `json:"source"`
-		Language    string  `json:"language"`
-		ProblemName string  `json:"problem"`
-		InputHash   string  `json:"input_hash"`
-		MaxScore    float64 `json:"max_score"`
-		Debug       bool    `json:"debug"`
+		AttemptID       uint64          `json:"attempt_id"`
+		GUID            string          `json:"guid,omitempty"`
+		Source          string          `json:"source"`
+		Language        string          `json:"language"`
+		ProblemName     string          `json:"problem"`
+		InputHash       string          `json:"input_hash"`
+		MaxScore        float64         `json:"max_score"`
+		Debug           bool            `json:"debug"`
+		SandboxProfile  string          `json:"sandbox_profile,omitempty"`
+		Toolchain       string          `json:"toolchain,omitempty"`
+		ProtocolVersion int             `json:"protocol_version,omitempty"`
+		Features        []RunFeature    `json:"features,omitempty"`
+		LimitsOverride  *LimitsOverride `json:"limits_override,omitempty"`
 	}{
-		AttemptID:   r.AttemptID,
-		Source:      r.Source,
-		Language:    r.Language,
-		ProblemName: r.ProblemName,
-		InputHash:   r.InputHash,
-		MaxScore:    base.RationalToFloat(r.MaxScore),
-		Debug:       r.Debug,
+		AttemptID:       r.AttemptID,
+		GUID:            r.GUID,
+		Source:          r.Source,
+		Language:        r.Language,
+		ProblemName:     r.ProblemName,
+		InputHash:       r.InputHash,
+		MaxScore:        base.RationalToFloat(r.MaxScore),
+		Debug:           r.Debug,
+		SandboxProfile:  r.SandboxProfile,
+		Toolchain:       r.Toolchain,
+		ProtocolVersion: r.ProtocolVersion,
+		Features:        r.Features,
+		LimitsOverride:  r.LimitsOverride,
 	})
 }
 
@@ -60,13 +155,19 @@ func (r *Run) UnmarshalJSON(data []byte) error {
 	}
 
 	run := struct {
-		AttemptID   uint64  `json:"attempt_id"`
-		Source      string  `json:"source"`
-		Language    string  `json:"language"`
-		ProblemName string  `json:"problem"`
-		InputHash   string  `json:"input_hash"`
-		MaxScore    float64 `json:"max_score"`
-		Debug       bool    `json:"debug"`
+		AttemptID       uint64          `json:"attempt_id"`
+		GUID            string          `json:"guid,omitempty"`
+		Source          string          `json:"source"`
+		Language        string          `json:"language"`
+		ProblemName     string          `json:"problem"`
+		InputHash       string          `json:"input_hash"`
+		MaxScore        float64         `json:"max_score"`
+		Debug           bool            `json:"debug"`
+		SandboxProfile  string          `json:"sandbox_profile,omitempty"`
+		Toolchain       string          `json:"toolchain,omitempty"`
+		ProtocolVersion int             `json:"protocol_version,omitempty"`
+		Features        []RunFeature    `json:"features,omitempty"`
+		LimitsOverride  *LimitsOverride `json:"limits_override,omitempty"`
 	}{}
 
 	if err := json.Unmarshal(data, &run); err != nil {
@@ -74,12 +175,18 @@ func (r *Run) UnmarshalJSON(data []byte) error {
 	}
 
 	r.AttemptID = run.AttemptID
+	r.GUID = run.GUID
 	r.Source = run.Source
 	r.Language = run.Language
 	r.ProblemName = run.ProblemName
 	r.InputHash = run.InputHash
 	r.MaxScore = base.FloatToRational(run.MaxScore)
 	r.Debug = run.Debug
+	r.SandboxProfile = run.SandboxProfile
+	r.Toolchain = run.Toolchain
+	r.ProtocolVersion = run.ProtocolVersion
+	r.Features = run.Features
+	r.LimitsOverride = run.LimitsOverride
 
 	return nil
 }
@@ -99,8 +206,9 @@ func (r *Run) UpdateAttemptID() uint64 {
 
 func (r *Run) String() string {
 	return fmt.Sprintf(
-		"Run{AttemptID:%d Language:%s ProblemName:%s InputHash:%s}",
+		"Run{AttemptID:%d GUID:%s Language:%s ProblemName:%s InputHash:%s}",
 		r.AttemptID,
+		r.GUID,
 		r.Language,
 		r.ProblemName,
 		r.InputHash,
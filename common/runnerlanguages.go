@@ -0,0 +1,110 @@
+package common
+
+import (
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+// RunnerLanguageConfig is the configuration of a language in the runner, so
+// that adding or tuning a language is a configuration change. Every field is
+// optional: the ones that are not set keep the value from
+// DefaultRunnerLanguages.
+type RunnerLanguageConfig struct {
+	// Compiler replaces the program (the first argument) of the command that
+	// the isolate and nsjail sandboxes use to compile the language.
+	Compiler string
+
+	// CompilerFlags are passed to the compiler right after its path.
+	CompilerFlags []string
+
+	// ParentFlags are passed to the compiler when the language is used for
+	// the main program of an interactive problem, which is linked with the
+	// code that libinteractive generates.
+	ParentFlags []string
+
+	// Limits is the LanguageLimits profile of the language. A non-nil
+	// profile replaces the default one as a whole, so an empty profile
+	// removes all the adjustments.
+	Limits *LanguageLimits
+
+	// Policy is the name of the nsjail policy in Nsjail.PolicyRoot, without
+	// the .cfg extension, that the language uses if it does not have its
+	// own.
+	Policy string
+}
+
+// DefaultRunnerLanguages is the configuration of the languages that need
+// one, unless Runner.Languages overrides it.
+var DefaultRunnerLanguages = map[string]RunnerLanguageConfig{
+	// libinteractive provides the entry point of the main program.
+	"c": {
+		ParentFlags: []string{"-Wl,-e__entry"},
+	},
+	"cpp": {
+		ParentFlags: []string{"-Wl,-e__entry"},
+	},
+	"cpp11": {
+		ParentFlags: []string{"-Wl,-e__entry"},
+	},
+	// The JVM takes a while to start.
+	"java": {
+		Limits: &LanguageLimits{
+			ExtraTime: base.Duration(time.Second),
+		},
+	},
+	// The JVM takes even longer to start when it has to load the Kotlin
+	// runtime from the jar. Kotlin programs run on the JVM, so they can use
+	// the same policy as Java programs.
+	"kt": {
+		Compiler: "/usr/bin/kotlinc",
+		Limits: &LanguageLimits{
+			ExtraTime:     base.Duration(time.Second),
+			ExtraWallTime: base.Duration(time.Second),
+		},
+		Policy: "java",
+	},
+	// The Go runtime reserves memory for its heap and its threads before the
+	// program needs it.
+	"go": {
+		Limits: &LanguageLimits{
+			MemoryFactor: 2,
+		},
+	},
+	// PyPy is faster than CPython, but still slower than the compiled
+	// languages that the limits of the problems are usually set for, and its
+	// JIT needs to warm up first. It does not use the Python policy, since
+	// its JIT needs to map executable memory.
+	"py3-pypy": {
+		Limits: &LanguageLimits{
+			TimeFactor: 2,
+		},
+	},
+}
+
+// Language returns the configuration of the language: the one in
+// DefaultRunnerLanguages, with the fields that are set in Runner.Languages
+// replacing the default ones.
+func (config *RunnerConfig) Language(lang string) RunnerLanguageConfig {
+	result := DefaultRunnerLanguages[lang]
+	override, ok := config.Languages[lang]
+	if !ok {
+		return result
+	}
+	if override.Compiler != "" {
+		result.Compiler = override.Compiler
+	}
+	if override.CompilerFlags != nil {
+		result.CompilerFlags = override.CompilerFlags
+	}
+	if override.ParentFlags != nil {
+		result.ParentFlags = override.ParentFlags
+	}
+	if override.Limits != nil {
+		result.Limits = override.Limits
+	}
+	if override.Policy != "" {
+		result.Policy = override.Policy
+	}
+	return result
+}
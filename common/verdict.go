@@ -0,0 +1,151 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Verdict is the stable code of the outcome of a run, a group or a case (e.g.
+// "AC" or "TLE"). Frontends should match on the code and use the
+// localization key of its VerdictDetail to show it to users, instead of
+// relying on any human-readable text.
+type Verdict string
+
+// The known verdicts.
+const (
+	VerdictJudgeError          Verdict = "JE"
+	VerdictCompileError        Verdict = "CE"
+	VerdictRestrictedFunction  Verdict = "RFE"
+	VerdictValidatorError      Verdict = "VE"
+	VerdictMemoryLimitExceeded Verdict = "MLE"
+	VerdictRuntimeError        Verdict = "RTE"
+	VerdictTimeLimitExceeded   Verdict = "TLE"
+	VerdictOutputLimitExceeded Verdict = "OLE"
+	VerdictWrongAnswer         Verdict = "WA"
+	VerdictPartiallyAccepted   Verdict = "PA"
+	VerdictAccepted            Verdict = "AC"
+	VerdictOK                  Verdict = "OK"
+	VerdictSkipped             Verdict = "SK"
+)
+
+// verdictLocalizationKeyPrefix is the prefix of the localization keys of the
+// verdicts in the frontend's translation strings.
+const verdictLocalizationKeyPrefix = "verdict"
+
+// VerdictDetail is the human-readable description of a Verdict.
+type VerdictDetail struct {
+	// LocalizationKey is the key of the translated name of the verdict in the
+	// frontend's translation strings.
+	LocalizationKey string `json:"localization_key"`
+
+	// Description is the English name of the verdict, for the clients that
+	// do not have translations.
+	Description string `json:"description"`
+}
+
+// verdicts are all the known verdicts, sorted from worse to better, together
+// with their English names. Adding a verdict only requires adding it here in
+// the right position.
+var verdicts = []struct {
+	verdict     Verdict
+	description string
+}{
+	{VerdictJudgeError, "Judge error"},
+	{VerdictCompileError, "Compilation error"},
+	{VerdictRestrictedFunction, "Restricted function"},
+	{VerdictValidatorError, "Validator error"},
+	{VerdictMemoryLimitExceeded, "Memory limit exceeded"},
+	{VerdictRuntimeError, "Runtime error"},
+	{VerdictTimeLimitExceeded, "Time limit exceeded"},
+	{VerdictOutputLimitExceeded, "Output limit exceeded"},
+	{VerdictWrongAnswer, "Wrong answer"},
+	{VerdictPartiallyAccepted, "Partially accepted"},
+	{VerdictAccepted, "Accepted"},
+	{VerdictOK, "OK"},
+	// Cases that were not run do not affect the verdict of the run.
+	{VerdictSkipped, "Skipped"},
+}
+
+// VerdictList is the sorted list of verdicts from worse to better.
+var VerdictList = func() []Verdict {
+	list := make([]Verdict, len(verdicts))
+	for i, v := range verdicts {
+		list[i] = v.verdict
+	}
+	return list
+}()
+
+var verdictRanks = func() map[Verdict]int {
+	ranks := make(map[Verdict]int, len(verdicts))
+	for i, v := range verdicts {
+		ranks[v.verdict] = i
+	}
+	return ranks
+}()
+
+// ParseVerdict returns the verdict with the provided code, or an error if it
+// is not one of the known ones.
+func ParseVerdict(code string) (Verdict, error) {
+	v := Verdict(code)
+	if !v.Known() {
+		return "", fmt.Errorf("unknown verdict %q", code)
+	}
+	return v, nil
+}
+
+// Known returns whether the verdict is one of the known ones.
+func (v Verdict) Known() bool {
+	_, ok := verdictRanks[v]
+	return ok
+}
+
+// orJudgeError returns the verdict if it is a known one, and a judge error
+// otherwise, since something went wrong to produce it. An empty verdict is
+// returned as is, since it means that there is no verdict.
+func (v Verdict) orJudgeError() Verdict {
+	if v == "" || v.Known() {
+		return v
+	}
+	return VerdictJudgeError
+}
+
+// Worse returns the worse of both verdicts, or v if they are equally bad.
+// Unknown verdicts are considered judge errors, so they are never better
+// than a known one.
+func (v Verdict) Worse(other Verdict) Verdict {
+	v, other = v.orJudgeError(), other.orJudgeError()
+	if verdictRanks[other] < verdictRanks[v] {
+		return other
+	}
+	return v
+}
+
+// MarshalJSON implements the json.Marshaler interface. Unknown verdicts are
+// marshaled as judge errors.
+func (v Verdict) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(v.orJudgeError()))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. Unknown verdicts,
+// like the ones that a newer or a misbehaving runner could send, are read as
+// judge errors, so that they cannot make a run look better than it is.
+func (v *Verdict) UnmarshalJSON(data []byte) error {
+	var code string
+	if err := json.Unmarshal(data, &code); err != nil {
+		return err
+	}
+	*v = Verdict(code).orJudgeError()
+	return nil
+}
+
+// Detail returns the human-readable description of the verdict.
+func (v Verdict) Detail() VerdictDetail {
+	detail := VerdictDetail{
+		LocalizationKey: verdictLocalizationKeyPrefix + string(v),
+		Description:     string(v),
+	}
+	if rank, ok := verdictRanks[v]; ok {
+		detail.Description = verdicts[rank].description
+	}
+	return detail
+}
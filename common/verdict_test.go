@@ -0,0 +1,96 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestVerdictWorse(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, expected Verdict
+	}{
+		{VerdictAccepted, VerdictWrongAnswer, VerdictWrongAnswer},
+		{VerdictWrongAnswer, VerdictAccepted, VerdictWrongAnswer},
+		{VerdictTimeLimitExceeded, VerdictMemoryLimitExceeded, VerdictMemoryLimitExceeded},
+		{VerdictAccepted, VerdictSkipped, VerdictAccepted},
+		{VerdictOK, VerdictOK, VerdictOK},
+		{VerdictCompileError, VerdictJudgeError, VerdictJudgeError},
+		// Unknown verdicts are as bad as judge errors.
+		{VerdictAccepted, "XYZ", VerdictJudgeError},
+		{"XYZ", VerdictAccepted, VerdictJudgeError},
+		{VerdictJudgeError, "XYZ", VerdictJudgeError},
+	} {
+		if got := tc.a.Worse(tc.b); got != tc.expected {
+			t.Errorf("%q.Worse(%q) == %q, want %q", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestVerdictDetail(t *testing.T) {
+	for _, v := range VerdictList {
+		if !v.Known() {
+			t.Errorf("%q.Known() == false, want true", v)
+		}
+		detail := v.Detail()
+		if detail.LocalizationKey != "verdict"+string(v) {
+			t.Errorf("%q.Detail().LocalizationKey == %q, want %q", v, detail.LocalizationKey, "verdict"+string(v))
+		}
+		if detail.Description == "" || detail.Description == string(v) && v != VerdictOK {
+			t.Errorf("%q.Detail().Description == %q, want a human-readable name", v, detail.Description)
+		}
+	}
+
+	unknown := Verdict("XYZ")
+	if unknown.Known() {
+		t.Errorf("%q.Known() == true, want false", unknown)
+	}
+	if detail := unknown.Detail(); detail != (VerdictDetail{LocalizationKey: "verdictXYZ", Description: "XYZ"}) {
+		t.Errorf("%q.Detail() == %+v, want the code as the description", unknown, detail)
+	}
+}
+
+func TestVerdictJSON(t *testing.T) {
+	var result struct {
+		Verdict Verdict `json:"verdict"`
+		Cases   []struct {
+			Verdict Verdict `json:"verdict,omitempty"`
+		} `json:"cases"`
+	}
+	if err := json.Unmarshal(
+		[]byte(`{"verdict": "XYZ", "cases": [{"verdict": "AC"}, {}]}`),
+		&result,
+	); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if result.Verdict != VerdictJudgeError {
+		t.Errorf("verdict == %q, want %q", result.Verdict, VerdictJudgeError)
+	}
+	if result.Cases[0].Verdict != VerdictAccepted || result.Cases[1].Verdict != "" {
+		t.Errorf("case verdicts == %q, %q, want %q, \"\"", result.Cases[0].Verdict, result.Cases[1].Verdict, VerdictAccepted)
+	}
+	if err := json.Unmarshal([]byte(`{"verdict": 1}`), &result); err == nil {
+		t.Errorf("Unmarshaling a number succeeded, want an error")
+	}
+
+	marshaled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if expected := `{"verdict":"JE","cases":[{"verdict":"AC"},{}]}`; string(marshaled) != expected {
+		t.Errorf("json.Marshal() == %s, want %s", marshaled, expected)
+	}
+	if marshaled, _ := json.Marshal(Verdict("XYZ")); string(marshaled) != `"JE"` {
+		t.Errorf("json.Marshal(\"XYZ\") == %s, want \"JE\"", marshaled)
+	}
+}
+
+func TestParseVerdict(t *testing.T) {
+	if v, err := ParseVerdict("TLE"); err != nil || v != VerdictTimeLimitExceeded {
+		t.Errorf("ParseVerdict(\"TLE\") == %q, %v, want %q, nil", v, err, VerdictTimeLimitExceeded)
+	}
+	for _, code := range []string{"", "XYZ", "ac"} {
+		if _, err := ParseVerdict(code); err == nil {
+			t.Errorf("ParseVerdict(%q) succeeded, want an error", code)
+		}
+	}
+}
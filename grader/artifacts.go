@@ -9,9 +9,11 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 
 	"github.com/omegaup/quark/common"
@@ -56,7 +58,14 @@ func (f *atomicFile) commit(modTime *time.Time) error {
 	released := f.f
 	defer os.Remove(released.Name())
 	f.f = nil
-	err := released.Close()
+	// Make sure the contents are durable before the file becomes visible, so
+	// that a crash never leaves a partially-written file behind.
+	err := released.Sync()
+	if err != nil {
+		released.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	err = released.Close()
 	if err != nil {
 		return fmt.Errorf("close: %w", err)
 	}
@@ -64,17 +73,38 @@ func (f *atomicFile) commit(modTime *time.Time) error {
 	if err != nil {
 		return fmt.Errorf("rename: %w", err)
 	}
+	if dir, err := os.Open(path.Dir(f.filename)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
 
 	return nil
 }
 
+// getArtifact returns the plaintext of an artifact, which is downloaded from
+// S3 if it is not in the filesystem.
 func getArtifact(
 	ctx *common.Context,
 	s3c *s3.S3,
+	c *ArtifactCipher,
 	bucketName string,
 	bucketKey string,
 	localPath string,
 ) (io.ReadCloser, error) {
+	f, err := openArtifact(ctx, s3c, bucketName, bucketKey, localPath)
+	if err != nil {
+		return nil, err
+	}
+	return c.decrypt(f)
+}
+
+func openArtifact(
+	ctx *common.Context,
+	s3c *s3.S3,
+	bucketName string,
+	bucketKey string,
+	localPath string,
+) (*os.File, error) {
 	f, err := os.Open(localPath)
 	if errors.Is(err, fs.ErrNotExist) && s3c != nil {
 		// Give it one more good try.
@@ -89,6 +119,11 @@ func getArtifact(
 			Key:    aws.String(bucketKey),
 		}
 		obj, err := s3c.GetObjectWithContext(aws.Context(ctx.Context), input)
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			// Let callers tell missing artifacts apart from other errors.
+			return nil, fmt.Errorf("get s3://%s/%s: %w", *input.Bucket, *input.Key, fs.ErrNotExist)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("get s3://%s/%s: %w", *input.Bucket, *input.Key, err)
 		}
@@ -107,9 +142,12 @@ func getArtifact(
 	return f, err
 }
 
+// putArtifact atomically writes an artifact, encrypted if the cipher is not
+// nil, to the filesystem and (if it's set) to S3.
 func putArtifact(
 	ctx *common.Context,
 	s3c *s3.S3,
+	c *ArtifactCipher,
 	bucketName string,
 	bucketKey string,
 	localPath string,
@@ -120,10 +158,22 @@ func putArtifact(
 		return fmt.Errorf("new: %w", err)
 	}
 	defer f.cleanup()
-	n, err := io.Copy(f.f, r)
+	w, err := c.Encrypt(f.f)
+	if err != nil {
+		return fmt.Errorf("encrypt: %w", err)
+	}
+	_, err = io.Copy(w, r)
 	if err != nil {
 		return fmt.Errorf("write: %w", err)
 	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	n, err := f.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
 	_, err = f.f.Seek(0, io.SeekStart)
 	if err != nil {
 		return fmt.Errorf("seek: %w", err)
@@ -152,19 +202,30 @@ func putArtifact(
 
 // ArtifactManager is an abstraction around the filesystem. All writes will go
 // to both the filesystem and (if it's set) S3, and reads will be attempted
-// against the filesystem first and then S3 as fallback.
+// against the filesystem first and then S3 as fallback. If there is a cipher,
+// the sources of the submissions and the grader artifacts are encrypted
+// before they are written, and the reads decrypt them.
 type ArtifactManager struct {
-	s3c *s3.S3
+	s3c    *s3.S3
+	cipher *ArtifactCipher
 
 	Submissions SubmissionsArtifacts
+	Uploads     UploadArtifacts
 }
 
-// NewArtifactManager returns a new ArtifactManager.
-func NewArtifactManager(s3c *s3.S3) *ArtifactManager {
+// NewArtifactManager returns a new ArtifactManager. The cipher can be nil to
+// store the artifacts unencrypted.
+func NewArtifactManager(s3c *s3.S3, cipher *ArtifactCipher) *ArtifactManager {
 	return &ArtifactManager{
-		s3c: s3c,
+		s3c:    s3c,
+		cipher: cipher,
 		Submissions: SubmissionsArtifacts{
-			s3c: s3c,
+			s3c:    s3c,
+			cipher: cipher,
+		},
+		Uploads: UploadArtifacts{
+			s3c:      s3c,
+			inflight: make(map[string]struct{}),
 		},
 	}
 }
@@ -172,7 +233,8 @@ func NewArtifactManager(s3c *s3.S3) *ArtifactManager {
 // Grader returns a wrapper for the grader artifacts.
 func (a *ArtifactManager) Grader(ctx *common.Context, runID int64) Artifacts {
 	return &graderArtifacts{
-		s3c: a.s3c,
+		s3c:    a.s3c,
+		cipher: a.cipher,
 		gradeDir: path.Join(
 			ctx.Config.Grader.V1.RuntimeGradePath,
 			fmt.Sprintf("%02d/%02d/%d", runID%100, (runID%10000)/100, runID),
@@ -181,6 +243,17 @@ func (a *ArtifactManager) Grader(ctx *common.Context, runID int64) Artifacts {
 	}
 }
 
+// Scratch returns a wrapper for grader artifacts that are stored in a
+// temporary directory, for runs whose results are discarded after they are
+// inspected. The caller must Clean them.
+func (a *ArtifactManager) Scratch() (Artifacts, error) {
+	artifacts, err := newDebugLocalGrader()
+	if err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
 func ephemeralTempDir(ctx *common.Context) (name string, err error) {
 	ephemeralPath := path.Join(ctx.Config.Grader.RuntimePath, "ephemeral")
 	buf := make([]byte, 10)
@@ -230,7 +303,8 @@ func newDebugLocalGrader() (*localGraderArtifacts, error) {
 
 // SubmissionsArtifacts is an object that allows interacting with submissions.
 type SubmissionsArtifacts struct {
-	s3c *s3.S3
+	s3c    *s3.S3
+	cipher *ArtifactCipher
 }
 
 // GetSource returns the source of a submission, identified by its guid.
@@ -243,6 +317,7 @@ func (a *SubmissionsArtifacts) GetSource(ctx *common.Context, guid string) (stri
 	r, err := getArtifact(
 		ctx,
 		a.s3c,
+		a.cipher,
 		"omegaup-submissions",
 		guid,
 		path.Join(ctx.Config.Grader.V1.RuntimePath, submissionKey),
@@ -269,6 +344,7 @@ func (a *SubmissionsArtifacts) PutSource(ctx *common.Context, guid string, r io.
 	return putArtifact(
 		ctx,
 		a.s3c,
+		a.cipher,
 		"omegaup-submissions",
 		guid,
 		path.Join(ctx.Config.Grader.V1.RuntimePath, backupSubmissionKey),
@@ -276,6 +352,170 @@ func (a *SubmissionsArtifacts) PutSource(ctx *common.Context, guid string, r io.
 	)
 }
 
+var (
+	// ErrUploadOffsetMismatch is returned when a chunk of an upload does not
+	// start where the previous one ended.
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrUploadInProgress is returned when another chunk of the same upload is
+	// being written.
+	ErrUploadInProgress = errors.New("upload in progress")
+	// ErrUploadComplete is returned when trying to modify an upload that has
+	// already been committed.
+	ErrUploadComplete = errors.New("upload already complete")
+	// ErrUploadTooLarge is returned when an upload exceeds the maximum size.
+	ErrUploadTooLarge = errors.New("upload too large")
+)
+
+// UploadArtifacts is an object that allows interacting with artifacts that
+// are uploaded in (possibly resumed) chunks before a run references them, such
+// as large output-only submissions. They are not encrypted, since Offset
+// reports the size that they have on disk.
+type UploadArtifacts struct {
+	sync.Mutex
+	s3c      *s3.S3
+	inflight map[string]struct{}
+}
+
+func (a *UploadArtifacts) localPath(ctx *common.Context, id string) string {
+	return path.Join(
+		ctx.Config.Grader.V1.RuntimePath,
+		"artifacts",
+		id[:2],
+		id[2:],
+	)
+}
+
+func (a *UploadArtifacts) acquire(id string) error {
+	a.Lock()
+	defer a.Unlock()
+	if _, ok := a.inflight[id]; ok {
+		return ErrUploadInProgress
+	}
+	a.inflight[id] = struct{}{}
+	return nil
+}
+
+func (a *UploadArtifacts) release(id string) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.inflight, id)
+}
+
+// Offset returns the number of bytes of the artifact that have been uploaded
+// so far, and whether the upload has been committed.
+func (a *UploadArtifacts) Offset(ctx *common.Context, id string) (int64, bool, error) {
+	localPath := a.localPath(ctx, id)
+	if info, err := os.Stat(localPath); err == nil {
+		return info.Size(), true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, false, fmt.Errorf("stat %s: %w", id, err)
+	}
+	info, err := os.Stat(localPath + ".partial")
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("stat %s: %w", id, err)
+	}
+	return info.Size(), false, nil
+}
+
+// Append writes the contents of r at the end of the partial upload of the
+// artifact, which must currently have exactly offset bytes. It returns the
+// new offset. If the upload would exceed maxSize bytes, the chunk is discarded
+// and ErrUploadTooLarge is returned.
+func (a *UploadArtifacts) Append(
+	ctx *common.Context,
+	id string,
+	offset int64,
+	r io.Reader,
+	maxSize int64,
+) (int64, error) {
+	if err := a.acquire(id); err != nil {
+		return 0, err
+	}
+	defer a.release(id)
+
+	currentOffset, complete, err := a.Offset(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if complete {
+		return currentOffset, ErrUploadComplete
+	}
+	if currentOffset != offset {
+		return currentOffset, ErrUploadOffsetMismatch
+	}
+
+	partialPath := a.localPath(ctx, id) + ".partial"
+	if err := os.MkdirAll(path.Dir(partialPath), 0o755); err != nil {
+		return currentOffset, fmt.Errorf("mkdir: %w", err)
+	}
+	f, err := os.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return currentOffset, fmt.Errorf("open %s: %w", id, err)
+	}
+	defer f.Close()
+
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize-offset+1)
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && maxSize > 0 && offset+n > maxSize {
+		err = ErrUploadTooLarge
+	}
+	if err != nil {
+		// Discard the partial chunk so that the client can retry it.
+		if truncateErr := f.Truncate(offset); truncateErr != nil {
+			return offset, fmt.Errorf("truncate %s: %w", id, truncateErr)
+		}
+		if errors.Is(err, ErrUploadTooLarge) {
+			return offset, err
+		}
+		return offset, fmt.Errorf("write %s: %w", id, err)
+	}
+	return offset + n, nil
+}
+
+// Commit marks the upload of the artifact as complete, which makes it
+// available to runs and prevents any further modifications.
+func (a *UploadArtifacts) Commit(ctx *common.Context, id string) error {
+	if err := a.acquire(id); err != nil {
+		return err
+	}
+	defer a.release(id)
+
+	localPath := a.localPath(ctx, id)
+	f, err := os.Open(localPath + ".partial")
+	if err != nil {
+		return fmt.Errorf("open %s: %w", id, err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	return putArtifact(
+		ctx,
+		a.s3c,
+		nil,
+		"omegaup-artifacts",
+		id,
+		localPath,
+		f,
+	)
+}
+
+// Get returns a io.ReadCloser with the contents of a committed artifact.
+func (a *UploadArtifacts) Get(ctx *common.Context, id string) (io.ReadCloser, error) {
+	return getArtifact(
+		ctx,
+		a.s3c,
+		nil,
+		"omegaup-artifacts",
+		id,
+		a.localPath(ctx, id),
+	)
+}
+
 // Artifacts is an interface to interact with grader artifacts.
 type Artifacts interface {
 	// Get returns a io.ReadCloser with the contents of the artifact.
@@ -290,6 +530,7 @@ type Artifacts interface {
 
 type graderArtifacts struct {
 	s3c          *s3.S3
+	cipher       *ArtifactCipher
 	gradeDir     string
 	bucketPrefix string
 }
@@ -298,6 +539,7 @@ func (a *graderArtifacts) Get(ctx *common.Context, filename string) (io.ReadClos
 	return getArtifact(
 		ctx,
 		a.s3c,
+		a.cipher,
 		"omegaup-runs",
 		path.Join(a.bucketPrefix, filename),
 		path.Join(a.gradeDir, filename),
@@ -308,6 +550,7 @@ func (a *graderArtifacts) Put(ctx *common.Context, filename string, r io.Reader)
 	return putArtifact(
 		ctx,
 		a.s3c,
+		a.cipher,
 		"omegaup-runs",
 		path.Join(a.bucketPrefix, filename),
 		path.Join(a.gradeDir, filename),
@@ -0,0 +1,107 @@
+package grader
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/omegaup/quark/common"
+)
+
+func TestUploadArtifacts(t *testing.T) {
+	config := common.DefaultConfig()
+	config.Grader.V1.RuntimePath = t.TempDir()
+	ctx, err := common.NewContext(&config)
+	if err != nil {
+		t.Fatalf("Failed to create context: %v", err)
+	}
+	defer ctx.Close()
+
+	const id = "0123456789abcdef0123456789abcdef"
+	uploads := &NewArtifactManager(nil, nil).Uploads
+
+	if offset, complete, err := uploads.Offset(ctx, id); err != nil || offset != 0 || complete {
+		t.Fatalf("Offset() == %d, %v, %v, want 0, false, nil", offset, complete, err)
+	}
+	offset, err := uploads.Append(ctx, id, 0, strings.NewReader("hello, "), 12)
+	if err != nil || offset != 7 {
+		t.Fatalf("Append() == %d, %v, want 7, nil", offset, err)
+	}
+	if _, err := uploads.Append(ctx, id, 0, strings.NewReader("hello, "), 12); !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Errorf("Append() with a stale offset == %v, want %v", err, ErrUploadOffsetMismatch)
+	}
+	if _, err := uploads.Append(ctx, id, 7, strings.NewReader("world!"), 12); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Append() past the size limit == %v, want %v", err, ErrUploadTooLarge)
+	}
+	// The rejected chunk must not have been kept.
+	offset, err = uploads.Append(ctx, id, 7, strings.NewReader("world"), 12)
+	if err != nil || offset != 12 {
+		t.Fatalf("Append() == %d, %v, want 12, nil", offset, err)
+	}
+	if err := uploads.Commit(ctx, id); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	if offset, complete, err := uploads.Offset(ctx, id); err != nil || offset != 12 || !complete {
+		t.Errorf("Offset() == %d, %v, %v, want 12, true, nil", offset, complete, err)
+	}
+	if _, err := uploads.Append(ctx, id, 12, strings.NewReader("!"), 0); !errors.Is(err, ErrUploadComplete) {
+		t.Errorf("Append() after Commit() == %v, want %v", err, ErrUploadComplete)
+	}
+
+	r, err := uploads.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read artifact: %v", err)
+	}
+	if string(contents) != "hello, world" {
+		t.Errorf("artifact contents == %q, want %q", string(contents), "hello, world")
+	}
+}
+
+func TestEncryptedArtifacts(t *testing.T) {
+	config := common.DefaultConfig()
+	config.Grader.V1.RuntimePath = t.TempDir()
+	config.Grader.V1.RuntimeGradePath = t.TempDir()
+	ctx, err := common.NewContext(&config)
+	if err != nil {
+		t.Fatalf("Failed to create context: %v", err)
+	}
+	defer ctx.Close()
+
+	cipher, err := NewArtifactCipher("key", map[string][]byte{"key": []byte(strings.Repeat("k", 32))})
+	if err != nil {
+		t.Fatalf("Failed to create the cipher: %v", err)
+	}
+	const guid = "0123456789abcdef0123456789abcdef"
+	plain := NewArtifactManager(nil, nil)
+	encrypted := NewArtifactManager(nil, cipher)
+
+	// Artifacts that were stored before encryption was enabled can be read.
+	if err := plain.Grader(ctx, 1).Put(ctx, "details.json", strings.NewReader("{}")); err != nil {
+		t.Fatalf("Failed to put the artifact: %v", err)
+	}
+	r, err := encrypted.Grader(ctx, 1).Get(ctx, "details.json")
+	if err != nil {
+		t.Fatalf("Failed to get the artifact: %v", err)
+	}
+	contents, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(contents) != "{}" {
+		t.Errorf("artifact contents == %q, %v, want \"{}\", nil", string(contents), err)
+	}
+
+	if err := encrypted.Submissions.PutSource(ctx, guid, strings.NewReader("int main() {}")); err != nil {
+		t.Fatalf("Failed to put the source: %v", err)
+	}
+	if source, err := encrypted.Submissions.GetSource(ctx, guid); err != nil || source != "int main() {}" {
+		t.Errorf("GetSource() == %q, %v, want the source", source, err)
+	}
+	if _, err := plain.Submissions.GetSource(ctx, guid); !errors.Is(err, ErrArtifactEncrypted) {
+		t.Errorf("GetSource() without the key == %v, want %v", err, ErrArtifactEncrypted)
+	}
+}
@@ -0,0 +1,114 @@
+package grader
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+var (
+	// ErrBinaryTooLarge is returned when a binary does not fit in the
+	// BinaryCache.
+	ErrBinaryTooLarge = errors.New("binary too large")
+)
+
+// BinaryCache keeps the compiled binaries that runners upload, so that other
+// runners with the same architecture and toolchains can download them instead
+// of compiling the same program again. The oldest binaries are evicted when
+// the cache grows beyond its maximum size.
+type BinaryCache struct {
+	sync.Mutex
+	root    string
+	maxSize base.Byte
+	size    base.Byte
+	keys    []string
+	sizes   map[string]base.Byte
+}
+
+// NewBinaryCache creates an empty BinaryCache that stores the binaries in
+// root.
+func NewBinaryCache(root string, maxSize base.Byte) (*BinaryCache, error) {
+	// The index is not persisted, so any binaries left over from a previous
+	// run are not known anymore.
+	if err := os.RemoveAll(root); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &BinaryCache{
+		root:    root,
+		maxSize: maxSize,
+		sizes:   make(map[string]base.Byte),
+	}, nil
+}
+
+func (c *BinaryCache) path(key string) string {
+	return path.Join(c.root, key+".tar.gz")
+}
+
+// Open returns the binary with the provided key. It returns an error that
+// wraps os.ErrNotExist if it is not in the cache.
+func (c *BinaryCache) Open(key string) (*os.File, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.sizes[key]; !ok {
+		return nil, os.ErrNotExist
+	}
+	// Even if the binary is evicted right after this, the file can still be
+	// read until it is closed.
+	return os.Open(c.path(key))
+}
+
+// Put stores the binary with the provided key, evicting the oldest binaries
+// if needed. Storing a binary that is already in the cache is a no-op.
+func (c *BinaryCache) Put(key string, r io.Reader) error {
+	c.Lock()
+	_, ok := c.sizes[key]
+	c.Unlock()
+	if ok {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(c.root, "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, io.LimitReader(r, c.maxSize.Bytes()+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	size := base.Byte(n)
+	if size > c.maxSize {
+		return ErrBinaryTooLarge
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.sizes[key]; ok {
+		return nil
+	}
+	for c.size+size > c.maxSize && len(c.keys) > 0 {
+		oldest := c.keys[0]
+		c.keys = c.keys[1:]
+		c.size -= c.sizes[oldest]
+		delete(c.sizes, oldest)
+		os.Remove(c.path(oldest))
+	}
+	if err := os.Rename(f.Name(), c.path(key)); err != nil {
+		return err
+	}
+	c.keys = append(c.keys, key)
+	c.sizes[key] = size
+	c.size += size
+	return nil
+}
@@ -0,0 +1,54 @@
+package grader
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+func TestBinaryCache(t *testing.T) {
+	cache, err := NewBinaryCache(path.Join(t.TempDir(), "binaries"), base.Byte(10))
+	if err != nil {
+		t.Fatalf("Failed to create the cache: %v", err)
+	}
+
+	if _, err := cache.Open("a"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open() of a missing binary == %v, want %v", err, os.ErrNotExist)
+	}
+	if err := cache.Put("a", strings.NewReader("aaaa")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := cache.Put("b", strings.NewReader("bbbb")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := cache.Put("c", strings.NewReader("more than ten bytes")); !errors.Is(err, ErrBinaryTooLarge) {
+		t.Errorf("Put() of a large binary == %v, want %v", err, ErrBinaryTooLarge)
+	}
+
+	// Adding this one evicts the oldest binary.
+	if err := cache.Put("d", strings.NewReader("dddd")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if _, err := cache.Open("a"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open() of an evicted binary == %v, want %v", err, os.ErrNotExist)
+	}
+	for key, expected := range map[string]string{"b": "bbbb", "d": "dddd"} {
+		f, err := cache.Open(key)
+		if err != nil {
+			t.Fatalf("Open(%q) failed: %v", key, err)
+		}
+		contents, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %q: %v", key, err)
+		}
+		if string(contents) != expected {
+			t.Errorf("contents of %q == %q, want %q", key, contents, expected)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 
 	"github.com/omegaup/quark/common"
@@ -17,7 +18,11 @@ type Context struct {
 	QueueManager          *QueueManager
 	InflightMonitor       *InflightMonitor
 	InputManager          *common.InputManager
+	SlowProblemDetector   *SlowProblemDetector
 	LibinteractiveVersion string
+
+	// BinaryCache is nil if sharing compiled binaries is disabled.
+	BinaryCache *BinaryCache
 }
 
 // GetLibinteractiveVersion returns the version of the installed libinteractive
@@ -60,15 +65,43 @@ func NewContext(reader io.Reader) (*Context, error) {
 		return nil, err
 	}
 
+	queueManager := NewQueueManager(
+		ctx.Config.Grader.ChannelLength,
+		ctx.Config.Grader.RuntimePath,
+	)
+	getOrAddQueue := func(name string) *Queue {
+		queue, err := queueManager.Get(name)
+		if err != nil {
+			queue = queueManager.Add(name)
+		}
+		return queue
+	}
+	for name, profile := range ctx.Config.Grader.QueueSandboxProfiles {
+		getOrAddQueue(name).SandboxProfile = profile
+	}
+	for name, retries := range ctx.Config.Grader.QueueMaxGradeRetries {
+		getOrAddQueue(name).MaxGradeRetries = retries
+	}
+
+	var binaryCache *BinaryCache
+	if ctx.Config.Grader.BinaryCacheSize > 0 {
+		binaryCache, err = NewBinaryCache(
+			path.Join(ctx.Config.Grader.RuntimePath, "binaries"),
+			ctx.Config.Grader.BinaryCacheSize,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Context{
-		Context: *ctx,
-		QueueManager: NewQueueManager(
-			ctx.Config.Grader.ChannelLength,
-			ctx.Config.Grader.RuntimePath,
-		),
+		Context:               *ctx,
+		QueueManager:          queueManager,
 		InflightMonitor:       NewInflightMonitor(),
 		InputManager:          common.NewInputManager(ctx),
+		SlowProblemDetector:   NewSlowProblemDetector(&ctx.Config.Grader.SlowDetection),
 		LibinteractiveVersion: libinteractiveVersion,
+		BinaryCache:           binaryCache,
 	}, nil
 }
 
@@ -0,0 +1,262 @@
+package grader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// encryptedArtifactMagic is at the start of every encrypted artifact, so that
+// they can be told apart from the ones that were stored before encryption was
+// enabled.
+var encryptedArtifactMagic = []byte("omegaUp-encrypted-v1\n")
+
+const (
+	// encryptedChunkSize is the size of the plaintext of each chunk of an
+	// encrypted artifact. Only the last chunk can be shorter.
+	encryptedChunkSize = 64 * 1024
+	// encryptedNoncePrefixSize is the size of the random part of the nonces
+	// of an artifact. The rest is the index of the chunk and whether it is the
+	// last one.
+	encryptedNoncePrefixSize = 7
+)
+
+// ErrArtifactEncrypted is returned when reading an encrypted artifact without
+// the key that it was encrypted with.
+var ErrArtifactEncrypted = errors.New("artifact is encrypted with an unknown key")
+
+// ArtifactCipher encrypts the artifacts that the grader stores with
+// AES-256-GCM. The artifacts are split in chunks that are sealed separately,
+// so that they can be streamed, and the nonce of each chunk has its index and
+// whether it is the last one, so that chunks cannot be reordered or dropped.
+// The ID of the key is stored with each artifact, so that the keys can be
+// rotated while keeping the older ones to read the artifacts they encrypted.
+//
+// A nil ArtifactCipher does not encrypt anything, and can only read the
+// artifacts that are not encrypted.
+type ArtifactCipher struct {
+	keyID string
+	keys  map[string]cipher.AEAD
+}
+
+// NewArtifactCipher returns an ArtifactCipher that encrypts with the key with
+// the provided ID, and decrypts with any of the keys. All keys must be 32
+// bytes long. An empty key ID makes the ArtifactCipher not encrypt anything,
+// so that it is only used to read the artifacts that were already encrypted.
+func NewArtifactCipher(keyID string, keys map[string][]byte) (*ArtifactCipher, error) {
+	if _, ok := keys[keyID]; !ok && keyID != "" {
+		return nil, fmt.Errorf("encryption key %q not found", keyID)
+	}
+	c := &ArtifactCipher{
+		keyID: keyID,
+		keys:  make(map[string]cipher.AEAD),
+	}
+	for id, key := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("invalid encryption key ID %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q is %d bytes long, want 32", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		c.keys[id] = aead
+	}
+	return c, nil
+}
+
+// chunkNonce returns the nonce of a chunk of an artifact.
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptedNoncePrefixSize:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// Encrypt returns a writer that encrypts everything that is written to it
+// into w. It must be closed to write the last chunk.
+func (c *ArtifactCipher) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if c == nil || c.keyID == "" {
+		return nopWriteCloser{w}, nil
+	}
+	prefix := make([]byte, encryptedNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	header := append([]byte(nil), encryptedArtifactMagic...)
+	header = append(header, byte(len(c.keyID)))
+	header = append(header, c.keyID...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{
+		w:      w,
+		aead:   c.keys[c.keyID],
+		header: header,
+		prefix: prefix,
+	}, nil
+}
+
+// decrypt returns a reader with the plaintext of an artifact that was read
+// from f. Artifacts that are not encrypted are returned as they are.
+func (c *ArtifactCipher) decrypt(f *os.File) (io.ReadCloser, error) {
+	magic := make([]byte, len(encryptedArtifactMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, encryptedArtifactMagic) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("seek: %w", err)
+		}
+		return f, nil
+	}
+	r, err := c.Decrypt(io.MultiReader(bytes.NewReader(magic), f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+// Decrypt returns a reader with the plaintext of an encrypted artifact.
+func (c *ArtifactCipher) Decrypt(r io.Reader) (io.Reader, error) {
+	header := make([]byte, len(encryptedArtifactMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(encryptedArtifactMagic)], encryptedArtifactMagic) {
+		return nil, errors.New("artifact is not encrypted")
+	}
+	rest := make([]byte, int(header[len(header)-1])+encryptedNoncePrefixSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	header = append(header, rest...)
+	keyID := string(rest[:len(rest)-encryptedNoncePrefixSize])
+	if c == nil {
+		return nil, ErrArtifactEncrypted
+	}
+	aead, ok := c.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key %q: %w", keyID, ErrArtifactEncrypted)
+	}
+	return &decryptingReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: rest[len(rest)-encryptedNoncePrefixSize:],
+	}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type encryptingWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	buf    []byte
+}
+
+func (e *encryptingWriter) seal(plaintext []byte, last bool) error {
+	nonce := chunkNonce(e.prefix, e.index, last)
+	e.index++
+	if e.index == 0 {
+		return errors.New("artifact is too large")
+	}
+	_, err := e.w.Write(e.aead.Seal(nil, nonce, plaintext, e.header))
+	return err
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	// The last chunk is only sealed on Close, since it is the only one that is
+	// marked as such.
+	for len(e.buf) > encryptedChunkSize {
+		if err := e.seal(e.buf[:encryptedChunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[encryptedChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+type decryptingReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	buf    []byte
+	done   bool
+	chunk  []byte
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk. A chunk is the last one if it is shorter than
+// a full chunk, or if nothing follows it.
+func (d *decryptingReader) next() error {
+	if d.chunk == nil {
+		// One more byte is read to know whether a full chunk is the last one.
+		d.chunk = make([]byte, 0, encryptedChunkSize+d.aead.Overhead()+1)
+	}
+	full := encryptedChunkSize + d.aead.Overhead()
+	n, err := io.ReadFull(d.r, d.chunk[len(d.chunk):full+1])
+	d.chunk = d.chunk[:len(d.chunk)+n]
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read: %w", err)
+	}
+	last := len(d.chunk) <= full
+	sealed := d.chunk
+	if !last {
+		sealed = d.chunk[:full]
+	}
+	plaintext, err := d.aead.Open(nil, chunkNonce(d.prefix, d.index, last), sealed, d.header)
+	if err != nil {
+		return fmt.Errorf("decrypt chunk %d: %w", d.index, err)
+	}
+	d.index++
+	d.buf = plaintext
+	d.done = last
+	// Keep the byte of the next chunk that was read ahead.
+	d.chunk = append(d.chunk[:0], d.chunk[len(sealed):]...)
+	return nil
+}
@@ -0,0 +1,110 @@
+package grader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestArtifactCipher(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	oldCipher, err := NewArtifactCipher("old", map[string][]byte{"old": oldKey})
+	if err != nil {
+		t.Fatalf("Failed to create the cipher: %v", err)
+	}
+	c, err := NewArtifactCipher("new", map[string][]byte{"old": oldKey, "new": newKey})
+	if err != nil {
+		t.Fatalf("Failed to create the cipher: %v", err)
+	}
+
+	encrypt := func(c *ArtifactCipher, plaintext []byte) []byte {
+		var buf bytes.Buffer
+		w, err := c.Encrypt(&buf)
+		if err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		return buf.Bytes()
+	}
+	decrypt := func(c *ArtifactCipher, ciphertext []byte) ([]byte, error) {
+		r, err := c.Decrypt(bytes.NewReader(ciphertext))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	for _, size := range []int{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3 * encryptedChunkSize} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i % 251)
+		}
+		ciphertext := encrypt(c, plaintext)
+		if size > 0 && bytes.Contains(ciphertext, plaintext) {
+			t.Errorf("ciphertext of %d bytes contains the plaintext", size)
+		}
+		decrypted, err := decrypt(c, ciphertext)
+		if err != nil {
+			t.Errorf("Failed to decrypt %d bytes: %v", size, err)
+		} else if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("decrypted %d bytes do not match", size)
+		}
+
+		// Dropping chunks is detected.
+		if size >= encryptedChunkSize {
+			headerSize := len(encryptedArtifactMagic) + 1 + len("new") + encryptedNoncePrefixSize
+			lastChunkSize := (len(ciphertext) - headerSize) % (encryptedChunkSize + 16)
+			if lastChunkSize == 0 {
+				lastChunkSize = encryptedChunkSize + 16
+			}
+			truncated := ciphertext[:len(ciphertext)-lastChunkSize]
+			if _, err := decrypt(c, truncated); err == nil {
+				t.Errorf("decrypting a truncated artifact of %d bytes succeeded", size)
+			}
+		}
+	}
+
+	// Artifacts encrypted with older keys can still be read.
+	decrypted, err := decrypt(c, encrypt(oldCipher, []byte("hello")))
+	if err != nil || string(decrypted) != "hello" {
+		t.Errorf("decrypt() == %q, %v, want \"hello\", nil", decrypted, err)
+	}
+	if _, err := decrypt(oldCipher, encrypt(c, []byte("hello"))); !errors.Is(err, ErrArtifactEncrypted) {
+		t.Errorf("decrypt() with an unknown key == %v, want %v", err, ErrArtifactEncrypted)
+	}
+	if _, err := decrypt(nil, encrypt(c, []byte("hello"))); !errors.Is(err, ErrArtifactEncrypted) {
+		t.Errorf("decrypt() without keys == %v, want %v", err, ErrArtifactEncrypted)
+	}
+
+	// Tampering is detected.
+	ciphertext := encrypt(c, []byte("hello"))
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := decrypt(c, ciphertext); err == nil {
+		t.Errorf("decrypting a tampered artifact succeeded")
+	}
+
+	// Ciphers without a key ID only decrypt.
+	readOnly, err := NewArtifactCipher("", map[string][]byte{"old": oldKey})
+	if err != nil {
+		t.Fatalf("Failed to create the cipher: %v", err)
+	}
+	if plaintext := encrypt(readOnly, []byte("hello")); string(plaintext) != "hello" {
+		t.Errorf("encrypt() without a key ID == %q, want \"hello\"", plaintext)
+	}
+
+	for _, keys := range []map[string][]byte{
+		{"new": []byte("short")},
+		{"old": oldKey},
+	} {
+		if _, err := NewArtifactCipher("new", keys); err == nil {
+			t.Errorf("NewArtifactCipher(%v) succeeded, want an error", keys)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package grader
 
 import (
 	"bufio"
+	"compress/gzip"
 	"encoding/json"
 	stderrors "errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	base "github.com/omegaup/go-base/v3"
@@ -19,66 +21,139 @@ import (
 )
 
 var (
-	slowProblemCache = base.NewLRUCache[slowProblemEntry](4 * 1024 * 1024) // 4 MiB cache should be enough.
+	slowProblemCache = base.NewLRUCache[*slowProblemEntry](4 * 1024 * 1024) // 4 MiB cache should be enough.
 )
 
-type slowProblemEntry bool
+// slowProblemEntry holds the settings of a problem that the grader needs to
+// know before the run is sent to a runner.
+type slowProblemEntry struct {
+	slow          bool
+	scoreRounding *common.ScoreRoundingSettings
+	languages     []string
+	maxSourceSize base.Byte
+}
 
-var _ base.SizedEntry = (slowProblemEntry)(false)
+var _ base.SizedEntry = (*slowProblemEntry)(nil)
 
-func (e slowProblemEntry) Size() base.Byte {
+func (e *slowProblemEntry) Size() base.Byte {
 	return base.Byte(1)
 }
 
-func (e slowProblemEntry) Release() {
+func (e *slowProblemEntry) Release() {
 	// It's just, like, a bool.
 }
 
 // IsProblemSlow returns whether the problem at that particular commit is slow.
 // It uses a global cache to avoid having to ask this question for every single problem.
 func IsProblemSlow(
+	ctx *common.Context,
 	gitserverURL string,
 	gitserverAuthorization string,
 	problemName string,
 	inputHash string,
 ) (bool, error) {
+	entry, err := getProblemEntry(ctx, gitserverURL, gitserverAuthorization, problemName, inputHash)
+	if err != nil {
+		return false, err
+	}
+	return entry.slow, nil
+}
+
+// ProblemScoreRounding returns the score rounding policy of the problem at
+// that particular commit, or nil if the problem does not override it. It uses
+// the same cache as IsProblemSlow.
+func ProblemScoreRounding(
+	ctx *common.Context,
+	gitserverURL string,
+	gitserverAuthorization string,
+	problemName string,
+	inputHash string,
+) (*common.ScoreRoundingSettings, error) {
+	entry, err := getProblemEntry(ctx, gitserverURL, gitserverAuthorization, problemName, inputHash)
+	if err != nil {
+		return nil, err
+	}
+	return entry.scoreRounding, nil
+}
+
+// ValidateSubmission returns an error if the problem at that particular commit
+// does not accept a submission in the language and with a source of the
+// provided size. It uses the same cache as IsProblemSlow, and errors that are
+// caused by the submission wrap common.ErrLanguageNotAllowed or
+// common.ErrSourceTooLarge.
+func ValidateSubmission(
+	ctx *common.Context,
+	gitserverURL string,
+	gitserverAuthorization string,
+	problemName string,
+	inputHash string,
+	language string,
+	sourceSize base.Byte,
+) error {
+	entry, err := getProblemEntry(ctx, gitserverURL, gitserverAuthorization, problemName, inputHash)
+	if err != nil {
+		return err
+	}
+	settings := common.ProblemSettings{
+		Languages:     entry.languages,
+		MaxSourceSize: entry.maxSourceSize,
+	}
+	return settings.ValidateSubmission(language, sourceSize)
+}
+
+func getProblemEntry(
+	ctx *common.Context,
+	gitserverURL string,
+	gitserverAuthorization string,
+	problemName string,
+	inputHash string,
+) (*slowProblemEntry, error) {
 	if !strings.HasSuffix(gitserverURL, "/") {
 		gitserverURL += "/"
 	}
 	cacheKey := fmt.Sprintf("%s:%s", problemName, inputHash)
-	entry, err := slowProblemCache.Get(cacheKey, func(key string) (slowProblemEntry, error) {
+	entry, err := slowProblemCache.Get(cacheKey, func(key string) (*slowProblemEntry, error) {
 		client := &http.Client{
 			Timeout: 15 * time.Second,
 		}
 
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s%s/+/%s/settings.json", gitserverURL, problemName, inputHash), nil)
+		req, err := http.NewRequestWithContext(
+			ctx.Context,
+			"GET",
+			fmt.Sprintf("%s%s/+/%s/settings.json", gitserverURL, problemName, inputHash),
+			nil,
+		)
 		if err != nil {
-			return false, errors.Wrapf(err, "failed to create a request for problem settings for %s", cacheKey)
+			return nil, errors.Wrapf(err, "failed to create a request for problem settings for %s", cacheKey)
 		}
 		if gitserverAuthorization != "" {
 			req.Header.Add("Authorization", gitserverAuthorization)
 		}
 		resp, err := client.Do(req)
 		if err != nil {
-			return false, errors.Wrapf(err, "failed to get problem settings for %s", cacheKey)
+			return nil, errors.Wrapf(err, "failed to get problem settings for %s", cacheKey)
 		}
 		var problemSettings common.ProblemSettings
 		err = json.NewDecoder(resp.Body).Decode(&problemSettings)
 		resp.Body.Close()
 		if err != nil {
-			return false, errors.Wrapf(err, "failed to unmarshal settings.json for %s", cacheKey)
+			return nil, errors.Wrapf(err, "failed to unmarshal settings.json for %s", cacheKey)
 		}
 
-		slow := slowProblemEntry(problemSettings.Slow)
-		return slow, nil
+		return &slowProblemEntry{
+			slow:          problemSettings.Slow,
+			scoreRounding: problemSettings.ScoreRounding,
+			languages:     problemSettings.Languages,
+			maxSourceSize: problemSettings.MaxSourceSize,
+		}, nil
 	})
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	slow := bool(entry.Value)
+	value := entry.Value
 	slowProblemCache.Put(entry)
 
-	return slow, nil
+	return value, nil
 }
 
 // CreateArchiveFromGit creates an archive that can be sent to a Runner as an
@@ -134,6 +209,11 @@ type graderBaseInput struct {
 	archivePath      string
 	storedHash       string
 	uncompressedSize int64
+
+	// lazyLock protects lazyHash, the hash of the lazy input archive, which is
+	// created the first time a runner requests it.
+	lazyLock sync.Mutex
+	lazyHash string
 }
 
 func (input *graderBaseInput) Verify() error {
@@ -198,6 +278,7 @@ func (input *graderBaseInput) getStoredLength() (int64, error) {
 }
 
 func (input *graderBaseInput) Delete() error {
+	os.Remove(input.lazyArchivePath())
 	os.Remove(fmt.Sprintf("%s.tmp", input.archivePath))
 	os.Remove(fmt.Sprintf("%s.sha1", input.archivePath))
 	os.Remove(fmt.Sprintf("%s.len", input.archivePath))
@@ -211,20 +292,99 @@ func (input *graderBaseInput) Release() {
 // Transmit sends a serialized version of the Input to the runner. It sends a
 // .tar.gz file with the Content-SHA1 header with the hexadecimal
 // representation of its SHA-1 hash.
-func (input *graderBaseInput) Transmit(w http.ResponseWriter) error {
+func (input *graderBaseInput) Transmit(w http.ResponseWriter, r *http.Request) error {
+	if strings.Contains(r.Header.Get("Accept"), common.LazyArchiveContentType) {
+		return input.transmitLazyArchive(w, r)
+	}
 	fd, err := os.Open(input.archivePath)
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
 	w.Header().Add("Content-Type", "application/x-gzip")
 	w.Header().Add("Content-SHA1", input.storedHash)
 	w.Header().Add(
 		"X-Content-Uncompressed-Size", strconv.FormatInt(input.uncompressedSize, 10),
 	)
-	w.WriteHeader(http.StatusOK)
-	_, err = io.Copy(w, fd)
-	return err
+	http.ServeContent(w, r, "", info.ModTime(), fd)
+	return nil
+}
+
+func (input *graderBaseInput) lazyArchivePath() string {
+	return fmt.Sprintf("%s.lazy", input.archivePath)
+}
+
+// ensureLazyArchive converts the .tar.gz archive into a lazy input archive,
+// if that has not been done before, and returns its hash.
+func (input *graderBaseInput) ensureLazyArchive() (string, error) {
+	input.lazyLock.Lock()
+	defer input.lazyLock.Unlock()
+	if input.lazyHash != "" {
+		return input.lazyHash, nil
+	}
+
+	fd, err := os.Open(input.archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+
+	tmpPath := fmt.Sprintf("%s.tmp", input.lazyArchivePath())
+	defer os.Remove(tmpPath)
+	tmpFd, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := common.WriteLazyArchive(tmpFd, gz); err != nil {
+		tmpFd.Close()
+		return "", errors.Wrap(err, "failed to create the lazy input archive")
+	}
+	if err := tmpFd.Close(); err != nil {
+		return "", err
+	}
+	hash, err := common.Sha1sum(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, input.lazyArchivePath()); err != nil {
+		return "", err
+	}
+	input.lazyHash = fmt.Sprintf("%0x", hash)
+	return input.lazyHash, nil
+}
+
+// transmitLazyArchive sends the Input as a lazy input archive, which allows
+// the runner to only decompress the cases it needs.
+func (input *graderBaseInput) transmitLazyArchive(w http.ResponseWriter, r *http.Request) error {
+	lazyHash, err := input.ensureLazyArchive()
+	if err != nil {
+		return err
+	}
+	fd, err := os.Open(input.lazyArchivePath())
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Content-Type", common.LazyArchiveContentType)
+	w.Header().Add("Content-SHA1", lazyHash)
+	w.Header().Add(
+		"X-Content-Uncompressed-Size", strconv.FormatInt(input.uncompressedSize, 10),
+	)
+	http.ServeContent(w, r, "", info.ModTime(), fd)
+	return nil
 }
 
 // Input is a common.Input generated from a git repository that is then stored
@@ -302,8 +462,8 @@ func (input *Input) Persist() error {
 // Transmit sends a serialized version of the Input to the runner. It sends a
 // .tar.gz file with the Content-SHA1 header with the hexadecimal
 // representation of its SHA-1 hash.
-func (input *Input) Transmit(w http.ResponseWriter) error {
-	return input.graderBaseInput.Transmit(w)
+func (input *Input) Transmit(w http.ResponseWriter, r *http.Request) error {
+	return input.graderBaseInput.Transmit(w, r)
 }
 
 // InputFactory is a common.InputFactory that can store specific versions of a
@@ -326,6 +486,25 @@ func NewInputFactory(
 	}
 }
 
+func inputArchivePath(config *common.Config, hash string) string {
+	return path.Join(
+		config.Grader.RuntimePath,
+		"cache",
+		fmt.Sprintf("%s/%s.tar.gz", hash[:2], hash[2:]),
+	)
+}
+
+// InputCached returns whether the input identified by the supplied hash is
+// already in the on-disk cache, so that it does not need to be fetched from
+// the gitserver before its runs can be graded.
+func InputCached(config *common.Config, hash string) bool {
+	if len(hash) < 3 {
+		return false
+	}
+	_, err := os.Stat(inputArchivePath(config, hash))
+	return err == nil
+}
+
 // NewInput creates a new Input that is identified by the supplied hash.
 func (factory *InputFactory) NewInput(
 	hash string,
@@ -337,11 +516,7 @@ func (factory *InputFactory) NewInput(
 				hash,
 				mgr,
 			),
-			archivePath: path.Join(
-				factory.config.Grader.RuntimePath,
-				"cache",
-				fmt.Sprintf("%s/%s.tar.gz", hash[:2], hash[2:]),
-			),
+			archivePath: inputArchivePath(factory.config, hash),
 		},
 		problemName:            factory.problemName,
 		gitserverURL:           factory.config.Grader.GitserverURL,
@@ -206,6 +206,7 @@ func TestIsProblemSlow(t *testing.T) {
 	}
 
 	slow, err := IsProblemSlow(
+		&ctx.Context,
 		ctx.Config.Grader.GitserverURL,
 		ctx.Config.Grader.GitserverAuthorization,
 		"test",
@@ -267,7 +268,7 @@ func TestTransmitInput(t *testing.T) {
 
 	graderInput := inputRef.Input.(*Input)
 	w := httptest.NewRecorder()
-	if err := graderInput.Transmit(w); err != nil {
+	if err := graderInput.Transmit(w, httptest.NewRequest("GET", "/input/", nil)); err != nil {
 		t.Fatalf("Failed to transmit input: %q", err)
 	}
 	headers := w.Header()
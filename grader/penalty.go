@@ -0,0 +1,44 @@
+package grader
+
+import (
+	"math"
+)
+
+const (
+	// PenaltyTypeNone is used when runs have no penalty.
+	PenaltyTypeNone = "none"
+
+	// PenaltyTypeRuntime is used when the penalty of a run is its runtime, in
+	// milliseconds.
+	PenaltyTypeRuntime = "runtime"
+
+	// PenaltyTypeContestStart is used when the penalty of a run is the number
+	// of minutes between the start of the contest and the submission.
+	PenaltyTypeContestStart = "contest_start"
+
+	// PenaltyTypeProblemOpen is used when the penalty of a run is the number of
+	// minutes between the contestant opening the problem and the submission.
+	PenaltyTypeProblemOpen = "problem_open"
+)
+
+// Penalty returns the penalty of the run according to its penalty type, and
+// whether it could be computed. Runs outside of a contest (or with a penalty
+// type that the grader does not know about) keep the penalty that the
+// frontend assigned to them when they were created.
+func (runInfo *RunInfo) Penalty() (int64, bool) {
+	switch runInfo.PenaltyType {
+	case PenaltyTypeNone:
+		return 0, true
+	case PenaltyTypeRuntime:
+		return int64(math.Round(runInfo.Result.Time * 1000)), true
+	case PenaltyTypeContestStart, PenaltyTypeProblemOpen:
+		// The frontend already measured the time from the relevant starting
+		// point when the submission was made.
+		if runInfo.SubmitDelay < 0 {
+			return 0, false
+		}
+		return runInfo.SubmitDelay, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,43 @@
+package grader
+
+import (
+	"testing"
+
+	"github.com/omegaup/quark/runner"
+)
+
+func TestRunInfoPenalty(t *testing.T) {
+	for _, tc := range []struct {
+		penaltyType string
+		time        float64
+		submitDelay int64
+		expected    int64
+		ok          bool
+	}{
+		{PenaltyTypeNone, 1.5, 10, 0, true},
+		{PenaltyTypeRuntime, 1.5, 10, 1500, true},
+		{PenaltyTypeRuntime, 0.0004, 10, 0, true},
+		{PenaltyTypeContestStart, 1.5, 10, 10, true},
+		{PenaltyTypeProblemOpen, 1.5, 25, 25, true},
+		{PenaltyTypeContestStart, 1.5, -1, 0, false},
+		{"", 1.5, 10, 0, false},
+		{"unknown", 1.5, 10, 0, false},
+	} {
+		runInfo := &RunInfo{
+			PenaltyType: tc.penaltyType,
+			SubmitDelay: tc.submitDelay,
+			Result:      runner.RunResult{Time: tc.time},
+		}
+		penalty, ok := runInfo.Penalty()
+		if penalty != tc.expected || ok != tc.ok {
+			t.Errorf(
+				"RunInfo{PenaltyType: %q}.Penalty() == (%d, %v), want (%d, %v)",
+				tc.penaltyType,
+				penalty,
+				ok,
+				tc.expected,
+				tc.ok,
+			)
+		}
+	}
+}
@@ -6,11 +6,13 @@ import (
 	"container/list"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/big"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,6 +29,21 @@ import (
 // those in a lower priority queue.
 type QueuePriority int
 
+// queuePriorityNames are the names of the priorities in the configuration.
+var queuePriorityNames = [QueueCount]string{"high", "normal", "low", "ephemeral"}
+
+// Name returns the name of the priority, as used in the configuration.
+func (p QueuePriority) Name() string {
+	if p < 0 || p >= QueueCount {
+		return ""
+	}
+	return queuePriorityNames[p]
+}
+
+// minRerouteDelay is the minimum time a run waits before going back to the
+// queue after a runner reported that it is not able to grade it.
+const minRerouteDelay = time.Second
+
 // QueueEventType represents the type of event that just occurred.
 type QueueEventType int
 
@@ -73,6 +90,47 @@ type EphemeralRunRequest struct {
 	Input    *common.LiteralInput `json:"input"`
 }
 
+// An ExecuteRequest represents a client's request to compile some code and
+// run it against a single input, without scoring it. This is used to try out
+// generators and validators while creating a problem.
+type ExecuteRequest struct {
+	Source   string                 `json:"source"`
+	Language string                 `json:"language"`
+	Input    string                 `json:"input"`
+	Limits   *common.LimitsSettings `json:"limits,omitempty"`
+}
+
+// An ExecuteResult is the outcome of an ExecuteRequest.
+type ExecuteResult struct {
+	CompileError *string                       `json:"compile_error,omitempty"`
+	CompileMeta  map[string]runner.RunMetadata `json:"compile_meta"`
+	Stdout       string                        `json:"stdout"`
+	Stderr       string                        `json:"stderr"`
+	Meta         *runner.RunMetadata           `json:"meta,omitempty"`
+}
+
+// A ValidateRequest represents a client's request to run a custom validator
+// against a candidate output for a single case, to debug the validator.
+type ValidateRequest struct {
+	Source         string                 `json:"source"`
+	Language       string                 `json:"language"`
+	Input          string                 `json:"input"`
+	ExpectedOutput string                 `json:"expected_output"`
+	Output         string                 `json:"output"`
+	Limits         *common.LimitsSettings `json:"limits,omitempty"`
+}
+
+// A ValidateResult is the outcome of a ValidateRequest. Score is the score
+// that the validator gave to the candidate output, in the [0, 1] range.
+type ValidateResult struct {
+	CompileError *string                       `json:"compile_error,omitempty"`
+	CompileMeta  map[string]runner.RunMetadata `json:"compile_meta"`
+	Verdict      common.Verdict                `json:"verdict,omitempty"`
+	Score        float64                       `json:"score"`
+	Stderr       string                        `json:"stderr"`
+	Meta         *runner.RunMetadata           `json:"meta,omitempty"`
+}
+
 // EphemeralRunManager handles a queue of recently-submitted ephemeral runs.
 // This has a fixed maximum size with a last-in, first-out eviction policy.
 type EphemeralRunManager struct {
@@ -293,9 +351,47 @@ type RunInfo struct {
 	Priority     QueuePriority
 	PenaltyType  string
 	ScoreMode    string
+	Slow         bool
+
+	// SubmitDelay is the number of minutes between the start of the contest
+	// (or the contestant opening the problem) and the submission, as computed
+	// by the frontend. It is used for the contest_start and problem_open
+	// penalty types.
+	SubmitDelay int64
+
+	// ScoreRounding is the policy used to round the contest score when it is
+	// stored and broadcast.
+	ScoreRounding *common.ScoreRoundingSettings
+
+	// FeedbackLevel is how much of the results can be shown to the contestant,
+	// as selected by the problemset. It determines what is written to
+	// details.redacted.json.
+	FeedbackLevel common.FeedbackLevel
+
+	// TimeLimit is the time limit of each case of the problem, or 0 if it is
+	// not known. It is set when the run is added to a Queue.
+	TimeLimit time.Duration
+
+	// MaxAttempts is the number of attempts that the run gets once it is added
+	// to a Queue. 0 means that the queue's default is used. It is set when the
+	// run is imported from the queue snapshot of another grader, so that it
+	// keeps the attempts it had left there.
+	MaxAttempts int
 
 	CreationTime time.Time
 	QueueTime    time.Time
+
+	// Summary is filled in as the run goes through the grader and the runner.
+	Summary RunSummary
+}
+
+// RunSummary is a compact summary of where the time of a run was spent, in
+// seconds. QueueWait is measured from the creation of the run until the
+// runner that graded it picked it up, so it includes any failed attempts.
+type RunSummary struct {
+	QueueWait float64 `json:"queue_wait"`
+	runner.RunTimings
+	DBWrite float64 `json:"db_write"`
 }
 
 // RunWaitHandle allows waiting on the run to change state.
@@ -334,9 +430,14 @@ type RunContext struct {
 	inputRef *common.InputRef
 
 	attemptsLeft int
-	queue        *Queue
-	queueManager *QueueManager
-	monitor      *InflightMonitor
+	// Whether the run was already retried due to a failure on the judge side
+	// that was not reported as a JE.
+	judgeFailureRetried bool
+	// The runners that reported that they are not able to grade the run.
+	incompatibleRunners map[string]struct{}
+	queue               *Queue
+	queueManager        *QueueManager
+	monitor             *InflightMonitor
 
 	runWaitHandle *RunWaitHandle
 }
@@ -348,7 +449,7 @@ func NewRunInfo() *RunInfo {
 			AttemptID: common.NewAttemptID(),
 			MaxScore:  big.NewRat(1, 1),
 		},
-		Result:       *runner.NewRunResult("JE", &big.Rat{}),
+		Result:       *runner.NewRunResult(common.VerdictJudgeError, &big.Rat{}),
 		CreationTime: time.Now(),
 		Priority:     QueuePriorityNormal,
 	}
@@ -374,6 +475,9 @@ func (runCtx *RunContext) Close() {
 		runCtx.Log.Warn("Attempting to close an already closed run", nil)
 		return
 	}
+	runCtx.recordTimeline(RunTimelineFinished, runCtx.RunInfo.Result.JudgedBy, map[string]any{
+		"verdict": runCtx.RunInfo.Result.Verdict,
+	})
 	defer runCtx.Context.Transaction.End()
 	runCtx.Log.Info(
 		"Marking run as done",
@@ -391,12 +495,14 @@ func (runCtx *RunContext) Close() {
 		if runCtx.runWaitHandle != nil {
 			close(runCtx.runWaitHandle.ready)
 		}
+		runCtx.queueManager.deactivate(runCtx)
 		runCtx.queueManager.PostProcessor.PostProcess(runCtx.RunInfo)
 
 		runCtx.Context.Close()
 	}()
-	if runCtx.monitor != nil {
-		runCtx.monitor.Remove(runCtx.RunInfo.Run.AttemptID)
+	if monitor := runCtx.monitor; monitor != nil {
+		monitor.Remove(runCtx.RunInfo.Run.AttemptID)
+		monitor.finish(runCtx.RunInfo.Run.AttemptID, attemptCompleted)
 	}
 	if runCtx.inputRef != nil {
 		runCtx.inputRef.Release()
@@ -427,31 +533,35 @@ func (runCtx *RunContext) Close() {
 		}
 	}
 
-	// Persist logs
+	// Results that can be shown to contestants
 	{
-		var logsBuffer bytes.Buffer
-		gz := gzip.NewWriter(&logsBuffer)
-		if _, err := gz.Write(runCtx.LogBuffer()); err != nil {
-			gz.Close()
+		prettyPrinted, err := json.MarshalIndent(runCtx.RunInfo.Result.WithFeedback(runCtx.RunInfo.FeedbackLevel), "", "  ")
+		if err != nil {
 			runCtx.Log.Error(
-				"Unable to write log file",
+				"Unable to marshal redacted results file",
 				map[string]any{
 					"err": err,
 				},
 			)
 			return
 		}
-		if err := gz.Close(); err != nil {
+		err = runCtx.RunInfo.Artifacts.Put(runCtx.Context, "details.redacted.json", bytes.NewReader(prettyPrinted))
+		if err != nil {
 			runCtx.Log.Error(
-				"Unable to finalize log file",
+				"Unable to write redacted results file",
 				map[string]any{
 					"err": err,
 				},
 			)
 			return
 		}
-		err := runCtx.RunInfo.Artifacts.Put(runCtx.Context, "logs.txt.gz", &logsBuffer)
-		if err != nil {
+	}
+
+	// Persist logs
+	{
+		logs := compressedRunLogs(runCtx.LogBuffer(), runCtx.Config.Grader.MaxRunLogSize)
+		defer logs.Close()
+		if err := runCtx.RunInfo.Artifacts.Put(runCtx.Context, "logs.txt.gz", logs); err != nil {
 			runCtx.Log.Error(
 				"Unable to create log file",
 				map[string]any{
@@ -463,12 +573,45 @@ func (runCtx *RunContext) Close() {
 	}
 }
 
+// compressedRunLogs returns a reader with the gzip-compressed logs of a run,
+// which are compressed as they are read instead of being held in memory. If
+// the logs are larger than maxSize, only the last maxSize bytes are kept,
+// since the runners' logs and the final verdict are appended at the end. The
+// reader must be closed so that the compression goroutine finishes.
+func compressedRunLogs(contents []byte, maxSize base.Byte) io.ReadCloser {
+	if maxSize > 0 && base.Byte(len(contents)) > maxSize {
+		contents = contents[len(contents)-int(maxSize):]
+	}
+	r, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		if _, err := gz.Write(contents); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		w.CloseWithError(gz.Close())
+	}()
+	return r
+}
+
+// RetryJudgeFailure returns whether a run that failed due to a problem on the
+// judge side should be retried. Each run is retried at most once.
+func (runCtx *RunContext) RetryJudgeFailure() bool {
+	if runCtx.judgeFailureRetried {
+		return false
+	}
+	runCtx.judgeFailureRetried = true
+	return true
+}
+
 // Requeue adds a RunContext back to the Queue from where it came from, if it
 // has any retries left. It always adds the RunContext to the highest-priority
-// queue.
+// queue. If a retry delay is configured, the RunContext is added once the
+// delay elapses and Requeue returns immediately.
 func (runCtx *RunContext) Requeue(lastAttempt bool) bool {
-	if runCtx.monitor != nil {
-		runCtx.monitor.Remove(runCtx.RunInfo.Run.AttemptID)
+	if monitor := runCtx.monitor; monitor != nil {
+		monitor.Remove(runCtx.RunInfo.Run.AttemptID)
+		monitor.finish(runCtx.RunInfo.Run.AttemptID, attemptSuperseded)
 	}
 	runCtx.attemptsLeft--
 	if runCtx.attemptsLeft <= 0 {
@@ -477,6 +620,9 @@ func (runCtx *RunContext) Requeue(lastAttempt bool) bool {
 			Priority: runCtx.RunInfo.Priority,
 			Type:     QueueEventTypeAbandoned,
 		})
+		runCtx.recordTimeline(RunTimelineAbandoned, "", map[string]any{
+			"reason": "too many failed attempts",
+		})
 		runCtx.Log.Error("run errored out too many times. giving up", nil)
 		runCtx.Close()
 		return false
@@ -487,7 +633,66 @@ func (runCtx *RunContext) Requeue(lastAttempt bool) bool {
 		// most once more.
 		runCtx.attemptsLeft = 1
 	}
+	runCtx.recordTimeline(RunTimelineRequeued, "", map[string]any{
+		"attempts_left": runCtx.attemptsLeft,
+	})
+	return runCtx.retry(0)
+}
+
+// Reroute adds the RunContext back to the Queue because the runner it was
+// given to is not able to grade it, so that a different runner picks it up.
+// This does not count as a failed attempt. Returns false if the run could not
+// be added back to the Queue.
+func (runCtx *RunContext) Reroute(runner string) bool {
+	if monitor := runCtx.monitor; monitor != nil {
+		monitor.Remove(runCtx.RunInfo.Run.AttemptID)
+		monitor.finish(runCtx.RunInfo.Run.AttemptID, attemptSuperseded)
+	}
+	if runCtx.incompatibleRunners == nil {
+		runCtx.incompatibleRunners = make(map[string]struct{})
+	}
+	runCtx.incompatibleRunners[runner] = struct{}{}
+	runCtx.recordTimeline(RunTimelineRerouted, runner, nil)
+	// Avoid having the same runner pick the run up again immediately.
+	return runCtx.retry(minRerouteDelay)
+}
+
+// IncompatibleWith returns whether the runner reported that it is not able to
+// grade the run.
+func (runCtx *RunContext) IncompatibleWith(runner string) bool {
+	_, ok := runCtx.incompatibleRunners[runner]
+	return ok
+}
+
+// retry gives the run a new attempt ID and adds it back to the Queue, after
+// the configured retry delay (but no sooner than minDelay).
+func (runCtx *RunContext) retry(minDelay time.Duration) bool {
 	runCtx.RunInfo.Run.UpdateAttemptID()
+	delay := runCtx.retryDelay()
+	if delay < minDelay {
+		delay = minDelay
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			runCtx.enqueueRetry()
+		})
+		return true
+	}
+	return runCtx.enqueueRetry()
+}
+
+// retryDelay returns how long the run should wait before being queued again,
+// so that runs that fail at the same time do not all retry at once.
+func (runCtx *RunContext) retryDelay() time.Duration {
+	delay := time.Duration(runCtx.Config.Grader.GradeRetryDelay)
+	if jitter := int64(runCtx.Config.Grader.GradeRetryJitter); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter + 1))
+	}
+	return delay
+}
+
+// enqueueRetry places the run back in its queue after a failed attempt.
+func (runCtx *RunContext) enqueueRetry() bool {
 	// Since it was already ready to be executed, place it in the high-priority
 	// queue.
 	if !runCtx.queue.enqueue(runCtx, QueuePriorityHigh) {
@@ -497,6 +702,9 @@ func (runCtx *RunContext) Requeue(lastAttempt bool) bool {
 			Priority: runCtx.RunInfo.Priority,
 			Type:     QueueEventTypeAbandoned,
 		})
+		runCtx.recordTimeline(RunTimelineAbandoned, "", map[string]any{
+			"reason": "the high-priority queue is full",
+		})
 		runCtx.Log.Error("The high-priority queue is full. giving up", nil)
 		runCtx.Close()
 		return false
@@ -509,6 +717,65 @@ func (runCtx *RunContext) Requeue(lastAttempt bool) bool {
 	return true
 }
 
+// dispatchDelay returns how long the run must wait before it can be handed to
+// a runner so that its problem does not exceed its configured dispatch rate.
+// If it can be handed right away, the dispatch is counted towards the rate.
+func (runCtx *RunContext) dispatchDelay() time.Duration {
+	rate, ok := runCtx.Config.Grader.ProblemDispatchRates[runCtx.RunInfo.Run.ProblemName]
+	if !ok || rate <= 0 {
+		return 0
+	}
+	return runCtx.queueManager.reserveDispatch(
+		runCtx.RunInfo.Run.ProblemName,
+		time.Duration(float64(time.Minute)/rate),
+		time.Now(),
+	)
+}
+
+// throttle places the run back in its queue after the provided delay. Unlike
+// a retry, this does not count as an attempt and the run keeps its priority.
+func (runCtx *RunContext) throttle(delay time.Duration) {
+	runCtx.Metrics.CounterAdd("grader_runs_throttled", 1)
+	runCtx.Log.Debug(
+		"Problem dispatch rate exceeded, delaying run",
+		map[string]any{
+			"problem": runCtx.RunInfo.Run.ProblemName,
+			"delay":   delay,
+		},
+	)
+	runCtx.recordTimeline(RunTimelineThrottled, "", map[string]any{
+		"delay": delay.Seconds(),
+	})
+	queue := runCtx.queue
+	time.AfterFunc(delay, func() {
+		queue.runs[runCtx.RunInfo.Priority] <- runCtx
+		queue.ready <- struct{}{}
+	})
+}
+
+// Closed returns whether the RunContext has already been closed.
+func (runCtx *RunContext) Closed() bool {
+	return atomic.LoadInt32(&runCtx.closedFlag) != 0
+}
+
+// recordTimeline adds an event about the current attempt of the run to its
+// timeline.
+func (runCtx *RunContext) recordTimeline(
+	eventType RunTimelineEventType,
+	runner string,
+	details map[string]any,
+) {
+	event := RunTimelineEvent{
+		Type:    eventType,
+		Runner:  runner,
+		Details: details,
+	}
+	if runCtx.RunInfo.Run != nil {
+		event.AttemptID = runCtx.RunInfo.Run.AttemptID
+	}
+	runCtx.queueManager.Timelines.Record(runCtx.RunInfo.GUID, event)
+}
+
 func (runCtx *RunContext) String() string {
 	return fmt.Sprintf(
 		"RunContext{ID:%d, GUID:%s, AttemptsLeft: %d, %s}",
@@ -521,7 +788,16 @@ func (runCtx *RunContext) String() string {
 
 // Queue represents a RunContext queue with three discrete priorities.
 type Queue struct {
-	Name         string
+	Name string
+
+	// SandboxProfile is the sandbox profile that runners use for the runs in
+	// this queue. Empty means the runner's default sandbox.
+	SandboxProfile string
+
+	// MaxGradeRetries is the number of attempts that the runs in this queue
+	// get. 0 means that the configured default for their priority is used.
+	MaxGradeRetries int
+
 	runs         [QueueCount]chan *RunContext
 	ready        chan struct{}
 	queueManager *QueueManager
@@ -535,43 +811,123 @@ func (queue *Queue) GetRun(
 	monitor *InflightMonitor,
 	closeNotifier <-chan bool,
 ) (*RunContext, <-chan struct{}, bool) {
-	select {
-	case <-closeNotifier:
-		return nil, nil, false
-	case <-queue.ready:
+	for {
+		select {
+		case <-closeNotifier:
+			return nil, nil, false
+		case <-queue.ready:
+		}
+
+		runCtx := queue.dequeue()
+		if delay := runCtx.dispatchDelay(); delay > 0 {
+			// The problem is being dispatched more often than it is allowed to.
+			// Let other runs go first.
+			runCtx.throttle(delay)
+			continue
+		}
+		inflight := monitor.Add(runCtx, runner)
+		return runCtx, inflight.timeout, true
 	}
+}
 
+// dequeue removes the highest-priority RunContext from the queue. It must
+// only be called after receiving from queue.ready.
+func (queue *Queue) dequeue() *RunContext {
 	for i := range queue.runs {
 		select {
 		case runCtx := <-queue.runs[i]:
-			inflight := monitor.Add(runCtx, runner)
-			return runCtx, inflight.timeout, true
+			return runCtx
 		default:
 		}
 	}
 	panic("unreachable")
 }
 
+// maxGradeRetries returns the number of attempts that a run in this queue
+// with the provided priority gets.
+func (queue *Queue) maxGradeRetries(ctx *common.Context, priority QueuePriority) int {
+	if queue.MaxGradeRetries > 0 {
+		return queue.MaxGradeRetries
+	}
+	if priority >= 0 && priority < QueueCount {
+		if retries, ok := ctx.Config.Grader.PriorityMaxGradeRetries[queuePriorityNames[priority]]; ok {
+			return retries
+		}
+	}
+	return ctx.Config.Grader.MaxGradeRetries
+}
+
+// attempts returns the number of attempts that the run gets when it is added
+// to this queue.
+func (queue *Queue) attempts(ctx *common.Context, runInfo *RunInfo) int {
+	if runInfo.MaxAttempts > 0 {
+		return runInfo.MaxAttempts
+	}
+	return queue.maxGradeRetries(ctx, runInfo.Priority)
+}
+
+// pinSandboxProfile makes the run use the queue's sandbox profile, unless it
+// already requested one.
+func (queue *Queue) pinSandboxProfile(runInfo *RunInfo) {
+	if runInfo.Run != nil && runInfo.Run.SandboxProfile == "" {
+		runInfo.Run.SandboxProfile = queue.SandboxProfile
+	}
+}
+
+// pinToolchain makes the run use the toolchain that the problem settings pin
+// for its language, if any.
+func pinToolchain(runInfo *RunInfo, inputRef *common.InputRef) {
+	if runInfo.Run == nil || inputRef == nil || runInfo.Run.Toolchain != "" {
+		return
+	}
+	if settings := inputRef.Input.Settings(); settings != nil {
+		runInfo.Run.Toolchain = settings.Toolchains[runInfo.Run.Language]
+	}
+}
+
+// recordTimeLimit stores the time limit that the problem settings set for
+// each case in the run.
+func recordTimeLimit(runInfo *RunInfo, inputRef *common.InputRef) {
+	if inputRef == nil {
+		return
+	}
+	if settings := inputRef.Input.Settings(); settings != nil {
+		runInfo.TimeLimit = time.Duration(settings.Limits.TimeLimit)
+	}
+}
+
 // AddRun adds a new RunContext to the current Queue.
 func (queue *Queue) AddRun(
 	ctx *common.Context,
 	runInfo *RunInfo,
 	inputRef *common.InputRef,
 ) error {
+	queue.pinSandboxProfile(runInfo)
+	pinToolchain(runInfo, inputRef)
+	recordTimeLimit(runInfo, inputRef)
 	runCtx := &RunContext{
 		RunInfo:  runInfo,
 		Context:  ctx.DebugContext(map[string]any{"id": runInfo.ID}),
 		inputRef: inputRef,
 
-		attemptsLeft: ctx.Config.Grader.MaxGradeRetries,
+		attemptsLeft: queue.attempts(ctx, runInfo),
 		queueManager: queue.queueManager,
 	}
+	if existing := queue.queueManager.activate(runCtx); existing != nil {
+		queue.coalesce(ctx, runCtx, existing)
+		return nil
+	}
 	runCtx.Context.Transaction = runCtx.Context.Tracing.StartTransaction(
 		"run",
 		tracing.Arg{Name: "id", Value: runInfo.ID},
 		tracing.Arg{Name: "submission", Value: runInfo.SubmissionID},
 		tracing.Arg{Name: "guid", Value: runInfo.GUID},
 	)
+	runCtx.recordTimeline(RunTimelineEnqueued, "", map[string]any{
+		"id":       runInfo.ID,
+		"queue":    queue.Name,
+		"priority": runInfo.Priority.Name(),
+	})
 
 	runCtx.queueManager.AddEvent(&QueueEvent{
 		Delta:    time.Now().Sub(runCtx.RunInfo.CreationTime),
@@ -602,24 +958,40 @@ func (queue *Queue) AddWaitableRun(
 	runInfo *RunInfo,
 	inputRef *common.InputRef,
 ) (*RunWaitHandle, error) {
+	queue.pinSandboxProfile(runInfo)
+	pinToolchain(runInfo, inputRef)
+	recordTimeLimit(runInfo, inputRef)
 	runCtx := &RunContext{
 		RunInfo:  runInfo,
 		Context:  ctx.DebugContext(map[string]any{"id": runInfo.ID}),
 		inputRef: inputRef,
 
-		attemptsLeft: ctx.Config.Grader.MaxGradeRetries,
+		attemptsLeft: queue.attempts(ctx, runInfo),
 		queueManager: queue.queueManager,
 		runWaitHandle: &RunWaitHandle{
 			running: make(chan struct{}),
 			ready:   make(chan struct{}),
 		},
 	}
+	if existing := queue.queueManager.activate(runCtx); existing != nil {
+		if existing.runWaitHandle == nil {
+			inputRef.Release()
+			return nil, errors.New("The run is already being graded")
+		}
+		queue.coalesce(ctx, runCtx, existing)
+		return existing.runWaitHandle, nil
+	}
 	runCtx.Context.Transaction = runCtx.Context.Tracing.StartTransaction(
 		"run",
 		tracing.Arg{Name: "id", Value: runInfo.ID},
 		tracing.Arg{Name: "submission", Value: runInfo.SubmissionID},
 		tracing.Arg{Name: "guid", Value: runInfo.GUID},
 	)
+	runCtx.recordTimeline(RunTimelineEnqueued, "", map[string]any{
+		"id":       runInfo.ID,
+		"queue":    queue.Name,
+		"priority": runInfo.Priority.Name(),
+	})
 
 	runCtx.queueManager.AddEvent(&QueueEvent{
 		Delta:    time.Now().Sub(runCtx.RunInfo.CreationTime),
@@ -635,6 +1007,27 @@ func (queue *Queue) AddWaitableRun(
 	return runCtx.runWaitHandle, nil
 }
 
+// coalesce discards runCtx, since the same run is already being graded by
+// existing. The results of existing will be reported for both.
+func (queue *Queue) coalesce(ctx *common.Context, runCtx, existing *RunContext) {
+	ctx.Log.Info(
+		"Run is already being graded, coalescing",
+		map[string]any{
+			"id":       runCtx.RunInfo.ID,
+			"guid":     runCtx.RunInfo.GUID,
+			"existing": existing.RunInfo.Run.AttemptID,
+		},
+	)
+	ctx.Metrics.CounterAdd("grader_runs_coalesced", 1)
+	existing.recordTimeline(RunTimelineCoalesced, "", map[string]any{
+		"id": runCtx.RunInfo.ID,
+	})
+	if runCtx.inputRef != nil {
+		runCtx.inputRef.Release()
+		runCtx.inputRef = nil
+	}
+}
+
 // enqueueBlocking adds a run to the queue, waits if needed.
 func (queue *Queue) enqueueBlocking(runCtx *RunContext) {
 	if runCtx == nil {
@@ -676,6 +1069,14 @@ type InflightRun struct {
 	connected    chan struct{}
 	ready        chan struct{}
 	timeout      chan struct{}
+	uploading    bool
+
+	// connectedRecorded is whether the runner connecting was already recorded
+	// in the timeline of the run.
+	connectedRecorded bool
+
+	// cases are the results of the cases that the runner has streamed so far.
+	cases []runner.CaseProgress
 }
 
 // InflightMonitor manages all in-flight Runs (Runs that have been picked up by
@@ -683,10 +1084,42 @@ type InflightRun struct {
 type InflightMonitor struct {
 	sync.Mutex
 	mapping        map[uint64]*InflightRun
+	logRequests    map[string][]*runnerLogRequest
 	connectTimeout time.Duration
 	readyTimeout   time.Duration
+
+	// finished contains the outcome of the most recent attempts that are no
+	// longer in flight, and finishedOrder the order in which they finished.
+	finished      map[uint64]attemptOutcome
+	finishedOrder []uint64
+}
+
+// attemptOutcome is the reason why an attempt is no longer in flight.
+type attemptOutcome int
+
+const (
+	// attemptSuperseded means that the attempt was replaced by a newer
+	// attempt of the same run, typically because it timed out.
+	attemptSuperseded attemptOutcome = iota
+	// attemptCompleted means that the results of the attempt were processed.
+	attemptCompleted
+)
+
+// runnerLogRequest is a request for the logs of an attempt that failed
+// before the runner was able to upload its results.
+type runnerLogRequest struct {
+	attemptID uint64
+	runCtx    *RunContext
 }
 
+// maxPendingLogRequests is the maximum number of log requests that will be
+// kept for any single runner. Older requests are discarded.
+const maxPendingLogRequests = 16
+
+// maxFinishedAttempts is the maximum number of finished attempt IDs that will
+// be remembered. Older ones are forgotten.
+const maxFinishedAttempts = 1024
+
 // RunData represents the data of a single run.
 type RunData struct {
 	AttemptID    uint64
@@ -697,14 +1130,17 @@ type RunData struct {
 	Runner       string
 	Time         int64
 	Elapsed      int64
+	CasesGraded  int
 }
 
 // NewInflightMonitor returns a new InflightMonitor.
 func NewInflightMonitor() *InflightMonitor {
 	return &InflightMonitor{
 		mapping:        make(map[uint64]*InflightRun),
+		logRequests:    make(map[string][]*runnerLogRequest),
 		connectTimeout: time.Duration(10) * time.Minute,
 		readyTimeout:   time.Duration(10) * time.Minute,
+		finished:       make(map[uint64]attemptOutcome),
 	}
 }
 
@@ -718,6 +1154,8 @@ func (monitor *InflightMonitor) Add(
 	if atomic.SwapInt32(&runCtx.runningFlag, 1) == 0 && runCtx.runWaitHandle != nil {
 		close(runCtx.runWaitHandle.running)
 	}
+	runCtx.RunInfo.Summary.QueueWait = time.Since(runCtx.RunInfo.CreationTime).Seconds()
+	runCtx.recordTimeline(RunTimelineDispatched, runner, nil)
 	monitor.Lock()
 	defer monitor.Unlock()
 	inflight := &InflightRun{
@@ -742,7 +1180,7 @@ func (monitor *InflightMonitor) Add(
 		select {
 		case <-inflight.connected:
 		case <-connectTimer.C:
-			monitor.timeout(runCtx, inflight.timeout)
+			monitor.timeout(runCtx, runner, inflight.timeout)
 			return
 		}
 
@@ -755,7 +1193,7 @@ func (monitor *InflightMonitor) Add(
 		select {
 		case <-inflight.ready:
 		case <-readyTimer.C:
-			monitor.timeout(runCtx, inflight.timeout)
+			monitor.timeout(runCtx, runner, inflight.timeout)
 			return
 		}
 	}()
@@ -764,6 +1202,7 @@ func (monitor *InflightMonitor) Add(
 
 func (monitor *InflightMonitor) timeout(
 	runCtx *RunContext,
+	runner string,
 	timeout chan<- struct{},
 ) {
 	runCtx.Log.Warn(
@@ -772,6 +1211,8 @@ func (monitor *InflightMonitor) timeout(
 			"context": runCtx,
 		},
 	)
+	runCtx.recordTimeline(RunTimelineTimedOut, runner, nil)
+	monitor.RequestLogs(runCtx, runner)
 	runCtx.Requeue(false)
 	timeout <- struct{}{}
 }
@@ -790,9 +1231,22 @@ func (monitor *InflightMonitor) Get(attemptID uint64) (*RunContext, <-chan struc
 	case inflight.connected <- struct{}{}:
 	default:
 	}
+	if !inflight.connectedRecorded {
+		inflight.connectedRecorded = true
+		inflight.runCtx.recordTimeline(RunTimelineConnected, inflight.runner, nil)
+	}
 	return inflight.runCtx, inflight.timeout, ok
 }
 
+// inflight returns whether the specified attempt ID is in flight. Unlike Get,
+// this does not signal that the runner has connected.
+func (monitor *InflightMonitor) inflight(attemptID uint64) bool {
+	monitor.Lock()
+	defer monitor.Unlock()
+	_, ok := monitor.mapping[attemptID]
+	return ok
+}
+
 // Remove removes the specified attempt ID from the in-flight runs and signals
 // the RunContext for completion.
 func (monitor *InflightMonitor) Remove(attemptID uint64) {
@@ -820,6 +1274,143 @@ func (monitor *InflightMonitor) Remove(attemptID uint64) {
 	delete(monitor.mapping, attemptID)
 }
 
+// finish records the outcome of an attempt that is no longer in flight, so
+// that results that are uploaded late for it can be told apart from results
+// for attempts that never existed.
+func (monitor *InflightMonitor) finish(attemptID uint64, outcome attemptOutcome) {
+	monitor.Lock()
+	defer monitor.Unlock()
+	if _, ok := monitor.finished[attemptID]; !ok {
+		monitor.finishedOrder = append(monitor.finishedOrder, attemptID)
+		if len(monitor.finishedOrder) > maxFinishedAttempts {
+			delete(monitor.finished, monitor.finishedOrder[0])
+			monitor.finishedOrder = monitor.finishedOrder[1:]
+		}
+	}
+	monitor.finished[attemptID] = outcome
+}
+
+// Superseded returns whether the attempt ID belongs to an attempt that was
+// replaced by a newer attempt of the same run, typically because it timed out.
+func (monitor *InflightMonitor) Superseded(attemptID uint64) bool {
+	monitor.Lock()
+	defer monitor.Unlock()
+	outcome, ok := monitor.finished[attemptID]
+	return ok && outcome == attemptSuperseded
+}
+
+// Completed returns whether the results of the attempt were already processed.
+func (monitor *InflightMonitor) Completed(attemptID uint64) bool {
+	monitor.Lock()
+	defer monitor.Unlock()
+	outcome, ok := monitor.finished[attemptID]
+	return ok && outcome == attemptCompleted
+}
+
+// ClaimUpload marks the in-flight attempt as having its results uploaded. It
+// returns false if the attempt is not in flight or another upload of its
+// results is already in progress. Processing an upload always ends with the
+// attempt being removed from the in-flight runs, so the claim is never
+// released.
+func (monitor *InflightMonitor) ClaimUpload(attemptID uint64) bool {
+	monitor.Lock()
+	defer monitor.Unlock()
+	inflight, ok := monitor.mapping[attemptID]
+	if !ok || inflight.uploading {
+		return false
+	}
+	inflight.uploading = true
+	return true
+}
+
+// AddCaseProgress records the result of a case that the runner streamed while
+// the attempt is still being graded. Results for attempts that are no longer
+// in flight are ignored.
+func (monitor *InflightMonitor) AddCaseProgress(attemptID uint64, progress *runner.CaseProgress) {
+	monitor.Lock()
+	defer monitor.Unlock()
+	inflight, ok := monitor.mapping[attemptID]
+	if !ok {
+		return
+	}
+	inflight.cases = append(inflight.cases, *progress)
+	inflight.runCtx.recordTimeline(RunTimelineProgress, inflight.runner, map[string]any{
+		"cases_graded": len(inflight.cases),
+		"group":        progress.Group,
+		"case":         progress.Case.Name,
+	})
+}
+
+// CaseProgress returns the results of the cases of the in-flight attempt of
+// the run with the specified ID that have been received so far, and whether
+// the run is in flight.
+func (monitor *InflightMonitor) CaseProgress(runID int64) ([]runner.CaseProgress, bool) {
+	monitor.Lock()
+	defer monitor.Unlock()
+	for _, inflight := range monitor.mapping {
+		if inflight.runCtx.RunInfo.ID != runID {
+			continue
+		}
+		cases := make([]runner.CaseProgress, len(inflight.cases))
+		copy(cases, inflight.cases)
+		return cases, true
+	}
+	return nil, false
+}
+
+// RequestLogs records that the logs for the current attempt of the
+// RunContext should be requested from the runner the next time it asks for a
+// run. This must be called before the RunContext is requeued, since that
+// changes its attempt ID.
+func (monitor *InflightMonitor) RequestLogs(runCtx *RunContext, runner string) {
+	monitor.Lock()
+	defer monitor.Unlock()
+	requests := append(monitor.logRequests[runner], &runnerLogRequest{
+		attemptID: runCtx.RunInfo.Run.AttemptID,
+		runCtx:    runCtx,
+	})
+	if len(requests) > maxPendingLogRequests {
+		requests = requests[len(requests)-maxPendingLogRequests:]
+	}
+	monitor.logRequests[runner] = requests
+}
+
+// PendingLogRequests returns the list of attempt IDs whose logs should be
+// requested from the runner.
+func (monitor *InflightMonitor) PendingLogRequests(runner string) []uint64 {
+	monitor.Lock()
+	defer monitor.Unlock()
+	attemptIDs := make([]uint64, 0, len(monitor.logRequests[runner]))
+	for _, request := range monitor.logRequests[runner] {
+		attemptIDs = append(attemptIDs, request.attemptID)
+	}
+	return attemptIDs
+}
+
+// TakeLogRequest removes the log request for the specified attempt ID that
+// was made to the runner and returns the RunContext that the logs belong to.
+func (monitor *InflightMonitor) TakeLogRequest(
+	runner string,
+	attemptID uint64,
+) (*RunContext, bool) {
+	monitor.Lock()
+	defer monitor.Unlock()
+	requests := monitor.logRequests[runner]
+	for i, request := range requests {
+		if request.attemptID != attemptID {
+			continue
+		}
+		requests = append(requests[:i], requests[i+1:]...)
+		if len(requests) == 0 {
+			delete(monitor.logRequests, runner)
+		} else {
+			monitor.logRequests[runner] = requests
+		}
+		return request.runCtx, true
+	}
+	return nil, false
+}
+
 // GetRunData returns the list of in-flight run information.
 func (monitor *InflightMonitor) GetRunData() []*RunData {
 	monitor.Lock()
@@ -838,6 +1429,7 @@ func (monitor *InflightMonitor) GetRunData() []*RunData {
 			Runner:       inflight.runner,
 			Time:         inflight.creationTime.Unix(),
 			Elapsed:      now.Sub(inflight.creationTime).Nanoseconds(),
+			CasesGraded:  len(inflight.cases),
 		}
 		idx++
 	}
@@ -936,12 +1528,46 @@ type queueEventListener struct {
 type QueueManager struct {
 	sync.Mutex
 	PostProcessor *RunPostProcessor
+	Timelines     *RunTimelines
 
 	mapping       map[string]*Queue
 	channelLength int
 	events        chan *QueueEvent
 	listenerChan  chan queueEventListener
 	listeners     []chan<- *QueueEvent
+
+	// activeRuns maps the IDs of the runs that have been added to a queue and
+	// have not been closed yet to their RunContexts.
+	activeRuns map[int64]*RunContext
+	// activeGUIDs maps the GUIDs of the active runs to their RunContexts, so
+	// that duplicate requests to grade a run can be coalesced.
+	activeGUIDs map[string]*RunContext
+	// nextDispatch maps the problems that have a dispatch rate to the earliest
+	// time at which another one of their runs can be handed to a runner.
+	nextDispatch map[string]time.Time
+}
+
+// QueueSnapshotEntry is a run in a QueueSnapshot.
+type QueueSnapshotEntry struct {
+	ID           int64         `json:"id"`
+	GUID         string        `json:"guid"`
+	Queue        string        `json:"queue"`
+	Priority     QueuePriority `json:"priority"`
+	AttemptsLeft int           `json:"attempts_left"`
+
+	// Running is whether a runner was grading the run when the snapshot was
+	// taken.
+	Running bool `json:"running"`
+
+	// QueueTime is when the run was last added to the queue.
+	QueueTime time.Time `json:"queue_time"`
+}
+
+// QueueSnapshot has all the runs that are being handled by a QueueManager, so
+// that they can be moved to another grader without waiting for it to find
+// them in the database.
+type QueueSnapshot struct {
+	Runs []QueueSnapshotEntry `json:"runs"`
 }
 
 // QueueInfo has information about one queue.
@@ -953,11 +1579,15 @@ type QueueInfo struct {
 func NewQueueManager(channelLength int, graderRuntimePath string) *QueueManager {
 	manager := &QueueManager{
 		PostProcessor: NewRunPostProcessor(),
+		Timelines:     NewRunTimelines(),
 		mapping:       make(map[string]*Queue),
 		channelLength: channelLength,
 		events:        make(chan *QueueEvent, 1),
 		listenerChan:  make(chan queueEventListener, 1),
 		listeners:     make([]chan<- *QueueEvent, 0),
+		activeRuns:    make(map[int64]*RunContext),
+		activeGUIDs:   make(map[string]*RunContext),
+		nextDispatch:  make(map[string]time.Time),
 	}
 	manager.Add(DefaultQueueName)
 	go manager.run()
@@ -994,6 +1624,68 @@ func (manager *QueueManager) Get(name string) (*Queue, error) {
 	return queue, nil
 }
 
+// IsActive returns whether the run with the specified ID has been added to a
+// queue and has not been closed yet.
+func (manager *QueueManager) IsActive(runID int64) bool {
+	manager.Lock()
+	defer manager.Unlock()
+	_, ok := manager.activeRuns[runID]
+	return ok
+}
+
+// activate records that the RunContext is being handled by the QueueManager.
+// If another RunContext with the same GUID is already active, nothing is
+// recorded and that RunContext is returned instead. This is the case even if
+// the runs have different IDs (e.g. a rejudge against another version of the
+// problem arrived while the previous one was being graded), since a GUID can
+// only be graded once at a time. Runs without a GUID (like ephemeral runs) are
+// never coalesced.
+func (manager *QueueManager) activate(runCtx *RunContext) *RunContext {
+	manager.Lock()
+	defer manager.Unlock()
+	if guid := runCtx.RunInfo.GUID; guid != "" {
+		if existing, ok := manager.activeGUIDs[guid]; ok {
+			return existing
+		}
+		manager.activeGUIDs[guid] = runCtx
+	}
+	if runCtx.RunInfo.ID != 0 {
+		manager.activeRuns[runCtx.RunInfo.ID] = runCtx
+	}
+	return nil
+}
+
+// reserveDispatch returns how long a run of the problem must wait before it
+// can be handed to a runner, given that the runs of the problem must be
+// dispatched at least interval apart. If the run does not need to wait, the
+// dispatch is recorded and 0 is returned.
+func (manager *QueueManager) reserveDispatch(
+	problem string,
+	interval time.Duration,
+	now time.Time,
+) time.Duration {
+	manager.Lock()
+	defer manager.Unlock()
+	if next, ok := manager.nextDispatch[problem]; ok && now.Before(next) {
+		return next.Sub(now)
+	}
+	manager.nextDispatch[problem] = now.Add(interval)
+	return 0
+}
+
+// deactivate records that the RunContext is no longer being handled by the
+// QueueManager.
+func (manager *QueueManager) deactivate(runCtx *RunContext) {
+	manager.Lock()
+	defer manager.Unlock()
+	if guid := runCtx.RunInfo.GUID; guid != "" && manager.activeGUIDs[guid] == runCtx {
+		delete(manager.activeGUIDs, guid)
+	}
+	if runCtx.RunInfo.ID != 0 {
+		delete(manager.activeRuns, runCtx.RunInfo.ID)
+	}
+}
+
 // GetQueueInfo returns the length of all the queues.
 func (manager *QueueManager) GetQueueInfo() map[string]QueueInfo {
 	manager.Lock()
@@ -1013,6 +1705,59 @@ func (manager *QueueManager) GetQueueInfo() map[string]QueueInfo {
 	return queues
 }
 
+// Snapshot returns all the runs that have been added to a queue and have not
+// been closed yet, sorted by ID. Runs without an ID (like ephemeral runs) are
+// not included, since they cannot be found by another grader.
+func (manager *QueueManager) Snapshot() *QueueSnapshot {
+	manager.Lock()
+	runCtxs := make([]*RunContext, 0, len(manager.activeRuns))
+	for _, runCtx := range manager.activeRuns {
+		runCtxs = append(runCtxs, runCtx)
+	}
+	manager.Unlock()
+
+	snapshot := &QueueSnapshot{
+		Runs: make([]QueueSnapshotEntry, 0, len(runCtxs)),
+	}
+	for _, runCtx := range runCtxs {
+		snapshot.Runs = append(snapshot.Runs, newQueueSnapshotEntry(runCtx))
+	}
+	sort.Slice(snapshot.Runs, func(i, j int) bool {
+		return snapshot.Runs[i].ID < snapshot.Runs[j].ID
+	})
+	return snapshot
+}
+
+// ActiveRun returns the state of the run with the provided GUID, if it has
+// been added to a queue and has not been closed yet.
+func (manager *QueueManager) ActiveRun(guid string) (*QueueSnapshotEntry, bool) {
+	manager.Lock()
+	runCtx, ok := manager.activeGUIDs[guid]
+	manager.Unlock()
+	if !ok {
+		return nil, false
+	}
+	entry := newQueueSnapshotEntry(runCtx)
+	return &entry, true
+}
+
+func newQueueSnapshotEntry(runCtx *RunContext) QueueSnapshotEntry {
+	entry := QueueSnapshotEntry{
+		ID:           runCtx.RunInfo.ID,
+		GUID:         runCtx.RunInfo.GUID,
+		Priority:     runCtx.RunInfo.Priority,
+		AttemptsLeft: runCtx.attemptsLeft,
+		QueueTime:    runCtx.RunInfo.QueueTime,
+	}
+	if runCtx.queue != nil {
+		entry.Queue = runCtx.queue.Name
+	}
+	if runCtx.monitor != nil {
+		entry.Running = runCtx.monitor.inflight(runCtx.RunInfo.Run.AttemptID)
+	}
+	return entry
+}
+
 // MarshalJSON returns a JSON representation of the queue lengths for reporting
 // purposes.
 func (manager *QueueManager) MarshalJSON() ([]byte, error) {
@@ -1,23 +1,27 @@
 package grader
 
 import (
+	"compress/gzip"
+	"io"
+
+	base "github.com/omegaup/go-base/v3"
 	"github.com/omegaup/quark/common"
+	"github.com/omegaup/quark/runner"
 	"math/big"
 	"os"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var (
 	runID int64 = 1
 )
 
-func addRun(
-	t *testing.T,
-	ctx *Context,
-	queue *Queue,
-	priority QueuePriority,
-) *RunInfo {
+func newAplusBInputRef(t *testing.T, ctx *Context) *common.InputRef {
+	t.Helper()
 	AplusB, err := common.NewLiteralInputFactory(
 		&common.LiteralInput{
 			Cases: map[string]*common.LiteralCaseSettings{
@@ -38,10 +42,19 @@ func addRun(
 	if err != nil {
 		t.Fatalf("Failed to get input back: %q", err)
 	}
+	return inputRef
+}
 
+func addRun(
+	t *testing.T,
+	ctx *Context,
+	queue *Queue,
+	priority QueuePriority,
+) *RunInfo {
+	inputRef := newAplusBInputRef(t, ctx)
 	originalLength := len(queue.runs[priority])
 
-	artifactManager := NewArtifactManager(nil)
+	artifactManager := NewArtifactManager(nil, nil)
 	runInfo := NewRunInfo()
 	runInfo.ID = atomic.AddInt64(&runID, 1)
 	runInfo.Priority = priority
@@ -181,6 +194,629 @@ func TestQueueRetry(t *testing.T) {
 	}
 }
 
+func TestQueueRetrySupersedesAttempt(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	closeNotifier := make(chan bool, 1)
+	addRun(t, ctx, queue, QueuePriorityNormal)
+	runCtx, _, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	originalAttemptID := runCtx.RunInfo.Run.AttemptID
+	if ctx.InflightMonitor.Superseded(originalAttemptID) {
+		t.Errorf("attempt %d superseded before being retried", originalAttemptID)
+	}
+	if !runCtx.Requeue(false) {
+		t.Fatalf("unable to retry run")
+	}
+	if !ctx.InflightMonitor.Superseded(originalAttemptID) {
+		t.Errorf("attempt %d not superseded after being retried", originalAttemptID)
+	}
+	if _, _, ok := ctx.InflightMonitor.Get(originalAttemptID); ok {
+		t.Errorf("attempt %d still in flight after being retried", originalAttemptID)
+	}
+
+	runCtx, _, _ = queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	if runCtx.RunInfo.Run.AttemptID == originalAttemptID {
+		t.Fatalf("retried run kept its attempt ID %d", originalAttemptID)
+	}
+	if ctx.InflightMonitor.Superseded(runCtx.RunInfo.Run.AttemptID) {
+		t.Errorf("current attempt %d marked as superseded", runCtx.RunInfo.Run.AttemptID)
+	}
+	ctx.InflightMonitor.Remove(runCtx.RunInfo.Run.AttemptID)
+	runCtx.Close()
+}
+
+func TestInflightMonitorClaimUpload(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	closeNotifier := make(chan bool, 1)
+	addRun(t, ctx, queue, QueuePriorityNormal)
+	runCtx, _, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	attemptID := runCtx.RunInfo.Run.AttemptID
+	if !ctx.InflightMonitor.ClaimUpload(attemptID) {
+		t.Fatalf("unable to claim the upload of attempt %d", attemptID)
+	}
+	if ctx.InflightMonitor.ClaimUpload(attemptID) {
+		t.Errorf("claimed the upload of attempt %d twice", attemptID)
+	}
+	if ctx.InflightMonitor.Completed(attemptID) {
+		t.Errorf("attempt %d completed before being closed", attemptID)
+	}
+
+	runCtx.Close()
+	if !ctx.InflightMonitor.Completed(attemptID) {
+		t.Errorf("attempt %d not completed after being closed", attemptID)
+	}
+	if ctx.InflightMonitor.Superseded(attemptID) {
+		t.Errorf("completed attempt %d marked as superseded", attemptID)
+	}
+	if ctx.InflightMonitor.ClaimUpload(attemptID) {
+		t.Errorf("claimed the upload of completed attempt %d", attemptID)
+	}
+}
+
+func TestInflightMonitorCaseProgress(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	closeNotifier := make(chan bool, 1)
+	runInfo := addRun(t, ctx, queue, QueuePriorityNormal)
+	if _, ok := ctx.InflightMonitor.CaseProgress(runInfo.ID); ok {
+		t.Errorf("queued run %d is reported as in flight", runInfo.ID)
+	}
+	runCtx, _, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	attemptID := runCtx.RunInfo.Run.AttemptID
+	for _, name := range []string{"0", "1"} {
+		ctx.InflightMonitor.AddCaseProgress(attemptID, &runner.CaseProgress{
+			Group: name,
+			Case:  runner.CaseResult{Name: name, Verdict: "OK"},
+		})
+	}
+	cases, ok := ctx.InflightMonitor.CaseProgress(runInfo.ID)
+	if !ok {
+		t.Fatalf("run %d is not in flight", runInfo.ID)
+	}
+	if len(cases) != 2 || cases[0].Case.Name != "0" || cases[1].Case.Name != "1" {
+		t.Errorf("CaseProgress(%d) == %+v, want cases 0 and 1", runInfo.ID, cases)
+	}
+	if runData := ctx.InflightMonitor.GetRunData(); len(runData) != 1 || runData[0].CasesGraded != 2 {
+		t.Errorf("GetRunData() == %+v, want one run with 2 cases graded", runData)
+	}
+
+	// The progress of an attempt is discarded once it is no longer in flight.
+	ctx.InflightMonitor.Remove(attemptID)
+	ctx.InflightMonitor.AddCaseProgress(attemptID, &runner.CaseProgress{Group: "2"})
+	if _, ok := ctx.InflightMonitor.CaseProgress(runInfo.ID); ok {
+		t.Errorf("run %d is still reported as in flight after being removed", runInfo.ID)
+	}
+}
+
+func TestQueueMaxGradeRetries(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+	ctx.Config.Grader.MaxGradeRetries = 3
+	ctx.Config.Grader.PriorityMaxGradeRetries = map[string]int{"low": 1}
+
+	defaultQueue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+	contestQueue := ctx.QueueManager.Add("contest")
+	contestQueue.MaxGradeRetries = 5
+
+	for _, tc := range []struct {
+		queue    *Queue
+		priority QueuePriority
+		expected int
+	}{
+		{defaultQueue, QueuePriorityNormal, 3},
+		{defaultQueue, QueuePriorityLow, 1},
+		{contestQueue, QueuePriorityNormal, 5},
+		{contestQueue, QueuePriorityLow, 5},
+	} {
+		if got := tc.queue.maxGradeRetries(&ctx.Context, tc.priority); got != tc.expected {
+			t.Errorf(
+				"%s.maxGradeRetries(%d) == %d, want %d",
+				tc.queue.Name,
+				tc.priority,
+				got,
+				tc.expected,
+			)
+		}
+	}
+}
+
+func TestQueueManagerSnapshot(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+	ctx.Config.Grader.MaxGradeRetries = 3
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	closeNotifier := make(chan bool, 1)
+	running := addRun(t, ctx, queue, QueuePriorityNormal)
+	queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+
+	// Imported runs keep the attempts they had left in the other grader.
+	inputRef := newAplusBInputRef(t, ctx)
+	queued := NewRunInfo()
+	queued.ID = atomic.AddInt64(&runID, 1)
+	queued.GUID = "queued"
+	queued.Priority = QueuePriorityLow
+	queued.MaxAttempts = 2
+	queued.Run.InputHash = inputRef.Input.Hash()
+	queued.Artifacts = NewArtifactManager(nil, nil).Grader(&ctx.Context, queued.ID)
+	if err := queue.AddRun(&ctx.Context, queued, inputRef); err != nil {
+		t.Fatalf("AddRun failed with %q", err)
+	}
+
+	// Runs without an ID cannot be exported.
+	ephemeral := NewRunInfo()
+	ephemeral.Priority = QueuePriorityEphemeral
+	ephemeral.Run.InputHash = inputRef.Input.Hash()
+	if err := queue.AddRun(&ctx.Context, ephemeral, newAplusBInputRef(t, ctx)); err != nil {
+		t.Fatalf("AddRun failed with %q", err)
+	}
+
+	expected := &QueueSnapshot{
+		Runs: []QueueSnapshotEntry{
+			{
+				ID:           running.ID,
+				Queue:        DefaultQueueName,
+				Priority:     QueuePriorityNormal,
+				AttemptsLeft: 3,
+				Running:      true,
+			},
+			{
+				ID:           queued.ID,
+				GUID:         "queued",
+				Queue:        DefaultQueueName,
+				Priority:     QueuePriorityLow,
+				AttemptsLeft: 2,
+			},
+		},
+	}
+	snapshot := ctx.QueueManager.Snapshot()
+	for i := range snapshot.Runs {
+		if snapshot.Runs[i].QueueTime.IsZero() {
+			t.Errorf("run %d has no queue time", snapshot.Runs[i].ID)
+		}
+		snapshot.Runs[i].QueueTime = time.Time{}
+	}
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Errorf("Snapshot() == %+v, want %+v", snapshot, expected)
+	}
+
+	if entry, ok := ctx.QueueManager.ActiveRun("queued"); !ok || entry.ID != queued.ID || entry.Running {
+		t.Errorf("ActiveRun(%q) == %+v, %v, want the queued run", "queued", entry, ok)
+	}
+	if _, ok := ctx.QueueManager.ActiveRun("missing"); ok {
+		t.Errorf("ActiveRun(%q) found a run", "missing")
+	}
+}
+
+func TestQueueRetryDelay(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+	ctx.Config.Grader.GradeRetryDelay = base.Duration(50 * time.Millisecond)
+	ctx.Config.Grader.GradeRetryJitter = base.Duration(50 * time.Millisecond)
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	closeNotifier := make(chan bool, 1)
+	addRun(t, ctx, queue, QueuePriorityNormal)
+	runCtx, _, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	retryTime := time.Now()
+	if !runCtx.Requeue(false) {
+		t.Fatalf("unable to retry run")
+	}
+	if len(queue.runs[QueuePriorityHigh]) != 0 {
+		t.Errorf("run was queued again before the retry delay")
+	}
+
+	runCtx, _, ok := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	if !ok {
+		t.Fatalf("retried run not found")
+	}
+	if elapsed := time.Since(retryTime); elapsed < 50*time.Millisecond {
+		t.Errorf("run was retried after %v, want at least %v", elapsed, 50*time.Millisecond)
+	}
+	ctx.InflightMonitor.Remove(runCtx.RunInfo.Run.AttemptID)
+	runCtx.Close()
+}
+
+func TestQueueSandboxProfile(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue := ctx.QueueManager.Add("untrusted")
+	queue.SandboxProfile = "strict"
+
+	runInfo := addRun(t, ctx, queue, QueuePriorityNormal)
+	if runInfo.Run.SandboxProfile != "strict" {
+		t.Errorf("run.SandboxProfile == %q, want %q", runInfo.Run.SandboxProfile, "strict")
+	}
+
+	defaultQueue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+	runInfo = addRun(t, ctx, defaultQueue, QueuePriorityNormal)
+	if runInfo.Run.SandboxProfile != "" {
+		t.Errorf("run.SandboxProfile == %q, want %q", runInfo.Run.SandboxProfile, "")
+	}
+}
+
+func TestQueueCoalesceDuplicateRuns(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	id := atomic.AddInt64(&runID, 1)
+	newDuplicateRunInfo := func(id int64) *RunInfo {
+		runInfo := NewRunInfo()
+		runInfo.ID = id
+		runInfo.GUID = "00000000000000000000000000000001"
+		runInfo.Run.Source = "print 3"
+		runInfo.Artifacts = NewArtifactManager(nil, nil).Grader(&ctx.Context, id)
+		return runInfo
+	}
+
+	for i := 0; i < 2; i++ {
+		inputRef := newAplusBInputRef(t, ctx)
+		runInfo := newDuplicateRunInfo(id)
+		runInfo.Run.InputHash = inputRef.Input.Hash()
+		if err := queue.AddRun(&ctx.Context, runInfo, inputRef); err != nil {
+			t.Fatalf("AddRun failed with %q", err)
+		}
+	}
+	if len(queue.runs[QueuePriorityNormal]) != 1 {
+		t.Fatalf("len(queue.runs) == %d, want 1", len(queue.runs[QueuePriorityNormal]))
+	}
+	if !ctx.QueueManager.IsActive(id) {
+		t.Errorf("run %d not active", id)
+	}
+
+	// The same submission graded against a different version is also a
+	// duplicate while the first one is active.
+	inputRef := newAplusBInputRef(t, ctx)
+	otherID := atomic.AddInt64(&runID, 1)
+	runInfo := newDuplicateRunInfo(otherID)
+	runInfo.Run.InputHash = inputRef.Input.Hash()
+	if err := queue.AddRun(&ctx.Context, runInfo, inputRef); err != nil {
+		t.Fatalf("AddRun failed with %q", err)
+	}
+	if len(queue.runs[QueuePriorityNormal]) != 1 {
+		t.Fatalf("len(queue.runs) == %d, want 1", len(queue.runs[QueuePriorityNormal]))
+	}
+	if ctx.QueueManager.IsActive(otherID) {
+		t.Errorf("coalesced run %d is active", otherID)
+	}
+
+	closeNotifier := make(chan bool, 1)
+	runCtx, _, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	runCtx.Close()
+	if ctx.QueueManager.IsActive(id) || ctx.QueueManager.IsActive(otherID) {
+		t.Errorf("runs still active after being closed")
+	}
+	if _, ok := ctx.QueueManager.ActiveRun(runInfo.GUID); ok {
+		t.Errorf("GUID still active after the run was closed")
+	}
+}
+
+func TestQueueCoalesceSameGUIDRace(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	// Runs with the same GUID and different IDs are added concurrently. Only
+	// one of them must be graded, and the GUID must stop being active once it
+	// is closed.
+	const guid = "00000000000000000000000000000002"
+	const runCount = 8
+	ids := make([]int64, runCount)
+	var wg sync.WaitGroup
+	for i := range ids {
+		ids[i] = atomic.AddInt64(&runID, 1)
+		inputRef := newAplusBInputRef(t, ctx)
+		runInfo := NewRunInfo()
+		runInfo.ID = ids[i]
+		runInfo.GUID = guid
+		runInfo.Run.Source = "print 3"
+		runInfo.Run.InputHash = inputRef.Input.Hash()
+		runInfo.Artifacts = NewArtifactManager(nil, nil).Grader(&ctx.Context, ids[i])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := queue.AddRun(&ctx.Context, runInfo, inputRef); err != nil {
+				t.Errorf("AddRun failed with %q", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(queue.runs[QueuePriorityNormal]) != 1 {
+		t.Fatalf("len(queue.runs) == %d, want 1", len(queue.runs[QueuePriorityNormal]))
+	}
+	activeCount := 0
+	for _, id := range ids {
+		if ctx.QueueManager.IsActive(id) {
+			activeCount++
+		}
+	}
+	if activeCount != 1 {
+		t.Errorf("%d runs active, want 1", activeCount)
+	}
+
+	closeNotifier := make(chan bool, 1)
+	runCtx, _, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	runCtx.Close()
+	if _, ok := ctx.QueueManager.ActiveRun(guid); ok {
+		t.Errorf("GUID still active after the run was closed")
+	}
+	for _, id := range ids {
+		if ctx.QueueManager.IsActive(id) {
+			t.Errorf("run %d still active after being closed", id)
+		}
+	}
+}
+
+func TestQueueReroute(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	inputRef := newAplusBInputRef(t, ctx)
+	inputRef.Input.Settings().Toolchains = map[string]string{"cpp17-gcc": "g++-10"}
+	runInfo := NewRunInfo()
+	runInfo.ID = atomic.AddInt64(&runID, 1)
+	runInfo.Run.InputHash = inputRef.Input.Hash()
+	runInfo.Run.Language = "cpp17-gcc"
+	runInfo.Run.Source = "int main() {}"
+	runInfo.Artifacts = NewArtifactManager(nil, nil).Grader(&ctx.Context, runInfo.ID)
+	if err := queue.AddRun(&ctx.Context, runInfo, inputRef); err != nil {
+		t.Fatalf("AddRun failed with %q", err)
+	}
+	if runInfo.Run.Toolchain != "g++-10" {
+		t.Errorf("run.Toolchain == %q, want %q", runInfo.Run.Toolchain, "g++-10")
+	}
+
+	closeNotifier := make(chan bool, 1)
+	runCtx, _, _ := queue.GetRun("runner-1", ctx.InflightMonitor, closeNotifier)
+	attemptsLeft := runCtx.attemptsLeft
+	originalAttemptID := runCtx.RunInfo.Run.AttemptID
+	if runCtx.IncompatibleWith("runner-1") {
+		t.Errorf("run incompatible with runner-1 before being rerouted")
+	}
+	if !runCtx.Reroute("runner-1") {
+		t.Fatalf("unable to reroute run")
+	}
+	if !runCtx.IncompatibleWith("runner-1") {
+		t.Errorf("run not incompatible with runner-1 after being rerouted")
+	}
+	if runCtx.IncompatibleWith("runner-2") {
+		t.Errorf("run incompatible with runner-2")
+	}
+	if !ctx.InflightMonitor.Superseded(originalAttemptID) {
+		t.Errorf("attempt %d not superseded after being rerouted", originalAttemptID)
+	}
+
+	runCtx, _, _ = queue.GetRun("runner-2", ctx.InflightMonitor, closeNotifier)
+	if runCtx.attemptsLeft != attemptsLeft {
+		t.Errorf("runCtx.attemptsLeft == %d, want %d", runCtx.attemptsLeft, attemptsLeft)
+	}
+	ctx.InflightMonitor.Remove(runCtx.RunInfo.Run.AttemptID)
+	runCtx.Close()
+}
+
+func TestQueueProblemDispatchRate(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+	// One run every 100ms.
+	ctx.Config.Grader.ProblemDispatchRates = map[string]float64{"slow": 600}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	slow1 := addRun(t, ctx, queue, QueuePriorityNormal)
+	slow1.Run.ProblemName = "slow"
+	slow2 := addRun(t, ctx, queue, QueuePriorityNormal)
+	slow2.Run.ProblemName = "slow"
+	fast := addRun(t, ctx, queue, QueuePriorityNormal)
+	fast.Run.ProblemName = "fast"
+
+	closeNotifier := make(chan bool, 1)
+	start := time.Now()
+	for i, expected := range []*RunInfo{slow1, fast, slow2} {
+		runCtx, _, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+		if expected != runCtx.RunInfo {
+			t.Fatalf("run %d: expected runCtx.RunInfo == %v, got %v", i, expected, runCtx.RunInfo)
+		}
+		ctx.InflightMonitor.Remove(runCtx.RunInfo.Run.AttemptID)
+		runCtx.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("second run of the problem dispatched after %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestQueueTimeoutRequestsLogs(t *testing.T) {
+	ctx, err := newGraderContext(t)
+	if err != nil {
+		t.Fatalf("GraderContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Grader.RuntimePath)
+	}
+
+	queue, err := ctx.QueueManager.Get(DefaultQueueName)
+	if err != nil {
+		t.Fatalf("default queue not found")
+	}
+
+	addRun(t, ctx, queue, QueuePriorityNormal)
+
+	closeNotifier := make(chan bool, 1)
+	ctx.InflightMonitor.connectTimeout = 0
+	runCtx, timeout, _ := queue.GetRun("test", ctx.InflightMonitor, closeNotifier)
+	attemptID := runCtx.RunInfo.Run.AttemptID
+	if _, didTimeout := <-timeout; !didTimeout {
+		t.Fatalf("expected timeout but did not happen")
+	}
+
+	if pending := ctx.InflightMonitor.PendingLogRequests("other"); len(pending) != 0 {
+		t.Errorf("PendingLogRequests(\"other\") == %v, want []", pending)
+	}
+	pending := ctx.InflightMonitor.PendingLogRequests("test")
+	if len(pending) != 1 || pending[0] != attemptID {
+		t.Fatalf("PendingLogRequests(\"test\") == %v, want [%d]", pending, attemptID)
+	}
+	if attemptID == runCtx.RunInfo.Run.AttemptID {
+		t.Errorf("attempt ID was not updated after the run was requeued")
+	}
+
+	if _, ok := ctx.InflightMonitor.TakeLogRequest("other", attemptID); ok {
+		t.Errorf("TakeLogRequest succeeded for the wrong runner")
+	}
+	requestedRunCtx, ok := ctx.InflightMonitor.TakeLogRequest("test", attemptID)
+	if !ok {
+		t.Fatalf("TakeLogRequest failed")
+	}
+	if requestedRunCtx != runCtx {
+		t.Errorf("TakeLogRequest returned %v, want %v", requestedRunCtx, runCtx)
+	}
+	if _, ok := ctx.InflightMonitor.TakeLogRequest("test", attemptID); ok {
+		t.Errorf("TakeLogRequest succeeded twice for the same request")
+	}
+}
+
+func TestCompressedRunLogs(t *testing.T) {
+	for _, tc := range []struct {
+		maxSize  base.Byte
+		expected string
+	}{
+		{0, "first line\nlast line\n"},
+		{base.Byte(10), "last line\n"},
+	} {
+		logs := compressedRunLogs([]byte("first line\nlast line\n"), tc.maxSize)
+		gz, err := gzip.NewReader(logs)
+		if err != nil {
+			t.Fatalf("Failed to open the compressed logs: %v", err)
+		}
+		contents, err := io.ReadAll(gz)
+		if err != nil {
+			t.Fatalf("Failed to read the compressed logs: %v", err)
+		}
+		logs.Close()
+		if string(contents) != tc.expected {
+			t.Errorf("compressedRunLogs(maxSize=%d) == %q, want %q", tc.maxSize, contents, tc.expected)
+		}
+	}
+}
+
 func TestQueuePriorities(t *testing.T) {
 	ctx, err := newGraderContext(t)
 	if err != nil {
@@ -0,0 +1,108 @@
+package grader
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/common"
+)
+
+// slowProblemHysteresis is the fraction of the threshold that the percentile
+// needs to go below for a problem that was considered slow to stop being
+// considered slow. This avoids problems flip-flopping between the two states.
+const slowProblemHysteresis = 0.8
+
+type problemRuntimes struct {
+	samples    []time.Duration
+	next       int
+	percentile time.Duration
+	slow       bool
+}
+
+// SlowProblemDetector keeps track of the observed grading times of each
+// problem and decides whether they should be treated as slow, instead of
+// relying exclusively on problemsetters marking them as such.
+type SlowProblemDetector struct {
+	sync.Mutex
+	config   *common.GraderSlowDetectionConfig
+	problems map[string]*problemRuntimes
+}
+
+// NewSlowProblemDetector returns a new SlowProblemDetector.
+func NewSlowProblemDetector(config *common.GraderSlowDetectionConfig) *SlowProblemDetector {
+	return &SlowProblemDetector{
+		config:   config,
+		problems: make(map[string]*problemRuntimes),
+	}
+}
+
+// Observe records the time it took to grade a run of the specified problem.
+func (d *SlowProblemDetector) Observe(problemName string, duration time.Duration) {
+	if !d.config.Enabled || d.config.WindowSize <= 0 {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	runtimes, ok := d.problems[problemName]
+	if !ok {
+		runtimes = &problemRuntimes{
+			samples: make([]time.Duration, 0, d.config.WindowSize),
+		}
+		d.problems[problemName] = runtimes
+	}
+	if len(runtimes.samples) < d.config.WindowSize {
+		runtimes.samples = append(runtimes.samples, duration)
+	} else {
+		runtimes.samples[runtimes.next] = duration
+		runtimes.next = (runtimes.next + 1) % len(runtimes.samples)
+	}
+	if len(runtimes.samples) < d.config.MinSamples {
+		return
+	}
+
+	runtimes.percentile = runtimes.computePercentile(d.config.Percentile)
+	runtimes.slow = d.isSlow(runtimes.percentile, runtimes.slow)
+}
+
+// IsSlow returns whether the problem should be considered slow. If there are
+// not enough observations for the problem, the value that was configured by
+// the problemsetter is returned. Otherwise the observations decide, so a
+// problem that the problemsetter marked as slow stops being slow once its
+// grading times go below the hysteresis window.
+func (d *SlowProblemDetector) IsSlow(problemName string, configuredSlow bool) bool {
+	if !d.config.Enabled {
+		return configuredSlow
+	}
+	d.Lock()
+	defer d.Unlock()
+	runtimes, ok := d.problems[problemName]
+	if !ok || len(runtimes.samples) < d.config.MinSamples {
+		return configuredSlow
+	}
+	return d.isSlow(runtimes.percentile, runtimes.slow || configuredSlow)
+}
+
+// isSlow returns whether a problem with the specified grading time percentile
+// is slow, given whether it was slow before.
+func (d *SlowProblemDetector) isSlow(percentile time.Duration, wasSlow bool) bool {
+	threshold := time.Duration(d.config.Threshold)
+	if wasSlow {
+		return float64(percentile) >= slowProblemHysteresis*float64(threshold)
+	}
+	return percentile >= threshold
+}
+
+func (r *problemRuntimes) computePercentile(p float64) time.Duration {
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
@@ -0,0 +1,80 @@
+package grader
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+)
+
+func TestSlowProblemDetector(t *testing.T) {
+	detector := NewSlowProblemDetector(&common.GraderSlowDetectionConfig{
+		Enabled:    true,
+		WindowSize: 4,
+		MinSamples: 2,
+		Percentile: 0.5,
+		Threshold:  base.Duration(10 * time.Second),
+	})
+
+	if detector.IsSlow("sumas", true) != true {
+		t.Errorf("expected the configured value to be used with no samples")
+	}
+	detector.Observe("sumas", 1*time.Second)
+	if detector.IsSlow("sumas", true) != true {
+		t.Errorf("expected the configured value to be used with too few samples")
+	}
+	detector.Observe("sumas", 2*time.Second)
+	if detector.IsSlow("sumas", false) != false {
+		t.Errorf("expected fast problem to not be slow")
+	}
+	if detector.IsSlow("sumas", true) != false {
+		t.Errorf("expected the problemsetter's setting to be cleared for a fast problem")
+	}
+
+	for i := 0; i < 4; i++ {
+		detector.Observe("sumas", 20*time.Second)
+	}
+	if detector.IsSlow("sumas", false) != true {
+		t.Errorf("expected slow problem to be slow")
+	}
+
+	// Within the hysteresis window, the problem is still slow.
+	for i := 0; i < 4; i++ {
+		detector.Observe("sumas", 9*time.Second)
+	}
+	if detector.IsSlow("sumas", false) != true {
+		t.Errorf("expected problem within the hysteresis window to still be slow")
+	}
+	if detector.IsSlow("sumas", true) != true {
+		t.Errorf("expected problem marked as slow within the hysteresis window to still be slow")
+	}
+
+	for i := 0; i < 4; i++ {
+		detector.Observe("sumas", 1*time.Second)
+	}
+	if detector.IsSlow("sumas", false) != false {
+		t.Errorf("expected problem to stop being slow")
+	}
+	if detector.IsSlow("sumas", true) != false {
+		t.Errorf("expected problem marked as slow to stop being slow")
+	}
+
+	if detector.IsSlow("restas", false) != false {
+		t.Errorf("expected unrelated problem to use the configured value")
+	}
+}
+
+func TestSlowProblemDetectorDisabled(t *testing.T) {
+	detector := NewSlowProblemDetector(&common.GraderSlowDetectionConfig{
+		Enabled:    false,
+		WindowSize: 4,
+		MinSamples: 1,
+		Percentile: 0.5,
+		Threshold:  base.Duration(10 * time.Second),
+	})
+	detector.Observe("sumas", 20*time.Second)
+	if detector.IsSlow("sumas", false) != false {
+		t.Errorf("expected the configured value to be used when disabled")
+	}
+}
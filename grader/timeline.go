@@ -0,0 +1,139 @@
+package grader
+
+import (
+	"sync"
+	"time"
+)
+
+// RunTimelineEventType is the type of a RunTimelineEvent.
+type RunTimelineEventType string
+
+const (
+	// RunTimelineEnqueued is recorded when the run is added to a queue.
+	RunTimelineEnqueued RunTimelineEventType = "enqueued"
+	// RunTimelineCoalesced is recorded when another request to grade the run
+	// arrives while it is still being graded.
+	RunTimelineCoalesced RunTimelineEventType = "coalesced"
+	// RunTimelineThrottled is recorded when the run is held back because its
+	// problem exceeded its dispatch rate.
+	RunTimelineThrottled RunTimelineEventType = "throttled"
+	// RunTimelineDispatched is recorded when a runner picks the run up.
+	RunTimelineDispatched RunTimelineEventType = "dispatched"
+	// RunTimelineConnected is recorded when the runner first reports back
+	// about an attempt.
+	RunTimelineConnected RunTimelineEventType = "connected"
+	// RunTimelineProgress is recorded as the runner streams the results of
+	// the cases. Consecutive progress of the same attempt is merged into a
+	// single event.
+	RunTimelineProgress RunTimelineEventType = "progress"
+	// RunTimelineTimedOut is recorded when the runner takes too long to
+	// report back about an attempt.
+	RunTimelineTimedOut RunTimelineEventType = "timed_out"
+	// RunTimelineRequeued is recorded when a failed attempt is retried.
+	RunTimelineRequeued RunTimelineEventType = "requeued"
+	// RunTimelineRerouted is recorded when a runner is not able to grade the
+	// run and it is given to another one.
+	RunTimelineRerouted RunTimelineEventType = "rerouted"
+	// RunTimelineAbandoned is recorded when the grader gives up on the run.
+	RunTimelineAbandoned RunTimelineEventType = "abandoned"
+	// RunTimelineFinished is recorded when the grader is done with the run.
+	RunTimelineFinished RunTimelineEventType = "finished"
+	// RunTimelineDBUpdated is recorded when the results are written to the
+	// database.
+	RunTimelineDBUpdated RunTimelineEventType = "db_updated"
+	// RunTimelineBroadcast is recorded when the results are broadcast.
+	RunTimelineBroadcast RunTimelineEventType = "broadcast"
+)
+
+// maxRunTimelines is the maximum number of runs whose timeline is kept. The
+// timelines of the oldest runs are discarded first.
+const maxRunTimelines = 4096
+
+// maxRunTimelineEvents is the maximum number of events that are kept in a
+// single timeline. Once it is reached, the oldest events after the first one
+// are discarded.
+const maxRunTimelineEvents = 256
+
+// A RunTimelineEvent is something that happened to a run while the grader
+// was handling it.
+type RunTimelineEvent struct {
+	Time      time.Time            `json:"time"`
+	Type      RunTimelineEventType `json:"type"`
+	AttemptID uint64               `json:"attempt_id,omitempty"`
+	Runner    string               `json:"runner,omitempty"`
+	Details   map[string]any       `json:"details,omitempty"`
+}
+
+// A RunTimeline is everything that happened to a run, in order.
+type RunTimeline struct {
+	GUID   string             `json:"guid"`
+	Events []RunTimelineEvent `json:"events"`
+
+	// Truncated is whether some events were discarded because the timeline
+	// was too long.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// RunTimelines keeps the timelines of the most recent runs, indexed by their
+// GUID, so that it is possible to tell where the time of a run was spent.
+type RunTimelines struct {
+	sync.Mutex
+	timelines map[string]*RunTimeline
+	order     []string
+}
+
+// NewRunTimelines returns an empty RunTimelines.
+func NewRunTimelines() *RunTimelines {
+	return &RunTimelines{
+		timelines: make(map[string]*RunTimeline),
+	}
+}
+
+// Record adds an event to the timeline of the run with the provided GUID. If
+// the event has no time, the current time is used. Runs without a GUID (like
+// ephemeral runs) have no timeline.
+func (t *RunTimelines) Record(guid string, event RunTimelineEvent) {
+	if guid == "" {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	t.Lock()
+	defer t.Unlock()
+	timeline, ok := t.timelines[guid]
+	if !ok {
+		timeline = &RunTimeline{GUID: guid}
+		t.timelines[guid] = timeline
+		t.order = append(t.order, guid)
+		if len(t.order) > maxRunTimelines {
+			delete(t.timelines, t.order[0])
+			t.order = t.order[1:]
+		}
+	}
+	if event.Type == RunTimelineProgress && len(timeline.Events) > 0 {
+		last := &timeline.Events[len(timeline.Events)-1]
+		if last.Type == RunTimelineProgress && last.AttemptID == event.AttemptID {
+			*last = event
+			return
+		}
+	}
+	if len(timeline.Events) >= maxRunTimelineEvents {
+		timeline.Events = append(timeline.Events[:1], timeline.Events[2:]...)
+		timeline.Truncated = true
+	}
+	timeline.Events = append(timeline.Events, event)
+}
+
+// Get returns a copy of the timeline of the run with the provided GUID.
+func (t *RunTimelines) Get(guid string) (*RunTimeline, bool) {
+	t.Lock()
+	defer t.Unlock()
+	timeline, ok := t.timelines[guid]
+	if !ok {
+		return nil, false
+	}
+	result := *timeline
+	result.Events = append([]RunTimelineEvent(nil), timeline.Events...)
+	return &result, true
+}
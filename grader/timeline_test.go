@@ -0,0 +1,76 @@
+package grader
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRunTimelines(t *testing.T) {
+	timelines := NewRunTimelines()
+	timelines.Record("", RunTimelineEvent{Type: RunTimelineEnqueued})
+	if _, ok := timelines.Get(""); ok {
+		t.Errorf("runs without a GUID have a timeline")
+	}
+
+	for _, event := range []RunTimelineEvent{
+		{Type: RunTimelineEnqueued},
+		{Type: RunTimelineDispatched, AttemptID: 1, Runner: "runner"},
+		{Type: RunTimelineProgress, AttemptID: 1, Details: map[string]any{"cases_graded": 1}},
+		{Type: RunTimelineProgress, AttemptID: 1, Details: map[string]any{"cases_graded": 2}},
+		{Type: RunTimelineFinished, AttemptID: 1},
+	} {
+		timelines.Record("guid", event)
+	}
+	timeline, ok := timelines.Get("guid")
+	if !ok {
+		t.Fatalf("timeline of %q not found", "guid")
+	}
+	var types []RunTimelineEventType
+	for _, event := range timeline.Events {
+		if event.Time.IsZero() {
+			t.Errorf("event %+v has no time", event)
+		}
+		types = append(types, event.Type)
+	}
+	expected := []RunTimelineEventType{
+		RunTimelineEnqueued,
+		RunTimelineDispatched,
+		RunTimelineProgress,
+		RunTimelineFinished,
+	}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Errorf("timeline events == %v, want %v", types, expected)
+	}
+	if casesGraded := timeline.Events[2].Details["cases_graded"]; casesGraded != 2 {
+		t.Errorf("merged progress cases_graded == %v, want 2", casesGraded)
+	}
+
+	// Long timelines keep the first event and the most recent ones.
+	for i := 0; i < maxRunTimelineEvents; i++ {
+		timelines.Record("guid", RunTimelineEvent{Type: RunTimelineRequeued, AttemptID: uint64(i + 2)})
+	}
+	timeline, _ = timelines.Get("guid")
+	if len(timeline.Events) != maxRunTimelineEvents || !timeline.Truncated {
+		t.Errorf(
+			"len(timeline.Events) == %d, truncated %v, want %d, true",
+			len(timeline.Events),
+			timeline.Truncated,
+			maxRunTimelineEvents,
+		)
+	}
+	if first, last := timeline.Events[0], timeline.Events[len(timeline.Events)-1]; first.Type != RunTimelineEnqueued ||
+		last.AttemptID != uint64(maxRunTimelineEvents+1) {
+		t.Errorf("timeline events == %v ... %v, want enqueued ... attempt %d", first, last, maxRunTimelineEvents+1)
+	}
+
+	// The timelines of the oldest runs are discarded first.
+	for i := 0; i < maxRunTimelines; i++ {
+		timelines.Record(fmt.Sprintf("guid%d", i), RunTimelineEvent{Type: RunTimelineEnqueued})
+	}
+	if _, ok := timelines.Get("guid"); ok {
+		t.Errorf("the oldest timeline was not discarded")
+	}
+	if _, ok := timelines.Get("guid0"); !ok {
+		t.Errorf("timeline of %q not found", "guid0")
+	}
+}
@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/omegaup/quark/common"
+)
+
+// xzMemoryLimit is the most memory that xz can use to decompress an
+// output-only archive. It is enough for any of the xz presets, so that only
+// archives crafted to exhaust the memory of the runner are rejected.
+const xzMemoryLimit = "128MiB"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// archiveEntryFunc is invoked for every regular file in an output-only
+// archive. size is the size that the archive claims the file has, which
+// cannot be trusted.
+type archiveEntryFunc func(name string, size uint64, open func() (io.ReadCloser, error)) error
+
+// walkOutputOnlyArchive detects the format of the archive in f (a zip, a
+// gzip-compressed tarball or an xz-compressed tarball) and calls fn for each
+// of its regular files, in order. The decompression of xz-compressed tarballs
+// is stopped if ctx is cancelled.
+func walkOutputOnlyArchive(ctx context.Context, f *os.File, fn archiveEntryFunc) error {
+	header := make([]byte, len(xzMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	header = header[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return fmt.Errorf("invalid output-only tar.gz file: %w", err)
+		}
+		defer gz.Close()
+		return walkTar(gz, "tar.gz", fn)
+	case bytes.HasPrefix(header, xzMagic):
+		return walkTarXz(ctx, f, fn)
+	default:
+		return walkZip(f, fn)
+	}
+}
+
+func walkZip(f *os.File, fn archiveEntryFunc) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	z, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("invalid output-only zip file: %w", err)
+	}
+	for _, zf := range z.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		if err := fn(zf.Name, zf.UncompressedSize64, zf.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, format string, fn archiveEntryFunc) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid output-only %s file: %w", format, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		size := uint64(0)
+		if hdr.Size > 0 {
+			size = uint64(hdr.Size)
+		}
+		if err := fn(hdr.Name, size, func() (io.ReadCloser, error) {
+			return io.NopCloser(tr), nil
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// walkTarXz decompresses the xz-compressed tarball in f with the xz binary,
+// since the standard library has no xz support.
+func walkTarXz(ctx context.Context, f *os.File, fn archiveEntryFunc) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(
+		ctx,
+		"xz",
+		"--decompress",
+		"--stdout",
+		"--memlimit-decompress="+xzMemoryLimit,
+	)
+	cmd.Stdin = f
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to decompress output-only txz file: %w", err)
+	}
+	walkErr := walkTar(stdout, "txz", fn)
+	if walkErr != nil {
+		cmd.Process.Kill()
+	} else {
+		// Drain whatever is left so that xz is not blocked writing to the pipe.
+		io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil && walkErr == nil {
+		return fmt.Errorf("invalid output-only txz file: %w: %s", err, stderr.String())
+	}
+	return walkErr
+}
+
+// OutputOnlyArtifactPath returns the path where the uploaded output-only
+// archive with the provided ID is stored while the attempt that references it
+// is being graded. The path is keyed by the attempt too, since several
+// attempts of the same run can be graded at the same time.
+func OutputOnlyArtifactPath(ctx *common.Context, attemptID uint64, id string) string {
+	return path.Join(
+		ctx.Config.Runner.RuntimePath,
+		"artifact",
+		fmt.Sprintf("%d-%s", attemptID, id),
+	)
+}
+
+// FetchOutputOnlyArtifact downloads the uploaded output-only archive with the
+// provided ID from the grader into OutputOnlyArtifactPath. A missing artifact
+// is not an error: the run will get a CE verdict when graded.
+func FetchOutputOnlyArtifact(
+	ctx *common.Context,
+	client *http.Client,
+	baseURL *url.URL,
+	attemptID uint64,
+	id string,
+) error {
+	requestURL, err := baseURL.Parse(fmt.Sprintf("artifact/%s/", id))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx.Context, "GET", requestURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch artifact %s: HTTP %d", id, resp.StatusCode)
+	}
+
+	artifactPath := OutputOnlyArtifactPath(ctx, attemptID, id)
+	if err := os.MkdirAll(path.Dir(artifactPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(artifactPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(artifactPath)
+		return fmt.Errorf("failed to fetch artifact %s: %w", id, err)
+	}
+	return f.Close()
+}
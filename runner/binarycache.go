@@ -0,0 +1,373 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+)
+
+// A BinaryCache stores the compiled binaries of contestant programs, so that
+// runs of the same submission don't need to compile it again.
+type BinaryCache interface {
+	// Get extracts the binary with the provided key into dst. It returns false
+	// if the binary is not in the cache.
+	Get(ctx *common.Context, key, dst string) (bool, error)
+
+	// Put stores the contents of the src directory as the binary with the
+	// provided key.
+	Put(ctx *common.Context, key, src string) error
+}
+
+// BinaryCacheKey returns the key under which the compiled binary of the run is
+// stored. Binaries are only shared between runners with the same fingerprint
+// (see ToolchainFingerprint). The key covers everything that determines the
+// compilation: the language, toolchain and sandbox profile, the source, and
+// the compiler flags (which only depend on whether it is a debug run). Runs
+// that cannot be cached get an empty key.
+func BinaryCacheKey(run *common.Run, fingerprint string) string {
+	if fingerprint == "" || run.Language == "cat" {
+		return ""
+	}
+	hasher := sha1.New()
+	fmt.Fprintf(
+		hasher,
+		"%s\x00%s\x00%s\x00%s\x00%t\x00",
+		fingerprint,
+		run.Language,
+		run.Toolchain,
+		run.SandboxProfile,
+		run.Debug,
+	)
+	io.WriteString(hasher, run.Source)
+	return fmt.Sprintf("%0x", hasher.Sum(nil))
+}
+
+// writeBinaryArchive writes the contents of the src directory as a
+// gzip-compressed tarball.
+func writeBinaryArchive(w io.Writer, src string) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	err := filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == src {
+			return nil
+		}
+		name, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			// Pipes and other special files are recreated for every run.
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err := archive.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(archive, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractBinaryArchive extracts a tarball written by writeBinaryArchive into
+// the dst directory.
+func extractBinaryArchive(r io.Reader, dst string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isUnsafeArchivePath(hdr.Name) {
+			return fmt.Errorf("invalid path %q in binary archive", hdr.Name)
+		}
+		filePath := path.Join(dst, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(filePath)
+			if err := os.Symlink(hdr.Linkname, filePath); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, archive)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type graderBinaryCache struct {
+	client  *http.Client
+	baseURL *url.URL
+}
+
+// NewGraderBinaryCache returns a BinaryCache that stores the binaries in the
+// grader, so that they are shared with all the other runners.
+func NewGraderBinaryCache(client *http.Client, baseURL *url.URL) BinaryCache {
+	return &graderBinaryCache{
+		client:  client,
+		baseURL: baseURL,
+	}
+}
+
+func (c *graderBinaryCache) Get(ctx *common.Context, key, dst string) (bool, error) {
+	requestURL, err := c.baseURL.Parse(fmt.Sprintf("binary/%s/", key))
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx.Context, "GET", requestURL.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to fetch binary %s: HTTP %d", key, resp.StatusCode)
+	}
+	if err := extractBinaryArchive(resp.Body, dst); err != nil {
+		return false, fmt.Errorf("failed to fetch binary %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (c *graderBinaryCache) Put(ctx *common.Context, key, src string) error {
+	requestURL, err := c.baseURL.Parse(fmt.Sprintf("binary/%s/", key))
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBinaryArchive(pw, src))
+	}()
+	defer pr.Close()
+	req, err := http.NewRequestWithContext(ctx.Context, "POST", requestURL.String(), pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to store binary %s: HTTP %d", key, resp.StatusCode)
+	}
+	return nil
+}
+
+type localBinaryCache struct {
+	sync.Mutex
+	root    string
+	maxSize base.Byte
+	size    base.Byte
+	next    BinaryCache
+
+	// keys are sorted from the least to the most recently used.
+	keys  []string
+	sizes map[string]base.Byte
+}
+
+// NewLocalBinaryCache returns a BinaryCache that stores the binaries in the
+// root directory, so that rejudges and identical submissions are not
+// compiled again by this runner. The least recently used binaries are
+// evicted when the cache grows beyond maxSize. If next is not nil, binaries
+// that are not found locally are looked up there, and all binaries are also
+// stored there.
+func NewLocalBinaryCache(root string, maxSize base.Byte, next BinaryCache) (BinaryCache, error) {
+	// The index is not persisted, so any binaries left over from a previous
+	// run are not known anymore.
+	if err := os.RemoveAll(root); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &localBinaryCache{
+		root:    root,
+		maxSize: maxSize,
+		next:    next,
+		sizes:   make(map[string]base.Byte),
+	}, nil
+}
+
+func (c *localBinaryCache) path(key string) string {
+	return path.Join(c.root, key+".tar.gz")
+}
+
+func (c *localBinaryCache) open(key string) (*os.File, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.sizes[key]; !ok {
+		return nil, nil
+	}
+	for i, k := range c.keys {
+		if k == key {
+			c.keys = append(append(c.keys[:i:i], c.keys[i+1:]...), key)
+			break
+		}
+	}
+	// Even if the binary is evicted right after this, the file can still be
+	// read until it is closed.
+	return os.Open(c.path(key))
+}
+
+func (c *localBinaryCache) Get(ctx *common.Context, key, dst string) (bool, error) {
+	f, err := c.open(key)
+	if err != nil {
+		return false, err
+	}
+	if f != nil {
+		defer f.Close()
+		if err := extractBinaryArchive(f, dst); err != nil {
+			return false, fmt.Errorf("failed to extract binary %s: %w", key, err)
+		}
+		return true, nil
+	}
+	if c.next == nil {
+		return false, nil
+	}
+	ok, err := c.next.Get(ctx, key, dst)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err := c.store(key, dst); err != nil {
+		ctx.Log.Warn(
+			"Failed to store binary in the local cache",
+			map[string]any{
+				"key": key,
+				"err": err,
+			},
+		)
+	}
+	return true, nil
+}
+
+func (c *localBinaryCache) Put(ctx *common.Context, key, src string) error {
+	err := c.store(key, src)
+	if c.next != nil {
+		if nextErr := c.next.Put(ctx, key, src); err == nil {
+			err = nextErr
+		}
+	}
+	return err
+}
+
+// store adds the contents of the src directory to the cache, evicting the
+// least recently used binaries if needed. Storing a binary that is already
+// in the cache is a no-op.
+func (c *localBinaryCache) store(key, src string) error {
+	c.Lock()
+	_, ok := c.sizes[key]
+	c.Unlock()
+	if ok {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(c.root, "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = writeBinaryArchive(f, src)
+	var size base.Byte
+	if err == nil {
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil {
+			size = base.Byte(info.Size())
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size > c.maxSize {
+		return fmt.Errorf("binary %s too large: %d bytes", key, size.Bytes())
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.sizes[key]; ok {
+		return nil
+	}
+	for c.size+size > c.maxSize && len(c.keys) > 0 {
+		oldest := c.keys[0]
+		c.keys = c.keys[1:]
+		c.size -= c.sizes[oldest]
+		delete(c.sizes, oldest)
+		os.Remove(c.path(oldest))
+	}
+	if err := os.Rename(f.Name(), c.path(key)); err != nil {
+		return err
+	}
+	c.keys = append(c.keys, key)
+	c.sizes[key] = size
+	c.size += size
+	return nil
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+func TestLocalBinaryCache(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	writeBinary := func(contents string) string {
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(path.Join(dir, "Main"), []byte(contents), 0755); err != nil {
+			t.Fatalf("Failed to write binary: %v", err)
+		}
+		return dir
+	}
+	readBinary := func(cache BinaryCache, key string) (string, bool) {
+		dir := t.TempDir()
+		ok, err := cache.Get(ctx, key, dir)
+		if err != nil {
+			t.Fatalf("Get(%q) failed: %v", key, err)
+		}
+		if !ok {
+			return "", false
+		}
+		contents, err := ioutil.ReadFile(path.Join(dir, "Main"))
+		if err != nil {
+			t.Fatalf("Failed to read binary %q: %v", key, err)
+		}
+		return string(contents), true
+	}
+
+	next := &memoryBinaryCache{binaries: make(map[string][]byte)}
+	if err := next.Put(ctx, "remote", writeBinary("remote binary")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	// Make room for two of the binaries, but not three.
+	size := int64(0)
+	{
+		f, err := ioutil.TempFile(t.TempDir(), "binary")
+		if err != nil {
+			t.Fatalf("Failed to create archive: %v", err)
+		}
+		if err := writeBinaryArchive(f, writeBinary("binary a")); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		info, err := f.Stat()
+		f.Close()
+		if err != nil {
+			t.Fatalf("Failed to stat archive: %v", err)
+		}
+		size = info.Size()
+	}
+	cache, err := NewLocalBinaryCache(
+		path.Join(ctx.Config.Runner.RuntimePath, "compile-cache"),
+		base.Byte(2*size+size/2),
+		next,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create the cache: %v", err)
+	}
+
+	if _, ok := readBinary(cache, "a"); ok {
+		t.Errorf("Get() of a missing binary succeeded")
+	}
+	if contents, ok := readBinary(cache, "remote"); !ok || contents != "remote binary" {
+		t.Errorf("Get(\"remote\") == %q, %v, want %q, true", contents, ok, "remote binary")
+	}
+	if err := cache.Put(ctx, "a", writeBinary("binary a")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if _, ok := next.binaries["a"]; !ok {
+		t.Errorf("Put() did not store the binary in the next cache")
+	}
+
+	// The remote binary was fetched before a, but a has not been used since,
+	// so it is the one that is evicted.
+	delete(next.binaries, "remote")
+	delete(next.binaries, "a")
+	if _, ok := readBinary(cache, "remote"); !ok {
+		t.Errorf("Get(\"remote\") was not served by the local cache")
+	}
+	if err := cache.Put(ctx, "b", writeBinary("binary b")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	delete(next.binaries, "b")
+	if _, ok := readBinary(cache, "a"); ok {
+		t.Errorf("Get() of an evicted binary succeeded")
+	}
+	for key, expected := range map[string]string{"remote": "remote binary", "b": "binary b"} {
+		if contents, ok := readBinary(cache, key); !ok || contents != expected {
+			t.Errorf("Get(%q) == %q, %v, want %q, true", key, contents, ok, expected)
+		}
+	}
+}
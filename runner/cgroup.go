@@ -0,0 +1,166 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+
+	"github.com/pkg/errors"
+)
+
+// executionCgroup is a cgroup v2 that is created for a single execution in a
+// sandbox, so that the resources used by all of its processes are accounted
+// for together.
+type executionCgroup struct {
+	path string
+}
+
+// newExecutionCgroup creates a new cgroup under root, which must be a cgroup
+// v2 directory that has the cpu and memory controllers enabled for its
+// children.
+func newExecutionCgroup(root string) (*executionCgroup, error) {
+	cgroupPath, err := ioutil.TempDir(root, "execution-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cgroup")
+	}
+	return &executionCgroup{
+		path: cgroupPath,
+	}, nil
+}
+
+// command returns the command line that runs params within the cgroup. A
+// shell moves itself into the cgroup before executing the program, so that
+// all of its descendants are accounted for from the very beginning.
+func (c *executionCgroup) command(params []string) []string {
+	return append(
+		[]string{
+			"/bin/sh",
+			"-c",
+			`echo $$ > "$0" && exec "$@"`,
+			path.Join(c.path, "cgroup.procs"),
+		},
+		params...,
+	)
+}
+
+// remove deletes the cgroup. All of its processes must have exited.
+func (c *executionCgroup) remove() error {
+	return os.Remove(c.path)
+}
+
+// cgroupStats are the resources used by the processes in a cgroup.
+type cgroupStats struct {
+	userTime   time.Duration
+	systemTime time.Duration
+
+	// peakMemory is 0 if the kernel does not report it (memory.peak was
+	// added in Linux 5.19).
+	peakMemory base.Byte
+
+	// oomKilled is set if any of the processes was killed for going over the
+	// memory limit.
+	oomKilled bool
+}
+
+// stats reads the resources that the processes in the cgroup have used.
+func (c *executionCgroup) stats() (*cgroupStats, error) {
+	stats := &cgroupStats{}
+
+	cpuStat, err := readCgroupKeyedFile(path.Join(c.path, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats.userTime = time.Duration(cpuStat["user_usec"]) * time.Microsecond
+	stats.systemTime = time.Duration(cpuStat["system_usec"]) * time.Microsecond
+
+	memoryEvents, err := readCgroupKeyedFile(path.Join(c.path, "memory.events"))
+	if err != nil {
+		return nil, err
+	}
+	stats.oomKilled = memoryEvents["oom_kill"] > 0
+
+	peak, err := ioutil.ReadFile(path.Join(c.path, "memory.peak"))
+	if err == nil {
+		peakBytes, err := strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid memory.peak")
+		}
+		stats.peakMemory = base.Byte(peakBytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// appendToMetaFile appends the stats to the meta file written by the
+// sandbox, in the same format. parseMetaFile keeps the last value of each
+// field, so these replace the ones that the sandbox measured.
+func (s *cgroupStats) appendToMetaFile(metaFile string) error {
+	f, err := os.OpenFile(metaFile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, s.metaLines())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *cgroupStats) metaLines() string {
+	lines := []string{
+		fmt.Sprintf("time:%d", s.userTime.Microseconds()),
+		fmt.Sprintf("time-sys:%d", s.systemTime.Microseconds()),
+	}
+	if s.peakMemory > 0 {
+		lines = append(lines, fmt.Sprintf("mem:%d", s.peakMemory.Bytes()))
+	}
+	if s.oomKilled {
+		lines = append(lines, "oom_killed:1")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func readCgroupKeyedFile(filename string) (map[string]int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values, err := parseCgroupKeyedFile(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", path.Base(filename))
+	}
+	return values, nil
+}
+
+// parseCgroupKeyedFile parses a cgroup file with one "key value" pair per
+// line, like cpu.stat or memory.events.
+func parseCgroupKeyedFile(r io.Reader) (map[string]int64, error) {
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) != 2 {
+			return nil, errors.Errorf("malformed line: %q", scanner.Text())
+		}
+		value, err := strconv.ParseInt(tokens[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "malformed line: %q", scanner.Text())
+		}
+		values[tokens[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCgroupKeyedFile(t *testing.T) {
+	values, err := parseCgroupKeyedFile(strings.NewReader(
+		"usage_usec 1500\nuser_usec 1000\nsystem_usec 500\nnr_periods 0\n",
+	))
+	if err != nil {
+		t.Fatalf("parseCgroupKeyedFile failed: %v", err)
+	}
+	for key, expected := range map[string]int64{
+		"usage_usec":  1500,
+		"user_usec":   1000,
+		"system_usec": 500,
+		"nr_periods":  0,
+	} {
+		if values[key] != expected {
+			t.Errorf("values[%q] == %d, want %d", key, values[key], expected)
+		}
+	}
+
+	for _, contents := range []string{
+		"oom_kill\n",
+		"oom_kill one\n",
+		"oom_kill 1 2\n",
+	} {
+		if _, err := parseCgroupKeyedFile(strings.NewReader(contents)); err == nil {
+			t.Errorf("parseCgroupKeyedFile(%q) succeeded, want error", contents)
+		}
+	}
+}
+
+func TestCgroupStatsMetaLines(t *testing.T) {
+	for _, tc := range []struct {
+		stats cgroupStats
+		lines string
+	}{
+		{
+			cgroupStats{
+				userTime:   1500 * time.Microsecond,
+				systemTime: 20 * time.Microsecond,
+			},
+			"time:1500\ntime-sys:20\n",
+		},
+		{
+			cgroupStats{
+				userTime:   time.Second,
+				systemTime: time.Millisecond,
+				peakMemory: 4096,
+				oomKilled:  true,
+			},
+			"time:1000000\ntime-sys:1000\nmem:4096\noom_killed:1\n",
+		},
+	} {
+		if lines := tc.stats.metaLines(); lines != tc.lines {
+			t.Errorf("%+v.metaLines() == %q, want %q", tc.stats, lines, tc.lines)
+		}
+	}
+}
+
+func TestExecutionCgroupCommand(t *testing.T) {
+	cgroup := &executionCgroup{path: "/sys/fs/cgroup/omegaup/execution-1"}
+	command := cgroup.command([]string{"/bin/omegajail", "--root", "/"})
+	expected := []string{
+		"/bin/sh",
+		"-c",
+		`echo $$ > "$0" && exec "$@"`,
+		"/sys/fs/cgroup/omegaup/execution-1/cgroup.procs",
+		"/bin/omegajail",
+		"--root",
+		"/",
+	}
+	if strings.Join(command, "\x00") != strings.Join(expected, "\x00") {
+		t.Errorf("command == %q, want %q", command, expected)
+	}
+}
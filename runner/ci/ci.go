@@ -226,7 +226,7 @@ func (t *ReportTest) SetResult(result *runner.RunResult) {
 			return
 		}
 	} else {
-		if result.Verdict != "AC" {
+		if result.Verdict != common.VerdictAccepted {
 			t.ReportError = &ReportError{
 				Error: errors.Errorf(
 					"expected verdict to be \"AC\", got %q",
@@ -676,7 +676,7 @@ func NewRunConfig(files common.ProblemFiles, generateOutputFiles bool) (*RunConf
 			// Real test cases to validate
 			{"inputs", config.Input.Cases, nil},
 			// Known invalid test cases
-			{"invalid-inputs", invalidInputCases, &common.SolutionSettings{Verdict: "WA"}},
+			{"invalid-inputs", invalidInputCases, &common.SolutionSettings{Verdict: common.VerdictWrongAnswer}},
 		} {
 			params := params
 
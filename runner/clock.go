@@ -0,0 +1,20 @@
+package runner
+
+import (
+	"time"
+)
+
+// A Clock tells the current time. Grade measures how long each of its phases
+// takes with it, so that tests can replace it with a deterministic one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock that uses the system time.
+var SystemClock Clock = systemClock{}
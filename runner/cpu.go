@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omegaup/quark/common"
+
+	"github.com/pkg/errors"
+)
+
+// cpuSysfsRoot is the directory where the kernel exposes the per-CPU
+// controls.
+const cpuSysfsRoot = "/sys/devices/system/cpu"
+
+// ParseCPUList parses a list of CPUs in the format that the kernel and
+// taskset use (e.g. "0-3,6"). The result is sorted and has no duplicates.
+func ParseCPUList(list string) ([]int, error) {
+	seen := make(map[int]struct{})
+	cpus := []int{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		first, last := entry, entry
+		if i := strings.Index(entry, "-"); i != -1 {
+			first, last = entry[:i], entry[i+1:]
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 0 {
+			return nil, errors.Errorf("invalid CPU list %q", list)
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			return nil, errors.Errorf("invalid CPU list %q", list)
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			if _, ok := seen[cpu]; ok {
+				continue
+			}
+			seen[cpu] = struct{}{}
+			cpus = append(cpus, cpu)
+		}
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// sandboxCommand returns the command that invokes the sandbox binary with the
+// provided arguments. If Runner.SandboxCPUs is set, it is pinned to those
+// CPUs with taskset, which all the sandboxed processes inherit.
+func sandboxCommand(ctx *common.Context, name string, args ...string) *exec.Cmd {
+	if cpus := ctx.Config.Runner.SandboxCPUs; cpus != "" {
+		args = append([]string{"--cpu-list", cpus, name}, args...)
+		name = "taskset"
+	}
+	return exec.CommandContext(ctx.Context, name, args...)
+}
+
+// cpuDirs returns the sysfs directories of the CPUs, or of all the CPUs if
+// cpus is empty.
+func cpuDirs(root string, cpus []int) ([]string, error) {
+	if len(cpus) == 0 {
+		dirs, err := filepath.Glob(path.Join(root, "cpu[0-9]*"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(dirs)
+		return dirs, nil
+	}
+	dirs := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		dirs[i] = path.Join(root, fmt.Sprintf("cpu%d", cpu))
+	}
+	return dirs, nil
+}
+
+// LockCPUGovernor sets the frequency scaling governor (e.g. "performance") of
+// the CPUs, or of all of them if cpus is empty, so that the programs are not
+// measured while the CPUs are ramping up their frequency. The returned
+// function restores the previous governors.
+func LockCPUGovernor(cpus []int, governor string) (func() error, error) {
+	return lockCPUGovernor(cpuSysfsRoot, cpus, governor)
+}
+
+func lockCPUGovernor(root string, cpus []int, governor string) (func() error, error) {
+	dirs, err := cpuDirs(root, cpus)
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[string]string)
+	restore := func() error {
+		var result error
+		for governorFile, previousGovernor := range previous {
+			if err := ioutil.WriteFile(governorFile, []byte(previousGovernor), 0644); err != nil && result == nil {
+				result = errors.Wrapf(err, "failed to restore %s", governorFile)
+			}
+		}
+		return result
+	}
+	for _, dir := range dirs {
+		governorFile := path.Join(dir, "cpufreq/scaling_governor")
+		contents, err := ioutil.ReadFile(governorFile)
+		if err != nil {
+			restore()
+			return nil, errors.Wrapf(err, "failed to read %s", governorFile)
+		}
+		currentGovernor := strings.TrimSpace(string(contents))
+		if currentGovernor == governor {
+			continue
+		}
+		if err := ioutil.WriteFile(governorFile, []byte(governor), 0644); err != nil {
+			restore()
+			return nil, errors.Wrapf(err, "failed to write %s", governorFile)
+		}
+		previous[governorFile] = currentGovernor
+	}
+	return restore, nil
+}
+
+// ThermalMonitor detects whether the CPUs have been throttled because they
+// were too hot, which makes the programs that run on them slower.
+type ThermalMonitor struct {
+	root   string
+	cpus   []int
+	counts map[string]int64
+}
+
+// NewThermalMonitor returns a ThermalMonitor for the CPUs, or for all of them
+// if cpus is empty.
+func NewThermalMonitor(cpus []int) *ThermalMonitor {
+	return newThermalMonitor(cpuSysfsRoot, cpus)
+}
+
+func newThermalMonitor(root string, cpus []int) *ThermalMonitor {
+	return &ThermalMonitor{
+		root: root,
+		cpus: cpus,
+	}
+}
+
+// Throttled returns whether any of the CPUs has been throttled since the
+// previous call. The first call only takes the initial measurement. CPUs
+// whose kernel does not report throttling are never considered throttled.
+func (m *ThermalMonitor) Throttled() (bool, error) {
+	dirs, err := cpuDirs(m.root, m.cpus)
+	if err != nil {
+		return false, err
+	}
+	counts := make(map[string]int64)
+	for _, dir := range dirs {
+		for _, name := range []string{"core_throttle_count", "package_throttle_count"} {
+			countFile := path.Join(dir, "thermal_throttle", name)
+			contents, err := ioutil.ReadFile(countFile)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			count, err := strconv.ParseInt(strings.TrimSpace(string(contents)), 10, 64)
+			if err != nil {
+				return false, errors.Wrapf(err, "invalid %s", countFile)
+			}
+			counts[countFile] = count
+		}
+	}
+	previous := m.counts
+	m.counts = counts
+	if previous == nil {
+		return false, nil
+	}
+	for countFile, count := range counts {
+		if previousCount, ok := previous[countFile]; ok && count > previousCount {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		cpus []int
+	}{
+		{"", []int{}},
+		{"3", []int{3}},
+		{"0-3", []int{0, 1, 2, 3}},
+		{"6,0-2, 4", []int{0, 1, 2, 4, 6}},
+		{"1-2,2-3", []int{1, 2, 3}},
+	} {
+		cpus, err := ParseCPUList(tc.list)
+		if err != nil {
+			t.Errorf("ParseCPUList(%q) failed: %v", tc.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(cpus, tc.cpus) {
+			t.Errorf("ParseCPUList(%q) == %v, want %v", tc.list, cpus, tc.cpus)
+		}
+	}
+
+	for _, list := range []string{"a", "3-1", "-1", "1-", "1-2-3"} {
+		if cpus, err := ParseCPUList(list); err == nil {
+			t.Errorf("ParseCPUList(%q) == %v, want error", list, cpus)
+		}
+	}
+}
+
+func writeSysfsFile(t *testing.T, root, name, contents string) {
+	t.Helper()
+	filename := path.Join(root, name)
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		t.Fatalf("Failed to create %q: %v", path.Dir(filename), err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write %q: %v", filename, err)
+	}
+}
+
+func readSysfsFile(t *testing.T, root, name string) string {
+	t.Helper()
+	contents, err := ioutil.ReadFile(path.Join(root, name))
+	if err != nil {
+		t.Fatalf("Failed to read %q: %v", name, err)
+	}
+	return string(contents)
+}
+
+func TestLockCPUGovernor(t *testing.T) {
+	root := t.TempDir()
+	writeSysfsFile(t, root, "cpu0/cpufreq/scaling_governor", "powersave\n")
+	writeSysfsFile(t, root, "cpu1/cpufreq/scaling_governor", "performance\n")
+	writeSysfsFile(t, root, "cpu2/cpufreq/scaling_governor", "schedutil\n")
+
+	restore, err := lockCPUGovernor(root, []int{0, 1}, "performance")
+	if err != nil {
+		t.Fatalf("lockCPUGovernor failed: %v", err)
+	}
+	for name, expected := range map[string]string{
+		"cpu0/cpufreq/scaling_governor": "performance",
+		"cpu1/cpufreq/scaling_governor": "performance\n",
+		"cpu2/cpufreq/scaling_governor": "schedutil\n",
+	} {
+		if contents := readSysfsFile(t, root, name); contents != expected {
+			t.Errorf("%s == %q, want %q", name, contents, expected)
+		}
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if contents := readSysfsFile(t, root, "cpu0/cpufreq/scaling_governor"); contents != "powersave" {
+		t.Errorf("restored governor == %q, want %q", contents, "powersave")
+	}
+
+	// All the CPUs are locked if none is specified, and nothing is changed if
+	// any of them fails.
+	if _, err := lockCPUGovernor(root, []int{0, 3}, "performance"); err == nil {
+		t.Errorf("lockCPUGovernor of a missing CPU succeeded")
+	}
+	if contents := readSysfsFile(t, root, "cpu0/cpufreq/scaling_governor"); contents != "powersave" {
+		t.Errorf("governor after a failed lock == %q, want %q", contents, "powersave")
+	}
+	if _, err := lockCPUGovernor(root, nil, "performance"); err != nil {
+		t.Fatalf("lockCPUGovernor failed: %v", err)
+	}
+	if contents := readSysfsFile(t, root, "cpu2/cpufreq/scaling_governor"); contents != "performance" {
+		t.Errorf("cpu2 governor == %q, want %q", contents, "performance")
+	}
+}
+
+func TestThermalMonitor(t *testing.T) {
+	root := t.TempDir()
+	writeSysfsFile(t, root, "cpu0/thermal_throttle/core_throttle_count", "5\n")
+	writeSysfsFile(t, root, "cpu0/thermal_throttle/package_throttle_count", "1\n")
+	writeSysfsFile(t, root, "cpu1/thermal_throttle/core_throttle_count", "0\n")
+	// CPUs that do not report throttling are ignored.
+	writeSysfsFile(t, root, "cpu2/cpufreq/scaling_governor", "performance\n")
+
+	monitor := newThermalMonitor(root, nil)
+	for i, tc := range []struct {
+		file      string
+		count     string
+		throttled bool
+	}{
+		// The first measurement is never throttled.
+		{"", "", false},
+		{"", "", false},
+		{"cpu1/thermal_throttle/core_throttle_count", "2\n", true},
+		{"", "", false},
+		{"cpu0/thermal_throttle/package_throttle_count", "3\n", true},
+	} {
+		if tc.file != "" {
+			writeSysfsFile(t, root, tc.file, tc.count)
+		}
+		throttled, err := monitor.Throttled()
+		if err != nil {
+			t.Fatalf("%d: Throttled() failed: %v", i, err)
+		}
+		if throttled != tc.throttled {
+			t.Errorf("%d: Throttled() == %v, want %v", i, throttled, tc.throttled)
+		}
+	}
+
+	// Only the selected CPUs are monitored.
+	monitor = newThermalMonitor(root, []int{0})
+	if _, err := monitor.Throttled(); err != nil {
+		t.Fatalf("Throttled() failed: %v", err)
+	}
+	writeSysfsFile(t, root, "cpu1/thermal_throttle/core_throttle_count", "10\n")
+	if throttled, err := monitor.Throttled(); err != nil || throttled {
+		t.Errorf("Throttled() == %v, %v, want false, nil", throttled, err)
+	}
+}
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/omegaup/quark/common"
+)
+
+// ExecutionEnvironment describes the machine and the software that graded a
+// run, so that a verdict can be traced back to where it was produced if it is
+// ever disputed.
+type ExecutionEnvironment struct {
+	// Runner is the name of the runner.
+	Runner string `json:"runner,omitempty"`
+
+	// Sandbox is the sandbox backend: "omegajail", "isolate", "nsjail" or
+	// "noop".
+	Sandbox string `json:"sandbox,omitempty"`
+
+	// Kernel is the release of the kernel of the runner.
+	Kernel string `json:"kernel,omitempty"`
+
+	// ToolchainFingerprint identifies the versions of the compilers that the
+	// runner has. It is the same fingerprint that the binary cache uses.
+	ToolchainFingerprint string `json:"toolchain_fingerprint,omitempty"`
+
+	// TimeFactor is the factor that the time limit of the run was multiplied
+	// by to calibrate it for its language, or 1 if it was not.
+	TimeFactor float64 `json:"time_factor,omitempty"`
+}
+
+// KernelVersion returns the release of the running kernel, or an empty
+// string if it cannot be read.
+func KernelVersion() string {
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(release))
+}
+
+// timeFactor returns the factor that common.RunnerConfig.SandboxLimits
+// multiplies the time limit of a program in the language by.
+func timeFactor(config *common.RunnerConfig, lang string) float64 {
+	if factor := config.LanguageProfile(lang).TimeFactor; factor > 1 {
+		return factor
+	}
+	return 1
+}
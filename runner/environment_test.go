@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/omegaup/quark/common"
+)
+
+func TestRunResultEnvironment(t *testing.T) {
+	result := NewRunResult(common.VerdictAccepted, big.NewRat(1, 1))
+	result.Environment = &ExecutionEnvironment{
+		Runner:               "runner",
+		Sandbox:              "omegajail",
+		Kernel:               "6.1.0",
+		ToolchainFingerprint: "abc",
+		TimeFactor:           2,
+	}
+	marshaled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	var unmarshaled RunResult
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if unmarshaled.Environment == nil || *unmarshaled.Environment != *result.Environment {
+		t.Errorf("Environment == %v, want %v", unmarshaled.Environment, result.Environment)
+	}
+
+	// The environment is never shown to contestants.
+	if environment := result.WithFeedback(common.FeedbackLevelFullDiff).Environment; environment != nil {
+		t.Errorf("WithFeedback().Environment == %v, want nil", environment)
+	}
+	if result.Environment == nil {
+		t.Errorf("WithFeedback() modified the original result")
+	}
+}
+
+func TestTimeFactor(t *testing.T) {
+	config := common.DefaultConfig()
+	for lang, expected := range map[string]float64{
+		"py3-pypy":  2,
+		"cpp17-gcc": 1,
+	} {
+		if factor := timeFactor(&config.Runner, lang); factor != expected {
+			t.Errorf("timeFactor(%q) == %v, want %v", lang, factor, expected)
+		}
+	}
+}
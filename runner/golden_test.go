@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+)
+
+// goldenProgramOutput is the recorded output of a single sandbox invocation.
+type goldenProgramOutput struct {
+	Stdout string       `json:"stdout"`
+	Stderr string       `json:"stderr"`
+	Meta   *RunMetadata `json:"meta"`
+}
+
+func (o *goldenProgramOutput) programOutput() programOutput {
+	return programOutput{o.Stdout, o.Stderr, o.Meta}
+}
+
+// frozenClock is a Clock that always returns the same time.
+type frozenClock struct {
+	now time.Time
+}
+
+func (c *frozenClock) Now() time.Time {
+	return c.now
+}
+
+// goldenScenario is a run whose sandbox outputs were recorded, so that it can
+// be replayed without a real sandbox.
+type goldenScenario struct {
+	Input    common.LiteralInput `json:"input"`
+	Language string              `json:"language"`
+	Source   string              `json:"source"`
+	MaxScore float64             `json:"max_score"`
+	Compile  goldenProgramOutput `json:"compile"`
+	Cases    map[string]struct {
+		Run       goldenProgramOutput `json:"run"`
+		Validator goldenProgramOutput `json:"validator"`
+	} `json:"cases"`
+}
+
+// normalizeRunResultJSON returns a generic representation of the JSON
+// serialization of a RunResult, so that two results can be compared regardless
+// of field order and formatting.
+func normalizeRunResultJSON(result *RunResult) (any, error) {
+	marshaled, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	if err := json.Unmarshal(marshaled, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// TestGolden replays the recorded sandbox outputs in testdata/golden/*.json
+// and compares the results with the ones in the matching .expected.json file.
+// Run the tests with UPDATE_GOLDEN=1 to regenerate the expected results.
+func TestGolden(t *testing.T) {
+	scenarioPaths, err := filepath.Glob("testdata/golden/*.json")
+	if err != nil {
+		t.Fatalf("Failed to list golden scenarios: %v", err)
+	}
+	for _, scenarioPath := range scenarioPaths {
+		if strings.HasSuffix(scenarioPath, ".expected.json") {
+			continue
+		}
+		scenarioPath := scenarioPath
+		name := strings.TrimSuffix(filepath.Base(scenarioPath), ".json")
+		t.Run(name, func(t *testing.T) {
+			contents, err := ioutil.ReadFile(scenarioPath)
+			if err != nil {
+				t.Fatalf("Failed to read scenario: %v", err)
+			}
+			var scenario goldenScenario
+			if err := json.Unmarshal(contents, &scenario); err != nil {
+				t.Fatalf("Failed to parse scenario: %v", err)
+			}
+
+			ctx, err := newRunnerContext(t)
+			if err != nil {
+				t.Fatalf("RunnerContext creation failed with %q", err)
+			}
+			defer ctx.Close()
+			if !ctx.Config.Runner.PreserveFiles {
+				defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+			}
+
+			inputManager := common.NewInputManager(ctx)
+			inputFactory, err := common.NewLiteralInputFactory(
+				&scenario.Input,
+				ctx.Config.Runner.RuntimePath,
+				common.LiteralPersistRunner,
+			)
+			if err != nil {
+				t.Fatalf("Failed to create Input: %q", err)
+			}
+			inputRef, err := inputManager.Add(inputFactory.Hash(), inputFactory)
+			if err != nil {
+				t.Fatalf("Failed to open problem: %q", err)
+			}
+			defer inputRef.Release()
+
+			testCase := runnerTestCase{
+				language:               scenario.Language,
+				source:                 scenario.Source,
+				maxScore:               base.FloatToRational(scenario.MaxScore),
+				expectedCompileResults: expectedResult{runOutput: scenario.Compile.programOutput()},
+				expectedResults:        make(map[string]expectedResult),
+			}
+			for caseName, recorded := range scenario.Cases {
+				testCase.expectedResults[caseName] = expectedResult{
+					runOutput:       recorded.Run.programOutput(),
+					validatorOutput: recorded.Validator.programOutput(),
+				}
+			}
+
+			results, err := GradeWithOptions(
+				ctx,
+				&bytes.Buffer{},
+				&common.Run{
+					AttemptID: 1,
+					Language:  testCase.language,
+					InputHash: inputRef.Input.Hash(),
+					Source:    testCase.source,
+					MaxScore:  testCase.maxScore,
+				},
+				inputRef.Input,
+				&fakeSandbox{testCase: &testCase},
+				GradeOptions{
+					Layout: &RunLayout{Root: filepath.Join(t.TempDir(), name)},
+					Clock:  &frozenClock{now: time.Unix(0, 0)},
+				},
+			)
+			if err != nil {
+				t.Fatalf("Failed to grade: %v", err)
+			}
+			if results.Timings.Compile != 0 || results.Timings.Run != 0 || results.Timings.Validate != 0 {
+				t.Errorf("Timings == %+v, want no time spent with a frozen clock", results.Timings)
+			}
+
+			expectedPath := strings.TrimSuffix(scenarioPath, ".json") + ".expected.json"
+			if os.Getenv("UPDATE_GOLDEN") != "" {
+				marshaled, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					t.Fatalf("Failed to marshal results: %v", err)
+				}
+				if err := ioutil.WriteFile(expectedPath, append(marshaled, '\n'), 0644); err != nil {
+					t.Fatalf("Failed to write expected results: %v", err)
+				}
+				return
+			}
+
+			expectedContents, err := ioutil.ReadFile(expectedPath)
+			if err != nil {
+				t.Fatalf("Failed to read expected results: %v", err)
+			}
+			var expectedResults RunResult
+			if err := json.Unmarshal(expectedContents, &expectedResults); err != nil {
+				t.Fatalf("Failed to parse expected results: %v", err)
+			}
+			expected, err := normalizeRunResultJSON(&expectedResults)
+			if err != nil {
+				t.Fatalf("Failed to normalize expected results: %v", err)
+			}
+			got, err := normalizeRunResultJSON(results)
+			if err != nil {
+				t.Fatalf("Failed to normalize results: %v", err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				marshaled, _ := json.MarshalIndent(results, "", "  ")
+				t.Errorf("results differ from %s, got:\n%s", expectedPath, marshaled)
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+)
+
+const (
+	// hookPreCompile is the hook point before the submission is compiled.
+	hookPreCompile = "pre-compile"
+	// hookPreCase is the hook point before each case runs.
+	hookPreCase = "pre-case"
+	// hookPostCase is the hook point after each case runs, before its output
+	// is validated.
+	hookPostCase = "post-case"
+	// hookScorer is the hook point after all the cases have been validated,
+	// where the scorer replaces the score of the run.
+	hookScorer = "scorer"
+
+	// hookTarget is the name of the source file of all hooks, without the
+	// extension.
+	hookTarget = "hook"
+)
+
+// A hook is a compiled program from the input that runs at one of the hook
+// points of the grading pipeline.
+type hook struct {
+	point    string
+	language string
+	binPath  string
+	limits   common.LimitsSettings
+}
+
+// gradingHooks are the compiled hooks of a problem. A nil gradingHooks or a
+// nil hook mean that nothing runs at that point.
+type gradingHooks struct {
+	preCompile *hook
+	preCase    *hook
+	postCase   *hook
+	scorer     *hook
+}
+
+// compileHooks compiles the hooks in the settings of the problem. Since hooks
+// are part of the problem, a hook that fails to compile is returned as an
+// error together with its metadata.
+func compileHooks(
+	ctx *common.Context,
+	input common.Input,
+	sandbox Sandbox,
+	settings *common.ProblemSettings,
+	layout *RunLayout,
+) (*gradingHooks, map[string]RunMetadata, []string, error) {
+	hooks := &gradingHooks{}
+	compileMeta := make(map[string]RunMetadata)
+	var generatedFiles []string
+	if settings.Hooks == nil {
+		return hooks, compileMeta, generatedFiles, nil
+	}
+	for _, h := range []struct {
+		point    string
+		settings *common.HookSettings
+		hook     **hook
+	}{
+		{hookPreCompile, settings.Hooks.PreCompile, &hooks.preCompile},
+		{hookPreCase, settings.Hooks.PreCase, &hooks.preCase},
+		{hookPostCase, settings.Hooks.PostCase, &hooks.postCase},
+		{hookScorer, settings.Hooks.Scorer, &hooks.scorer},
+	} {
+		if h.settings == nil {
+			continue
+		}
+		if h.settings.Lang == "" {
+			return nil, compileMeta, generatedFiles, fmt.Errorf("the %s hook has no language", h.point)
+		}
+		binRoot := layout.BinRoot(h.point)
+		binPath := layout.BinPath(h.point)
+		if err := os.MkdirAll(binPath, 0755); err != nil {
+			return nil, compileMeta, generatedFiles, err
+		}
+		sourceFile := path.Join(
+			binPath,
+			fmt.Sprintf("%s.%s", hookTarget, common.LanguageFileExtension(h.settings.Lang)),
+		)
+		if err := copyFile(
+			path.Join(input.Path(), "hooks", fmt.Sprintf("%s.%s", h.point, h.settings.Lang)),
+			sourceFile,
+		); err != nil {
+			return nil, compileMeta, generatedFiles, err
+		}
+
+		lang := h.settings.Lang
+		if lang == "cpp" {
+			// Same as with validators, problemsetters are not forced to use old
+			// languages.
+			lang = "cpp11"
+		}
+		meta, err := sandbox.Compile(
+			ctx,
+			lang,
+			[]string{sourceFile},
+			binPath,
+			path.Join(binRoot, "compile.out"),
+			path.Join(binRoot, "compile.err"),
+			path.Join(binRoot, "compile.meta"),
+			hookTarget,
+			[]string{},
+		)
+		generatedFiles = append(
+			generatedFiles,
+			path.Join(h.point, "compile.out"),
+			path.Join(h.point, "compile.err"),
+			path.Join(h.point, "compile.meta"),
+		)
+		if meta != nil {
+			compileMeta[h.point] = *meta
+		}
+		if err != nil {
+			return nil, compileMeta, generatedFiles, fmt.Errorf("failed to compile the %s hook: %w", h.point, err)
+		}
+		if meta.Verdict != common.VerdictOK {
+			return nil, compileMeta, generatedFiles, fmt.Errorf(
+				"failed to compile the %s hook: %s",
+				h.point,
+				compileErrorOutput(binRoot, h.settings.Lang),
+			)
+		}
+		*h.hook = &hook{
+			point:    h.point,
+			language: lang,
+			binPath:  binPath,
+			limits:   *validatorLimits(&settings.Limits, h.settings.Limits),
+		}
+	}
+	return hooks, compileMeta, generatedFiles, nil
+}
+
+// run runs the hook with the file in inputPath as its standard input. Its
+// standard output is written to the .out file of name under the directory of
+// the hook. The name and the language of the submission are passed as
+// arguments. It returns an error if the hook did not finish correctly.
+func (h *hook) run(
+	ctx *common.Context,
+	sandbox Sandbox,
+	layout *RunLayout,
+	inputPath string,
+	name string,
+	language string,
+) (*RunMetadata, []string, error) {
+	meta, err := sandbox.Run(
+		ctx,
+		&h.limits,
+		h.language,
+		h.binPath,
+		inputPath,
+		layout.CaseOut(h.point, name),
+		layout.CaseErr(h.point, name),
+		layout.CaseMeta(h.point, name),
+		hookTarget,
+		nil,
+		nil,
+		nil,
+		[]string{name, language},
+		map[string]string{},
+	)
+	generatedFiles := []string{
+		caseFileName(h.point, name, "out"),
+		caseFileName(h.point, name, "err"),
+		caseFileName(h.point, name, "meta"),
+	}
+	if err != nil {
+		return meta, generatedFiles, fmt.Errorf("failed to run the %s hook: %w", h.point, err)
+	}
+	if meta.Verdict != common.VerdictOK {
+		return meta, generatedFiles, fmt.Errorf("the %s hook finished with %s", h.point, meta.Verdict)
+	}
+	return meta, generatedFiles, nil
+}
+
+// source returns the source of the submission that is compiled, which is
+// the output of the pre-compile hook if there is one.
+func (h *gradingHooks) source(
+	ctx *common.Context,
+	sandbox Sandbox,
+	layout *RunLayout,
+	run *common.Run,
+) (string, []string, error) {
+	if h == nil || h.preCompile == nil {
+		return run.Source, nil, nil
+	}
+	sourcePath := layout.Path(caseFileName(hookPreCompile, "source", "in"))
+	if err := ioutil.WriteFile(sourcePath, []byte(run.Source), 0644); err != nil {
+		return "", nil, err
+	}
+	_, generatedFiles, err := h.preCompile.run(ctx, sandbox, layout, sourcePath, "source", run.Language)
+	if err != nil {
+		return "", generatedFiles, err
+	}
+	source, err := ioutil.ReadFile(layout.CaseOut(hookPreCompile, "source"))
+	if err != nil {
+		return "", generatedFiles, err
+	}
+	return string(source), generatedFiles, nil
+}
+
+// caseInput returns the path of the input of a case that the programs and the
+// validator receive, which is the output of the pre-case hook if there is
+// one.
+func (h *gradingHooks) caseInput(input common.Input, layout *RunLayout, caseName string) string {
+	if h == nil || h.preCase == nil {
+		return path.Join(input.Path(), "cases", fmt.Sprintf("%s.in", caseName))
+	}
+	return layout.CaseOut(hookPreCase, caseName)
+}
+
+// caseOutput returns the path of the output of the submission in a case that
+// is validated, which is the output of the post-case hook if there is one.
+func (h *gradingHooks) caseOutput(layout *RunLayout, caseName string) string {
+	if h == nil || h.postCase == nil {
+		return layout.CaseOut("", caseName)
+	}
+	return layout.CaseOut(hookPostCase, caseName)
+}
+
+// runPreCase runs the pre-case hook, if there is one, with the input of a
+// case. It returns nil metadata if there is no hook.
+func (h *gradingHooks) runPreCase(
+	ctx *common.Context,
+	sandbox Sandbox,
+	input common.Input,
+	layout *RunLayout,
+	run *common.Run,
+	caseName string,
+) (*RunMetadata, []string, error) {
+	if h == nil || h.preCase == nil {
+		return nil, nil, nil
+	}
+	extractCase(ctx, input, caseName)
+	return h.preCase.run(
+		ctx,
+		sandbox,
+		layout,
+		path.Join(input.Path(), "cases", fmt.Sprintf("%s.in", caseName)),
+		caseName,
+		run.Language,
+	)
+}
+
+// runPostCase runs the post-case hook, if there is one, with the output of
+// the submission in a case. It returns nil metadata if there is no hook.
+func (h *gradingHooks) runPostCase(
+	ctx *common.Context,
+	sandbox Sandbox,
+	layout *RunLayout,
+	run *common.Run,
+	caseName string,
+) (*RunMetadata, []string, error) {
+	if h == nil || h.postCase == nil {
+		return nil, nil, nil
+	}
+	return h.postCase.run(ctx, sandbox, layout, layout.CaseOut("", caseName), caseName, run.Language)
+}
+
+// scorerCase is the result of a case that the scorer receives.
+type scorerCase struct {
+	Name    string         `json:"name"`
+	Verdict common.Verdict `json:"verdict"`
+	// Score is the score that the validator gave to the case, between 0 and
+	// 1.
+	Score float64 `json:"score"`
+	// Weight is the fraction of the score of the problem that the case is
+	// worth.
+	Weight float64     `json:"weight"`
+	Meta   RunMetadata `json:"meta"`
+}
+
+// scorerGroup is the result of a group that the scorer receives.
+type scorerGroup struct {
+	Name string `json:"name"`
+	// Score is the fraction of the score of the problem that the group got
+	// with its score policy.
+	Score float64      `json:"score"`
+	Cases []scorerCase `json:"cases"`
+}
+
+// runScorer runs the scorer, if there is one, with the results of all the
+// cases as JSON in its standard input. The scorer writes the score of the
+// run, between 0 and 1, optionally followed by pairs of the name of a group
+// and its score as a fraction of the score of the problem. The scores of the
+// run and the groups are replaced with those, and the verdict of runs that
+// only failed validation follows the new score.
+func (h *gradingHooks) runScorer(
+	ctx *common.Context,
+	sandbox Sandbox,
+	layout *RunLayout,
+	run *common.Run,
+	settings *common.ProblemSettings,
+	totalWeightFactor *big.Rat,
+	runResult *RunResult,
+) ([]string, error) {
+	if h == nil || h.scorer == nil {
+		return nil, nil
+	}
+	groups := make([]scorerGroup, len(runResult.Groups))
+	for i, group := range runResult.Groups {
+		groups[i] = scorerGroup{
+			Name:  group.Group,
+			Score: base.RationalToFloat(group.Score),
+			Cases: make([]scorerCase, len(group.Cases)),
+		}
+		for j, caseResult := range group.Cases {
+			groups[i].Cases[j] = scorerCase{
+				Name:    caseResult.Name,
+				Verdict: caseResult.Verdict,
+				Score:   base.RationalToFloat(caseResult.Score),
+				Weight: base.RationalToFloat(new(big.Rat).Mul(
+					settings.Cases[i].Cases[j].Weight,
+					totalWeightFactor,
+				)),
+				Meta: caseResult.Meta,
+			}
+		}
+	}
+	results, err := json.Marshal(&struct {
+		Groups []scorerGroup `json:"groups"`
+	}{groups})
+	if err != nil {
+		return nil, err
+	}
+	resultsPath := layout.Path(caseFileName(hookScorer, "results", "in"))
+	if err := ioutil.WriteFile(resultsPath, results, 0644); err != nil {
+		return nil, err
+	}
+	_, generatedFiles, err := h.scorer.run(ctx, sandbox, layout, resultsPath, "results", run.Language)
+	if err != nil {
+		return generatedFiles, err
+	}
+	output, err := ioutil.ReadFile(layout.CaseOut(hookScorer, "results"))
+	if err != nil {
+		return generatedFiles, err
+	}
+	score, groupScores, err := parseScorerOutput(string(output), runResult.Groups)
+	if err != nil {
+		return generatedFiles, fmt.Errorf("invalid output of the scorer: %w", err)
+	}
+
+	runResult.Score = score
+	for i := range runResult.Groups {
+		if groupScore, ok := groupScores[runResult.Groups[i].Group]; ok {
+			runResult.Groups[i].Score = groupScore
+			runResult.Groups[i].ContestScore = new(big.Rat).Mul(runResult.MaxScore, groupScore)
+		}
+	}
+	switch runResult.Verdict {
+	case common.VerdictAccepted, common.VerdictPartiallyAccepted, common.VerdictWrongAnswer:
+		switch {
+		case score.Cmp(big.NewRat(1, 1)) == 0:
+			runResult.Verdict = common.VerdictAccepted
+		case score.Sign() == 0:
+			runResult.Verdict = common.VerdictWrongAnswer
+		default:
+			runResult.Verdict = common.VerdictPartiallyAccepted
+		}
+	}
+	return generatedFiles, nil
+}
+
+// parseScorerOutput returns the score of the run and of the groups in the
+// output of the scorer. All the scores are clamped between 0 and 1.
+func parseScorerOutput(output string, groups []GroupResult) (*big.Rat, map[string]*big.Rat, error) {
+	tokens := strings.Fields(output)
+	if len(tokens) == 0 || len(tokens)%2 != 1 {
+		return nil, nil, fmt.Errorf("expected a score and pairs of groups and scores, got %d tokens", len(tokens))
+	}
+	score, err := base.ParseRational(tokens[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	groupScores := make(map[string]*big.Rat)
+	for i := 1; i < len(tokens); i += 2 {
+		known := false
+		for _, group := range groups {
+			if group.Group == tokens[i] {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, nil, fmt.Errorf("unknown group %q", tokens[i])
+		}
+		groupScore, err := base.ParseRational(tokens[i+1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("group %q: %w", tokens[i], err)
+		}
+		groupScores[tokens[i]] = ratClamp(groupScore, &big.Rat{}, big.NewRat(1, 1))
+	}
+	return ratClamp(score, &big.Rat{}, big.NewRat(1, 1)), groupScores, nil
+}
@@ -0,0 +1,291 @@
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/omegaup/quark/common"
+)
+
+// hooksTestInput is an Input with files on disk and the provided settings.
+type hooksTestInput struct {
+	common.Input
+	path     string
+	settings *common.ProblemSettings
+}
+
+func (input *hooksTestInput) Path() string {
+	return input.path
+}
+
+func (input *hooksTestInput) Settings() *common.ProblemSettings {
+	return input.settings
+}
+
+// hooksSandbox pretends to run the hooks and the contestant's program: the
+// pre-compile hook appends a comment to the source, the pre-case hook
+// uppercases the input, the program echoes its input, and the post-case hook
+// appends an exclamation mark to the output. The scorer writes scorerOutput,
+// and its input is kept in scorerInput.
+type hooksSandbox struct {
+	NoopSandbox
+	compiledSources map[string]string
+	metas           map[string]*RunMetadata
+	scorerInput     string
+	scorerOutput    string
+}
+
+func (sandbox *hooksSandbox) Compile(
+	ctx *common.Context,
+	lang string,
+	inputFiles []string,
+	chdir, outputFile, errorFile, metaFile, target string,
+	extraFlags []string,
+) (*RunMetadata, error) {
+	source, err := ioutil.ReadFile(inputFiles[0])
+	if err != nil {
+		return nil, err
+	}
+	sandbox.compiledSources[path.Base(path.Dir(chdir))] = string(source)
+	return sandbox.NoopSandbox.Compile(ctx, lang, inputFiles, chdir, outputFile, errorFile, metaFile, target, extraFlags)
+}
+
+func (sandbox *hooksSandbox) Run(
+	ctx *common.Context,
+	limits *common.LimitsSettings,
+	lang, chdir, inputFile, outputFile, errorFile, metaFile, target string,
+	originalInputFile, originalOutputFile, runMetaFile *string,
+	extraParams []string,
+	extraMountPoints map[string]string,
+) (*RunMetadata, error) {
+	name := path.Base(path.Dir(chdir))
+	if meta, ok := sandbox.metas[name]; ok {
+		return meta, nil
+	}
+	if _, err := sandbox.NoopSandbox.Run(
+		ctx, limits, lang, chdir, inputFile, outputFile, errorFile, metaFile, target,
+		originalInputFile, originalOutputFile, runMetaFile, extraParams, extraMountPoints,
+	); err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	output := string(contents)
+	switch name {
+	case hookPreCompile:
+		output += "\n# hooked"
+	case hookPreCase:
+		output = strings.ToUpper(output)
+	case hookPostCase:
+		output += "!"
+	case hookScorer:
+		sandbox.scorerInput = output
+		output = sandbox.scorerOutput
+	}
+	if err := ioutil.WriteFile(outputFile, []byte(output), 0644); err != nil {
+		return nil, err
+	}
+	return &RunMetadata{Verdict: common.VerdictOK, OutputSize: 1}, nil
+}
+
+func TestGradeHooks(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	inputPath := path.Join(ctx.Config.Runner.RuntimePath, "input")
+	for name, contents := range map[string]string{
+		"cases/0.in":            "abc",
+		"cases/0.out":           "ABC!",
+		"hooks/pre-compile.py3": "",
+		"hooks/pre-case.py3":    "",
+		"hooks/post-case.py3":   "",
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(inputPath, name)), 0755); err != nil {
+			t.Fatalf("Failed to create the input directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path.Join(inputPath, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	input := &hooksTestInput{
+		path: inputPath,
+		settings: &common.ProblemSettings{
+			Cases: []common.GroupSettings{
+				{
+					Name:  "0",
+					Cases: []common.CaseSettings{{Name: "0", Weight: big.NewRat(1, 1)}},
+				},
+			},
+			Limits:    common.DefaultLimits,
+			Validator: common.ValidatorSettings{Name: common.ValidatorNameToken},
+			Hooks: &common.HooksSettings{
+				PreCompile: &common.HookSettings{Lang: "py3"},
+				PreCase:    &common.HookSettings{Lang: "py3"},
+				PostCase:   &common.HookSettings{Lang: "py3"},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		metas   map[string]*RunMetadata
+		verdict common.Verdict
+	}{
+		{"hooks", map[string]*RunMetadata{}, common.VerdictAccepted},
+		{
+			"failed hook",
+			map[string]*RunMetadata{hookPostCase: {Verdict: common.VerdictRuntimeError}},
+			common.VerdictJudgeError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sandbox := &hooksSandbox{
+				compiledSources: make(map[string]string),
+				metas:           tc.metas,
+			}
+			result, err := Grade(
+				ctx,
+				ioutil.Discard,
+				&common.Run{
+					AttemptID: 1,
+					Source:    "print(input())",
+					Language:  "py3",
+					MaxScore:  big.NewRat(1, 1),
+				},
+				input,
+				sandbox,
+			)
+			if err != nil {
+				t.Fatalf("Failed to grade: %v", err)
+			}
+			if result.Verdict != tc.verdict {
+				t.Errorf("verdict == %q, want %q", result.Verdict, tc.verdict)
+			}
+			if source := sandbox.compiledSources["Main"]; source != "print(input())\n# hooked" {
+				t.Errorf("compiled source == %q, want the output of the pre-compile hook", source)
+			}
+			for _, point := range []string{hookPreCompile, hookPreCase, hookPostCase} {
+				if _, ok := result.CompileMeta[point]; !ok {
+					t.Errorf("missing the compile metadata of the %s hook: %v", point, result.CompileMeta)
+				}
+			}
+		})
+	}
+}
+
+func TestGradeScorer(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	inputPath := path.Join(ctx.Config.Runner.RuntimePath, "input")
+	for name, contents := range map[string]string{
+		"cases/0.in":       "a",
+		"cases/0.out":      "a",
+		"cases/1.in":       "b",
+		"cases/1.out":      "c",
+		"hooks/scorer.py3": "",
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(inputPath, name)), 0755); err != nil {
+			t.Fatalf("Failed to create the input directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path.Join(inputPath, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	input := &hooksTestInput{
+		path: inputPath,
+		settings: &common.ProblemSettings{
+			Cases: []common.GroupSettings{
+				{
+					Name:  "0",
+					Cases: []common.CaseSettings{{Name: "0", Weight: big.NewRat(1, 1)}},
+				},
+				{
+					Name:  "1",
+					Cases: []common.CaseSettings{{Name: "1", Weight: big.NewRat(3, 1)}},
+				},
+			},
+			NormalizeWeights: true,
+			Limits:           common.DefaultLimits,
+			Validator:        common.ValidatorSettings{Name: common.ValidatorNameToken},
+			Hooks: &common.HooksSettings{
+				Scorer: &common.HookSettings{Lang: "py3"},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name         string
+		scorerOutput string
+		verdict      common.Verdict
+		score        *big.Rat
+		groupScores  []*big.Rat
+	}{
+		{"score", "0.5", common.VerdictPartiallyAccepted, big.NewRat(1, 2), []*big.Rat{big.NewRat(1, 4), &big.Rat{}}},
+		{"group scores", "1\n0 0.25\n1 0.75\n", common.VerdictAccepted, big.NewRat(1, 1), []*big.Rat{big.NewRat(1, 4), big.NewRat(3, 4)}},
+		{"zero", "0", common.VerdictWrongAnswer, &big.Rat{}, []*big.Rat{big.NewRat(1, 4), &big.Rat{}}},
+		{"unknown group", "1\n2 1", common.VerdictJudgeError, &big.Rat{}, []*big.Rat{big.NewRat(1, 4), &big.Rat{}}},
+		{"malformed", "1\n0", common.VerdictJudgeError, &big.Rat{}, []*big.Rat{big.NewRat(1, 4), &big.Rat{}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sandbox := &hooksSandbox{
+				compiledSources: make(map[string]string),
+				metas:           map[string]*RunMetadata{},
+				scorerOutput:    tc.scorerOutput,
+			}
+			result, err := Grade(
+				ctx,
+				ioutil.Discard,
+				&common.Run{
+					AttemptID: 1,
+					Source:    "print(input())",
+					Language:  "py3",
+					MaxScore:  big.NewRat(1, 1),
+				},
+				input,
+				sandbox,
+			)
+			if err != nil {
+				t.Fatalf("Failed to grade: %v", err)
+			}
+			if result.Verdict != tc.verdict {
+				t.Errorf("verdict == %q, want %q", result.Verdict, tc.verdict)
+			}
+			if result.Score.Cmp(tc.score) != 0 {
+				t.Errorf("score == %v, want %v", result.Score, tc.score)
+			}
+			for i, groupScore := range tc.groupScores {
+				if result.Groups[i].Score.Cmp(groupScore) != 0 {
+					t.Errorf("group %d score == %v, want %v", i, result.Groups[i].Score, groupScore)
+				}
+			}
+
+			var scorerInput struct {
+				Groups []scorerGroup `json:"groups"`
+			}
+			if err := json.Unmarshal([]byte(sandbox.scorerInput), &scorerInput); err != nil {
+				t.Fatalf("Failed to unmarshal the input of the scorer %q: %v", sandbox.scorerInput, err)
+			}
+			if len(scorerInput.Groups) != 2 ||
+				scorerInput.Groups[1].Cases[0].Verdict != common.VerdictWrongAnswer ||
+				scorerInput.Groups[1].Cases[0].Weight != 0.75 {
+				t.Errorf("scorer input == %+v, want the results of the cases", scorerInput)
+			}
+		})
+	}
+}
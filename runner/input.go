@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
@@ -19,27 +20,44 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// inputDownloadAttempts is the number of times an input download is
+	// attempted before giving up.
+	inputDownloadAttempts = 5
+)
+
+var (
+	// inputDownloadRetryDelay is how long to wait before resuming an interrupted
+	// input download. It grows linearly with the number of attempts.
+	inputDownloadRetryDelay = time.Second
 )
 
 // InputFactory is a common.InputFactory that can fetch the test case data from
 // the grader.
 type InputFactory struct {
+	ctx     *common.Context
 	client  *http.Client
-	config  *common.Config
 	baseURL *url.URL
 	problem string
 }
 
-// NewInputFactory returns a new InputFactory.
+// NewInputFactory returns a new InputFactory. Any download made by the Inputs
+// created by this factory will be cancelled if the context is cancelled.
+// Inputs are shared by all the runs that need them, so the context must not
+// be the one of a single run.
 func NewInputFactory(
+	ctx *common.Context,
 	client *http.Client,
-	config *common.Config,
 	baseURL *url.URL,
 	problem string,
 ) common.InputFactory {
 	return &InputFactory{
+		ctx:     ctx,
 		client:  client,
-		config:  config,
 		baseURL: baseURL,
 		problem: problem,
 	}
@@ -67,19 +85,29 @@ func (factory *InputFactory) NewInput(
 				mgr,
 			),
 			path: path.Join(
-				factory.config.Runner.RuntimePath,
+				factory.ctx.Config.Runner.RuntimePath,
 				"input",
 				fmt.Sprintf("%s/%s", hash[:2], hash[2:]),
 			),
 		},
-		client:     factory.client,
-		requestURL: requestURL.String(),
+		ctx:                      factory.ctx.Context,
+		client:                   factory.client,
+		requestURL:               requestURL.String(),
+		segmentedDownloadMinSize: int64(factory.ctx.Config.Runner.InputSegmentedDownloadMinSize),
+		downloadConcurrency:      factory.ctx.Config.Runner.InputDownloadConcurrency,
+		acceptLazyArchive:        factory.ctx.Config.Runner.LazyInputs,
 	}
 }
 
 type runnerBaseInput struct {
 	common.BaseInput
 	path string
+
+	// lazyLock protects lazyArchive, which is only opened for Inputs that were
+	// transmitted as a lazy input archive.
+	lazyLock    sync.Mutex
+	lazyArchive *common.LazyArchive
+	lazyFile    *os.File
 }
 
 func (input *runnerBaseInput) Path() string {
@@ -113,6 +141,9 @@ func (input *runnerBaseInput) Verify() error {
 		}
 		size += stat.Size()
 	}
+	if stat, err := os.Stat(input.lazyArchivePath()); err == nil {
+		size += stat.Size()
+	}
 
 	settingsFd, err := os.Open(path.Join(input.path, "settings.json"))
 	if err != nil {
@@ -128,7 +159,146 @@ func (input *runnerBaseInput) Verify() error {
 	return nil
 }
 
+func (input *runnerBaseInput) lazyArchivePath() string {
+	return fmt.Sprintf("%s.lazy", input.path)
+}
+
+// ExtractCase extracts the .in and .out files of the case from the lazy input
+// archive, if the Input was transmitted as one and they have not been
+// extracted yet.
+func (input *runnerBaseInput) ExtractCase(caseName string) error {
+	input.lazyLock.Lock()
+	defer input.lazyLock.Unlock()
+
+	if input.lazyArchive == nil {
+		f, err := os.Open(input.lazyArchivePath())
+		if os.IsNotExist(err) {
+			// All the files were extracted when the Input was persisted.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		archive, err := common.OpenLazyArchive(f, stat.Size())
+		if err != nil {
+			f.Close()
+			return err
+		}
+		input.lazyFile = f
+		input.lazyArchive = archive
+	}
+
+	for _, extension := range []string{"in", "out"} {
+		name := fmt.Sprintf("cases/%s.%s", caseName, extension)
+		if _, ok := input.lazyArchive.Index[name]; !ok {
+			continue
+		}
+		dst := path.Join(input.path, name)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := input.lazyArchive.Extract(name, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistFromLazyArchive stores the Input from the lazy input archive in f.
+// Only the files that are not case data are extracted, and the archive is kept
+// so that the cases can be extracted as they are needed.
+func (input *runnerBaseInput) persistFromLazyArchive(
+	f *os.File,
+	archive *common.LazyArchive,
+	streamHash string,
+) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hasher := sha1.New()
+	archiveSize, err := io.Copy(hasher, f)
+	if err != nil {
+		return err
+	}
+	if streamHash != fmt.Sprintf("%0x", hasher.Sum(nil)) {
+		return fmt.Errorf(
+			"hash mismatch: expected %s got %s",
+			streamHash,
+			fmt.Sprintf("%0x", hasher.Sum(nil)),
+		)
+	}
+
+	tmpPath := fmt.Sprintf("%s.tmp", input.path)
+	if err := os.MkdirAll(tmpPath, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	sha1sumFile, err := os.Create(fmt.Sprintf("%s.sha1", input.path))
+	if err != nil {
+		return err
+	}
+	defer sha1sumFile.Close()
+
+	size := archiveSize
+	for _, name := range archive.Names() {
+		if common.IsLazyCaseFile(name) {
+			continue
+		}
+		if err := archive.Extract(name, path.Join(tmpPath, name)); err != nil {
+			return err
+		}
+		entry := archive.Index[name]
+		_, err = fmt.Fprintf(
+			sha1sumFile,
+			"%s *%s/%s\n",
+			entry.SHA1,
+			input.Hash()[2:],
+			name,
+		)
+		if err != nil {
+			return err
+		}
+		size += entry.Size
+	}
+
+	settingsFd, err := os.Open(path.Join(tmpPath, "settings.json"))
+	if err != nil {
+		return err
+	}
+	defer settingsFd.Close()
+	decoder := json.NewDecoder(settingsFd)
+	if err := decoder.Decode(input.Settings()); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), input.lazyArchivePath()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, input.path); err != nil {
+		os.Remove(input.lazyArchivePath())
+		return err
+	}
+
+	input.Commit(size)
+
+	return nil
+}
+
 func (input *runnerBaseInput) Delete() error {
+	input.lazyLock.Lock()
+	if input.lazyFile != nil {
+		input.lazyFile.Close()
+		input.lazyFile = nil
+		input.lazyArchive = nil
+	}
+	input.lazyLock.Unlock()
+	os.Remove(input.lazyArchivePath())
 	os.RemoveAll(fmt.Sprintf("%s.tmp", input.path))
 	os.RemoveAll(fmt.Sprintf("%s.sha1", input.path))
 	return os.RemoveAll(input.path)
@@ -285,31 +455,308 @@ func (input *runnerBaseInput) persistFromTarStream(
 // Input is a common.Input that can fetch the test case data from the grader.
 type Input struct {
 	runnerBaseInput
+	ctx        context.Context
 	requestURL string
 	client     *http.Client
+
+	// Archives of at least segmentedDownloadMinSize bytes are downloaded in
+	// downloadConcurrency byte ranges in parallel.
+	segmentedDownloadMinSize int64
+	downloadConcurrency      int
+
+	// acceptLazyArchive makes the grader send the Input as a lazy input
+	// archive, if it supports it.
+	acceptLazyArchive bool
 }
 
-// Persist stores the Input into the filesystem.
+// archiveInfo is the information about the compressed archive of an Input
+// that the grader sends along with it.
+type archiveInfo struct {
+	size             int64
+	streamHash       string
+	uncompressedSize int64
+}
+
+// offsetWriter is an io.Writer that writes to a file starting at an offset.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(b []byte) (int, error) {
+	n, err := w.f.WriteAt(b, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// Persist stores the Input into the filesystem. The compressed archive is
+// downloaded into a temporary file first, so that transient network errors
+// only require the missing part of the archive to be requested again.
 func (input *Input) Persist() error {
-	resp, err := input.client.Get(input.requestURL)
+	if err := os.MkdirAll(path.Dir(input.path), 0755); err != nil {
+		return err
+	}
+	downloadPath := fmt.Sprintf("%s.download", input.path)
+	f, err := os.OpenFile(downloadPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer os.Remove(downloadPath)
+	defer f.Close()
 
-	uncompressedSize, err := strconv.ParseInt(
-		resp.Header.Get("X-Content-Uncompressed-Size"), 10, 64,
-	)
+	var streamHash string
+	var uncompressedSize int64
+	if info, ok := input.segmentedDownloadInfo(); ok {
+		streamHash, uncompressedSize = info.streamHash, info.uncompressedSize
+		err = input.downloadSegmented(f, info)
+	} else {
+		streamHash, uncompressedSize, err = input.download(f)
+	}
 	if err != nil {
-		return errors.Wrap(err, "failed to parse the X-Content-Uncompressed-Size header")
+		return err
+	}
+	if stat, err := f.Stat(); err == nil {
+		if archive, err := common.OpenLazyArchive(f, stat.Size()); err == nil {
+			return input.persistFromLazyArchive(f, archive, streamHash)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
 
 	return input.persistFromTarStream(
-		resp.Body,
+		f,
 		"gzip",
 		uncompressedSize,
-		resp.Header.Get("Content-SHA1"),
+		streamHash,
+	)
+}
+
+// newRequest creates a request for the Input's archive.
+func (input *Input) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, input.requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if input.acceptLazyArchive {
+		req.Header.Set("Accept", common.LazyArchiveContentType+", application/x-gzip")
+	}
+	return req, nil
+}
+
+// withDownloadRetries calls fn until it succeeds, it returns an error that
+// cannot be retried, or inputDownloadAttempts attempts have been made.
+func (input *Input) withDownloadRetries(ctx context.Context, fn func() (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		retry, err := fn()
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= inputDownloadAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * inputDownloadRetryDelay):
+		}
+	}
+}
+
+// segmentedDownloadInfo returns the information about the archive if it is
+// large enough to be downloaded in parallel segments and the grader supports
+// Range requests.
+func (input *Input) segmentedDownloadInfo() (*archiveInfo, bool) {
+	if input.segmentedDownloadMinSize <= 0 || input.downloadConcurrency <= 1 {
+		return nil, false
+	}
+	req, err := input.newRequest(input.ctx, "HEAD")
+	if err != nil {
+		return nil, false
+	}
+	resp, err := input.client.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("Accept-Ranges") != "bytes" ||
+		resp.ContentLength < input.segmentedDownloadMinSize {
+		return nil, false
+	}
+	uncompressedSize, err := strconv.ParseInt(
+		resp.Header.Get("X-Content-Uncompressed-Size"), 10, 64,
 	)
+	if err != nil {
+		return nil, false
+	}
+	return &archiveInfo{
+		size:             resp.ContentLength,
+		streamHash:       resp.Header.Get("Content-SHA1"),
+		uncompressedSize: uncompressedSize,
+	}, true
+}
+
+// downloadSegmented fetches the compressed archive into f by splitting it into
+// downloadConcurrency byte ranges that are downloaded in parallel. Each range
+// is resumed independently after a transient error.
+func (input *Input) downloadSegmented(f *os.File, info *archiveInfo) error {
+	if err := f.Truncate(info.size); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(input.ctx)
+	defer cancel()
+
+	segmentSize := (info.size + int64(input.downloadConcurrency) - 1) / int64(input.downloadConcurrency)
+	var wg sync.WaitGroup
+	errs := make(chan error, input.downloadConcurrency)
+	for start := int64(0); start < info.size; start += segmentSize {
+		end := start + segmentSize
+		if end > info.size {
+			end = info.size
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			offset := start
+			err := input.withDownloadRetries(ctx, func() (bool, error) {
+				var retry bool
+				var err error
+				offset, retry, err = input.downloadRange(ctx, f, offset, end, info.streamHash)
+				return retry, err
+			})
+			if err != nil {
+				errs <- err
+				cancel()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// downloadRange requests the [offset, end) byte range of the archive and
+// writes it into the same range of f. It returns the offset up to which the
+// range was written and whether the download can be retried.
+func (input *Input) downloadRange(
+	ctx context.Context,
+	f *os.File,
+	offset int64,
+	end int64,
+	streamHash string,
+) (int64, bool, error) {
+	req, err := input.newRequest(ctx, "GET")
+	if err != nil {
+		return offset, false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end-1))
+	resp, err := input.client.Do(req)
+	if err != nil {
+		return offset, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return offset, resp.StatusCode >= 500, errors.Errorf(
+			"unexpected status code for range request: %d",
+			resp.StatusCode,
+		)
+	}
+	if resp.Header.Get("Content-SHA1") != streamHash {
+		return offset, false, errors.New("input archive changed while downloading")
+	}
+
+	w := &offsetWriter{f: f, offset: offset}
+	_, err = io.Copy(w, io.LimitReader(resp.Body, end-offset))
+	if err == nil && w.offset < end {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return w.offset, ctx.Err() == nil, errors.Wrap(err, "failed to download the input")
+	}
+	return w.offset, false, nil
+}
+
+// download fetches the compressed archive into f, resuming it with Range
+// requests after a transient error. It returns the hash of the archive (which
+// is verified once it is extracted) and its uncompressed size.
+func (input *Input) download(f *os.File) (string, int64, error) {
+	var streamHash string
+	var uncompressedSize int64
+	var offset int64
+	err := input.withDownloadRetries(input.ctx, func() (bool, error) {
+		var retry bool
+		var err error
+		offset, retry, err = input.downloadFrom(f, offset, &streamHash, &uncompressedSize)
+		return retry, err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return streamHash, uncompressedSize, nil
+}
+
+// downloadFrom requests the archive starting at offset and appends it to f.
+// It returns the new offset and whether the download can be retried.
+func (input *Input) downloadFrom(
+	f *os.File,
+	offset int64,
+	streamHash *string,
+	uncompressedSize *int64,
+) (int64, bool, error) {
+	req, err := input.newRequest(input.ctx, "GET")
+	if err != nil {
+		return offset, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := input.client.Do(req)
+	if err != nil {
+		return offset, input.ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Either this is the first request or the server ignored the Range
+		// header, so the whole archive is being sent.
+		parsedSize, err := strconv.ParseInt(
+			resp.Header.Get("X-Content-Uncompressed-Size"), 10, 64,
+		)
+		if err != nil {
+			return 0, false, errors.Wrap(err, "failed to parse the X-Content-Uncompressed-Size header")
+		}
+		*streamHash = resp.Header.Get("Content-SHA1")
+		*uncompressedSize = parsedSize
+		offset = 0
+		if err := f.Truncate(0); err != nil {
+			return 0, false, err
+		}
+	case http.StatusPartialContent:
+		if resp.Header.Get("Content-SHA1") != *streamHash {
+			// The archive changed between requests, so start over.
+			if err := f.Truncate(0); err != nil {
+				return 0, false, err
+			}
+			return 0, true, errors.New("input archive changed while downloading")
+		}
+	default:
+		return offset, resp.StatusCode >= 500, errors.Errorf(
+			"non-2xx error code returned: %d",
+			resp.StatusCode,
+		)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, false, err
+	}
+	n, err := io.Copy(f, resp.Body)
+	offset += n
+	if err != nil {
+		return offset, input.ctx.Err() == nil, errors.Wrap(err, "failed to download the input")
+	}
+	return offset, false, nil
 }
 
 // Delete removes the filesystem files for the Input.
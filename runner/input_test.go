@@ -1,7 +1,9 @@
 package runner
 
 import (
+	"bytes"
 	"encoding/base64"
+	"fmt"
 	"github.com/omegaup/quark/common"
 	"io/ioutil"
 	"math/big"
@@ -10,9 +12,12 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 	"unicode"
 )
 
@@ -311,7 +316,7 @@ func TestInputFactory(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	factory := NewInputFactory(http.DefaultClient, &ctx.Config, baseURL, "")
+	factory := NewInputFactory(ctx, http.DefaultClient, baseURL, "")
 	for _, het := range hashentries {
 		inputRef, err := inputManager.Add(het.hash, factory)
 		if het.valid {
@@ -328,3 +333,119 @@ func TestInputFactory(t *testing.T) {
 		}
 	}
 }
+
+func TestInputFactoryResume(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	ctx.Config.Runner.InputSegmentedDownloadMinSize = 0
+
+	originalRetryDelay := inputDownloadRetryDelay
+	inputDownloadRetryDelay = time.Millisecond
+	defer func() { inputDownloadRetryDelay = originalRetryDelay }()
+
+	content := mustDecode(
+		`H4sIAFBMXVYAA+2WzU+DMBTAd93+CsJZWUspEK/Gmx+HLXowHppZZx0UA2VqFv53X1HYXDKXmDCi
+		vt+B0r6Pvva1D5QeD7qGABHntqURJ5ttw9d3ShllA4d3HhlQFkbkjjNYinkutNmpt0/+S1F6TDyl
+		O53DJjUMgm/yz7bzH0WQf9JpVJ/88/xTx+87BKRHstJ0/gH4Qf3nNMD6fwhs/okHzw7n2F//6Vb+
+		fYb1/zCwvgNAeqWQxig9L7ynIuvqL3Df/Q/hZ5/6oR/5Aec+1ALKOOF4/w/BauQ47qkoZOGeOLej
+		4XC17q3cS5FKeHOJe+S4N1LNHw10qUeqOxjYLQWnoGBdn6tUGevNTgT9s1eTixuRJFNVG5NaDQQX
+		Ms3yt1odhsOIkjgOg0Z6VZrn0jRSGrJ4LVrKHPw1PlsP9mQ1OhMjZovWmgQxj8JWuGnFNoyuRaLu
+		hcnybQUQVx+rmyTZCww+iKSQMDJc29gVtxtksoXUx7pMZa5mdrOmWQJB61m9Ax5ch2pUjfo+CgiC
+		IAiCIAiCIAiCIAiCIAiC/BHeAU4V1PQAKAAA`,
+	)
+	var rangeHeaders []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeaders = append(rangeHeaders, r.Header.Get("Range"))
+		w.Header().Set("Content-Type", "application/x-gzip")
+		w.Header().Set("Content-SHA1", "a32f0f018441df71efc8d1c6a55391354a8d4897")
+		w.Header().Set("X-Content-Uncompressed-Size", "20")
+		if len(rangeHeaders) == 1 {
+			// Simulate a connection that drops halfway through the download.
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	baseURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+	inputManager := common.NewInputManager(ctx)
+	inputRef, err := inputManager.Add(
+		"4bba61b5499a7a511eb515594f3293a8741516ad",
+		NewInputFactory(ctx, ts.Client(), baseURL, ""),
+	)
+	if err != nil {
+		t.Fatalf("Input creation failed with %q", err)
+	}
+	inputRef.Release()
+
+	expectedRangeHeaders := []string{"", fmt.Sprintf("bytes=%d-", len(content)/2)}
+	if !reflect.DeepEqual(expectedRangeHeaders, rangeHeaders) {
+		t.Errorf("Range headers == %q, want %q", rangeHeaders, expectedRangeHeaders)
+	}
+}
+
+func TestInputFactorySegmented(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+	ctx.Config.Runner.InputSegmentedDownloadMinSize = 1
+	ctx.Config.Runner.InputDownloadConcurrency = 3
+
+	content := mustDecode(
+		`H4sIAFBMXVYAA+2WzU+DMBTAd93+CsJZWUspEK/Gmx+HLXowHppZZx0UA2VqFv53X1HYXDKXmDCi
+		vt+B0r6Pvva1D5QeD7qGABHntqURJ5ttw9d3ShllA4d3HhlQFkbkjjNYinkutNmpt0/+S1F6TDyl
+		O53DJjUMgm/yz7bzH0WQf9JpVJ/88/xTx+87BKRHstJ0/gH4Qf3nNMD6fwhs/okHzw7n2F//6Vb+
+		fYb1/zCwvgNAeqWQxig9L7ynIuvqL3Df/Q/hZ5/6oR/5Aec+1ALKOOF4/w/BauQ47qkoZOGeOLej
+		4XC17q3cS5FKeHOJe+S4N1LNHw10qUeqOxjYLQWnoGBdn6tUGevNTgT9s1eTixuRJFNVG5NaDQQX
+		Ms3yt1odhsOIkjgOg0Z6VZrn0jRSGrJ4LVrKHPw1PlsP9mQ1OhMjZovWmgQxj8JWuGnFNoyuRaLu
+		hcnybQUQVx+rmyTZCww+iKSQMDJc29gVtxtksoXUx7pMZa5mdrOmWQJB61m9Ax5ch2pUjfo+CgiC
+		IAiCIAiCIAiCIAiCIAiC/BHeAU4V1PQAKAAA`,
+	)
+	var lock sync.Mutex
+	var rangeRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			lock.Lock()
+			rangeRequests++
+			lock.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/x-gzip")
+		w.Header().Set("Content-SHA1", "a32f0f018441df71efc8d1c6a55391354a8d4897")
+		w.Header().Set("X-Content-Uncompressed-Size", "20")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	baseURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+	inputManager := common.NewInputManager(ctx)
+	inputRef, err := inputManager.Add(
+		"4bba61b5499a7a511eb515594f3293a8741516ad",
+		NewInputFactory(ctx, ts.Client(), baseURL, ""),
+	)
+	if err != nil {
+		t.Fatalf("Input creation failed with %q", err)
+	}
+	inputRef.Release()
+
+	if rangeRequests != 3 {
+		t.Errorf("rangeRequests == %d, want 3", rangeRequests)
+	}
+}
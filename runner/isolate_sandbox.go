@@ -0,0 +1,564 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+// IsolateSandbox is an implementation of a Sandbox that uses the isolate
+// sandbox (https://github.com/ioi/isolate), for the systems where omegajail
+// cannot be installed. Since isolate does not know about languages, the
+// commands that compile and run each one of them are configurable.
+//
+// Each program is run in its own isolate box. Compilations copy the sources
+// into the box and copy everything back to the directory of the binary
+// afterwards, and runs mount the directory of the binary read-only in the
+// same path within the box.
+type IsolateSandbox struct {
+	config common.IsolateConfig
+	boxes  chan int
+}
+
+var _ Sandbox = &IsolateSandbox{}
+
+// NewIsolateSandbox creates a new IsolateSandbox.
+func NewIsolateSandbox(config common.IsolateConfig) *IsolateSandbox {
+	boxCount := config.Boxes
+	if boxCount < 1 {
+		boxCount = 1
+	}
+	boxes := make(chan int, boxCount)
+	for i := 0; i < boxCount; i++ {
+		boxes <- config.FirstBoxID + i
+	}
+	return &IsolateSandbox{
+		config: config,
+		boxes:  boxes,
+	}
+}
+
+// Supported returns whether the isolate binary is installed in the system.
+func (s *IsolateSandbox) Supported() bool {
+	_, err := exec.LookPath(s.config.Binary)
+	return err == nil
+}
+
+// Compile compiles the contestant-supplied program using the specified
+// configuration using the isolate sandbox.
+func (s *IsolateSandbox) Compile(
+	ctx *common.Context,
+	lang string,
+	inputFiles []string,
+	chdir, outputFile, errorFile, metaFile, target string,
+	extraFlags []string,
+) (*RunMetadata, error) {
+	language, err := s.language(ctx, lang)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	box, err := s.acquireBox(ctx)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	defer s.releaseBox(ctx, box)
+
+	sources := make([]string, 0, len(inputFiles))
+	for _, inputFile := range inputFiles {
+		if !strings.HasPrefix(inputFile, chdir) {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, errors.Errorf("file %q is not within the chroot", inputFile)
+		}
+		rel, err := filepath.Rel(chdir, inputFile)
+		if err != nil {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, err
+		}
+		if err := copyBoxFile(inputFile, path.Join(box.root, rel)); err != nil {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, err
+		}
+		sources = append(sources, rel)
+	}
+
+	compileTimeLimit := time.Duration(ctx.Config.Runner.CompileTimeLimit)
+	err = s.invoke(ctx, box, &isolateInvocation{
+		command:   expandSandboxCommand(language.Compile, target, sources, extraFlags),
+		time:      compileTimeLimit,
+		wallTime:  compileTimeLimit,
+		fileSize:  ctx.Config.Runner.CompileOutputLimit,
+		processes: -1,
+		stdin:     "/dev/null",
+		stdout:    outputFile,
+		stderr:    errorFile,
+		metaFile:  metaFile,
+	})
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	// Everything the compiler produced goes next to the sources, just like
+	// with omegajail.
+	entries, err := ioutil.ReadDir(box.root)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		if err := copyBoxFile(path.Join(box.root, entry.Name()), path.Join(chdir, entry.Name())); err != nil {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, err
+		}
+	}
+
+	metaFd, err := os.Open(metaFile)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	defer metaFd.Close()
+	metadata, err := parseMetaFile(ctx, nil, lang, metaFd, &outputFile, nil, false)
+
+	if lang == "java" && metadata.Verdict == common.VerdictOK {
+		if err := checkJavaClass(chdir, target, errorFile, metadata); err != nil {
+			return metadata, err
+		}
+	}
+
+	return metadata, err
+}
+
+// Run invokes the contestant-supplied program against a specified input and
+// run configuration using the isolate sandbox.
+func (s *IsolateSandbox) Run(
+	ctx *common.Context,
+	limits *common.LimitsSettings,
+	lang, chdir, inputFile, outputFile, errorFile, metaFile, target string,
+	originalInputFile, originalOutputFile, runMetaFile *string,
+	extraParams []string,
+	extraMountPoints map[string]string,
+) (*RunMetadata, error) {
+	language, err := s.language(ctx, lang)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	sandboxLimits := ctx.Config.Runner.SandboxLimits(lang, limits)
+	timeLimit := time.Duration(sandboxLimits.TimeLimit)
+	extraWallTime := time.Duration(sandboxLimits.ExtraWallTime)
+
+	if err := copyRunFiles(chdir, originalInputFile, originalOutputFile, runMetaFile); err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	// Create intermediate directories, if needed.
+	if err := os.MkdirAll(path.Dir(outputFile), 0o755); err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	invocation := &isolateInvocation{
+		command:   sandboxRunCommand(ctx, language, lang, target, limits, extraParams),
+		chdir:     chdir,
+		dirs:      []string{fmt.Sprintf("%s=%s", chdir, chdir)},
+		time:      timeLimit,
+		wallTime:  timeLimit + extraWallTime,
+		memory:    sandboxLimits.MemoryLimit,
+		fileSize:  sandboxFileSizeLimit(limits.OutputLimit),
+		processes: language.Processes,
+		stdin:     inputFile,
+		stdout:    outputFile,
+		stderr:    errorFile,
+		metaFile:  metaFile,
+	}
+	for path, mountTarget := range extraMountPoints {
+		invocation.dirs = append(invocation.dirs, fmt.Sprintf("%s=%s:rw", mountTarget, path))
+	}
+
+	if isJVMLanguage(lang) && ctx.Config.Runner.JavaPolicyTemplate != "" {
+		if err := writeJavaPolicy(ctx.Config.Runner.JavaPolicyTemplate, chdir); err != nil {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, err
+		}
+		invocation.env = append(
+			invocation.env,
+			"JAVA_TOOL_OPTIONS=-Djava.security.manager -Djava.security.policy==java.policy",
+		)
+	}
+
+	preloader, err := newInputPreloader(inputFile)
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to preload input",
+			map[string]any{
+				"file": inputFile,
+				"err":  err,
+			},
+		)
+	} else if preloader != nil {
+		// preloader might be nil, even with no error.
+		preloader.release()
+	}
+
+	box, err := s.acquireBox(ctx)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	defer s.releaseBox(ctx, box)
+
+	if err := s.invoke(ctx, box, invocation); err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	metaFd, err := os.Open(metaFile)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	defer metaFd.Close()
+	meta, err := parseMetaFile(ctx, limits, lang, metaFd, &outputFile, &errorFile, lang == "c")
+	if err == nil && invocation.oomKilled && limits.MemoryLimit > 0 {
+		meta.OOMKilled = true
+		meta.Verdict = common.VerdictMemoryLimitExceeded
+		meta.Memory = limits.MemoryLimit
+	}
+	return meta, err
+}
+
+// CompilerVersion returns the version of the compiler that the language uses.
+// The compilers are the ones installed in the system.
+func (s *IsolateSandbox) CompilerVersion(ctx *common.Context, lang string) (string, error) {
+	language, err := s.language(ctx, lang)
+	if err != nil {
+		return "", err
+	}
+	return compilerVersions.Get(ctx, language.Compile)
+}
+
+func (s *IsolateSandbox) language(ctx *common.Context, lang string) (*common.SandboxLanguageConfig, error) {
+	return sandboxLanguage(ctx, s.config.Languages, lang, "isolate")
+}
+
+// isolateBox is an initialized isolate box.
+type isolateBox struct {
+	id int
+
+	// root is the directory that is mounted as /box within the box.
+	root string
+}
+
+func (s *IsolateSandbox) acquireBox(ctx *common.Context) (*isolateBox, error) {
+	var id int
+	select {
+	case id = <-s.boxes:
+	case <-ctx.Context.Done():
+		return nil, ctx.Context.Err()
+	}
+
+	// A previous instance of the runner might have left the box behind.
+	s.command(ctx, id, "--cleanup").Run()
+	output, err := s.command(ctx, id, "--init").Output()
+	if err != nil {
+		s.boxes <- id
+		return nil, errors.Wrapf(err, "failed to initialize isolate box %d", id)
+	}
+	return &isolateBox{
+		id:   id,
+		root: path.Join(strings.TrimSpace(string(output)), "box"),
+	}, nil
+}
+
+func (s *IsolateSandbox) releaseBox(ctx *common.Context, box *isolateBox) {
+	if err := s.command(ctx, box.id, "--cleanup").Run(); err != nil {
+		ctx.Log.Error(
+			"Failed to clean up isolate box",
+			map[string]any{
+				"box": box.id,
+				"err": err,
+			},
+		)
+	}
+	s.boxes <- box.id
+}
+
+// command returns the isolate command that performs an action on a box. It
+// does not use the context, so that boxes can be cleaned up after the context
+// is done.
+func (s *IsolateSandbox) command(ctx *common.Context, boxID int, params ...string) *exec.Cmd {
+	isolateParams := s.boxParams(boxID)
+	isolateParams = append(isolateParams, params...)
+	ctx.Log.Debug(
+		"invoking",
+		map[string]any{
+			"params": shellquote.Join(append([]string{s.config.Binary}, isolateParams...)...),
+		},
+	)
+	return exec.Command(s.config.Binary, isolateParams...)
+}
+
+func (s *IsolateSandbox) boxParams(boxID int) []string {
+	params := []string{fmt.Sprintf("--box-id=%d", boxID)}
+	if s.config.Cgroups {
+		params = append(params, "--cg")
+	}
+	return params
+}
+
+// isolateInvocation is a program that is run within an isolate box.
+type isolateInvocation struct {
+	command []string
+	chdir   string   // the working directory within the box, /box if empty
+	dirs    []string // extra directory rules, in isolate's --dir format
+	env     []string
+
+	time      time.Duration
+	wallTime  time.Duration
+	memory    base.Byte // 0 means unlimited
+	fileSize  base.Byte // 0 means unlimited
+	processes int       // 0 means a single one, negative means unlimited
+
+	stdin, stdout, stderr, metaFile string
+
+	// oomKilled is set if the program was killed for going over the memory
+	// limit of its control group.
+	oomKilled bool
+}
+
+// invoke runs the program in the box and writes its metadata into
+// invocation.metaFile in the format that omegajail uses, so that
+// parseMetaFile can interpret it. An error is returned only if the program
+// could not be run at all.
+func (s *IsolateSandbox) invoke(ctx *common.Context, box *isolateBox, invocation *isolateInvocation) error {
+	isolateMetaFile := invocation.metaFile + ".isolate"
+	defer os.Remove(isolateMetaFile)
+
+	params := s.boxParams(box.id)
+	params = append(
+		params,
+		"--meta="+isolateMetaFile,
+		"--time="+strconv.FormatFloat(invocation.time.Seconds(), 'f', 3, 64),
+		"--wall-time="+strconv.FormatFloat(invocation.wallTime.Seconds(), 'f', 3, 64),
+		"--env=PATH=/usr/local/bin:/usr/bin:/bin",
+	)
+	if invocation.fileSize > 0 {
+		params = append(params, "--fsize="+strconv.FormatInt(kibibytes(invocation.fileSize), 10))
+	}
+	if invocation.memory > 0 {
+		if s.config.Cgroups {
+			params = append(params, "--cg-mem="+strconv.FormatInt(kibibytes(invocation.memory), 10))
+		} else {
+			params = append(params, "--mem="+strconv.FormatInt(kibibytes(invocation.memory), 10))
+		}
+	}
+	if invocation.processes < 0 {
+		params = append(params, "--processes")
+	} else if invocation.processes > 0 {
+		params = append(params, fmt.Sprintf("--processes=%d", invocation.processes))
+	}
+	if invocation.chdir != "" {
+		params = append(params, "--chdir="+invocation.chdir)
+	}
+	for _, dir := range invocation.dirs {
+		params = append(params, "--dir="+dir)
+	}
+	for _, env := range invocation.env {
+		params = append(params, "--env="+env)
+	}
+	params = append(params, "--run", "--")
+	params = append(params, invocation.command...)
+	ctx.Log.Debug(
+		"invoking",
+		map[string]any{
+			"params": shellquote.Join(append([]string{s.config.Binary}, params...)...),
+		},
+	)
+
+	// isolate lets the program inherit its standard file descriptors, which
+	// means that its own errors also end up in the program's stderr.
+	stdin, err := os.Open(invocation.stdin)
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(invocation.stdout)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(invocation.stderr)
+	if err != nil {
+		return err
+	}
+	defer stderr.Close()
+
+	cmd := sandboxCommand(ctx, s.config.Binary, params...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		// isolate exits with 1 when the program does not finish correctly,
+		// which is reported in the meta file.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return errors.Wrap(err, "isolate execution failed")
+		}
+	}
+
+	isolateMetaFd, err := os.Open(isolateMetaFile)
+	if err != nil {
+		return err
+	}
+	defer isolateMetaFd.Close()
+	metaFd, err := os.Create(invocation.metaFile)
+	if err != nil {
+		return err
+	}
+	invocation.oomKilled, err = convertIsolateMeta(isolateMetaFd, metaFd)
+	if closeErr := metaFd.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// convertIsolateMeta reads a meta file written by isolate and writes the
+// equivalent one in the format that omegajail uses. It returns whether the
+// program was killed for going over the memory limit of its control group.
+func convertIsolateMeta(isolateMeta io.Reader, meta io.Writer) (bool, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(isolateMeta)
+	for scanner.Scan() {
+		tokens := strings.SplitN(scanner.Text(), ":", 2)
+		if len(tokens) < 2 {
+			return false, errors.Errorf("malformed isolate meta file line: %q", scanner.Text())
+		}
+		fields[tokens[0]] = tokens[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	if fields["status"] == "XX" {
+		return false, errors.Errorf("isolate internal error: %s", fields["message"])
+	}
+
+	exitCode, ok := fields["exitcode"]
+	if !ok {
+		exitCode = "0"
+	}
+	lines := []string{"status:" + exitCode}
+	for _, field := range []string{"time", "time-wall"} {
+		if value, ok := fields[field]; ok {
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return false, errors.Wrapf(err, "invalid %s in isolate meta file", field)
+			}
+			lines = append(lines, fmt.Sprintf("%s:%d", field, int64(math.Round(seconds*1e6))))
+		}
+	}
+	// The memory of the control group also accounts for all the children.
+	for _, field := range []string{"cg-mem", "max-rss"} {
+		if value, ok := fields[field]; ok {
+			kib, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return false, errors.Wrapf(err, "invalid %s in isolate meta file", field)
+			}
+			lines = append(lines, fmt.Sprintf("mem:%d", kib*1024))
+			break
+		}
+	}
+	if fields["status"] == "TO" {
+		// isolate kills the program with SIGKILL, but it was a time limit.
+		lines = append(lines, "signal:SIGXCPU")
+	} else if value, ok := fields["exitsig"]; ok {
+		signal, err := strconv.Atoi(value)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid exitsig in isolate meta file")
+		}
+		if name, ok := signalNames[signal]; ok {
+			lines = append(lines, "signal:"+name)
+		} else {
+			lines = append(lines, fmt.Sprintf("signal_number:%d", signal))
+		}
+	}
+
+	_, err := io.WriteString(meta, strings.Join(lines, "\n")+"\n")
+	return fields["cg-oom-killed"] == "1", err
+}
+
+// copyBoxFile copies a file in or out of a box, keeping its permissions so
+// that the binaries stay executable.
+func copyBoxFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		os.Remove(dst)
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+func kibibytes(b base.Byte) int64 {
+	return (b.Bytes() + 1023) / 1024
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConvertIsolateMeta(t *testing.T) {
+	for _, tc := range []struct {
+		isolateMeta string
+		meta        string
+		oomKilled   bool
+	}{
+		{
+			"time:0.012\ntime-wall:0.034\nmax-rss:2048\ncsw-voluntary:3\nexitcode:0\n",
+			"status:0\ntime:12000\ntime-wall:34000\nmem:2097152\n",
+			false,
+		},
+		{
+			"time:0.100\nmax-rss:1024\ncg-mem:4096\nexitcode:3\nstatus:RE\nmessage:Exited with error status 3\n",
+			"status:3\ntime:100000\nmem:4194304\n",
+			false,
+		},
+		{
+			"time:0.200\nexitsig:11\nstatus:SG\nmessage:Caught fatal signal 11\n",
+			"status:0\ntime:200000\nsignal:SIGSEGV\n",
+			false,
+		},
+		{
+			"time:0.200\nexitsig:10\nstatus:SG\n",
+			"status:0\ntime:200000\nsignal_number:10\n",
+			false,
+		},
+		{
+			"time:1.001\ntime-wall:1.050\nexitsig:9\nkilled:1\nstatus:TO\nmessage:Time limit exceeded\n",
+			"status:0\ntime:1001000\ntime-wall:1050000\nsignal:SIGXCPU\n",
+			false,
+		},
+		{
+			"time:0.050\ncg-mem:65536\ncg-oom-killed:1\nexitsig:9\nkilled:1\nstatus:SG\n",
+			"status:0\ntime:50000\nmem:67108864\nsignal:SIGKILL\n",
+			true,
+		},
+	} {
+		var meta bytes.Buffer
+		oomKilled, err := convertIsolateMeta(strings.NewReader(tc.isolateMeta), &meta)
+		if err != nil {
+			t.Errorf("convertIsolateMeta(%q) failed: %v", tc.isolateMeta, err)
+			continue
+		}
+		if meta.String() != tc.meta || oomKilled != tc.oomKilled {
+			t.Errorf(
+				"convertIsolateMeta(%q) == %q, %v, want %q, %v",
+				tc.isolateMeta,
+				meta.String(),
+				oomKilled,
+				tc.meta,
+				tc.oomKilled,
+			)
+		}
+	}
+
+	for _, isolateMeta := range []string{
+		"status:XX\nmessage:Cannot run proxy\n",
+		"time:0.1\ngarbage\n",
+		"time:fast\n",
+	} {
+		var meta bytes.Buffer
+		if _, err := convertIsolateMeta(strings.NewReader(isolateMeta), &meta); err == nil {
+			t.Errorf("convertIsolateMeta(%q) == %q, want error", isolateMeta, meta.String())
+		}
+	}
+}
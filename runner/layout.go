@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+const (
+	// RunRootStorageDisk is used when the files of the run are stored on disk.
+	RunRootStorageDisk = "disk"
+
+	// RunRootStorageTmpfs is used when the files of the run are stored in a
+	// tmpfs.
+	RunRootStorageTmpfs = "tmpfs"
+)
+
+// RunLayout determines where the files of a run are stored on disk. All the
+// paths that the grading logic uses are derived from it, so that the on-disk
+// layout can be changed in a single place.
+type RunLayout struct {
+	// Root is the directory that contains all the files of the run.
+	Root string
+}
+
+// NewRunLayout returns the default RunLayout for the attempt, which is stored
+// under the runtime path of the runner.
+func NewRunLayout(runtimePath string, attemptID uint64) *RunLayout {
+	return &RunLayout{
+		Root: path.Join(runtimePath, "grade", strconv.FormatUint(attemptID, 10)),
+	}
+}
+
+// MountTmpfs mounts a tmpfs of at most the provided size on the root of the
+// run.
+func (l *RunLayout) MountTmpfs(size base.Byte) error {
+	if err := os.MkdirAll(l.Root, 0755); err != nil {
+		return err
+	}
+	return syscall.Mount(
+		"tmpfs",
+		l.Root,
+		"tmpfs",
+		syscall.MS_NOSUID|syscall.MS_NODEV,
+		fmt.Sprintf("size=%d,mode=0755", size.Bytes()),
+	)
+}
+
+// Unmount unmounts the tmpfs that was mounted on the root of the run.
+func (l *RunLayout) Unmount() error {
+	return syscall.Unmount(l.Root, 0)
+}
+
+// Path returns the absolute path of a file relative to the root of the run,
+// like the ones in the generated files list.
+func (l *RunLayout) Path(name string) string {
+	return path.Join(l.Root, name)
+}
+
+// BinRoot returns the directory of the binary with the provided name, which
+// contains the compilation outputs.
+func (l *RunLayout) BinRoot(name string) string {
+	return path.Join(l.Root, name)
+}
+
+// BinPath returns the directory where the binary with the provided name is
+// compiled and run.
+func (l *RunLayout) BinPath(name string) string {
+	return path.Join(l.BinRoot(name), "bin")
+}
+
+// PipesDir returns the directory with the named pipes that the binary with
+// the provided name of an interactive problem uses to communicate with the
+// problemsetter's binary.
+func (l *RunLayout) PipesDir(name string) string {
+	return path.Join(l.Root, pipesDirName(name))
+}
+
+// PipesMountPoint returns the directory inside the bin directory of the
+// binary with the provided name where the pipes of the interface are mounted.
+func (l *RunLayout) PipesMountPoint(name, iface string) string {
+	return path.Join(l.BinPath(name), pipesDirName(iface))
+}
+
+// CaseOut returns the path of the standard output of a case.
+func (l *RunLayout) CaseOut(prefix, caseName string) string {
+	return l.Path(caseFileName(prefix, caseName, "out"))
+}
+
+// CaseErr returns the path of the standard error of a case.
+func (l *RunLayout) CaseErr(prefix, caseName string) string {
+	return l.Path(caseFileName(prefix, caseName, "err"))
+}
+
+// CaseMeta returns the path of the metadata of a case.
+func (l *RunLayout) CaseMeta(prefix, caseName string) string {
+	return l.Path(caseFileName(prefix, caseName, "meta"))
+}
+
+// caseFileName returns the name of a file of a case, relative to the root of
+// the run. prefix is the outputPathPrefix of the binary that generated it.
+func caseFileName(prefix, caseName, extension string) string {
+	return path.Join(prefix, fmt.Sprintf("%s.%s", caseName, extension))
+}
+
+func pipesDirName(name string) string {
+	return fmt.Sprintf("%s_pipes", name)
+}
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"os"
+	"testing"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+func TestRunLayout(t *testing.T) {
+	layout := NewRunLayout("/var/lib/omegaup/runner", 42)
+
+	entries := []struct {
+		got, expected string
+	}{
+		{layout.Root, "/var/lib/omegaup/runner/grade/42"},
+		{layout.BinRoot("Main"), "/var/lib/omegaup/runner/grade/42/Main"},
+		{layout.BinPath("Main"), "/var/lib/omegaup/runner/grade/42/Main/bin"},
+		{layout.PipesDir("sums"), "/var/lib/omegaup/runner/grade/42/sums_pipes"},
+		{layout.PipesMountPoint("Main", "sums"), "/var/lib/omegaup/runner/grade/42/Main/bin/sums_pipes"},
+		{layout.CaseOut("", "1.0"), "/var/lib/omegaup/runner/grade/42/1.0.out"},
+		{layout.CaseErr("validator", "1.0"), "/var/lib/omegaup/runner/grade/42/validator/1.0.err"},
+		{layout.CaseMeta("sums", "1.0"), "/var/lib/omegaup/runner/grade/42/sums/1.0.meta"},
+		{caseFileName("sums", "1.0", "out"), "sums/1.0.out"},
+	}
+	for _, entry := range entries {
+		if entry.got != entry.expected {
+			t.Errorf("got %q, expected %q", entry.got, entry.expected)
+		}
+	}
+}
+
+func TestRunLayoutMountTmpfs(t *testing.T) {
+	layout := &RunLayout{Root: t.TempDir()}
+	if err := layout.MountTmpfs(base.Mebibyte); err != nil {
+		t.Skipf("tmpfs not supported: %v", err)
+	}
+	if err := os.WriteFile(layout.Path("Main.out"), []byte("3\n"), 0644); err != nil {
+		layout.Unmount()
+		t.Fatalf("Failed to write into the tmpfs: %v", err)
+	}
+	if err := layout.Unmount(); err != nil {
+		t.Fatalf("Failed to unmount the tmpfs: %v", err)
+	}
+	if _, err := os.Stat(layout.Path("Main.out")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat() == %v, expected the file to be gone with the tmpfs", err)
+	}
+}
@@ -9,7 +9,7 @@ import (
 // NoopSandbox is a sandbox that does nothing and always grades runs as AC.
 type NoopSandbox struct{}
 
-var _ Sandbox = &NoopSandbox{}
+var _ NetworkSandbox = &NoopSandbox{}
 
 // Supported returns true if the sandbox is available in the system.
 func (*NoopSandbox) Supported() bool {
@@ -32,7 +32,7 @@ func (*NoopSandbox) Compile(
 		}
 		f.Close()
 	}
-	return &RunMetadata{Verdict: "OK"}, nil
+	return &RunMetadata{Verdict: common.VerdictOK}, nil
 }
 
 // Run uses a previously compiled program and runs it against a single test
@@ -52,13 +52,18 @@ func (*NoopSandbox) Run(
 		}
 		f.Close()
 	}
-	return &RunMetadata{Verdict: "OK"}, nil
+	return &RunMetadata{Verdict: common.VerdictOK}, nil
+}
+
+// WithNetworkPolicy returns the same sandbox, since it does not run anything.
+func (s *NoopSandbox) WithNetworkPolicy(policy common.NetworkPolicy) (Sandbox, error) {
+	return s, nil
 }
 
 // NoopSandboxFixupResult amends the result so that it is AC.
 func NoopSandboxFixupResult(result *RunResult) {
 	// The no-op runner judges everything as AC.
-	result.Verdict = "AC"
+	result.Verdict = common.VerdictAccepted
 	result.Score = big.NewRat(1, 1)
 	result.ContestScore = new(big.Rat).Mul(
 		result.Score,
@@ -86,7 +91,7 @@ func NoopSandboxFixupResult(result *RunResult) {
 				caseResult.MaxScore,
 				result.ContestScore,
 			)
-			caseResult.Verdict = "AC"
+			caseResult.Verdict = common.VerdictAccepted
 
 		}
 	}
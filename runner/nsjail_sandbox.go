@@ -0,0 +1,427 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+	"github.com/omegaup/quark/common"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+// NsjailSandbox is an implementation of a Sandbox that uses nsjail
+// (https://github.com/google/nsjail). Like with isolate, the commands that
+// compile and run each language are configurable, and the restrictions that
+// apply to the programs (mounts, namespaces and the seccomp policy) come from
+// the nsjail configuration file of each language in the policy root.
+//
+// The programs run in the directory of the binary, which is mounted in the
+// same path within the jail: read-write for compilations and read-only for
+// runs.
+type NsjailSandbox struct {
+	config common.NsjailConfig
+}
+
+var _ Sandbox = &NsjailSandbox{}
+
+// NewNsjailSandbox creates a new NsjailSandbox.
+func NewNsjailSandbox(config common.NsjailConfig) *NsjailSandbox {
+	return &NsjailSandbox{
+		config: config,
+	}
+}
+
+// Supported returns whether the nsjail binary is installed in the system.
+func (s *NsjailSandbox) Supported() bool {
+	_, err := exec.LookPath(s.config.Binary)
+	return err == nil
+}
+
+// Compile compiles the contestant-supplied program using the specified
+// configuration using the nsjail sandbox.
+func (s *NsjailSandbox) Compile(
+	ctx *common.Context,
+	lang string,
+	inputFiles []string,
+	chdir, outputFile, errorFile, metaFile, target string,
+	extraFlags []string,
+) (*RunMetadata, error) {
+	language, err := s.language(ctx, lang)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	sources := make([]string, 0, len(inputFiles))
+	for _, inputFile := range inputFiles {
+		if !strings.HasPrefix(inputFile, chdir) {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, errors.Errorf("file %q is not within the chroot", inputFile)
+		}
+		rel, err := filepath.Rel(chdir, inputFile)
+		if err != nil {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, err
+		}
+		sources = append(sources, rel)
+	}
+
+	compileTimeLimit := time.Duration(ctx.Config.Runner.CompileTimeLimit)
+	err = s.invoke(ctx, &nsjailInvocation{
+		lang:      lang,
+		command:   expandSandboxCommand(language.Compile, target, sources, extraFlags),
+		chdir:     chdir,
+		mounts:    []string{"--bindmount", fmt.Sprintf("%s:%s", chdir, chdir)},
+		time:      compileTimeLimit,
+		wallTime:  compileTimeLimit,
+		fileSize:  ctx.Config.Runner.CompileOutputLimit,
+		processes: -1,
+		stdin:     "/dev/null",
+		stdout:    outputFile,
+		stderr:    errorFile,
+		metaFile:  metaFile,
+	})
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	metaFd, err := os.Open(metaFile)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	defer metaFd.Close()
+	metadata, err := parseMetaFile(ctx, nil, lang, metaFd, &outputFile, nil, false)
+
+	if lang == "java" && metadata.Verdict == common.VerdictOK {
+		if err := checkJavaClass(chdir, target, errorFile, metadata); err != nil {
+			return metadata, err
+		}
+	}
+
+	return metadata, err
+}
+
+// Run invokes the contestant-supplied program against a specified input and
+// run configuration using the nsjail sandbox.
+func (s *NsjailSandbox) Run(
+	ctx *common.Context,
+	limits *common.LimitsSettings,
+	lang, chdir, inputFile, outputFile, errorFile, metaFile, target string,
+	originalInputFile, originalOutputFile, runMetaFile *string,
+	extraParams []string,
+	extraMountPoints map[string]string,
+) (*RunMetadata, error) {
+	language, err := s.language(ctx, lang)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	sandboxLimits := ctx.Config.Runner.SandboxLimits(lang, limits)
+	timeLimit := time.Duration(sandboxLimits.TimeLimit)
+	extraWallTime := time.Duration(sandboxLimits.ExtraWallTime)
+
+	if err := copyRunFiles(chdir, originalInputFile, originalOutputFile, runMetaFile); err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	// Create intermediate directories, if needed.
+	if err := os.MkdirAll(path.Dir(outputFile), 0o755); err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+
+	invocation := &nsjailInvocation{
+		lang:      lang,
+		command:   sandboxRunCommand(ctx, language, lang, target, limits, extraParams),
+		chdir:     chdir,
+		mounts:    []string{"--bindmount_ro", fmt.Sprintf("%s:%s", chdir, chdir)},
+		time:      timeLimit,
+		wallTime:  timeLimit + extraWallTime,
+		memory:    sandboxLimits.MemoryLimit,
+		fileSize:  sandboxFileSizeLimit(limits.OutputLimit),
+		processes: language.Processes,
+		stdin:     inputFile,
+		stdout:    outputFile,
+		stderr:    errorFile,
+		metaFile:  metaFile,
+	}
+	for path, mountTarget := range extraMountPoints {
+		invocation.mounts = append(invocation.mounts, "--bindmount", fmt.Sprintf("%s:%s", path, mountTarget))
+	}
+
+	if isJVMLanguage(lang) && ctx.Config.Runner.JavaPolicyTemplate != "" {
+		if err := writeJavaPolicy(ctx.Config.Runner.JavaPolicyTemplate, chdir); err != nil {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, err
+		}
+		invocation.env = append(
+			invocation.env,
+			"JAVA_TOOL_OPTIONS=-Djava.security.manager -Djava.security.policy==java.policy",
+		)
+	}
+
+	preloader, err := newInputPreloader(inputFile)
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to preload input",
+			map[string]any{
+				"file": inputFile,
+				"err":  err,
+			},
+		)
+	} else if preloader != nil {
+		// preloader might be nil, even with no error.
+		preloader.release()
+	}
+
+	if err := s.invoke(ctx, invocation); err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	metaFd, err := os.Open(metaFile)
+	if err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
+	}
+	defer metaFd.Close()
+	return parseMetaFile(ctx, limits, lang, metaFd, &outputFile, &errorFile, lang == "c")
+}
+
+// CompilerVersion returns the version of the compiler that the language uses.
+// The compilers are the ones installed in the system.
+func (s *NsjailSandbox) CompilerVersion(ctx *common.Context, lang string) (string, error) {
+	language, err := s.language(ctx, lang)
+	if err != nil {
+		return "", err
+	}
+	return compilerVersions.Get(ctx, language.Compile)
+}
+
+func (s *NsjailSandbox) language(ctx *common.Context, lang string) (*common.SandboxLanguageConfig, error) {
+	return sandboxLanguage(ctx, s.config.Languages, lang, "nsjail")
+}
+
+// policyFile returns the nsjail configuration file for the language: its own,
+// the one named by its Policy if it does not have one, or the default one.
+func (s *NsjailSandbox) policyFile(ctx *common.Context, lang string) (string, error) {
+	names := []string{lang}
+	if policy := ctx.Config.Runner.Language(lang).Policy; policy != "" {
+		names = append(names, policy)
+	}
+	for _, name := range append(names, "default") {
+		policyFile := path.Join(s.config.PolicyRoot, name+".cfg")
+		if _, err := os.Stat(policyFile); err == nil {
+			return policyFile, nil
+		}
+	}
+	return "", errors.Errorf("no nsjail policy for language %q in %s", lang, s.config.PolicyRoot)
+}
+
+// nsjailInvocation is a program that is run within nsjail.
+type nsjailInvocation struct {
+	lang    string
+	command []string
+	chdir   string
+	mounts  []string // extra mount flags, in nsjail's command-line format
+	env     []string
+
+	time      time.Duration
+	wallTime  time.Duration
+	memory    base.Byte // 0 means unlimited
+	fileSize  base.Byte // 0 means unlimited
+	processes int       // 0 means a single one, negative means unlimited
+
+	stdin, stdout, stderr, metaFile string
+}
+
+// nsjailResult is how a program that was run within nsjail finished.
+type nsjailResult struct {
+	exitStatus int
+	signal     int // 0 if the program was not killed by a signal
+	cpuTime    time.Duration
+	sysTime    time.Duration
+	wallTime   time.Duration
+	memory     base.Byte
+}
+
+// invoke runs the program within nsjail and writes its metadata into
+// invocation.metaFile in the format that omegajail uses, so that
+// parseMetaFile can interpret it. An error is returned only if the program
+// could not be run at all.
+func (s *NsjailSandbox) invoke(ctx *common.Context, invocation *nsjailInvocation) error {
+	policyFile, err := s.policyFile(ctx, invocation.lang)
+	if err != nil {
+		return err
+	}
+	logFile := invocation.stderr + ".nsjail"
+	defer os.Remove(logFile)
+
+	params := []string{
+		"--config", policyFile,
+		"--mode", "o",
+		"--log", logFile,
+		"--cwd", invocation.chdir,
+		// nsjail only counts whole seconds. The CPU time limit is enforced
+		// with some slack, and the exact time is checked afterwards.
+		"--rlimit_cpu", strconv.FormatInt(int64(math.Ceil(invocation.time.Seconds()))+1, 10),
+		"--time_limit", strconv.FormatInt(int64(math.Ceil(invocation.wallTime.Seconds())), 10),
+	}
+	params = append(params, invocation.mounts...)
+	if invocation.fileSize > 0 {
+		params = append(params, "--rlimit_fsize", strconv.FormatInt(mebibytes(invocation.fileSize), 10))
+	} else {
+		params = append(params, "--rlimit_fsize", "inf")
+	}
+	if invocation.memory > 0 {
+		if s.config.Cgroups {
+			params = append(params, "--cgroup_mem_max", strconv.FormatInt(invocation.memory.Bytes(), 10))
+		} else if !reservesAddressSpace(invocation.lang) {
+			params = append(params, "--rlimit_as", strconv.FormatInt(mebibytes(invocation.memory), 10))
+		}
+	} else {
+		params = append(params, "--rlimit_as", "inf")
+	}
+	if s.config.Cgroups && invocation.processes >= 0 {
+		params = append(params, "--cgroup_pids_max", strconv.Itoa(base.Max(invocation.processes, 1)))
+	}
+	for _, env := range invocation.env {
+		params = append(params, "--env", env)
+	}
+	params = append(params, "--")
+	params = append(params, invocation.command...)
+	ctx.Log.Debug(
+		"invoking",
+		map[string]any{
+			"params": shellquote.Join(append([]string{s.config.Binary}, params...)...),
+		},
+	)
+
+	stdin, err := os.Open(invocation.stdin)
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(invocation.stdout)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(invocation.stderr)
+	if err != nil {
+		return err
+	}
+	defer stderr.Close()
+
+	cmd := sandboxCommand(ctx, s.config.Binary, params...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	start := time.Now()
+	err = cmd.Run()
+	wallTime := time.Since(start)
+	if err != nil {
+		// nsjail exits with the status of the program, which is reported in
+		// the meta file.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return errors.Wrap(err, "nsjail execution failed")
+		}
+	}
+
+	// nsjail's own messages go after the program's errors.
+	if logFd, err := os.Open(logFile); err == nil {
+		io.Copy(stderr, logFd)
+		logFd.Close()
+	}
+
+	result := &nsjailResult{
+		exitStatus: cmd.ProcessState.ExitCode(),
+		wallTime:   wallTime,
+	}
+	// nsjail waits for the program, so its resource usage includes the
+	// program's.
+	if rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		result.cpuTime = time.Duration(rusage.Utime.Nano())
+		result.sysTime = time.Duration(rusage.Stime.Nano())
+		result.memory = base.Byte(rusage.Maxrss) * base.Kibibyte
+	}
+	if result.exitStatus > 128 {
+		result.signal = result.exitStatus - 128
+		result.exitStatus = 0
+	}
+
+	return ioutil.WriteFile(
+		invocation.metaFile,
+		[]byte(formatNsjailMeta(result, invocation.time, invocation.wallTime)),
+		0o644,
+	)
+}
+
+// formatNsjailMeta returns the meta file in the format that omegajail uses
+// for a program that was run within nsjail with the provided limits.
+func formatNsjailMeta(result *nsjailResult, timeLimit, wallTimeLimit time.Duration) string {
+	lines := []string{
+		fmt.Sprintf("status:%d", result.exitStatus),
+		fmt.Sprintf("time:%d", result.cpuTime.Microseconds()),
+		fmt.Sprintf("time-sys:%d", result.sysTime.Microseconds()),
+		fmt.Sprintf("time-wall:%d", result.wallTime.Microseconds()),
+		fmt.Sprintf("mem:%d", result.memory.Bytes()),
+	}
+	signal := result.signal
+	if result.cpuTime > timeLimit ||
+		(signal == int(syscall.SIGKILL) && result.wallTime >= wallTimeLimit) {
+		// nsjail kills the program with SIGKILL when it goes over the wall
+		// time limit, and the CPU time limit has some slack.
+		signal = int(syscall.SIGXCPU)
+	}
+	if signal != 0 {
+		if name, ok := signalNames[signal]; ok {
+			lines = append(lines, "signal:"+name)
+		} else {
+			lines = append(lines, fmt.Sprintf("signal_number:%d", signal))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func mebibytes(b base.Byte) int64 {
+	return (b.Bytes() + base.Mebibyte.Bytes() - 1) / base.Mebibyte.Bytes()
+}
@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/omegaup/go-base/v3"
+)
+
+func TestFormatNsjailMeta(t *testing.T) {
+	for _, tc := range []struct {
+		result *nsjailResult
+		meta   string
+	}{
+		{
+			&nsjailResult{
+				cpuTime:  12 * time.Millisecond,
+				sysTime:  time.Millisecond,
+				wallTime: 34 * time.Millisecond,
+				memory:   2 * base.Mebibyte,
+			},
+			"status:0\ntime:12000\ntime-sys:1000\ntime-wall:34000\nmem:2097152\n",
+		},
+		{
+			&nsjailResult{
+				exitStatus: 3,
+				cpuTime:    100 * time.Millisecond,
+			},
+			"status:3\ntime:100000\ntime-sys:0\ntime-wall:0\nmem:0\n",
+		},
+		{
+			&nsjailResult{
+				signal:  11,
+				cpuTime: 200 * time.Millisecond,
+			},
+			"status:0\ntime:200000\ntime-sys:0\ntime-wall:0\nmem:0\nsignal:SIGSEGV\n",
+		},
+		{
+			&nsjailResult{
+				signal: 10,
+			},
+			"status:0\ntime:0\ntime-sys:0\ntime-wall:0\nmem:0\nsignal_number:10\n",
+		},
+		{
+			// Killed by nsjail after going over the wall time limit.
+			&nsjailResult{
+				signal:   9,
+				cpuTime:  500 * time.Millisecond,
+				wallTime: 2 * time.Second,
+			},
+			"status:0\ntime:500000\ntime-sys:0\ntime-wall:2000000\nmem:0\nsignal:SIGXCPU\n",
+		},
+		{
+			// Finished within the CPU time limit slack.
+			&nsjailResult{
+				cpuTime:  1500 * time.Millisecond,
+				wallTime: 1600 * time.Millisecond,
+			},
+			"status:0\ntime:1500000\ntime-sys:0\ntime-wall:1600000\nmem:0\nsignal:SIGXCPU\n",
+		},
+		{
+			// Killed by something other than the time limits.
+			&nsjailResult{
+				signal:   9,
+				cpuTime:  50 * time.Millisecond,
+				wallTime: 60 * time.Millisecond,
+			},
+			"status:0\ntime:50000\ntime-sys:0\ntime-wall:60000\nmem:0\nsignal:SIGKILL\n",
+		},
+	} {
+		meta := formatNsjailMeta(tc.result, time.Second, 2*time.Second)
+		if meta != tc.meta {
+			t.Errorf("formatNsjailMeta(%+v) == %q, want %q", tc.result, meta, tc.meta)
+		}
+	}
+}
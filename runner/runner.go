@@ -3,6 +3,7 @@ package runner
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,46 +14,80 @@ import (
 	"math/big"
 	"os"
 	"path"
-	"strconv"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	base "github.com/omegaup/go-base/v3"
 	"github.com/omegaup/quark/common"
-
-	"github.com/vincent-petithory/dataurl"
 )
 
-// A CaseResult represents the sub-results of a specific test case.
+// A CaseResult represents the sub-results of a specific test case. Meta
+// contains the merged metadata of all the contestant binaries, and for
+// interactive problems IndividualMeta contains the metadata of each binary
+// keyed by its interface name (including the problemsetter's Main), as well as
+// the validator's, so that it's possible to tell which side consumed the
+// resources.
 type CaseResult struct {
-	Verdict        string                 `json:"verdict"`
+	Verdict        common.Verdict         `json:"verdict"`
 	Name           string                 `json:"name"`
 	Score          *big.Rat               `json:"score"`
 	ContestScore   *big.Rat               `json:"contest_score"`
 	MaxScore       *big.Rat               `json:"max_score"`
+	OutputSize     base.Byte              `json:"output_size"`
+	ErrorSize      base.Byte              `json:"error_size"`
 	Meta           RunMetadata            `json:"meta"`
 	IndividualMeta map[string]RunMetadata `json:"individual_meta,omitempty"`
+	Visibility     common.CaseVisibility  `json:"visibility,omitempty"`
+
+	// TimeLimitRatio is the CPU time of the case as a fraction of the time
+	// limit, regardless of the verdict, so that problemsetters can see how
+	// tight their limits are. It is 0 if the problem has no time limit.
+	TimeLimitRatio float64 `json:"time_limit_ratio"`
+
+	// redacted is set when all the information that should not be shown to
+	// contestants has been removed from the result.
+	redacted bool
 }
 
 // MarshalJSON implements the json.Marshaler interface.
 func (c *CaseResult) MarshalJSON() ([]byte, error) {
+	var outputSize, errorSize *base.Byte
+	var meta *RunMetadata
+	var timeLimitRatio *float64
+	if !c.redacted {
+		outputSize = &c.OutputSize
+		errorSize = &c.ErrorSize
+		meta = &c.Meta
+		timeLimitRatio = &c.TimeLimitRatio
+	}
 	return json.Marshal(&struct {
-		Verdict        string                 `json:"verdict"`
+		Verdict        common.Verdict         `json:"verdict"`
+		VerdictDetail  common.VerdictDetail   `json:"verdict_detail"`
 		Name           string                 `json:"name"`
 		Score          float64                `json:"score"`
 		ContestScore   float64                `json:"contest_score"`
 		MaxScore       float64                `json:"max_score"`
-		Meta           RunMetadata            `json:"meta"`
+		OutputSize     *base.Byte             `json:"output_size,omitempty"`
+		ErrorSize      *base.Byte             `json:"error_size,omitempty"`
+		Meta           *RunMetadata           `json:"meta,omitempty"`
 		IndividualMeta map[string]RunMetadata `json:"individual_meta,omitempty"`
+		Visibility     common.CaseVisibility  `json:"visibility,omitempty"`
+		TimeLimitRatio *float64               `json:"time_limit_ratio,omitempty"`
 	}{
 		Verdict:        c.Verdict,
+		VerdictDetail:  c.Verdict.Detail(),
 		Name:           c.Name,
 		Score:          base.RationalToFloat(c.Score),
 		ContestScore:   base.RationalToFloat(c.ContestScore),
 		MaxScore:       base.RationalToFloat(c.MaxScore),
-		Meta:           c.Meta,
+		OutputSize:     outputSize,
+		ErrorSize:      errorSize,
+		Meta:           meta,
 		IndividualMeta: c.IndividualMeta,
+		Visibility:     c.Visibility,
+		TimeLimitRatio: timeLimitRatio,
 	})
 }
 
@@ -63,13 +98,17 @@ func (c *CaseResult) UnmarshalJSON(data []byte) error {
 	}
 
 	result := struct {
-		Verdict        string                 `json:"verdict"`
+		Verdict        common.Verdict         `json:"verdict"`
 		Name           string                 `json:"name"`
 		Score          float64                `json:"score"`
 		ContestScore   float64                `json:"contest_score"`
 		MaxScore       float64                `json:"max_score"`
-		Meta           RunMetadata            `json:"meta"`
+		OutputSize     base.Byte              `json:"output_size"`
+		ErrorSize      base.Byte              `json:"error_size"`
+		Meta           *RunMetadata           `json:"meta,omitempty"`
 		IndividualMeta map[string]RunMetadata `json:"individual_meta,omitempty"`
+		Visibility     common.CaseVisibility  `json:"visibility,omitempty"`
+		TimeLimitRatio float64                `json:"time_limit_ratio"`
 	}{}
 
 	if err := json.Unmarshal(data, &result); err != nil {
@@ -81,8 +120,17 @@ func (c *CaseResult) UnmarshalJSON(data []byte) error {
 	c.Score = base.FloatToRational(result.Score)
 	c.ContestScore = base.FloatToRational(result.ContestScore)
 	c.MaxScore = base.FloatToRational(result.MaxScore)
-	c.Meta = result.Meta
+	c.OutputSize = result.OutputSize
+	c.ErrorSize = result.ErrorSize
+	if result.Meta != nil {
+		c.Meta = *result.Meta
+	} else {
+		c.Meta = RunMetadata{}
+		c.redacted = true
+	}
 	c.IndividualMeta = result.IndividualMeta
+	c.Visibility = result.Visibility
+	c.TimeLimitRatio = result.TimeLimitRatio
 
 	return nil
 }
@@ -140,18 +188,24 @@ func (g *GroupResult) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// ProblemsetterFailure returns whether the case got a VE verdict because the
+// problemsetter's binary of an interactive problem failed.
+func (c *CaseResult) ProblemsetterFailure() bool {
+	return c.Meta.Verdict == common.VerdictValidatorError && c.Meta.ParentMeta != nil
+}
+
 // Verdict returns the final verdict of the group.
-func (g *GroupResult) Verdict() string {
-	verdict := "AC"
+func (g *GroupResult) Verdict() common.Verdict {
+	verdict := common.VerdictAccepted
 	for _, c := range g.Cases {
-		verdict = worseVerdict(verdict, c.Verdict)
+		verdict = verdict.Worse(c.Verdict)
 	}
 	return verdict
 }
 
 // A RunResult represents the results of a run.
 type RunResult struct {
-	Verdict       string                 `json:"verdict"`
+	Verdict       common.Verdict         `json:"verdict"`
 	CompileError  *string                `json:"compile_error,omitempty"`
 	CompileMeta   map[string]RunMetadata `json:"compile_meta"`
 	Score         *big.Rat               `json:"score"`
@@ -161,12 +215,63 @@ type RunResult struct {
 	WallTime      float64                `json:"wall_time"`
 	Memory        base.Byte              `json:"memory"`
 	OverallOutput base.Byte              `json:"total_output"`
+	OverallError  base.Byte              `json:"total_error"`
 	JudgedBy      string                 `json:"judged_by,omitempty"`
 	Groups        []GroupResult          `json:"groups"`
+
+	// TruncatedArtifacts are the files that were truncated (or left empty) in
+	// files.zip to keep it within Runner.MaxArtifactSize.
+	TruncatedArtifacts []string `json:"truncated_artifacts,omitempty"`
+
+	// Environment describes where the run was graded. It is not shown to
+	// contestants.
+	Environment *ExecutionEnvironment `json:"environment,omitempty"`
+
+	// Timings is not part of the JSON representation of the RunResult, since
+	// it is not deterministic. Runners send it separately as timings.json.
+	Timings RunTimings `json:"-"`
+
+	// feedback is set when the RunResult was produced by WithFeedback, so
+	// that the fields that the feedback level does not allow to show are not
+	// marshaled at all.
+	feedback common.FeedbackLevel
+}
+
+// RunTimings is a summary of how long a runner spent in each of the phases of
+// grading a run, in seconds. Validation overlaps with running the cases, so
+// the phases can add up to more than the total time.
+type RunTimings struct {
+	Download float64 `json:"download"`
+	Compile  float64 `json:"compile"`
+	Run      float64 `json:"run"`
+	Validate float64 `json:"validate"`
+	Upload   float64 `json:"upload"`
+
+	// RunRootStorage is the backing store of the directory of the run (either
+	// RunRootStorageDisk or RunRootStorageTmpfs), since it affects the times.
+	RunRootStorage string `json:"run_root_storage,omitempty"`
+}
+
+// Total returns the time that the runner spent grading the run, in seconds.
+func (t *RunTimings) Total() float64 {
+	return t.Download + t.Compile + t.Run + t.Validate + t.Upload
+}
+
+// ProblemsetterFailure returns whether any of the cases got a VE verdict
+// because the problemsetter's binary of an interactive problem failed.
+func (r *RunResult) ProblemsetterFailure() bool {
+	for _, group := range r.Groups {
+		for i := range group.Cases {
+			if group.Cases[i].ProblemsetterFailure() {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // NewRunResult returns a new RunResult.
-func NewRunResult(verdict string, maxScore *big.Rat) *RunResult {
+func NewRunResult(verdict common.Verdict, maxScore *big.Rat) *RunResult {
 	return &RunResult{
 		Verdict:      verdict,
 		Score:        &big.Rat{},
@@ -177,30 +282,59 @@ func NewRunResult(verdict string, maxScore *big.Rat) *RunResult {
 
 // MarshalJSON implements the json.Marshaler interface.
 func (r *RunResult) MarshalJSON() ([]byte, error) {
+	if r.feedback == common.FeedbackLevelNone {
+		var verdict common.Verdict
+		if r.CompileError != nil {
+			verdict = r.Verdict
+		}
+		return json.Marshal(&struct {
+			Verdict      common.Verdict       `json:"verdict,omitempty"`
+			CompileError *string              `json:"compile_error,omitempty"`
+			Feedback     common.FeedbackLevel `json:"feedback"`
+		}{
+			Verdict:      verdict,
+			CompileError: r.CompileError,
+			Feedback:     r.feedback,
+		})
+	}
 	return json.Marshal(&struct {
-		Verdict      string                 `json:"verdict"`
-		CompileError *string                `json:"compile_error,omitempty"`
-		CompileMeta  map[string]RunMetadata `json:"compile_meta"`
-		Score        float64                `json:"score"`
-		ContestScore float64                `json:"contest_score"`
-		MaxScore     float64                `json:"max_score"`
-		Time         float64                `json:"time"`
-		WallTime     float64                `json:"wall_time"`
-		Memory       base.Byte              `json:"memory"`
-		JudgedBy     string                 `json:"judged_by,omitempty"`
-		Groups       []GroupResult          `json:"groups"`
+		Verdict       common.Verdict         `json:"verdict"`
+		VerdictDetail common.VerdictDetail   `json:"verdict_detail"`
+		CompileError  *string                `json:"compile_error,omitempty"`
+		CompileMeta   map[string]RunMetadata `json:"compile_meta"`
+		Score         float64                `json:"score"`
+		ContestScore  float64                `json:"contest_score"`
+		MaxScore      float64                `json:"max_score"`
+		Time          float64                `json:"time"`
+		WallTime      float64                `json:"wall_time"`
+		Memory        base.Byte              `json:"memory"`
+		OverallOutput base.Byte              `json:"total_output"`
+		OverallError  base.Byte              `json:"total_error"`
+		JudgedBy      string                 `json:"judged_by,omitempty"`
+		Groups        []GroupResult          `json:"groups"`
+
+		TruncatedArtifacts []string              `json:"truncated_artifacts,omitempty"`
+		Environment        *ExecutionEnvironment `json:"environment,omitempty"`
+		Feedback           common.FeedbackLevel  `json:"feedback,omitempty"`
 	}{
-		Verdict:      r.Verdict,
-		CompileError: r.CompileError,
-		CompileMeta:  r.CompileMeta,
-		Score:        base.RationalToFloat(r.Score),
-		ContestScore: base.RationalToFloat(r.ContestScore),
-		MaxScore:     base.RationalToFloat(r.MaxScore),
-		Time:         r.Time,
-		WallTime:     r.WallTime,
-		Memory:       r.Memory,
-		JudgedBy:     r.JudgedBy,
-		Groups:       r.Groups,
+		Verdict:       r.Verdict,
+		VerdictDetail: r.Verdict.Detail(),
+		CompileError:  r.CompileError,
+		CompileMeta:   r.CompileMeta,
+		Score:         base.RationalToFloat(r.Score),
+		ContestScore:  base.RationalToFloat(r.ContestScore),
+		MaxScore:      base.RationalToFloat(r.MaxScore),
+		Time:          r.Time,
+		WallTime:      r.WallTime,
+		Memory:        r.Memory,
+		OverallOutput: r.OverallOutput,
+		OverallError:  r.OverallError,
+		JudgedBy:      r.JudgedBy,
+		Groups:        r.Groups,
+
+		TruncatedArtifacts: r.TruncatedArtifacts,
+		Environment:        r.Environment,
+		Feedback:           r.feedback,
 	})
 }
 
@@ -211,17 +345,23 @@ func (r *RunResult) UnmarshalJSON(data []byte) error {
 	}
 
 	result := struct {
-		Verdict      string                 `json:"verdict"`
-		CompileError *string                `json:"compile_error,omitempty"`
-		CompileMeta  map[string]RunMetadata `json:"compile_meta"`
-		Score        float64                `json:"score"`
-		ContestScore float64                `json:"contest_score"`
-		MaxScore     float64                `json:"max_score"`
-		Time         float64                `json:"time"`
-		WallTime     float64                `json:"wall_time"`
-		Memory       base.Byte              `json:"memory"`
-		JudgedBy     string                 `json:"judged_by,omitempty"`
-		Groups       []GroupResult          `json:"groups"`
+		Verdict       common.Verdict         `json:"verdict"`
+		CompileError  *string                `json:"compile_error,omitempty"`
+		CompileMeta   map[string]RunMetadata `json:"compile_meta"`
+		Score         float64                `json:"score"`
+		ContestScore  float64                `json:"contest_score"`
+		MaxScore      float64                `json:"max_score"`
+		Time          float64                `json:"time"`
+		WallTime      float64                `json:"wall_time"`
+		Memory        base.Byte              `json:"memory"`
+		OverallOutput base.Byte              `json:"total_output"`
+		OverallError  base.Byte              `json:"total_error"`
+		JudgedBy      string                 `json:"judged_by,omitempty"`
+		Groups        []GroupResult          `json:"groups"`
+
+		TruncatedArtifacts []string              `json:"truncated_artifacts,omitempty"`
+		Environment        *ExecutionEnvironment `json:"environment,omitempty"`
+		Feedback           common.FeedbackLevel  `json:"feedback,omitempty"`
 	}{}
 
 	if err := json.Unmarshal(data, &result); err != nil {
@@ -237,12 +377,103 @@ func (r *RunResult) UnmarshalJSON(data []byte) error {
 	r.Time = result.Time
 	r.WallTime = result.WallTime
 	r.Memory = result.Memory
+	r.OverallOutput = result.OverallOutput
+	r.OverallError = result.OverallError
 	r.JudgedBy = result.JudgedBy
 	r.Groups = result.Groups
+	r.TruncatedArtifacts = result.TruncatedArtifacts
+	r.Environment = result.Environment
+	r.feedback = result.Feedback
 
 	return nil
 }
 
+// Redacted returns a copy of the RunResult that is suitable to be shown
+// directly to contestants: only the verdicts and scores of the hidden cases
+// are kept, and the public cases keep all of their information. The totals of
+// the run only account for the public cases, and the information about the
+// compilation and the runner that graded the run is removed.
+func (r *RunResult) Redacted() *RunResult {
+	redacted := *r
+	redacted.CompileMeta = nil
+	redacted.JudgedBy = ""
+	redacted.TruncatedArtifacts = nil
+	redacted.Environment = nil
+	redacted.Time = 0
+	redacted.WallTime = 0
+	redacted.Memory = 0
+	redacted.OverallOutput = 0
+	redacted.OverallError = 0
+	redacted.Groups = make([]GroupResult, len(r.Groups))
+	for i, group := range r.Groups {
+		redacted.Groups[i] = group
+		redacted.Groups[i].Cases = make([]CaseResult, len(group.Cases))
+		for j := range group.Cases {
+			c := group.Cases[j].Redacted()
+			redacted.Groups[i].Cases[j] = c
+			if c.redacted {
+				continue
+			}
+			redacted.Time += c.Meta.Time
+			redacted.WallTime += c.Meta.WallTime
+			redacted.Memory = base.Max(redacted.Memory, c.Meta.Memory)
+			redacted.OverallOutput += c.Meta.OutputSize
+			redacted.OverallError += c.Meta.ErrorSize
+		}
+	}
+	return &redacted
+}
+
+// Redacted returns a copy of the CaseResult that only has the verdict and the
+// scores of the case, unless the case is public.
+func (c *CaseResult) Redacted() CaseResult {
+	if c.Visibility == common.CaseVisibilityPublic {
+		return *c
+	}
+	return CaseResult{
+		Verdict:      c.Verdict,
+		Name:         c.Name,
+		Score:        c.Score,
+		ContestScore: c.ContestScore,
+		MaxScore:     c.MaxScore,
+		Visibility:   c.Visibility,
+		redacted:     true,
+	}
+}
+
+// WithFeedback returns a copy of the RunResult that only has the information
+// that the feedback level allows to show to the contestant. This is the
+// variant of the results that can be shown directly to contestants, so that
+// frontends do not need to redact anything themselves.
+func (r *RunResult) WithFeedback(level common.FeedbackLevel) *RunResult {
+	var result *RunResult
+	switch level {
+	case common.FeedbackLevelNone:
+		result = &RunResult{
+			Verdict:      r.Verdict,
+			CompileError: r.CompileError,
+		}
+	case common.FeedbackLevelSummary:
+		result = r.Redacted()
+		result.Groups = nil
+	case common.FeedbackLevelPerGroup:
+		result = r.Redacted()
+		for i := range result.Groups {
+			result.Groups[i].Cases = nil
+		}
+	case common.FeedbackLevelFullDiff:
+		full := *r
+		result = &full
+	default:
+		result = r.Redacted()
+		level = common.FeedbackLevelPerCase
+	}
+	result.Timings = RunTimings{}
+	result.Environment = nil
+	result.feedback = level
+	return result
+}
+
 type binaryType int
 
 const (
@@ -277,20 +508,43 @@ type outputOnlyFile struct {
 	ole      bool
 }
 
-func extraParentFlags(language string) []string {
-	if language == "c" || language == "cpp" || language == "cpp11" {
-		return []string{"-Wl,-e__entry"}
+// sandboxCompilerVersion returns the version of the compiler that the sandbox
+// uses for the language, or an empty string if it cannot tell.
+func sandboxCompilerVersion(ctx *common.Context, sandbox Sandbox, lang string) string {
+	versionSandbox, ok := sandbox.(CompilerVersionSandbox)
+	if !ok {
+		return ""
 	}
-	return []string{}
+	version, err := versionSandbox.CompilerVersion(ctx, lang)
+	if err != nil {
+		ctx.Log.Warn(
+			"Failed to get the compiler version",
+			map[string]any{
+				"lang": lang,
+				"err":  err,
+			},
+		)
+		return ""
+	}
+	return version
 }
 
 func targetName(language string, target string) string {
-	if language == "py" || language == "py2" || language == "py3" || language == "java" {
+	if language == "py" || language == "py2" || language == "py3" || language == "py3-pypy" || language == "java" || language == "go" || language == "js" {
 		return fmt.Sprintf("%s_entry", target)
 	}
 	return target
 }
 
+// timeLimitRatio returns the CPU time of a case as a fraction of the time
+// limit.
+func timeLimitRatio(meta *RunMetadata, timeLimit base.Duration) float64 {
+	if timeLimit <= 0 {
+		return 0
+	}
+	return meta.Time / time.Duration(timeLimit).Seconds()
+}
+
 // isPeerDeath determines whether a process died because of their peer dying or
 // misbehaving. These deaths will be considered to the peer's fault.
 func isPeerDeath(meta *RunMetadata) bool {
@@ -307,15 +561,17 @@ func mergeVerdict(
 	ctx *common.Context,
 	chosenMetadata, parentMetadata *RunMetadata,
 ) *RunMetadata {
-	if parentMetadata == nil || parentMetadata.Verdict == "OK" {
+	if parentMetadata == nil || parentMetadata.Verdict == common.VerdictOK {
 		return chosenMetadata
 	}
 
 	// Make a copy to avoid modifying the in-parameter.
 	copied := *chosenMetadata
 	chosenMetadata = &copied
+	copiedParent := *parentMetadata
+	chosenMetadata.ParentMeta = &copiedParent
 
-	if parentMetadata.Verdict == "TLE" {
+	if parentMetadata.Verdict == common.VerdictTimeLimitExceeded {
 		// Regardless of what happened, if one of the processes died of TLE, the
 		// whole run is marked as TLE.
 		ctx.Log.Warn(
@@ -325,7 +581,7 @@ func mergeVerdict(
 				"parent": parentMetadata,
 			},
 		)
-		chosenMetadata.Verdict = "TLE"
+		chosenMetadata.Verdict = common.VerdictTimeLimitExceeded
 		return chosenMetadata
 	}
 
@@ -338,7 +594,7 @@ func mergeVerdict(
 				"parent": parentMetadata,
 			},
 		)
-		if parentMetadata.Verdict == "OLE" {
+		if parentMetadata.Verdict == common.VerdictOutputLimitExceeded {
 			// This should only happen if the child caused the parent to print out
 			// too much stuff.
 			ctx.Log.Warn(
@@ -348,14 +604,14 @@ func mergeVerdict(
 					"parent": parentMetadata,
 				},
 			)
-			chosenMetadata.Verdict = "OLE"
+			chosenMetadata.Verdict = common.VerdictOutputLimitExceeded
 			return chosenMetadata
 		}
-		chosenMetadata.Verdict = "VE"
+		chosenMetadata.Verdict = common.VerdictValidatorError
 		return chosenMetadata
 	}
 
-	if chosenMetadata.Verdict == "OK" {
+	if chosenMetadata.Verdict == common.VerdictOK {
 		ctx.Log.Warn(
 			"child process finished correctly, but parent did not",
 			map[string]any{
@@ -363,21 +619,21 @@ func mergeVerdict(
 				"parent": parentMetadata,
 			},
 		)
-		if parentMetadata.Verdict == "OLE" {
+		if parentMetadata.Verdict == common.VerdictOutputLimitExceeded {
 			// This should only happen if the child caused the parent to print out
 			// too much stuff.
-			chosenMetadata.Verdict = "OLE"
+			chosenMetadata.Verdict = common.VerdictOutputLimitExceeded
 			return chosenMetadata
 		}
 		if isPeerDeath(parentMetadata) {
 			// The parent died because of the parent's fault.
-			chosenMetadata.Verdict = "RTE"
+			chosenMetadata.Verdict = common.VerdictRuntimeError
 			return chosenMetadata
 		}
 		// This is probably the user's fault, but let's not guess this and mark
 		// this explicitly as being the validator's fault so that the problemsetter
 		// can fix this.
-		chosenMetadata.Verdict = "VE"
+		chosenMetadata.Verdict = common.VerdictValidatorError
 		return chosenMetadata
 	}
 
@@ -385,48 +641,103 @@ func mergeVerdict(
 }
 
 func normalizedSourceFiles(
-	runRoot string,
+	layout *RunLayout,
 	lang string,
 	name string,
 	iface *common.InteractiveInterface,
 ) []string {
-	binRoot := path.Join(runRoot, name, "bin")
+	binPath := layout.BinPath(name)
 	sources := make([]string, len(iface.MakefileRules[0].Requisites))
 	for idx, requisite := range iface.MakefileRules[0].Requisites {
-		sources[idx] = path.Join(binRoot, path.Base(requisite))
+		sources[idx] = path.Join(binPath, path.Base(requisite))
 	}
 	return sources
 }
 
+// decodeOutputOnlyArchive decodes the archive contained in the
+// base64-encoded dataurl into a temporary file inside runRoot, without holding
+// the whole decoded file in memory. It returns false if data is not a
+// base64-encoded dataurl.
+func decodeOutputOnlyArchive(data, runRoot string) (*os.File, bool, error) {
+	if !strings.HasPrefix(data, "data:") {
+		return nil, false, nil
+	}
+	commaIdx := strings.Index(data, ",")
+	if commaIdx == -1 || !strings.HasSuffix(data[:commaIdx], ";base64") {
+		return nil, false, nil
+	}
+	f, err := ioutil.TempFile(runRoot, "output-only-*")
+	if err != nil {
+		return nil, true, err
+	}
+	if _, err := io.Copy(
+		f,
+		base64.NewDecoder(base64.StdEncoding, strings.NewReader(data[commaIdx+1:])),
+	); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, true, fmt.Errorf("invalid output-only file encoding: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, true, err
+	}
+	return f, true, nil
+}
+
+// isUnsafeArchivePath returns whether the name of a file in an archive would
+// escape the directory it is extracted into.
+func isUnsafeArchivePath(name string) bool {
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return true
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." {
+			return true
+		}
+	}
+	return false
+}
+
 func parseOutputOnlyFile(
 	ctx *common.Context,
+	attemptID uint64,
 	data string,
 	settings *common.ProblemSettings,
+	runRoot string,
 ) (map[string]outputOnlyFile, error) {
-	dataURL, err := dataurl.DecodeString(data)
 	result := make(map[string]outputOnlyFile)
 	overallOutput := base.Byte(0)
-	if err != nil {
-		// |data| is not a dataurl. Try just returning the data as an Entry.
-		ctx.Log.Info(
-			"data is not a dataurl. Generating Main.out",
-			map[string]any{
-				"err": err,
-			},
-		)
-		result["Main.out"] = outputOnlyFile{data, false}
-		return result, nil
-	}
-	z, err := zip.NewReader(bytes.NewReader(dataURL.Data), int64(len(dataURL.Data)))
-	if err != nil {
-		ctx.Log.Warn(
-			"error reading zip",
-			map[string]any{
-				"err": err,
-			},
-		)
-		return result, err
+	var archiveFile *os.File
+	if id, ok := common.ArtifactReference(data); ok {
+		// The archive was uploaded separately and downloaded before grading.
+		f, err := os.Open(OutputOnlyArtifactPath(ctx, attemptID, id))
+		if errors.Is(err, fs.ErrNotExist) {
+			return result, fmt.Errorf("output-only artifact %s not found", id)
+		}
+		if err != nil {
+			return result, err
+		}
+		archiveFile = f
+	} else {
+		f, isDataURL, err := decodeOutputOnlyArchive(data, runRoot)
+		if !isDataURL {
+			// |data| is not a dataurl. Try just returning the data as an Entry.
+			ctx.Log.Info(
+				"data is not a dataurl. Generating Main.out",
+				nil,
+			)
+			result["Main.out"] = outputOnlyFile{data, false}
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		defer os.Remove(f.Name())
+		archiveFile = f
 	}
+	defer archiveFile.Close()
 
 	expectedFileNames := make(map[string]struct{})
 	for _, groupSettings := range settings.Cases {
@@ -435,19 +746,37 @@ func parseOutputOnlyFile(
 		}
 	}
 
-	for _, f := range z.File {
-		if !strings.HasSuffix(f.FileHeader.Name, ".out") {
+	fileCount := 0
+	err := walkOutputOnlyArchive(ctx.Context, archiveFile, func(
+		name string,
+		size uint64,
+		open func() (io.ReadCloser, error),
+	) error {
+		fileCount++
+		if maxFiles := ctx.Config.Runner.OutputOnlyMaxFiles; maxFiles > 0 && fileCount > maxFiles {
+			return fmt.Errorf(
+				"output-only archive has too many files (maximum %d)",
+				maxFiles,
+			)
+		}
+		if isUnsafeArchivePath(name) {
+			return fmt.Errorf(
+				"output-only archive contains an invalid path: %q",
+				name,
+			)
+		}
+		if !strings.HasSuffix(name, ".out") {
 			ctx.Log.Info(
 				"Output-only compressed file has invalid name. Skipping",
 				map[string]any{
-					"name": f.FileHeader.Name,
+					"name": name,
 				},
 			)
-			continue
+			return nil
 		}
 		// Some people just cannot follow instructions. Be a little bit more
 		// tolerant and skip any intermediate directories.
-		fileName := f.FileHeader.Name
+		fileName := name
 		if idx := strings.LastIndex(fileName, "/"); idx != -1 {
 			fileName = fileName[idx+1:]
 		}
@@ -455,66 +784,75 @@ func parseOutputOnlyFile(
 			ctx.Log.Info(
 				"Output-only compressed file not expected. Skipping",
 				map[string]any{
-					"name": f.FileHeader.Name,
+					"name": name,
 				},
 			)
-			continue
+			return nil
 		}
-		if f.FileHeader.UncompressedSize64 > uint64(settings.Limits.OutputLimit) {
+		if size > uint64(settings.Limits.OutputLimit) {
 			ctx.Log.Info(
 				"Output-only compressed file is too large. Generating empty file",
 				map[string]any{
-					"name": f.FileHeader.Name,
-					"size": f.FileHeader.UncompressedSize64,
+					"name": name,
+					"size": size,
 				},
 			)
 			result[fileName] = outputOnlyFile{"", true}
-			continue
+			return nil
 		}
 		if overallOutput > ctx.Config.Runner.OverallOutputLimit {
 			ctx.Log.Info(
 				"Output-only overall size limit has been exceeded. Generating empty file",
 				map[string]any{
-					"name":           f.FileHeader.Name,
+					"name":           name,
 					"overall output": overallOutput,
 					"limit":          ctx.Config.Runner.OverallOutputLimit,
 				},
 			)
 			result[fileName] = outputOnlyFile{"", true}
-			continue
+			return nil
 		}
-		rc, err := f.Open()
+		rc, err := open()
 		if err != nil {
-			ctx.Log.Info(
-				"Error opening file",
-				map[string]any{
-					"name": f.FileHeader.Name,
-					"err":  err,
-				},
-			)
-			continue
+			return fmt.Errorf("failed to open %q in the output-only archive: %w", name, err)
 		}
+		// The sizes in the archive headers cannot be trusted, so never read more
+		// than one byte past the limit.
 		var buf bytes.Buffer
-		_, err = io.Copy(&buf, rc)
+		_, err = io.Copy(&buf, io.LimitReader(rc, int64(settings.Limits.OutputLimit)+1))
 		rc.Close()
 		if err != nil {
+			return fmt.Errorf("failed to read %q in the output-only archive: %w", name, err)
+		}
+		if base.Byte(buf.Len()) > settings.Limits.OutputLimit {
 			ctx.Log.Info(
-				"Error reading file",
+				"Output-only compressed file is larger than its header claims. Generating empty file",
 				map[string]any{
-					"name": f.FileHeader.Name,
-					"err":  err,
+					"name": name,
+					"size": size,
 				},
 			)
-			continue
+			result[fileName] = outputOnlyFile{"", true}
+			return nil
 		}
 		result[fileName] = outputOnlyFile{buf.String(), false}
 		overallOutput += base.Byte(buf.Len())
+		return nil
+	})
+	if err != nil {
+		ctx.Log.Warn(
+			"error reading output-only archive",
+			map[string]any{
+				"err": err,
+			},
+		)
+		return result, err
 	}
 	return result, nil
 }
 
 func generateParentMountpoints(
-	runRoot string,
+	layout *RunLayout,
 	interactive *common.InteractiveSettings,
 ) map[string]string {
 	result := make(map[string]string)
@@ -522,7 +860,7 @@ func generateParentMountpoints(
 		if name == interactive.Main {
 			continue
 		}
-		for src, dst := range generateMountpoint(runRoot, name) {
+		for src, dst := range generateMountpoint(layout, name) {
 			result[src] = dst
 		}
 	}
@@ -530,30 +868,41 @@ func generateParentMountpoints(
 }
 
 func generateMountpoint(
-	runRoot string,
+	layout *RunLayout,
 	name string,
 ) map[string]string {
 	return map[string]string{
-		path.Join(
-			runRoot,
-			fmt.Sprintf("%s_pipes", name),
-		): path.Join(
-			"/home",
-			fmt.Sprintf("%s_pipes", name),
-		),
+		layout.PipesDir(name): path.Join("/home", pipesDirName(name)),
 	}
 }
 
+// validatorLimits returns the limits that the validator will run with. Any
+// limits that were not set by the problemsetter are taken from
+// common.DefaultValidatorLimits, except for the time limit, which is taken from
+// the problem.
 func validatorLimits(
 	limits *common.LimitsSettings,
 	validatorLimits *common.LimitsSettings,
 ) *common.LimitsSettings {
-	var limitsCopy common.LimitsSettings
-	if validatorLimits != nil {
-		limitsCopy = *validatorLimits
-	} else {
-		limitsCopy = common.DefaultValidatorLimits
-		limitsCopy.TimeLimit = limits.TimeLimit
+	limitsCopy := common.DefaultValidatorLimits
+	limitsCopy.TimeLimit = limits.TimeLimit
+	if validatorLimits == nil {
+		return &limitsCopy
+	}
+	if validatorLimits.ExtraWallTime != 0 {
+		limitsCopy.ExtraWallTime = validatorLimits.ExtraWallTime
+	}
+	if validatorLimits.MemoryLimit != 0 {
+		limitsCopy.MemoryLimit = validatorLimits.MemoryLimit
+	}
+	if validatorLimits.OutputLimit != 0 {
+		limitsCopy.OutputLimit = validatorLimits.OutputLimit
+	}
+	if validatorLimits.OverallWallTimeLimit != 0 {
+		limitsCopy.OverallWallTimeLimit = validatorLimits.OverallWallTimeLimit
+	}
+	if validatorLimits.TimeLimit != 0 {
+		limitsCopy.TimeLimit = validatorLimits.TimeLimit
 	}
 	return &limitsCopy
 }
@@ -585,6 +934,23 @@ func copyFile(src string, dst string) error {
 	return err
 }
 
+// A GroupResultListener is notified every time all the cases of a group have
+// been run and validated. It is called from a different goroutine than the
+// one that called Grade.
+type GroupResultListener func(groupResult *GroupResult)
+
+// A CaseResultListener is notified every time a case has been run and
+// validated, with the name of the group it belongs to. It is called from a
+// different goroutine than the one that called Grade.
+type CaseResultListener func(group string, caseResult *CaseResult)
+
+// CaseProgress is the result of a single case that is sent while the rest of
+// the run is still being graded.
+type CaseProgress struct {
+	Group string     `json:"group"`
+	Case  CaseResult `json:"case"`
+}
+
 // Grade compiles and runs a contestant-provided program, supplies it with the
 // Input-specified inputs, and computes its final score and verdict.
 func Grade(
@@ -594,17 +960,109 @@ func Grade(
 	input common.Input,
 	sandbox Sandbox,
 ) (*RunResult, error) {
-	runResult := NewRunResult("JE", run.MaxScore)
+	return GradeWithListener(ctx, filesWriter, run, input, sandbox, nil)
+}
+
+// GradeWithListener is the same as Grade, but it also notifies listener (if
+// non-nil) of the results of each group as soon as they are available.
+func GradeWithListener(
+	ctx *common.Context,
+	filesWriter io.Writer,
+	run *common.Run,
+	input common.Input,
+	sandbox Sandbox,
+	listener GroupResultListener,
+) (*RunResult, error) {
+	return GradeWithOptions(ctx, filesWriter, run, input, sandbox, GradeOptions{
+		Listener: listener,
+	})
+}
+
+// GradeOptions are the optional parameters of GradeWithOptions.
+type GradeOptions struct {
+	// Listener (if non-nil) is notified of the results of each group as soon
+	// as they are available.
+	Listener GroupResultListener
+
+	// CaseListener (if non-nil) is notified of the results of each case as
+	// soon as they are available.
+	CaseListener CaseResultListener
+
+	// BinaryCache (if non-nil) is used to avoid compiling the contestant's
+	// program if it was already compiled, by this runner or by another one
+	// with the same BinaryFingerprint.
+	BinaryCache       BinaryCache
+	BinaryFingerprint string
+
+	// Environment (if non-nil) describes where the run is graded. It is
+	// recorded in the RunResult, together with the time factor of the
+	// language of the run.
+	Environment *ExecutionEnvironment
+
+	// Layout (if non-nil) determines where the files of the run are stored.
+	// It defaults to NewRunLayout for the runtime path and the attempt.
+	Layout *RunLayout
+
+	// Clock (if non-nil) is used to measure how long each phase of the run
+	// takes. It defaults to SystemClock.
+	Clock Clock
+}
+
+// GradeWithOptions is the same as Grade, but with additional options.
+func GradeWithOptions(
+	ctx *common.Context,
+	filesWriter io.Writer,
+	run *common.Run,
+	input common.Input,
+	sandbox Sandbox,
+	opts GradeOptions,
+) (*RunResult, error) {
+	listener := opts.Listener
+	runResult := NewRunResult(common.VerdictJudgeError, run.MaxScore)
+	if opts.Environment != nil {
+		environment := *opts.Environment
+		environment.TimeFactor = timeFactor(&ctx.Config.Runner, run.Language)
+		runResult.Environment = &environment
+	}
 	if !sandbox.Supported() {
 		return runResult, errors.New("Sandbox not supported")
 	}
-	runRoot := path.Join(
-		ctx.Config.Runner.RuntimePath,
-		"grade",
-		strconv.FormatUint(run.AttemptID, 10),
-	)
+	layout := opts.Layout
+	if layout == nil {
+		layout = NewRunLayout(ctx.Config.Runner.RuntimePath, run.AttemptID)
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
 	if !ctx.Config.Runner.PreserveFiles {
-		defer os.RemoveAll(runRoot)
+		defer os.RemoveAll(layout.Root)
+	}
+	runResult.Timings.RunRootStorage = RunRootStorageDisk
+	// Preserved files would be lost once the tmpfs is unmounted.
+	if size := ctx.Config.Runner.TmpfsRunRootSize; size > 0 && !ctx.Config.Runner.PreserveFiles {
+		if err := layout.MountTmpfs(size); err != nil {
+			ctx.Log.Warn(
+				"Failed to mount the run root on a tmpfs, using the disk instead",
+				map[string]any{
+					"root": layout.Root,
+					"err":  err,
+				},
+			)
+		} else {
+			runResult.Timings.RunRootStorage = RunRootStorageTmpfs
+			defer func() {
+				if err := layout.Unmount(); err != nil {
+					ctx.Log.Error(
+						"Failed to unmount the run root tmpfs",
+						map[string]any{
+							"root": layout.Root,
+							"err":  err,
+						},
+					)
+				}
+			}()
+		}
 	}
 
 	ctx.Log.Info(
@@ -615,15 +1073,32 @@ func Grade(
 	)
 
 	generatedFiles := make([]string, 0)
-	defer func() {
-		defer ctx.Transaction.StartSegment("upload").End()
-		if err := uploadFiles(
+	// caseVerdicts are the verdicts of running each case, before their outputs
+	// are validated. They decide which files are truncated first if the
+	// artifacts do not fit in Runner.MaxArtifactSize.
+	caseVerdicts := make(map[string]common.Verdict)
+	// uploadGeneratedFiles uploads the generated files. Transaction segments
+	// can only be started from the goroutine of the transaction, so traced must
+	// be false when it is called from another goroutine.
+	uploadGeneratedFiles := func(generatedFiles []string, traced bool) {
+		if traced {
+			defer ctx.Transaction.StartSegment("upload").End()
+		}
+		uploadStart := clock.Now()
+		defer func() {
+			runResult.Timings.Upload = clock.Now().Sub(uploadStart).Seconds()
+		}()
+		truncated, err := uploadFiles(
 			ctx,
 			filesWriter,
-			runRoot,
+			layout.Root,
 			input,
 			generatedFiles,
-		); err != nil {
+			func(file string) int {
+				return artifactPriority(file, caseVerdicts)
+			},
+		)
+		if err != nil {
 			ctx.Log.Error(
 				"uploadFiles failed",
 				map[string]any{
@@ -631,6 +1106,26 @@ func Grade(
 				},
 			)
 		}
+		if len(truncated) > 0 {
+			ctx.Log.Warn(
+				"Artifacts truncated to fit in the size limit",
+				map[string]any{
+					"files": truncated,
+					"limit": ctx.Config.Runner.MaxArtifactSize,
+				},
+			)
+			runResult.TruncatedArtifacts = truncated
+		}
+	}
+	// uploadDone is non-nil if the upload was started before all the outputs
+	// were validated.
+	var uploadDone chan struct{}
+	defer func() {
+		if uploadDone != nil {
+			<-uploadDone
+			return
+		}
+		uploadGeneratedFiles(generatedFiles, true)
 	}()
 
 	var binaries []*binary
@@ -638,18 +1133,61 @@ func Grade(
 	runResult.CompileMeta = make(map[string]RunMetadata)
 
 	settings := *input.Settings()
+	if err := settings.ValidateWeights(); err != nil {
+		ctx.Log.Warn(
+			"Case weights do not add up to 1, set NormalizeWeights to normalize them",
+			map[string]any{
+				"err": err,
+			},
+		)
+	}
+	usesPoints := settings.UsesPoints()
+	settings.Cases = settings.ScoringCases()
+	if run.LimitsOverride != nil {
+		run.LimitsOverride.Apply(&settings.Limits)
+	}
 
-	// totalWeightFactor is used to normalize all the weights in the case data.
-	totalWeightFactor := new(big.Rat)
-	for _, group := range settings.Cases {
-		for _, caseData := range group.Cases {
-			totalWeightFactor.Add(totalWeightFactor, caseData.Weight)
+	// The hooks are part of the problem, so they always run without network
+	// access.
+	hookSandbox := sandbox
+	hooks, hookCompileMeta, hookFiles, err := compileHooks(ctx, input, hookSandbox, &settings, layout)
+	for name, meta := range hookCompileMeta {
+		runResult.CompileMeta[name] = meta
+	}
+	generatedFiles = append(generatedFiles, hookFiles...)
+	if err != nil {
+		return runResult, err
+	}
+	source := run.Source
+	if run.Language != "cat" {
+		source, hookFiles, err = hooks.source(ctx, hookSandbox, layout, run)
+		generatedFiles = append(generatedFiles, hookFiles...)
+		if err != nil {
+			return runResult, err
 		}
 	}
-	if totalWeightFactor.Cmp(new(big.Rat)) <= 0 {
-		totalWeightFactor = big.NewRat(1, 1)
-	} else {
-		totalWeightFactor.Quo(big.NewRat(1, 1), totalWeightFactor)
+
+	if settings.NetworkPolicy != common.NetworkPolicyDefault &&
+		settings.NetworkPolicy != common.NetworkPolicyNone {
+		networkSandbox, ok := sandbox.(NetworkSandbox)
+		if !ok {
+			return runResult, fmt.Errorf(
+				"sandbox does not support the %q network policy",
+				settings.NetworkPolicy,
+			)
+		}
+		if sandbox, err = networkSandbox.WithNetworkPolicy(settings.NetworkPolicy); err != nil {
+			return runResult, err
+		}
+	}
+
+	// totalWeightFactor converts the weights in the case data into fractions
+	// of the run's score. Weights are used as-is (ScoringCases already
+	// normalized them if requested), and absolute points are fractions of the
+	// run's MaxScore.
+	totalWeightFactor := big.NewRat(1, 1)
+	if usesPoints && runResult.MaxScore != nil && runResult.MaxScore.Sign() > 0 {
+		totalWeightFactor.Inv(runResult.MaxScore)
 	}
 
 	interactive := settings.Interactive
@@ -673,19 +1211,19 @@ func Grade(
 				name:             interactive.Main,
 				target:           target,
 				language:         lang,
-				binPath:          path.Join(runRoot, interactive.Main, "bin"),
+				binPath:          layout.BinPath(interactive.Main),
 				outputPathPrefix: "",
 				binaryType:       binaryProblemsetter,
 				limits:           *validatorLimits(&settings.Limits, settings.Validator.Limits),
 				receiveInput:     true,
 				sourceFiles: normalizedSourceFiles(
-					runRoot,
+					layout,
 					interactive.ParentLang,
 					interactive.Main,
 					interactive.Interfaces[interactive.Main][interactive.ParentLang],
 				),
-				extraFlags:       extraParentFlags(interactive.ParentLang),
-				extraMountPoints: generateParentMountpoints(runRoot, interactive),
+				extraFlags:       ctx.Config.Runner.Language(interactive.ParentLang).ParentFlags,
+				extraMountPoints: generateParentMountpoints(layout, interactive),
 			},
 		}
 		for name, langIface := range interactive.Interfaces {
@@ -694,7 +1232,7 @@ func Grade(
 			}
 			iface, ok := langIface[common.LanguageFileExtension(run.Language)]
 			if !ok {
-				runResult.Verdict = "CE"
+				runResult.Verdict = common.VerdictCompileError
 				compileError := fmt.Sprintf("libinteractive does not support language '%s'", run.Language)
 				runResult.CompileError = &compileError
 				return runResult, nil
@@ -706,27 +1244,26 @@ func Grade(
 					name:             name,
 					target:           target,
 					language:         run.Language,
-					binPath:          path.Join(runRoot, name, "bin"),
+					binPath:          layout.BinPath(name),
 					outputPathPrefix: name,
 					binaryType:       binaryContestant,
 					limits:           settings.Limits,
 					receiveInput:     false,
 					sourceFiles: normalizedSourceFiles(
-						runRoot,
+						layout,
 						run.Language,
 						name,
 						iface,
 					),
 					extraFlags:       []string{},
-					extraMountPoints: generateMountpoint(runRoot, name),
+					extraMountPoints: generateMountpoint(layout, name),
 				},
 			)
 		}
 
 		// Setup all source files.
 		for _, bin := range binaries {
-			binPath := path.Join(runRoot, bin.name, "bin")
-			if err := os.MkdirAll(binPath, 0755); err != nil {
+			if err := os.MkdirAll(layout.BinPath(bin.name), 0755); err != nil {
 				return runResult, err
 			}
 		}
@@ -739,9 +1276,9 @@ func Grade(
 				),
 			),
 			path.Join(
-				runRoot,
+				layout.BinPath(interactive.Main),
 				fmt.Sprintf(
-					"Main/bin/Main.%s",
+					"Main.%s",
 					common.LanguageFileExtension(interactive.ParentLang),
 				),
 			),
@@ -756,10 +1293,7 @@ func Grade(
 				lang = common.LanguageFileExtension(run.Language)
 			}
 			for filename, contents := range langIface[lang].Files {
-				sourcePath := path.Join(
-					runRoot,
-					fmt.Sprintf("%s/bin/%s", name, path.Base(filename)),
-				)
+				sourcePath := path.Join(layout.BinPath(name), path.Base(filename))
 				err := ioutil.WriteFile(sourcePath, []byte(contents), 0644)
 				if err != nil {
 					return runResult, err
@@ -770,45 +1304,28 @@ func Grade(
 					if ifaceName == "Main" {
 						continue
 					}
-					pipesMountPath := path.Join(
-						runRoot,
-						name,
-						"bin",
-						fmt.Sprintf("%s_pipes", ifaceName),
-					)
-					if err := os.MkdirAll(pipesMountPath, 0755); err != nil {
+					if err := os.MkdirAll(layout.PipesMountPoint(name, ifaceName), 0755); err != nil {
 						return runResult, err
 					}
 				}
 				continue
 			}
 			sourcePath := path.Join(
-				runRoot,
-				name,
-				"bin",
+				layout.BinPath(name),
 				fmt.Sprintf(
 					"%s.%s",
 					interactive.ModuleName,
 					common.LanguageFileExtension(run.Language),
 				),
 			)
-			err := ioutil.WriteFile(sourcePath, []byte(run.Source), 0644)
+			err := ioutil.WriteFile(sourcePath, []byte(source), 0644)
 			if err != nil {
 				return runResult, err
 			}
-			pipesMountPath := path.Join(
-				runRoot,
-				name,
-				"bin",
-				fmt.Sprintf("%s_pipes", name),
-			)
-			if err := os.MkdirAll(pipesMountPath, 0755); err != nil {
+			if err := os.MkdirAll(layout.PipesMountPoint(name, name), 0755); err != nil {
 				return runResult, err
 			}
-			pipesPath := path.Join(
-				runRoot,
-				fmt.Sprintf("%s_pipes", name),
-			)
+			pipesPath := layout.PipesDir(name)
 			if err := os.MkdirAll(pipesPath, 0755); err != nil {
 				return runResult, err
 			}
@@ -821,7 +1338,7 @@ func Grade(
 		}
 	} else {
 		// Setup all source files.
-		mainBinPath := path.Join(runRoot, "Main", "bin")
+		mainBinPath := layout.BinPath("Main")
 		if err := os.MkdirAll(mainBinPath, 0755); err != nil {
 			return runResult, err
 		}
@@ -829,21 +1346,20 @@ func Grade(
 			mainBinPath,
 			fmt.Sprintf("Main.%s", common.LanguageFileExtension(run.Language)),
 		)
-		err := ioutil.WriteFile(mainSourcePath, []byte(run.Source), 0644)
-		if err != nil {
+		if err := ioutil.WriteFile(mainSourcePath, []byte(source), 0644); err != nil {
 			return runResult, err
 		}
 
 		if run.Language == "cat" {
-			outputOnlyFiles, err = parseOutputOnlyFile(ctx, run.Source, &settings)
+			outputOnlyFiles, err = parseOutputOnlyFile(ctx, run.AttemptID, run.Source, &settings, layout.Root)
 			if err != nil {
-				runResult.Verdict = "CE"
+				runResult.Verdict = common.VerdictCompileError
 				compileError := err.Error()
 				runResult.CompileError = &compileError
 				return runResult, nil
 			}
 			runResult.CompileMeta["Main"] = RunMetadata{
-				Verdict: "OK",
+				Verdict: common.VerdictOK,
 			}
 			binaries = []*binary{}
 		} else {
@@ -877,13 +1393,16 @@ func Grade(
 		}
 	}
 
-	validatorBinPath := path.Join(runRoot, "validator", "bin")
+	validatorBinPath := layout.BinPath("validator")
 	regularBinaryCount := len(binaries)
-	if settings.Validator.Name == common.ValidatorNameCustom {
+	validatorLang, customValidatorLimits, err := settings.CustomValidatorLang()
+	if err != nil {
+		return runResult, err
+	}
+	if validatorLang != "" {
 		if err := os.MkdirAll(validatorBinPath, 0755); err != nil {
 			return runResult, err
 		}
-		validatorLang := *settings.Validator.Lang
 		// The file will always have the actual language as the extension.
 		validatorInputFile := path.Join(
 			input.Path(),
@@ -907,7 +1426,7 @@ func Grade(
 				binPath:          validatorBinPath,
 				outputPathPrefix: "validator",
 				binaryType:       binaryValidator,
-				limits:           *validatorLimits(&settings.Limits, settings.Validator.Limits),
+				limits:           *validatorLimits(&settings.Limits, customValidatorLimits),
 				receiveInput:     false,
 				sourceFiles:      []string{validatorSourceFile},
 				extraFlags:       []string{},
@@ -916,10 +1435,46 @@ func Grade(
 		)
 	}
 
+	// Only the contestant's program in non-interactive problems is cached,
+	// since it is the only binary that does not depend on the problem, unless
+	// its source is changed by a hook.
+	binaryCacheKey := ""
+	if opts.BinaryCache != nil && interactive == nil && hooks.preCompile == nil {
+		binaryCacheKey = BinaryCacheKey(run, opts.BinaryFingerprint)
+	}
+
 	compileSegment := ctx.Transaction.StartSegment("compile")
+	compileStart := clock.Now()
 	for _, b := range binaries {
-		binRoot := path.Join(runRoot, b.name)
-		binPath := path.Join(binRoot, "bin")
+		binRoot := layout.BinRoot(b.name)
+		binPath := layout.BinPath(b.name)
+
+		cacheable := binaryCacheKey != "" && b.binaryType == binaryContestant
+		if cacheable {
+			ok, err := opts.BinaryCache.Get(ctx, binaryCacheKey, binPath)
+			if err != nil {
+				ctx.Log.Warn(
+					"Failed to get cached binary",
+					map[string]any{
+						"key": binaryCacheKey,
+						"err": err,
+					},
+				)
+			} else if ok {
+				ctx.Log.Info(
+					"Using cached binary",
+					map[string]any{
+						"key": binaryCacheKey,
+					},
+				)
+				runResult.CompileMeta[b.name] = RunMetadata{
+					Verdict:         common.VerdictOK,
+					Cached:          true,
+					CompilerVersion: sandboxCompilerVersion(ctx, sandbox, b.language),
+				}
+				continue
+			}
+		}
 
 		singleCompileSegment := ctx.Transaction.StartSegment(fmt.Sprintf("%s (%s)", b.name, b.language))
 		lang := b.language
@@ -939,6 +1494,24 @@ func Grade(
 			b.extraFlags,
 		)
 		singleCompileSegment.End()
+		if compileMeta != nil {
+			for _, outputFile := range []string{"compile.out", "compile.err"} {
+				truncated, err := truncateCompileOutput(
+					path.Join(binRoot, outputFile),
+					ctx.Config.Runner.CompileErrorLimit,
+				)
+				if err != nil {
+					ctx.Log.Error(
+						"Failed to truncate the compiler output",
+						map[string]any{
+							"file": path.Join(b.name, outputFile),
+							"err":  err,
+						},
+					)
+				}
+				compileMeta.OutputTruncated = compileMeta.OutputTruncated || truncated
+			}
+		}
 		generatedFiles = append(
 			generatedFiles,
 			path.Join(b.name, "compile.out"),
@@ -947,10 +1520,11 @@ func Grade(
 		)
 
 		if compileMeta != nil {
+			compileMeta.CompilerVersion = sandboxCompilerVersion(ctx, sandbox, lang)
 			runResult.CompileMeta[b.name] = *compileMeta
 		}
 
-		if err != nil || compileMeta.Verdict != "OK" {
+		if err != nil || compileMeta.Verdict != common.VerdictOK {
 			ctx.Log.Error(
 				"Compile error",
 				map[string]any{
@@ -958,45 +1532,100 @@ func Grade(
 					"compileMeta": compileMeta,
 				},
 			)
-			runResult.Verdict = "CE"
-			compileErrorFile := "compile.err"
-			if b.language == "pas" || b.language == "cs" {
-				// Lazarus and dotnet writes the output of the compile error in compile.out.
-				compileErrorFile = "compile.out"
-			} else {
-				compileErrorFile = "compile.err"
-			}
+			runResult.Verdict = common.VerdictCompileError
 			compileError := fmt.Sprintf(
 				"%s:\n%s",
 				b.name,
-				getCompileError(path.Join(binRoot, compileErrorFile)),
+				compileErrorOutput(binRoot, b.language),
 			)
 			runResult.CompileError = &compileError
 			compileSegment.End()
+			runResult.Timings.Compile = clock.Now().Sub(compileStart).Seconds()
 			return runResult, err
 		}
+
+		if cacheable {
+			if err := opts.BinaryCache.Put(ctx, binaryCacheKey, binPath); err != nil {
+				ctx.Log.Warn(
+					"Failed to store binary in the cache",
+					map[string]any{
+						"key": binaryCacheKey,
+						"err": err,
+					},
+				)
+			}
+		}
 	}
 	compileSegment.End()
+	runResult.Timings.Compile = clock.Now().Sub(compileStart).Seconds()
+
+	groupResults := make([]GroupResult, len(settings.Cases))
+	runResult.Verdict = common.VerdictOK
+
+	// executedGroups are the indices of the groups in the order in which they
+	// ran, which is also the order in which they are validated.
+	executedGroups := make([]int, 0, len(settings.Cases))
 
-	groupResults := make([]GroupResult, 0, len(settings.Cases))
-	runResult.Verdict = "OK"
 	runSegment := ctx.Transaction.StartSegment("run")
-	for _, group := range settings.Cases {
+	runStart := clock.Now()
+	// failedGroups are the groups that have already run and that have a case
+	// that did not run successfully.
+	failedGroups := make(map[string]struct{})
+	for _, i := range groupExecutionOrder(settings.Cases) {
+		group := settings.Cases[i]
 		caseResults := make([]CaseResult, 0, len(group.Cases))
+		// skipReason is set once the rest of the cases of the group are not
+		// going to be run.
+		skipReason := ""
+		for _, dependency := range group.DependsOn {
+			if _, ok := failedGroups[dependency]; ok {
+				skipReason = SkippedDependency
+				break
+			}
+		}
 		for _, caseData := range group.Cases {
+			if err := ctx.Context.Err(); err != nil {
+				ctx.Log.Error(
+					"Run cancelled",
+					map[string]any{
+						"case": caseData.Name,
+						"err":  err,
+					},
+				)
+				runSegment.End()
+				return runResult, err
+			}
 			var runMeta *RunMetadata
 			var individualMeta = make(map[string]RunMetadata)
-			if runResult.WallTime > settings.Limits.OverallWallTimeLimit.Seconds() {
+			caseLimits := settings.Limits
+			caseData.ApplyLimits(&caseLimits)
+			remainingWallTime := settings.Limits.OverallWallTimeLimit -
+				base.Duration(time.Duration(runResult.WallTime*float64(time.Second)))
+			if skipReason != "" {
 				ctx.Log.Debug(
-					"Not even running since the wall time limit has been exceeded",
+					"Not even running the case",
 					map[string]any{
-						"case":      caseData.Name,
-						"wall time": runResult.WallTime,
-						"limit":     settings.Limits.OverallWallTimeLimit.Seconds(),
+						"case":   caseData.Name,
+						"reason": skipReason,
 					},
 				)
 				runMeta = &RunMetadata{
-					Verdict: "TLE",
+					Verdict: common.VerdictSkipped,
+					Skipped: skipReason,
+				}
+			} else if remainingWallTime <= 0 {
+				ctx.Log.Debug(
+					"Not even running since the wall time limit has been exceeded",
+					map[string]any{
+						"case":      caseData.Name,
+						"wall time": runResult.WallTime,
+						"limit":     settings.Limits.OverallWallTimeLimit.Seconds(),
+					},
+				)
+				runResult.Verdict = runResult.Verdict.Worse(common.VerdictTimeLimitExceeded)
+				runMeta = &RunMetadata{
+					Verdict: common.VerdictSkipped,
+					Skipped: SkippedWallTimeLimit,
 				}
 			} else if runResult.OverallOutput > ctx.Config.Runner.OverallOutputLimit {
 				ctx.Log.Debug(
@@ -1007,15 +1636,18 @@ func Grade(
 						"limit":          ctx.Config.Runner.OverallOutputLimit,
 					},
 				)
+				runResult.Verdict = runResult.Verdict.Worse(common.VerdictOutputLimitExceeded)
 				runMeta = &RunMetadata{
-					Verdict: "OLE",
+					Verdict: common.VerdictSkipped,
+					Skipped: SkippedOverallOutputLimit,
 				}
 			} else if run.Language == "cat" {
-				outName := fmt.Sprintf("%s.out", caseData.Name)
-				errName := fmt.Sprintf("%s.err", caseData.Name)
-				metaName := fmt.Sprintf("%s.meta", caseData.Name)
-				outPath := path.Join(runRoot, outName)
-				metaPath := path.Join(runRoot, metaName)
+				extractCase(ctx, input, caseData.Name)
+				outName := caseFileName("", caseData.Name, "out")
+				errName := caseFileName("", caseData.Name, "err")
+				metaName := caseFileName("", caseData.Name, "meta")
+				outPath := layout.Path(outName)
+				metaPath := layout.Path(metaName)
 				if file, ok := outputOnlyFiles[outName]; ok {
 					if err := ioutil.WriteFile(outPath, []byte(file.contents), 0644); err != nil {
 						ctx.Log.Error(
@@ -1028,11 +1660,11 @@ func Grade(
 						)
 					}
 					runMeta = &RunMetadata{
-						Verdict:    "OK",
+						Verdict:    common.VerdictOK,
 						OutputSize: base.Byte(len(file.contents)),
 					}
 					if file.ole {
-						runMeta.Verdict = "OLE"
+						runMeta.Verdict = common.VerdictOutputLimitExceeded
 					}
 					if err := ioutil.WriteFile(metaPath, []byte("status:0"), 0644); err != nil {
 						ctx.Log.Error(
@@ -1063,7 +1695,7 @@ func Grade(
 						)
 					}
 					runMeta = &RunMetadata{
-						Verdict: "RTE",
+						Verdict: common.VerdictRuntimeError,
 					}
 					if err := ioutil.WriteFile(metaPath, []byte("status:1"), 0644); err != nil {
 						ctx.Log.Error(
@@ -1076,7 +1708,7 @@ func Grade(
 						)
 					}
 				}
-				errPath := path.Join(runRoot, errName)
+				errPath := layout.Path(errName)
 				if err := ioutil.WriteFile(errPath, []byte{}, 0644); err != nil {
 					ctx.Log.Error(
 						"failed to write err file",
@@ -1088,153 +1720,107 @@ func Grade(
 					)
 				}
 				generatedFiles = append(generatedFiles, outName, errName, metaName)
+			} else if hookMeta, hookFiles, err := hooks.runPreCase(
+				ctx,
+				hookSandbox,
+				input,
+				layout,
+				run,
+				caseData.Name,
+			); err != nil {
+				ctx.Log.Error(
+					"Failed to run the pre-case hook",
+					map[string]any{
+						"case": caseData.Name,
+						"err":  err,
+					},
+				)
+				generatedFiles = append(generatedFiles, hookFiles...)
+				runMeta = &RunMetadata{
+					Verdict: common.VerdictJudgeError,
+				}
+				if hookMeta != nil {
+					individualMeta[hookPreCase] = *hookMeta
+				}
 			} else {
-				singleRunSegment := ctx.Transaction.StartSegment("case " + caseData.Name)
-				metaChan := make(chan intermediateRunResult, regularBinaryCount)
-				for _, bin := range binaries {
-					if bin.binaryType == binaryValidator {
-						continue
-					}
-					go func(bin *binary, caseData *common.CaseSettings) {
-						var inputPath string
-						if bin.receiveInput {
-							inputPath = path.Join(
-								input.Path(),
-								"cases",
-								fmt.Sprintf("%s.in", caseData.Name),
-							)
-						} else {
-							inputPath = "/dev/null"
-						}
-						extraParams := make([]string, 0)
-						if bin.binaryType == binaryProblemsetter {
-							extraParams = append(extraParams, caseData.Name, run.Language)
-						}
-						singleBinarySegment := ctx.Transaction.StartSegment(
-							fmt.Sprintf("%s - %s", caseData.Name, bin.name),
-						)
-						runMeta, err := sandbox.Run(
-							ctx,
-							&bin.limits,
-							bin.language,
-							bin.binPath,
-							inputPath,
-							path.Join(
-								runRoot,
-								bin.outputPathPrefix,
-								fmt.Sprintf("%s.out", caseData.Name),
-							),
-							path.Join(
-								runRoot,
-								bin.outputPathPrefix,
-								fmt.Sprintf("%s.err", caseData.Name),
-							),
-							path.Join(
-								runRoot,
-								bin.outputPathPrefix,
-								fmt.Sprintf("%s.meta", caseData.Name),
-							),
-							bin.target,
-							nil,
-							nil,
-							nil,
-							extraParams,
-							bin.extraMountPoints,
-						)
-						if err != nil {
-							ctx.Log.Error(
-								"failed to run",
-								map[string]any{
-									"caseName":  caseData.Name,
-									"interface": bin.name,
-									"err":       err,
-								},
-							)
-						}
-						generatedFiles := []string{
-							path.Join(
-								bin.outputPathPrefix,
-								fmt.Sprintf("%s.out", caseData.Name),
-							),
-							path.Join(
-								bin.outputPathPrefix,
-								fmt.Sprintf("%s.err", caseData.Name),
-							),
-							path.Join(
-								bin.outputPathPrefix,
-								fmt.Sprintf("%s.meta", caseData.Name),
-							),
-						}
-						singleBinarySegment.End()
-						metaChan <- intermediateRunResult{
-							bin.name,
-							runMeta,
-							bin.binaryType,
-							generatedFiles,
-						}
-					}(bin, &caseData)
+				generatedFiles = append(generatedFiles, hookFiles...)
+				var caseFiles []string
+				extractCase(ctx, input, caseData.Name)
+				caseBinaries, budgeted := budgetBinaries(
+					caseLimitsBinaries(binaries, &caseData),
+					remainingWallTime,
+				)
+				stabilizationTimeLimit := caseLimits.TimeLimit
+				if budgeted {
+					ctx.Log.Debug(
+						"Limiting the case to the remaining wall time",
+						map[string]any{
+							"case":      caseData.Name,
+							"remaining": remainingWallTime,
+						},
+					)
+					// Re-running the case would only exceed the overall wall time
+					// limit further.
+					stabilizationTimeLimit = 0
 				}
-				var parentMetadata *RunMetadata
-				chosenMetadata := RunMetadata{
-					Verdict: "OK",
+				runMeta, individualMeta, caseFiles = runStabilizedCase(
+					ctx,
+					run,
+					input,
+					sandbox,
+					caseBinaries,
+					regularBinaryCount,
+					layout,
+					&caseData,
+					hooks.caseInput(input, layout, caseData.Name),
+					stabilizationTimeLimit,
+				)
+				generatedFiles = append(generatedFiles, caseFiles...)
+				if hookMeta != nil {
+					individualMeta[hookPreCase] = *hookMeta
 				}
-				chosenMetadataEmpty := true
-				var finalVerdict = "OK"
-				var totalTime float64
-				var totalWallTime float64
-				var totalMemory base.Byte
-				var totalOutput base.Byte
-				for i := 0; i < regularBinaryCount; i++ {
-					intermediateResult := <-metaChan
-					generatedFiles = append(generatedFiles, intermediateResult.generatedFiles...)
-					if regularBinaryCount != 1 {
-						// Only populate invidualMeta if there is more than one binary.
-						individualMeta[intermediateResult.name] = *intermediateResult.runMeta
-					}
-					if intermediateResult.binaryType == binaryProblemsetter {
-						parentMetadata = intermediateResult.runMeta
-					} else {
-						if intermediateResult.runMeta.Verdict != "OK" {
-							if chosenMetadataEmpty {
-								chosenMetadata = *intermediateResult.runMeta
-								chosenMetadataEmpty = false
-							}
-						}
-						finalVerdict = worseVerdict(
-							finalVerdict,
-							intermediateResult.runMeta.Verdict,
-						)
-						totalTime += intermediateResult.runMeta.Time
-						totalWallTime = math.Max(
-							totalWallTime,
-							intermediateResult.runMeta.WallTime,
-						)
-						totalMemory += intermediateResult.runMeta.Memory
-						totalOutput += intermediateResult.runMeta.OutputSize
-					}
+			}
+			if runMeta.Verdict == common.VerdictOK {
+				hookMeta, hookFiles, err := hooks.runPostCase(ctx, hookSandbox, layout, run, caseData.Name)
+				generatedFiles = append(generatedFiles, hookFiles...)
+				if err != nil {
+					ctx.Log.Error(
+						"Failed to run the post-case hook",
+						map[string]any{
+							"case": caseData.Name,
+							"err":  err,
+						},
+					)
+					runMeta.Verdict = common.VerdictJudgeError
+				}
+				if hookMeta != nil {
+					individualMeta[hookPostCase] = *hookMeta
+				}
+			}
+			if runMeta.Verdict != common.VerdictOK {
+				failedGroups[group.Name] = struct{}{}
+				if group.FailFast && skipReason == "" {
+					skipReason = SkippedFailFast
 				}
-				close(metaChan)
-				singleRunSegment.End()
-				chosenMetadata.Verdict = finalVerdict
-				chosenMetadata.Time = totalTime
-				chosenMetadata.WallTime = totalWallTime
-				chosenMetadata.Memory = totalMemory
-				chosenMetadata.OutputSize = totalOutput
-
-				runMeta = mergeVerdict(ctx, &chosenMetadata, parentMetadata)
 			}
-			runResult.Verdict = worseVerdict(runResult.Verdict, runMeta.Verdict)
+			caseVerdicts[caseData.Name] = runMeta.Verdict
+			runResult.Verdict = runResult.Verdict.Worse(runMeta.Verdict)
 			runResult.Time += runMeta.Time
 			runResult.WallTime += runMeta.WallTime
 			runResult.Memory = base.Max(runResult.Memory, runMeta.Memory)
 			runResult.OverallOutput += runMeta.OutputSize
+			runResult.OverallError += runMeta.ErrorSize
 
 			// TODO: change CaseResult to split original metadatas and final metadata
 			caseResults = append(caseResults, CaseResult{
 				Name:           caseData.Name,
 				Verdict:        runMeta.Verdict,
+				OutputSize:     runMeta.OutputSize,
+				ErrorSize:      runMeta.ErrorSize,
 				Meta:           *runMeta,
 				IndividualMeta: individualMeta,
+				Visibility:     caseData.Visibility,
+				TimeLimitRatio: timeLimitRatio(runMeta, caseLimits.TimeLimit),
 
 				Score:        &big.Rat{},
 				ContestScore: &big.Rat{},
@@ -1244,7 +1830,7 @@ func Grade(
 				),
 			})
 		}
-		groupResults = append(groupResults, GroupResult{
+		groupResults[i] = GroupResult{
 			Group: group.Name,
 			Cases: caseResults,
 
@@ -1254,29 +1840,516 @@ func Grade(
 				runResult.MaxScore,
 				new(big.Rat).Mul(group.Weight(), totalWeightFactor),
 			),
-		})
+		}
+		executedGroups = append(executedGroups, i)
 	}
 	runSegment.End()
+	runResult.Timings.Run = clock.Now().Sub(runStart).Seconds()
+
+	if validatorLang == "" && hooks.scorer == nil {
+		// Only custom validators and the scorer generate files, so all of them
+		// are already present. The upload can start while the outputs are
+		// validated, since the contestant's programs are no longer running.
+		uploadDone = make(chan struct{})
+		go func(generatedFiles []string) {
+			defer close(uploadDone)
+			uploadGeneratedFiles(generatedFiles, false)
+		}(generatedFiles)
+	}
+
+	// The outputs are only validated once all the cases have run, so that the
+	// validators do not compete for the CPU with the contestant's programs.
+	validation := validateGroups(
+		ctx,
+		run,
+		input,
+		sandbox,
+		&settings,
+		layout,
+		clock,
+		validatorBinPath,
+		hooks,
+		totalWeightFactor,
+		runResult.MaxScore,
+		groupResults,
+		executedGroups,
+		listener,
+		opts.CaseListener,
+	)
+	runResult.Timings.Validate = validation.duration.Seconds()
+	runResult.Verdict = runResult.Verdict.Worse(validation.verdict)
+	runResult.Score.Add(runResult.Score, validation.score)
+	generatedFiles = append(generatedFiles, validation.generatedFiles...)
+
+	runResult.Groups = groupResults
+
+	if runResult.Verdict == common.VerdictPartiallyAccepted && runResult.Score.Cmp(&big.Rat{}) == 0 {
+		runResult.Verdict = common.VerdictWrongAnswer
+	} else if runResult.Verdict == common.VerdictOK {
+		runResult.Verdict = common.VerdictAccepted
+		runResult.Score = big.NewRat(1, 1)
+	}
+	scorerFiles, err := hooks.runScorer(
+		ctx,
+		hookSandbox,
+		layout,
+		run,
+		&settings,
+		totalWeightFactor,
+		runResult,
+	)
+	generatedFiles = append(generatedFiles, scorerFiles...)
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to run the scorer",
+			map[string]any{
+				"id":  run.AttemptID,
+				"err": err,
+			},
+		)
+		runResult.Verdict = runResult.Verdict.Worse(common.VerdictJudgeError)
+		runResult.Score = &big.Rat{}
+	}
+	runResult.ContestScore = new(big.Rat).Mul(
+		runResult.MaxScore,
+		runResult.Score,
+	)
+
+	ctx.Log.Debug(
+		"Finished running",
+		map[string]any{
+			"id":      run.AttemptID,
+			"verdict": runResult.Verdict,
+			"score":   runResult.Score,
+		},
+	)
+
+	return runResult, nil
+}
+
+// extractCase makes the files of the case available, for Inputs that only
+// extract them once they are needed.
+func extractCase(ctx *common.Context, input common.Input, caseName string) {
+	lazyInput, ok := input.(common.LazyInput)
+	if !ok {
+		return
+	}
+	if err := lazyInput.ExtractCase(caseName); err != nil {
+		ctx.Log.Error(
+			"Failed to extract case",
+			map[string]any{
+				"case": caseName,
+				"err":  err,
+			},
+		)
+	}
+}
+
+// runCase runs all the non-validator binaries for a single case, giving the
+// file in inputPath to the ones that receive the input. It returns the merged
+// metadata, the metadata of each binary (only if there is more than one), and
+// the list of files that were generated.
+func runCase(
+	ctx *common.Context,
+	run *common.Run,
+	input common.Input,
+	sandbox Sandbox,
+	binaries []*binary,
+	regularBinaryCount int,
+	layout *RunLayout,
+	caseData *common.CaseSettings,
+	caseInputPath string,
+) (*RunMetadata, map[string]RunMetadata, []string) {
+	individualMeta := make(map[string]RunMetadata)
+	generatedFiles := make([]string, 0)
+	singleRunSegment := ctx.Transaction.StartSegment("case " + caseData.Name)
+	metaChan := make(chan intermediateRunResult, regularBinaryCount)
+	for _, bin := range binaries {
+		if bin.binaryType == binaryValidator {
+			continue
+		}
+		go func(bin *binary, caseData *common.CaseSettings) {
+			inputPath := "/dev/null"
+			if bin.receiveInput {
+				inputPath = caseInputPath
+			}
+			extraParams := make([]string, 0)
+			if bin.binaryType == binaryProblemsetter {
+				extraParams = append(extraParams, caseData.Name, run.Language)
+			}
+			singleBinarySegment := ctx.Transaction.StartSegment(
+				fmt.Sprintf("%s - %s", caseData.Name, bin.name),
+			)
+			runMeta, err := sandbox.Run(
+				ctx,
+				&bin.limits,
+				bin.language,
+				bin.binPath,
+				inputPath,
+				layout.CaseOut(bin.outputPathPrefix, caseData.Name),
+				layout.CaseErr(bin.outputPathPrefix, caseData.Name),
+				layout.CaseMeta(bin.outputPathPrefix, caseData.Name),
+				bin.target,
+				nil,
+				nil,
+				nil,
+				extraParams,
+				bin.extraMountPoints,
+			)
+			if err != nil {
+				ctx.Log.Error(
+					"failed to run",
+					map[string]any{
+						"caseName":  caseData.Name,
+						"interface": bin.name,
+						"err":       err,
+					},
+				)
+			}
+			generatedFiles := []string{
+				caseFileName(bin.outputPathPrefix, caseData.Name, "out"),
+				caseFileName(bin.outputPathPrefix, caseData.Name, "err"),
+				caseFileName(bin.outputPathPrefix, caseData.Name, "meta"),
+			}
+			singleBinarySegment.End()
+			metaChan <- intermediateRunResult{
+				bin.name,
+				runMeta,
+				bin.binaryType,
+				generatedFiles,
+			}
+		}(bin, caseData)
+	}
+	var parentMetadata *RunMetadata
+	chosenMetadata := RunMetadata{
+		Verdict: common.VerdictOK,
+	}
+	chosenMetadataEmpty := true
+	finalVerdict := common.VerdictOK
+	var totalTime float64
+	var totalWallTime float64
+	var totalMemory base.Byte
+	var totalOutput base.Byte
+	var totalError base.Byte
+	for i := 0; i < regularBinaryCount; i++ {
+		intermediateResult := <-metaChan
+		generatedFiles = append(generatedFiles, intermediateResult.generatedFiles...)
+		if regularBinaryCount != 1 {
+			// Only populate invidualMeta if there is more than one binary.
+			individualMeta[intermediateResult.name] = *intermediateResult.runMeta
+		}
+		if intermediateResult.binaryType == binaryProblemsetter {
+			parentMetadata = intermediateResult.runMeta
+		} else {
+			if intermediateResult.runMeta.Verdict != common.VerdictOK {
+				if chosenMetadataEmpty {
+					chosenMetadata = *intermediateResult.runMeta
+					chosenMetadataEmpty = false
+				}
+			}
+			finalVerdict = finalVerdict.Worse(intermediateResult.runMeta.Verdict)
+			totalTime += intermediateResult.runMeta.Time
+			totalWallTime = math.Max(
+				totalWallTime,
+				intermediateResult.runMeta.WallTime,
+			)
+			totalMemory += intermediateResult.runMeta.Memory
+			totalOutput += intermediateResult.runMeta.OutputSize
+			totalError += intermediateResult.runMeta.ErrorSize
+		}
+	}
+	close(metaChan)
+	singleRunSegment.End()
+	chosenMetadata.Verdict = finalVerdict
+	chosenMetadata.Time = totalTime
+	chosenMetadata.WallTime = totalWallTime
+	chosenMetadata.Memory = totalMemory
+	chosenMetadata.OutputSize = totalOutput
+	chosenMetadata.ErrorSize = totalError
+
+	return mergeVerdict(ctx, &chosenMetadata, parentMetadata), individualMeta, generatedFiles
+}
+
+// IsSampleGroup returns whether the group contains the sample cases that are
+// shown in the problem statement.
+func IsSampleGroup(groupName string) bool {
+	return strings.HasPrefix(strings.ToLower(groupName), "sample")
+}
+
+// groupExecutionOrder returns the indices of the groups in the order in which
+// they should be run. Sample groups go first so that contestants can get
+// feedback about them as soon as possible.
+func groupExecutionOrder(groups []common.GroupSettings) []int {
+	order := make([]int, 0, len(groups))
+	for i, group := range groups {
+		if IsSampleGroup(group.Name) {
+			order = append(order, i)
+		}
+	}
+	for i, group := range groups {
+		if !IsSampleGroup(group.Name) {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// caseLimitsBinaries returns the binaries with the limits that the case
+// replaces. The validators keep their own limits, and binaries without a
+// memory limit (as in debug runs) keep not having one.
+func caseLimitsBinaries(binaries []*binary, caseData *common.CaseSettings) []*binary {
+	if !caseData.HasLimits() {
+		return binaries
+	}
+	result := make([]*binary, len(binaries))
+	for i, bin := range binaries {
+		if bin.binaryType == binaryValidator {
+			result[i] = bin
+			continue
+		}
+		limited := *bin
+		caseData.ApplyLimits(&limited.limits)
+		if bin.limits.MemoryLimit < 0 {
+			limited.limits.MemoryLimit = bin.limits.MemoryLimit
+		}
+		result[i] = &limited
+	}
+	return result
+}
+
+// budgetBinaries returns the binaries with their limits reduced so that a
+// single case cannot run for longer than the remaining wall time of the run.
+// The second return value is true if any of the limits had to be reduced.
+func budgetBinaries(binaries []*binary, remaining base.Duration) ([]*binary, bool) {
+	var result []*binary
+	for i, bin := range binaries {
+		if bin.binaryType == binaryValidator ||
+			bin.limits.TimeLimit+bin.limits.ExtraWallTime <= remaining {
+			continue
+		}
+		if result == nil {
+			result = make([]*binary, len(binaries))
+			copy(result, binaries)
+		}
+		budgeted := *bin
+		if budgeted.limits.TimeLimit > remaining {
+			budgeted.limits.TimeLimit = remaining
+		}
+		budgeted.limits.ExtraWallTime = remaining - budgeted.limits.TimeLimit
+		result[i] = &budgeted
+	}
+	if result == nil {
+		return binaries, false
+	}
+	return result, true
+}
+
+// isBorderlineTLE returns whether the CPU time of a case is so close to the
+// time limit that the verdict could change just due to machine noise. Runs
+// that were killed for using up their CPU time do not count, since their
+// time is capped at the limit and says nothing about how long they needed.
+func isBorderlineTLE(meta *RunMetadata, timeLimit base.Duration, margin float64) bool {
+	limit := timeLimit.Seconds()
+	if limit <= 0 || margin <= 0 {
+		return false
+	}
+	switch meta.Verdict {
+	case common.VerdictOK:
+		return math.Abs(meta.Time-limit) <= margin*limit
+	case common.VerdictTimeLimitExceeded:
+		if meta.Signal != nil && *meta.Signal == "SIGXCPU" {
+			return false
+		}
+		return meta.Time < limit+margin*limit
+	default:
+		return false
+	}
+}
+
+// runStabilizedCase runs a case and, if its CPU time is borderline, re-runs it
+// a few more times and keeps the attempt with the median CPU time. The
+// metadata of all the attempts is recorded in the returned metadata.
+func runStabilizedCase(
+	ctx *common.Context,
+	run *common.Run,
+	input common.Input,
+	sandbox Sandbox,
+	binaries []*binary,
+	regularBinaryCount int,
+	layout *RunLayout,
+	caseData *common.CaseSettings,
+	caseInputPath string,
+	timeLimit base.Duration,
+) (*RunMetadata, map[string]RunMetadata, []string) {
+	runMeta, individualMeta, generatedFiles := runCase(
+		ctx,
+		run,
+		input,
+		sandbox,
+		binaries,
+		regularBinaryCount,
+		layout,
+		caseData,
+		caseInputPath,
+	)
+	reruns := ctx.Config.Runner.BorderlineTLEReruns
+	if reruns <= 0 || !isBorderlineTLE(runMeta, timeLimit, ctx.Config.Runner.BorderlineTLEMargin) {
+		return runMeta, individualMeta, generatedFiles
+	}
+
+	ctx.Log.Info(
+		"Time is too close to the limit, re-running case",
+		map[string]any{
+			"case":   caseData.Name,
+			"meta":   runMeta,
+			"limit":  timeLimit,
+			"reruns": reruns,
+		},
+	)
+
+	type caseAttempt struct {
+		meta           *RunMetadata
+		individualMeta map[string]RunMetadata
+	}
+	attempts := []caseAttempt{{runMeta, individualMeta}}
+	for len(attempts) <= reruns {
+		// Set aside the files of the previous attempt so that they are not
+		// overwritten.
+		moveAttemptFiles(ctx, layout, generatedFiles, len(attempts)-1, false)
+		runMeta, individualMeta, _ := runCase(
+			ctx,
+			run,
+			input,
+			sandbox,
+			binaries,
+			regularBinaryCount,
+			layout,
+			caseData,
+			caseInputPath,
+		)
+		attempts = append(attempts, caseAttempt{runMeta, individualMeta})
+	}
+	moveAttemptFiles(ctx, layout, generatedFiles, len(attempts)-1, false)
 
-	// Validate outputs.
-	validateSegment := ctx.Transaction.StartSegment("validate")
-	for i, group := range settings.Cases {
+	order := make([]int, len(attempts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return attempts[order[i]].meta.Time < attempts[order[j]].meta.Time
+	})
+	chosen := order[len(order)/2]
+
+	// Only the files of the chosen attempt are kept.
+	for i := range attempts {
+		if i == chosen {
+			moveAttemptFiles(ctx, layout, generatedFiles, i, true)
+		} else {
+			for _, file := range generatedFiles {
+				os.Remove(layout.Path(attemptFileName(file, i)))
+			}
+		}
+	}
+
+	chosenMeta := *attempts[chosen].meta
+	chosenMeta.Attempts = make([]RunMetadata, 0, len(attempts))
+	for _, attempt := range attempts {
+		chosenMeta.Attempts = append(chosenMeta.Attempts, *attempt.meta)
+	}
+	return &chosenMeta, attempts[chosen].individualMeta, generatedFiles
+}
+
+func attemptFileName(file string, attempt int) string {
+	return fmt.Sprintf("%s.attempt%d", file, attempt)
+}
+
+// moveAttemptFiles renames the generated files of a case to a name that is
+// specific to the attempt, or restores them back to their original names.
+func moveAttemptFiles(
+	ctx *common.Context,
+	layout *RunLayout,
+	generatedFiles []string,
+	attempt int,
+	restore bool,
+) {
+	for _, file := range generatedFiles {
+		src := layout.Path(file)
+		dst := layout.Path(attemptFileName(file, attempt))
+		if restore {
+			src, dst = dst, src
+		}
+		if err := os.Rename(src, dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			ctx.Log.Error(
+				"Failed to move attempt file",
+				map[string]any{
+					"src": src,
+					"dst": dst,
+					"err": err,
+				},
+			)
+		}
+	}
+}
+
+// validationResult is the aggregated result of validating the outputs of all
+// groups.
+type validationResult struct {
+	verdict        common.Verdict
+	score          *big.Rat
+	generatedFiles []string
+	duration       time.Duration
+}
+
+// validateGroups validates the outputs of the groups whose indices are in
+// groupIndices, in that order. The GroupResult for each of the groups must be
+// fully populated.
+func validateGroups(
+	ctx *common.Context,
+	run *common.Run,
+	input common.Input,
+	sandbox Sandbox,
+	settings *common.ProblemSettings,
+	layout *RunLayout,
+	clock Clock,
+	validatorBinPath string,
+	hooks *gradingHooks,
+	totalWeightFactor *big.Rat,
+	maxScore *big.Rat,
+	groupResults []GroupResult,
+	groupIndices []int,
+	listener GroupResultListener,
+	caseListener CaseResultListener,
+) *validationResult {
+	result := &validationResult{
+		verdict: common.VerdictOK,
+		score:   &big.Rat{},
+	}
+	for _, i := range groupIndices {
+		group := settings.Cases[i]
+		validator := settings.GroupValidator(&group)
+		validateSegment := ctx.Transaction.StartSegment("validate " + group.Name)
+		validateStart := clock.Now()
 		correct := true
+		// fullScore is set when all the cases got a full score.
+		fullScore := true
 		groupScore := &big.Rat{}
 		minGroupScore := big.NewRat(1, 1)
+		productGroupScore := big.NewRat(1, 1)
 		groupWeight := &big.Rat{}
+		notifyCase := func(caseResults *CaseResult) {
+			if caseListener != nil {
+				caseListener(group.Name, caseResults)
+			}
+		}
 		for j, caseData := range group.Cases {
 			caseResults := &groupResults[i].Cases[j]
-			if caseResults.Verdict == "OK" {
-				contestantPath := path.Join(
-					runRoot, fmt.Sprintf("%s.out", caseData.Name),
-				)
-				if settings.Validator.Name == common.ValidatorNameCustom {
-					originalInputFile := path.Join(
-						input.Path(),
-						"cases",
-						fmt.Sprintf("%s.in", caseData.Name),
-					)
+			if caseResults.Verdict == common.VerdictOK {
+				contestantPath := hooks.caseOutput(layout, caseData.Name)
+				// validatorFailed is set when the custom validator did not finish
+				// correctly, so its output cannot be trusted.
+				validatorFailed := false
+				if validator.Name == common.ValidatorNameCustom {
+					originalInputFile := hooks.caseInput(input, layout, caseData.Name)
 					originalOutputFile := path.Join(
 						input.Path(),
 						"cases",
@@ -1292,16 +2365,16 @@ func Grade(
 						)
 						originalOutputFile = "/dev/null"
 					}
-					runMetaFile := path.Join(runRoot, fmt.Sprintf("%s.meta", caseData.Name))
+					runMetaFile := layout.CaseMeta("", caseData.Name)
 					validateMeta, err := sandbox.Run(
 						ctx,
-						validatorLimits(&settings.Limits, settings.Validator.Limits),
-						*settings.Validator.Lang,
+						validatorLimits(&settings.Limits, validator.Limits),
+						*validator.Lang,
 						validatorBinPath,
 						contestantPath,
-						path.Join(runRoot, "validator", fmt.Sprintf("%s.out", caseData.Name)),
-						path.Join(runRoot, "validator", fmt.Sprintf("%s.err", caseData.Name)),
-						path.Join(runRoot, "validator", fmt.Sprintf("%s.meta", caseData.Name)),
+						layout.CaseOut("validator", caseData.Name),
+						layout.CaseErr("validator", caseData.Name),
+						layout.CaseMeta("validator", caseData.Name),
 						"validator",
 						&originalInputFile,
 						&originalOutputFile,
@@ -1317,15 +2390,16 @@ func Grade(
 								"err":       err,
 							},
 						)
+						validatorFailed = true
 					}
 					caseResults.IndividualMeta["validator"] = *validateMeta
-					generatedFiles = append(
-						generatedFiles,
+					result.generatedFiles = append(
+						result.generatedFiles,
 						fmt.Sprintf("validator/%s.out", caseData.Name),
 						fmt.Sprintf("validator/%s.err", caseData.Name),
 						fmt.Sprintf("validator/%s.meta", caseData.Name),
 					)
-					if validateMeta.Verdict != "OK" {
+					if validateMeta.Verdict != common.VerdictOK {
 						// If the validator did not exit cleanly, assume an empty output.
 						ctx.Log.Info(
 							"validator verdict not OK. Using /dev/null",
@@ -1335,12 +2409,9 @@ func Grade(
 							},
 						)
 						contestantPath = "/dev/null"
+						validatorFailed = true
 					} else {
-						contestantPath = path.Join(
-							runRoot,
-							"validator",
-							fmt.Sprintf("%s.out", caseData.Name),
-						)
+						contestantPath = layout.CaseOut("validator", caseData.Name)
 					}
 				}
 				contestantFd, err := os.Open(contestantPath)
@@ -1352,12 +2423,13 @@ func Grade(
 							"err":  err,
 						},
 					)
+					notifyCase(caseResults)
 					continue
 				}
 				expectedPath := path.Join(
 					input.Path(), "cases", fmt.Sprintf("%s.out", caseData.Name),
 				)
-				if settings.Validator.Name == common.ValidatorNameCustom {
+				if validator.Name == common.ValidatorNameCustom {
 					// No need to open the actual file. It might not even exist.
 					expectedPath = "/dev/null"
 				}
@@ -1371,10 +2443,11 @@ func Grade(
 							"err":  err,
 						},
 					)
+					notifyCase(caseResults)
 					continue
 				}
 				runScore, _, err := CalculateScore(
-					&settings.Validator,
+					validator,
 					expectedFd,
 					contestantFd,
 				)
@@ -1388,6 +2461,30 @@ func Grade(
 							"err":  err,
 						},
 					)
+					if validator.Name == common.ValidatorNameCustom {
+						// The custom validator did not print a valid score.
+						validatorFailed = true
+					}
+				}
+				if validatorFailed && !hasExpectedValidatorFailure(input, caseData.Name) {
+					// Problemsetters can write validators that fail on purpose for some
+					// cases, but otherwise this is a problem with the validator and not
+					// the contestant's fault. This includes the validator exceeding its
+					// own limits.
+					ctx.Metrics.CounterAdd("runner_validator_errors", 1)
+					ctx.Log.Error(
+						"Custom validator failed",
+						map[string]any{
+							"case name": caseData.Name,
+							"meta":      caseResults.IndividualMeta["validator"],
+							"limits":    validatorLimits(&settings.Limits, validator.Limits),
+							"err":       err,
+						},
+					)
+					caseResults.Verdict = common.VerdictValidatorError
+					result.verdict = result.verdict.Worse(common.VerdictValidatorError)
+					correct = false
+					runScore = big.NewRat(0, 1)
 				}
 				// If the case didn't get a full score, check if we have an expected stderr
 				// message from the validator. Fail the case with VE if there's a mismatch.
@@ -1412,7 +2509,7 @@ func Grade(
 							)
 							return err
 						}
-						validatorStderrPath := path.Join(runRoot, "validator", fmt.Sprintf("%s.err", caseData.Name))
+						validatorStderrPath := layout.CaseErr("validator", caseData.Name)
 						validatorStderr, err := ioutil.ReadFile(validatorStderrPath)
 						if err != nil {
 							ctx.Log.Warn(
@@ -1432,8 +2529,8 @@ func Grade(
 									"case name": caseData.Name,
 								},
 							)
-							caseResults.Verdict = "VE"
-							runResult.Verdict = worseVerdict(runResult.Verdict, "VE")
+							caseResults.Verdict = common.VerdictValidatorError
+							result.verdict = result.verdict.Worse(common.VerdictValidatorError)
 							correct = false
 							runScore = big.NewRat(0, 1)
 						}
@@ -1441,6 +2538,7 @@ func Grade(
 					}()
 
 					if err != nil {
+						notifyCase(caseResults)
 						continue
 					}
 				}
@@ -1448,7 +2546,7 @@ func Grade(
 				caseWeight := new(big.Rat).Mul(caseData.Weight, totalWeightFactor)
 				caseResults.ContestScore = new(big.Rat).Mul(
 					new(big.Rat).Mul(
-						runResult.MaxScore,
+						maxScore,
 						caseWeight,
 					),
 					caseResults.Score,
@@ -1457,6 +2555,7 @@ func Grade(
 				if minGroupScore.Cmp(runScore) > 0 {
 					minGroupScore = runScore
 				}
+				productGroupScore.Mul(productGroupScore, runScore)
 				groupScore.Add(
 					groupScore,
 					new(big.Rat).Mul(
@@ -1465,58 +2564,75 @@ func Grade(
 					),
 				)
 				if runScore.Cmp(big.NewRat(1, 1)) == 0 {
-					caseResults.Verdict = "AC"
-				} else if caseResults.Verdict != "VE" {
-					runResult.Verdict = worseVerdict(runResult.Verdict, "PA")
-					if runScore.Cmp(&big.Rat{}) == 0 {
-						correct = false
-						caseResults.Verdict = "WA"
-					} else {
-						caseResults.Verdict = "PA"
+					caseResults.Verdict = common.VerdictAccepted
+				} else {
+					fullScore = false
+					if caseResults.Verdict != common.VerdictValidatorError {
+						result.verdict = result.verdict.Worse(common.VerdictPartiallyAccepted)
+						if runScore.Cmp(&big.Rat{}) == 0 {
+							correct = false
+							caseResults.Verdict = common.VerdictWrongAnswer
+						} else {
+							caseResults.Verdict = common.VerdictPartiallyAccepted
+						}
 					}
 				}
 			} else {
 				correct = false
+				fullScore = false
 			}
+			notifyCase(caseResults)
 		}
-		if correct {
-			if settings.Validator.GroupScorePolicy == common.GroupScorePolicyMin {
-				groupScore = new(big.Rat).Mul(minGroupScore, groupWeight)
-			}
-			runResult.Score.Add(runResult.Score, groupScore)
-
-			groupResults[i].Score.Add(groupResults[i].Score, groupScore)
-			groupResults[i].ContestScore = new(big.Rat).Mul(
-				runResult.MaxScore,
-				groupScore,
+		policy := settings.GroupScorePolicy(&group)
+		if !policy.Valid() {
+			ctx.Log.Warn(
+				"Unknown group score policy, using the default",
+				map[string]any{
+					"group":  group.Name,
+					"policy": policy,
+				},
 			)
 		}
+		switch {
+		case policy == common.GroupScorePolicySum:
+			// The cases that were not correct did not add anything.
+		case !correct:
+			// All the other policies give no points to groups with a case that
+			// was not correct.
+			groupScore = &big.Rat{}
+		case policy == common.GroupScorePolicyMin:
+			groupScore = new(big.Rat).Mul(minGroupScore, groupWeight)
+		case policy == common.GroupScorePolicyMultiplicative:
+			groupScore = new(big.Rat).Mul(productGroupScore, groupWeight)
+		case policy == common.GroupScorePolicyAllOrNothing && !fullScore:
+			groupScore = &big.Rat{}
+		}
+		result.score.Add(result.score, groupScore)
+		groupResults[i].Score.Add(groupResults[i].Score, groupScore)
+		groupResults[i].ContestScore = new(big.Rat).Mul(
+			maxScore,
+			groupScore,
+		)
+		validateSegment.End()
+		result.duration += clock.Now().Sub(validateStart)
+		if listener != nil {
+			listener(&groupResults[i])
+		}
 	}
-	validateSegment.End()
-
-	runResult.Groups = groupResults
-
-	if runResult.Verdict == "PA" && runResult.Score.Cmp(&big.Rat{}) == 0 {
-		runResult.Verdict = "WA"
-	} else if runResult.Verdict == "OK" {
-		runResult.Verdict = "AC"
-		runResult.Score = big.NewRat(1, 1)
-	}
-	runResult.ContestScore = new(big.Rat).Mul(
-		runResult.MaxScore,
-		runResult.Score,
-	)
-
-	ctx.Log.Debug(
-		"Finished running",
-		map[string]any{
-			"id":      run.AttemptID,
-			"verdict": runResult.Verdict,
-			"score":   runResult.Score,
-		},
-	)
+	return result
+}
 
-	return runResult, nil
+// hasExpectedValidatorFailure returns whether the problemsetter expects the
+// custom validator to fail for the specified case. This is declared with a
+// cases/<case>.expected-failure file (validator_stderr in literal inputs)
+// next to the case, with a string that the stderr of the validator must
+// contain. The failure then gives the case no points instead of a VE, unless
+// the stderr does not contain the string.
+func hasExpectedValidatorFailure(input common.Input, caseName string) bool {
+	_, err := os.Stat(path.Join(
+		input.Path(), "cases", fmt.Sprintf("%s.expected-failure", caseName),
+	))
+	return err == nil
 }
 
 func uploadFiles(
@@ -1525,35 +2641,71 @@ func uploadFiles(
 	runRoot string,
 	input common.Input,
 	files []string,
-) error {
+	priority func(file string) int,
+) ([]string, error) {
 	if filesWriter == nil {
-		return nil
-	}
-	path, err := createZipFile(runRoot, files)
-	if err != nil {
-		return err
+		return nil, nil
 	}
+	return writeZipFile(filesWriter, runRoot, files, ctx.Config.Runner.MaxArtifactSize, priority)
+}
 
-	fd, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer fd.Close()
+// The priorities of the artifacts of a run, from the first to be truncated to
+// the last one. The outputs of the cases that failed are the most useful to
+// debug them, while the stderr of the ones that ran correctly rarely is.
+const (
+	artifactPriorityPassingError = iota
+	artifactPriorityPassingOutput
+	artifactPriorityOther
+	artifactPriorityFailingError
+	artifactPriorityFailingOutput
+	artifactPriorityMeta
+)
 
-	_, err = io.Copy(filesWriter, fd)
-	return err
+// artifactPriority returns the priority of keeping the file when the
+// artifacts do not fit in the size limit, given the verdicts of running each
+// case.
+func artifactPriority(file string, caseVerdicts map[string]common.Verdict) int {
+	extension := path.Ext(file)
+	if extension == ".meta" {
+		return artifactPriorityMeta
+	}
+	verdict, ok := caseVerdicts[strings.TrimSuffix(path.Base(file), extension)]
+	if !ok {
+		return artifactPriorityOther
+	}
+	passed := verdict == common.VerdictOK
+	switch {
+	case extension == ".err" && passed:
+		return artifactPriorityPassingError
+	case extension == ".out" && passed:
+		return artifactPriorityPassingOutput
+	case extension == ".err":
+		return artifactPriorityFailingError
+	case extension == ".out":
+		return artifactPriorityFailingOutput
+	}
+	return artifactPriorityOther
 }
 
-func createZipFile(runRoot string, files []string) (string, error) {
-	zipFd, err := ioutil.TempFile(runRoot, ".results_zip")
-	if err != nil {
-		return "", err
+// writeZipFile streams a .zip file with the provided files into w. The total
+// uncompressed size of the files is limited to maxSize (if non-zero). The
+// files with the highest priority (if priority is non-nil) are the ones that
+// get to use it first, and any file that does not fit will be truncated and
+// have a marker appended to it. It returns the files that were truncated.
+func writeZipFile(
+	w io.Writer,
+	runRoot string,
+	files []string,
+	maxSize base.Byte,
+	priority func(file string) int,
+) ([]string, error) {
+	var budgets []int64
+	if maxSize != 0 {
+		budgets = artifactBudgets(runRoot, files, maxSize, priority)
 	}
-	defer zipFd.Close()
-
-	zipPath := zipFd.Name()
-	zip := zip.NewWriter(zipFd)
-	for _, file := range files {
+	var truncatedFiles []string
+	zip := zip.NewWriter(w)
+	for i, file := range files {
 		f, err := os.Open(path.Join(runRoot, file))
 		if err != nil {
 			continue
@@ -1562,51 +2714,147 @@ func createZipFile(runRoot string, files []string) (string, error) {
 		if err != nil {
 			f.Close()
 			zip.Close()
-			return zipPath, err
+			return truncatedFiles, err
 		}
-		if _, err := io.Copy(zf, f); err != nil {
-			f.Close()
-			zip.Close()
-			return zipPath, err
+		if maxSize == 0 {
+			_, err = io.Copy(zf, f)
+		} else {
+			var truncated bool
+			truncated, err = copyTruncated(zf, f, &budgets[i])
+			if truncated {
+				truncatedFiles = append(truncatedFiles, file)
+			}
 		}
 		f.Close()
+		if err != nil {
+			zip.Close()
+			return truncatedFiles, err
+		}
 	}
-	return zipPath, zip.Close()
+	return truncatedFiles, zip.Close()
 }
 
-func getCompileError(errorFile string) string {
-	fd, err := os.Open(errorFile)
+// artifactBudgets splits maxSize among the files, giving each one as much as
+// it needs in decreasing order of priority, and in the order in which they
+// were provided for files with the same priority.
+func artifactBudgets(
+	runRoot string,
+	files []string,
+	maxSize base.Byte,
+	priority func(file string) int,
+) []int64 {
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	if priority != nil {
+		sort.SliceStable(order, func(i, j int) bool {
+			return priority(files[order[i]]) > priority(files[order[j]])
+		})
+	}
+	budgets := make([]int64, len(files))
+	remaining := maxSize.Bytes()
+	for _, i := range order {
+		info, err := os.Stat(path.Join(runRoot, files[i]))
+		if err != nil {
+			continue
+		}
+		budgets[i] = info.Size()
+		if budgets[i] > remaining {
+			budgets[i] = remaining
+		}
+		remaining -= budgets[i]
+	}
+	return budgets
+}
+
+// copyTruncated copies at most *remaining bytes from r into w, and decrements
+// *remaining by the number of bytes copied. If r had more bytes than that, a
+// truncation marker is written at the end and it returns true.
+func copyTruncated(w io.Writer, r io.Reader, remaining *int64) (bool, error) {
+	written, err := io.Copy(w, io.LimitReader(r, *remaining))
+	*remaining -= written
 	if err != nil {
-		return err.Error()
+		return false, err
 	}
-	defer fd.Close()
-	bytes, err := ioutil.ReadAll(fd)
+	omitted, err := io.Copy(io.Discard, r)
 	if err != nil {
-		return err.Error()
+		return false, err
 	}
-	return string(bytes)
+	if omitted == 0 {
+		return false, nil
+	}
+	_, err = fmt.Fprintf(w, "\n[truncated: %d bytes omitted]\n", omitted)
+	return true, err
 }
 
-func worseVerdict(a, b string) string {
-	idxA := sliceIndex(len(common.VerdictList),
-		func(i int) bool { return common.VerdictList[i] == a })
-	idxB := sliceIndex(len(common.VerdictList),
-		func(i int) bool { return common.VerdictList[i] == b })
-	return common.VerdictList[min(idxA, idxB)]
+// truncateCompileOutput replaces the file with its first and last limit/2
+// bytes if it is larger than limit, with a truncation marker in between, and
+// returns whether it did. This keeps both the first errors, which are usually
+// the relevant ones, and the summary that compilers print at the end.
+func truncateCompileOutput(filename string, limit base.Byte) (bool, error) {
+	if limit <= 0 {
+		return false, nil
+	}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+	if size <= limit.Bytes() {
+		return false, nil
+	}
+
+	head := make([]byte, limit.Bytes()/2)
+	tail := make([]byte, limit.Bytes()-int64(len(head)))
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return false, err
+	}
+	if _, err := f.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return false, err
+	}
+	var buf bytes.Buffer
+	buf.Write(head)
+	fmt.Fprintf(&buf, "\n[truncated: %d bytes omitted]\n", size-limit.Bytes())
+	buf.Write(tail)
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func sliceIndex(limit int, predicate func(i int) bool) int {
-	for i := 0; i < limit; i++ {
-		if predicate(i) {
-			return i
-		}
+// compileErrorOutput returns the output of a compiler that failed, which was
+// written to binRoot.
+func compileErrorOutput(binRoot, language string) string {
+	if language != "pas" && language != "cs" {
+		return getCompileError(path.Join(binRoot, "compile.err"))
+	}
+	// Lazarus, dotnet and mono write the compile errors in compile.out. If
+	// the compiler could not even start, the reason is in compile.err.
+	compileError := getCompileError(path.Join(binRoot, "compile.out"))
+	if strings.TrimSpace(compileError) == "" {
+		compileError = getCompileError(path.Join(binRoot, "compile.err"))
 	}
-	return -1
+	return compileError
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+func getCompileError(errorFile string) string {
+	fd, err := os.Open(errorFile)
+	if err != nil {
+		return err.Error()
+	}
+	defer fd.Close()
+	bytes, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return err.Error()
 	}
-	return b
+	return string(bytes)
 }
@@ -1,12 +1,19 @@
 package runner
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"os"
 	"path"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -29,7 +36,7 @@ type expectedResult struct {
 type runnerTestCase struct {
 	language, source       string
 	maxScore               *big.Rat
-	expectedVerdict        string
+	expectedVerdict        common.Verdict
 	expectedScore          *big.Rat
 	expectedCompileResults expectedResult
 	expectedResults        map[string]expectedResult
@@ -1095,6 +1102,290 @@ if __name__ == '__main__':
 	}
 }
 
+func TestGradeGroupScorePolicies(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+	}
+
+	validatorResults := func(scores ...string) map[string]expectedResult {
+		results := make(map[string]expectedResult)
+		for i, name := range []string{"0", "1.0", "1.1"} {
+			results[name] = expectedResult{
+				runOutput:       programOutput{"", "", &RunMetadata{Verdict: "OK"}},
+				validatorOutput: programOutput{scores[i], "", &RunMetadata{Verdict: "OK"}},
+			}
+		}
+		return results
+	}
+	partial := validatorResults("1", "0.5", "0.8")
+	zero := validatorResults("1", "0", "1")
+
+	inputManager := common.NewInputManager(ctx)
+	for _, tc := range []struct {
+		policy        common.GroupScorePolicy
+		results       map[string]expectedResult
+		expectedScore *big.Rat
+	}{
+		{common.GroupScorePolicySumIfNotZero, partial, big.NewRat(31, 40)},
+		{common.GroupScorePolicySumIfNotZero, zero, big.NewRat(1, 4)},
+		{common.GroupScorePolicySum, partial, big.NewRat(31, 40)},
+		{common.GroupScorePolicySum, zero, big.NewRat(3, 4)},
+		{common.GroupScorePolicyMin, partial, big.NewRat(5, 8)},
+		{common.GroupScorePolicyMin, zero, big.NewRat(1, 4)},
+		{common.GroupScorePolicyMultiplicative, partial, big.NewRat(11, 20)},
+		{common.GroupScorePolicyMultiplicative, zero, big.NewRat(1, 4)},
+		{common.GroupScorePolicyAllOrNothing, partial, big.NewRat(1, 4)},
+		{common.GroupScorePolicyAllOrNothing, zero, big.NewRat(1, 4)},
+	} {
+		factory, err := common.NewLiteralInputFactory(
+			&common.LiteralInput{
+				Cases: map[string]*common.LiteralCaseSettings{
+					"0":   {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+					"1.0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+					"1.1": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(2, 1)},
+				},
+				Validator: &common.LiteralValidatorSettings{
+					Name:             common.ValidatorNameCustom,
+					GroupScorePolicy: tc.policy,
+					CustomValidator: &common.LiteralCustomValidatorSettings{
+						Source:   "print(1)",
+						Language: "python3",
+					},
+				},
+			},
+			ctx.Config.Runner.RuntimePath,
+			common.LiteralPersistRunner,
+		)
+		if err != nil {
+			t.Fatalf("Failed to create Input: %q", err)
+		}
+		inputRef, err := inputManager.Add(factory.Hash(), factory)
+		if err != nil {
+			t.Fatalf("Failed to open problem: %q", err)
+		}
+
+		rte := runnerTestCase{
+			language:               "py3",
+			source:                 "print(3)",
+			maxScore:               big.NewRat(1, 1),
+			expectedCompileResults: expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+			expectedResults:        tc.results,
+		}
+		results, err := Grade(
+			ctx,
+			&bytes.Buffer{},
+			&common.Run{
+				AttemptID: 1,
+				Language:  rte.language,
+				InputHash: inputRef.Input.Hash(),
+				Source:    rte.source,
+				MaxScore:  rte.maxScore,
+			},
+			inputRef.Input,
+			(&fakeSandboxWrapper{}).sandbox(&rte),
+		)
+		inputRef.Release()
+		if err != nil {
+			t.Fatalf("Failed to run %q: %q", tc.policy, err)
+		}
+		if results.Score.Cmp(tc.expectedScore) != 0 {
+			t.Errorf(
+				"policy %q: results.Score = %s, expected %s",
+				tc.policy,
+				results.Score.String(),
+				tc.expectedScore.String(),
+			)
+		}
+	}
+}
+
+func TestGradeGroupValidators(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	inputPath := path.Join(ctx.Config.Runner.RuntimePath, "input")
+	for name, contents := range map[string]string{
+		"cases/0.in":    "a",
+		"cases/0.out":   "a",
+		"cases/1.in":    "0.5",
+		"cases/1.out":   "1",
+		"validator.py3": "",
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(inputPath, name)), 0755); err != nil {
+			t.Fatalf("Failed to create the input directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path.Join(inputPath, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	validatorLang := "py3"
+	input := &hooksTestInput{
+		path: inputPath,
+		settings: &common.ProblemSettings{
+			Cases: []common.GroupSettings{
+				{
+					Name:  "0",
+					Cases: []common.CaseSettings{{Name: "0", Weight: big.NewRat(1, 2)}},
+				},
+				{
+					Name:  "1",
+					Cases: []common.CaseSettings{{Name: "1", Weight: big.NewRat(1, 2)}},
+					// The sandbox makes the validator print the output of the
+					// contestant, which is the score.
+					Validator: &common.ValidatorSettings{
+						Name: common.ValidatorNameCustom,
+						Lang: &validatorLang,
+					},
+				},
+			},
+			Limits:    common.DefaultLimits,
+			Validator: common.ValidatorSettings{Name: common.ValidatorNameToken},
+		},
+	}
+
+	sandbox := &hooksSandbox{compiledSources: make(map[string]string)}
+	result, err := Grade(
+		ctx,
+		ioutil.Discard,
+		&common.Run{
+			AttemptID: 1,
+			Source:    "print(input())",
+			Language:  "py3",
+			MaxScore:  big.NewRat(1, 1),
+		},
+		input,
+		sandbox,
+	)
+	if err != nil {
+		t.Fatalf("Failed to grade: %v", err)
+	}
+	if _, ok := sandbox.compiledSources["validator"]; !ok {
+		t.Errorf("the custom validator of the group was not compiled")
+	}
+	if result.Verdict != common.VerdictPartiallyAccepted {
+		t.Errorf("verdict == %q, want %q", result.Verdict, common.VerdictPartiallyAccepted)
+	}
+	for i, expected := range []*big.Rat{big.NewRat(1, 2), big.NewRat(1, 4)} {
+		if result.Groups[i].Score.Cmp(expected) != 0 {
+			t.Errorf("group %d score == %v, want %v", i, result.Groups[i].Score, expected)
+		}
+	}
+	if _, ok := result.Groups[0].Cases[0].IndividualMeta["validator"]; ok {
+		t.Errorf("the custom validator ran for a group with the token validator")
+	}
+}
+
+func TestGradeWeights(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	inputPath := path.Join(ctx.Config.Runner.RuntimePath, "input")
+	for name, contents := range map[string]string{
+		"cases/0.in":  "a",
+		"cases/0.out": "a",
+		"cases/1.in":  "b",
+		"cases/1.out": "c",
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(inputPath, name)), 0755); err != nil {
+			t.Fatalf("Failed to create the input directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path.Join(inputPath, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name              string
+		cases             []common.CaseSettings
+		normalizeWeights  bool
+		maxScore          *big.Rat
+		expectedMaxScores []*big.Rat
+		expectedScore     *big.Rat
+	}{
+		{
+			name: "points",
+			cases: []common.CaseSettings{
+				{Name: "0", Weight: big.NewRat(1, 1), Points: big.NewRat(30, 1)},
+				{Name: "1", Weight: big.NewRat(1, 1), Points: big.NewRat(70, 1)},
+			},
+			maxScore:          big.NewRat(100, 1),
+			expectedMaxScores: []*big.Rat{big.NewRat(30, 1), big.NewRat(70, 1)},
+			expectedScore:     big.NewRat(30, 1),
+		},
+		{
+			name: "unnormalized weights",
+			cases: []common.CaseSettings{
+				{Name: "0", Weight: big.NewRat(1, 1)},
+				{Name: "1", Weight: big.NewRat(1, 1)},
+			},
+			maxScore:          big.NewRat(1, 1),
+			expectedMaxScores: []*big.Rat{big.NewRat(1, 1), big.NewRat(1, 1)},
+			expectedScore:     big.NewRat(1, 1),
+		},
+		{
+			name: "normalized weights",
+			cases: []common.CaseSettings{
+				{Name: "0", Weight: big.NewRat(1, 1)},
+				{Name: "1", Weight: big.NewRat(1, 1)},
+			},
+			normalizeWeights:  true,
+			maxScore:          big.NewRat(1, 1),
+			expectedMaxScores: []*big.Rat{big.NewRat(1, 2), big.NewRat(1, 2)},
+			expectedScore:     big.NewRat(1, 2),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := &common.ProblemSettings{
+				NormalizeWeights: tc.normalizeWeights,
+				Limits:           common.DefaultLimits,
+				Validator:        common.ValidatorSettings{Name: common.ValidatorNameToken},
+			}
+			for _, c := range tc.cases {
+				settings.Cases = append(settings.Cases, common.GroupSettings{
+					Name:  c.Name,
+					Cases: []common.CaseSettings{c},
+				})
+			}
+			result, err := Grade(
+				ctx,
+				ioutil.Discard,
+				&common.Run{
+					AttemptID: 1,
+					Source:    "print(input())",
+					Language:  "py3",
+					MaxScore:  tc.maxScore,
+				},
+				&hooksTestInput{path: inputPath, settings: settings},
+				&hooksSandbox{compiledSources: make(map[string]string)},
+			)
+			if err != nil {
+				t.Fatalf("Failed to grade: %v", err)
+			}
+			for i, expected := range tc.expectedMaxScores {
+				if result.Groups[i].MaxScore.Cmp(expected) != 0 {
+					t.Errorf("group %d max score == %v, want %v", i, result.Groups[i].MaxScore, expected)
+				}
+			}
+			if result.ContestScore.Cmp(tc.expectedScore) != 0 {
+				t.Errorf("contest score == %v, want %v", result.ContestScore, tc.expectedScore)
+			}
+		})
+	}
+}
+
 func TestGradeLowMemOmegajail(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode.")
@@ -1390,145 +1681,21 @@ func TestGradeOLE(t *testing.T) {
 							results.OverallOutput.Bytes(),
 						)
 					}
-				})
-			}
-		})
-	}
-}
-
-func TestKarelGrade(t *testing.T) {
-	for name, wrapper := range map[string]sandboxWrapper{
-		"fake":      &fakeSandboxWrapper{},
-		"omegajail": &omegajailSandboxWrapper{omegajail: getSandbox()},
-	} {
-		wrapper := wrapper
-		t.Run(name, func(t *testing.T) {
-			if testing.Short() && wrapper.name() == "OmegajailSandbox" {
-				t.Skip("skipping test in short mode.")
-			}
-			if !wrapper.supported() {
-				t.Skip(fmt.Sprintf("%s not supported", wrapper.name()))
-			}
-
-			ctx, err := newRunnerContext(t)
-			if err != nil {
-				t.Fatalf("RunnerContext creation failed with %q", err)
-			}
-			defer ctx.Close()
-			if !ctx.Config.Runner.PreserveFiles {
-				defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
-			}
-
-			inputManager := common.NewInputManager(ctx)
-			expectedOutput := `<resultados>
-	<mundos>
-		<mundo nombre="mundo_0"/>
-	</mundos>
-	<programas>
-		<programa nombre="p1" resultadoEjecucion="FIN PROGRAMA">
-			<karel x="1" y="2"/>
-		</programa>
-	</programas>
-</resultados>`
-			AplusB, err := common.NewLiteralInputFactory(
-				&common.LiteralInput{
-					Cases: map[string]*common.LiteralCaseSettings{
-						"0": {Input: `<ejecucion>
-	<condiciones instruccionesMaximasAEjecutar="10000000" longitudStack="65000"></condiciones>
-	<mundos>
-		<mundo nombre="mundo_0" ancho="100" alto="100">
-			<monton x="1" y="2" zumbadores="1"></monton>
-		</mundo>
-	</mundos>
-	<programas tipoEjecucion="CONTINUA" intruccionesCambioContexto="1" milisegundosParaPasoAutomatico="0">
-		<programa nombre="p1" ruta="{$2$}" mundoDeEjecucion="mundo_0" xKarel="1" yKarel="1" direccionKarel="NORTE" mochilaKarel="0">
-			<despliega tipo="MUNDO"></despliega>
-			<despliega tipo="POSICION"></despliega>
-		</programa>
-	</programas>
-</ejecucion>`, ExpectedOutput: expectedOutput, Weight: big.NewRat(1, 1)},
-					},
-					Validator: &common.LiteralValidatorSettings{
-						Name: common.ValidatorNameTokenNumeric,
-					},
-				},
-				ctx.Config.Runner.RuntimePath,
-				common.LiteralPersistRunner,
-			)
-			if err != nil {
-				t.Fatalf("Failed to create Input: %q", err)
-			}
-
-			inputRef, err := inputManager.Add(AplusB.Hash(), AplusB)
-			if err != nil {
-				t.Fatalf("Failed to open problem: %q", err)
-			}
-			defer inputRef.Release()
-
-			runtests := []runnerTestCase{
-				{
-					"kp",
-					`
-			iniciar-programa
-				inicia-ejecucion
-					mientras no-junto-a-zumbador hacer avanza;
-					apagate;
-				termina-ejecucion
-			finalizar-programa
-			`,
-					big.NewRat(1, 1),
-					"AC",
-					big.NewRat(1, 1),
-					expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
-					map[string]expectedResult{
-						"0": {runOutput: programOutput{expectedOutput, "", &RunMetadata{Verdict: "OK"}}},
-					},
-				},
-				{
-					"kj",
-					"class program { program () { while (!nextToABeeper()) move(); turnoff(); } }",
-					big.NewRat(1, 1),
-					"AC",
-					big.NewRat(1, 1),
-					expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
-					map[string]expectedResult{
-						"0": {runOutput: programOutput{expectedOutput, "", &RunMetadata{Verdict: "OK"}}},
-					},
-				},
-			}
-			for idx, rte := range runtests {
-				t.Run(fmt.Sprintf("%s/%d/%s %s", wrapper.name(), idx, rte.language, rte.expectedVerdict), func(t *testing.T) {
-					results, err := Grade(
-						ctx,
-						&bytes.Buffer{},
-						&common.Run{
-							AttemptID: uint64(idx),
-							Language:  rte.language,
-							InputHash: inputRef.Input.Hash(),
-							Source:    rte.source,
-							MaxScore:  rte.maxScore,
-						},
-						inputRef.Input,
-						wrapper.sandbox(&rte),
-					)
-					if err != nil {
-						t.Fatalf("Failed to run %v: %q", rte, err)
-					}
-					if results.Verdict != rte.expectedVerdict {
-						t.Errorf(
-							"results.Verdict = %q, expected %q, test %v: %v",
-							results.Verdict,
-							rte.expectedVerdict,
-							idx,
-							rte,
-						)
-					}
-					if results.Score.Cmp(rte.expectedScore) != 0 {
-						t.Errorf(
-							"results.Score = %s, expected %s",
-							results.Score.String(),
-							rte.expectedScore.String(),
-						)
+					for _, group := range results.Groups {
+						for _, c := range group.Cases {
+							if c.Name != "1.1" {
+								continue
+							}
+							if c.Verdict != "SK" || c.Meta.Skipped != SkippedOverallOutputLimit {
+								t.Errorf(
+									"case %q = {%q, %q}, expected {\"SK\", %q}",
+									c.Name,
+									c.Verdict,
+									c.Meta.Skipped,
+									SkippedOverallOutputLimit,
+								)
+							}
+						}
 					}
 				})
 			}
@@ -1536,14 +1703,7 @@ func TestKarelGrade(t *testing.T) {
 	}
 }
 
-func TestLibinteractive(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping test in short mode.")
-	}
-	omegajail := getSandbox()
-	if !omegajail.Supported() {
-		t.Skip("omegajail sandbox not supported")
-	}
+func TestGradeSkippedGroups(t *testing.T) {
 	ctx, err := newRunnerContext(t)
 	if err != nil {
 		t.Fatalf("RunnerContext creation failed with %q", err)
@@ -1554,11 +1714,633 @@ func TestLibinteractive(t *testing.T) {
 	}
 
 	inputManager := common.NewInputManager(ctx)
-	AplusB, err := common.NewLiteralInputFactory(
+	factory, err := common.NewLiteralInputFactory(
 		&common.LiteralInput{
 			Cases: map[string]*common.LiteralCaseSettings{
-				"0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
-				"1": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(1, 1)},
+				"0":   {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+				"1.0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+				"1.1": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(1, 1)},
+				"2.0": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(1, 1)},
+				"3.0": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(1, 1)},
+			},
+			Validator: &common.LiteralValidatorSettings{
+				Name: common.ValidatorNameTokenNumeric,
+			},
+		},
+		ctx.Config.Runner.RuntimePath,
+		common.LiteralPersistRunner,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create Input: %q", err)
+	}
+	inputRef, err := inputManager.Add(factory.Hash(), factory)
+	if err != nil {
+		t.Fatalf("Failed to open problem: %q", err)
+	}
+	defer inputRef.Release()
+
+	// Group 1 stops at its first failure, group 2 depends on it, and group 3
+	// only depends on group 0, which succeeds.
+	settings := *inputRef.Input.Settings()
+	settings.Cases = append([]common.GroupSettings(nil), settings.Cases...)
+	for i := range settings.Cases {
+		switch settings.Cases[i].Name {
+		case "1":
+			settings.Cases[i].FailFast = true
+		case "2":
+			settings.Cases[i].DependsOn = []string{"1"}
+		case "3":
+			settings.Cases[i].DependsOn = []string{"0"}
+		}
+	}
+	input := &hooksTestInput{
+		Input:    inputRef.Input,
+		path:     inputRef.Input.Path(),
+		settings: &settings,
+	}
+
+	ok := expectedResult{runOutput: programOutput{"5", "", &RunMetadata{Verdict: "OK"}}}
+	rte := runnerTestCase{
+		language:               "py3",
+		source:                 "print(5)",
+		maxScore:               big.NewRat(1, 1),
+		expectedCompileResults: expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+		expectedResults: map[string]expectedResult{
+			"0":   {runOutput: programOutput{"3", "", &RunMetadata{Verdict: "OK"}}},
+			"1.0": {runOutput: programOutput{"", "", &RunMetadata{Verdict: "RTE"}}},
+			"1.1": ok,
+			"2.0": ok,
+			"3.0": ok,
+		},
+	}
+	results, err := Grade(
+		ctx,
+		&bytes.Buffer{},
+		&common.Run{
+			AttemptID: 1,
+			Language:  rte.language,
+			InputHash: input.Hash(),
+			Source:    rte.source,
+			MaxScore:  rte.maxScore,
+		},
+		input,
+		(&fakeSandboxWrapper{}).sandbox(&rte),
+	)
+	if err != nil {
+		t.Fatalf("Failed to grade: %v", err)
+	}
+	if results.Verdict != common.VerdictRuntimeError {
+		t.Errorf("results.Verdict = %q, expected %q", results.Verdict, common.VerdictRuntimeError)
+	}
+	if expected := big.NewRat(2, 5); results.Score.Cmp(expected) != 0 {
+		t.Errorf("results.Score = %s, expected %s", results.Score, expected)
+	}
+	expected := map[string]struct {
+		verdict common.Verdict
+		skipped string
+	}{
+		"0":   {common.VerdictAccepted, ""},
+		"1.0": {common.VerdictRuntimeError, ""},
+		"1.1": {common.VerdictSkipped, SkippedFailFast},
+		"2.0": {common.VerdictSkipped, SkippedDependency},
+		"3.0": {common.VerdictAccepted, ""},
+	}
+	for _, group := range results.Groups {
+		for _, c := range group.Cases {
+			if c.Verdict != expected[c.Name].verdict || c.Meta.Skipped != expected[c.Name].skipped {
+				t.Errorf(
+					"case %q = {%q, %q}, expected {%q, %q}",
+					c.Name,
+					c.Verdict,
+					c.Meta.Skipped,
+					expected[c.Name].verdict,
+					expected[c.Name].skipped,
+				)
+			}
+		}
+	}
+}
+
+func TestGradeWithListener(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+	}
+
+	inputManager := common.NewInputManager(ctx)
+	AplusB, err := common.NewLiteralInputFactory(
+		&common.LiteralInput{
+			Cases: map[string]*common.LiteralCaseSettings{
+				"0":   {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+				"1.0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+				"1.1": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(2, 1)},
+			},
+			Validator: &common.LiteralValidatorSettings{
+				Name: common.ValidatorNameTokenNumeric,
+			},
+		},
+		ctx.Config.Runner.RuntimePath,
+		common.LiteralPersistRunner,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create Input: %q", err)
+	}
+	inputRef, err := inputManager.Add(AplusB.Hash(), AplusB)
+	if err != nil {
+		t.Fatalf("Failed to open problem: %q", err)
+	}
+	defer inputRef.Release()
+
+	testCase := runnerTestCase{
+		"py3",
+		"print(sum(map(int, input().split())))",
+		big.NewRat(1, 1),
+		"PA",
+		big.NewRat(1, 4),
+		expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+		map[string]expectedResult{
+			"0":   {runOutput: programOutput{"3", "", &RunMetadata{Verdict: "OK"}}},
+			"1.0": {runOutput: programOutput{"3", "", &RunMetadata{Verdict: "OK"}}},
+			"1.1": {runOutput: programOutput{"4", "", &RunMetadata{Verdict: "OK"}}},
+		},
+	}
+	var groupResults []GroupResult
+	results, err := GradeWithListener(
+		ctx,
+		&bytes.Buffer{},
+		&common.Run{
+			AttemptID: 1,
+			Language:  testCase.language,
+			InputHash: inputRef.Input.Hash(),
+			Source:    testCase.source,
+			MaxScore:  testCase.maxScore,
+		},
+		inputRef.Input,
+		&fakeSandbox{testCase: &testCase},
+		func(groupResult *GroupResult) {
+			groupResults = append(groupResults, *groupResult)
+		},
+	)
+	if err != nil {
+		t.Fatalf("Failed to run %v: %q", testCase, err)
+	}
+	if results.Verdict != testCase.expectedVerdict {
+		t.Errorf("results.Verdict = %q, expected %q", results.Verdict, testCase.expectedVerdict)
+	}
+	if len(groupResults) != 2 {
+		t.Fatalf("len(groupResults) = %d, expected 2", len(groupResults))
+	}
+	for i, expected := range []struct {
+		group   string
+		verdict common.Verdict
+	}{
+		{"0", "AC"},
+		{"1", "WA"},
+	} {
+		if groupResults[i].Group != expected.group {
+			t.Errorf("groupResults[%d].Group = %q, expected %q", i, groupResults[i].Group, expected.group)
+		}
+		if groupResults[i].Verdict() != expected.verdict {
+			t.Errorf("groupResults[%d].Verdict() = %q, expected %q", i, groupResults[i].Verdict(), expected.verdict)
+		}
+	}
+	if results.Timings.Compile <= 0 || results.Timings.Run <= 0 || results.Timings.Validate <= 0 {
+		t.Errorf("results.Timings = %+v, expected the compile, run and validate phases to be timed", results.Timings)
+	}
+	marshaled, err := json.Marshal(results)
+	if err != nil {
+		t.Fatalf("Failed to marshal results: %v", err)
+	}
+	if strings.Contains(string(marshaled), "timings") {
+		t.Errorf("marshaled results = %s, expected the timings to not be included", marshaled)
+	}
+}
+
+func TestGradeWithCaseListener(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+	}
+
+	inputManager := common.NewInputManager(ctx)
+	AplusB, err := common.NewLiteralInputFactory(
+		&common.LiteralInput{
+			Cases: map[string]*common.LiteralCaseSettings{
+				"0":   {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+				"1.0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+				"1.1": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(2, 1)},
+			},
+			Validator: &common.LiteralValidatorSettings{
+				Name: common.ValidatorNameTokenNumeric,
+			},
+		},
+		ctx.Config.Runner.RuntimePath,
+		common.LiteralPersistRunner,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create Input: %q", err)
+	}
+	inputRef, err := inputManager.Add(AplusB.Hash(), AplusB)
+	if err != nil {
+		t.Fatalf("Failed to open problem: %q", err)
+	}
+	defer inputRef.Release()
+
+	testCase := runnerTestCase{
+		"py3",
+		"print(sum(map(int, input().split())))",
+		big.NewRat(1, 1),
+		"PA",
+		big.NewRat(1, 4),
+		expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+		map[string]expectedResult{
+			"0":   {runOutput: programOutput{"3", "", &RunMetadata{Verdict: "OK"}}},
+			"1.0": {runOutput: programOutput{"3", "", &RunMetadata{Verdict: "OK"}}},
+			"1.1": {runOutput: programOutput{"4", "", &RunMetadata{Verdict: "OK"}}},
+		},
+	}
+	var cases []CaseProgress
+	_, err = GradeWithOptions(
+		ctx,
+		&bytes.Buffer{},
+		&common.Run{
+			AttemptID: 1,
+			Language:  testCase.language,
+			InputHash: inputRef.Input.Hash(),
+			Source:    testCase.source,
+			MaxScore:  testCase.maxScore,
+		},
+		inputRef.Input,
+		&fakeSandbox{testCase: &testCase},
+		GradeOptions{
+			CaseListener: func(group string, caseResult *CaseResult) {
+				cases = append(cases, CaseProgress{Group: group, Case: *caseResult})
+			},
+		},
+	)
+	if err != nil {
+		t.Fatalf("Failed to run %v: %q", testCase, err)
+	}
+	expected := []struct {
+		group   string
+		name    string
+		verdict common.Verdict
+	}{
+		{"0", "0", "AC"},
+		{"1", "1.0", "AC"},
+		{"1", "1.1", "WA"},
+	}
+	if len(cases) != len(expected) {
+		t.Fatalf("len(cases) = %d, expected %d", len(cases), len(expected))
+	}
+	for i, e := range expected {
+		if cases[i].Group != e.group || cases[i].Case.Name != e.name || cases[i].Case.Verdict != e.verdict {
+			t.Errorf(
+				"cases[%d] = {%q, %q, %q}, expected {%q, %q, %q}",
+				i, cases[i].Group, cases[i].Case.Name, cases[i].Case.Verdict,
+				e.group, e.name, e.verdict,
+			)
+		}
+	}
+}
+
+// memoryBinaryCache is a BinaryCache that keeps the binaries in memory.
+type memoryBinaryCache struct {
+	binaries map[string][]byte
+}
+
+func (c *memoryBinaryCache) Get(ctx *common.Context, key, dst string) (bool, error) {
+	contents, ok := c.binaries[key]
+	if !ok {
+		return false, nil
+	}
+	return true, extractBinaryArchive(bytes.NewReader(contents), dst)
+}
+
+func (c *memoryBinaryCache) Put(ctx *common.Context, key, src string) error {
+	var buf bytes.Buffer
+	if err := writeBinaryArchive(&buf, src); err != nil {
+		return err
+	}
+	c.binaries[key] = buf.Bytes()
+	return nil
+}
+
+// compileCountingSandbox is a fakeSandbox that counts the compilations and
+// writes a fake binary, and checks that it is present when running.
+type compileCountingSandbox struct {
+	fakeSandbox
+	t        *testing.T
+	compiles int
+}
+
+func (sandbox *compileCountingSandbox) Compile(
+	ctx *common.Context,
+	lang string,
+	inputFiles []string,
+	chdir, outputFile, errorFile, metaFile, target string,
+	extraFlags []string,
+) (*RunMetadata, error) {
+	sandbox.compiles++
+	if err := ioutil.WriteFile(path.Join(chdir, target), []byte("binary"), 0755); err != nil {
+		return nil, err
+	}
+	return sandbox.fakeSandbox.Compile(
+		ctx,
+		lang,
+		inputFiles,
+		chdir, outputFile, errorFile, metaFile, target,
+		extraFlags,
+	)
+}
+
+func (sandbox *compileCountingSandbox) Run(
+	ctx *common.Context,
+	limits *common.LimitsSettings,
+	lang, chdir, inputFile, outputFile, errorFile, metaFile, target string,
+	originalInputFile, originalOutputFile, runMetaFile *string,
+	extraParams []string,
+	extraMountPoints map[string]string,
+) (*RunMetadata, error) {
+	if info, err := os.Stat(path.Join(chdir, target)); err != nil || info.Mode().Perm()&0100 == 0 {
+		sandbox.t.Errorf("binary %q not found or not executable: %v", target, err)
+	}
+	return sandbox.fakeSandbox.Run(
+		ctx,
+		limits,
+		lang, chdir, inputFile, outputFile, errorFile, metaFile, target,
+		originalInputFile, originalOutputFile, runMetaFile,
+		extraParams,
+		extraMountPoints,
+	)
+}
+
+func TestGradeWithBinaryCache(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+	}
+
+	inputManager := common.NewInputManager(ctx)
+	AplusB, err := common.NewLiteralInputFactory(
+		&common.LiteralInput{
+			Cases: map[string]*common.LiteralCaseSettings{
+				"0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+			},
+			Validator: &common.LiteralValidatorSettings{
+				Name: common.ValidatorNameTokenNumeric,
+			},
+		},
+		ctx.Config.Runner.RuntimePath,
+		common.LiteralPersistRunner,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create Input: %q", err)
+	}
+	inputRef, err := inputManager.Add(AplusB.Hash(), AplusB)
+	if err != nil {
+		t.Fatalf("Failed to open problem: %q", err)
+	}
+	defer inputRef.Release()
+
+	testCase := runnerTestCase{
+		"cpp17-gcc",
+		"int main() { int a, b; std::cin >> a >> b; std::cout << a + b; }",
+		big.NewRat(1, 1),
+		"AC",
+		big.NewRat(1, 1),
+		expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+		map[string]expectedResult{
+			"0": {runOutput: programOutput{"3", "", &RunMetadata{Verdict: "OK"}}},
+		},
+	}
+	sandbox := &compileCountingSandbox{
+		fakeSandbox: fakeSandbox{testCase: &testCase},
+		t:           t,
+	}
+	cache := &memoryBinaryCache{binaries: make(map[string][]byte)}
+
+	for i, tc := range []struct {
+		fingerprint      string
+		expectedCompiles int
+		expectedCached   bool
+	}{
+		{"fingerprint", 1, false},
+		// The second run uses the binary compiled by the first one.
+		{"fingerprint", 1, true},
+		// Binaries are not shared across fingerprints.
+		{"other fingerprint", 2, false},
+	} {
+		results, err := GradeWithOptions(
+			ctx,
+			&bytes.Buffer{},
+			&common.Run{
+				AttemptID: uint64(i + 1),
+				Language:  testCase.language,
+				InputHash: inputRef.Input.Hash(),
+				Source:    testCase.source,
+				MaxScore:  testCase.maxScore,
+			},
+			inputRef.Input,
+			sandbox,
+			GradeOptions{
+				BinaryCache:       cache,
+				BinaryFingerprint: tc.fingerprint,
+			},
+		)
+		if err != nil {
+			t.Fatalf("Failed to run %v: %q", testCase, err)
+		}
+		if results.Verdict != testCase.expectedVerdict {
+			t.Errorf("%d: results.Verdict = %q, expected %q", i, results.Verdict, testCase.expectedVerdict)
+		}
+		if sandbox.compiles != tc.expectedCompiles {
+			t.Errorf("%d: sandbox.compiles = %d, expected %d", i, sandbox.compiles, tc.expectedCompiles)
+		}
+		if results.CompileMeta["Main"].Cached != tc.expectedCached {
+			t.Errorf("%d: results.CompileMeta[\"Main\"].Cached = %v, expected %v", i, results.CompileMeta["Main"].Cached, tc.expectedCached)
+		}
+	}
+	if len(cache.binaries) != 2 {
+		t.Errorf("len(cache.binaries) = %d, expected 2", len(cache.binaries))
+	}
+}
+
+func TestKarelGrade(t *testing.T) {
+	for name, wrapper := range map[string]sandboxWrapper{
+		"fake":      &fakeSandboxWrapper{},
+		"omegajail": &omegajailSandboxWrapper{omegajail: getSandbox()},
+	} {
+		wrapper := wrapper
+		t.Run(name, func(t *testing.T) {
+			if testing.Short() && wrapper.name() == "OmegajailSandbox" {
+				t.Skip("skipping test in short mode.")
+			}
+			if !wrapper.supported() {
+				t.Skip(fmt.Sprintf("%s not supported", wrapper.name()))
+			}
+
+			ctx, err := newRunnerContext(t)
+			if err != nil {
+				t.Fatalf("RunnerContext creation failed with %q", err)
+			}
+			defer ctx.Close()
+			if !ctx.Config.Runner.PreserveFiles {
+				defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+			}
+
+			inputManager := common.NewInputManager(ctx)
+			expectedOutput := `<resultados>
+	<mundos>
+		<mundo nombre="mundo_0"/>
+	</mundos>
+	<programas>
+		<programa nombre="p1" resultadoEjecucion="FIN PROGRAMA">
+			<karel x="1" y="2"/>
+		</programa>
+	</programas>
+</resultados>`
+			AplusB, err := common.NewLiteralInputFactory(
+				&common.LiteralInput{
+					Cases: map[string]*common.LiteralCaseSettings{
+						"0": {Input: `<ejecucion>
+	<condiciones instruccionesMaximasAEjecutar="10000000" longitudStack="65000"></condiciones>
+	<mundos>
+		<mundo nombre="mundo_0" ancho="100" alto="100">
+			<monton x="1" y="2" zumbadores="1"></monton>
+		</mundo>
+	</mundos>
+	<programas tipoEjecucion="CONTINUA" intruccionesCambioContexto="1" milisegundosParaPasoAutomatico="0">
+		<programa nombre="p1" ruta="{$2$}" mundoDeEjecucion="mundo_0" xKarel="1" yKarel="1" direccionKarel="NORTE" mochilaKarel="0">
+			<despliega tipo="MUNDO"></despliega>
+			<despliega tipo="POSICION"></despliega>
+		</programa>
+	</programas>
+</ejecucion>`, ExpectedOutput: expectedOutput, Weight: big.NewRat(1, 1)},
+					},
+					Validator: &common.LiteralValidatorSettings{
+						Name: common.ValidatorNameTokenNumeric,
+					},
+				},
+				ctx.Config.Runner.RuntimePath,
+				common.LiteralPersistRunner,
+			)
+			if err != nil {
+				t.Fatalf("Failed to create Input: %q", err)
+			}
+
+			inputRef, err := inputManager.Add(AplusB.Hash(), AplusB)
+			if err != nil {
+				t.Fatalf("Failed to open problem: %q", err)
+			}
+			defer inputRef.Release()
+
+			runtests := []runnerTestCase{
+				{
+					"kp",
+					`
+			iniciar-programa
+				inicia-ejecucion
+					mientras no-junto-a-zumbador hacer avanza;
+					apagate;
+				termina-ejecucion
+			finalizar-programa
+			`,
+					big.NewRat(1, 1),
+					"AC",
+					big.NewRat(1, 1),
+					expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+					map[string]expectedResult{
+						"0": {runOutput: programOutput{expectedOutput, "", &RunMetadata{Verdict: "OK"}}},
+					},
+				},
+				{
+					"kj",
+					"class program { program () { while (!nextToABeeper()) move(); turnoff(); } }",
+					big.NewRat(1, 1),
+					"AC",
+					big.NewRat(1, 1),
+					expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+					map[string]expectedResult{
+						"0": {runOutput: programOutput{expectedOutput, "", &RunMetadata{Verdict: "OK"}}},
+					},
+				},
+			}
+			for idx, rte := range runtests {
+				t.Run(fmt.Sprintf("%s/%d/%s %s", wrapper.name(), idx, rte.language, rte.expectedVerdict), func(t *testing.T) {
+					results, err := Grade(
+						ctx,
+						&bytes.Buffer{},
+						&common.Run{
+							AttemptID: uint64(idx),
+							Language:  rte.language,
+							InputHash: inputRef.Input.Hash(),
+							Source:    rte.source,
+							MaxScore:  rte.maxScore,
+						},
+						inputRef.Input,
+						wrapper.sandbox(&rte),
+					)
+					if err != nil {
+						t.Fatalf("Failed to run %v: %q", rte, err)
+					}
+					if results.Verdict != rte.expectedVerdict {
+						t.Errorf(
+							"results.Verdict = %q, expected %q, test %v: %v",
+							results.Verdict,
+							rte.expectedVerdict,
+							idx,
+							rte,
+						)
+					}
+					if results.Score.Cmp(rte.expectedScore) != 0 {
+						t.Errorf(
+							"results.Score = %s, expected %s",
+							results.Score.String(),
+							rte.expectedScore.String(),
+						)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestLibinteractive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	omegajail := getSandbox()
+	if !omegajail.Supported() {
+		t.Skip("omegajail sandbox not supported")
+	}
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	if !ctx.Config.Runner.PreserveFiles {
+		defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+	}
+
+	inputManager := common.NewInputManager(ctx)
+	AplusB, err := common.NewLiteralInputFactory(
+		&common.LiteralInput{
+			Cases: map[string]*common.LiteralCaseSettings{
+				"0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+				"1": {Input: "2 3", ExpectedOutput: "5", Weight: big.NewRat(1, 1)},
 			},
 			Validator: &common.LiteralValidatorSettings{
 				Name: common.ValidatorNameTokenNumeric,
@@ -1919,7 +2701,7 @@ func TestGradeWithCustomValidatorExpectedOutput(t *testing.T) {
 				ValidatorName   string
 				ValidatorSource string
 				ExpectedOutput  programOutput
-				ExpectedVerdict string
+				ExpectedVerdict common.Verdict
 				ExpectedScore   *big.Rat
 			}{
 				{
@@ -2054,29 +2836,717 @@ func TestGradeWithCustomValidatorExpectedOutput(t *testing.T) {
 					}
 				})
 			}
-		})
+		})
+	}
+}
+
+func TestGradeWithBrokenCustomValidator(t *testing.T) {
+	for _, vv := range []struct {
+		name            string
+		validatorOutput programOutput
+		expectedVerdict common.Verdict
+	}{
+		{"crash", programOutput{"", "", &RunMetadata{Verdict: "RTE", ExitStatus: 1}}, "VE"},
+		{"timeout", programOutput{"", "", &RunMetadata{Verdict: "TLE"}}, "VE"},
+		{"unparsable score", programOutput{"yes", "", &RunMetadata{Verdict: "OK"}}, "VE"},
+		{"valid score", programOutput{"1", "", &RunMetadata{Verdict: "OK"}}, "AC"},
+	} {
+		vv := vv
+		t.Run(vv.name, func(t *testing.T) {
+			ctx, err := newRunnerContext(t)
+			if err != nil {
+				t.Fatalf("RunnerContext creation failed with %q", err)
+			}
+			defer ctx.Close()
+			if !ctx.Config.Runner.PreserveFiles {
+				defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+			}
+
+			inputManager := common.NewInputManager(ctx)
+			AplusB, err := common.NewLiteralInputFactory(
+				&common.LiteralInput{
+					Cases: map[string]*common.LiteralCaseSettings{
+						"0": {Input: "1 2", ExpectedOutput: "3", Weight: big.NewRat(1, 1)},
+					},
+					Limits: &common.DefaultLimits,
+					Validator: &common.LiteralValidatorSettings{
+						Name: common.ValidatorNameCustom,
+						CustomValidator: &common.LiteralCustomValidatorSettings{
+							Language: "py3",
+							Limits:   &common.DefaultValidatorLimits,
+							Source:   "print(1)",
+						},
+					},
+				},
+				ctx.Config.Runner.RuntimePath,
+				common.LiteralPersistRunner,
+			)
+			if err != nil {
+				t.Fatalf("Failed to create Input: %q", err)
+			}
+			inputRef, err := inputManager.Add(AplusB.Hash(), AplusB)
+			if err != nil {
+				t.Fatalf("Failed to open problem: %q", err)
+			}
+			defer inputRef.Release()
+
+			rte := runnerTestCase{
+				"py3",
+				"print(sum(map(int, input().strip().split())))",
+				big.NewRat(1, 1),
+				vv.expectedVerdict,
+				big.NewRat(1, 1),
+				expectedResult{runOutput: programOutput{"", "", &RunMetadata{Verdict: "OK"}}},
+				map[string]expectedResult{
+					"0": {
+						runOutput:       programOutput{"3", "", &RunMetadata{Verdict: "OK"}},
+						validatorOutput: vv.validatorOutput,
+					},
+				},
+			}
+			results, err := Grade(
+				ctx,
+				&bytes.Buffer{},
+				&common.Run{
+					AttemptID: 0,
+					Language:  rte.language,
+					InputHash: inputRef.Input.Hash(),
+					Source:    rte.source,
+					MaxScore:  rte.maxScore,
+				},
+				inputRef.Input,
+				(&fakeSandboxWrapper{}).sandbox(&rte),
+			)
+			if err != nil {
+				t.Fatalf("Failed to run %v: %q", rte, err)
+			}
+			if results.Verdict != vv.expectedVerdict {
+				t.Errorf("results.Verdict = %q, expected %q", results.Verdict, vv.expectedVerdict)
+			}
+			if vv.expectedVerdict == "VE" && results.Score.Cmp(&big.Rat{}) != 0 {
+				t.Errorf("results.Score = %s, expected 0", results.Score.String())
+			}
+		})
+	}
+}
+
+func TestValidatorLimits(t *testing.T) {
+	problemLimits := common.DefaultLimits
+	problemLimits.TimeLimit = base.Duration(3 * time.Second)
+
+	limits := validatorLimits(&problemLimits, nil)
+	if limits.TimeLimit != problemLimits.TimeLimit {
+		t.Errorf("TimeLimit == %v, expected %v", limits.TimeLimit, problemLimits.TimeLimit)
+	}
+	if limits.MemoryLimit != common.DefaultValidatorLimits.MemoryLimit {
+		t.Errorf("MemoryLimit == %v, expected %v", limits.MemoryLimit, common.DefaultValidatorLimits.MemoryLimit)
+	}
+
+	limits = validatorLimits(&problemLimits, &common.LimitsSettings{
+		MemoryLimit: base.Byte(512) * base.Mebibyte,
+	})
+	if limits.MemoryLimit != base.Byte(512)*base.Mebibyte {
+		t.Errorf("MemoryLimit == %v, expected the validator's", limits.MemoryLimit)
+	}
+	if limits.TimeLimit != problemLimits.TimeLimit {
+		t.Errorf("TimeLimit == %v, expected %v", limits.TimeLimit, problemLimits.TimeLimit)
+	}
+	if limits.OutputLimit != common.DefaultValidatorLimits.OutputLimit {
+		t.Errorf("OutputLimit == %v, expected %v", limits.OutputLimit, common.DefaultValidatorLimits.OutputLimit)
+	}
+}
+
+func TestWorseVerdict(t *testing.T) {
+	verdictentries := []struct {
+		a, b, expected common.Verdict
+	}{
+		{"OK", "AC", "AC"},
+		{"AC", "OK", "AC"},
+		{"JE", "AC", "JE"},
+		{"SK", "OK", "OK"},
+	}
+	for _, vet := range verdictentries {
+		got := vet.a.Worse(vet.b)
+		if got != vet.expected {
+			t.Errorf(
+				"%q.Worse(%q) == %q, expected %q",
+				vet.a,
+				vet.b,
+				got,
+				vet.expected,
+			)
+		}
+	}
+}
+
+type perBinarySandbox struct {
+	fakeSandbox
+	metas map[string]*RunMetadata
+}
+
+func (sandbox *perBinarySandbox) Run(
+	ctx *common.Context,
+	limits *common.LimitsSettings,
+	lang, chdir, inputFile, outputFile, errorFile, metaFile, target string,
+	originalInputFile, originalOutputFile, runMetaFile *string,
+	extraParams []string,
+	extraMountPoints map[string]string,
+) (*RunMetadata, error) {
+	meta := *sandbox.metas[chdir]
+	return &meta, nil
+}
+
+func TestRunCaseIndividualMeta(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	binaries := []*binary{
+		{
+			name:       "Main",
+			binPath:    "Main/bin",
+			binaryType: binaryProblemsetter,
+		},
+		{
+			name:       "AplusB",
+			binPath:    "AplusB/bin",
+			binaryType: binaryContestant,
+		},
+	}
+	sandbox := &perBinarySandbox{
+		metas: map[string]*RunMetadata{
+			"Main/bin":   {Verdict: "OK", Time: 0.3, Memory: 1024},
+			"AplusB/bin": {Verdict: "OK", Time: 0.1, Memory: 2048},
+		},
+	}
+
+	runMeta, individualMeta, _ := runCase(
+		ctx,
+		&common.Run{Language: "cpp17-gcc"},
+		nil,
+		sandbox,
+		binaries,
+		len(binaries),
+		&RunLayout{Root: ctx.Config.Runner.RuntimePath},
+		&common.CaseSettings{Name: "0", Weight: big.NewRat(1, 1)},
+		"/dev/null",
+	)
+	if runMeta.Time != 0.1 || runMeta.Memory != 2048 {
+		t.Errorf("expected the merged metadata to be the contestant's, got %v", runMeta)
+	}
+	for name, expected := range sandbox.metas {
+		name = path.Dir(name)
+		meta, ok := individualMeta[name]
+		if !ok {
+			t.Errorf("missing metadata for %q: %v", name, individualMeta)
+			continue
+		}
+		if meta.Time != expected.Time || meta.Memory != expected.Memory {
+			t.Errorf("individualMeta[%q] == %v, expected %v", name, meta, *expected)
+		}
+	}
+}
+
+func TestRunResultRedacted(t *testing.T) {
+	result := NewRunResult("WA", big.NewRat(1, 1))
+	result.CompileMeta = map[string]RunMetadata{
+		"Main":      {Verdict: "OK", Time: 1.5},
+		"validator": {Verdict: "OK", Time: 2.5},
+	}
+	result.Time = 1.2
+	result.WallTime = 1.4
+	result.Memory = 4096
+	result.OverallOutput = 30
+	result.OverallError = 3
+	result.JudgedBy = "runner.example.com"
+	result.TruncatedArtifacts = []string{"0.hidden.out"}
+	result.Groups = []GroupResult{
+		{
+			Group:        "0",
+			Score:        big.NewRat(1, 2),
+			ContestScore: big.NewRat(1, 2),
+			MaxScore:     big.NewRat(1, 1),
+			Cases: []CaseResult{
+				{
+					Verdict:        "AC",
+					Name:           "0.public",
+					Score:          big.NewRat(1, 1),
+					ContestScore:   big.NewRat(1, 2),
+					MaxScore:       big.NewRat(1, 2),
+					OutputSize:     10,
+					Meta:           RunMetadata{Verdict: "OK", Time: 0.5, WallTime: 0.6, Memory: 1024, OutputSize: 10, ErrorSize: 1},
+					Visibility:     common.CaseVisibilityPublic,
+					TimeLimitRatio: 0.5,
+				},
+				{
+					Verdict:        "WA",
+					Name:           "0.hidden",
+					Score:          &big.Rat{},
+					ContestScore:   &big.Rat{},
+					MaxScore:       big.NewRat(1, 2),
+					OutputSize:     20,
+					Meta:           RunMetadata{Verdict: "OK", Time: 0.7, WallTime: 0.8, Memory: 4096, OutputSize: 20, ErrorSize: 2},
+					TimeLimitRatio: 0.7,
+				},
+			},
+		},
+	}
+
+	redacted := result.Redacted()
+	if result.Groups[0].Cases[1].OutputSize != 20 {
+		t.Errorf("original result was modified: %v", result.Groups[0].Cases[1])
+	}
+	publicCase := redacted.Groups[0].Cases[0]
+	if publicCase.Meta.Time != 0.5 || publicCase.OutputSize != 10 {
+		t.Errorf("public case was redacted: %v", publicCase)
+	}
+	hiddenCase := redacted.Groups[0].Cases[1]
+	if hiddenCase.Verdict != "WA" || hiddenCase.Meta.Time != 0 || hiddenCase.OutputSize != 0 || hiddenCase.TimeLimitRatio != 0 {
+		t.Errorf("hidden case was not redacted: %v", hiddenCase)
+	}
+	if redacted.Time != 0.5 {
+		t.Errorf("redacted time == %v, want 0.5", redacted.Time)
+	}
+	if redacted.WallTime != 0.6 {
+		t.Errorf("redacted wall time == %v, want 0.6", redacted.WallTime)
+	}
+	if redacted.Memory != 1024 {
+		t.Errorf("redacted memory == %v, want 1024", redacted.Memory)
+	}
+	if redacted.OverallOutput != 10 {
+		t.Errorf("redacted overall output == %v, want 10", redacted.OverallOutput)
+	}
+	if redacted.OverallError != 1 {
+		t.Errorf("redacted overall error == %v, want 1", redacted.OverallError)
+	}
+	if redacted.CompileMeta != nil {
+		t.Errorf("redacted compile metadata == %v, want nil", redacted.CompileMeta)
+	}
+	if redacted.JudgedBy != "" {
+		t.Errorf("redacted judged by == %q, want empty", redacted.JudgedBy)
+	}
+	if redacted.TruncatedArtifacts != nil {
+		t.Errorf("redacted truncated artifacts == %v, want nil", redacted.TruncatedArtifacts)
+	}
+	if result.Time != 1.2 || result.JudgedBy == "" || len(result.CompileMeta) != 2 {
+		t.Errorf("original result was modified: %v", result)
+	}
+
+	marshaled, err := json.Marshal(redacted)
+	if err != nil {
+		t.Fatalf("Failed to marshal redacted result: %v", err)
+	}
+	var unmarshaled struct {
+		Groups []struct {
+			Cases []map[string]any `json:"cases"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		t.Fatalf("Failed to unmarshal redacted result: %v", err)
+	}
+	if _, ok := unmarshaled.Groups[0].Cases[0]["meta"]; !ok {
+		t.Errorf("public case is missing its metadata: %s", marshaled)
+	}
+	if _, ok := unmarshaled.Groups[0].Cases[1]["meta"]; ok {
+		t.Errorf("hidden case has metadata: %s", marshaled)
+	}
+	if ratio, ok := unmarshaled.Groups[0].Cases[0]["time_limit_ratio"]; !ok || ratio != 0.5 {
+		t.Errorf("public case has the wrong time limit ratio: %s", marshaled)
+	}
+	if _, ok := unmarshaled.Groups[0].Cases[1]["time_limit_ratio"]; ok {
+		t.Errorf("hidden case has a time limit ratio: %s", marshaled)
+	}
+}
+
+func TestRunResultWithFeedback(t *testing.T) {
+	result := NewRunResult("WA", big.NewRat(1, 1))
+	result.Score = big.NewRat(1, 2)
+	result.Time = 1.5
+	result.Groups = []GroupResult{
+		{
+			Group:        "0",
+			Score:        big.NewRat(1, 2),
+			ContestScore: big.NewRat(1, 2),
+			MaxScore:     big.NewRat(1, 1),
+			Cases: []CaseResult{
+				{
+					Verdict:      "AC",
+					Name:         "0.public",
+					Score:        big.NewRat(1, 1),
+					ContestScore: big.NewRat(1, 2),
+					MaxScore:     big.NewRat(1, 2),
+					OutputSize:   10,
+					Meta:         RunMetadata{Verdict: "OK", Time: 0.5},
+					Visibility:   common.CaseVisibilityPublic,
+				},
+				{
+					Verdict:      "WA",
+					Name:         "0.hidden",
+					Score:        &big.Rat{},
+					ContestScore: &big.Rat{},
+					MaxScore:     big.NewRat(1, 2),
+					OutputSize:   20,
+					Meta:         RunMetadata{Verdict: "OK", Time: 0.7},
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		level      common.FeedbackLevel
+		present    []string
+		absent     []string
+		groups     bool
+		cases      bool
+		hiddenMeta bool
+		feedback   common.FeedbackLevel
+	}{
+		{common.FeedbackLevelNone, nil, []string{"verdict", "score", "time", "groups"}, false, false, false, "none"},
+		{common.FeedbackLevelSummary, []string{"verdict", "score", "time"}, nil, false, false, false, "summary"},
+		{common.FeedbackLevelPerGroup, []string{"verdict", "score", "time"}, nil, true, false, false, "per-group"},
+		{common.FeedbackLevelPerCase, []string{"verdict", "score", "time"}, nil, true, true, false, "per-case"},
+		{common.FeedbackLevelDefault, []string{"verdict", "score", "time"}, nil, true, true, false, "per-case"},
+		{common.FeedbackLevelFullDiff, []string{"verdict", "score", "time"}, nil, true, true, true, "full-diff"},
+	} {
+		marshaled, err := json.Marshal(result.WithFeedback(tc.level))
+		if err != nil {
+			t.Fatalf("Failed to marshal the %q result: %v", tc.level, err)
+		}
+		var unmarshaled map[string]any
+		if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+			t.Fatalf("Failed to unmarshal the %q result: %v", tc.level, err)
+		}
+		for _, key := range tc.present {
+			if _, ok := unmarshaled[key]; !ok {
+				t.Errorf("%q result %s is missing %q", tc.level, marshaled, key)
+			}
+		}
+		for _, key := range tc.absent {
+			if _, ok := unmarshaled[key]; ok {
+				t.Errorf("%q result %s has %q", tc.level, marshaled, key)
+			}
+		}
+		if feedback := unmarshaled["feedback"]; feedback != string(tc.feedback) {
+			t.Errorf("%q result feedback == %v, want %q", tc.level, feedback, tc.feedback)
+		}
+		groups, _ := unmarshaled["groups"].([]any)
+		if (len(groups) > 0) != tc.groups {
+			t.Errorf("%q result %s has groups: %v, want %v", tc.level, marshaled, len(groups) > 0, tc.groups)
+			continue
+		}
+		if !tc.groups {
+			continue
+		}
+		cases, _ := groups[0].(map[string]any)["cases"].([]any)
+		if (len(cases) > 0) != tc.cases {
+			t.Errorf("%q result %s has cases: %v, want %v", tc.level, marshaled, len(cases) > 0, tc.cases)
+			continue
+		}
+		if !tc.cases {
+			continue
+		}
+		if _, ok := cases[1].(map[string]any)["meta"]; ok != tc.hiddenMeta {
+			t.Errorf("%q result %s has hidden case metadata: %v, want %v", tc.level, marshaled, ok, tc.hiddenMeta)
+		}
+	}
+
+	// Runs that did not compile still show the compile error.
+	compileError := "main.cpp: error"
+	result = NewRunResult("CE", big.NewRat(1, 1))
+	result.CompileError = &compileError
+	marshaled, err := json.Marshal(result.WithFeedback(common.FeedbackLevelNone))
+	if err != nil {
+		t.Fatalf("Failed to marshal the result: %v", err)
+	}
+	var unmarshaled RunResult
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		t.Fatalf("Failed to unmarshal the result: %v", err)
+	}
+	if unmarshaled.Verdict != "CE" || unmarshaled.CompileError == nil || *unmarshaled.CompileError != compileError {
+		t.Errorf("result == %s, want the CE verdict and its compile error", marshaled)
 	}
 }
 
-func TestWorseVerdict(t *testing.T) {
-	verdictentries := []struct {
-		a, b, expected string
+func TestRunResultVerdictDetail(t *testing.T) {
+	result := NewRunResult("TLE", big.NewRat(1, 1))
+	result.Groups = []GroupResult{
+		{
+			Group: "0",
+			Cases: []CaseResult{{Verdict: "TLE", Name: "0"}},
+		},
+	}
+
+	marshaled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Failed to marshal result: %v", err)
+	}
+	var unmarshaled struct {
+		Verdict       string               `json:"verdict"`
+		VerdictDetail common.VerdictDetail `json:"verdict_detail"`
+		Groups        []struct {
+			Cases []struct {
+				Verdict       string               `json:"verdict"`
+				VerdictDetail common.VerdictDetail `json:"verdict_detail"`
+			} `json:"cases"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+	expectedDetail := common.VerdictDetail{
+		LocalizationKey: "verdictTLE",
+		Description:     "Time limit exceeded",
+	}
+	if unmarshaled.Verdict != "TLE" || unmarshaled.VerdictDetail != expectedDetail {
+		t.Errorf("run has the wrong verdict: %s", marshaled)
+	}
+	caseResult := unmarshaled.Groups[0].Cases[0]
+	if caseResult.Verdict != "TLE" || caseResult.VerdictDetail != expectedDetail {
+		t.Errorf("case has the wrong verdict: %s", marshaled)
+	}
+
+	var roundTripped RunResult
+	if err := json.Unmarshal(marshaled, &roundTripped); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+	if roundTripped.Verdict != "TLE" || roundTripped.Groups[0].Cases[0].Verdict != "TLE" {
+		t.Errorf("verdict did not survive a round trip: %+v", roundTripped)
+	}
+}
+
+func TestTimeLimitRatio(t *testing.T) {
+	timeLimit := base.Duration(2 * time.Second)
+	for _, entry := range []struct {
+		time      float64
+		timeLimit base.Duration
+		expected  float64
 	}{
-		{"OK", "AC", "AC"},
-		{"AC", "OK", "AC"},
-		{"JE", "AC", "JE"},
+		{0.5, timeLimit, 0.25},
+		{2, timeLimit, 1},
+		{2.2, timeLimit, 1.1},
+		{0.5, 0, 0},
+	} {
+		got := timeLimitRatio(&RunMetadata{Time: entry.time}, entry.timeLimit)
+		if got != entry.expected {
+			t.Errorf(
+				"timeLimitRatio(%v, %v) == %v, expected %v",
+				entry.time,
+				entry.timeLimit,
+				got,
+				entry.expected,
+			)
+		}
 	}
-	for _, vet := range verdictentries {
-		got := worseVerdict(vet.a, vet.b)
-		if got != vet.expected {
+}
+
+func TestCompileErrorOutput(t *testing.T) {
+	binRoot := t.TempDir()
+	writeFile := func(name, contents string) {
+		if err := ioutil.WriteFile(path.Join(binRoot, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	writeFile("compile.out", "Main.cs(3,1): error CS1525: Unexpected symbol `}'\n")
+	writeFile("compile.err", "warning: unused variable\n")
+
+	for _, entry := range []struct {
+		language string
+		expected string
+	}{
+		{"cpp17-gcc", "warning: unused variable\n"},
+		{"cs", "Main.cs(3,1): error CS1525: Unexpected symbol `}'\n"},
+	} {
+		if got := compileErrorOutput(binRoot, entry.language); got != entry.expected {
+			t.Errorf("compileErrorOutput(%q) == %q, expected %q", entry.language, got, entry.expected)
+		}
+	}
+
+	// If the compiler did not write anything to its output, the error comes
+	// from compile.err.
+	writeFile("compile.out", "")
+	writeFile("compile.err", "mcs: not found\n")
+	if got := compileErrorOutput(binRoot, "cs"); got != "mcs: not found\n" {
+		t.Errorf("compileErrorOutput(\"cs\") == %q, expected %q", got, "mcs: not found\n")
+	}
+}
+
+func TestTruncateCompileOutput(t *testing.T) {
+	filename := path.Join(t.TempDir(), "compile.err")
+	for _, entry := range []struct {
+		contents  string
+		limit     base.Byte
+		expected  string
+		truncated bool
+	}{
+		{"0123456789", 0, "0123456789", false},
+		{"0123456789", 10, "0123456789", false},
+		{"0123456789", 4, "01\n[truncated: 6 bytes omitted]\n89", true},
+		{"0123456789", 5, "01\n[truncated: 5 bytes omitted]\n789", true},
+	} {
+		if err := ioutil.WriteFile(filename, []byte(entry.contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", filename, err)
+		}
+		truncated, err := truncateCompileOutput(filename, entry.limit)
+		if err != nil {
+			t.Fatalf("truncateCompileOutput(%q, %d) failed: %v", entry.contents, entry.limit, err)
+		}
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+		if string(contents) != entry.expected || truncated != entry.truncated {
 			t.Errorf(
-				"WorseVerdict(%q %q) == %q, expected %q",
-				vet.a,
-				vet.b,
+				"truncateCompileOutput(%q, %d) == %q, %v, expected %q, %v",
+				entry.contents,
+				entry.limit,
+				contents,
+				truncated,
+				entry.expected,
+				entry.truncated,
+			)
+		}
+	}
+
+	if truncated, err := truncateCompileOutput(filename+".missing", 4); err != nil || truncated {
+		t.Errorf("truncateCompileOutput(missing) == %v, %v, expected false, nil", truncated, err)
+	}
+}
+
+func TestGroupExecutionOrder(t *testing.T) {
+	groups := []common.GroupSettings{
+		{Name: "0"},
+		{Name: "1"},
+		{Name: "sample"},
+		{Name: "2"},
+	}
+	expected := []int{2, 0, 1, 3}
+	got := groupExecutionOrder(groups)
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("groupExecutionOrder() == %v, expected %v", got, expected)
+	}
+}
+
+func TestIsBorderlineTLE(t *testing.T) {
+	timeLimit := base.Duration(time.Second)
+	sigxcpu := "SIGXCPU"
+	sigalrm := "SIGALRM"
+	entries := []struct {
+		verdict  common.Verdict
+		time     float64
+		signal   *string
+		expected bool
+	}{
+		{"OK", 0.5, nil, false},
+		{"OK", 0.96, nil, true},
+		{"TLE", 0.98, &sigalrm, true},
+		{"TLE", 1.04, &sigalrm, true},
+		{"TLE", 1.0, &sigxcpu, false},
+		{"TLE", 1.02, &sigxcpu, false},
+		{"TLE", 1.05, &sigalrm, false},
+		{"TLE", 1.5, &sigalrm, false},
+		{"RTE", 0.99, nil, false},
+	}
+	for _, entry := range entries {
+		meta := &RunMetadata{Verdict: entry.verdict, Time: entry.time, Signal: entry.signal}
+		got := isBorderlineTLE(meta, timeLimit, 0.05)
+		if got != entry.expected {
+			t.Errorf(
+				"isBorderlineTLE(%q, %v, %v) == %v, expected %v",
+				entry.verdict,
+				entry.time,
+				entry.signal != nil && *entry.signal == sigxcpu,
 				got,
-				vet.expected,
+				entry.expected,
+			)
+		}
+	}
+}
+
+func TestBudgetBinaries(t *testing.T) {
+	limits := common.DefaultLimits
+	limits.TimeLimit = base.Duration(time.Second)
+	limits.ExtraWallTime = base.Duration(500 * time.Millisecond)
+	binaries := []*binary{
+		{name: "Main", binaryType: binaryContestant, limits: limits},
+		{name: "validator", binaryType: binaryValidator, limits: limits},
+	}
+
+	got, budgeted := budgetBinaries(binaries, base.Duration(2*time.Second))
+	if budgeted || got[0] != binaries[0] {
+		t.Errorf("budgetBinaries(2s) == %v, expected the original binaries", budgeted)
+	}
+
+	entries := []struct {
+		remaining     base.Duration
+		timeLimit     base.Duration
+		extraWallTime base.Duration
+	}{
+		{base.Duration(1200 * time.Millisecond), base.Duration(time.Second), base.Duration(200 * time.Millisecond)},
+		{base.Duration(300 * time.Millisecond), base.Duration(300 * time.Millisecond), 0},
+	}
+	for _, entry := range entries {
+		got, budgeted := budgetBinaries(binaries, entry.remaining)
+		if !budgeted {
+			t.Errorf("budgetBinaries(%v) == false, expected true", entry.remaining)
+			continue
+		}
+		if got[0].limits.TimeLimit != entry.timeLimit ||
+			got[0].limits.ExtraWallTime != entry.extraWallTime {
+			t.Errorf(
+				"budgetBinaries(%v) == {%v, %v}, expected {%v, %v}",
+				entry.remaining,
+				got[0].limits.TimeLimit,
+				got[0].limits.ExtraWallTime,
+				entry.timeLimit,
+				entry.extraWallTime,
 			)
 		}
+		if got[1] != binaries[1] {
+			t.Errorf("budgetBinaries(%v) modified the validator", entry.remaining)
+		}
+		if binaries[0].limits.TimeLimit != limits.TimeLimit {
+			t.Errorf("budgetBinaries(%v) modified the original binary", entry.remaining)
+		}
+	}
+}
+
+func TestCaseLimitsBinaries(t *testing.T) {
+	limits := common.DefaultLimits
+	debugLimits := limits
+	debugLimits.MemoryLimit = -1
+	binaries := []*binary{
+		{name: "Main", binaryType: binaryContestant, limits: limits},
+		{name: "Debug", binaryType: binaryContestant, limits: debugLimits},
+		{name: "validator", binaryType: binaryValidator, limits: limits},
+	}
+
+	got := caseLimitsBinaries(binaries, &common.CaseSettings{Name: "0"})
+	if got[0] != binaries[0] {
+		t.Errorf("caseLimitsBinaries() without limits did not return the original binaries")
+	}
+
+	caseData := &common.CaseSettings{
+		Name:        "huge",
+		TimeLimit:   base.Duration(5 * time.Second),
+		MemoryLimit: 512 * base.Mebibyte,
+	}
+	got = caseLimitsBinaries(binaries, caseData)
+	if got[0].limits.TimeLimit != caseData.TimeLimit ||
+		got[0].limits.MemoryLimit != caseData.MemoryLimit ||
+		got[0].limits.OutputLimit != limits.OutputLimit {
+		t.Errorf("caseLimitsBinaries() == %v, expected the limits of the case", got[0].limits)
+	}
+	if got[1].limits.TimeLimit != caseData.TimeLimit || got[1].limits.MemoryLimit != -1 {
+		t.Errorf("caseLimitsBinaries() == %v, expected no memory limit", got[1].limits)
+	}
+	if got[2] != binaries[2] {
+		t.Errorf("caseLimitsBinaries() modified the validator")
+	}
+	if binaries[0].limits != limits {
+		t.Errorf("caseLimitsBinaries() modified the original binary")
 	}
 }
 
@@ -2092,7 +3562,7 @@ func TestMergeVerdict(t *testing.T) {
 	// Contestant has a no fault verdict.
 	for _, entry := range []struct {
 		b        *RunMetadata
-		expected string
+		expected common.Verdict
 	}{
 		{&RunMetadata{Verdict: "CE"}, "VE"},
 		{&RunMetadata{Verdict: "RFE"}, "VE"},
@@ -2117,7 +3587,7 @@ func TestMergeVerdict(t *testing.T) {
 	// Contestant finished successfully.
 	for _, entry := range []struct {
 		b        *RunMetadata
-		expected string
+		expected common.Verdict
 	}{
 		{&RunMetadata{Verdict: "CE"}, "VE"},
 		{&RunMetadata{Verdict: "RFE"}, "VE"},
@@ -2142,7 +3612,7 @@ func TestMergeVerdict(t *testing.T) {
 	// Parent has a no fault verdict.
 	for _, entry := range []struct {
 		a        *RunMetadata
-		expected string
+		expected common.Verdict
 	}{
 		{&RunMetadata{Verdict: "CE"}, "CE"},
 		{&RunMetadata{Verdict: "RFE"}, "RFE"},
@@ -2167,7 +3637,7 @@ func TestMergeVerdict(t *testing.T) {
 	// Parent finished successfully.
 	for _, entry := range []struct {
 		a        *RunMetadata
-		expected string
+		expected common.Verdict
 	}{
 		{&RunMetadata{Verdict: "CE"}, "CE"},
 		{&RunMetadata{Verdict: "RFE"}, "RFE"},
@@ -2188,4 +3658,366 @@ func TestMergeVerdict(t *testing.T) {
 			}
 		})
 	}
+
+	// The parent's metadata is attached when it fails.
+	merged := mergeVerdict(ctx, &RunMetadata{Verdict: "OK"}, &RunMetadata{Verdict: "RTE", ExitStatus: 1})
+	if merged.ParentMeta == nil || merged.ParentMeta.ExitStatus != 1 {
+		t.Errorf("mergeVerdict().ParentMeta == %v, expected the parent's metadata", merged.ParentMeta)
+	}
+	caseResult := CaseResult{Verdict: merged.Verdict, Meta: *merged}
+	if !caseResult.ProblemsetterFailure() {
+		t.Errorf("expected %v to be a problemsetter failure", caseResult)
+	}
+	if merged := mergeVerdict(ctx, &RunMetadata{Verdict: "OK"}, &RunMetadata{Verdict: "OK"}); merged.ParentMeta != nil {
+		t.Errorf("mergeVerdict().ParentMeta == %v, expected nil", merged.ParentMeta)
+	}
+}
+
+func TestWriteZipFileTruncation(t *testing.T) {
+	dirname := t.TempDir()
+	for name, contents := range map[string]string{
+		"0.out": "hello",
+		"1.out": "world",
+		"2.out": "!",
+	} {
+		if err := os.WriteFile(path.Join(dirname, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	truncated, err := writeZipFile(
+		&buf,
+		dirname,
+		[]string{"0.out", "1.out", "missing.out", "2.out"},
+		base.Byte(8),
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("Failed to write zip file: %v", err)
+	}
+	if !reflect.DeepEqual(truncated, []string{"1.out", "2.out"}) {
+		t.Errorf("truncated == %q, want %q", truncated, []string{"1.out", "2.out"})
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read zip file: %v", err)
+	}
+	expected := map[string]string{
+		"0.out": "hello",
+		"1.out": "wor\n[truncated: 2 bytes omitted]\n",
+		"2.out": "\n[truncated: 1 bytes omitted]\n",
+	}
+	if len(z.File) != len(expected) {
+		t.Fatalf("len(z.File) == %d, want %d", len(z.File), len(expected))
+	}
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		contents, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", f.Name, err)
+		}
+		if expected[f.Name] != string(contents) {
+			t.Errorf("contents of %s == %q, want %q", f.Name, string(contents), expected[f.Name])
+		}
+	}
+}
+
+func TestWriteZipFilePriorities(t *testing.T) {
+	dirname := t.TempDir()
+	files := map[string]string{
+		"Main/compile.err": "warning",
+		"0.out":            "3\n",
+		"0.err":            "debug output",
+		"0.meta":           "status:0",
+		"1.out":            "partial",
+		"1.err":            "segfault",
+		"1.meta":           "status:0\nsignal:SIGSEGV",
+	}
+	for name, contents := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(dirname, name)), 0755); err != nil {
+			t.Fatalf("Failed to create the directory of %s: %v", name, err)
+		}
+		if err := os.WriteFile(path.Join(dirname, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	caseVerdicts := map[string]common.Verdict{"0": "OK", "1": "RTE"}
+
+	for _, tc := range []struct {
+		maxSize   base.Byte
+		truncated []string
+	}{
+		// Everything fits.
+		{68, nil},
+		// The stderr of the case that ran correctly goes first.
+		{56, []string{"0.err"}},
+		// Then its output, and then anything that is not from a case.
+		{54, []string{"0.out", "0.err"}},
+		{47, []string{"Main/compile.err", "0.out", "0.err"}},
+		// Then the stderr and the output of the case that failed.
+		{39, []string{"Main/compile.err", "0.out", "0.err", "1.err"}},
+		// The metadata is kept above everything else.
+		{32, []string{"Main/compile.err", "0.out", "0.err", "1.out", "1.err"}},
+	} {
+		var buf bytes.Buffer
+		truncated, err := writeZipFile(
+			&buf,
+			dirname,
+			[]string{"Main/compile.err", "0.out", "0.err", "0.meta", "1.out", "1.err", "1.meta"},
+			tc.maxSize,
+			func(file string) int {
+				return artifactPriority(file, caseVerdicts)
+			},
+		)
+		if err != nil {
+			t.Fatalf("Failed to write zip file: %v", err)
+		}
+		if !reflect.DeepEqual(truncated, tc.truncated) {
+			t.Errorf("maxSize %d: truncated == %q, want %q", tc.maxSize, truncated, tc.truncated)
+		}
+
+		z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("Failed to read zip file: %v", err)
+		}
+		for _, f := range z.File {
+			if path.Ext(f.Name) != ".meta" {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", f.Name, err)
+			}
+			contents, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", f.Name, err)
+			}
+			if string(contents) != files[f.Name] {
+				t.Errorf("maxSize %d: contents of %s == %q, want %q", tc.maxSize, f.Name, contents, files[f.Name])
+			}
+		}
+	}
+}
+
+func TestParseOutputOnlyFile(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+	ctx.Config.Runner.OutputOnlyMaxFiles = 3
+
+	settings := &common.ProblemSettings{
+		Cases: []common.GroupSettings{
+			{
+				Name: "0",
+				Cases: []common.CaseSettings{
+					{Name: "0", Weight: big.NewRat(1, 1)},
+					{Name: "1", Weight: big.NewRat(1, 1)},
+				},
+			},
+		},
+		Limits: common.LimitsSettings{
+			OutputLimit: base.Byte(4),
+		},
+	}
+	zipDataURL := func(files map[string]string) string {
+		var buf bytes.Buffer
+		z := zip.NewWriter(&buf)
+		for name, contents := range files {
+			w, err := z.Create(name)
+			if err != nil {
+				t.Fatalf("Failed to create %s: %v", name, err)
+			}
+			if _, err := w.Write([]byte(contents)); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+		if err := z.Close(); err != nil {
+			t.Fatalf("Failed to close zip: %v", err)
+		}
+		return "data:application/zip;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	tarGzDataURL := func(files map[string]string) string {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for name, contents := range files {
+			if err := tw.WriteHeader(&tar.Header{
+				Name:     name,
+				Mode:     0644,
+				Size:     int64(len(contents)),
+				Typeflag: tar.TypeReg,
+			}); err != nil {
+				t.Fatalf("Failed to create %s: %v", name, err)
+			}
+			if _, err := tw.Write([]byte(contents)); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Failed to close tarball: %v", err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatalf("Failed to close gzip stream: %v", err)
+		}
+		return "data:application/gzip;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	for _, tc := range []struct {
+		name          string
+		data          string
+		expectedError string
+		expected      map[string]outputOnlyFile
+	}{
+		{
+			name:     "plain",
+			data:     "1 2",
+			expected: map[string]outputOnlyFile{"Main.out": {"1 2", false}},
+		},
+		{
+			name: "valid",
+			data: zipDataURL(map[string]string{
+				"out/0.out": "3",
+				"1.out":     "12345",
+				"2.out":     "4",
+			}),
+			expected: map[string]outputOnlyFile{
+				"0.out": {"3", false},
+				"1.out": {"", true},
+			},
+		},
+		{
+			name: "tar.gz",
+			data: tarGzDataURL(map[string]string{
+				"out/0.out": "3",
+				"1.out":     "12345",
+			}),
+			expected: map[string]outputOnlyFile{
+				"0.out": {"3", false},
+				"1.out": {"", true},
+			},
+		},
+		{
+			name:          "tar.gz zip slip",
+			data:          tarGzDataURL(map[string]string{"../../0.out": "3"}),
+			expectedError: "invalid path",
+		},
+		{
+			name:          "corrupt tar.gz",
+			data:          "data:application/gzip;base64," + base64.StdEncoding.EncodeToString([]byte{0x1f, 0x8b, 0x08, 0x00}),
+			expectedError: "invalid output-only tar.gz file",
+		},
+		{
+			name:          "corrupt",
+			data:          "data:application/zip;base64," + base64.StdEncoding.EncodeToString([]byte("not a zip")),
+			expectedError: "invalid output-only zip file",
+		},
+		{
+			name:          "bad encoding",
+			data:          "data:application/zip;base64,!!!!",
+			expectedError: "invalid output-only file encoding",
+		},
+		{
+			name:          "zip slip",
+			data:          zipDataURL(map[string]string{"../0.out": "3"}),
+			expectedError: "invalid path",
+		},
+		{
+			name: "too many files",
+			data: zipDataURL(map[string]string{
+				"0.out": "1",
+				"1.out": "2",
+				"2.out": "3",
+				"3.out": "4",
+			}),
+			expectedError: "too many files",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parseOutputOnlyFile(ctx, 1, tc.data, settings, ctx.Config.Runner.RuntimePath)
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("parseOutputOnlyFile() error == %v, want %q", err, tc.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseOutputOnlyFile() failed: %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, result) {
+				t.Errorf("parseOutputOnlyFile() == %v, want %v", result, tc.expected)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(ctx.Config.Runner.RuntimePath)
+	if err != nil {
+		t.Fatalf("Failed to read runtime path: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("temporary files were left behind: %v", entries)
+	}
+
+	// Uploaded artifacts are referenced instead of being inlined.
+	const artifactID = "0123456789abcdef0123456789abcdef"
+	artifactSource := common.ArtifactSourcePrefix + artifactID
+	if _, err := parseOutputOnlyFile(ctx, 1, artifactSource, settings, ctx.Config.Runner.RuntimePath); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("parseOutputOnlyFile() with a missing artifact error == %v, want not found", err)
+	}
+	zipContents, err := base64.StdEncoding.DecodeString(
+		strings.SplitN(zipDataURL(map[string]string{"0.out": "3"}), ",", 2)[1],
+	)
+	if err != nil {
+		t.Fatalf("Failed to decode zip: %v", err)
+	}
+	artifactPath := OutputOnlyArtifactPath(ctx, 1, artifactID)
+	if err := os.MkdirAll(path.Dir(artifactPath), 0755); err != nil {
+		t.Fatalf("Failed to create artifact directory: %v", err)
+	}
+	if err := os.WriteFile(artifactPath, zipContents, 0644); err != nil {
+		t.Fatalf("Failed to write artifact: %v", err)
+	}
+	result, err := parseOutputOnlyFile(ctx, 1, artifactSource, settings, ctx.Config.Runner.RuntimePath)
+	if err != nil {
+		t.Fatalf("parseOutputOnlyFile() with an artifact failed: %v", err)
+	}
+	if expected := map[string]outputOnlyFile{"0.out": {"3", false}}; !reflect.DeepEqual(expected, result) {
+		t.Errorf("parseOutputOnlyFile() with an artifact == %v, want %v", result, expected)
+	}
+	// Other attempts of the same run get their own copy of the artifact.
+	if _, err := parseOutputOnlyFile(ctx, 2, artifactSource, settings, ctx.Config.Runner.RuntimePath); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("parseOutputOnlyFile() with another attempt's artifact error == %v, want not found", err)
+	}
+
+	// Once the overall limit has been exceeded, the rest of the files are
+	// generated empty.
+	ctx.Config.Runner.OverallOutputLimit = base.Byte(2)
+	result, err = parseOutputOnlyFile(
+		ctx,
+		1,
+		tarGzDataURL(map[string]string{"0.out": "123", "1.out": "456"}),
+		settings,
+		ctx.Config.Runner.RuntimePath,
+	)
+	if err != nil {
+		t.Fatalf("parseOutputOnlyFile() with a small overall limit failed: %v", err)
+	}
+	exceeded := 0
+	for _, file := range result {
+		if file.ole {
+			exceeded++
+		}
+	}
+	if len(result) != 2 || exceeded != 1 {
+		t.Errorf("parseOutputOnlyFile() with a small overall limit == %v, want exactly one file over the limit", result)
+	}
 }
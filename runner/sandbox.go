@@ -6,12 +6,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"text/template"
 
 	base "github.com/omegaup/go-base/v3"
 	"github.com/omegaup/quark/common"
@@ -73,20 +73,72 @@ func (preloader *inputPreloader) release() {
 
 // RunMetadata represents the results of an execution.
 type RunMetadata struct {
-	Verdict    string    `json:"verdict"`
-	ExitStatus int       `json:"exit_status,omitempty"`
-	Time       float64   `json:"time"`
-	SystemTime float64   `json:"sys_time"`
-	WallTime   float64   `json:"wall_time"`
-	Memory     base.Byte `json:"memory"`
-	OutputSize base.Byte `json:"output_size"`
-	Signal     *string   `json:"signal,omitempty"`
-	Syscall    *string   `json:"syscall,omitempty"`
+	Verdict    common.Verdict `json:"verdict"`
+	ExitStatus int            `json:"exit_status,omitempty"`
+	Time       float64        `json:"time"`
+	SystemTime float64        `json:"sys_time"`
+	WallTime   float64        `json:"wall_time"`
+	Memory     base.Byte      `json:"memory"`
+	OutputSize base.Byte      `json:"output_size"`
+	ErrorSize  base.Byte      `json:"error_size"`
+	Signal     *string        `json:"signal,omitempty"`
+	Syscall    *string        `json:"syscall,omitempty"`
+
+	// OOMKilled is set if the kernel killed the program for going over the
+	// memory limit of its cgroup.
+	OOMKilled bool `json:"oom_killed,omitempty"`
+
+	// Attempts contains the metadata of all the attempts of a case that was
+	// re-run because its time was too close to the time limit.
+	Attempts []RunMetadata `json:"attempts,omitempty"`
+
+	// ParentMeta contains the metadata of the problemsetter's binary of an
+	// interactive problem if it did not finish correctly.
+	ParentMeta *RunMetadata `json:"parent_meta,omitempty"`
+
+	// DeniedPermission is the permission that the Java security policy denied,
+	// if that is what caused the RFE verdict.
+	DeniedPermission *string `json:"denied_permission,omitempty"`
+
+	// Skipped is set to the reason why the case was not run at all. Skipped
+	// cases have the "SK" verdict.
+	Skipped string `json:"skipped,omitempty"`
+
+	// Cached is set if the binary was taken from the BinaryCache instead of
+	// being compiled.
+	Cached bool `json:"cached,omitempty"`
+
+	// OutputTruncated is set if the compiler wrote more than
+	// Runner.CompileErrorLimit to any of its outputs, so only the beginning
+	// and the end of it were kept.
+	OutputTruncated bool `json:"output_truncated,omitempty"`
+
+	// CompilerVersion is the version of the compiler or interpreter that
+	// compiled the binary, if the sandbox can tell it.
+	CompilerVersion string `json:"compiler_version,omitempty"`
 }
 
+const (
+	// SkippedWallTimeLimit is the reason for cases that were not run because
+	// the overall wall time limit of the run had already been exhausted.
+	SkippedWallTimeLimit = "wall_time_limit"
+
+	// SkippedOverallOutputLimit is the reason for cases that were not run
+	// because the overall output limit of the run had already been exceeded.
+	SkippedOverallOutputLimit = "overall_output_limit"
+
+	// SkippedFailFast is the reason for cases that were not run because an
+	// earlier case of their FailFast group did not run successfully.
+	SkippedFailFast = "fail_fast"
+
+	// SkippedDependency is the reason for cases that were not run because one
+	// of the groups that their group depends on did not run successfully.
+	SkippedDependency = "dependency"
+)
+
 func (m *RunMetadata) String() string {
 	metadata := fmt.Sprintf(
-		"{Verdict: %s, ExitStatus: %d, Time: %.3fs, SystemTime: %.3fs, WallTime: %.3fs, Memory: %.3fMiB, OutputSize: %.3fMiB",
+		"{Verdict: %s, ExitStatus: %d, Time: %.3fs, SystemTime: %.3fs, WallTime: %.3fs, Memory: %.3fMiB, OutputSize: %.3fMiB, ErrorSize: %.3fMiB",
 		m.Verdict,
 		m.ExitStatus,
 		m.Time,
@@ -94,6 +146,7 @@ func (m *RunMetadata) String() string {
 		m.WallTime,
 		m.Memory.Mebibytes(),
 		m.OutputSize.Mebibytes(),
+		m.ErrorSize.Mebibytes(),
 	)
 	if m.Signal != nil {
 		metadata += fmt.Sprintf(", Signal: %s", *m.Signal)
@@ -132,18 +185,252 @@ type Sandbox interface {
 	) (*RunMetadata, error)
 }
 
+// A NetworkSandbox is a Sandbox that can give the programs it runs a network
+// policy other than common.NetworkPolicyNone.
+type NetworkSandbox interface {
+	Sandbox
+
+	// WithNetworkPolicy returns a Sandbox that runs the programs with the
+	// provided network policy.
+	WithNetworkPolicy(policy common.NetworkPolicy) (Sandbox, error)
+}
+
+// A CompilerVersionSandbox is a Sandbox that can tell the version of the
+// compiler or interpreter that it uses for a language.
+type CompilerVersionSandbox interface {
+	Sandbox
+
+	// CompilerVersion returns the version that the compiler of the language
+	// prints.
+	CompilerVersion(ctx *common.Context, lang string) (string, error)
+}
+
+// defaultSandboxLanguages are the commands that compile and run each language
+// in the sandboxes that do not know about languages (isolate and nsjail),
+// unless the configuration overrides them.
+var defaultSandboxLanguages = map[string]common.SandboxLanguageConfig{
+	"c": {
+		Compile: []string{"/usr/bin/gcc", "-std=gnu11", "-O2", "-o", "{target}", "{sources}", "-lm", "{flags}"},
+		Run:     []string{"./{target}", "{flags}"},
+	},
+	"c11-gcc": {
+		Compile: []string{"/usr/bin/gcc", "-std=gnu11", "-O2", "-o", "{target}", "{sources}", "-lm", "{flags}"},
+		Run:     []string{"./{target}", "{flags}"},
+	},
+	"cpp": {
+		Compile: []string{"/usr/bin/g++", "-std=gnu++03", "-O2", "-o", "{target}", "{sources}", "-lm", "{flags}"},
+		Run:     []string{"./{target}", "{flags}"},
+	},
+	"cpp11": {
+		Compile: []string{"/usr/bin/g++", "-std=gnu++11", "-O2", "-o", "{target}", "{sources}", "-lm", "{flags}"},
+		Run:     []string{"./{target}", "{flags}"},
+	},
+	"cpp11-gcc": {
+		Compile: []string{"/usr/bin/g++", "-std=gnu++11", "-O2", "-o", "{target}", "{sources}", "-lm", "{flags}"},
+		Run:     []string{"./{target}", "{flags}"},
+	},
+	"cpp17-gcc": {
+		Compile: []string{"/usr/bin/g++", "-std=gnu++17", "-O2", "-o", "{target}", "{sources}", "-lm", "{flags}"},
+		Run:     []string{"./{target}", "{flags}"},
+	},
+	"cpp20-gcc": {
+		Compile: []string{"/usr/bin/g++", "-std=gnu++20", "-O2", "-o", "{target}", "{sources}", "-lm", "{flags}"},
+		Run:     []string{"./{target}", "{flags}"},
+	},
+	"py3": {
+		Compile: []string{"/usr/bin/python3", "-m", "py_compile", "{sources}"},
+		Run:     []string{"/usr/bin/python3", "{target}.py", "{flags}"},
+	},
+	"py3-pypy": {
+		Compile: []string{"/usr/bin/pypy3", "-m", "py_compile", "{sources}"},
+		Run:     []string{"/usr/bin/pypy3", "{target}.py", "{flags}"},
+	},
+	"java": {
+		Compile: []string{"/usr/bin/javac", "-J-Xmx512M", "{sources}"},
+		Run:     []string{"/usr/bin/java", "-Xss64M", "{target}", "{flags}"},
+		// The JVM needs several threads even for single-threaded programs.
+		Processes: 64,
+	},
+	"go": {
+		// The binaries are static so that they do not need anything else from
+		// the sandbox to run.
+		Compile: []string{"/usr/bin/env", "CGO_ENABLED=0", "GOCACHE=/tmp/go-build", "/usr/bin/go", "build", "-o", "{target}", "{sources}"},
+		Run:     []string{"./{target}", "{flags}"},
+		// The Go runtime starts several threads even for single-threaded
+		// programs.
+		Processes: 64,
+	},
+	"js": {
+		Compile: []string{"/usr/bin/node", "--check", "{sources}"},
+		Run:     []string{"/usr/bin/node", "--max-old-space-size={heap}", "{target}.js", "{flags}"},
+		// Node starts several threads even for single-threaded programs.
+		Processes: 64,
+	},
+	"kt": {
+		// The jar includes the Kotlin runtime, so it runs on the JVM like a
+		// Java program.
+		Compile: []string{"/usr/bin/kotlinc", "-include-runtime", "-d", "{target}.jar", "{sources}"},
+		Run:     []string{"/usr/bin/java", "-Xss64M", "-jar", "{target}.jar", "{flags}"},
+		// The JVM needs several threads even for single-threaded programs.
+		Processes: 64,
+	},
+	"cs": {
+		// omegajail uses the .NET SDK, but it needs a runtime configuration
+		// next to each program. Mono can compile and run a single file on its
+		// own.
+		Compile: []string{"/usr/bin/mcs", "-optimize+", "-out:{target}.exe", "{sources}", "{flags}"},
+		Run:     []string{"/usr/bin/mono", "{target}.exe", "{flags}"},
+		// The runtime starts several threads even for single-threaded programs.
+		Processes: 64,
+	},
+}
+
+// signalNames are the names of the signals that parseMetaFile knows about.
+var signalNames = map[int]string{
+	int(syscall.SIGILL):  "SIGILL",
+	int(syscall.SIGABRT): "SIGABRT",
+	int(syscall.SIGBUS):  "SIGBUS",
+	int(syscall.SIGFPE):  "SIGFPE",
+	int(syscall.SIGKILL): "SIGKILL",
+	int(syscall.SIGSEGV): "SIGSEGV",
+	int(syscall.SIGPIPE): "SIGPIPE",
+	int(syscall.SIGALRM): "SIGALRM",
+	int(syscall.SIGXCPU): "SIGXCPU",
+	int(syscall.SIGXFSZ): "SIGXFSZ",
+	int(syscall.SIGSYS):  "SIGSYS",
+}
+
+// sandboxLanguage returns the commands that compile and run the language,
+// from the configuration of the sandbox or the default ones. The compiler and
+// its flags in Runner.Languages are applied to the compile command.
+func sandboxLanguage(
+	ctx *common.Context,
+	languages map[string]common.SandboxLanguageConfig,
+	lang, sandboxName string,
+) (*common.SandboxLanguageConfig, error) {
+	language, ok := languages[lang]
+	if !ok {
+		language, ok = defaultSandboxLanguages[lang]
+	}
+	if !ok {
+		return nil, errors.Errorf("language %q is not supported by the %s sandbox", lang, sandboxName)
+	}
+	config := ctx.Config.Runner.Language(lang)
+	if len(language.Compile) > 0 && (config.Compiler != "" || len(config.CompilerFlags) > 0) {
+		compiler := language.Compile[0]
+		if config.Compiler != "" {
+			compiler = config.Compiler
+		}
+		compile := append([]string{compiler}, config.CompilerFlags...)
+		language.Compile = append(compile, language.Compile[1:]...)
+	}
+	return &language, nil
+}
+
+// isJVMLanguage returns whether the programs in the language run on the JVM,
+// so they get the same allowances and security policy as Java programs.
+func isJVMLanguage(lang string) bool {
+	return lang == "java" || lang == "kt"
+}
+
+// expandSandboxCommand replaces the placeholders in the command of a
+// language.
+func expandSandboxCommand(command []string, target string, sources, flags []string) []string {
+	var result []string
+	for _, arg := range command {
+		switch arg {
+		case "{sources}":
+			result = append(result, sources...)
+		case "{flags}":
+			result = append(result, flags...)
+		default:
+			result = append(result, strings.ReplaceAll(arg, "{target}", target))
+		}
+	}
+	return result
+}
+
+// sandboxRunCommand returns the command that runs a program in the language,
+// with all its placeholders replaced.
+func sandboxRunCommand(
+	ctx *common.Context,
+	language *common.SandboxLanguageConfig,
+	lang, target string,
+	limits *common.LimitsSettings,
+	extraParams []string,
+) []string {
+	heapSize := managedHeapSize(ctx.Config.Runner.SandboxLimits(lang, limits).MemoryLimit)
+	var result []string
+	for _, arg := range expandSandboxCommand(language.Run, target, nil, extraParams) {
+		if strings.Contains(arg, "{heap}") {
+			if heapSize <= 0 {
+				continue
+			}
+			arg = strings.ReplaceAll(arg, "{heap}", strconv.FormatInt(heapSize, 10))
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// managedHeapOverhead is the memory that a managed runtime like V8 needs
+// besides its heap, for its code, stacks and buffers.
+const managedHeapOverhead = base.Byte(64) * base.Mebibyte
+
+// managedHeapSize returns the size in MiB that the heap of a managed runtime
+// can have so that the whole program stays within memoryLimit. Programs get at
+// least half of the limit for their heap. It returns 0 if there is no memory
+// limit.
+func managedHeapSize(memoryLimit base.Byte) int64 {
+	if memoryLimit <= 0 {
+		return 0
+	}
+	heapSize := memoryLimit - managedHeapOverhead
+	if heapSize < memoryLimit/2 {
+		heapSize = memoryLimit / 2
+	}
+	return heapSize.Bytes() / base.Mebibyte.Bytes()
+}
+
+// reservesAddressSpace returns whether the runtime of the language reserves
+// much more address space than it uses, so that the memory of its programs
+// can only be limited with control groups.
+func reservesAddressSpace(lang string) bool {
+	return isJVMLanguage(lang) || lang == "cs" || lang == "js"
+}
+
 // OmegajailSandbox is an implementation of a Sandbox that uses the omegajail
 // sandbox.
 type OmegajailSandbox struct {
 	omegajailRoot string
 
+	// networkPolicy is the network policy of the programs. All programs run in
+	// their own network namespace, which only has a loopback interface that is
+	// brought up with common.NetworkPolicyLoopback.
+	networkPolicy common.NetworkPolicy
+
 	// AllowSigsysFallback allows omegajail to use the previous implementation of
-	// the sigsys detector if it's running on an older pre-5.13 kernel.
+	// the sigsys detector if it's running on an older pre-5.13 kernel. It is
+	// passed as --allow-sigsys-fallback.
 	AllowSigsysFallback bool
 
+	// DisableSandboxing is passed to omegajail as --disable-sandboxing, for
+	// environments (like CI inside Docker) where it cannot set up its
+	// sandbox.
 	DisableSandboxing bool
+
+	// ExtraFlags are passed to omegajail after the flags above and before any
+	// other parameter.
+	ExtraFlags []string
+
+	// CgroupRoot is the cgroup v2 directory under which a cgroup is created
+	// for each execution, to take its CPU time, peak memory and OOM kills
+	// from the kernel's accounting instead of omegajail's. Empty disables it.
+	CgroupRoot string
 }
 
+var _ NetworkSandbox = &OmegajailSandbox{}
+
 // NewOmegajailSandbox creates a new OmegajailSandbox.
 func NewOmegajailSandbox(omegajailRoot string) *OmegajailSandbox {
 	return &OmegajailSandbox{
@@ -198,14 +485,14 @@ func (o *OmegajailSandbox) Compile(
 	for _, inputFile := range inputFiles {
 		if !strings.HasPrefix(inputFile, chdir) {
 			return &RunMetadata{
-				Verdict:    "JE",
+				Verdict:    common.VerdictJudgeError,
 				ExitStatus: -1,
 			}, errors.Errorf("file %q is not within the chroot", inputFile)
 		}
 		rel, err := filepath.Rel(chdir, inputFile)
 		if err != nil {
 			return &RunMetadata{
-				Verdict:    "JE",
+				Verdict:    common.VerdictJudgeError,
 				ExitStatus: -1,
 			}, err
 		}
@@ -219,34 +506,20 @@ func (o *OmegajailSandbox) Compile(
 		params = append(params, extraFlags...)
 	}
 
-	o.invokeOmegajail(ctx, params, errorFile)
+	o.invokeOmegajail(ctx, params, nil, errorFile, metaFile)
 	metaFd, err := os.Open(metaFile)
 	if err != nil {
 		return &RunMetadata{
-			Verdict:    "JE",
+			Verdict:    common.VerdictJudgeError,
 			ExitStatus: -1,
 		}, err
 	}
 	defer metaFd.Close()
 	metadata, err := parseMetaFile(ctx, nil, lang, metaFd, &outputFile, nil, false)
 
-	if lang == "java" && metadata.Verdict == "OK" {
-		classPath := path.Join(chdir, fmt.Sprintf("%s.class", target))
-		if _, err := os.Stat(classPath); os.IsNotExist(err) {
-			compileError := fmt.Sprintf(
-				"Class `%s` not found. Make sure your class is named `%s` "+
-					"and outside all packages",
-				target,
-				target,
-			)
-			metadata.Verdict = "CE"
-			f, err := os.OpenFile(errorFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-			if err != nil {
-				return metadata, err
-			}
-			defer f.Close()
-			f.WriteString("\n")
-			f.WriteString(compileError)
+	if lang == "java" && metadata.Verdict == common.VerdictOK {
+		if err := checkJavaClass(chdir, target, errorFile, metadata); err != nil {
+			return metadata, err
 		}
 	}
 
@@ -263,60 +536,30 @@ func (o *OmegajailSandbox) Run(
 	extraParams []string,
 	extraMountPoints map[string]string,
 ) (*RunMetadata, error) {
-	timeLimit := limits.TimeLimit
-	if lang == "java" {
-		timeLimit += 1000
-	}
+	sandboxLimits := ctx.Config.Runner.SandboxLimits(lang, limits)
 
 	// Avoid using the real /dev/null. Pass in an empty file instead.
 	if inputFile == "/dev/null" {
 		inputFile = path.Join(o.omegajailRoot, "root/dev/null")
 	}
 
-	type fileLink struct {
-		sourceFile, targetFile string
-	}
-	fileLinks := []fileLink{}
-	if originalInputFile != nil {
-		fileLinks = append(fileLinks, fileLink{
-			sourceFile: *originalInputFile,
-			targetFile: path.Join(chdir, "data.in"),
-		})
-	}
-	if originalOutputFile != nil && *originalOutputFile != "/dev/null" {
-		fileLinks = append(fileLinks, fileLink{
-			sourceFile: *originalOutputFile,
-			targetFile: path.Join(chdir, "data.out"),
-		})
-	}
-	if runMetaFile != nil {
-		fileLinks = append(fileLinks, fileLink{
-			sourceFile: *runMetaFile,
-			targetFile: path.Join(chdir, "meta.in"),
-		})
-	}
-	for _, fl := range fileLinks {
-		if _, err := os.Stat(fl.targetFile); err == nil {
-			os.Remove(fl.targetFile)
-		}
-		if err := copyFile(fl.sourceFile, fl.targetFile); err != nil {
-			return &RunMetadata{
-				Verdict:    "JE",
-				ExitStatus: -1,
-			}, err
-		}
+	if err := copyRunFiles(chdir, originalInputFile, originalOutputFile, runMetaFile); err != nil {
+		return &RunMetadata{
+			Verdict:    common.VerdictJudgeError,
+			ExitStatus: -1,
+		}, err
 	}
 
 	// Create intermediate directories, if needed.
 	if err := os.MkdirAll(path.Dir(outputFile), 0o755); err != nil {
 		return &RunMetadata{
-			Verdict:    "JE",
+			Verdict:    common.VerdictJudgeError,
 			ExitStatus: -1,
 		}, err
 	}
 
 	// "640MB should be enough for anybody"
-	hardLimit := base.Min(ctx.Config.Runner.HardMemoryLimit, limits.MemoryLimit)
+	hardLimit := sandboxLimits.MemoryLimit
 
 	params := []string{
 		"--homedir", chdir,
@@ -325,9 +568,9 @@ func (o *OmegajailSandbox) Run(
 		"-2", errorFile,
 		"-M", metaFile,
 		"-m", strconv.FormatInt(hardLimit.Bytes(), 10),
-		"-t", strconv.FormatInt(int64(timeLimit.Milliseconds()), 10),
-		"-w", strconv.FormatInt(int64(limits.ExtraWallTime.Milliseconds()), 10),
-		"-O", strconv.FormatInt(limits.OutputLimit.Bytes(), 10),
+		"-t", strconv.FormatInt(int64(sandboxLimits.TimeLimit.Milliseconds()), 10),
+		"-w", strconv.FormatInt(int64(sandboxLimits.ExtraWallTime.Milliseconds()), 10),
+		"-O", strconv.FormatInt(sandboxFileSizeLimit(limits.OutputLimit).Bytes(), 10),
 		"--root", o.omegajailRoot,
 		"--run", lang,
 		"--run-target", target,
@@ -338,11 +581,30 @@ func (o *OmegajailSandbox) Run(
 			"--bind", fmt.Sprintf("%s:%s", path, mountTarget),
 		)
 	}
+	if o.networkPolicy == common.NetworkPolicyLoopback {
+		params = append(params, "--allow-loopback")
+	}
 	if len(extraParams) > 0 {
 		params = append(params, "--")
 		params = append(params, extraParams...)
 	}
 
+	var env []string
+	if isJVMLanguage(lang) && ctx.Config.Runner.JavaPolicyTemplate != "" {
+		if err := writeJavaPolicy(ctx.Config.Runner.JavaPolicyTemplate, chdir); err != nil {
+			return &RunMetadata{
+				Verdict:    common.VerdictJudgeError,
+				ExitStatus: -1,
+			}, err
+		}
+		// The policy path is relative to the working directory of the program,
+		// which is the run directory.
+		env = append(
+			env,
+			"JAVA_TOOL_OPTIONS=-Djava.security.manager -Djava.security.policy==java.policy",
+		)
+	}
+
 	preloader, err := newInputPreloader(inputFile)
 	if err != nil {
 		ctx.Log.Error(
@@ -357,11 +619,11 @@ func (o *OmegajailSandbox) Run(
 		preloader.release()
 	}
 
-	o.invokeOmegajail(ctx, params, errorFile)
+	o.invokeOmegajail(ctx, params, env, errorFile, metaFile)
 	metaFd, err := os.Open(metaFile)
 	if err != nil {
 		return &RunMetadata{
-			Verdict:    "JE",
+			Verdict:    common.VerdictJudgeError,
 			ExitStatus: -1,
 		}, err
 	}
@@ -369,7 +631,102 @@ func (o *OmegajailSandbox) Run(
 	return parseMetaFile(ctx, limits, lang, metaFd, &outputFile, &errorFile, lang == "c")
 }
 
-func (o *OmegajailSandbox) invokeOmegajail(ctx *common.Context, omegajailParams []string, errorFile string) {
+// WithNetworkPolicy returns a copy of the OmegajailSandbox that runs the
+// programs with the provided network policy.
+func (o *OmegajailSandbox) WithNetworkPolicy(policy common.NetworkPolicy) (Sandbox, error) {
+	switch policy {
+	case common.NetworkPolicyDefault, common.NetworkPolicyNone, common.NetworkPolicyLoopback:
+	default:
+		return nil, fmt.Errorf("unsupported network policy %q", policy)
+	}
+	sandbox := *o
+	sandbox.networkPolicy = policy
+	return &sandbox, nil
+}
+
+// copyRunFiles copies the original input and output of the case, and the
+// metadata of the contestant's run, into the directory of a program that
+// needs them (e.g. a validator).
+func copyRunFiles(chdir string, originalInputFile, originalOutputFile, runMetaFile *string) error {
+	type fileLink struct {
+		sourceFile, targetFile string
+	}
+	fileLinks := []fileLink{}
+	if originalInputFile != nil {
+		fileLinks = append(fileLinks, fileLink{
+			sourceFile: *originalInputFile,
+			targetFile: path.Join(chdir, "data.in"),
+		})
+	}
+	if originalOutputFile != nil && *originalOutputFile != "/dev/null" {
+		fileLinks = append(fileLinks, fileLink{
+			sourceFile: *originalOutputFile,
+			targetFile: path.Join(chdir, "data.out"),
+		})
+	}
+	if runMetaFile != nil {
+		fileLinks = append(fileLinks, fileLink{
+			sourceFile: *runMetaFile,
+			targetFile: path.Join(chdir, "meta.in"),
+		})
+	}
+	for _, fl := range fileLinks {
+		if _, err := os.Stat(fl.targetFile); err == nil {
+			os.Remove(fl.targetFile)
+		}
+		if err := copyFile(fl.sourceFile, fl.targetFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkJavaClass marks a successful Java compilation as a compile error if it
+// did not produce the class of the target, which happens when the class has a
+// different name or is inside a package.
+func checkJavaClass(chdir, target, errorFile string, metadata *RunMetadata) error {
+	classPath := path.Join(chdir, fmt.Sprintf("%s.class", target))
+	if _, err := os.Stat(classPath); os.IsNotExist(err) {
+		compileError := fmt.Sprintf(
+			"Class `%s` not found. Make sure your class is named `%s` "+
+				"and outside all packages",
+			target,
+			target,
+		)
+		metadata.Verdict = common.VerdictCompileError
+		f, err := os.OpenFile(errorFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		f.WriteString("\n")
+		f.WriteString(compileError)
+	}
+	return nil
+}
+
+// writeJavaPolicy writes the Java security policy for a run into the run
+// directory, interpolating the run directory into the template.
+func writeJavaPolicy(templatePath, runRoot string) error {
+	policyTemplate, err := template.ParseFiles(templatePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse the Java policy template")
+	}
+	f, err := os.Create(path.Join(runRoot, "java.policy"))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the Java policy")
+	}
+	err = policyTemplate.Execute(f, struct{ RunRoot string }{RunRoot: runRoot})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write the Java policy")
+	}
+	return nil
+}
+
+func (o *OmegajailSandbox) invokeOmegajail(ctx *common.Context, omegajailParams, env []string, errorFile, metaFile string) {
 	omegajailFullParams := []string{path.Join(o.omegajailRoot, "bin/omegajail")}
 	if o.AllowSigsysFallback {
 		omegajailFullParams = append(omegajailFullParams, "--allow-sigsys-fallback")
@@ -377,14 +734,44 @@ func (o *OmegajailSandbox) invokeOmegajail(ctx *common.Context, omegajailParams
 	if o.DisableSandboxing {
 		omegajailFullParams = append(omegajailFullParams, "--disable-sandboxing")
 	}
+	omegajailFullParams = append(omegajailFullParams, o.ExtraFlags...)
 	omegajailFullParams = append(omegajailFullParams, omegajailParams...)
+	var cgroup *executionCgroup
+	if o.CgroupRoot != "" {
+		var err error
+		cgroup, err = newExecutionCgroup(o.CgroupRoot)
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to create cgroup, using the omegajail measurements",
+				map[string]any{
+					"err": err,
+				},
+			)
+		} else {
+			defer func() {
+				if err := cgroup.remove(); err != nil {
+					ctx.Log.Error(
+						"Failed to remove cgroup",
+						map[string]any{
+							"cgroup": cgroup.path,
+							"err":    err,
+						},
+					)
+				}
+			}()
+			omegajailFullParams = cgroup.command(omegajailFullParams)
+		}
+	}
 	ctx.Log.Debug(
 		"invoking",
 		map[string]any{
 			"params": shellquote.Join(omegajailFullParams...),
 		},
 	)
-	cmd := exec.Command(omegajailFullParams[0], omegajailParams...)
+	cmd := sandboxCommand(ctx, omegajailFullParams[0], omegajailFullParams[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	omegajailErrorFile := errorFile + ".omegajail"
 	omegajailErrorFd, err := os.Create(omegajailErrorFile)
 	if err != nil {
@@ -406,6 +793,21 @@ func (o *OmegajailSandbox) invokeOmegajail(ctx *common.Context, omegajailParams
 			},
 		)
 	}
+	if cgroup != nil {
+		stats, err := cgroup.stats()
+		if err == nil {
+			err = stats.appendToMetaFile(metaFile)
+		}
+		if err != nil {
+			ctx.Log.Error(
+				"Failed to record cgroup stats",
+				map[string]any{
+					"cgroup": cgroup.path,
+					"err":    err,
+				},
+			)
+		}
+	}
 	if omegajailErrorFd != nil {
 		omegajailErrorFd.Close()
 		if err := appendFile(errorFile, omegajailErrorFile); err != nil {
@@ -452,7 +854,7 @@ func parseMetaFile(
 	allowNonZeroExitCode bool,
 ) (*RunMetadata, error) {
 	meta := &RunMetadata{
-		Verdict:    "JE",
+		Verdict:    common.VerdictJudgeError,
 		ExitStatus: -1,
 	}
 	scanner := bufio.NewScanner(metaFile)
@@ -486,6 +888,8 @@ func parseMetaFile(
 		case "syscall_number":
 			stringSyscall := fmt.Sprintf("SYSCALL %s", tokens[1])
 			meta.Syscall = &stringSyscall
+		case "oom_killed":
+			meta.OOMKilled = tokens[1] == "1"
 		default:
 			ctx.Log.Warn(
 				"Unknown field in .meta file",
@@ -502,13 +906,13 @@ func parseMetaFile(
 	if meta.Signal != nil {
 		switch *meta.Signal {
 		case "SIGSYS":
-			meta.Verdict = "RFE"
+			meta.Verdict = common.VerdictRestrictedFunction
 		case "SIGILL", "SIGABRT", "SIGFPE", "SIGKILL", "SIGPIPE", "SIGBUS", "SIGSEGV":
-			meta.Verdict = "RTE"
+			meta.Verdict = common.VerdictRuntimeError
 		case "SIGALRM", "SIGXCPU":
-			meta.Verdict = "TLE"
+			meta.Verdict = common.VerdictTimeLimitExceeded
 		case "SIGXFSZ":
-			meta.Verdict = "OLE"
+			meta.Verdict = common.VerdictOutputLimitExceeded
 		default:
 			ctx.Log.Error(
 				"Received odd signal",
@@ -516,26 +920,32 @@ func parseMetaFile(
 					"signal": *meta.Signal,
 				},
 			)
-			meta.Verdict = "RTE"
+			meta.Verdict = common.VerdictRuntimeError
 		}
 	} else if meta.ExitStatus == 0 || allowNonZeroExitCode {
-		meta.Verdict = "OK"
+		meta.Verdict = common.VerdictOK
 	} else {
-		meta.Verdict = "RTE"
+		meta.Verdict = common.VerdictRuntimeError
 	}
 
 	if lang == "kj" || lang == "kp" {
 		// Karel programs have a unique exit status per each one of the failure
 		// modes. Map 1 (INSTRUCTION) to TLE.
 		if meta.ExitStatus == 1 {
-			meta.Verdict = "TLE"
+			meta.Verdict = common.VerdictTimeLimitExceeded
+		}
+	}
+	if isJVMLanguage(lang) && meta.ExitStatus != 0 && ctx.Config.Runner.JavaPolicyTemplate != "" {
+		if permission := javaDeniedPermission(ctx, errorFilePath); permission != nil {
+			meta.Verdict = common.VerdictRestrictedFunction
+			meta.DeniedPermission = permission
 		}
 	}
 	if limits != nil &&
 		limits.MemoryLimit > 0 &&
-		(meta.Memory > limits.MemoryLimit ||
-			lang == "java" && meta.ExitStatus != 0 && isJavaMLE(ctx, errorFilePath)) {
-		meta.Verdict = "MLE"
+		(meta.Memory > limits.MemoryLimit || meta.OOMKilled ||
+			isJVMLanguage(lang) && meta.ExitStatus != 0 && isJavaMLE(ctx, errorFilePath)) {
+		meta.Verdict = common.VerdictMemoryLimitExceeded
 		meta.Memory = limits.MemoryLimit
 	}
 
@@ -545,10 +955,36 @@ func parseMetaFile(
 			meta.OutputSize = base.Byte(outputFileStat.Size())
 		}
 	}
+	if errorFilePath != nil {
+		errorFileStat, err := os.Stat(*errorFilePath)
+		if err == nil {
+			meta.ErrorSize = base.Byte(errorFileStat.Size())
+		}
+	}
+	// Programs that ignore SIGXFSZ keep running after their writes start
+	// failing, and they either finish normally or crash because of it, so the
+	// size of the output is what tells that the limit was exceeded.
+	if limits != nil &&
+		limits.OutputLimit > 0 &&
+		meta.OutputSize > limits.OutputLimit &&
+		(meta.Verdict == common.VerdictOK || meta.Verdict == common.VerdictRuntimeError) {
+		meta.Verdict = common.VerdictOutputLimitExceeded
+	}
 
 	return meta, nil
 }
 
+// sandboxFileSizeLimit returns the file size limit that the sandboxes enforce
+// for the provided output limit. One byte more than the limit is allowed, so
+// that parseMetaFile can tell outputs that exceed the limit apart from the
+// ones that reach it exactly, even if the program does not die of SIGXFSZ.
+func sandboxFileSizeLimit(outputLimit base.Byte) base.Byte {
+	if outputLimit <= 0 {
+		return outputLimit
+	}
+	return outputLimit + 1
+}
+
 func isJavaMLE(ctx *common.Context, errorFilePath *string) bool {
 	if errorFilePath == nil {
 		return false
@@ -579,3 +1015,38 @@ func isJavaMLE(ctx *common.Context, errorFilePath *string) bool {
 
 	return false
 }
+
+// javaDeniedPermission returns the permission that was denied by the Java
+// security policy, as reported by the AccessControlException in stderr.
+func javaDeniedPermission(ctx *common.Context, errorFilePath *string) *string {
+	if errorFilePath == nil {
+		return nil
+	}
+
+	f, err := os.Open(*errorFilePath)
+	if err != nil {
+		ctx.Log.Error(
+			"Failed to open stderr",
+			map[string]any{
+				"err": err,
+			},
+		)
+		return nil
+	}
+	defer f.Close()
+
+	const marker = "java.security.AccessControlException: access denied "
+	r := bufio.NewReaderSize(f, 4096)
+	for {
+		line, _, err := r.ReadLine()
+		if err != nil {
+			break
+		}
+		if idx := strings.Index(string(line), marker); idx != -1 {
+			permission := strings.TrimSpace(string(line[idx+len(marker):]))
+			return &permission
+		}
+	}
+
+	return nil
+}
@@ -3,8 +3,11 @@ package runner
 import (
 	"bytes"
 	"os"
+	"path"
+	"reflect"
 	"testing"
 
+	base "github.com/omegaup/go-base/v3"
 	"github.com/omegaup/quark/common"
 )
 
@@ -130,6 +133,22 @@ func TestParseMetaFile(t *testing.T) {
 				return meta.Verdict == "MLE" && meta.Memory == 1000
 			},
 		},
+		{
+			"status:0\nsignal:SIGKILL\nmem:500\noom_killed:1",
+			"c",
+			&common.LimitsSettings{MemoryLimit: 1000},
+			func(meta *RunMetadata) bool {
+				return meta.Verdict == "MLE" && meta.OOMKilled && meta.Memory == 1000
+			},
+		},
+		{
+			"status:0\ntime:2000000\nmem:4000\ntime:1000\nmem:500",
+			"c",
+			&common.LimitsSettings{MemoryLimit: 1000},
+			func(meta *RunMetadata) bool {
+				return meta.Verdict == "OK" && meta.Time == 0.001 && meta.Memory == 500
+			},
+		},
 	}
 	for _, te := range test {
 		meta, err := parseMetaFile(
@@ -150,3 +169,263 @@ func TestParseMetaFile(t *testing.T) {
 		}
 	}
 }
+
+func TestParseMetaFileOutputSizes(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	dir := t.TempDir()
+	outputFile := path.Join(dir, "0.out")
+	errorFile := path.Join(dir, "0.err")
+	if err := os.WriteFile(outputFile, []byte("3\n"), 0644); err != nil {
+		t.Fatalf("Failed to write output file: %v", err)
+	}
+	if err := os.WriteFile(errorFile, []byte("debugging\n"), 0644); err != nil {
+		t.Fatalf("Failed to write error file: %v", err)
+	}
+
+	meta, err := parseMetaFile(
+		ctx,
+		nil,
+		"cpp",
+		bytes.NewBufferString("status:0"),
+		&outputFile,
+		&errorFile,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("Parsing meta file failed: %q", err)
+	}
+	if meta.OutputSize != 2 {
+		t.Errorf("meta.OutputSize == %d, want %d", meta.OutputSize, 2)
+	}
+	if meta.ErrorSize != 10 {
+		t.Errorf("meta.ErrorSize == %d, want %d", meta.ErrorSize, 10)
+	}
+}
+
+func TestParseMetaFileOutputLimit(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	outputFile := path.Join(t.TempDir(), "0.out")
+	for _, tc := range []struct {
+		output, contents string
+		verdict          common.Verdict
+	}{
+		// Reaching the limit exactly is fine.
+		{"1234", "status:0", "OK"},
+		{"1234", "status:1", "RTE"},
+		// Going past it is an OLE, whether the program ended normally or it
+		// crashed because its writes failed.
+		{"12345", "status:0", "OLE"},
+		{"12345", "status:1", "OLE"},
+		{"12345", "status:0\nsignal:SIGXFSZ", "OLE"},
+		// Other verdicts are kept.
+		{"12345", "status:0\nsignal:SIGXCPU", "TLE"},
+	} {
+		if err := os.WriteFile(outputFile, []byte(tc.output), 0644); err != nil {
+			t.Fatalf("Failed to write output file: %v", err)
+		}
+		meta, err := parseMetaFile(
+			ctx,
+			&common.LimitsSettings{OutputLimit: 4},
+			"cpp",
+			bytes.NewBufferString(tc.contents),
+			&outputFile,
+			nil,
+			false,
+		)
+		if err != nil {
+			t.Fatalf("Parsing meta file failed: %q", err)
+		}
+		if meta.Verdict != tc.verdict {
+			t.Errorf("%q with output %q: meta.Verdict == %q, want %q", tc.contents, tc.output, meta.Verdict, tc.verdict)
+		}
+	}
+}
+
+func TestSandboxFileSizeLimit(t *testing.T) {
+	for _, tc := range []struct {
+		outputLimit, fileSizeLimit base.Byte
+	}{
+		{0, 0},
+		{-1, -1},
+		{1024, 1025},
+	} {
+		if limit := sandboxFileSizeLimit(tc.outputLimit); limit != tc.fileSizeLimit {
+			t.Errorf("sandboxFileSizeLimit(%d) == %d, want %d", tc.outputLimit, limit, tc.fileSizeLimit)
+		}
+	}
+}
+
+func TestOmegajailWithNetworkPolicy(t *testing.T) {
+	omegajail := getSandbox()
+
+	sandbox, err := omegajail.WithNetworkPolicy(common.NetworkPolicyLoopback)
+	if err != nil {
+		t.Fatalf("WithNetworkPolicy(loopback) failed: %v", err)
+	}
+	if got := sandbox.(*OmegajailSandbox).networkPolicy; got != common.NetworkPolicyLoopback {
+		t.Errorf("networkPolicy == %q, want %q", got, common.NetworkPolicyLoopback)
+	}
+	if omegajail.networkPolicy != common.NetworkPolicyDefault {
+		t.Errorf("original networkPolicy == %q, want %q", omegajail.networkPolicy, common.NetworkPolicyDefault)
+	}
+
+	if _, err := omegajail.WithNetworkPolicy(common.NetworkPolicy("internet")); err == nil {
+		t.Errorf("WithNetworkPolicy(internet) succeeded, want error")
+	}
+}
+
+func TestParseMetaFileJavaDeniedPermission(t *testing.T) {
+	ctx, err := newRunnerContext(t)
+	if err != nil {
+		t.Fatalf("RunnerContext creation failed with %q", err)
+	}
+	defer ctx.Close()
+	defer os.RemoveAll(ctx.Config.Runner.RuntimePath)
+
+	dir := t.TempDir()
+	templatePath := path.Join(dir, "java.policy.tmpl")
+	if err := os.WriteFile(
+		templatePath,
+		[]byte(`grant { permission java.io.FilePermission "{{.RunRoot}}/-", "read"; };`),
+		0644,
+	); err != nil {
+		t.Fatalf("Failed to write policy template: %v", err)
+	}
+	ctx.Config.Runner.JavaPolicyTemplate = templatePath
+
+	if err := writeJavaPolicy(templatePath, dir); err != nil {
+		t.Fatalf("Failed to write Java policy: %v", err)
+	}
+	policy, err := os.ReadFile(path.Join(dir, "java.policy"))
+	if err != nil {
+		t.Fatalf("Failed to read Java policy: %v", err)
+	}
+	expectedPolicy := `grant { permission java.io.FilePermission "` + dir + `/-", "read"; };`
+	if string(policy) != expectedPolicy {
+		t.Errorf("policy == %q, want %q", string(policy), expectedPolicy)
+	}
+
+	errorFile := path.Join(dir, "0.err")
+	if err := os.WriteFile(
+		errorFile,
+		[]byte(
+			"Exception in thread \"main\" java.security.AccessControlException: "+
+				"access denied (\"java.io.FilePermission\" \"/etc/passwd\" \"read\")\n"+
+				"\tat Main.main(Main.java:3)\n",
+		),
+		0644,
+	); err != nil {
+		t.Fatalf("Failed to write error file: %v", err)
+	}
+
+	meta, err := parseMetaFile(
+		ctx,
+		nil,
+		"java",
+		bytes.NewBufferString("status:1"),
+		nil,
+		&errorFile,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("Parsing meta file failed: %q", err)
+	}
+	if meta.Verdict != "RFE" {
+		t.Errorf("meta.Verdict == %q, want %q", meta.Verdict, "RFE")
+	}
+	expectedPermission := `("java.io.FilePermission" "/etc/passwd" "read")`
+	if meta.DeniedPermission == nil || *meta.DeniedPermission != expectedPermission {
+		t.Errorf("meta.DeniedPermission == %v, want %q", meta.DeniedPermission, expectedPermission)
+	}
+}
+
+func TestExpandSandboxCommand(t *testing.T) {
+	command := expandSandboxCommand(
+		defaultSandboxLanguages["cpp17-gcc"].Compile,
+		"Main",
+		[]string{"Main.cpp", "lib.cpp"},
+		[]string{"-Wl,-e__entry"},
+	)
+	expected := []string{
+		"/usr/bin/g++", "-std=gnu++17", "-O2", "-o", "Main", "Main.cpp", "lib.cpp", "-lm", "-Wl,-e__entry",
+	}
+	if !reflect.DeepEqual(expected, command) {
+		t.Errorf("expandSandboxCommand() == %q, want %q", command, expected)
+	}
+
+	command = expandSandboxCommand(defaultSandboxLanguages["py3"].Run, "Main_entry", nil, nil)
+	expected = []string{"/usr/bin/python3", "Main_entry.py"}
+	if !reflect.DeepEqual(expected, command) {
+		t.Errorf("expandSandboxCommand() == %q, want %q", command, expected)
+	}
+
+	command = expandSandboxCommand(defaultSandboxLanguages["py3-pypy"].Run, "Main_entry", nil, nil)
+	expected = []string{"/usr/bin/pypy3", "Main_entry.py"}
+	if !reflect.DeepEqual(expected, command) {
+		t.Errorf("expandSandboxCommand() == %q, want %q", command, expected)
+	}
+}
+
+func TestKotlinSandboxLanguage(t *testing.T) {
+	ctx := &common.Context{Config: common.DefaultConfig()}
+	ctx.Config.Runner.Languages = map[string]common.RunnerLanguageConfig{
+		"kt": {
+			Compiler:      "/opt/kotlinc/bin/kotlinc",
+			CompilerFlags: []string{"-nowarn"},
+		},
+	}
+
+	language, err := sandboxLanguage(ctx, nil, "kt", "isolate")
+	if err != nil {
+		t.Fatalf("sandboxLanguage(\"kt\") failed: %v", err)
+	}
+	command := expandSandboxCommand(language.Compile, "Main", []string{"Main.kt"}, nil)
+	expected := []string{
+		"/opt/kotlinc/bin/kotlinc", "-nowarn", "-include-runtime", "-d", "Main.jar", "Main.kt",
+	}
+	if !reflect.DeepEqual(expected, command) {
+		t.Errorf("expandSandboxCommand() == %q, want %q", command, expected)
+	}
+	command = expandSandboxCommand(language.Run, "Main", nil, nil)
+	expected = []string{"/usr/bin/java", "-Xss64M", "-jar", "Main.jar"}
+	if !reflect.DeepEqual(expected, command) {
+		t.Errorf("expandSandboxCommand() == %q, want %q", command, expected)
+	}
+
+	// The default commands are not modified.
+	if compiler := defaultSandboxLanguages["kt"].Compile[0]; compiler != "/usr/bin/kotlinc" {
+		t.Errorf("default Kotlin compiler == %q, want \"/usr/bin/kotlinc\"", compiler)
+	}
+}
+
+func TestSandboxRunCommand(t *testing.T) {
+	ctx := &common.Context{Config: common.DefaultConfig()}
+	ctx.Config.Runner.HardMemoryLimit = 640 * base.Mebibyte
+	language := defaultSandboxLanguages["js"]
+	for _, tc := range []struct {
+		memoryLimit base.Byte
+		expected    []string
+	}{
+		{256 * base.Mebibyte, []string{"/usr/bin/node", "--max-old-space-size=192", "Main_entry.js", "-v"}},
+		{96 * base.Mebibyte, []string{"/usr/bin/node", "--max-old-space-size=48", "Main_entry.js", "-v"}},
+		{0, []string{"/usr/bin/node", "Main_entry.js", "-v"}},
+	} {
+		limits := &common.LimitsSettings{MemoryLimit: tc.memoryLimit}
+		command := sandboxRunCommand(ctx, &language, "js", "Main_entry", limits, []string{"-v"})
+		if !reflect.DeepEqual(tc.expected, command) {
+			t.Errorf("sandboxRunCommand(%d) == %q, want %q", tc.memoryLimit, command, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omegaup/quark/common"
+)
+
+// CapabilityError is returned when the runner is not able to grade a run
+// because it lacks something that the run requires, so that the grader can
+// send it to a different runner.
+type CapabilityError struct {
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// ProtocolVersion is set when the run requires a newer version of the
+	// protocol, and Features when it requires features that the runner does
+	// not support.
+	ProtocolVersion int                 `json:"protocol_version,omitempty"`
+	Features        []common.RunFeature `json:"features,omitempty"`
+}
+
+func (e *CapabilityError) Error() string {
+	if e.ProtocolVersion != 0 {
+		return fmt.Sprintf(
+			"protocol version %d is not supported (want at most %d)",
+			e.ProtocolVersion,
+			common.RunnerProtocolVersion,
+		)
+	}
+	if len(e.Features) != 0 {
+		return fmt.Sprintf("features %q are not supported", common.FormatRunFeatures(e.Features))
+	}
+	return fmt.Sprintf("toolchain %q is not available", e.Toolchain)
+}
+
+// CheckRunProtocol returns a CapabilityError if the run requires a version of
+// the protocol or features that this runner does not support.
+func CheckRunProtocol(run *common.Run) error {
+	if run.ProtocolVersion > common.RunnerProtocolVersion {
+		return &CapabilityError{ProtocolVersion: run.ProtocolVersion}
+	}
+	if missing := common.MissingRunFeatures(run.Features, common.SupportedRunFeatures); len(missing) != 0 {
+		return &CapabilityError{Features: missing}
+	}
+	return nil
+}
+
+// toolchainProbe is the command that needs to be run to obtain the version of
+// a compiler or interpreter.
+type toolchainProbe struct {
+	name string
+	args []string
+}
+
+var toolchainProbes = []toolchainProbe{
+	{name: "gcc", args: []string{"gcc", "--version"}},
+	{name: "g++", args: []string{"g++", "--version"}},
+	{name: "javac", args: []string{"javac", "-version"}},
+	{name: "python2", args: []string{"python2", "--version"}},
+	{name: "python3", args: []string{"python3", "--version"}},
+	{name: "fpc", args: []string{"fpc", "-iV"}},
+	{name: "ruby", args: []string{"ruby", "--version"}},
+	{name: "lua", args: []string{"lua", "-v"}},
+	{name: "ghc", args: []string{"ghc", "--version"}},
+	{name: "dotnet", args: []string{"dotnet", "--version"}},
+}
+
+// ToolchainVersions returns the version string of each one of the compilers
+// and interpreters that are installed in the system. Toolchains that are not
+// installed are omitted.
+func ToolchainVersions(ctx *common.Context) map[string]string {
+	versions := make(map[string]string)
+	for _, probe := range toolchainProbes {
+		binPath, err := exec.LookPath(probe.args[0])
+		if err != nil {
+			continue
+		}
+		version, err := probeToolchainVersion(ctx, binPath, probe.args[1:])
+		if err != nil {
+			ctx.Log.Warn(
+				"Failed to get toolchain version",
+				map[string]any{
+					"toolchain": probe.name,
+					"err":       err,
+				},
+			)
+			continue
+		}
+		versions[probe.name] = version
+	}
+	return versions
+}
+
+// ToolchainFingerprint returns a string that identifies the architecture and
+// toolchain versions of a runner. Runners with the same fingerprint produce
+// interchangeable binaries.
+func ToolchainFingerprint(versions map[string]string) string {
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hasher := sha1.New()
+	fmt.Fprintf(hasher, "%s/%s\n", runtime.GOOS, runtime.GOARCH)
+	for _, name := range names {
+		fmt.Fprintf(hasher, "%s=%s\n", name, versions[name])
+	}
+	return fmt.Sprintf("%0x", hasher.Sum(nil))
+}
+
+func probeToolchainVersion(ctx *common.Context, binPath string, args []string) (string, error) {
+	probeCtx, cancel := context.WithTimeout(ctx.Context, 5*time.Second)
+	defer cancel()
+
+	// Some toolchains (e.g. javac) print their version to stderr.
+	output, err := exec.CommandContext(probeCtx, binPath, args...).CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", nil
+}
+
+// compilerVersionArgs are the arguments that make each compiler print its
+// version, by the name of its binary. The rest use --version.
+var compilerVersionArgs = map[string][]string{
+	"javac":   {"-version"},
+	"kotlinc": {"-version"},
+	"go":      {"version"},
+}
+
+// compilerBinary returns the compiler of a compile command. Commands that
+// run the compiler through env, to set its environment variables, get the
+// program that env runs.
+func compilerBinary(command []string) string {
+	if len(command) == 0 {
+		return ""
+	}
+	if path.Base(command[0]) != "env" {
+		return command[0]
+	}
+	for _, arg := range command[1:] {
+		if !strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") {
+			return arg
+		}
+	}
+	return ""
+}
+
+type compilerVersion struct {
+	modTime time.Time
+	size    int64
+	version string
+}
+
+// compilerVersionCache remembers the versions of the compilers, so that they
+// are not run every time a binary is compiled. A version is probed again if
+// the compiler binary changes, so that it is always the one that was used.
+type compilerVersionCache struct {
+	sync.Mutex
+	versions map[string]compilerVersion
+}
+
+var compilerVersions = &compilerVersionCache{
+	versions: make(map[string]compilerVersion),
+}
+
+// Get returns the version of the compiler of a compile command.
+func (c *compilerVersionCache) Get(ctx *common.Context, command []string) (string, error) {
+	binary := compilerBinary(command)
+	if binary == "" {
+		return "", fmt.Errorf("no compiler in %q", command)
+	}
+	binPath, err := exec.LookPath(binary)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return "", err
+	}
+
+	c.Lock()
+	cached, ok := c.versions[binPath]
+	c.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.version, nil
+	}
+
+	args, ok := compilerVersionArgs[path.Base(binPath)]
+	if !ok {
+		args = []string{"--version"}
+	}
+	version, err := probeToolchainVersion(ctx, binPath, args)
+	if err != nil {
+		return "", err
+	}
+	c.Lock()
+	c.versions[binPath] = compilerVersion{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		version: version,
+	}
+	c.Unlock()
+	return version, nil
+}
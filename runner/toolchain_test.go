@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/omegaup/quark/common"
+)
+
+func TestCompilerBinary(t *testing.T) {
+	for _, tc := range []struct {
+		command  []string
+		expected string
+	}{
+		{[]string{"/usr/bin/gcc", "-O2", "{sources}"}, "/usr/bin/gcc"},
+		{[]string{"/usr/bin/env", "CGO_ENABLED=0", "/usr/bin/go", "build"}, "/usr/bin/go"},
+		{[]string{"/usr/bin/env", "-i", "PATH=/usr/bin"}, ""},
+		{nil, ""},
+	} {
+		if binary := compilerBinary(tc.command); binary != tc.expected {
+			t.Errorf("compilerBinary(%q) == %q, want %q", tc.command, binary, tc.expected)
+		}
+	}
+}
+
+func TestCompilerVersionCache(t *testing.T) {
+	ctx := &common.Context{Context: context.Background()}
+	dir := t.TempDir()
+	compiler := path.Join(dir, "cc")
+	invocations := path.Join(dir, "invocations")
+	writeCompiler := func(version string) {
+		script := "#!/bin/sh\necho >> " + invocations + "\necho\necho '" + version + "'\necho 'Copyright'\n"
+		if err := os.WriteFile(compiler, []byte(script), 0o755); err != nil {
+			t.Fatalf("Failed to write the compiler: %v", err)
+		}
+	}
+	countInvocations := func() int {
+		contents, _ := os.ReadFile(invocations)
+		return len(contents)
+	}
+
+	cache := &compilerVersionCache{versions: make(map[string]compilerVersion)}
+	writeCompiler("cc 1.0")
+	for i := 0; i < 2; i++ {
+		version, err := cache.Get(ctx, []string{compiler, "-o", "{target}"})
+		if err != nil || version != "cc 1.0" {
+			t.Errorf("Get() == %q, %v, want \"cc 1.0\", nil", version, err)
+		}
+	}
+	if n := countInvocations(); n != 1 {
+		t.Errorf("the compiler ran %d times, want 1", n)
+	}
+
+	// Upgrading the compiler is noticed.
+	writeCompiler("cc 2.0.0")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(compiler, future, future)
+	if version, err := cache.Get(ctx, []string{compiler}); err != nil || version != "cc 2.0.0" {
+		t.Errorf("Get() == %q, %v, want \"cc 2.0.0\", nil", version, err)
+	}
+
+	if _, err := cache.Get(ctx, []string{path.Join(dir, "missing")}); err == nil {
+		t.Errorf("Get() of a missing compiler succeeded")
+	}
+}